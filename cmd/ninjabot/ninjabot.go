@@ -2,14 +2,25 @@ package main
 
 // 导入必要的包
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log" // 用于记录错误信息
 	"os"  // 用于访问系统操作，如命令行参数
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
 	// 导入ninjabot包，用于下载数据、交互交易所和其他服务
 	"github.com/rodrigo-brito/ninjabot/download"
 	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/parquet"
 	"github.com/rodrigo-brito/ninjabot/service"
 
+	"github.com/samber/lo"
 	"github.com/urfave/cli/v2" // 导入urfave/cli库，用于创建命令行界面
 )
 
@@ -24,6 +35,17 @@ import (
 
 使用指定的选项执行数据下载操作，并将结果输出到指定的输出文件路径中。
 
+指定多个交易对（或者用 --quote USDT 下载所有USDT交易对）和多个时间帧时进入批量模式，必须用 --store 指定本地数据仓库的目录，
+任务按 --parallel 并发增量下载，请求频率由交易所的RateLimiter控制，结束后输出每个交易对的下载数量、缺口和错误。
+
+"validate" 命令在回测之前检查CSV文件中重复、乱序、缺口、连续零成交量和价格不一致的K线，输出每种问题的数量和位置，
+指定 --repair 和 --output 时把修复后的K线写入新的文件。
+
+"ticks" 命令把币安的归集成交下载到本地成交仓库，或者导入币安数据下载站的trades/aggTrades文件；
+"bars" 命令从成交仓库生成时间、成交笔数、成交量、成交额、Renko或价格区间K线并写入文件。
+
+"depth" 命令录制币安的订单簿快照和增量更新，录制的文件可以由exchange.DepthReplay回放，用于依赖订单簿的策略回测。
+
 最后，运行应用程序并处理命令行输入，如果发生错误，则记录错误并退出。下载的数据保存到用户指定的输出文件中。在命令行选项中，通过 --output 或 -o 参数指定输出文件的路径和文件名。
 
 用户可以在命令行中指定要保存数据的文件路径和名称，例如 --output ./btc.csvs
@@ -42,12 +64,19 @@ func main() {
 				Usage:    "Download historical data", // 命令的描述
 				// 命令的选项
 				Flags: []cli.Flag{
-					// 定义一个字符串选项，用于指定交易对
-					&cli.StringFlag{
+					// 定义一个字符串列表选项，用于指定交易对，可以重复指定或者用逗号分隔多个交易对
+					&cli.StringSliceFlag{
 						Name:     "pair",
-						Aliases:  []string{"p"}, // 选项的短名称
-						Usage:    "eg. BTCUSDT", // 选项的说明
-						Required: true,          // 此选项为必须
+						Aliases:  []string{"p"},                    // 选项的短名称
+						Usage:    "eg. BTCUSDT or BTCUSDT,ETHUSDT", // 选项的说明
+						Required: false,                            // 可以用quote选项代替
+					},
+					// 定义一个字符串选项，下载报价资产的所有交易对，例如所有USDT交易对
+					&cli.StringFlag{
+						Name:     "quote",
+						Aliases:  []string{"q"},
+						Usage:    "download all pairs of the quote asset, eg. USDT",
+						Required: false,
 					},
 					// 定义一个整数选项，用于指定下载数据的天数
 					&cli.IntFlag{
@@ -72,19 +101,32 @@ func main() {
 						Layout:   "2006-01-02",
 						Required: false, // 此选项非必须
 					},
-					// 定义一个字符串选项，用于指定时间帧
-					&cli.StringFlag{
+					// 定义一个字符串列表选项，用于指定时间帧，可以指定多个时间帧
+					&cli.StringSliceFlag{
 						Name:     "timeframe",
 						Aliases:  []string{"t"},
-						Usage:    "eg. 1h",
+						Usage:    "eg. 1h or 1h,4h,1d",
 						Required: true, // 此选项为必须
 					},
-					// 定义一个字符串选项，用于指定输出文件的路径
+					// 定义一个字符串选项，用于指定输出文件的路径，只下载一个交易对和时间帧时使用
 					&cli.StringFlag{
 						Name:     "output",
 						Aliases:  []string{"o"},
-						Usage:    "eg. ./btc.csv",
-						Required: true, // 此选项为必须
+						Usage:    "eg. ./btc.csv, use .bin or .parquet extension for binary or Parquet format",
+						Required: false,
+					},
+					// 定义一个字符串选项，用于指定本地数据仓库的目录，批量下载时必须指定
+					&cli.StringFlag{
+						Name:     "store",
+						Usage:    "local data store directory, eg. ./data",
+						Required: false,
+					},
+					// 定义一个整数选项，用于指定批量下载时同时下载的任务数量
+					&cli.IntFlag{
+						Name:     "parallel",
+						Usage:    "concurrent downloads in batch mode",
+						Value:    4,
+						Required: false,
 					},
 					// 定义一个布尔选项，用于指定是否下载期货数据
 					&cli.BoolFlag{
@@ -94,6 +136,12 @@ func main() {
 						Value:    false, // 默认值为false
 						Required: false, // 此选项非必须
 					},
+					// 下载这个时间周期的K线并重采样为--timeframe，用于交易所不支持的周期，只支持下载到文件
+					&cli.StringFlag{
+						Name:     "resample",
+						Usage:    "download this timeframe and resample to --timeframe, eg. 15m to build 45m candles",
+						Required: false,
+					},
 				},
 				// 当download命令被执行时调用的动作
 				Action: func(c *cli.Context) error {
@@ -134,9 +182,412 @@ func main() {
 						log.Fatal("START and END must be informed together")
 					}
 
-					// 使用指定的选项执行下载
-					return download.NewDownloader(exc).Download(c.Context, c.String("pair"),
-						c.String("timeframe"), c.String("output"), options...)
+					pairs := c.StringSlice("pair")
+					if quote := c.String("quote"); quote != "" {
+						// 现货和合约的交易对不同，使用所选市场当前的交易对列表
+						lister, ok := exc.(interface{ PairsByQuote(quote string) []string })
+						if !ok {
+							return errors.New("QUOTE is not supported by this exchange")
+						}
+						pairs = append(pairs, lister.PairsByQuote(quote)...)
+					}
+					timeframes := c.StringSlice("timeframe")
+					if len(pairs) == 0 {
+						return errors.New("PAIR or QUOTE must be informed")
+					}
+
+					downloader := download.NewDownloader(exc)
+					// 只下载一个交易对和时间帧并且指定了输出文件时，直接下载到文件
+					if c.String("store") == "" {
+						if len(pairs) > 1 || len(timeframes) > 1 || c.String("output") == "" {
+							return errors.New("STORE must be informed to download many pairs or timeframes")
+						}
+						if source := c.String("resample"); source != "" {
+							options = append(options, download.WithResample(source, exchange.Alignment{}))
+						}
+						return downloader.Download(c.Context, pairs[0], timeframes[0], c.String("output"), options...)
+					}
+
+					if c.String("resample") != "" {
+						return errors.New("RESAMPLE is only supported when downloading to OUTPUT")
+					}
+
+					store, err := download.NewStore(c.String("store"))
+					if err != nil {
+						return err
+					}
+
+					// 现货和期货的数据分别保存在仓库的不同目录
+					venue := "binance"
+					if c.Bool("futures") {
+						venue = "binance-futures"
+					}
+
+					// 增量下载到本地数据仓库，输出每个交易对的下载结果
+					results := downloader.DownloadBatch(c.Context, store, venue, download.Batch{
+						Pairs:      pairs,
+						Timeframes: timeframes,
+						Parallel:   c.Int("parallel"),
+					}, options...)
+					fmt.Print(results.String())
+					return results.Err()
+				},
+			},
+			{
+				Name:     "convert",
+				HelpName: "convert",
+				Usage:    "Convert a CSV candle file to binary format for fast backtest loading",
+				Flags: []cli.Flag{
+					// 输入的CSV文件，额外的列会保存为附加列
+					&cli.StringFlag{
+						Name:     "input",
+						Aliases:  []string{"i"},
+						Usage:    "eg. ./btc.csv",
+						Required: true,
+					},
+					// 输出的二进制文件
+					&cli.StringFlag{
+						Name:     "output",
+						Aliases:  []string{"o"},
+						Usage:    "eg. ./btc.bin",
+						Required: true,
+					},
+					// 自动识别第三方CSV文件的分隔符、列名和时间格式
+					&cli.BoolFlag{
+						Name:  "detect",
+						Usage: "detect the layout of third-party CSV files (TradingView, Kaggle, exchange exports)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.Bool("detect") {
+						return exchange.ConvertCSVFeedToBinary(exchange.PairFeed{
+							File:   c.String("input"),
+							Schema: &exchange.CSVSchema{},
+						}, c.String("output"))
+					}
+					return exchange.ConvertCSVToBinary(c.String("input"), c.String("output"))
+				},
+			},
+			{
+				Name:     "validate",
+				HelpName: "validate",
+				Usage:    "Check a CSV candle file for duplicates, gaps and inconsistent prices and optionally repair it",
+				Flags: []cli.Flag{
+					// 要校验的CSV文件
+					&cli.StringFlag{
+						Name:     "input",
+						Aliases:  []string{"i"},
+						Usage:    "eg. ./btc.csv",
+						Required: true,
+					},
+					// 文件的时间帧，用于检查缺口
+					&cli.StringFlag{
+						Name:     "timeframe",
+						Aliases:  []string{"t"},
+						Usage:    "eg. 1h",
+						Required: true,
+					},
+					// 自动识别第三方CSV文件的分隔符、列名和时间格式
+					&cli.BoolFlag{
+						Name:  "detect",
+						Usage: "detect the layout of third-party CSV files (TradingView, Kaggle, exchange exports)",
+					},
+					// 修复：删除价格不一致的K线、排序、去重并填充缺口
+					&cli.BoolFlag{
+						Name:  "repair",
+						Usage: "drop bad rows, sort, dedupe and forward-fill gaps with flat candles",
+					},
+					// 修复后的输出文件
+					&cli.StringFlag{
+						Name:     "output",
+						Aliases:  []string{"o"},
+						Usage:    "write the repaired candles, eg. ./btc-fixed.csv, use .bin or .parquet extension for binary or Parquet format",
+						Required: false,
+					},
+					// 最多打印的问题数量
+					&cli.IntFlag{
+						Name:  "limit",
+						Usage: "maximum number of issues printed, 0 prints all",
+						Value: 20,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					var options exchange.ValidationOptions
+					if c.Bool("repair") {
+						options = exchange.RepairAll()
+					}
+
+					// 报告中使用文件名作为交易对名称
+					input := c.String("input")
+					feed := exchange.PairFeed{
+						Pair:       strings.TrimSuffix(filepath.Base(input), filepath.Ext(input)),
+						File:       input,
+						Timeframe:  c.String("timeframe"),
+						Validation: &options,
+					}
+					if c.Bool("detect") {
+						feed.Schema = &exchange.CSVSchema{}
+					}
+
+					csvFeed, err := exchange.NewCSVFeed(feed.Timeframe, feed)
+					if err != nil {
+						return err
+					}
+					report := csvFeed.Reports[feed.Pair]
+					report.Print(os.Stdout, c.Int("limit"))
+
+					output := c.String("output")
+					if output == "" {
+						if !report.Valid() {
+							return exchange.ErrInvalidCandles
+						}
+						return nil
+					}
+
+					candles := csvFeed.CandlePairTimeFrame[fmt.Sprintf("%s--%s", feed.Pair, feed.Timeframe)]
+					var metadata []string
+					if len(candles) > 0 {
+						metadata = lo.Keys(candles[0].Metadata)
+						sort.Strings(metadata)
+					}
+
+					switch filepath.Ext(output) {
+					case exchange.BinaryFileExt:
+						return exchange.WriteBinaryFile(output, candles, metadata...)
+					case ".parquet":
+						return parquet.WriteCandles(output, candles, metadata...)
+					default:
+						return exchange.WriteCSVFile(output, candles, metadata...)
+					}
+				},
+			},
+			{
+				Name:     "ticks",
+				HelpName: "ticks",
+				Usage:    "Download Binance aggregated trades or import trade CSV dumps into a local tick store",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "pair",
+						Aliases:  []string{"p"},
+						Usage:    "eg. BTCUSDT",
+						Required: true,
+					},
+					// 成交数据仓库的目录
+					&cli.StringFlag{
+						Name:     "store",
+						Usage:    "local data store directory, eg. ./data",
+						Required: true,
+					},
+					// 导入的成交文件，支持币安数据下载站的trades和aggTrades文件，不指定时从币安下载
+					&cli.StringSliceFlag{
+						Name:     "input",
+						Aliases:  []string{"i"},
+						Usage:    "import trade CSV dumps instead of downloading, eg. ./BTCUSDT-aggTrades-2021-12-01.csv",
+						Required: false,
+					},
+					&cli.IntFlag{
+						Name:     "days",
+						Aliases:  []string{"d"},
+						Usage:    "eg. 7 (default 1 day)",
+						Required: false,
+					},
+					&cli.TimestampFlag{
+						Name:     "start",
+						Aliases:  []string{"s"},
+						Usage:    "eg. 2021-12-01",
+						Layout:   "2006-01-02",
+						Required: false,
+					},
+					&cli.TimestampFlag{
+						Name:     "end",
+						Aliases:  []string{"e"},
+						Usage:    "eg. 2021-12-31",
+						Layout:   "2006-01-02",
+						Required: false,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					store, err := download.NewTickStore(c.String("store"))
+					if err != nil {
+						return err
+					}
+
+					pair := c.String("pair")
+					if inputs := c.StringSlice("input"); len(inputs) > 0 {
+						for _, input := range inputs {
+							ticks, err := exchange.ReadTicksCSV(input, pair)
+							if err != nil {
+								return err
+							}
+							if err := store.Append("binance", pair, ticks); err != nil {
+								return err
+							}
+							fmt.Printf("%s: %d ticks\n", input, len(ticks))
+						}
+						return nil
+					}
+
+					end := time.Now().UTC()
+					start := end.AddDate(0, 0, -1)
+					if days := c.Int("days"); days > 0 {
+						start = end.AddDate(0, 0, -days)
+					}
+					if c.Timestamp("start") != nil && c.Timestamp("end") != nil {
+						start, end = *c.Timestamp("start"), *c.Timestamp("end")
+					} else if c.Timestamp("start") != nil || c.Timestamp("end") != nil {
+						return errors.New("START and END must be informed together")
+					}
+
+					binance, err := exchange.NewBinance(c.Context)
+					if err != nil {
+						return err
+					}
+					total, err := download.DownloadTicks(c.Context, binance, store, "binance", pair, start, end)
+					fmt.Printf("%s: %d ticks\n", pair, total)
+					return err
+				},
+			},
+			{
+				Name:     "bars",
+				HelpName: "bars",
+				Usage:    "Build time, tick, volume, dollar, Renko or range bars from the local tick store",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "pair",
+						Aliases:  []string{"p"},
+						Usage:    "eg. BTCUSDT",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "store",
+						Usage:    "local data store directory, eg. ./data",
+						Required: true,
+					},
+					// K线类型，格式见exchange.BarSpec
+					&cli.StringFlag{
+						Name:     "bars",
+						Aliases:  []string{"b"},
+						Usage:    "eg. 1m, tick:500, volume:100, dollar:1e6, renko:50 or range:100",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "output",
+						Aliases:  []string{"o"},
+						Usage:    "eg. ./btc-volume.csv, use .bin or .parquet extension for binary or Parquet format",
+						Required: true,
+					},
+					// 只使用这个日期范围内的成交，不指定时使用仓库中的全部成交
+					&cli.TimestampFlag{
+						Name:     "start",
+						Aliases:  []string{"s"},
+						Usage:    "first day of trades, eg. 2021-12-01",
+						Layout:   "2006-01-02",
+						Required: false,
+					},
+					&cli.TimestampFlag{
+						Name:     "end",
+						Aliases:  []string{"e"},
+						Usage:    "last day of trades (inclusive), eg. 2021-12-31",
+						Layout:   "2006-01-02",
+						Required: false,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					store, err := download.NewTickStore(c.String("store"))
+					if err != nil {
+						return err
+					}
+
+					// 零值时间表示不限制该边界，结束日期包含当天的全部成交
+					var start, end time.Time
+					if c.Timestamp("start") != nil {
+						start = *c.Timestamp("start")
+					}
+					if c.Timestamp("end") != nil {
+						end = c.Timestamp("end").AddDate(0, 0, 1).Add(-time.Nanosecond)
+					}
+
+					pair := c.String("pair")
+					feed, err := store.Feed("binance", exchange.Alignment{}, start, end, pair)
+					if err != nil {
+						return err
+					}
+					candles, err := feed.CandlesByPeriod(c.Context, pair, c.String("bars"), start, end)
+					if err != nil {
+						return err
+					}
+					fmt.Printf("%s %s: %d bars\n", pair, c.String("bars"), len(candles))
+
+					metadata := []string{exchange.BarMetadataBuyVolume, exchange.BarMetadataTicks}
+					switch output := c.String("output"); filepath.Ext(output) {
+					case exchange.BinaryFileExt:
+						return exchange.WriteBinaryFile(output, candles, metadata...)
+					case ".parquet":
+						return parquet.WriteCandles(output, candles, metadata...)
+					default:
+						return exchange.WriteCSVFile(output, candles, metadata...)
+					}
+				},
+			},
+			{
+				Name:     "depth",
+				HelpName: "depth",
+				Usage:    "Record Binance order book snapshots and diffs for backtesting",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:     "pair",
+						Aliases:  []string{"p"},
+						Usage:    "eg. BTCUSDT or BTCUSDT,ETHUSDT",
+						Required: true,
+					},
+					// 录制文件，所有交易对写入同一个文件，回放时按交易对过滤
+					&cli.StringFlag{
+						Name:     "output",
+						Aliases:  []string{"o"},
+						Usage:    "eg. ./btc-depth.jsonl, use .gz extension for gzip compression",
+						Required: true,
+					},
+					&cli.IntFlag{
+						Name:     "limit",
+						Usage:    "levels per side of order book snapshots",
+						Value:    1000,
+						Required: false,
+					},
+					// 录制时长，不指定时录制到程序被中断
+					&cli.DurationFlag{
+						Name:     "duration",
+						Aliases:  []string{"d"},
+						Usage:    "eg. 1h (default until interrupted)",
+						Required: false,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					ctx, cancel := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+					defer cancel()
+					if duration := c.Duration("duration"); duration > 0 {
+						ctx, cancel = context.WithTimeout(ctx, duration)
+						defer cancel()
+					}
+
+					binance, err := exchange.NewBinance(ctx)
+					if err != nil {
+						return err
+					}
+					recorder, err := exchange.NewDepthRecorder(c.String("output"))
+					if err != nil {
+						return err
+					}
+
+					pairs := c.StringSlice("pair")
+					feed := exchange.NewDepthFeed(ctx, binance, exchange.WithDepthLimit(c.Int("limit")))
+					for _, pair := range pairs {
+						feed.Subscribe(pair, recorder.Record)
+					}
+					feed.Connect(pairs...)
+					feed.Start()
+
+					<-ctx.Done()
+					return recorder.Close()
 				},
 			},
 		},
@@ -0,0 +1,156 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/schollz/progressbar/v3"
+
+	"github.com/rodrigo-brito/ninjabot/tools/log"
+)
+
+// defaultParallel 批量下载默认同时下载的任务数量
+const defaultParallel = 4
+
+/*
+Batch 把多个交易对和时间周期并发下载到本地数据仓库。
+每个交易对和时间周期是一个任务，最多同时执行parallel个任务。交易所的请求频率由交易所实例的RateLimiter控制，
+所有任务共享同一个交易所实例，并发下载也不会超过交易所的限制。所有任务共用一个进度条，
+结束后返回每个任务的结果，单个任务失败不会影响其他任务。
+*/
+type Batch struct {
+	Pairs      []string // Pairs 需要下载的交易对
+	Timeframes []string // Timeframes 需要下载的时间周期
+	Parallel   int      // Parallel 同时下载的任务数量，默认为4
+}
+
+// BatchResult 是批量下载中一个交易对和时间周期的下载结果
+type BatchResult struct {
+	Pair       string // Pair 交易对
+	Timeframe  string // Timeframe 时间周期
+	Downloaded int    // Downloaded 本次写入仓库的K线数量
+	Gaps       []Gap  // Gaps 下载后仓库中仍然缺失的数据，通常是交易所本身没有数据
+	Err        error  // Err 下载失败的原因
+}
+
+// BatchResults 是批量下载所有任务的结果
+type BatchResults []BatchResult
+
+// Failed 返回下载失败的任务
+func (r BatchResults) Failed() BatchResults {
+	var failed BatchResults
+	for _, result := range r {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// String 生成每个任务下载结果的汇总表格
+func (r BatchResults) String() string {
+	tableString := &strings.Builder{}
+	table := tablewriter.NewWriter(tableString)
+	table.SetHeader([]string{"Pair", "Timeframe", "Downloaded", "Gaps", "Error"})
+	for _, result := range r {
+		errMessage := ""
+		if result.Err != nil {
+			errMessage = result.Err.Error()
+		}
+		table.Append([]string{
+			result.Pair,
+			result.Timeframe,
+			strconv.Itoa(result.Downloaded),
+			strconv.Itoa(len(result.Gaps)),
+			errMessage,
+		})
+	}
+	table.Render()
+	return tableString.String()
+}
+
+// DownloadBatch 方法并发下载批量任务到本地数据仓库，结果的顺序与交易对和时间周期的顺序一致
+func (d Downloader) DownloadBatch(ctx context.Context, store *Store, venue string, batch Batch,
+	options ...Option) BatchResults {
+
+	parameters := newParameters(options...)
+	parallel := batch.Parallel
+	if parallel <= 0 {
+		parallel = defaultParallel
+	}
+
+	// 先统计所有任务缺失的K线数量，作为共用进度条的总数
+	results := make(BatchResults, 0, len(batch.Pairs)*len(batch.Timeframes))
+	gaps := make([][]Gap, 0, cap(results))
+	total := 0
+	for _, pair := range batch.Pairs {
+		for _, timeframe := range batch.Timeframes {
+			result := BatchResult{Pair: pair, Timeframe: timeframe}
+			missing, count, err := missingCandles(store, venue, pair, timeframe, parameters)
+			result.Err = err
+			total += count
+			results = append(results, result)
+			gaps = append(gaps, missing)
+		}
+	}
+
+	log.Infof("Downloading %d missing candles of %d pairs and %d timeframes", total,
+		len(batch.Pairs), len(batch.Timeframes))
+	progressBar := progressbar.Default(int64(total))
+	progress := func(n int) {
+		if err := progressBar.Add(n); err != nil {
+			log.Warnf("update progresbar fail: %s", err.Error())
+		}
+	}
+
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				result := &results[index]
+				result.Downloaded, result.Err = d.fill(ctx, store, venue, result.Pair, result.Timeframe,
+					gaps[index], progress)
+				if result.Err != nil {
+					continue
+				}
+				result.Gaps, result.Err = store.Gaps(venue, result.Pair, result.Timeframe,
+					parameters.Start, parameters.End)
+			}
+		}()
+	}
+
+	for index := range results {
+		if results[index].Err == nil {
+			jobs <- index
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := progressBar.Close(); err != nil {
+		log.Warnf("close progresbar fail: %s", err.Error())
+	}
+
+	if failed := results.Failed(); len(failed) > 0 {
+		log.Warnf("%d of %d downloads failed", len(failed), len(results))
+	}
+	log.Info("Done!")
+	return results
+}
+
+// Err 把所有失败的任务合并为一个错误，没有失败时返回nil
+func (r BatchResults) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d downloads failed, first %s %s: %w", len(failed), len(r),
+		failed[0].Pair, failed[0].Timeframe, failed[0].Err)
+}
@@ -0,0 +1,81 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+)
+
+// failingFeeder 对指定交易对返回错误，模拟交易所请求失败
+type failingFeeder struct {
+	service.Feeder
+	pair string
+}
+
+func (f failingFeeder) CandlesByPeriod(ctx context.Context, pair, timeframe string,
+	start, end time.Time) ([]model.Candle, error) {
+	if pair == f.pair {
+		return nil, errors.New("invalid symbol")
+	}
+	return f.Feeder.CandlesByPeriod(ctx, pair, timeframe, start, end)
+}
+
+func TestDownloader_DownloadBatch(t *testing.T) {
+	start := time.Date(2021, 4, 26, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2021, 5, 10, 0, 0, 0, 0, time.UTC)
+
+	csvFeed, err := exchange.NewCSVFeed("1d", exchange.PairFeed{
+		Pair:      "BTCUSDT",
+		File:      "../testdata/btc-1d.csv",
+		Timeframe: "1d",
+	})
+	require.NoError(t, err)
+	downloader := NewDownloader(failingFeeder{Feeder: csvFeed, pair: "LUNAUSDT"})
+
+	store, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	results := downloader.DownloadBatch(context.Background(), store, "binance", Batch{
+		Pairs:      []string{"BTCUSDT", "ETHUSDT", "LUNAUSDT"},
+		Timeframes: []string{"1d", "batata"},
+		Parallel:   2,
+	}, WithInterval(start, end))
+	require.Len(t, results, 6)
+
+	assert.Equal(t, "BTCUSDT", results[0].Pair)
+	assert.Equal(t, 14, results[0].Downloaded)
+	assert.Empty(t, results[0].Gaps)
+	assert.NoError(t, results[0].Err)
+
+	// 交易所没有数据的交易对记录缺口
+	assert.Equal(t, "ETHUSDT", results[2].Pair)
+	assert.Zero(t, results[2].Downloaded)
+	assert.Equal(t, []Gap{{Start: start, End: end.AddDate(0, 0, -1)}}, results[2].Gaps)
+
+	// 无效的时间周期和请求失败都记录在结果中，不影响其他任务
+	assert.Error(t, results[1].Err)
+	assert.EqualError(t, results[4].Err, "invalid symbol")
+	require.Len(t, results.Failed(), 4)
+	assert.ErrorContains(t, results.Err(), "4 of 6 downloads failed")
+	assert.Contains(t, results.String(), "invalid symbol")
+
+	candles, err := store.Candles("binance", "BTCUSDT", "1d", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, candles, 14)
+
+	// 再次下载时已经没有缺失的数据
+	results = downloader.DownloadBatch(context.Background(), store, "binance", Batch{
+		Pairs:      []string{"BTCUSDT"},
+		Timeframes: []string{"1d"},
+	}, WithInterval(start, end))
+	require.NoError(t, results.Err())
+	assert.Zero(t, results[0].Downloaded)
+}
@@ -3,12 +3,19 @@ package download
 import (
 	"context"
 	"encoding/csv"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/samber/lo"
 	"github.com/schollz/progressbar/v3"
 	"github.com/xhit/go-str2duration/v2"
 
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/parquet"
 	"github.com/rodrigo-brito/ninjabot/service"
 	"github.com/rodrigo-brito/ninjabot/tools/log"
 )
@@ -50,6 +57,11 @@ func NewDownloader(exchange service.Feeder) Downloader {
 type Parameters struct {
 	Start time.Time // Start 表示下载数据的起始时间。
 	End   time.Time // End 表示下载数据的结束时间。
+
+	// SourceTimeframe 不为空时下载这个时间周期的K线并重采样到目标时间周期，用于交易所不支持的周期，如45m或2d
+	SourceTimeframe string
+	// Alignment 重采样时周期的边界
+	Alignment exchange.Alignment
 }
 
 // Option 是一个函数类型，用于修改 Parameters 实例。
@@ -63,6 +75,15 @@ func WithInterval(start, end time.Time) Option {
 	}
 }
 
+// WithResample 下载sourceTimeframe的K线，按alignment重采样为目标时间周期后写入文件，只写入完整的K线。
+// 例如交易所没有45m的K线时，可以下载15m的K线合成45m。
+func WithResample(sourceTimeframe string, alignment exchange.Alignment) Option {
+	return func(parameters *Parameters) {
+		parameters.SourceTimeframe = sourceTimeframe
+		parameters.Alignment = alignment
+	}
+}
+
 // WithDays 函数生成一个 Option 类型的函数，该函数用于设置数据下载的时间范围。
 // 参数 `days` 表示从当前时间往回数的天数。
 func WithDays(days int) Option {
@@ -92,19 +113,8 @@ func candlesCount(start, end time.Time, timeframe string) (int, time.Duration, e
 	return int(totalDuration / interval), interval, nil
 }
 
-// Download 方法，下载交易对的K线数据到CSV文件。
-// output string这个字符串参数指定了下载数据要保存到的文件路径。这里的数据将被保存为CSV格式，可以被用于后续的数据分析或作为历史数据记录。
-// 这是一个可变参数，允许传入多个Option类型的函数。每个Option函数可以修改Parameters结构的字段，比如调整数据下载的起始和结束时间。这提供了高度的灵活性，使调用者可以在调用方法时动态配置数据下载的具体参数。
-func (d Downloader) Download(ctx context.Context, pair, timeframe string, output string, options ...Option) error {
-	// 尝试创建输出文件，用于保存下载的数据。
-	//根据output文件路径，创建一个文件，如果创建失败比如因为文件系统权限不足、磁盘空间不足、路径错误或其他系统问题就返回错误
-	//os.Create 是 Go 语言标准库中的一个函数，属于 os 包。它用于在文件系统中创建一个新文件。如果指定的文件已经存在，os.Create 会将其长度截断为 0（即清空文件内容），确保返回的文件句柄是针对一个空文件。
-	recordFile, err := os.Create(output)
-	if err != nil {
-		// 文件创建失败，返回错误。
-		return err
-	}
-
+// newParameters 应用所有选项并把时间范围标准化：开始时间对齐到当天的UTC零点，结束时间不超过当前时间。
+func newParameters(options ...Option) *Parameters {
 	// 获取当前时间。
 	now := time.Now()
 	// 设置默认的下载参数（起始时间为一月前，结束时间为当前时间）。
@@ -133,6 +143,37 @@ func (d Downloader) Download(ctx context.Context, pair, timeframe string, output
 		parameters.End = now
 	}
 
+	return parameters
+}
+
+// Download 方法，下载交易对的K线数据到CSV文件。
+// output string这个字符串参数指定了下载数据要保存到的文件路径。这里的数据将被保存为CSV格式，可以被用于后续的数据分析或作为历史数据记录。
+// 这是一个可变参数，允许传入多个Option类型的函数。每个Option函数可以修改Parameters结构的字段，比如调整数据下载的起始和结束时间。这提供了高度的灵活性，使调用者可以在调用方法时动态配置数据下载的具体参数。
+func (d Downloader) Download(ctx context.Context, pair, timeframe string, output string, options ...Option) error {
+	// 尝试创建输出文件，用于保存下载的数据。
+	//根据output文件路径，创建一个文件，如果创建失败比如因为文件系统权限不足、磁盘空间不足、路径错误或其他系统问题就返回错误
+	//os.Create 是 Go 语言标准库中的一个函数，属于 os 包。它用于在文件系统中创建一个新文件。如果指定的文件已经存在，os.Create 会将其长度截断为 0（即清空文件内容），确保返回的文件句柄是针对一个空文件。
+	recordFile, err := os.Create(output)
+	if err != nil {
+		// 文件创建失败，返回错误。
+		return err
+	}
+	// 出错提前返回时关闭文件，正常结束时由最后的Close返回关闭文件的错误
+	defer recordFile.Close()
+
+	// 根据选项计算下载的时间范围。
+	parameters := newParameters(options...)
+
+	// 需要重采样时下载源时间周期的K线，下面的数量、进度和缺失数据都按源时间周期计算
+	var resampler *exchange.Resampler
+	if parameters.SourceTimeframe != "" {
+		resampler, err = exchange.NewResampler(parameters.SourceTimeframe, timeframe, parameters.Alignment)
+		if err != nil {
+			return err
+		}
+		timeframe = parameters.SourceTimeframe
+	}
+
 	// 计算需要下载的K线数量和每个K线的时间间隔。
 	candlesCount, interval, err := candlesCount(parameters.Start, parameters.End, timeframe)
 	if err != nil {
@@ -145,8 +186,11 @@ func (d Downloader) Download(ctx context.Context, pair, timeframe string, output
 	log.Infof("Downloading %d candles of %s for %s", candlesCount, timeframe, pair)
 	// 获取交易对的资产信息。
 	info := d.exchange.AssetsInfo(pair)
-	//csv.NewWriter 函数创建了一个新的 CSV 文件写入器。这个写入器与先前创建的文件 recordFile 绑定。 这个写入器 writer 将被用来向 CSV 文件写入一系列的字符串数组。每个字符串数组代表 CSV 文件的一行，通常包括各种数据字段，如在金融数据分析中的时间、开盘价、收盘价、最低价、最高价和交易量等。
-	writer := csv.NewWriter(recordFile)
+	// 根据输出文件的扩展名选择写入格式，.bin 文件写入二进制列式格式，.parquet 文件写入Parquet，其他文件写入CSV
+	writer, err := newCandleWriter(recordFile, output, info.QuotePrecision)
+	if err != nil {
+		return err
+	}
 
 	//  创建了一个进度条实例，这个进度条的最大值被设置为 candlesCount，即预计要下载的K线的总数。这个进度条用于可视化表示数据下载的进展情况。
 	/*
@@ -167,15 +211,6 @@ func (d Downloader) Download(ctx context.Context, pair, timeframe string, output
 	//意思就是从2023年1月1日起，计划每七天下载一次数据，每次循环一个星期，第一次循环，isLastLoop = false，因为满七天 ，第二次也是isLastLoop = false满七天，最后一次开始日期：1月29日，预计结束日期：2月4日因为已经超过了这个月的下载数据，所以把isLastLoop 调为 true，调整结束日期为1月31日。因为isLastLoop 调为 true 就可以不用循环下载一个星期，到最后一个下载时间点就结束
 	isLastLoop := false // 标记是否是最后一次循环。true 就是最后一次循环
 
-	// 写入CSV文件的表头。就是CSV文件第一行的标题
-	err = writer.Write([]string{
-		"time", "open", "close", "low", "high", "volume",
-	})
-	if err != nil {
-		// 写入失败，返回错误。
-		return err
-	}
-
 	// 循环从 parameters.Start 开始，这是指定的开始时间，要 begin（当前循环的起始时间）仍然在 parameters.End（结束时间）之前。就继续循环，在每次循环迭代中，begin 时间会通过加上 interval * batchSize 来更新。这意味着每次循环结束时，begin 都会向前推进由 interval 和 batchSize 定义的总时间跨度。 interval 设为一小时batchSize = 500就是500小时的数据点，每次循环开始时间，就往后推500小时，直到，开始时间在结束时间之后
 	for begin := parameters.Start; begin.Before(parameters.End); begin = begin.Add(interval * batchSize) {
 		//这里计算的是每次循环周期的结束时间= 开始时间 + （间隔时间 x 数据点）
@@ -201,12 +236,9 @@ func (d Downloader) Download(ctx context.Context, pair, timeframe string, output
 		// 将K线数据写入CSV文件。
 		//for 循环来遍历 candles 切片，其中每个 candle 代表一个时间段的交易数据，包括开盘价、最高价、最低价、收盘价和成交量等信息。
 		//它的作用是将 candle 对象的数据转换成一个字符串切片（slice）。这个切片包含了K线数据的所有重要元素，格式化为字符串，便于存储和处理。info.QuotePrecision：这是一个参数，通常用于指定在转换数据时应该保留的小数位数。 意思就是将k线图数组数据格式化为字符串，里面的及格精度是我们设定的报价精度对吗比如精度是0.01，意思就是里面的价格也是保留两位小数
-		for _, candle := range candles {
-			err := writer.Write(candle.ToSlice(info.QuotePrecision))
-			if err != nil {
-				// 数据写入失败，返回错误。
-				return err
-			}
+		if err := writer.Write(resampleComplete(resampler, candles)...); err != nil {
+			// 数据写入失败，返回错误。
+			return err
 		}
 
 		// 更新处理的K线计数和进度条。
@@ -237,10 +269,168 @@ func (d Downloader) Download(ctx context.Context, pair, timeframe string, output
 		log.Warnf("%d missing candles", lostData)
 	}
 
-	// 刷新CSV写入器，确保所有数据都已写入文件。
-	writer.Flush()
+	// 刷新写入器，确保所有数据都已写入文件，二进制格式在这里写入索引。
+	if err := writer.Close(); err != nil {
+		return err
+	}
 	// 记录日志，表示下载任务完成。
 	log.Info("Done!")
-	// 检查CSV写入过程中是否有错误发生，并返回这个错误。
-	return writer.Error()
+	return recordFile.Close()
+}
+
+// resampleComplete 把下载的源K线交给重采样器，只返回完整的目标周期K线，没有重采样器时直接返回candles
+func resampleComplete(resampler *exchange.Resampler, candles []model.Candle) []model.Candle {
+	if resampler == nil {
+		return candles
+	}
+
+	result := make([]model.Candle, 0, len(candles))
+	for _, candle := range candles {
+		if resampled, ok := resampler.Update(candle); ok && resampled.Complete {
+			result = append(result, resampled)
+		}
+	}
+	return result
+}
+
+// candleWriter 是Download输出文件的写入器
+type candleWriter interface {
+	Write(candles ...model.Candle) error
+	Close() error
+}
+
+// newCandleWriter 根据输出文件的扩展名创建写入器
+func newCandleWriter(w io.Writer, output string, precision int) (candleWriter, error) {
+	switch ext := filepath.Ext(output); {
+	case strings.EqualFold(ext, exchange.BinaryFileExt):
+		return exchange.NewBinaryWriter(w)
+	case strings.EqualFold(ext, ".parquet"):
+		return parquet.NewCandleWriter(w)
+	}
+
+	writer := &csvCandleWriter{writer: csv.NewWriter(w), precision: precision}
+	// 写入CSV文件的表头。就是CSV文件第一行的标题
+	if err := writer.writer.Write([]string{"time", "open", "close", "low", "high", "volume"}); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// csvCandleWriter 以CSV格式写入K线，价格保留交易对的报价精度
+type csvCandleWriter struct {
+	writer    *csv.Writer
+	precision int
+}
+
+func (c *csvCandleWriter) Write(candles ...model.Candle) error {
+	for _, candle := range candles {
+		if err := c.writer.Write(candle.ToSlice(c.precision)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *csvCandleWriter) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// Sync 方法把交易对的K线增量下载到本地数据仓库。
+// 只下载仓库中缺失的部分：新数据追加到文件末尾，中间的缺口会被回补。每批数据下载后立即写入仓库，
+// 下载中断后再次执行会从上次的位置继续，不需要重新下载已有的数据。
+func (d Downloader) Sync(ctx context.Context, store *Store, venue, pair, timeframe string, options ...Option) error {
+	parameters := newParameters(options...)
+
+	gaps, missing, err := missingCandles(store, venue, pair, timeframe, parameters)
+	if err != nil {
+		return err
+	}
+	if missing == 0 {
+		log.Infof("%s %s %s is up to date", venue, pair, timeframe)
+		return nil
+	}
+
+	log.Infof("Downloading %d missing candles of %s for %s in %d gaps", missing, timeframe, pair, len(gaps))
+	progressBar := progressbar.Default(int64(missing))
+	_, err = d.fill(ctx, store, venue, pair, timeframe, gaps, func(n int) {
+		if err := progressBar.Add(n); err != nil {
+			log.Warnf("update progresbar fail: %s", err.Error())
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = progressBar.Close(); err != nil {
+		log.Warnf("close progresbar fail: %s", err.Error())
+	}
+
+	// 交易所本身缺失的数据无法回补，下次同步时会再次尝试
+	gaps, err = store.Gaps(venue, pair, timeframe, parameters.Start, parameters.End)
+	if err != nil {
+		return err
+	}
+	if len(gaps) > 0 {
+		log.Warnf("%d gaps still missing, first from %s to %s", len(gaps), gaps[0].Start, gaps[0].End)
+	}
+
+	log.Info("Done!")
+	return nil
+}
+
+// missingCandles 返回仓库在下载范围内的缺口和缺失的K线数量
+func missingCandles(store *Store, venue, pair, timeframe string, parameters *Parameters) ([]Gap, int, error) {
+	interval, err := str2duration.ParseDuration(timeframe)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	gaps, err := store.Gaps(venue, pair, timeframe, parameters.Start, parameters.End)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	missing := 0
+	for _, gap := range gaps {
+		missing += int(gap.End.Sub(gap.Start)/interval) + 1
+	}
+	return gaps, missing, nil
+}
+
+// fill 分批下载缺口中的K线并写入仓库，progress在每批下载后收到这一批覆盖的K线数量，返回写入仓库的K线数量
+func (d Downloader) fill(ctx context.Context, store *Store, venue, pair, timeframe string, gaps []Gap,
+	progress func(int)) (int, error) {
+
+	interval, err := str2duration.ParseDuration(timeframe)
+	if err != nil {
+		return 0, err
+	}
+
+	stored := 0
+	for _, gap := range gaps {
+		for begin := gap.Start; !begin.After(gap.End); begin = begin.Add(interval * batchSize) {
+			end := begin.Add(interval*batchSize - time.Second)
+			if end.After(gap.End) {
+				end = gap.End
+			}
+
+			candles, err := d.exchange.CandlesByPeriod(ctx, pair, timeframe, begin, end)
+			if err != nil {
+				return stored, err
+			}
+
+			// 只保存缺口范围内的完整K线，交易所可能返回正在形成的K线
+			candles = lo.Filter(candles, func(candle model.Candle, _ int) bool {
+				return !candle.Time.Before(begin) && !candle.Time.After(end)
+			})
+			if err := store.Append(venue, pair, timeframe, candles); err != nil {
+				return stored, err
+			}
+
+			stored += len(candles)
+			progress(int(end.Sub(begin)/interval) + 1)
+		}
+	}
+	return stored, nil
 }
@@ -3,10 +3,13 @@ package download
 import (
 	"context"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/parquet"
 	"github.com/rodrigo-brito/ninjabot/service"
 
 	"github.com/stretchr/testify/assert"
@@ -97,4 +100,76 @@ func TestDownloader_download(t *testing.T) {
 		require.NoError(t, err)
 		require.Len(t, csvFeed.CandlePairTimeFrame["BTCUSDT--1d"], 14)
 	})
+
+	t.Run("parquet", func(t *testing.T) {
+		output := filepath.Join(t.TempDir(), "btc.parquet")
+		err := downloader.Download(ctx, "BTCUSDT", "1d", output, WithInterval(param.Start, param.End))
+		require.NoError(t, err)
+
+		expected, err := csvFeed.CandlesByPeriod(ctx, "BTCUSDT", "1d", param.Start, param.End)
+		require.NoError(t, err)
+
+		candles, err := parquet.ReadCandles(output, "BTCUSDT", parquet.Columns{})
+		require.NoError(t, err)
+		require.Equal(t, expected, candles)
+	})
+
+	t.Run("binary", func(t *testing.T) {
+		output := filepath.Join(t.TempDir(), "btc.bin")
+		err := downloader.Download(ctx, "BTCUSDT", "1d", output, WithInterval(param.Start, param.End))
+		require.NoError(t, err)
+
+		expected, err := csvFeed.CandlesByPeriod(ctx, "BTCUSDT", "1d", param.Start, param.End)
+		require.NoError(t, err)
+
+		binaryFeed, err := exchange.NewBinaryFeed("1d", exchange.PairFeed{
+			Pair:      "BTCUSDT",
+			File:      output,
+			Timeframe: "1d",
+		})
+		require.NoError(t, err)
+		defer binaryFeed.Close()
+
+		candles, err := binaryFeed.CandlesByPeriod(ctx, "BTCUSDT", "1d", param.Start, param.End)
+		require.NoError(t, err)
+		require.Len(t, candles, 14)
+		require.Equal(t, expected, candles)
+	})
+	t.Run("resample", func(t *testing.T) {
+		output := filepath.Join(t.TempDir(), "btc-3d.csv")
+		err := downloader.Download(ctx, "BTCUSDT", "3d", output, WithInterval(param.Start, param.End),
+			WithResample("1d", exchange.Alignment{}))
+		require.NoError(t, err)
+
+		resampled, err := exchange.NewCSVFeed("3d", exchange.PairFeed{
+			Pair:      "BTCUSDT",
+			File:      "../testdata/btc-1d.csv",
+			Timeframe: "1d",
+		})
+		require.NoError(t, err)
+		expected := make(map[int64]model.Candle)
+		for _, candle := range resampled.CandlePairTimeFrame["BTCUSDT--3d"] {
+			if candle.Complete {
+				expected[candle.Time.Unix()] = candle
+			}
+		}
+
+		downloaded, err := exchange.NewCSVFeed("3d", exchange.PairFeed{
+			Pair:      "BTCUSDT",
+			File:      output,
+			Timeframe: "3d",
+		})
+		require.NoError(t, err)
+		candles := downloaded.CandlePairTimeFrame["BTCUSDT--3d"]
+		require.Len(t, candles, 4)
+		for _, candle := range candles {
+			require.Contains(t, expected, candle.Time.Unix())
+			assert.Zero(t, candle.Time.Unix()%(3*24*60*60))
+			assert.InDelta(t, expected[candle.Time.Unix()].Open, candle.Open, 1e-6)
+			assert.InDelta(t, expected[candle.Time.Unix()].Close, candle.Close, 1e-6)
+			assert.InDelta(t, expected[candle.Time.Unix()].High, candle.High, 1e-6)
+			assert.InDelta(t, expected[candle.Time.Unix()].Low, candle.Low, 1e-6)
+			assert.InDelta(t, expected[candle.Time.Unix()].Volume, candle.Volume, 1e-6)
+		}
+	})
 }
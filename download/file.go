@@ -0,0 +1,130 @@
+package download
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// partialLineChunk 是向前查找最后一个换行符时每次读取的字节数
+const partialLineChunk = 4096
+
+/*
+Store和TickStore都把数据按行写入CSV文件：新数据都在已有数据之后时直接追加，否则写入临时文件再原子替换。
+写入过程被中断时，文件末尾可能留下一行没有以换行结束的残缺数据，直接追加会把新数据拼接到这一行后面。
+追加之前先把文件截断到最后一个完整的行。
+*/
+
+// appendLines 把数据追加到文件末尾，文件末尾有残缺的行时先截断到最后一个完整的行。
+// 文件为空（或者只有一行残缺的表头）时write的header参数为true，需要先写入表头。
+func appendLines(path string, header bool, write func(w io.Writer, header bool) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	size, err := trimPartialLine(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := write(file, header || size == 0); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// rewriteFile 先把数据写入同一目录下的临时文件，再替换原文件，写入过程中断不会损坏已有数据
+func rewriteFile(path string, write func(w io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// trimPartialFile 截断文件末尾残缺的行，文件不存在时不做任何操作
+func trimPartialFile(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := trimPartialLine(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// hasPartialLine 判断文件是否以没有换行结束的残缺行结尾，使用ReadAt读取，不改变文件的读写位置
+func hasPartialLine(file *os.File) (bool, error) {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return false, err
+	}
+
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return false, err
+	}
+	return last[0] != '\n', nil
+}
+
+// trimPartialLine 把文件截断到最后一个换行符之后，返回截断后的文件大小
+func trimPartialLine(file *os.File) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	partial, err := hasPartialLine(file)
+	if err != nil || !partial {
+		return info.Size(), err
+	}
+
+	// 从文件末尾向前分块查找最后一个换行符
+	buffer := make([]byte, partialLineChunk)
+	end := info.Size()
+	for end > 0 {
+		start := end - partialLineChunk
+		if start < 0 {
+			start = 0
+		}
+		chunk := buffer[:end-start]
+		if _, err := file.ReadAt(chunk, start); err != nil {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			end = start + int64(i) + 1
+			break
+		}
+		end = start
+	}
+
+	return end, file.Truncate(end)
+}
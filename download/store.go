@@ -0,0 +1,335 @@
+package download
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+// ErrEmptyStore 表示本地数据仓库中没有指定交易对和时间周期的K线数据
+var ErrEmptyStore = errors.New("no candles in store")
+
+// storeHeader 是仓库CSV文件的表头，与Download输出的格式一致，可以直接被CSVFeed读取
+var storeHeader = []string{"time", "open", "close", "low", "high", "volume"}
+
+// storeAlignment 是仓库中K线周期的对齐方式，主流交易所的周线都从UTC周一开始
+var storeAlignment = exchange.Alignment{WeekStart: time.Monday}
+
+/*
+Store 是本地行情数据仓库，每个交易所/交易对/时间周期对应一个CSV文件：
+
+	<dir>/<exchange>/<pair>/<timeframe>.csv
+
+文件中的K线按时间升序排列且不重复。新的K线如果都在已有数据之后，直接追加到文件末尾；
+补齐中间缺口的K线会与已有数据合并后写入临时文件，再原子替换原文件，中断也不会损坏已有数据。
+*/
+type Store struct {
+	dir  string
+	mtx  sync.Mutex
+	last map[string]time.Time // last 本进程写入的每个文件最后一根K线的时间，连续追加时不需要重新读取文件
+}
+
+// Gap 表示仓库中缺失的一段K线，Start和End都是缺失K线的开盘时间（包含两端）
+type Gap struct {
+	Start time.Time
+	End   time.Time
+}
+
+// NewStore 创建一个以dir为根目录的本地数据仓库，目录不存在时自动创建
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, last: make(map[string]time.Time)}, nil
+}
+
+// Path 返回交易所/交易对/时间周期对应的CSV文件路径
+func (s *Store) Path(venue, pair, timeframe string) string {
+	return filepath.Join(s.dir, venue, pair, timeframe+".csv")
+}
+
+// Candles 返回仓库中开盘时间在[start, end]范围内的K线，零值时间表示不限制该边界
+func (s *Store) Candles(venue, pair, timeframe string, start, end time.Time) ([]model.Candle, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	candles, _, err := s.read(venue, pair, timeframe)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]model.Candle, 0, len(candles))
+	for _, candle := range candles {
+		if (!start.IsZero() && candle.Time.Before(start)) || (!end.IsZero() && candle.Time.After(end)) {
+			continue
+		}
+		result = append(result, candle)
+	}
+	return result, nil
+}
+
+// Last 返回仓库中最后一根K线的开盘时间，仓库为空时返回false
+func (s *Store) Last(venue, pair, timeframe string) (time.Time, bool, error) {
+	candles, err := s.Candles(venue, pair, timeframe, time.Time{}, time.Time{})
+	if err != nil || len(candles) == 0 {
+		return time.Time{}, false, err
+	}
+	return candles[len(candles)-1].Time, true, nil
+}
+
+// Append 把K线写入仓库，已经存在的K线会被新数据覆盖
+func (s *Store) Append(venue, pair, timeframe string, candles []model.Candle) error {
+	if len(candles) == 0 {
+		return nil
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	path := s.Path(venue, pair, timeframe)
+	candles = sortCandles(candles)
+	// 本进程刚写入过这个文件，并且新数据在最后一根K线之后，直接追加
+	if last, ok := s.last[path]; ok && candles[0].Time.After(last) {
+		return s.appendFile(path, false, candles)
+	}
+
+	stored, truncated, err := s.read(venue, pair, timeframe)
+	if err != nil {
+		return err
+	}
+
+	// 新数据全部在已有数据之后时直接追加，避免重写整个文件；文件末尾有中断写入的残缺行时需要重写
+	if !truncated && (len(stored) == 0 || candles[0].Time.After(stored[len(stored)-1].Time)) {
+		return s.appendFile(path, len(stored) == 0, candles)
+	}
+
+	return s.rewrite(path, sortCandles(append(stored, candles...)))
+}
+
+// Gaps 检测仓库在[start, end)范围内缺失的K线，只统计收盘时间不晚于end的完整K线。
+// K线的开盘时间按storeAlignment对齐，start不在周期边界上时从下一个周期开始检查。
+func (s *Store) Gaps(venue, pair, timeframe string, start, end time.Time) ([]Gap, error) {
+	period, err := exchange.ParseTimeframe(timeframe)
+	if err != nil {
+		return nil, err
+	}
+
+	candles, err := s.Candles(venue, pair, timeframe, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := period.PeriodStart(start, storeAlignment)
+	if expected.Before(start) {
+		expected = period.Next(expected)
+	}
+
+	var (
+		gaps []Gap
+		open bool // open 上一根应该存在的K线是否缺失
+		i    int
+	)
+	for ; !period.Next(expected).After(end); expected = period.Next(expected) {
+		for i < len(candles) && candles[i].Time.Before(expected) {
+			i++
+		}
+		if i < len(candles) && candles[i].Time.Equal(expected) {
+			open = false
+			continue
+		}
+
+		if open {
+			gaps[len(gaps)-1].End = expected
+		} else {
+			gaps = append(gaps, Gap{Start: expected, End: expected})
+			open = true
+		}
+	}
+	return gaps, nil
+}
+
+// Feed 从仓库创建一个CSVFeed，只包含[start, end]范围内的K线，零值时间表示不限制该边界
+func (s *Store) Feed(venue, timeframe string, start, end time.Time, pairs ...string) (*exchange.CSVFeed, error) {
+	feeds := make([]exchange.PairFeed, 0, len(pairs))
+	for _, pair := range pairs {
+		// 仓库文件至少需要一根K线，CSVFeed无法读取空文件
+		if last, ok, err := s.Last(venue, pair, timeframe); err != nil {
+			return nil, err
+		} else if !ok || (!start.IsZero() && last.Before(start)) {
+			return nil, fmt.Errorf("%w: %s %s %s", ErrEmptyStore, venue, pair, timeframe)
+		}
+
+		feeds = append(feeds, exchange.PairFeed{
+			Pair:      pair,
+			File:      s.Path(venue, pair, timeframe),
+			Timeframe: timeframe,
+		})
+	}
+
+	feed, err := exchange.NewCSVFeed(timeframe, feeds...)
+	if err != nil {
+		return nil, err
+	}
+	return feed.Between(start, end), nil
+}
+
+// read 读取仓库文件中的全部K线，文件不存在时返回空列表。
+// 下载中断可能在文件末尾留下一行残缺的数据：没有以换行结束的最后一行即使能够解析也可能缺少数字，
+// 这一行会被忽略并通过truncated返回；其他位置的错误行直接返回错误。
+func (s *Store) read(venue, pair, timeframe string) (candles []model.Candle, truncated bool, err error) {
+	file, err := os.Open(s.Path(venue, pair, timeframe))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+
+	partial, err := hasPartialLine(file)
+	if err != nil {
+		return nil, false, err
+	}
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, false, err
+	}
+	if partial && len(records) > 0 {
+		records = records[:len(records)-1]
+		truncated = true
+	}
+	if len(records) == 0 {
+		return nil, truncated, nil
+	}
+
+	// 第一行是表头
+	records = records[1:]
+	candles = make([]model.Candle, 0, len(records))
+	for i, line := range records {
+		candle, err := parseStoreLine(pair, line)
+		if err != nil {
+			if i == len(records)-1 {
+				return candles, true, nil
+			}
+			return nil, false, err
+		}
+		candles = append(candles, candle)
+	}
+	return candles, truncated, nil
+}
+
+// appendFile 把K线追加到文件末尾，新文件先写入表头
+func (s *Store) appendFile(path string, header bool, candles []model.Candle) error {
+	err := appendLines(path, header, func(w io.Writer, header bool) error {
+		return writeCandles(w, header, candles)
+	})
+	if err != nil {
+		delete(s.last, path)
+		return err
+	}
+
+	s.last[path] = candles[len(candles)-1].Time
+	return nil
+}
+
+// rewrite 先写入临时文件再替换原文件，写入过程中断不会损坏已有数据
+func (s *Store) rewrite(path string, candles []model.Candle) error {
+	err := rewriteFile(path, func(w io.Writer) error {
+		return writeCandles(w, true, candles)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.last[path] = candles[len(candles)-1].Time
+	return nil
+}
+
+// writeCandles 以仓库格式写入K线，价格使用最短的精确表示，读回时不会损失精度
+func writeCandles(w io.Writer, header bool, candles []model.Candle) error {
+	writer := csv.NewWriter(w)
+	if header {
+		if err := writer.Write(storeHeader); err != nil {
+			return err
+		}
+	}
+
+	for _, candle := range candles {
+		err := writer.Write([]string{
+			strconv.FormatInt(candle.Time.Unix(), 10),
+			strconv.FormatFloat(candle.Open, 'f', -1, 64),
+			strconv.FormatFloat(candle.Close, 'f', -1, 64),
+			strconv.FormatFloat(candle.Low, 'f', -1, 64),
+			strconv.FormatFloat(candle.High, 'f', -1, 64),
+			strconv.FormatFloat(candle.Volume, 'f', -1, 64),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// parseStoreLine 把仓库文件中的一行转换为K线
+func parseStoreLine(pair string, line []string) (model.Candle, error) {
+	if len(line) < len(storeHeader) {
+		return model.Candle{}, fmt.Errorf("invalid store line: %v", line)
+	}
+
+	timestamp, err := strconv.ParseInt(line[0], 10, 64)
+	if err != nil {
+		return model.Candle{}, err
+	}
+
+	values := make([]float64, len(storeHeader)-1)
+	for i := range values {
+		values[i], err = strconv.ParseFloat(line[i+1], 64)
+		if err != nil {
+			return model.Candle{}, err
+		}
+	}
+
+	return model.Candle{
+		Pair:      pair,
+		Time:      time.Unix(timestamp, 0).UTC(),
+		UpdatedAt: time.Unix(timestamp, 0).UTC(),
+		Open:      values[0],
+		Close:     values[1],
+		Low:       values[2],
+		High:      values[3],
+		Volume:    values[4],
+		Complete:  true,
+	}, nil
+}
+
+// sortCandles 按时间排序并去掉重复的K线，时间相同时保留后面的数据
+func sortCandles(candles []model.Candle) []model.Candle {
+	sort.SliceStable(candles, func(i, j int) bool {
+		return candles[i].Time.Before(candles[j].Time)
+	})
+
+	result := make([]model.Candle, 0, len(candles))
+	for _, candle := range candles {
+		if n := len(result); n > 0 && result[n-1].Time.Equal(candle.Time) {
+			result[n-1] = candle
+			continue
+		}
+		result = append(result, candle)
+	}
+	return result
+}
@@ -0,0 +1,186 @@
+package download
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+)
+
+func TestStore(t *testing.T) {
+	start := time.Date(2021, 4, 26, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	candle := func(i int) model.Candle {
+		return model.Candle{Pair: "BTCUSDT", Time: start.Add(time.Duration(i) * day), Close: float64(i) + 0.1}
+	}
+
+	t.Run("append and gaps", func(t *testing.T) {
+		store, err := NewStore(t.TempDir())
+		require.NoError(t, err)
+
+		gaps, err := store.Gaps("binance", "BTCUSDT", "1d", start, start.Add(5*day))
+		require.NoError(t, err)
+		assert.Equal(t, []Gap{{Start: start, End: start.Add(4 * day)}}, gaps)
+
+		require.NoError(t, store.Append("binance", "BTCUSDT", "1d", []model.Candle{candle(0), candle(1)}))
+		require.NoError(t, store.Append("binance", "BTCUSDT", "1d", []model.Candle{candle(3)}))
+
+		gaps, err = store.Gaps("binance", "BTCUSDT", "1d", start, start.Add(5*day))
+		require.NoError(t, err)
+		assert.Equal(t, []Gap{{Start: start.Add(2 * day), End: start.Add(2 * day)},
+			{Start: start.Add(4 * day), End: start.Add(4 * day)}}, gaps)
+
+		// 回补中间的缺口，已有的K线被新数据覆盖
+		updated := candle(1)
+		updated.Close = 42
+		require.NoError(t, store.Append("binance", "BTCUSDT", "1d", []model.Candle{candle(2), updated}))
+
+		candles, err := store.Candles("binance", "BTCUSDT", "1d", time.Time{}, time.Time{})
+		require.NoError(t, err)
+		require.Len(t, candles, 4)
+		assert.Equal(t, 42.0, candles[1].Close)
+		assert.Equal(t, 2.1, candles[2].Close)
+		assert.Equal(t, start.Add(3*day), candles[3].Time)
+
+		last, ok, err := store.Last("binance", "BTCUSDT", "1d")
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, start.Add(3*day), last)
+	})
+
+	t.Run("truncated file", func(t *testing.T) {
+		store, err := NewStore(t.TempDir())
+		require.NoError(t, err)
+		require.NoError(t, store.Append("binance", "BTCUSDT", "1d", []model.Candle{candle(0)}))
+
+		// 模拟写入过程中被中断留下的残缺行
+		file, err := os.OpenFile(store.Path("binance", "BTCUSDT", "1d"), os.O_APPEND|os.O_WRONLY, 0o644)
+		require.NoError(t, err)
+		_, err = file.WriteString("1619481600,1.5,2")
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		candles, err := store.Candles("binance", "BTCUSDT", "1d", time.Time{}, time.Time{})
+		require.NoError(t, err)
+		require.Len(t, candles, 1)
+
+		require.NoError(t, store.Append("binance", "BTCUSDT", "1d", []model.Candle{candle(1)}))
+		candles, err = store.Candles("binance", "BTCUSDT", "1d", time.Time{}, time.Time{})
+		require.NoError(t, err)
+		require.Len(t, candles, 2)
+		assert.Equal(t, start.Add(day), candles[1].Time)
+		assert.Equal(t, 1.1, candles[1].Close)
+	})
+
+	t.Run("truncated line that parses", func(t *testing.T) {
+		store, err := NewStore(t.TempDir())
+		require.NoError(t, err)
+		require.NoError(t, store.Append("binance", "BTCUSDT", "1d", []model.Candle{candle(0)}))
+
+		// 最后一行的成交量被截断，但仍然可以解析，没有换行结尾说明这一行不完整
+		path := store.Path("binance", "BTCUSDT", "1d")
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+		require.NoError(t, err)
+		_, err = file.WriteString("1619481600,1.1,1.1,1,2,12")
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		candles, err := store.Candles("binance", "BTCUSDT", "1d", time.Time{}, time.Time{})
+		require.NoError(t, err)
+		require.Len(t, candles, 1)
+
+		// 新的进程没有写入记录，追加前截断残缺的行
+		store, err = NewStore(store.dir)
+		require.NoError(t, err)
+		require.NoError(t, store.Append("binance", "BTCUSDT", "1d", []model.Candle{candle(2)}))
+		candles, err = store.Candles("binance", "BTCUSDT", "1d", time.Time{}, time.Time{})
+		require.NoError(t, err)
+		require.Len(t, candles, 2)
+		assert.Equal(t, start.Add(2*day), candles[1].Time)
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "time,open,close,low,high,volume\n1619395200,0,0.1,0,0,0\n1619568000,0,2.1,0,0,0\n",
+			string(data))
+	})
+
+	t.Run("aligned gaps", func(t *testing.T) {
+		store, err := NewStore(t.TempDir())
+		require.NoError(t, err)
+
+		// 周线从周一开始，2021-04-26是周一
+		week := 7 * day
+		require.NoError(t, store.Append("binance", "BTCUSDT", "1w", []model.Candle{
+			{Pair: "BTCUSDT", Time: start, Close: 1}, {Pair: "BTCUSDT", Time: start.Add(2 * week), Close: 2}}))
+		gaps, err := store.Gaps("binance", "BTCUSDT", "1w", start.Add(-2*day), start.Add(4*week))
+		require.NoError(t, err)
+		assert.Equal(t, []Gap{{Start: start.Add(week), End: start.Add(week)},
+			{Start: start.Add(3 * week), End: start.Add(3 * week)}}, gaps)
+
+		// 不在周期边界上的开始时间从下一根K线开始检查
+		gaps, err = store.Gaps("binance", "BTCUSDT", "1h", start.Add(30*time.Minute), start.Add(3*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, []Gap{{Start: start.Add(time.Hour), End: start.Add(2 * time.Hour)}}, gaps)
+	})
+
+	t.Run("feed", func(t *testing.T) {
+		store, err := NewStore(t.TempDir())
+		require.NoError(t, err)
+
+		_, err = store.Feed("binance", "1d", time.Time{}, time.Time{}, "BTCUSDT")
+		require.ErrorIs(t, err, ErrEmptyStore)
+
+		require.NoError(t, store.Append("binance", "BTCUSDT", "1d",
+			[]model.Candle{candle(0), candle(1), candle(2), candle(3)}))
+
+		feed, err := store.Feed("binance", "1d", start.Add(day), start.Add(2*day), "BTCUSDT")
+		require.NoError(t, err)
+		candles, err := feed.CandlesByPeriod(context.Background(), "BTCUSDT", "1d", time.Time{}, start.Add(10*day))
+		require.NoError(t, err)
+		require.Len(t, candles, 2)
+		assert.Equal(t, 1.1, candles[0].Close)
+		assert.Equal(t, 2.1, candles[1].Close)
+	})
+}
+
+func TestDownloader_Sync(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2021, 4, 26, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2021, 5, 10, 0, 0, 0, 0, time.UTC)
+
+	csvFeed, err := exchange.NewCSVFeed("1d", exchange.PairFeed{
+		Pair:      "BTCUSDT",
+		File:      "../testdata/btc-1d.csv",
+		Timeframe: "1d",
+	})
+	require.NoError(t, err)
+	downloader := Downloader{struct{ service.Feeder }{csvFeed}}
+
+	store, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	// 先下载前半段，再增量下载剩余的数据
+	require.NoError(t, downloader.Sync(ctx, store, "binance", "BTCUSDT", "1d",
+		WithInterval(start, start.AddDate(0, 0, 7))))
+	candles, err := store.Candles("binance", "BTCUSDT", "1d", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, candles, 7)
+
+	require.NoError(t, downloader.Sync(ctx, store, "binance", "BTCUSDT", "1d", WithInterval(start, end)))
+	candles, err = store.Candles("binance", "BTCUSDT", "1d", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, candles, 14)
+	assert.Equal(t, start, candles[0].Time)
+	assert.Equal(t, end.AddDate(0, 0, -1), candles[13].Time)
+
+	gaps, err := store.Gaps("binance", "BTCUSDT", "1d", start, end)
+	require.NoError(t, err)
+	assert.Empty(t, gaps)
+}
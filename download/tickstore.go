@@ -0,0 +1,253 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+	"github.com/rodrigo-brito/ninjabot/tools/log"
+)
+
+// ErrEmptyTickStore 表示本地数据仓库中没有指定交易对的成交数据
+var ErrEmptyTickStore = errors.New("no ticks in store")
+
+// tickDayLayout 是成交文件名中日期的格式
+const tickDayLayout = "2006-01-02"
+
+/*
+TickStore 是本地成交数据仓库，每个交易所/交易对/日期（UTC）对应一个CSV文件：
+
+	<dir>/<exchange>/<pair>/ticks/<2006-01-02>.csv
+
+文件中的成交按时间和成交ID升序排列且不重复，写入方式与Store相同：新成交都在已有数据之后时直接追加，
+否则与已有数据合并后写入临时文件再原子替换。文件可以直接由exchange.TickFeed读取。
+*/
+type TickStore struct {
+	dir  string
+	mtx  sync.Mutex
+	last map[string]model.Tick // last 本进程写入的每个文件的最后一笔成交
+}
+
+// NewTickStore 创建一个以dir为根目录的成交数据仓库，目录不存在时自动创建
+func NewTickStore(dir string) (*TickStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &TickStore{dir: dir, last: make(map[string]model.Tick)}, nil
+}
+
+// Path 返回交易对某一天的成交文件路径
+func (s *TickStore) Path(venue, pair string, day time.Time) string {
+	return filepath.Join(s.dir, venue, pair, "ticks", day.UTC().Format(tickDayLayout)+".csv")
+}
+
+// Files 返回日期在[start, end]范围内的成交文件，按日期排序，零值时间表示不限制该边界
+func (s *TickStore) Files(venue, pair string, start, end time.Time) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, venue, pair, "ticks"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		day, err := time.Parse(tickDayLayout, strings.TrimSuffix(entry.Name(), ".csv"))
+		if err != nil || entry.IsDir() || !strings.HasSuffix(entry.Name(), ".csv") {
+			continue
+		}
+		if (!start.IsZero() && day.Before(truncateDay(start))) || (!end.IsZero() && day.After(end)) {
+			continue
+		}
+		files = append(files, filepath.Join(s.dir, venue, pair, "ticks", entry.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Ticks 返回成交时间在[start, end]范围内的成交，零值时间表示不限制该边界
+func (s *TickStore) Ticks(venue, pair string, start, end time.Time) ([]model.Tick, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	files, err := s.Files(venue, pair, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]model.Tick, 0)
+	for _, file := range files {
+		ticks, err := exchange.ReadTicksCSV(file, pair)
+		if err != nil {
+			return nil, err
+		}
+		for _, tick := range ticks {
+			if (!start.IsZero() && tick.Time.Before(start)) || (!end.IsZero() && tick.Time.After(end)) {
+				continue
+			}
+			result = append(result, tick)
+		}
+	}
+	return result, nil
+}
+
+// Append 把成交按日期写入仓库，成交ID相同的成交会被新数据覆盖
+func (s *TickStore) Append(venue, pair string, ticks []model.Tick) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	days := make(map[string][]model.Tick)
+	for _, tick := range ticks {
+		path := s.Path(venue, pair, tick.Time)
+		days[path] = append(days[path], tick)
+	}
+
+	paths := make([]string, 0, len(days))
+	for path := range days {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if err := s.appendDay(path, pair, sortTicks(days[path])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Feed 从仓库创建一个TickFeed，只包含日期在[start, end]范围内的文件，零值时间表示不限制该边界
+func (s *TickStore) Feed(venue string, alignment exchange.Alignment, start, end time.Time,
+	pairs ...string) (*exchange.TickFeed, error) {
+
+	feeds := make([]exchange.TickPairFeed, 0, len(pairs))
+	for _, pair := range pairs {
+		files, err := s.Files(venue, pair, start, end)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("%w: %s %s", ErrEmptyTickStore, venue, pair)
+		}
+		feeds = append(feeds, exchange.TickPairFeed{Pair: pair, Files: files, Alignment: alignment})
+	}
+	return exchange.NewTickFeed(feeds...)
+}
+
+// appendDay 把同一天的成交写入文件，ticks已经排序
+func (s *TickStore) appendDay(path, pair string, ticks []model.Tick) error {
+	// 本进程刚写入过这个文件，并且新数据在最后一笔成交之后，直接追加
+	if last, ok := s.last[path]; ok && tickBefore(last, ticks[0]) {
+		return s.appendFile(path, ticks)
+	}
+
+	// 下载中断可能在文件末尾留下一行残缺的成交，即使能够解析也可能缺少数字，读取之前先截断
+	if err := trimPartialFile(path); err != nil {
+		return err
+	}
+
+	var stored []model.Tick
+	if _, err := os.Stat(path); err == nil {
+		if stored, err = exchange.ReadTicksCSV(path, pair); err != nil && !errors.Is(err, exchange.ErrInsufficientData) {
+			return err
+		}
+	}
+
+	if len(stored) == 0 || tickBefore(stored[len(stored)-1], ticks[0]) {
+		return s.appendFile(path, ticks)
+	}
+	return s.rewrite(path, sortTicks(append(stored, ticks...)))
+}
+
+// appendFile 把成交追加到文件末尾，空文件先写入表头
+func (s *TickStore) appendFile(path string, ticks []model.Tick) error {
+	err := appendLines(path, false, func(w io.Writer, header bool) error {
+		return exchange.WriteTicksCSV(w, header, ticks)
+	})
+	if err != nil {
+		delete(s.last, path)
+		return err
+	}
+
+	s.last[path] = ticks[len(ticks)-1]
+	return nil
+}
+
+// rewrite 先写入临时文件再替换原文件，写入过程中断不会损坏已有数据
+func (s *TickStore) rewrite(path string, ticks []model.Tick) error {
+	err := rewriteFile(path, func(w io.Writer) error {
+		return exchange.WriteTicksCSV(w, true, ticks)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.last[path] = ticks[len(ticks)-1]
+	return nil
+}
+
+// DownloadTicks 按天下载[start, end]范围内的成交并写入仓库，每天下载完成后立即写入，中断后已经下载的数据不会丢失
+func DownloadTicks(ctx context.Context, feeder service.TickFeeder, store *TickStore, venue, pair string,
+	start, end time.Time) (int, error) {
+
+	total := 0
+	for day := start; day.Before(end); day = truncateDay(day).AddDate(0, 0, 1) {
+		dayEnd := truncateDay(day).AddDate(0, 0, 1).Add(-time.Millisecond)
+		if dayEnd.After(end) {
+			dayEnd = end
+		}
+
+		ticks, err := feeder.TicksByPeriod(ctx, pair, day, dayEnd)
+		if err != nil {
+			return total, err
+		}
+		if err := store.Append(venue, pair, ticks); err != nil {
+			return total, err
+		}
+
+		total += len(ticks)
+		log.Infof("[TICKS] %s %s: %d ticks", pair, day.Format(tickDayLayout), len(ticks))
+	}
+	return total, nil
+}
+
+// tickBefore 成交a是否在成交b之前，时间相同时按成交ID比较
+func tickBefore(a, b model.Tick) bool {
+	if a.Time.Equal(b.Time) {
+		return a.ID < b.ID
+	}
+	return a.Time.Before(b.Time)
+}
+
+// sortTicks 按时间和成交ID排序并去掉重复的成交，ID相同时保留后面的数据，没有ID的成交不去重
+func sortTicks(ticks []model.Tick) []model.Tick {
+	sort.SliceStable(ticks, func(i, j int) bool {
+		return tickBefore(ticks[i], ticks[j])
+	})
+
+	result := make([]model.Tick, 0, len(ticks))
+	for _, tick := range ticks {
+		if n := len(result); n > 0 && tick.ID != 0 && result[n-1].ID == tick.ID {
+			result[n-1] = tick
+			continue
+		}
+		result = append(result, tick)
+	}
+	return result
+}
+
+func truncateDay(t time.Time) time.Time {
+	year, month, day := t.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
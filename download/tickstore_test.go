@@ -0,0 +1,141 @@
+package download
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+func TestTickStore(t *testing.T) {
+	start := time.Date(2021, 12, 1, 23, 0, 0, 0, time.UTC)
+	tick := func(id int64, minutes int, price float64) model.Tick {
+		return model.Tick{Pair: "BTCUSDT", ID: id, Time: start.Add(time.Duration(minutes) * time.Minute),
+			Price: price, Quantity: 1}
+	}
+
+	t.Run("append by day", func(t *testing.T) {
+		store, err := NewTickStore(t.TempDir())
+		require.NoError(t, err)
+
+		require.NoError(t, store.Append("binance", "BTCUSDT", []model.Tick{tick(1, 0, 100), tick(2, 30, 101)}))
+		// 跨天的成交写入两个文件
+		require.NoError(t, store.Append("binance", "BTCUSDT", []model.Tick{tick(4, 90, 103), tick(3, 61, 102)}))
+		// 回补中间的成交，ID相同的成交被新数据覆盖
+		require.NoError(t, store.Append("binance", "BTCUSDT", []model.Tick{tick(2, 30, 42), tick(1, 0, 100)}))
+
+		files, err := store.Files("binance", "BTCUSDT", time.Time{}, time.Time{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"2021-12-01.csv", "2021-12-02.csv"},
+			[]string{filepath.Base(files[0]), filepath.Base(files[1])})
+
+		ticks, err := store.Ticks("binance", "BTCUSDT", time.Time{}, time.Time{})
+		require.NoError(t, err)
+		require.Len(t, ticks, 4)
+		for i, tick := range ticks {
+			assert.Equal(t, int64(i+1), tick.ID)
+		}
+		assert.Equal(t, 42.0, ticks[1].Price)
+
+		ticks, err = store.Ticks("binance", "BTCUSDT", start.Add(time.Hour), time.Time{})
+		require.NoError(t, err)
+		require.Len(t, ticks, 2)
+	})
+
+	t.Run("truncated line", func(t *testing.T) {
+		store, err := NewTickStore(t.TempDir())
+		require.NoError(t, err)
+		require.NoError(t, store.Append("binance", "BTCUSDT", []model.Tick{tick(1, 0, 100), tick(2, 30, 101)}))
+
+		// 中断的写入留下一行没有换行结尾的残缺成交
+		path := store.Path("binance", "BTCUSDT", start)
+		expected, err := os.ReadFile(path)
+		require.NoError(t, err)
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+		require.NoError(t, err)
+		_, err = file.WriteString("1638401400000,10")
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		// 新的进程没有写入记录，读取和追加前截断残缺的行
+		store, err = NewTickStore(store.dir)
+		require.NoError(t, err)
+		require.NoError(t, store.Append("binance", "BTCUSDT", []model.Tick{tick(3, 45, 102)}))
+
+		ticks, err := store.Ticks("binance", "BTCUSDT", time.Time{}, time.Time{})
+		require.NoError(t, err)
+		require.Len(t, ticks, 3)
+		assert.Equal(t, 102.0, ticks[2].Price)
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(data), string(expected)))
+		assert.Equal(t, strings.Count(string(expected), "\n")+1, strings.Count(string(data), "\n"))
+	})
+
+	t.Run("feed", func(t *testing.T) {
+		store, err := NewTickStore(t.TempDir())
+		require.NoError(t, err)
+
+		_, err = store.Feed("binance", exchange.Alignment{}, time.Time{}, time.Time{}, "BTCUSDT")
+		require.ErrorIs(t, err, ErrEmptyTickStore)
+
+		require.NoError(t, store.Append("binance", "BTCUSDT",
+			[]model.Tick{tick(1, 0, 100), tick(2, 30, 101), tick(3, 61, 102), tick(4, 90, 103), tick(5, 95, 104)}))
+
+		feed, err := store.Feed("binance", exchange.Alignment{}, time.Time{}, time.Time{}, "BTCUSDT")
+		require.NoError(t, err)
+		candles, err := feed.CandlesByPeriod(context.Background(), "BTCUSDT", "tick:2", time.Time{},
+			start.Add(24*time.Hour))
+		require.NoError(t, err)
+		require.Len(t, candles, 2)
+		assert.Equal(t, 101.0, candles[0].Close)
+		assert.Equal(t, 103.0, candles[1].Close)
+
+		// 零值的结束时间不限制范围
+		unbounded, err := feed.CandlesByPeriod(context.Background(), "BTCUSDT", "tick:2", time.Time{}, time.Time{})
+		require.NoError(t, err)
+		assert.Equal(t, candles, unbounded)
+	})
+
+	t.Run("download", func(t *testing.T) {
+		store, err := NewTickStore(t.TempDir())
+		require.NoError(t, err)
+
+		feeder := tickFeeder{ticks: []model.Tick{tick(1, 0, 100), tick(2, 30, 101), tick(3, 61, 102)}}
+		total, err := DownloadTicks(context.Background(), feeder, store, "binance", "BTCUSDT", start,
+			start.Add(2*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, 3, total)
+
+		ticks, err := store.Ticks("binance", "BTCUSDT", time.Time{}, time.Time{})
+		require.NoError(t, err)
+		assert.Len(t, ticks, 3)
+	})
+}
+
+type tickFeeder struct {
+	ticks []model.Tick
+}
+
+func (f tickFeeder) TicksByPeriod(_ context.Context, _ string, start, end time.Time) ([]model.Tick, error) {
+	var ticks []model.Tick
+	for _, tick := range f.ticks {
+		if !tick.Time.Before(start) && !tick.Time.After(end) {
+			ticks = append(ticks, tick)
+		}
+	}
+	return ticks, nil
+}
+
+func (f tickFeeder) TicksSubscription(_ context.Context, _ string) (chan model.Tick, chan error) {
+	return nil, nil
+}
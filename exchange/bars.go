@@ -0,0 +1,263 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+// ErrInvalidBarSpec 表示无法解析的K线类型
+var ErrInvalidBarSpec = errors.New("invalid bar spec")
+
+// BarType 是由成交生成的K线的类型
+type BarType string
+
+const (
+	BarTime   BarType = "time"   // 时间K线，按时间周期合并成交
+	BarTick   BarType = "tick"   // 成交笔数K线，每Size笔成交生成一根K线
+	BarVolume BarType = "volume" // 成交量K线，成交量累计到Size时生成一根K线
+	BarDollar BarType = "dollar" // 成交额K线，成交额（价格*数量）累计到Size时生成一根K线
+	BarRenko  BarType = "renko"  // Renko K线，价格每移动Size生成一块砖，反转需要移动两倍的Size
+	BarRange  BarType = "range"  // 价格区间K线，最高价与最低价的差达到Size时生成一根K线
+)
+
+// BarMetadata 是由成交生成的K线附带的元数据：成交笔数和主动买入的成交量
+const (
+	BarMetadataTicks     = "ticks"
+	BarMetadataBuyVolume = "buy_volume"
+)
+
+/*
+BarSpec 描述由成交生成的K线，字符串格式为"类型:大小"，可以直接作为时间周期传给CandlesSubscription：
+
+	tick:100      每100笔成交
+	volume:50     每50个币的成交量
+	dollar:1e6    每100万计价货币的成交额
+	renko:10      价格每移动10
+	range:25      价格区间达到25
+	time:1m / 1m  1分钟的时间K线
+
+不带类型前缀的时间周期也是合法的BarSpec，但只有带前缀的时间周期需要由成交生成，见IsBarSpec。
+*/
+type BarSpec struct {
+	Type      BarType
+	Size      float64   // Size 非时间K线的大小
+	Timeframe Timeframe // Timeframe 时间K线的周期
+}
+
+// ParseBarSpec 解析K线类型
+func ParseBarSpec(spec string) (BarSpec, error) {
+	kind, value, ok := strings.Cut(spec, ":")
+	if !ok || BarType(kind) == BarTime {
+		if !ok {
+			value = spec
+		}
+		timeframe, err := ParseTimeframe(value)
+		if err != nil {
+			return BarSpec{}, fmt.Errorf("%w: %s", ErrInvalidBarSpec, spec)
+		}
+		return BarSpec{Type: BarTime, Timeframe: timeframe}, nil
+	}
+
+	switch BarType(kind) {
+	case BarTick, BarVolume, BarDollar, BarRenko, BarRange:
+		size, err := strconv.ParseFloat(value, 64)
+		if err != nil || size <= 0 || math.IsInf(size, 0) || math.IsNaN(size) ||
+			(BarType(kind) == BarTick && size != math.Trunc(size)) {
+			return BarSpec{}, fmt.Errorf("%w: %s", ErrInvalidBarSpec, spec)
+		}
+		return BarSpec{Type: BarType(kind), Size: size}, nil
+	}
+	return BarSpec{}, fmt.Errorf("%w: %s", ErrInvalidBarSpec, spec)
+}
+
+// IsBarSpec 时间周期是否带有类型前缀，带前缀的K线需要由成交生成，交易所的K线接口不支持
+func IsBarSpec(timeframe string) bool {
+	return strings.Contains(timeframe, ":")
+}
+
+/*
+BarAggregator 把按时间排序的成交合并为K线。
+
+  - 时间K线在收到下一个周期的成交时完成，没有成交的周期不会生成K线
+  - 成交笔数、成交量、成交额和价格区间K线在达到Size的那笔成交时完成，一笔成交不会被拆分到两根K线
+  - Renko的砖块只包含价格，开盘价和收盘价是砖块的边界，一笔成交可能完成多块砖，成交量计入第一块砖
+
+非时间K线的开盘时间是第一笔成交的时间，同一时间完成多根K线时依次加1纳秒，保证开盘时间严格递增。
+成交ID不大于上一笔成交的成交被忽略，重连时重复推送的成交不会被计算两次。
+*/
+type BarAggregator struct {
+	pair      string
+	spec      BarSpec
+	alignment Alignment
+
+	current   *model.Candle // current 正在生成的K线
+	ticks     int           // ticks 当前K线的成交笔数
+	amount    float64       // amount 当前K线的成交额
+	buyVolume float64       // buyVolume 当前K线主动买入的成交量
+	last      time.Time     // last 上一根完成的K线的开盘时间
+	lastID    int64         // lastID 上一笔成交的ID
+
+	// Renko的最后一块砖的上下边界和收盘价，anchored为false时还没有收到成交
+	top, bottom, level float64
+	anchored           bool
+}
+
+// NewBarAggregator 创建交易对的K线生成器，spec的格式见BarSpec，alignment只用于时间K线
+func NewBarAggregator(pair, spec string, alignment Alignment) (*BarAggregator, error) {
+	barSpec, err := ParseBarSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &BarAggregator{pair: pair, spec: barSpec, alignment: alignment}, nil
+}
+
+// Update 处理一笔成交，返回因这笔成交完成的K线
+func (b *BarAggregator) Update(tick model.Tick) []model.Candle {
+	if tick.ID != 0 {
+		if tick.ID <= b.lastID {
+			return nil
+		}
+		b.lastID = tick.ID
+	}
+
+	switch b.spec.Type {
+	case BarTime:
+		return b.updateTime(tick)
+	case BarRenko:
+		return b.updateRenko(tick)
+	}
+
+	b.add(tick, tick.Time)
+	var done bool
+	switch b.spec.Type {
+	case BarTick:
+		done = float64(b.ticks) >= b.spec.Size
+	case BarVolume:
+		done = b.current.Volume >= b.spec.Size
+	case BarDollar:
+		done = b.amount >= b.spec.Size
+	case BarRange:
+		done = b.current.High-b.current.Low >= b.spec.Size
+	}
+	if done {
+		return []model.Candle{b.finish()}
+	}
+	return nil
+}
+
+// Current 返回正在生成的K线，还没有成交时返回false
+func (b *BarAggregator) Current() (model.Candle, bool) {
+	if b.current == nil {
+		return model.Candle{}, false
+	}
+	candle := *b.current
+	candle.Metadata = b.metadata()
+	return candle, true
+}
+
+// updateTime 成交进入新的周期时完成上一根K线，迟到的成交被忽略
+func (b *BarAggregator) updateTime(tick model.Tick) []model.Candle {
+	start := b.spec.Timeframe.PeriodStart(tick.Time, b.alignment).UTC()
+
+	var result []model.Candle
+	if b.current != nil {
+		if start.Before(b.current.Time) {
+			return nil
+		}
+		if start.After(b.current.Time) {
+			result = append(result, b.finish())
+		}
+	}
+	b.add(tick, start)
+	return result
+}
+
+// updateRenko 价格超出最后一块砖的上边界或下边界Size时生成新的砖块
+func (b *BarAggregator) updateRenko(tick model.Tick) []model.Candle {
+	if !b.anchored {
+		// 第一块砖的边界按Size取整，不同起点的数据生成相同的砖块
+		b.top = math.Floor(tick.Price/b.spec.Size) * b.spec.Size
+		b.bottom, b.level = b.top, b.top
+		b.anchored = true
+	}
+	b.add(tick, tick.Time)
+
+	var result []model.Candle
+	for {
+		var from, to float64
+		switch {
+		case tick.Price >= b.top+b.spec.Size:
+			from, to = b.top, b.top+b.spec.Size
+			b.bottom, b.top = b.top, to
+		case tick.Price <= b.bottom-b.spec.Size:
+			from, to = b.bottom, b.bottom-b.spec.Size
+			b.top, b.bottom = b.bottom, to
+		default:
+			// 正在生成的砖块从最后一块砖的收盘价开始
+			if b.current != nil {
+				b.current.Open = b.level
+			}
+			return result
+		}
+
+		if b.current == nil {
+			b.reset(tick.Time, tick.Time)
+		}
+		b.current.Open, b.current.Close = from, to
+		b.current.High, b.current.Low = math.Max(from, to), math.Min(from, to)
+		b.level = to
+		result = append(result, b.finish())
+	}
+}
+
+// add 把成交计入当前K线，没有K线时以start为开盘时间创建
+func (b *BarAggregator) add(tick model.Tick, start time.Time) {
+	if b.current == nil {
+		b.reset(start, tick.Time)
+		b.current.Open, b.current.Low, b.current.High = tick.Price, tick.Price, tick.Price
+	}
+
+	candle := b.current
+	candle.UpdatedAt = tick.Time
+	candle.Close = tick.Price
+	candle.High = math.Max(candle.High, tick.Price)
+	candle.Low = math.Min(candle.Low, tick.Price)
+	candle.Volume += tick.Quantity
+	b.ticks++
+	b.amount += tick.Price * tick.Quantity
+	if !tick.BuyerMaker {
+		b.buyVolume += tick.Quantity
+	}
+}
+
+// reset 创建一根没有成交的K线
+func (b *BarAggregator) reset(start, updated time.Time) {
+	b.current = &model.Candle{Pair: b.pair, Time: start, UpdatedAt: updated}
+	b.ticks, b.amount, b.buyVolume = 0, 0, 0
+}
+
+// finish 完成当前K线
+func (b *BarAggregator) finish() model.Candle {
+	candle := *b.current
+	candle.Complete = true
+	candle.Metadata = b.metadata()
+	if !b.last.IsZero() && !candle.Time.After(b.last) {
+		candle.Time = b.last.Add(time.Nanosecond)
+	}
+	b.last = candle.Time
+	b.current = nil
+	return candle
+}
+
+func (b *BarAggregator) metadata() map[string]float64 {
+	return map[string]float64{
+		BarMetadataTicks:     float64(b.ticks),
+		BarMetadataBuyVolume: b.buyVolume,
+	}
+}
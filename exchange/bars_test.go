@@ -0,0 +1,305 @@
+package exchange
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+)
+
+func TestParseBarSpec(t *testing.T) {
+	tt := []struct {
+		spec     string
+		expected BarSpec
+	}{
+		{"1m", BarSpec{Type: BarTime, Timeframe: Timeframe{1, UnitMinute}}},
+		{"time:45m", BarSpec{Type: BarTime, Timeframe: Timeframe{45, UnitMinute}}},
+		{"tick:100", BarSpec{Type: BarTick, Size: 100}},
+		{"volume:0.5", BarSpec{Type: BarVolume, Size: 0.5}},
+		{"dollar:1e6", BarSpec{Type: BarDollar, Size: 1e6}},
+		{"renko:10", BarSpec{Type: BarRenko, Size: 10}},
+		{"range:25", BarSpec{Type: BarRange, Size: 25}},
+	}
+
+	for _, tc := range tt {
+		spec, err := ParseBarSpec(tc.spec)
+		require.NoError(t, err, tc.spec)
+		assert.Equal(t, tc.expected, spec, tc.spec)
+	}
+
+	for _, spec := range []string{"", "1y", "tick:1.5", "volume:0", "renko:-1", "range:abc", "kagi:10"} {
+		_, err := ParseBarSpec(spec)
+		require.ErrorIs(t, err, ErrInvalidBarSpec, spec)
+	}
+
+	assert.True(t, IsBarSpec("tick:100"))
+	assert.False(t, IsBarSpec("1h"))
+}
+
+func TestBarAggregator(t *testing.T) {
+	start := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	tick := func(id int64, second int, price, quantity float64) model.Tick {
+		return model.Tick{
+			Pair:       "BTCUSDT",
+			ID:         id,
+			Time:       start.Add(time.Duration(second) * time.Second),
+			Price:      price,
+			Quantity:   quantity,
+			BuyerMaker: id%2 == 0,
+		}
+	}
+	ticks := []model.Tick{
+		tick(1, 0, 100, 1),
+		tick(2, 10, 104, 2),
+		tick(3, 50, 98, 1),
+		tick(4, 70, 101, 3),
+		tick(5, 80, 110, 1),
+		tick(6, 130, 95, 2),
+		tick(7, 140, 96, 1),
+	}
+	aggregate := func(spec string, ticks []model.Tick) []model.Candle {
+		aggregator, err := NewBarAggregator("BTCUSDT", spec, Alignment{})
+		require.NoError(t, err)
+		var candles []model.Candle
+		for _, tick := range ticks {
+			candles = append(candles, aggregator.Update(tick)...)
+		}
+		return candles
+	}
+
+	t.Run("time", func(t *testing.T) {
+		candles := aggregate("1m", ticks)
+		// 最后一分钟的K线还没有完成
+		require.Len(t, candles, 2)
+		assert.Equal(t, model.Candle{
+			Pair:      "BTCUSDT",
+			Time:      start,
+			UpdatedAt: start.Add(50 * time.Second),
+			Open:      100,
+			Close:     98,
+			Low:       98,
+			High:      104,
+			Volume:    4,
+			Complete:  true,
+			Metadata:  map[string]float64{BarMetadataTicks: 3, BarMetadataBuyVolume: 2},
+		}, candles[0])
+		assert.Equal(t, start.Add(time.Minute), candles[1].Time)
+		assert.Equal(t, 110.0, candles[1].Close)
+	})
+
+	t.Run("tick", func(t *testing.T) {
+		candles := aggregate("tick:3", ticks)
+		require.Len(t, candles, 2)
+		assert.Equal(t, 98.0, candles[0].Close)
+		assert.Equal(t, 101.0, candles[1].Open)
+		assert.Equal(t, 95.0, candles[1].Close)
+		assert.Equal(t, ticks[3].Time, candles[1].Time)
+	})
+
+	t.Run("volume", func(t *testing.T) {
+		candles := aggregate("volume:3.5", ticks)
+		require.Len(t, candles, 2)
+		// 一笔成交不会被拆分，成交量可能超过大小
+		assert.Equal(t, 4.0, candles[0].Volume)
+		assert.Equal(t, 4.0, candles[1].Volume)
+		assert.Equal(t, ticks[4].Time, candles[1].UpdatedAt)
+	})
+
+	t.Run("dollar", func(t *testing.T) {
+		candles := aggregate("dollar:300", ticks)
+		require.Len(t, candles, 3)
+		assert.Equal(t, 2.0, candles[0].Metadata[BarMetadataTicks])
+		assert.Equal(t, 101.0, candles[1].Close)
+	})
+
+	t.Run("range", func(t *testing.T) {
+		candles := aggregate("range:6", ticks)
+		require.Len(t, candles, 2)
+		assert.Equal(t, 104.0, candles[0].High)
+		assert.Equal(t, 98.0, candles[0].Low)
+		assert.Equal(t, 110.0, candles[1].High)
+		assert.Equal(t, 101.0, candles[1].Low)
+	})
+
+	t.Run("renko", func(t *testing.T) {
+		candles := aggregate("renko:5", ticks)
+		// 110完成两块上涨的砖，反转需要从110下跌两块砖，95完成两块下跌的砖
+		require.Len(t, candles, 4)
+		opens, closes := make([]float64, 0, 4), make([]float64, 0, 4)
+		for _, candle := range candles {
+			opens, closes = append(opens, candle.Open), append(closes, candle.Close)
+		}
+		assert.Equal(t, []float64{100, 105, 105, 100}, opens)
+		assert.Equal(t, []float64{105, 110, 100, 95}, closes)
+		assert.Equal(t, 110.0, candles[1].High)
+		assert.Equal(t, 105.0, candles[1].Low)
+		assert.Equal(t, 8.0, candles[0].Volume)
+		assert.Zero(t, candles[1].Volume)
+		// 同一笔成交完成的砖块开盘时间严格递增
+		assert.Equal(t, ticks[5].Time, candles[2].Time)
+		assert.True(t, candles[3].Time.After(candles[2].Time))
+	})
+
+	t.Run("duplicated ticks", func(t *testing.T) {
+		duplicated := append(append([]model.Tick(nil), ticks[:4]...), ticks[2:]...)
+		assert.Equal(t, aggregate("tick:3", ticks), aggregate("tick:3", duplicated))
+	})
+
+	t.Run("current", func(t *testing.T) {
+		aggregator, err := NewBarAggregator("BTCUSDT", "tick:3", Alignment{})
+		require.NoError(t, err)
+		_, ok := aggregator.Current()
+		require.False(t, ok)
+
+		aggregator.Update(ticks[0])
+		aggregator.Update(ticks[1])
+		candle, ok := aggregator.Current()
+		require.True(t, ok)
+		assert.False(t, candle.Complete)
+		assert.Equal(t, 3.0, candle.Volume)
+		assert.Equal(t, 2.0, candle.Metadata[BarMetadataTicks])
+	})
+}
+
+func TestTickFeed(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// 币安归集成交文件没有表头，时间是毫秒
+	aggTrades := filepath.Join(dir, "BTCUSDT-aggTrades-2021-12-01.csv")
+	require.NoError(t, os.WriteFile(aggTrades, []byte(
+		"1,100.0,1.0,10,10,1638316800000,false,true\n"+
+			"2,104.0,2.0,11,12,1638316810000,true,true\n"+
+			"3,98.0,1.0,13,13,1638316850000,false,true\n"+
+			"4,101.0,3.0,14,15,1638316870000,true,true\n"), 0o644))
+
+	// ninjabot成交文件
+	file, err := os.Create(filepath.Join(dir, "2021-12-02.csv"))
+	require.NoError(t, err)
+	require.NoError(t, WriteTicksCSV(file, true, []model.Tick{
+		{ID: 5, Time: time.UnixMilli(1638316880000), Price: 110, Quantity: 1},
+		{ID: 6, Time: time.UnixMilli(1638316930000), Price: 95, Quantity: 2, BuyerMaker: true},
+		{ID: 7, Time: time.UnixMilli(1638316940000), Price: 96, Quantity: 1},
+	}))
+	require.NoError(t, file.Close())
+
+	ticks, err := ReadTicksCSV(file.Name(), "BTCUSDT")
+	require.NoError(t, err)
+	require.Len(t, ticks, 3)
+	assert.Equal(t, model.Tick{Pair: "BTCUSDT", ID: 6, Time: time.UnixMilli(1638316930000).UTC(), Price: 95,
+		Quantity: 2, BuyerMaker: true}, ticks[1])
+
+	feed, err := NewTickFeed(TickPairFeed{Pair: "BTCUSDT", Files: []string{aggTrades, file.Name()}})
+	require.NoError(t, err)
+
+	t.Run("bars", func(t *testing.T) {
+		candles, err := collectCandles(feed.CandlesSubscription(ctx, "BTCUSDT", "tick:3"))
+		require.NoError(t, err)
+		require.Len(t, candles, 2)
+		assert.Equal(t, 101.0, candles[1].Open)
+		assert.Equal(t, 95.0, candles[1].Close)
+
+		candles, err = feed.CandlesByPeriod(ctx, "BTCUSDT", "1m", time.Time{}, time.Now())
+		require.NoError(t, err)
+		require.Len(t, candles, 2)
+		assert.Equal(t, 4.0, candles[0].Volume)
+	})
+
+	t.Run("data feed subscription", func(t *testing.T) {
+		var candles []model.Candle
+		dataFeed := NewDataFeed(tickExchange{feed: feed})
+		dataFeed.Subscribe("BTCUSDT", "volume:4", func(candle model.Candle) {
+			candles = append(candles, candle)
+		}, true)
+		dataFeed.Start(true)
+		require.Len(t, candles, 2)
+	})
+
+	t.Run("invalid files", func(t *testing.T) {
+		_, err := NewTickFeed(TickPairFeed{Pair: "BTCUSDT"})
+		require.ErrorIs(t, err, ErrInsufficientData)
+
+		invalid := filepath.Join(dir, "invalid.csv")
+		require.NoError(t, os.WriteFile(invalid, []byte("a,b\n1,2\n"), 0o644))
+		_, err = NewTickFeed(TickPairFeed{Pair: "BTCUSDT", Files: []string{invalid}})
+		require.ErrorIs(t, err, ErrUnknownCSVLayout)
+	})
+}
+
+func TestTickBarFeed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	ticks := make(chan model.Tick)
+	feeder := &fakeTickFeeder{ticks: ticks}
+	feed := NewTickBarFeed(nil, feeder)
+
+	ccandle, _ := feed.CandlesSubscription(ctx, "BTCUSDT", "tick:2")
+	go func() {
+		for i := 1; i <= 5; i++ {
+			ticks <- model.Tick{Pair: "BTCUSDT", ID: int64(i), Time: start.Add(time.Duration(i) * time.Second),
+				Price: float64(100 + i), Quantity: 1}
+		}
+		close(ticks)
+	}()
+
+	var candles []model.Candle
+	for candle := range ccandle {
+		candles = append(candles, candle)
+	}
+	require.Len(t, candles, 2)
+	assert.Equal(t, 101.0, candles[0].Open)
+	assert.Equal(t, 104.0, candles[1].Close)
+
+	// CandlesByLimit 由最近的成交生成K线
+	feeder.history = []model.Tick{
+		{ID: 1, Time: time.Now().Add(-time.Minute), Price: 100, Quantity: 1},
+		{ID: 2, Time: time.Now().Add(-time.Minute), Price: 101, Quantity: 1},
+		{ID: 3, Time: time.Now(), Price: 102, Quantity: 1},
+	}
+	candles, err := feed.CandlesByLimit(ctx, "BTCUSDT", "tick:1", 2)
+	require.NoError(t, err)
+	require.Len(t, candles, 2)
+	assert.Equal(t, 102.0, candles[1].Close)
+
+	_, err = feed.CandlesByLimit(ctx, "BTCUSDT", "tick:2", 2)
+	require.ErrorIs(t, err, ErrInsufficientData)
+}
+
+// tickExchange 使用TickFeed作为交易所的数据源
+type tickExchange struct {
+	service.Exchange
+	feed *TickFeed
+}
+
+func (s tickExchange) CandlesSubscription(ctx context.Context, pair, timeframe string) (chan model.Candle,
+	chan error) {
+	return s.feed.CandlesSubscription(ctx, pair, timeframe)
+}
+
+type fakeTickFeeder struct {
+	ticks   chan model.Tick
+	history []model.Tick
+}
+
+func (f *fakeTickFeeder) TicksByPeriod(_ context.Context, _ string, start, end time.Time) ([]model.Tick, error) {
+	var ticks []model.Tick
+	for _, tick := range f.history {
+		if !tick.Time.Before(start) && !tick.Time.After(end) {
+			ticks = append(ticks, tick)
+		}
+	}
+	return ticks, nil
+}
+
+func (f *fakeTickFeeder) TicksSubscription(_ context.Context, _ string) (chan model.Tick, chan error) {
+	return f.ticks, make(chan error)
+}
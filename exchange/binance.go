@@ -2,8 +2,10 @@ package exchange
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/adshao/go-binance/v2"
@@ -28,8 +30,11 @@ type Binance struct {
 
 	APIKey    string // APIKey 用户的Binance API密钥，用于访问Binance API。
 	APISecret string // APISecret 用户的Binance API密钥，用于访问Binance API。
+	APIURL    string // APIURL 自定义的REST接口地址，例如本地的交易所模拟器，为空时使用币安的地址。
+	WsURL     string // WsURL 自定义的WebSocket地址，为空时使用币安的地址。
 
 	MetadataFetchers []MetadataFetchers // MetadataFetchers 是一个函数列表，用于在接收新K线数据后添加额外的元数据。
+	RateLimiter      *RateLimiter       // RateLimiter 控制REST请求的权重和下单次数，默认为NewBinanceRateLimiter。
 }
 
 // BinanceOption 定义了一个函数类型，用于通过不同的配置选项来定制化Binance实例。
@@ -66,6 +71,21 @@ func WithTestNet() BinanceOption {
 	}
 }
 
+// WithBinanceEndpoint 使用自定义的REST接口和WebSocket地址，例如本地的交易所模拟器，wsURL为空时WebSocket仍然连接币安。
+func WithBinanceEndpoint(apiURL, wsURL string) BinanceOption {
+	return func(b *Binance) {
+		b.APIURL = apiURL
+		b.WsURL = wsURL
+	}
+}
+
+// WithBinanceRateLimiter 使用指定的RateLimiter，同一个IP的多个实例可以共享一个RateLimiter。
+func WithBinanceRateLimiter(limiter *RateLimiter) BinanceOption {
+	return func(b *Binance) {
+		b.RateLimiter = limiter
+	}
+}
+
 // NewBinance 创建一个新的Binance实例，options 相当于type BinanceOption func(*Binance) 可以灵活的改动Binance结构体里面的任何东西 然后传参给NewBinance
 func NewBinance(ctx context.Context, options ...BinanceOption) (*Binance, error) {
 	// 开启WebSocket保持连接，以维持长时间的WebSocket连接不被断开。
@@ -81,6 +101,15 @@ func NewBinance(ctx context.Context, options ...BinanceOption) (*Binance, error)
 
 	// 使用API密钥和密钥创建Binance API客户端。
 	exchange.client = binance.NewClient(exchange.APIKey, exchange.APISecret)
+	if exchange.APIURL != "" {
+		exchange.client.BaseURL = exchange.APIURL
+	}
+
+	// 所有REST请求经过RateLimiter，超过请求权重或者下单次数的限制时排队等待。
+	if exchange.RateLimiter == nil {
+		exchange.RateLimiter = NewBinanceRateLimiter()
+	}
+	exchange.client.HTTPClient = exchange.RateLimiter.Client()
 
 	// 发送Ping请求到Binance服务器，检查API连接是否正常。
 	err := exchange.client.NewPingService().Do(ctx)
@@ -151,6 +180,12 @@ func (b *Binance) AssetsInfo(pair string) model.AssetInfo {
 	return b.assetsInfo[pair]
 }
 
+// PairsByQuote 返回现货市场报价资产为quote的所有交易对，按名称排序。
+// 与内置的PairsByQuote不同，交易对来自交易所当前的交易对信息，不包含只在合约市场存在的交易对。
+func (b *Binance) PairsByQuote(quote string) []string {
+	return pairsByQuote(b.assetsInfo, quote)
+}
+
 // 根据交易对，数量进行校验
 func (b *Binance) validate(pair string, quantity float64) error {
 	// 从assetsInfo映射中查找指定交易对的资产信息。
@@ -176,7 +211,7 @@ func (b *Binance) validate(pair string, quantity float64) error {
 
 // CreateOrderOCO 创建一个OCO（One Cancels the Other，一单成交另一单取消）订单。参数 买卖方向、交易对、数量、价格、止损价、止损限价
 func (b *Binance) CreateOrderOCO(side model.SideType, pair string,
-	quantity, price, stop, stopLimit float64) ([]model.Order, error) {
+	quantity, price, stop, stopLimit float64, options ...model.OrderOption) ([]model.Order, error) {
 
 	// 验证给定的数量是否满足交易所的最小和最大交易量限制。
 	err := b.validate(pair, quantity)
@@ -186,15 +221,24 @@ func (b *Binance) CreateOrderOCO(side model.SideType, pair string,
 	}
 
 	// 使用Binance API客户端创建OCO订单。
-	ocoOrder, err := b.client.NewCreateOCOService().
+	params := model.NewOrderParams(options...)
+	service := b.client.NewCreateOCOService().
 		Side(binance.SideType(side)).                     // 设置订单方向（买/卖）。
 		Quantity(b.formatQuantity(pair, quantity)).       // 格式化并设置订单数量。
 		Price(b.formatPrice(pair, price)).                // 格式化并设置订单价格。
 		StopPrice(b.formatPrice(pair, stop)).             // 格式化并设置止损价格。
 		StopLimitPrice(b.formatPrice(pair, stopLimit)).   // 格式化并设置止损限价。其止损价格为95美元 跌倒95美元触发止损价，但是不会立即执行，限价90美元：这意味着即使市场价格快速下跌，穿过了95美元，只要价格没有低于90美元，你的资产就有可能被出售。
 		StopLimitTimeInForce(binance.TimeInForceTypeGTC). // 设置订单有效期为“直到取消”。根据市场的订单类型（如限价订单、市价订单）直到被成交，或者被用户手动取消。
-		Symbol(pair).                                     // 设置交易对。
-		Do(b.ctx)                                         // 执行创建订单的操作。
+		Symbol(pair)                                      // 设置交易对。
+
+	// 设置客户端订单ID，止损单加上"-stop"后缀以便区分
+	if params.ClientOrderID != "" {
+		service.ListClientOrderID(params.ClientOrderID).
+			LimitClientOrderID(params.ClientOrderID).
+			StopClientOrderID(params.ClientOrderID + "-stop")
+	}
+
+	ocoOrder, err := service.Do(b.ctx) // 执行创建订单的操作。
 	if err != nil {
 		// 如果创建订单失败，返回错误。
 		return nil, err
@@ -209,16 +253,18 @@ func (b *Binance) CreateOrderOCO(side model.SideType, pair string,
 		quantity, _ := strconv.ParseFloat(order.OrigQuantity, 64)
 		// 构建内部订单模型。
 		item := model.Order{
-			ExchangeID: order.OrderID,                                                  // 订单在交易所的ID。
-			CreatedAt:  time.Unix(0, ocoOrder.TransactionTime*int64(time.Millisecond)), // 订单创建时间。
-			UpdatedAt:  time.Unix(0, ocoOrder.TransactionTime*int64(time.Millisecond)), // 订单更新时间。
-			Pair:       pair,                                                           // 交易对。
-			Side:       model.SideType(order.Side),                                     // 订单方向。
-			Type:       model.OrderType(order.Type),                                    // 订单类型。
-			Status:     model.OrderStatusType(order.Status),                            // 订单状态。
-			Price:      price,                                                          // 订单价格。
-			Quantity:   quantity,                                                       // 订单数量。
-			GroupID:    &order.OrderListID,                                             // 订单组ID，用于将OCO订单联系起来。
+			ExchangeID:    order.OrderID,                                                  // 订单在交易所的ID。
+			CreatedAt:     time.Unix(0, ocoOrder.TransactionTime*int64(time.Millisecond)), // 订单创建时间。
+			UpdatedAt:     time.Unix(0, ocoOrder.TransactionTime*int64(time.Millisecond)), // 订单更新时间。
+			Pair:          pair,                                                           // 交易对。
+			Side:          model.SideType(order.Side),                                     // 订单方向。
+			Type:          model.OrderType(order.Type),                                    // 订单类型。
+			Status:        model.OrderStatusType(order.Status),                            // 订单状态。
+			Price:         price,                                                          // 订单价格。
+			Quantity:      quantity,                                                       // 订单数量。
+			GroupID:       &order.OrderListID,                                             // 订单组ID，用于将OCO订单联系起来。
+			ClientOrderID: order.ClientOrderID,                                            // 客户端订单ID。
+			TimeInForce:   model.TimeInForceType(order.TimeInForce),                       // 订单有效方式。
 		}
 
 		// 如果订单是止损类型，设置止损价格。把item.Stop设置为stop的地址，让我们在需要的时候可以方便地查看或修改止损价格，同时也允许我们在不需要止损价格时，明确表示出这一点。这种方式既节省了资源，又提高了程序处理的灵活性。想拿到值 *item.Stop
@@ -235,7 +281,8 @@ func (b *Binance) CreateOrderOCO(side model.SideType, pair string,
 }
 
 // 定义CreateOrderStop方法，用于创建止损卖单。参数 交易对、 数量、止损价格
-func (b *Binance) CreateOrderStop(pair string, quantity float64, limit float64) (model.Order, error) {
+func (b *Binance) CreateOrderStop(pair string, quantity float64, limit float64,
+	options ...model.OrderOption) (model.Order, error) {
 	// 验证交易对和数量是否符合交易所的要求。
 	err := b.validate(pair, quantity)
 	if err != nil {
@@ -243,14 +290,24 @@ func (b *Binance) CreateOrderStop(pair string, quantity float64, limit float64)
 		return model.Order{}, err
 	}
 
+	// 现货的止损单不能设置只做挂单（GTX），交易所会拒绝，下单前直接返回错误
+	params := model.NewOrderParams(options...)
+	if params.TimeInForce == model.TimeInForceGTX {
+		return model.Order{}, fmt.Errorf("%w: binance spot post-only stop order", ErrNotSupported)
+	}
+
 	// 使用Binance API客户端创建新的订单服务，配置订单参数。
-	order, err := b.client.NewCreateOrderService().Symbol(pair).
+	service := b.client.NewCreateOrderService().Symbol(pair).
 		Type(binance.OrderTypeStopLoss).            // 设置订单类型为止损。
-		TimeInForce(binance.TimeInForceTypeGTC).    // 设置订单有效期为直到取消（GTC）。
+		TimeInForce(binanceTimeInForce(params)).    // 设置订单有效期，默认为直到取消（GTC）。
 		Side(binance.SideTypeSell).                 // 设置订单为卖出。
 		Quantity(b.formatQuantity(pair, quantity)). // 设置订单数量，经过格式化。
-		Price(b.formatPrice(pair, limit)).          // 设置止损价格，经过格式化。
-		Do(b.ctx)                                   // 执行订单创建操作。
+		Price(b.formatPrice(pair, limit))           // 设置止损价格，经过格式化。
+	if params.ClientOrderID != "" {
+		service.NewClientOrderID(params.ClientOrderID) // 设置客户端订单ID。
+	}
+
+	order, err := service.Do(b.ctx) // 执行订单创建操作。
 	if err != nil {
 		// 如果创建订单失败，返回空的Order结构体和错误信息。
 		return model.Order{}, err
@@ -261,17 +318,29 @@ func (b *Binance) CreateOrderStop(pair string, quantity float64, limit float64)
 	quantity, _ = strconv.ParseFloat(order.OrigQuantity, 64)
 
 	// 构造并返回一个填充了订单信息的Order结构体。
-	return model.Order{
-		ExchangeID: order.OrderID,                                            // 交易所的订单ID。
-		CreatedAt:  time.Unix(0, order.TransactTime*int64(time.Millisecond)), // 订单创建时间，转换为Go的时间格式。
-		UpdatedAt:  time.Unix(0, order.TransactTime*int64(time.Millisecond)), // 订单更新时间，同上。
-		Pair:       pair,                                                     // 交易对。
-		Side:       model.SideType(order.Side),                               // 订单方向（卖出）。
-		Type:       model.OrderType(order.Type),                              // 订单类型（止损）。
-		Status:     model.OrderStatusType(order.Status),                      // 订单状态。
-		Price:      price,                                                    // 订单价格。
-		Quantity:   quantity,                                                 // 订单数量。
-	}, nil
+	result := model.Order{
+		ExchangeID:    order.OrderID,                                            // 交易所的订单ID。
+		CreatedAt:     time.Unix(0, order.TransactTime*int64(time.Millisecond)), // 订单创建时间，转换为Go的时间格式。
+		UpdatedAt:     time.Unix(0, order.TransactTime*int64(time.Millisecond)), // 订单更新时间，同上。
+		Pair:          pair,                                                     // 交易对。
+		Side:          model.SideType(order.Side),                               // 订单方向（卖出）。
+		Type:          model.OrderType(order.Type),                              // 订单类型（止损）。
+		Status:        model.OrderStatusType(order.Status),                      // 订单状态。
+		Price:         price,                                                    // 订单价格。
+		Quantity:      quantity,                                                 // 订单数量。
+		ClientOrderID: order.ClientOrderID,                                      // 客户端订单ID。
+		TimeInForce:   model.TimeInForceType(order.TimeInForce),                 // 订单有效方式。
+	}
+	params.Apply(&result)
+	return result, nil
+}
+
+// binanceTimeInForce 将订单参数中的有效方式转换为币安的类型，未指定时使用GTC。
+func binanceTimeInForce(params model.OrderParams) binance.TimeInForceType {
+	if params.TimeInForce == "" {
+		return binance.TimeInForceTypeGTC
+	}
+	return binance.TimeInForceType(params.TimeInForce)
 }
 
 // formatPrice用于格式化给定的价格值，确保它符合特定交易对的价格规则。
@@ -295,7 +364,7 @@ func (b *Binance) formatQuantity(pair string, value float64) string {
 
 // CreateOrderLimit 创建一个限价订单。
 func (b *Binance) CreateOrderLimit(side model.SideType, pair string,
-	quantity float64, limit float64) (model.Order, error) {
+	quantity float64, limit float64, options ...model.OrderOption) (model.Order, error) {
 
 	// 验证交易对和数量是否符合交易所的规则。
 	err := b.validate(pair, quantity)
@@ -305,14 +374,25 @@ func (b *Binance) CreateOrderLimit(side model.SideType, pair string,
 	}
 
 	// 使用Binance API客户端配置并发送创建限价订单的请求。
-	order, err := b.client.NewCreateOrderService().
+	params := model.NewOrderParams(options...)
+	service := b.client.NewCreateOrderService().
 		Symbol(pair).                               // 设置交易对。
-		Type(binance.OrderTypeLimit).               // 设置订单类型为限价。
-		TimeInForce(binance.TimeInForceTypeGTC).    // 设置订单有效期为“直到取消（GTC）”。
 		Side(binance.SideType(side)).               // 设置订单买卖方向（买入/卖出）。
 		Quantity(b.formatQuantity(pair, quantity)). // 设置订单数量，经过格式化以符合交易对要求。
-		Price(b.formatPrice(pair, limit)).          // 设置订单价格，经过格式化以符合交易对要求。
-		Do(b.ctx)                                   // 执行创建订单操作。
+		Price(b.formatPrice(pair, limit))           // 设置订单价格，经过格式化以符合交易对要求。
+
+	// 现货的只做挂单通过LIMIT_MAKER订单类型实现，不能再设置有效方式
+	if params.TimeInForce == model.TimeInForceGTX {
+		service.Type(binance.OrderTypeLimitMaker)
+	} else {
+		service.Type(binance.OrderTypeLimit).TimeInForce(binanceTimeInForce(params))
+	}
+
+	if params.ClientOrderID != "" {
+		service.NewClientOrderID(params.ClientOrderID) // 设置客户端订单ID。
+	}
+
+	order, err := service.Do(b.ctx) // 执行创建订单操作。
 	if err != nil {
 		// 如果创建订单失败，返回空的Order结构体和错误信息。
 		return model.Order{}, err
@@ -333,21 +413,28 @@ func (b *Binance) CreateOrderLimit(side model.SideType, pair string,
 	}
 
 	// 构造并返回一个填充了订单信息的Order结构体实例。
-	return model.Order{
-		ExchangeID: order.OrderID,                                            // 订单在交易所的唯一标识符。
-		CreatedAt:  time.Unix(0, order.TransactTime*int64(time.Millisecond)), // 订单创建时间。
-		UpdatedAt:  time.Unix(0, order.TransactTime*int64(time.Millisecond)), // 订单更新时间。
-		Pair:       pair,                                                     // 交易对。
-		Side:       model.SideType(order.Side),                               // 订单买卖方向。
-		Type:       model.OrderType(order.Type),                              // 订单类型（限价）。
-		Status:     model.OrderStatusType(order.Status),                      // 订单状态。
-		Price:      price,                                                    // 订单价格。
-		Quantity:   quantity,                                                 // 订单数量。
-	}, nil
+	result := model.Order{
+		ExchangeID:    order.OrderID,                                            // 订单在交易所的唯一标识符。
+		CreatedAt:     time.Unix(0, order.TransactTime*int64(time.Millisecond)), // 订单创建时间。
+		UpdatedAt:     time.Unix(0, order.TransactTime*int64(time.Millisecond)), // 订单更新时间。
+		Pair:          pair,                                                     // 交易对。
+		Side:          model.SideType(order.Side),                               // 订单买卖方向。
+		Type:          model.OrderType(order.Type),                              // 订单类型（限价）。
+		Status:        model.OrderStatusType(order.Status),                      // 订单状态。
+		Price:         price,                                                    // 订单价格。
+		Quantity:      quantity,                                                 // 订单数量。
+		ClientOrderID: order.ClientOrderID,                                      // 客户端订单ID。
+		TimeInForce:   model.TimeInForceType(order.TimeInForce),                 // 订单有效方式。
+	}
+
+	// 币安现货不支持按时间过期，过期时间记录在订单上，由Controller到期后撤单
+	params.Apply(&result)
+	return result, nil
 }
 
 // CreateOrderMarket 创建一个市价订单。CreateOrderMarket 方法直接指定了要购买或出售的货币(如btc 1个)数量，
-func (b *Binance) CreateOrderMarket(side model.SideType, pair string, quantity float64) (model.Order, error) {
+func (b *Binance) CreateOrderMarket(side model.SideType, pair string, quantity float64,
+	options ...model.OrderOption) (model.Order, error) {
 	// 首先，验证交易对和数量是否符合交易所的规则。
 	err := b.validate(pair, quantity)
 	if err != nil {
@@ -356,13 +443,18 @@ func (b *Binance) CreateOrderMarket(side model.SideType, pair string, quantity f
 	}
 
 	// 使用Binance API客户端配置并发送创建市价订单的请求。
-	order, err := b.client.NewCreateOrderService().
-		Symbol(pair).                                   // 设置交易对。
-		Type(binance.OrderTypeMarket).                  // 设置订单类型为市价。
-		Side(binance.SideType(side)).                   // 设置订单买卖方向（买入/卖出）。
-		Quantity(b.formatQuantity(pair, quantity)).     // 设置订单数量，经过格式化以符合交易对要求。
-		NewOrderRespType(binance.NewOrderRespTypeFULL). // 设置返回类型为完整响应。味着你希望在订单创建成功后，API返回包含完整订单信息的响应。这包括订单的所有细节，如成交量、成交价格、订单状态等。
-		Do(b.ctx)                                       // 执行创建订单操作。
+	params := model.NewOrderParams(options...)
+	service := b.client.NewCreateOrderService().
+		Symbol(pair).                                  // 设置交易对。
+		Type(binance.OrderTypeMarket).                 // 设置订单类型为市价。
+		Side(binance.SideType(side)).                  // 设置订单买卖方向（买入/卖出）。
+		Quantity(b.formatQuantity(pair, quantity)).    // 设置订单数量，经过格式化以符合交易对要求。
+		NewOrderRespType(binance.NewOrderRespTypeFULL) // 设置返回类型为完整响应。味着你希望在订单创建成功后，API返回包含完整订单信息的响应。这包括订单的所有细节，如成交量、成交价格、订单状态等。
+	if params.ClientOrderID != "" {
+		service.NewClientOrderID(params.ClientOrderID) // 设置客户端订单ID。
+	}
+
+	order, err := service.Do(b.ctx) // 执行创建订单操作。
 	if err != nil {
 		// 如果创建订单失败，返回空的Order结构体和错误信息。
 		return model.Order{}, err
@@ -382,20 +474,22 @@ func (b *Binance) CreateOrderMarket(side model.SideType, pair string, quantity f
 
 	// 构造并返回一个填充了订单信息的Order结构体实例。
 	return model.Order{
-		ExchangeID: order.OrderID,                                            // 订单在交易所的唯一标识符。
-		CreatedAt:  time.Unix(0, order.TransactTime*int64(time.Millisecond)), // 订单创建时间。
-		UpdatedAt:  time.Unix(0, order.TransactTime*int64(time.Millisecond)), // 订单更新时间。
-		Pair:       order.Symbol,                                             // 交易对。
-		Side:       model.SideType(order.Side),                               // 订单买卖方向。
-		Type:       model.OrderType(order.Type),                              // 订单类型（市价）。
-		Status:     model.OrderStatusType(order.Status),                      // 订单状态。
-		Price:      cost / quantity,                                          // 计算平均成交价格。
-		Quantity:   quantity,                                                 // 订单数量。
+		ExchangeID:    order.OrderID,                                            // 订单在交易所的唯一标识符。
+		CreatedAt:     time.Unix(0, order.TransactTime*int64(time.Millisecond)), // 订单创建时间。
+		UpdatedAt:     time.Unix(0, order.TransactTime*int64(time.Millisecond)), // 订单更新时间。
+		Pair:          order.Symbol,                                             // 交易对。
+		Side:          model.SideType(order.Side),                               // 订单买卖方向。
+		Type:          model.OrderType(order.Type),                              // 订单类型（市价）。
+		Status:        model.OrderStatusType(order.Status),                      // 订单状态。
+		Price:         cost / quantity,                                          // 计算平均成交价格。
+		Quantity:      quantity,                                                 // 订单数量。
+		ClientOrderID: order.ClientOrderID,                                      // 客户端订单ID。
 	}, nil
 }
 
 // CreateOrderMarketQuote 创建一个基于报价金额的市价订单。将交易对设置为BTC/USDT，然后指定报价货币数量为100 USDT，这样就会以市价条件购买BTC，获得相应的BTC数量。而 CreateOrderMarketQuote 方法则指定了用于交易的报价金额（通常是基准货币的数量）。
-func (b *Binance) CreateOrderMarketQuote(side model.SideType, pair string, quantity float64) (model.Order, error) {
+func (b *Binance) CreateOrderMarketQuote(side model.SideType, pair string, quantity float64,
+	options ...model.OrderOption) (model.Order, error) {
 	// 验证交易对和报价数量是否符合交易所的规则。
 	err := b.validate(pair, quantity)
 	if err != nil {
@@ -404,13 +498,18 @@ func (b *Binance) CreateOrderMarketQuote(side model.SideType, pair string, quant
 	}
 
 	// 使用Binance API客户端配置并发送创建市价订单的请求，这里的订单是基于报价金额而不是数量。
-	order, err := b.client.NewCreateOrderService().
+	params := model.NewOrderParams(options...)
+	service := b.client.NewCreateOrderService().
 		Symbol(pair).                                    // 设置交易对。
 		Type(binance.OrderTypeMarket).                   // 设置订单类型为市价。
 		Side(binance.SideType(side)).                    // 设置订单买卖方向（买入/卖出）。
 		QuoteOrderQty(b.formatQuantity(pair, quantity)). // 设置基于报价金额（USDT）的订单数量，经过格式化以符合交易对要求。
-		NewOrderRespType(binance.NewOrderRespTypeFULL).  // 设置返回类型为完整响应。
-		Do(b.ctx)                                        // 执行创建订单操作。
+		NewOrderRespType(binance.NewOrderRespTypeFULL)   // 设置返回类型为完整响应。
+	if params.ClientOrderID != "" {
+		service.NewClientOrderID(params.ClientOrderID) // 设置客户端订单ID。
+	}
+
+	order, err := service.Do(b.ctx) // 执行创建订单操作。
 	if err != nil {
 		// 如果创建订单失败，返回空的Order结构体和错误信息。
 		return model.Order{}, err
@@ -430,18 +529,29 @@ func (b *Binance) CreateOrderMarketQuote(side model.SideType, pair string, quant
 
 	// 构造并返回一个填充了订单信息的Order结构体实例。
 	return model.Order{
-		ExchangeID: order.OrderID,                                            // 订单在交易所的唯一标识符。
-		CreatedAt:  time.Unix(0, order.TransactTime*int64(time.Millisecond)), // 订单创建时间。
-		UpdatedAt:  time.Unix(0, order.TransactTime*int64(time.Millisecond)), // 订单更新时间。
-		Pair:       order.Symbol,                                             // 交易对。
-		Side:       model.SideType(order.Side),                               // 订单买卖方向。
-		Type:       model.OrderType(order.Type),                              // 订单类型（市价）。
-		Status:     model.OrderStatusType(order.Status),                      // 订单状态。
-		Price:      cost / quantity,                                          // 计算平均成交价格。
-		Quantity:   quantity,                                                 // 实际执行数量。
+		ExchangeID:    order.OrderID,                                            // 订单在交易所的唯一标识符。
+		CreatedAt:     time.Unix(0, order.TransactTime*int64(time.Millisecond)), // 订单创建时间。
+		UpdatedAt:     time.Unix(0, order.TransactTime*int64(time.Millisecond)), // 订单更新时间。
+		Pair:          order.Symbol,                                             // 交易对。
+		Side:          model.SideType(order.Side),                               // 订单买卖方向。
+		Type:          model.OrderType(order.Type),                              // 订单类型（市价）。
+		Status:        model.OrderStatusType(order.Status),                      // 订单状态。
+		Price:         cost / quantity,                                          // 计算平均成交价格。
+		Quantity:      quantity,                                                 // 实际执行数量。
+		ClientOrderID: order.ClientOrderID,                                      // 客户端订单ID。
 	}, nil
 }
 
+// ReplaceOrder 修改挂单的价格和数量。币安现货没有原子改单接口，通过撤单后重新下限价单实现，
+// 新订单沿用原订单的有效方式和过期时间，并通过ReplacedID关联原订单。
+func (b *Binance) ReplaceOrder(order model.Order, price, quantity float64) (model.Order, error) {
+	// 撤单前先校验新数量，避免原订单被撤掉后新订单因为数量不合法下单失败
+	if err := b.validate(order.Pair, quantity); err != nil {
+		return model.Order{}, err
+	}
+	return cancelReplace(b, order, price, quantity)
+}
+
 func (b *Binance) Cancel(order model.Order) error {
 	// 使用Binance API客户端配置并发送取消订单的请求，指定要取消的订单的交易对和订单ID。
 	_, err := b.client.NewCancelOrderService().
@@ -487,15 +597,23 @@ func (b *Binance) Order(pair string, id int64) (model.Order, error) {
 		OrderID(id).
 		Do(b.ctx)
 
-	// 如果在获取订单信息时发生了错误，直接返回空订单和错误信息。
+	// 如果在获取订单信息时发生了错误，直接返回空订单和错误信息，订单不存在时返回ErrOrderNotFound。
 	if err != nil {
-		return model.Order{}, err
+		return model.Order{}, binanceOrderError(err)
 	}
 
 	// 将获取到的订单信息转换为应用内部的订单模型，并返回给调用者。
 	return newOrder(order), nil
 }
 
+// binanceOrderError 把币安订单不存在的错误码转换为ErrOrderNotFound，其他错误原样返回。
+func binanceOrderError(err error) error {
+	if apiError, ok := err.(*common.APIError); ok && apiError.Code == ErrOrderDoesNotExist {
+		return fmt.Errorf("%w: %s", ErrOrderNotFound, apiError.Message)
+	}
+	return err
+}
+
 // newOrder 函数用于将从 Binance API 返回的订单转换为内部订单模型。
 // 它接收一个指向 binance.Order 类型的指针作为参数，并返回一个 model.Order 类型的实例。
 func newOrder(order *binance.Order) model.Order {
@@ -514,15 +632,17 @@ func newOrder(order *binance.Order) model.Order {
 
 	// 构建并返回内部订单模型。
 	return model.Order{
-		ExchangeID: order.OrderID,                                          // 订单在交易所的唯一标识符。
-		Pair:       order.Symbol,                                           // 交易对。
-		CreatedAt:  time.Unix(0, order.Time*int64(time.Millisecond)),       // 订单创建时间。
-		UpdatedAt:  time.Unix(0, order.UpdateTime*int64(time.Millisecond)), // 订单更新时间。
-		Side:       model.SideType(order.Side),                             // 订单买卖方向。
-		Type:       model.OrderType(order.Type),                            // 订单类型。
-		Status:     model.OrderStatusType(order.Status),                    // 订单状态。
-		Price:      price,                                                  // 订单价格。
-		Quantity:   quantity,                                               // 订单数量。
+		ExchangeID:    order.OrderID,                                          // 订单在交易所的唯一标识符。
+		Pair:          order.Symbol,                                           // 交易对。
+		CreatedAt:     time.Unix(0, order.Time*int64(time.Millisecond)),       // 订单创建时间。
+		UpdatedAt:     time.Unix(0, order.UpdateTime*int64(time.Millisecond)), // 订单更新时间。
+		Side:          model.SideType(order.Side),                             // 订单买卖方向。
+		Type:          model.OrderType(order.Type),                            // 订单类型。
+		Status:        model.OrderStatusType(order.Status),                    // 订单状态。
+		Price:         price,                                                  // 订单价格。
+		Quantity:      quantity,                                               // 订单数量。
+		ClientOrderID: order.ClientOrderID,                                    // 客户端订单ID。
+		TimeInForce:   model.TimeInForceType(order.TimeInForce),               // 订单有效方式。
 	}
 }
 
@@ -583,6 +703,25 @@ func (b *Binance) Position(pair string) (asset, quote float64, err error) {
 	return assetTotal, quoteTotal, nil
 }
 
+// PositionInfo 返回现货持仓详情。现货没有开仓均价和杠杆，持仓数量是基础资产的总余额，标记价格使用最新成交价。
+func (b *Binance) PositionInfo(pair string) (model.PositionInfo, error) {
+	assetTick, _ := SplitAssetQuote(pair)
+	acc, err := b.Account()
+	if err != nil {
+		return model.PositionInfo{}, err
+	}
+
+	position := accountPosition(acc, pair, assetTick)
+	if position.Open() {
+		position.MarkPrice, err = b.LastQuote(b.ctx, pair)
+		if err != nil {
+			return model.PositionInfo{}, err
+		}
+	}
+	position.UpdatedAt = time.Now()
+	return position, nil
+}
+
 // CandlesSubscription 方法用于订阅指定交易对和周期的K线数据流，并返回两个通道，一个用于接收K线数据，另一个用于接收错误信息。
 // ctx 是上下文对象，用于控制订阅的生命周期。
 // pair 是交易对，表示要订阅的资产对，例如 "BTCUSDT"。
@@ -607,7 +746,7 @@ func (b *Binance) CandlesSubscription(ctx context.Context, pair, period string)
 		// 循环进行 K 线数据订阅，直到订阅被取消或发生错误。
 		for {
 			// 启动 K 线数据订阅，返回一个 done 通道用于信号订阅是否完成，以及一个错误通道用于传递连接错误。
-			done, _, err := binance.WsKlineServe(pair, period, func(event *binance.WsKlineEvent) {
+			done, stop, err := b.wsKlineServe(pair, period, func(event *binance.WsKlineEvent) {
 				// 重置指数退避器，以便在成功接收数据时重新计时。
 				ba.Reset()
 				// 将 WebSocket 事件转换为 Candle 结构。
@@ -628,10 +767,16 @@ func (b *Binance) CandlesSubscription(ctx context.Context, pair, period string)
 				}
 
 				// 将处理后的 K 线数据发送到通道中。
-				ccandle <- candle
+				select {
+				case ccandle <- candle:
+				case <-ctx.Done():
+				}
 			}, func(err error) {
 				// 在发生连接错误时，将错误信息发送到错误通道中。
-				cerr <- err
+				select {
+				case cerr <- err:
+				case <-ctx.Done():
+				}
 			})
 			// 如果发生连接错误，则将错误信息发送到错误通道中并关闭通道，然后退出循环。
 			if err != nil {
@@ -644,6 +789,9 @@ func (b *Binance) CandlesSubscription(ctx context.Context, pair, period string)
 			// 等待 ctx 取消信号或订阅完成信号，如果收到取消信号，则关闭通道并退出循环。
 			select {
 			case <-ctx.Done():
+				// 先断开连接并等待连接协程退出，避免向已经关闭的通道发送
+				close(stop)
+				<-done
 				close(cerr)
 				close(ccandle)
 				return
@@ -658,6 +806,24 @@ func (b *Binance) CandlesSubscription(ctx context.Context, pair, period string)
 	return ccandle, cerr
 }
 
+// wsKlineServe 订阅K线推送，配置了自定义WebSocket地址时连接该地址，否则使用go-binance连接币安。
+func (b *Binance) wsKlineServe(pair, period string, handler binance.WsKlineHandler,
+	errHandler binance.ErrHandler) (doneC, stopC chan struct{}, err error) {
+	if b.WsURL == "" {
+		return binance.WsKlineServe(pair, period, handler, errHandler)
+	}
+
+	endpoint := fmt.Sprintf("%s/%s@kline_%s", b.WsURL, strings.ToLower(pair), period)
+	return wsServe(endpoint, func(message []byte) {
+		event := new(binance.WsKlineEvent)
+		if err := json.Unmarshal(message, event); err != nil {
+			errHandler(err)
+			return
+		}
+		handler(event)
+	}, errHandler)
+}
+
 // CandlesByLimit 获取指定交易对、时间周期和数量限制的K线数据。
 func (b *Binance) CandlesByLimit(ctx context.Context, pair, period string, limit int) ([]model.Candle, error) {
 	// 初始化用于存储K线数据的切片。
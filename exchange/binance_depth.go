@@ -0,0 +1,145 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/jpillora/backoff"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+// DepthSnapshot 获取订单簿快照，limit是每一边的档位数量，币安支持5到5000档
+func (b *Binance) DepthSnapshot(ctx context.Context, pair string, limit int) (model.DepthUpdate, error) {
+	depth, err := b.client.NewDepthService().Symbol(pair).Limit(limit).Do(ctx)
+	if err != nil {
+		return model.DepthUpdate{}, err
+	}
+
+	return model.DepthUpdate{
+		Pair:         pair,
+		Time:         time.Now().UTC(),
+		LastUpdateID: depth.LastUpdateID,
+		Bids:         priceLevels(depth.Bids),
+		Asks:         priceLevels(depth.Asks),
+		Snapshot:     true,
+	}, nil
+}
+
+// DepthSubscription 订阅每100ms推送一次的订单簿增量更新，连接断开后按指数退避重连。
+// 重连期间丢失的更新由DepthFeed通过更新ID发现，并重新获取快照。
+func (b *Binance) DepthSubscription(ctx context.Context, pair string) (chan model.DepthUpdate, chan error) {
+	cupdate := make(chan model.DepthUpdate)
+	cerr := make(chan error)
+
+	go func() {
+		ba := &backoff.Backoff{
+			Min: 100 * time.Millisecond,
+			Max: 1 * time.Second,
+		}
+
+		for {
+			done, stop, err := b.wsDepthServe(pair, func(event *binance.WsDepthEvent) {
+				ba.Reset()
+				select {
+				case cupdate <- DepthUpdateFromWsDepth(pair, event):
+				case <-ctx.Done():
+				}
+			}, func(err error) {
+				select {
+				case cerr <- err:
+				case <-ctx.Done():
+				}
+			})
+			if err != nil {
+				cerr <- err
+				close(cerr)
+				close(cupdate)
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				// 先断开连接并等待连接协程退出，避免向已经关闭的通道发送
+				close(stop)
+				<-done
+				close(cerr)
+				close(cupdate)
+				return
+			case <-done:
+				time.Sleep(ba.Duration())
+			}
+		}
+	}()
+
+	return cupdate, cerr
+}
+
+// wsDepthEvent 是深度推送的原始消息，档位是[价格, 数量]数组，不能直接解析为binance.WsDepthEvent
+type wsDepthEvent struct {
+	Time          int64       `json:"E"`
+	FirstUpdateID int64       `json:"U"`
+	LastUpdateID  int64       `json:"u"`
+	Bids          [][2]string `json:"b"`
+	Asks          [][2]string `json:"a"`
+}
+
+// wsDepthServe 订阅深度推送，配置了自定义WebSocket地址时连接该地址，否则使用go-binance连接币安。
+func (b *Binance) wsDepthServe(pair string, handler binance.WsDepthHandler,
+	errHandler binance.ErrHandler) (doneC, stopC chan struct{}, err error) {
+	if b.WsURL == "" {
+		return binance.WsDepthServe100Ms(pair, handler, errHandler)
+	}
+
+	endpoint := fmt.Sprintf("%s/%s@depth@100ms", b.WsURL, strings.ToLower(pair))
+	return wsServe(endpoint, func(message []byte) {
+		raw := new(wsDepthEvent)
+		if err := json.Unmarshal(message, raw); err != nil {
+			errHandler(err)
+			return
+		}
+
+		event := &binance.WsDepthEvent{
+			Time:          raw.Time,
+			Symbol:        pair,
+			FirstUpdateID: raw.FirstUpdateID,
+			LastUpdateID:  raw.LastUpdateID,
+		}
+		for _, level := range raw.Bids {
+			event.Bids = append(event.Bids, binance.Bid{Price: level[0], Quantity: level[1]})
+		}
+		for _, level := range raw.Asks {
+			event.Asks = append(event.Asks, binance.Ask{Price: level[0], Quantity: level[1]})
+		}
+		handler(event)
+	}, errHandler)
+}
+
+// DepthUpdateFromWsDepth 把币安WebSocket推送的深度更新转换为model.DepthUpdate
+func DepthUpdateFromWsDepth(pair string, event *binance.WsDepthEvent) model.DepthUpdate {
+	return model.DepthUpdate{
+		Pair:          pair,
+		Time:          time.Unix(0, event.Time*int64(time.Millisecond)).UTC(),
+		FirstUpdateID: event.FirstUpdateID,
+		LastUpdateID:  event.LastUpdateID,
+		Bids:          priceLevels(event.Bids),
+		Asks:          priceLevels(event.Asks),
+	}
+}
+
+// priceLevels 把币安的字符串档位转换为model.PriceLevel
+func priceLevels(levels []common.PriceLevel) []model.PriceLevel {
+	result := make([]model.PriceLevel, 0, len(levels))
+	for _, level := range levels {
+		price, _ := strconv.ParseFloat(level.Price, 64)
+		quantity, _ := strconv.ParseFloat(level.Quantity, 64)
+		result = append(result, model.PriceLevel{Price: price, Quantity: quantity})
+	}
+	return result
+}
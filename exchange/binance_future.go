@@ -2,7 +2,14 @@ package exchange
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -25,6 +32,8 @@ var (
 	MarginTypeCrossed  MarginType = "CROSSED"  // 交叉全仓杠杆当你在某个交易中的资金快要亏完时，系统会自动使用你账户中的其他可用资金来补充保证金，以避免被强制平仓。
 
 	ErrNoNeedChangeMarginType int64 = -4046 // 不需要改变杠杆类型的错误码1.以是当前杠杆的情况下，2.没有持仓影响
+	ErrNoNeedChangePosition   int64 = -4059 // 不需要改变持仓模式的错误码，账户已经是要设置的模式
+	ErrOrderDoesNotExist      int64 = -2013 // 订单不存在的错误码，现货和合约相同
 )
 
 // PairOption 定义了交易对的配置选项
@@ -41,11 +50,15 @@ type BinanceFuture struct {
 	assetsInfo map[string]model.AssetInfo // 交易对的资产信息 键是交易对名称，值是交易的信息，如最小数量。
 	HeikinAshi bool                       // 是否使用Heikin Ashi蜡烛图
 	Testnet    bool                       // 是否使用测试网
+	HedgeMode  bool                       // 是否使用双向持仓模式，同一个交易对可以同时持有多头和空头
 
 	APIKey    string // API密钥（Key）可以被视为一个特殊的用户名或标识符，它唯一标识了API的调用者。
 	APISecret string //  API密钥的秘密（Secret）部分可以被视为密码。
+	APIURL    string // 自定义的REST接口地址，例如本地的交易所模拟器，为空时使用币安的地址。
+	WsURL     string // 自定义的WebSocket地址，为空时使用币安的地址。
 
 	MetadataFetchers []MetadataFetchers // 元数据获取器 这个方法或者工具被用来“抓取”或“获取”交易相关的额外信息，也就是元数据。元数据可以是任何有助于分析或决策的额外数据，比如交易对的历史表现、市场趋势、交易量分析等。
+	RateLimiter      *RateLimiter       // 控制REST请求的权重和下单次数，默认为NewBinanceFutureRateLimiter。
 	PairOptions      []PairOption       // 交易对选项PairOptions是一个切片装填着多个交易对的杠杆信息 里面有交易对 杠杆倍数 ，杠杆类型
 }
 
@@ -67,6 +80,14 @@ func WithBinanceFutureCredentials(key, secret string) BinanceFutureOption {
 	}
 }
 
+// WithBinanceFutureEndpoint 使用自定义的REST接口和WebSocket地址，例如本地的交易所模拟器，wsURL为空时WebSocket仍然连接币安。
+func WithBinanceFutureEndpoint(apiURL, wsURL string) BinanceFutureOption {
+	return func(b *BinanceFuture) {
+		b.APIURL = apiURL
+		b.WsURL = wsURL
+	}
+}
+
 // WithBinanceFutureLeverage 设置交易对的杠杆，leverage杠杆倍数，marginType杠杆类型
 func WithBinanceFutureLeverage(pair string, leverage int, marginType MarginType) BinanceFutureOption {
 	return func(b *BinanceFuture) {
@@ -78,6 +99,22 @@ func WithBinanceFutureLeverage(pair string, leverage int, marginType MarginType)
 	}
 }
 
+// WithBinanceFutureHedgeMode 使用双向持仓模式（Hedge Mode），创建时把账户切换为双向持仓。
+// 订单通过model.WithPositionSide指定LONG或SHORT，没有指定时买单开多、卖单开空；
+// 止损单和OCO订单用于平仓，没有指定时平掉与订单方向相反的持仓。
+func WithBinanceFutureHedgeMode() BinanceFutureOption {
+	return func(b *BinanceFuture) {
+		b.HedgeMode = true
+	}
+}
+
+// WithBinanceFutureRateLimiter 使用指定的RateLimiter，同一个IP的多个实例可以共享一个RateLimiter。
+func WithBinanceFutureRateLimiter(limiter *RateLimiter) BinanceFutureOption {
+	return func(b *BinanceFuture) {
+		b.RateLimiter = limiter
+	}
+}
+
 // 这段代码实现了一个用于初始化并配置一个与Binance Futures API交互的交易机器人的功能。这个过程不仅仅是获取交易所的资产信息，而是涵盖了多个步骤，用于创建、配置，并启动一个为期货交易设计的交易机器人的实例。
 // NewBinanceFuture 创建一个新的 BinanceFuture 实例。
 // options ...BinanceFutureOption: 表示这个函数可以接受零个或多个BinanceFutureOption类型的参数。
@@ -95,6 +132,15 @@ func NewBinanceFuture(ctx context.Context, options ...BinanceFutureOption) (*Bin
 
 	// exchange.client 是机器人的一部分，使用提供的 API 密钥和秘钥创建一个 futures.Client 实例，用于后续的 API 调用。这段代码的作用就是利用交易所提供的API密钥和秘钥，让交易机器人建立与交易所的连接。
 	exchange.client = futures.NewClient(exchange.APIKey, exchange.APISecret)
+	if exchange.APIURL != "" {
+		exchange.client.BaseURL = exchange.APIURL
+	}
+
+	// 所有REST请求经过RateLimiter，超过请求权重或者下单次数的限制时排队等待。
+	if exchange.RateLimiter == nil {
+		exchange.RateLimiter = NewBinanceFutureRateLimiter()
+	}
+	exchange.client.HTTPClient = exchange.RateLimiter.Client()
 
 	// NewPingService定于币安API客户端库的，向币安服务器发送 Ping 请求，检查与服务器的连接是否正常Do(ctx)，Do(ctx)相当一个控制器  在记录连接的同时 还可以下达命令 如取消操作，设置超时、截至时间等
 	err := exchange.client.NewPingService().Do(ctx)
@@ -108,6 +154,16 @@ func NewBinanceFuture(ctx context.Context, options ...BinanceFutureOption) (*Bin
 		return nil, err
 	}
 
+	// 双向持仓模式需要先切换账户的持仓模式，已经是双向持仓时交易所返回ErrNoNeedChangePosition
+	if exchange.HedgeMode {
+		err = exchange.client.NewChangePositionModeService().DualSide(true).Do(ctx)
+		if err != nil {
+			if apiError, ok := err.(*common.APIError); !ok || apiError.Code != ErrNoNeedChangePosition {
+				return nil, err
+			}
+		}
+	}
+
 	// 根据配置的交易对选项，设置每个交易对的杠杆和杠杆类型
 	// 遍历exchange.PairOptions 得到里面的 交易对 杠杆倍数 ，杠杆类型
 	for _, option := range exchange.PairOptions {
@@ -185,6 +241,11 @@ func (b *BinanceFuture) AssetsInfo(pair string) model.AssetInfo {
 	return b.assetsInfo[pair]
 }
 
+// PairsByQuote 返回合约市场报价资产为quote的所有交易对，按名称排序，交易对来自交易所当前的交易对信息。
+func (b *BinanceFuture) PairsByQuote(quote string) []string {
+	return pairsByQuote(b.assetsInfo, quote)
+}
+
 // 这个validate方法专门用于校验交易数量是否位于交易所规定特定交易对允许的最小和最大交易数量范围内。
 func (b *BinanceFuture) validate(pair string, quantity float64) error {
 	// 从 b.assetsInfo 中尝试获取指定交易对的资产信息。
@@ -209,19 +270,107 @@ func (b *BinanceFuture) validate(pair string, quantity float64) error {
 	return nil
 }
 
-// 一个止盈单（盈利单）和一个止损单（亏损单）。这两个订单被同时下达，但一旦其中一个条件被触发并且订单执行，另一个订单会自动被取消。
-// 使用panic("not implemented")和将所有参数替换为下划线 _ 明确地表明了这个功能还没有被开发或实现。
-func (b *BinanceFuture) CreateOrderOCO(_ model.SideType, _ string,
-	_, _, _, _ float64) ([]model.Order, error) {
-	// 方法直接触发一个panic，说明这个功能没有被实现。
-	panic("not implemented")
+// CreateOrderOCO 创建一个止盈单（TAKE_PROFIT_MARKET，触发价price）和一个止损单（STOP_MARKET，触发价stop），用于平掉已有的仓位。
+// 币安合约没有原生的OCO订单，两个订单都是reduceOnly，只会减少仓位；quantity为0时使用closePosition，触发时平掉整个仓位。
+// 两个订单使用止盈单的订单ID作为GroupID，一个成交后由Controller撤销另一个（见EmulatesOCO）。
+// 触发后以市价成交，所以stopLimit没有使用。止损单下单失败时撤销已经下的止盈单。
+func (b *BinanceFuture) CreateOrderOCO(side model.SideType, pair string,
+	quantity, price, stop, _ float64, options ...model.OrderOption) ([]model.Order, error) {
+	if quantity != 0 {
+		if err := b.validate(pair, quantity); err != nil {
+			return nil, err
+		}
+	}
+
+	params := model.NewOrderParams(options...)
+	takeProfit, err := b.createTriggerOrder(futures.OrderTypeTakeProfitMarket, side, pair, quantity, price,
+		params, params.ClientOrderID)
+	if err != nil {
+		return nil, err
+	}
+
+	stopClientOrderID := ""
+	if params.ClientOrderID != "" {
+		stopClientOrderID = params.ClientOrderID + "-stop"
+	}
+	stopLoss, err := b.createTriggerOrder(futures.OrderTypeStopMarket, side, pair, quantity, stop,
+		params, stopClientOrderID)
+	if err != nil {
+		// 止损单失败时撤销止盈单，避免留下没有止损保护的单边订单
+		if cancelErr := b.Cancel(takeProfit); cancelErr != nil {
+			log.Errorf("binance future: cancel take profit %d: %v", takeProfit.ExchangeID, cancelErr)
+		}
+		return nil, err
+	}
+
+	groupID := takeProfit.ExchangeID
+	takeProfit.GroupID = &groupID
+	stopLoss.GroupID = &groupID
+	return []model.Order{takeProfit, stopLoss}, nil
+}
+
+// EmulatesOCO 币安合约的OCO由两个独立的订单模拟，交易所不会自动撤销另一个订单。
+func (b *BinanceFuture) EmulatesOCO() bool {
+	return true
+}
+
+// createTriggerOrder 创建一个触发后以市价成交的reduceOnly订单，quantity为0时使用closePosition平掉整个仓位。
+func (b *BinanceFuture) createTriggerOrder(orderType futures.OrderType, side model.SideType, pair string,
+	quantity, stop float64, params model.OrderParams, clientOrderID string) (model.Order, error) {
+	service := b.client.NewCreateOrderService().
+		Symbol(pair).
+		Type(orderType).
+		Side(futures.SideType(side)).
+		StopPrice(b.formatPrice(pair, stop)).
+		WorkingType(futures.WorkingTypeMarkPrice) // 按标记价格触发，避免被成交价的瞬间波动触发
+	positionSide := b.positionSide(side, params, true)
+	if positionSide != futures.PositionSideTypeBoth {
+		service.PositionSide(positionSide)
+	}
+	if quantity == 0 {
+		service.ClosePosition(true)
+	} else {
+		service.Quantity(b.formatQuantity(pair, quantity))
+		// 双向持仓模式下平仓由持仓方向决定，交易所不接受reduceOnly参数
+		if positionSide == futures.PositionSideTypeBoth {
+			service.ReduceOnly(true)
+		}
+	}
+	if clientOrderID != "" {
+		service.NewClientOrderID(clientOrderID)
+	}
+
+	order, err := service.Do(b.ctx)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	quantity, _ = strconv.ParseFloat(order.OrigQuantity, 64)
+	result := model.Order{
+		ExchangeID:    order.OrderID,
+		CreatedAt:     time.Unix(0, order.UpdateTime*int64(time.Millisecond)),
+		UpdatedAt:     time.Unix(0, order.UpdateTime*int64(time.Millisecond)),
+		Pair:          pair,
+		Side:          model.SideType(order.Side),
+		Type:          model.OrderType(order.Type),
+		Status:        model.OrderStatusType(order.Status),
+		Price:         stop,
+		Quantity:      quantity,
+		Stop:          &stop,
+		ClientOrderID: order.ClientOrderID,
+		TimeInForce:   model.TimeInForceType(order.TimeInForce),
+		PositionSide:  futurePositionSide(order.PositionSide),
+	}
+	params.Apply(&result)
+	return result, nil
 }
 
 // CreateOrderStop创建一个止损订单。
 // pair指定了交易对，比如"BTCUSDT"。
 // quantity是想要交易的数量。
 // limit是止损价格。
-func (b *BinanceFuture) CreateOrderStop(pair string, quantity float64, limit float64) (model.Order, error) {
+func (b *BinanceFuture) CreateOrderStop(pair string, quantity float64, limit float64,
+	options ...model.OrderOption) (model.Order, error) {
 	// 首先，使用validate方法校验提供的交易对和数量是否有效。
 	err := b.validate(pair, quantity)
 	if err != nil {
@@ -230,35 +379,90 @@ func (b *BinanceFuture) CreateOrderStop(pair string, quantity float64, limit flo
 	}
 
 	// 使用客户端的NewCreateOrderService方法准备创建新的订单。
-	order, err := b.client.NewCreateOrderService().
+	params := model.NewOrderParams(options...)
+	service := b.client.NewCreateOrderService().
 		Symbol(pair).                               // 设置订单的交易对。
 		Type(futures.OrderTypeStopMarket).          // 设置订单类型为止损市价单。
-		TimeInForce(futures.TimeInForceTypeGTC).    //根据市场的订单类型（如限价订单、市价订单）直到被成交，或者被用户手动取消。
+		TimeInForce(futuresTimeInForce(params)).    //根据市场的订单类型（如限价订单、市价订单）直到被成交，或者被用户手动取消。
 		Side(futures.SideTypeSell).                 // 设置订单方向为卖出。
 		Quantity(b.formatQuantity(pair, quantity)). // 设置订单数量，使用formatQuantity方法格式化。
-		Price(b.formatPrice(pair, limit)).          // 格式化价格的目的是确保价格符合交易所的要求，并且符合交易对的价格精度规则。需要确保价格的精度符合交易所的规定，否则拒绝执行
-		Do(b.ctx)                                   // 调用 Do(b.ctx) 方法并传入上下文会触发客户端向交易所发送订单请求。
+		StopPrice(b.formatPrice(pair, limit))       // 止损市价单使用触发价，格式化价格的目的是确保价格符合交易对的价格精度规则。
+	if positionSide := b.positionSide(model.SideTypeSell, params, true); positionSide != futures.PositionSideTypeBoth {
+		service.PositionSide(positionSide) // 双向持仓模式下平掉多头持仓。
+	} else {
+		service.ReduceOnly(true) // 止损单只减少仓位，不会反向开仓。
+	}
+	if params.ClientOrderID != "" {
+		service.NewClientOrderID(params.ClientOrderID) // 设置客户端订单ID。
+	}
+
+	order, err := service.Do(b.ctx) // 调用 Do(b.ctx) 方法并传入上下文会触发客户端向交易所发送订单请求。
 	if err != nil {
 		// 如果创建订单请求失败，则返回空的Order对象和错误信息。
 		return model.Order{}, err
 	}
 
-	// 解析订单价格和原始数量的值。
-	price, _ := strconv.ParseFloat(order.Price, 64)
+	// 解析订单原始数量的值，止损市价单没有委托价格，价格使用触发价。
 	quantity, _ = strconv.ParseFloat(order.OrigQuantity, 64)
 
 	// 构造并返回一个Order对象，包含订单的详细信息。
-	return model.Order{
-		ExchangeID: order.OrderID,                                          // 订单在交易所的ID。
-		CreatedAt:  time.Unix(0, order.UpdateTime*int64(time.Millisecond)), // 订单创建时间。将订单的最后更新时间转换为毫秒级别，然后符合国际标准的时间格式。
-		UpdatedAt:  time.Unix(0, order.UpdateTime*int64(time.Millisecond)), // 订单最后更新时间。
-		Pair:       pair,                                                   // 交易对。
-		Side:       model.SideType(order.Side),                             // 订单方向（买/卖）。
-		Type:       model.OrderType(order.Type),                            // 订单类型。
-		Status:     model.OrderStatusType(order.Status),                    // 订单状态。
-		Price:      price,                                                  // 订单价格。
-		Quantity:   quantity,                                               // 订单数量。
-	}, nil
+	result := model.Order{
+		ExchangeID:    order.OrderID,                                          // 订单在交易所的ID。
+		CreatedAt:     time.Unix(0, order.UpdateTime*int64(time.Millisecond)), // 订单创建时间。将订单的最后更新时间转换为毫秒级别，然后符合国际标准的时间格式。
+		UpdatedAt:     time.Unix(0, order.UpdateTime*int64(time.Millisecond)), // 订单最后更新时间。
+		Pair:          pair,                                                   // 交易对。
+		Side:          model.SideType(order.Side),                             // 订单方向（买/卖）。
+		Type:          model.OrderType(order.Type),                            // 订单类型。
+		Status:        model.OrderStatusType(order.Status),                    // 订单状态。
+		Price:         limit,                                                  // 订单价格，即触发价。
+		Quantity:      quantity,                                               // 订单数量。
+		Stop:          &limit,                                                 // 止损触发价。
+		ClientOrderID: order.ClientOrderID,                                    // 客户端订单ID。
+		TimeInForce:   model.TimeInForceType(order.TimeInForce),               // 订单有效方式。
+		PositionSide:  futurePositionSide(order.PositionSide),                 // 双向持仓模式下的持仓方向。
+	}
+	params.Apply(&result)
+	return result, nil
+}
+
+// positionSide 返回订单在交易所的持仓方向。单向持仓模式下总是BOTH；双向持仓模式下使用订单参数中的方向，
+// 没有指定时开仓单（closing为false）买单开多、卖单开空，平仓单（止损、OCO）平掉与订单方向相反的持仓。
+func (b *BinanceFuture) positionSide(side model.SideType, params model.OrderParams, closing bool) futures.PositionSideType {
+	if !b.HedgeMode {
+		return futures.PositionSideTypeBoth
+	}
+	if params.PositionSide != "" {
+		return futures.PositionSideType(params.PositionSide)
+	}
+
+	long := side == model.SideTypeBuy
+	if closing {
+		long = !long
+	}
+	if long {
+		return futures.PositionSideTypeLong
+	}
+	return futures.PositionSideTypeShort
+}
+
+// futurePositionSide 将交易所的持仓方向转换为订单的持仓方向，单向持仓（BOTH）为空。
+func futurePositionSide(side futures.PositionSideType) model.PositionSideType {
+	switch side {
+	case futures.PositionSideTypeLong:
+		return model.PositionSideTypeLong
+	case futures.PositionSideTypeShort:
+		return model.PositionSideTypeShort
+	}
+	return ""
+}
+
+// futuresTimeInForce 将订单参数中的有效方式转换为币安期货的类型，未指定时使用GTC。
+// 期货原生支持GTX（只做挂单）。
+func futuresTimeInForce(params model.OrderParams) futures.TimeInForceType {
+	if params.TimeInForce == "" {
+		return futures.TimeInForceTypeGTC
+	}
+	return futures.TimeInForceType(params.TimeInForce)
 }
 
 // formatPrice 就是保证价格变动能在交易所的指定的值内 比如上涨0.01 就必须保证变动是这个
@@ -290,7 +494,7 @@ func (b *BinanceFuture) formatQuantity(pair string, value float64) string {
 // CreateOrderLimit 方法用于在币安期货交易所创建限价订单。限价订单 投资者设置一个合理的价格买卖 如btc60000 觉得太高了 设置4000再买
 // 它接收订单的方向（买入或卖出）、交易对名称、交易数量和限价价格作为输入参数，并返回创建的订单信息或者错误。
 func (b *BinanceFuture) CreateOrderLimit(side model.SideType, pair string,
-	quantity float64, limit float64) (model.Order, error) {
+	quantity float64, limit float64, options ...model.OrderOption) (model.Order, error) {
 	// 首先，使用 validate 方法校验提供的交易对和数量是否有效。
 	err := b.validate(pair, quantity)
 	if err != nil {
@@ -299,14 +503,22 @@ func (b *BinanceFuture) CreateOrderLimit(side model.SideType, pair string,
 	}
 
 	// 使用客户端的 NewCreateOrderService 方法准备创建新的订单。
-	order, err := b.client.NewCreateOrderService().
+	params := model.NewOrderParams(options...)
+	service := b.client.NewCreateOrderService().
 		Symbol(pair).                               // 设置订单的交易对。
 		Type(futures.OrderTypeLimit).               // 设置订单类型为限价单。
-		TimeInForce(futures.TimeInForceTypeGTC).    // 设置订单有效期为直到取消（Good Till Cancel）。
+		TimeInForce(futuresTimeInForce(params)).    // 设置订单有效方式，默认为直到取消（Good Till Cancel）。
 		Side(futures.SideType(side)).               // 设置订单方向为买入或卖出，根据参数 side 确定。
 		Quantity(b.formatQuantity(pair, quantity)). // 设置订单数量，使用 formatQuantity 方法格式化。
-		Price(b.formatPrice(pair, limit)).          // 设置订单价格，使用 formatPrice 方法格式化。
-		Do(b.ctx)                                   // 调用 Do 方法并传入上下文，触发客户端向交易所发送订单请求。
+		Price(b.formatPrice(pair, limit))           // 设置订单价格，使用 formatPrice 方法格式化。
+	if positionSide := b.positionSide(side, params, false); positionSide != futures.PositionSideTypeBoth {
+		service.PositionSide(positionSide) // 双向持仓模式下设置持仓方向。
+	}
+	if params.ClientOrderID != "" {
+		service.NewClientOrderID(params.ClientOrderID) // 设置客户端订单ID。
+	}
+
+	order, err := service.Do(b.ctx) // 调用 Do 方法并传入上下文，触发客户端向交易所发送订单请求。
 	if err != nil {
 		// 如果创建订单请求失败，则返回空的 Order 对象和错误信息。
 		return model.Order{}, err
@@ -324,34 +536,50 @@ func (b *BinanceFuture) CreateOrderLimit(side model.SideType, pair string,
 	}
 
 	// 构造并返回一个 Order 对象，包含订单的详细信息。
-	return model.Order{
-		ExchangeID: order.OrderID,                                          // 订单在交易所的 ID。
-		CreatedAt:  time.Unix(0, order.UpdateTime*int64(time.Millisecond)), // 订单创建时间，转换为毫秒级别，符合国际标准的时间格式。
-		UpdatedAt:  time.Unix(0, order.UpdateTime*int64(time.Millisecond)), // 订单最后更新时间。
-		Pair:       pair,                                                   // 交易对。
-		Side:       model.SideType(order.Side),                             // 订单方向（买/卖）。
-		Type:       model.OrderType(order.Type),                            // 订单类型。
-		Status:     model.OrderStatusType(order.Status),                    // 订单状态。
-		Price:      price,                                                  // 订单价格。
-		Quantity:   quantity,                                               // 订单数量。
-	}, nil
+	result := model.Order{
+		ExchangeID:    order.OrderID,                                          // 订单在交易所的 ID。
+		CreatedAt:     time.Unix(0, order.UpdateTime*int64(time.Millisecond)), // 订单创建时间，转换为毫秒级别，符合国际标准的时间格式。
+		UpdatedAt:     time.Unix(0, order.UpdateTime*int64(time.Millisecond)), // 订单最后更新时间。
+		Pair:          pair,                                                   // 交易对。
+		Side:          model.SideType(order.Side),                             // 订单方向（买/卖）。
+		Type:          model.OrderType(order.Type),                            // 订单类型。
+		Status:        model.OrderStatusType(order.Status),                    // 订单状态。
+		Price:         price,                                                  // 订单价格。
+		Quantity:      quantity,                                               // 订单数量。
+		ClientOrderID: order.ClientOrderID,                                    // 客户端订单ID。
+		TimeInForce:   model.TimeInForceType(order.TimeInForce),               // 订单有效方式。
+		PositionSide:  futurePositionSide(order.PositionSide),                 // 双向持仓模式下的持仓方向。
+	}
+
+	// 过期时间记录在订单上，由Controller到期后撤单
+	params.Apply(&result)
+	return result, nil
 }
 
 // CreateOrderMarket 方法用于在币安期货交易所创建市价订单。市价订单是指以当前市场价格立即执行的订单，不限制价格。
 // 这种类型的订单允许交易者立即买入或卖出资产，而无需等待价格达到特定水平。
-func (b *BinanceFuture) CreateOrderMarket(side model.SideType, pair string, quantity float64) (model.Order, error) {
+func (b *BinanceFuture) CreateOrderMarket(side model.SideType, pair string, quantity float64,
+	options ...model.OrderOption) (model.Order, error) {
 	err := b.validate(pair, quantity)
 	if err != nil {
 		return model.Order{}, err
 	}
 
-	order, err := b.client.NewCreateOrderService().
+	params := model.NewOrderParams(options...)
+	service := b.client.NewCreateOrderService().
 		Symbol(pair).
 		Type(futures.OrderTypeMarket).
 		Side(futures.SideType(side)).
 		Quantity(b.formatQuantity(pair, quantity)).
-		NewOrderResponseType(futures.NewOrderRespTypeRESULT). // 置订单响应类型为 RESULT 表示订单执行后立即返回执行结果，而不会等待其他条件的满足，如止损、止盈或限制条件
-		Do(b.ctx)
+		NewOrderResponseType(futures.NewOrderRespTypeRESULT) // 置订单响应类型为 RESULT 表示订单执行后立即返回执行结果，而不会等待其他条件的满足，如止损、止盈或限制条件
+	if positionSide := b.positionSide(side, params, false); positionSide != futures.PositionSideTypeBoth {
+		service.PositionSide(positionSide) // 双向持仓模式下设置持仓方向。
+	}
+	if params.ClientOrderID != "" {
+		service.NewClientOrderID(params.ClientOrderID) // 设置客户端订单ID。
+	}
+
+	order, err := service.Do(b.ctx)
 	if err != nil {
 		return model.Order{}, err
 	}
@@ -368,23 +596,118 @@ func (b *BinanceFuture) CreateOrderMarket(side model.SideType, pair string, quan
 
 	// 返回一个包含订单详细信息的model.Order结构体。
 	return model.Order{
-		ExchangeID: order.OrderID,                                          // 订单在交易所的唯一标识符。
-		CreatedAt:  time.Unix(0, order.UpdateTime*int64(time.Millisecond)), // 订单的创建时间，转换为毫秒级别的UNIX时间戳。
-		UpdatedAt:  time.Unix(0, order.UpdateTime*int64(time.Millisecond)), // 订单的最后更新时间，转换为毫秒级别的UNIX时间戳。
-		Pair:       order.Symbol,                                           // order.Symbol 是订单的交易对标识符，表示交易对的基础货币和报价货币的组合
-		Side:       model.SideType(order.Side),                             // 订单的交易方向（买入/卖出）。
-		Type:       model.OrderType(order.Type),                            // 订单类型（市价/限价/止损/止盈等）。
-		Status:     model.OrderStatusType(order.Status),                    // 订单的状态（已成交/未成交/部分成交等）。
-		Price:      cost / quantity,                                        // 订单的成交均价，即成交总金额除以成交数量。
-		Quantity:   quantity,                                               // 订单的数量。
+		ExchangeID:    order.OrderID,                                          // 订单在交易所的唯一标识符。
+		CreatedAt:     time.Unix(0, order.UpdateTime*int64(time.Millisecond)), // 订单的创建时间，转换为毫秒级别的UNIX时间戳。
+		UpdatedAt:     time.Unix(0, order.UpdateTime*int64(time.Millisecond)), // 订单的最后更新时间，转换为毫秒级别的UNIX时间戳。
+		Pair:          order.Symbol,                                           // order.Symbol 是订单的交易对标识符，表示交易对的基础货币和报价货币的组合
+		Side:          model.SideType(order.Side),                             // 订单的交易方向（买入/卖出）。
+		Type:          model.OrderType(order.Type),                            // 订单类型（市价/限价/止损/止盈等）。
+		Status:        model.OrderStatusType(order.Status),                    // 订单的状态（已成交/未成交/部分成交等）。
+		Price:         cost / quantity,                                        // 订单的成交均价，即成交总金额除以成交数量。
+		Quantity:      quantity,                                               // 订单的数量。
+		ClientOrderID: order.ClientOrderID,                                    // 客户端订单ID。
+		PositionSide:  futurePositionSide(order.PositionSide),                 // 双向持仓模式下的持仓方向。
 	}, nil
 
 }
 
-// 假设投资者想要以市场最优价格购买比特币，但不愿意设置一个具体的购买价格。他可以使用市价报价订单来执行这个交易。投资者只需指定购买的数量，交易所将会以当前市场上最佳的价格立即执行这个订单。
-func (b *BinanceFuture) CreateOrderMarketQuote(_ model.SideType, _ string, _ float64) (model.Order, error) {
-	// 该功能没有实现
-	panic("not implemented")
+// CreateOrderMarketQuote 按计价资产的金额创建市价单，例如用100 USDT买入BTC。币安合约不支持按金额下单，
+// 数量按标记价格换算，并按交易对的步长向下取整，所以实际成交金额会略少于quote。
+func (b *BinanceFuture) CreateOrderMarketQuote(side model.SideType, pair string, quote float64,
+	options ...model.OrderOption) (model.Order, error) {
+	markPrice, err := b.markPrice(pair)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	quantity, _ := strconv.ParseFloat(b.formatQuantity(pair, quote/markPrice), 64)
+	return b.CreateOrderMarket(side, pair, quantity, options...)
+}
+
+// markPrice 返回交易对的标记价格。
+func (b *BinanceFuture) markPrice(pair string) (float64, error) {
+	indexes, err := b.client.NewPremiumIndexService().Symbol(pair).Do(b.ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, index := range indexes {
+		if index.Symbol == pair {
+			price, err := strconv.ParseFloat(index.MarkPrice, 64)
+			if err != nil {
+				return 0, err
+			}
+			if price <= 0 {
+				break
+			}
+			return price, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: no mark price for %s", ErrInvalidAsset, pair)
+}
+
+// ReplaceOrder 修改挂单的价格和数量。尚未成交的限价单通过改单接口（PUT /fapi/v1/order）原子修改，
+// 返回的订单ExchangeID与原订单相同；部分成交的订单改单时数量包含已成交的部分，与其他交易所"新订单数量"的语义不同，
+// 因此仍然通过撤单后重新下单实现，新订单沿用原订单的有效方式和过期时间，并通过ReplacedID关联原订单。
+// 撤单成功到新订单创建之间两个订单都不在交易所挂单，行情剧烈波动时可能错过成交。
+func (b *BinanceFuture) ReplaceOrder(order model.Order, price, quantity float64) (model.Order, error) {
+	// 撤单前先校验新数量，避免原订单被撤掉后新订单因为数量不合法下单失败
+	if err := b.validate(order.Pair, quantity); err != nil {
+		return model.Order{}, err
+	}
+	if order.IsReplaceable() && order.Status == model.OrderStatusTypeNew {
+		return b.modifyOrder(order, price, quantity)
+	}
+	return cancelReplace(b, order, price, quantity)
+}
+
+// modifyOrder 调用改单接口修改订单的价格和数量。go-binance没有提供改单服务，这里直接发送签名请求，
+// 请求同样经过RateLimiter。改单失败时原订单保持不变。
+func (b *BinanceFuture) modifyOrder(order model.Order, price, quantity float64) (model.Order, error) {
+	params := url.Values{}
+	params.Set("symbol", order.Pair)
+	params.Set("orderId", strconv.FormatInt(order.ExchangeID, 10))
+	params.Set("side", string(order.Side))
+	params.Set("quantity", b.formatQuantity(order.Pair, quantity))
+	params.Set("price", b.formatPrice(order.Pair, price))
+	params.Set("timestamp", strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond)-b.client.TimeOffset, 10))
+
+	mac := hmac.New(sha256.New, []byte(b.client.SecretKey))
+	mac.Write([]byte(params.Encode()))
+	query := params.Encode() + "&signature=" + hex.EncodeToString(mac.Sum(nil))
+
+	request, err := http.NewRequestWithContext(b.ctx, http.MethodPut, b.client.BaseURL+"/fapi/v1/order?"+query, nil)
+	if err != nil {
+		return model.Order{}, err
+	}
+	request.Header.Set("X-MBX-APIKEY", b.client.APIKey)
+
+	response, err := b.client.HTTPClient.Do(request)
+	if err != nil {
+		return model.Order{}, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return model.Order{}, err
+	}
+	if response.StatusCode >= http.StatusBadRequest {
+		apiError := new(common.APIError)
+		if err := json.Unmarshal(body, apiError); err != nil {
+			return model.Order{}, fmt.Errorf("binance modify order: status %d: %s", response.StatusCode, body)
+		}
+		return model.Order{}, binanceOrderError(apiError)
+	}
+
+	var result futures.Order
+	if err := json.Unmarshal(body, &result); err != nil {
+		return model.Order{}, err
+	}
+
+	// 过期时间只记录在本地订单上
+	modified := newFutureOrder(&result)
+	modified.ExpireAt = order.ExpireAt
+	return modified, nil
 }
 
 // 用于通过Binance Futures的API客户端向交易所发送取消订单的请求
@@ -426,7 +749,7 @@ func (b *BinanceFuture) Order(pair string, id int64) (model.Order, error) {
 		Do(b.ctx)
 
 	if err != nil {
-		return model.Order{}, err
+		return model.Order{}, binanceOrderError(err)
 	}
 
 	return newFutureOrder(order), nil
@@ -457,17 +780,30 @@ func newFutureOrder(order *futures.Order) model.Order {
 		log.CheckErr(log.WarnLevel, err) // 检查错误并记录警告级别日志。
 	}
 
+	// 止损、止盈市价单没有委托价格，使用触发价。
+	var stop *float64
+	if stopPrice, _ := strconv.ParseFloat(order.StopPrice, 64); stopPrice > 0 {
+		stop = &stopPrice
+		if price <= 0 {
+			price = stopPrice
+		}
+	}
+
 	// 构造并返回一个通用订单模型。
 	return model.Order{
-		ExchangeID: order.OrderID,                                          // 订单在交易所的唯一标识符。
-		Pair:       order.Symbol,                                           // 订单所属的交易对。
-		CreatedAt:  time.Unix(0, order.Time*int64(time.Millisecond)),       // 订单创建时间。
-		UpdatedAt:  time.Unix(0, order.UpdateTime*int64(time.Millisecond)), // 订单最后更新时间。
-		Side:       model.SideType(order.Side),                             // 订单方向（买/卖）。
-		Type:       model.OrderType(order.Type),                            // 订单类型。
-		Status:     model.OrderStatusType(order.Status),                    // 订单状态。
-		Price:      price,                                                  // 订单价格。
-		Quantity:   quantity,                                               // 订单数量。
+		ExchangeID:    order.OrderID,                                          // 订单在交易所的唯一标识符。
+		Pair:          order.Symbol,                                           // 订单所属的交易对。
+		CreatedAt:     time.Unix(0, order.Time*int64(time.Millisecond)),       // 订单创建时间。
+		UpdatedAt:     time.Unix(0, order.UpdateTime*int64(time.Millisecond)), // 订单最后更新时间。
+		Side:          model.SideType(order.Side),                             // 订单方向（买/卖）。
+		Type:          model.OrderType(order.Type),                            // 订单类型。
+		Status:        model.OrderStatusType(order.Status),                    // 订单状态。
+		Price:         price,                                                  // 订单价格。
+		Quantity:      quantity,                                               // 订单数量。
+		Stop:          stop,                                                   // 止损、止盈单的触发价。
+		ClientOrderID: order.ClientOrderID,                                    // 客户端订单ID。
+		TimeInForce:   model.TimeInForceType(order.TimeInForce),               // 订单有效方式。
+		PositionSide:  futurePositionSide(order.PositionSide),                 // 双向持仓模式下的持仓方向。
 	}
 }
 
@@ -567,6 +903,75 @@ func (b *BinanceFuture) Position(pair string) (asset, quote float64, err error)
 	return assetBalance.Free + assetBalance.Lock, quoteBalance.Free + quoteBalance.Lock, nil
 }
 
+// PositionInfo 通过持仓风险接口（/fapi/v2/positionRisk）查询交易对的持仓详情，包括开仓均价、标记价格、
+// 未实现盈亏、杠杆、保证金和强平价。单向持仓模式下只有一条记录，数量带符号；没有持仓时返回的Side为空。
+func (b *BinanceFuture) PositionInfo(pair string) (model.PositionInfo, error) {
+	risks, err := b.client.NewGetPositionRiskService().Symbol(pair).Do(b.ctx)
+	if err != nil {
+		return model.PositionInfo{}, err
+	}
+
+	for _, risk := range risks {
+		position, err := newFuturePosition(risk)
+		if err != nil {
+			return model.PositionInfo{}, err
+		}
+		if position.Open() {
+			return position, nil
+		}
+	}
+
+	position := model.NewPositionInfo(pair, 0)
+	if len(risks) > 0 {
+		position.Leverage, _ = strconv.ParseFloat(risks[0].Leverage, 64)
+		position.Isolated = risks[0].MarginType == "isolated"
+	}
+	position.UpdatedAt = time.Now()
+	return position, nil
+}
+
+// newFuturePosition 将币安的持仓风险记录转换为持仓详情。逐仓的保证金是逐仓钱包余额，
+// 全仓的保证金按名义价值除以杠杆估算。
+func newFuturePosition(risk *futures.PositionRisk) (model.PositionInfo, error) {
+	values := make(map[string]float64, 7)
+	for name, value := range map[string]string{
+		"positionAmt":      risk.PositionAmt,
+		"entryPrice":       risk.EntryPrice,
+		"markPrice":        risk.MarkPrice,
+		"unRealizedProfit": risk.UnRealizedProfit,
+		"leverage":         risk.Leverage,
+		"liquidationPrice": risk.LiquidationPrice,
+		"isolatedMargin":   risk.IsolatedMargin,
+	} {
+		if value == "" {
+			continue
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return model.PositionInfo{}, fmt.Errorf("position %s: invalid %s: %w", risk.Symbol, name, err)
+		}
+		values[name] = number
+	}
+
+	position := model.NewPositionInfo(risk.Symbol, values["positionAmt"])
+	position.EntryPrice = values["entryPrice"]
+	position.MarkPrice = values["markPrice"]
+	position.UnrealizedPnL = values["unRealizedProfit"]
+	position.LiquidationPrice = values["liquidationPrice"]
+	position.Isolated = risk.MarginType == "isolated"
+	if values["leverage"] > 0 {
+		position.Leverage = values["leverage"]
+	}
+
+	if position.Isolated {
+		position.Margin = values["isolatedMargin"]
+	} else {
+		position.Margin = position.Notional() / position.Leverage
+	}
+	position.UpdatedAt = time.Now()
+	return position, nil
+}
+
 // CandlesSubscription 方法用于订阅特定交易对和周期的K线数据，并返回两个通道：一个用于接收K线数据，另一个用于接收错误信息。通过使用协程来处理futures.WsKlineServe函数的调用，你的程序可以在后台实时接收和处理K线数据，而不会干扰到主程序的其他操作。这种方式允许你的订阅实时更新，确保用户能够看到最新的数据。
 func (b *BinanceFuture) CandlesSubscription(ctx context.Context, pair, period string) (chan model.Candle, chan error) {
 	// 创建用于接收K线数据的通道 ccandle 和用于接收错误信息的通道 cerr。
@@ -586,7 +991,7 @@ func (b *BinanceFuture) CandlesSubscription(ctx context.Context, pair, period st
 		for {
 			// futures.WsKlineServe函数通常是用来建立一个单向的数据流从Binance Futures交易所到机器人的WebSocket连接。，并在收到数据时将其发送到 ccandle 通道中。
 			// 第三个参数是一个匿名函数，也称为回调函数。这个函数在每次有新的K线数据到来时被调用。函数的参数event是一个*futures.WsKlineEvent类型的指针，包含了最新的K线数据。
-			done, _, err := futures.WsKlineServe(pair, period, func(event *futures.WsKlineEvent) {
+			done, stop, err := b.wsKlineServe(pair, period, func(event *futures.WsKlineEvent) {
 				//这行代码调用了之前定义的指数退避器对象ba的Reset方法，用于重置退避计时。 每次成功接收数据后重置，以便下次重试时从最小等待时间开始。
 				ba.Reset()
 				// 将 WebSocket 接收到的原始K线数据转换为期货蜡烛图模型。
@@ -608,10 +1013,16 @@ func (b *BinanceFuture) CandlesSubscription(ctx context.Context, pair, period st
 				}
 
 				// 将处理后的蜡烛图数据发送到 ccandle 通道中。
-				ccandle <- candle
+				select {
+				case ccandle <- candle:
+				case <-ctx.Done():
+				}
 				// 第二个匿名函数如果在WebSocket订阅的过程中遇到错误，这个函数将错误发送到cerr通道中。
 			}, func(err error) {
-				cerr <- err
+				select {
+				case cerr <- err:
+				case <-ctx.Done():
+				}
 			})
 
 			// futures.WsKlineServe函数尝试建立WebSocket连接并开始订阅时可能立即发生的错误。
@@ -627,6 +1038,9 @@ func (b *BinanceFuture) CandlesSubscription(ctx context.Context, pair, period st
 			// 监听上下文的取消信号，这行代码监听ctx.Done()返回的通道。如果这个通道关闭了（意味着上下文被取消了）关闭cerr和ccandle两个通道，分别用于传递错误信息和蜡烛图数据。
 			// ctx.Done()用于监听上下文（context）的取消信号。当你创建一个上下文对象时，你可以控制它，比如设置一个超时或手动取消。一旦上下文被取消（无论是因为超时、手动取消，还是其他原因），ctx.Done()返回的通道就会被关闭。
 			case <-ctx.Done():
+				// 先断开连接并等待连接协程退出，避免向已经关闭的通道发送
+				close(stop)
+				<-done
 				close(cerr)
 				close(ccandle)
 				return
@@ -642,6 +1056,24 @@ func (b *BinanceFuture) CandlesSubscription(ctx context.Context, pair, period st
 	return ccandle, cerr
 }
 
+// wsKlineServe 订阅合约K线推送，配置了自定义WebSocket地址时连接该地址，否则使用go-binance连接币安。
+func (b *BinanceFuture) wsKlineServe(pair, period string, handler futures.WsKlineHandler,
+	errHandler futures.ErrHandler) (doneC, stopC chan struct{}, err error) {
+	if b.WsURL == "" {
+		return futures.WsKlineServe(pair, period, handler, errHandler)
+	}
+
+	endpoint := fmt.Sprintf("%s/%s@kline_%s", b.WsURL, strings.ToLower(pair), period)
+	return wsServe(endpoint, func(message []byte) {
+		event := new(futures.WsKlineEvent)
+		if err := json.Unmarshal(message, event); err != nil {
+			errHandler(err)
+			return
+		}
+		handler(event)
+	}, errHandler)
+}
+
 // CandlesByLimit 获取指定交易对的最近一段时间内的K线数据，并限制返回的K线数量。
 // 它接受一个上下文、交易对、时间周期和限制数量作为参数，返回K线数据切片和可能的错误。
 func (b *BinanceFuture) CandlesByLimit(ctx context.Context, pair, period string, limit int) ([]model.Candle, error) {
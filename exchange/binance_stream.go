@@ -0,0 +1,237 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/jpillora/backoff"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/tools/log"
+)
+
+/*
+币安通过用户数据流（User Data Stream）推送订单的执行回报：先用REST接口申请listenKey，再用listenKey建立WebSocket连接，
+listenKey需要每隔一段时间续期，否则60分钟后失效。订单状态变化时交易所立即推送，Controller不需要每秒轮询每个挂单。
+*/
+
+// userStreamKeepalive 是listenKey的续期间隔，币安建议每30分钟续期一次。
+const userStreamKeepalive = 30 * time.Minute
+
+// userStream 描述一个币安用户数据流，现货和合约只是接口不同，连接、续期和重连的流程相同。
+type userStream struct {
+	start     func(ctx context.Context) (string, error)   // 申请listenKey
+	keepalive func(ctx context.Context, key string) error // 续期listenKey
+	// serve 建立WebSocket连接，收到订单回报时调用send
+	serve func(key string, send func(model.Order), errHandler func(error)) (doneC, stopC chan struct{}, err error)
+}
+
+// subscribe 订阅用户数据流，连接断开后按指数退避重连，重新申请listenKey。
+// 申请listenKey失败时发送错误并关闭通道，调用方可以回退到轮询。
+func (s userStream) subscribe(ctx context.Context) (chan model.Order, chan error) {
+	corder := make(chan model.Order)
+	cerr := make(chan error)
+
+	go func() {
+		defer close(cerr)
+		defer close(corder)
+
+		ba := &backoff.Backoff{
+			Min: 100 * time.Millisecond,
+			Max: 5 * time.Second,
+		}
+
+		// send 在ctx取消后不再阻塞，避免关闭通道后继续发送
+		send := func(order model.Order) {
+			ba.Reset()
+			select {
+			case corder <- order:
+			case <-ctx.Done():
+			}
+		}
+		errHandler := func(err error) {
+			select {
+			case cerr <- err:
+			case <-ctx.Done():
+			}
+		}
+
+		for {
+			key, err := s.start(ctx)
+			if err != nil {
+				errHandler(err)
+				return
+			}
+
+			done, stop, err := s.serve(key, send, errHandler)
+			if err != nil {
+				errHandler(err)
+				return
+			}
+
+			ticker := time.NewTicker(userStreamKeepalive)
+		wait:
+			for {
+				select {
+				case <-ticker.C:
+					if err := s.keepalive(ctx, key); err != nil {
+						log.Warnf("binance/userstream: keepalive failed: %v", err)
+					}
+				case <-ctx.Done():
+					ticker.Stop()
+					close(stop)
+					return
+				case <-done:
+					// 连接断开，等待一段时间后重新申请listenKey并连接
+					ticker.Stop()
+					time.Sleep(ba.Duration())
+					break wait
+				}
+			}
+		}
+	}()
+
+	return corder, cerr
+}
+
+// OrderSubscription 通过现货用户数据流订阅订单更新。
+func (b *Binance) OrderSubscription(ctx context.Context) (chan model.Order, chan error) {
+	return userStream{
+		start: func(ctx context.Context) (string, error) {
+			return b.client.NewStartUserStreamService().Do(ctx)
+		},
+		keepalive: func(ctx context.Context, key string) error {
+			return b.client.NewKeepaliveUserStreamService().ListenKey(key).Do(ctx)
+		},
+		serve: func(key string, send func(model.Order), errHandler func(error)) (chan struct{}, chan struct{}, error) {
+			return b.wsUserDataServe(key, func(event *binance.WsUserDataEvent) {
+				if event.Event == binance.UserDataEventTypeExecutionReport {
+					send(OrderFromWsUpdate(event.OrderUpdate))
+				}
+			}, errHandler)
+		},
+	}.subscribe(ctx)
+}
+
+// OrderSubscription 通过合约用户数据流订阅订单更新。
+func (b *BinanceFuture) OrderSubscription(ctx context.Context) (chan model.Order, chan error) {
+	return userStream{
+		start: func(ctx context.Context) (string, error) {
+			return b.client.NewStartUserStreamService().Do(ctx)
+		},
+		keepalive: func(ctx context.Context, key string) error {
+			return b.client.NewKeepaliveUserStreamService().ListenKey(key).Do(ctx)
+		},
+		serve: func(key string, send func(model.Order), errHandler func(error)) (chan struct{}, chan struct{}, error) {
+			return b.wsUserDataServe(key, func(event *futures.WsUserDataEvent) {
+				if event.Event == futures.UserDataEventTypeOrderTradeUpdate {
+					send(FutureOrderFromWsUpdate(event.OrderTradeUpdate))
+				}
+			}, errHandler)
+		},
+	}.subscribe(ctx)
+}
+
+// wsUserDataServe 连接现货用户数据流，配置了自定义WebSocket地址时连接该地址，否则使用go-binance连接币安。
+func (b *Binance) wsUserDataServe(key string, handler binance.WsUserDataHandler,
+	errHandler binance.ErrHandler) (doneC, stopC chan struct{}, err error) {
+	if b.WsURL == "" {
+		return binance.WsUserDataServe(key, handler, errHandler)
+	}
+
+	return wsServe(b.WsURL+"/"+key, func(message []byte) {
+		event := new(binance.WsUserDataEvent)
+		if err := json.Unmarshal(message, event); err != nil {
+			errHandler(err)
+			return
+		}
+
+		// 执行回报的字段平铺在事件中，与go-binance一样再解析一次
+		if event.Event == binance.UserDataEventTypeExecutionReport {
+			if err := json.Unmarshal(message, &event.OrderUpdate); err != nil {
+				errHandler(err)
+				return
+			}
+			event.TransactionTime = event.OrderUpdate.TransactionTime
+		}
+		handler(event)
+	}, errHandler)
+}
+
+// wsUserDataServe 连接合约用户数据流，配置了自定义WebSocket地址时连接该地址，否则使用go-binance连接币安。
+func (b *BinanceFuture) wsUserDataServe(key string, handler futures.WsUserDataHandler,
+	errHandler futures.ErrHandler) (doneC, stopC chan struct{}, err error) {
+	if b.WsURL == "" {
+		return futures.WsUserDataServe(key, handler, errHandler)
+	}
+
+	return wsServe(b.WsURL+"/"+key, func(message []byte) {
+		event := new(futures.WsUserDataEvent)
+		if err := json.Unmarshal(message, event); err != nil {
+			errHandler(err)
+			return
+		}
+		handler(event)
+	}, errHandler)
+}
+
+// OrderFromWsUpdate 将现货用户数据流中的订单执行回报转换为内部订单模型，价格和数量的规则与newOrder一致。
+func OrderFromWsUpdate(update binance.WsOrderUpdate) model.Order {
+	var price float64
+
+	cost, _ := strconv.ParseFloat(update.FilledQuoteVolume, 64)
+	quantity, _ := strconv.ParseFloat(update.FilledVolume, 64)
+	if cost > 0 && quantity > 0 {
+		price = cost / quantity
+	} else {
+		price, _ = strconv.ParseFloat(update.Price, 64)
+		quantity, _ = strconv.ParseFloat(update.Volume, 64)
+	}
+
+	return model.Order{
+		ExchangeID:    update.Id,
+		Pair:          update.Symbol,
+		CreatedAt:     time.Unix(0, update.CreateTime*int64(time.Millisecond)),
+		UpdatedAt:     time.Unix(0, update.TransactionTime*int64(time.Millisecond)),
+		Side:          model.SideType(update.Side),
+		Type:          model.OrderType(update.Type),
+		Status:        model.OrderStatusType(update.Status),
+		Price:         price,
+		Quantity:      quantity,
+		ClientOrderID: update.ClientOrderId,
+		TimeInForce:   model.TimeInForceType(update.TimeInForce),
+	}
+}
+
+// FutureOrderFromWsUpdate 将合约用户数据流中的订单回报转换为内部订单模型，价格和数量的规则与newFutureOrder一致。
+func FutureOrderFromWsUpdate(update futures.WsOrderTradeUpdate) model.Order {
+	price, _ := strconv.ParseFloat(update.AveragePrice, 64)
+	quantity, _ := strconv.ParseFloat(update.AccumulatedFilledQty, 64)
+	if price <= 0 || quantity <= 0 {
+		price, _ = strconv.ParseFloat(update.OriginalPrice, 64)
+		quantity, _ = strconv.ParseFloat(update.OriginalQty, 64)
+	}
+	// 止损、止盈市价单没有委托价格，使用触发价
+	if price <= 0 {
+		price, _ = strconv.ParseFloat(update.StopPrice, 64)
+	}
+
+	return model.Order{
+		ExchangeID:    update.ID,
+		Pair:          update.Symbol,
+		CreatedAt:     time.Unix(0, update.TradeTime*int64(time.Millisecond)),
+		UpdatedAt:     time.Unix(0, update.TradeTime*int64(time.Millisecond)),
+		Side:          model.SideType(update.Side),
+		Type:          model.OrderType(update.Type),
+		Status:        model.OrderStatusType(update.Status),
+		Price:         price,
+		Quantity:      quantity,
+		ClientOrderID: update.ClientOrderID,
+		TimeInForce:   model.TimeInForceType(update.TimeInForce),
+		PositionSide:  futurePositionSide(update.PositionSide),
+	}
+}
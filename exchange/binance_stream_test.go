@@ -0,0 +1,69 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+func TestOrderFromWsUpdate(t *testing.T) {
+	t.Run("new order", func(t *testing.T) {
+		order := OrderFromWsUpdate(binance.WsOrderUpdate{
+			Symbol:        "BTCUSDT",
+			ClientOrderId: "entry-1",
+			Side:          "BUY",
+			Type:          "LIMIT",
+			TimeInForce:   binance.TimeInForceTypeGTC,
+			Volume:        "0.5",
+			Price:         "20000",
+			Status:        "NEW",
+			Id:            42,
+			FilledVolume:  "0",
+		})
+		require.Equal(t, int64(42), order.ExchangeID)
+		require.Equal(t, model.OrderStatusTypeNew, order.Status)
+		require.Equal(t, 20000.0, order.Price)
+		require.Equal(t, 0.5, order.Quantity)
+		require.Equal(t, "entry-1", order.ClientOrderID)
+		require.Equal(t, model.TimeInForceGTC, order.TimeInForce)
+	})
+
+	t.Run("filled order uses average price", func(t *testing.T) {
+		order := OrderFromWsUpdate(binance.WsOrderUpdate{
+			Symbol:            "BTCUSDT",
+			Side:              "SELL",
+			Type:              "MARKET",
+			Volume:            "1",
+			Price:             "0",
+			Status:            "FILLED",
+			Id:                43,
+			FilledVolume:      "1",
+			FilledQuoteVolume: "20500",
+		})
+		require.Equal(t, model.OrderStatusTypeFilled, order.Status)
+		require.Equal(t, 20500.0, order.Price)
+		require.Equal(t, 1.0, order.Quantity)
+	})
+}
+
+func TestFutureOrderFromWsUpdate(t *testing.T) {
+	order := FutureOrderFromWsUpdate(futures.WsOrderTradeUpdate{
+		Symbol:               "BTCUSDT",
+		Side:                 futures.SideTypeBuy,
+		Type:                 futures.OrderTypeLimit,
+		OriginalQty:          "2",
+		OriginalPrice:        "20000",
+		AveragePrice:         "19990",
+		Status:               futures.OrderStatusTypePartiallyFilled,
+		ID:                   7,
+		AccumulatedFilledQty: "1",
+	})
+	require.Equal(t, int64(7), order.ExchangeID)
+	require.Equal(t, model.OrderStatusTypePartiallyFilled, order.Status)
+	require.Equal(t, 19990.0, order.Price)
+	require.Equal(t, 1.0, order.Quantity)
+}
@@ -1,9 +1,17 @@
 package exchange
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/require"
 
 	"github.com/rodrigo-brito/ninjabot/model"
@@ -51,3 +59,106 @@ func TestFormatQuantity(t *testing.T) {
 		})
 	}
 }
+
+func TestBinance_CreateOrderStopPostOnly(t *testing.T) {
+	binance := Binance{assetsInfo: map[string]model.AssetInfo{
+		"BTCUSDT": {MinQuantity: 0.0001, MaxQuantity: 100},
+	}}
+
+	_, err := binance.CreateOrderStop("BTCUSDT", 1, 20000, model.WithPostOnly())
+	require.ErrorIs(t, err, ErrNotSupported)
+}
+
+func TestBinanceFuture_ReplaceOrder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPut, r.Method)
+		require.Equal(t, "/fapi/v1/order", r.URL.Path)
+		require.Equal(t, "key", r.Header.Get("X-MBX-APIKEY"))
+		query := r.URL.Query()
+		require.NotEmpty(t, query.Get("signature"))
+		require.Equal(t, "SELL", query.Get("side"))
+
+		if query.Get("orderId") != "42" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"code":-2013,"msg":"Order does not exist."}`)
+			return
+		}
+		fmt.Fprintf(w, `{"orderId":42,"symbol":"BTCUSDT","status":"NEW","price":"%s","origQty":"%s",`+
+			`"executedQty":"0","cumQuote":"0","timeInForce":"GTX","type":"LIMIT","side":"SELL",`+
+			`"time":1700000000000,"updateTime":1700000001000}`, query.Get("price"), query.Get("quantity"))
+	}))
+	defer server.Close()
+
+	client := futures.NewClient("key", "secret")
+	client.BaseURL = server.URL
+	binance := BinanceFuture{ctx: context.Background(), client: client, assetsInfo: map[string]model.AssetInfo{
+		"BTCUSDT": {MinQuantity: 0.001, MaxQuantity: 100, StepSize: 0.001, TickSize: 0.1,
+			BaseAssetPrecision: 3, QuotePrecision: 1},
+	}}
+
+	order := model.Order{ExchangeID: 42, Pair: "BTCUSDT", Side: model.SideTypeSell, Type: model.OrderTypeLimit,
+		Status: model.OrderStatusTypeNew, Price: 30000, Quantity: 1}
+	modified, err := binance.ReplaceOrder(order, 31000.12, 0.5)
+	require.NoError(t, err)
+	require.Equal(t, int64(42), modified.ExchangeID)
+	require.Equal(t, 31000.1, modified.Price)
+	require.Equal(t, 0.5, modified.Quantity)
+	require.Equal(t, model.TimeInForceGTX, modified.TimeInForce)
+
+	order.ExchangeID = 43
+	_, err = binance.ReplaceOrder(order, 31000, 0.5)
+	require.ErrorIs(t, err, ErrOrderNotFound)
+}
+
+func TestBinanceOrderError(t *testing.T) {
+	err := binanceOrderError(&common.APIError{Code: ErrOrderDoesNotExist, Message: "Order does not exist."})
+	require.ErrorIs(t, err, ErrOrderNotFound)
+
+	other := &common.APIError{Code: -1021, Message: "Timestamp for this request is outside of the recvWindow."}
+	err = binanceOrderError(other)
+	require.NotErrorIs(t, err, ErrOrderNotFound)
+	require.Equal(t, other, err)
+}
+
+// TestBinance_TicksSubscriptionCancel 取消订阅时服务器仍在推送，通道关闭后不能再有发送
+func TestBinance_TicksSubscriptionCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for id := 1; ; id++ {
+			event := fmt.Sprintf(`{"e":"aggTrade","E":1,"s":"BTCUSDT","a":%d,"p":"100","q":"1","T":1,"m":false}`, id)
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(event)); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	binance := &Binance{WsURL: "ws" + strings.TrimPrefix(server.URL, "http")}
+	ctx, cancel := context.WithCancel(context.Background())
+	ticks, errs := binance.TicksSubscription(ctx, "BTCUSDT")
+
+	tick := <-ticks
+	require.Equal(t, int64(1), tick.ID)
+	cancel()
+
+	// 通道在连接断开后关闭
+	timeout := time.After(5 * time.Second)
+	for ticks != nil || errs != nil {
+		select {
+		case _, ok := <-ticks:
+			if !ok {
+				ticks = nil
+			}
+		case _, ok := <-errs:
+			if !ok {
+				errs = nil
+			}
+		case <-timeout:
+			t.Fatal("subscription channels not closed")
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/jpillora/backoff"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+// aggTradesLimit 是币安归集成交接口每次请求返回的最大数量
+const aggTradesLimit = 1000
+
+// TicksByPeriod 获取时间范围内的归集成交。币安同时指定开始和结束时间时范围不能超过1小时，
+// 先按小时找到第一笔成交，之后按成交ID连续翻页，直到成交时间超过end。
+func (b *Binance) TicksByPeriod(ctx context.Context, pair string, start, end time.Time) ([]model.Tick, error) {
+	ticks := make([]model.Tick, 0)
+	cursor := start
+	fromID := int64(-1)
+
+	for cursor.Before(end) {
+		service := b.client.NewAggTradesService().Symbol(pair).Limit(aggTradesLimit)
+		window := cursor.Add(time.Hour)
+		if window.After(end) {
+			window = end
+		}
+		if fromID >= 0 {
+			service.FromID(fromID)
+		} else {
+			service.StartTime(cursor.UnixNano() / int64(time.Millisecond)).
+				EndTime(window.UnixNano() / int64(time.Millisecond))
+		}
+
+		data, err := service.Do(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		// 这一小时没有成交，继续查找下一小时
+		if len(data) == 0 && fromID < 0 {
+			cursor = window
+			continue
+		}
+
+		for _, d := range data {
+			tick := TickFromAggTrade(pair, *d)
+			if tick.Time.After(end) {
+				return ticks, nil
+			}
+			ticks = append(ticks, tick)
+		}
+
+		// 按ID翻页时返回的数量不足，已经是最新的成交
+		if fromID >= 0 && len(data) < aggTradesLimit {
+			break
+		}
+		fromID = data[len(data)-1].AggTradeID + 1
+	}
+	return ticks, nil
+}
+
+// TicksSubscription 订阅实时归集成交，连接断开后按指数退避重连
+func (b *Binance) TicksSubscription(ctx context.Context, pair string) (chan model.Tick, chan error) {
+	ctick := make(chan model.Tick)
+	cerr := make(chan error)
+
+	go func() {
+		ba := &backoff.Backoff{
+			Min: 100 * time.Millisecond,
+			Max: 1 * time.Second,
+		}
+
+		for {
+			done, stop, err := b.wsAggTradeServe(pair, func(event *binance.WsAggTradeEvent) {
+				ba.Reset()
+				select {
+				case ctick <- TickFromWsAggTrade(pair, event):
+				case <-ctx.Done():
+				}
+			}, func(err error) {
+				select {
+				case cerr <- err:
+				case <-ctx.Done():
+				}
+			})
+			if err != nil {
+				cerr <- err
+				close(cerr)
+				close(ctick)
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				// 先断开连接并等待连接协程退出，避免向已经关闭的通道发送
+				close(stop)
+				<-done
+				close(cerr)
+				close(ctick)
+				return
+			case <-done:
+				time.Sleep(ba.Duration())
+			}
+		}
+	}()
+
+	return ctick, cerr
+}
+
+// wsAggTradeServe 订阅归集成交推送，配置了自定义WebSocket地址时连接该地址，否则使用go-binance连接币安。
+func (b *Binance) wsAggTradeServe(pair string, handler binance.WsAggTradeHandler,
+	errHandler binance.ErrHandler) (doneC, stopC chan struct{}, err error) {
+	if b.WsURL == "" {
+		return binance.WsAggTradeServe(pair, handler, errHandler)
+	}
+
+	endpoint := fmt.Sprintf("%s/%s@aggTrade", b.WsURL, strings.ToLower(pair))
+	return wsServe(endpoint, func(message []byte) {
+		event := new(binance.WsAggTradeEvent)
+		if err := json.Unmarshal(message, event); err != nil {
+			errHandler(err)
+			return
+		}
+		handler(event)
+	}, errHandler)
+}
+
+// TickFromAggTrade 把币安REST接口的归集成交转换为model.Tick
+func TickFromAggTrade(pair string, trade binance.AggTrade) model.Tick {
+	tick := model.Tick{
+		Pair:       pair,
+		ID:         trade.AggTradeID,
+		Time:       time.Unix(0, trade.Timestamp*int64(time.Millisecond)).UTC(),
+		BuyerMaker: trade.IsBuyerMaker,
+	}
+	tick.Price, _ = strconv.ParseFloat(trade.Price, 64)
+	tick.Quantity, _ = strconv.ParseFloat(trade.Quantity, 64)
+	return tick
+}
+
+// TickFromWsAggTrade 把币安WebSocket推送的归集成交转换为model.Tick
+func TickFromWsAggTrade(pair string, event *binance.WsAggTradeEvent) model.Tick {
+	tick := model.Tick{
+		Pair:       pair,
+		ID:         event.AggTradeID,
+		Time:       time.Unix(0, event.TradeTime*int64(time.Millisecond)).UTC(),
+		BuyerMaker: event.IsBuyerMaker,
+	}
+	tick.Price, _ = strconv.ParseFloat(event.Price, 64)
+	tick.Quantity, _ = strconv.ParseFloat(event.Quantity, 64)
+	return tick
+}
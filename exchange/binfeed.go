@@ -0,0 +1,536 @@
+package exchange
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"golang.org/x/exp/mmap"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+// BinaryFileExt 是二进制K线文件的扩展名，下载器根据输出文件的扩展名选择格式
+const BinaryFileExt = ".bin"
+
+const (
+	binaryVersion   = 1     // binaryVersion 文件格式版本
+	binaryBlockRows = 4096  // binaryBlockRows 每个数据块最多包含的K线数量
+	binaryHeaderLen = 16    // binaryHeaderLen 文件头固定部分的长度，不包含附加列名称
+	binaryIndexLen  = 32    // binaryIndexLen 每个数据块索引的长度
+	binaryFooterLen = 24    // binaryFooterLen 文件尾的长度
+	binaryColumns   = 7     // binaryColumns 固定的8字节列：开盘时间、更新时间、开高低收和成交量
+	binaryMaxColumn = 0xfff // binaryMaxColumn 附加列的最大数量
+)
+
+// binaryMagic 出现在文件头和文件尾，文件尾缺失说明写入没有完成
+var binaryMagic = [8]byte{'N', 'I', 'N', 'J', 'A', 'B', 'I', 'N'}
+
+var (
+	// ErrInvalidBinaryFile 表示文件不是完整的二进制K线文件
+	ErrInvalidBinaryFile = errors.New("invalid binary candle file")
+	// ErrUnsortedCandles 表示写入的K线没有按开盘时间升序排列
+	ErrUnsortedCandles = errors.New("candles must be sorted by time")
+)
+
+/*
+二进制K线文件是按列存储的，读取时不需要解析文本，适合大数据量回测的快速加载。所有数值都是小端序：
+
+	文件头  magic[8] version(uint16) 附加列数量(uint16) 保留(uint32)，之后每个附加列名称为 长度(uint16)+名称
+	数据块  每块最多4096根K线，块内按列连续存放：开盘时间、更新时间(int64纳秒)，开、收、低、高、成交量、
+	       每个附加列(float64)，最后是是否完成(uint8)
+	索引    每个数据块一条：偏移(uint64) 数量(uint32) 保留(uint32) 第一根和最后一根K线的开盘时间(int64纳秒)
+	文件尾  索引偏移(uint64) 数据块数量(uint32) 保留(uint32) magic[8]
+
+K线按开盘时间升序写入，索引记录每个数据块的时间范围，读取时通过二分查找直接定位到需要的数据块。
+附加列保存CSV中的额外列，读取后放回Candle.Metadata。
+*/
+
+// BinaryWriter 按上面的格式写入二进制K线文件，K线先缓存在内存中，每满一个数据块写入一次
+type BinaryWriter struct {
+	writer   *bufio.Writer
+	metadata []string       // metadata 附加列名称
+	pending  []model.Candle // pending 还没有写入的数据块
+	index    []binaryBlock  // index 已经写入的数据块索引
+	offset   int64          // offset 下一个数据块在文件中的偏移
+	last     time.Time      // last 最后写入的K线开盘时间
+	closed   bool
+}
+
+// binaryBlock 是一个数据块的索引
+type binaryBlock struct {
+	offset int64
+	rows   int
+	first  time.Time
+	last   time.Time
+}
+
+// NewBinaryWriter 创建二进制K线文件的写入器并写入文件头，metadata是需要保存的Candle.Metadata列名称。
+// 写入完成后必须调用Close写入索引，Close不会关闭w。
+func NewBinaryWriter(w io.Writer, metadata ...string) (*BinaryWriter, error) {
+	if len(metadata) > binaryMaxColumn {
+		return nil, fmt.Errorf("too many metadata columns: %d", len(metadata))
+	}
+
+	writer := &BinaryWriter{
+		writer:   bufio.NewWriterSize(w, 1<<20),
+		metadata: metadata,
+		pending:  make([]model.Candle, 0, binaryBlockRows),
+	}
+
+	header := make([]byte, binaryHeaderLen)
+	copy(header, binaryMagic[:])
+	binary.LittleEndian.PutUint16(header[8:], binaryVersion)
+	binary.LittleEndian.PutUint16(header[10:], uint16(len(metadata)))
+	for _, name := range metadata {
+		if len(name) > math.MaxUint16 {
+			return nil, fmt.Errorf("metadata column name too long: %s", name)
+		}
+		header = appendUint16(header, uint16(len(name)))
+		header = append(header, name...)
+	}
+
+	if _, err := writer.writer.Write(header); err != nil {
+		return nil, err
+	}
+	writer.offset = int64(len(header))
+	return writer, nil
+}
+
+// Write 按开盘时间升序写入K线，开盘时间早于已经写入的K线时返回ErrUnsortedCandles
+func (w *BinaryWriter) Write(candles ...model.Candle) error {
+	if w.closed {
+		return errors.New("binary writer closed")
+	}
+
+	for _, candle := range candles {
+		if candle.Time.Before(w.last) {
+			return fmt.Errorf("%w: %s before %s", ErrUnsortedCandles, candle.Time, w.last)
+		}
+		w.last = candle.Time
+
+		w.pending = append(w.pending, candle)
+		if len(w.pending) == binaryBlockRows {
+			if err := w.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close 写入剩余的K线、索引和文件尾
+func (w *BinaryWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if err := w.flush(); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 0, len(w.index)*binaryIndexLen+binaryFooterLen)
+	for _, block := range w.index {
+		buf = appendUint64(buf, uint64(block.offset))
+		buf = appendUint32(buf, uint32(block.rows))
+		buf = appendUint32(buf, 0)
+		buf = appendUint64(buf, uint64(block.first.UnixNano()))
+		buf = appendUint64(buf, uint64(block.last.UnixNano()))
+	}
+	buf = appendUint64(buf, uint64(w.offset))
+	buf = appendUint32(buf, uint32(len(w.index)))
+	buf = appendUint32(buf, 0)
+	buf = append(buf, binaryMagic[:]...)
+
+	if _, err := w.writer.Write(buf); err != nil {
+		return err
+	}
+	return w.writer.Flush()
+}
+
+// flush 把缓存的K线按列写入一个数据块
+func (w *BinaryWriter) flush() error {
+	rows := len(w.pending)
+	if rows == 0 {
+		return nil
+	}
+
+	buf := make([]byte, 0, binaryBlockLen(rows, len(w.metadata)))
+	for _, candle := range w.pending {
+		buf = appendUint64(buf, uint64(candle.Time.UnixNano()))
+	}
+	for _, candle := range w.pending {
+		buf = appendUint64(buf, uint64(candle.UpdatedAt.UnixNano()))
+	}
+
+	columns := []func(model.Candle) float64{
+		func(c model.Candle) float64 { return c.Open },
+		func(c model.Candle) float64 { return c.Close },
+		func(c model.Candle) float64 { return c.Low },
+		func(c model.Candle) float64 { return c.High },
+		func(c model.Candle) float64 { return c.Volume },
+	}
+	for _, name := range w.metadata {
+		name := name
+		columns = append(columns, func(c model.Candle) float64 { return c.Metadata[name] })
+	}
+	for _, column := range columns {
+		for _, candle := range w.pending {
+			buf = appendUint64(buf, math.Float64bits(column(candle)))
+		}
+	}
+
+	for _, candle := range w.pending {
+		complete := byte(0)
+		if candle.Complete {
+			complete = 1
+		}
+		buf = append(buf, complete)
+	}
+
+	if _, err := w.writer.Write(buf); err != nil {
+		return err
+	}
+
+	w.index = append(w.index, binaryBlock{
+		offset: w.offset,
+		rows:   rows,
+		first:  w.pending[0].Time,
+		last:   w.pending[rows-1].Time,
+	})
+	w.offset += int64(len(buf))
+	w.pending = w.pending[:0]
+	return nil
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v), byte(v>>8))
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	return appendUint32(appendUint32(buf, uint32(v)), uint32(v>>32))
+}
+
+// binaryBlockLen 返回包含rows根K线和metadata个附加列的数据块长度
+func binaryBlockLen(rows, metadata int) int {
+	return rows * ((binaryColumns+metadata)*8 + 1)
+}
+
+// WriteBinaryFile 把K线写入二进制文件，先写入临时文件再替换，中断不会留下不完整的文件
+func WriteBinaryFile(path string, candles []model.Candle, metadata ...string) error {
+	return writeBinaryFile(path, metadata, func(writer *BinaryWriter) error {
+		return writer.Write(candles...)
+	})
+}
+
+// ConvertCSVToBinary 把CSV文件转换为二进制K线文件，CSV中的额外列保存为附加列。
+// CSV文件逐行读取，转换不需要把整个文件读入内存。
+func ConvertCSVToBinary(input, output string) error {
+	return ConvertCSVFeedToBinary(PairFeed{File: input}, output)
+}
+
+// ConvertCSVFeedToBinary 与ConvertCSVToBinary相同，按feed.Schema读取第三方格式的CSV文件
+func ConvertCSVFeedToBinary(feed PairFeed, output string) error {
+	source, err := openCSV(feed)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	return writeBinaryFile(output, source.metadata, func(writer *BinaryWriter) error {
+		for {
+			candle, err := source.next(feed.Pair)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := writer.Write(candle); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// writeBinaryFile 在path所在目录创建临时文件，调用write写入K线后替换path
+func writeBinaryFile(path string, metadata []string, write func(*BinaryWriter) error) error {
+	file, err := os.CreateTemp(filepath.Dir(path), ".binary-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	writer, err := NewBinaryWriter(file, metadata...)
+	if err == nil {
+		if err = write(writer); err == nil {
+			err = writer.Close()
+		}
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// binaryFile 是通过内存映射打开的二进制K线文件，打开时只读取文件头和索引
+type binaryFile struct {
+	reader   *mmap.ReaderAt
+	metadata []string
+	blocks   []binaryBlock
+}
+
+// openBinaryFile 映射文件并读取文件头和索引
+func openBinaryFile(path string) (*binaryFile, error) {
+	reader, err := mmap.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &binaryFile{reader: reader}
+	if err := file.readIndex(); err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return file, nil
+}
+
+// readIndex 校验文件头和文件尾，读取附加列名称和数据块索引
+func (f *binaryFile) readIndex() error {
+	size := int64(f.reader.Len())
+	if size < binaryHeaderLen+binaryFooterLen {
+		return ErrInvalidBinaryFile
+	}
+
+	header := make([]byte, binaryHeaderLen)
+	footer := make([]byte, binaryFooterLen)
+	if _, err := f.reader.ReadAt(header, 0); err != nil {
+		return err
+	}
+	if _, err := f.reader.ReadAt(footer, size-binaryFooterLen); err != nil {
+		return err
+	}
+	if string(header[:8]) != string(binaryMagic[:]) || string(footer[16:]) != string(binaryMagic[:]) {
+		return ErrInvalidBinaryFile
+	}
+	if version := binary.LittleEndian.Uint16(header[8:]); version != binaryVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidBinaryFile, version)
+	}
+
+	offset := int64(binaryHeaderLen)
+	f.metadata = make([]string, binary.LittleEndian.Uint16(header[10:]))
+	for i := range f.metadata {
+		length := make([]byte, 2)
+		if _, err := f.reader.ReadAt(length, offset); err != nil {
+			return err
+		}
+		name := make([]byte, binary.LittleEndian.Uint16(length))
+		if _, err := f.reader.ReadAt(name, offset+2); err != nil {
+			return err
+		}
+		f.metadata[i] = string(name)
+		offset += 2 + int64(len(name))
+	}
+
+	indexOffset := int64(binary.LittleEndian.Uint64(footer))
+	count := int64(binary.LittleEndian.Uint32(footer[8:]))
+	if indexOffset < offset || indexOffset+count*binaryIndexLen != size-binaryFooterLen {
+		return ErrInvalidBinaryFile
+	}
+
+	index := make([]byte, count*binaryIndexLen)
+	if _, err := f.reader.ReadAt(index, indexOffset); err != nil {
+		return err
+	}
+	f.blocks = make([]binaryBlock, count)
+	for i := range f.blocks {
+		entry := index[i*binaryIndexLen:]
+		block := binaryBlock{
+			offset: int64(binary.LittleEndian.Uint64(entry)),
+			rows:   int(binary.LittleEndian.Uint32(entry[8:])),
+			first:  time.Unix(0, int64(binary.LittleEndian.Uint64(entry[16:]))).UTC(),
+			last:   time.Unix(0, int64(binary.LittleEndian.Uint64(entry[24:]))).UTC(),
+		}
+		if block.offset < offset || block.offset+int64(binaryBlockLen(block.rows, len(f.metadata))) > indexOffset {
+			return ErrInvalidBinaryFile
+		}
+		f.blocks[i] = block
+	}
+	return nil
+}
+
+// search 返回第一个包含开盘时间不早于start的K线的数据块
+func (f *binaryFile) search(start time.Time) int {
+	return sort.Search(len(f.blocks), func(i int) bool {
+		return !f.blocks[i].last.Before(start)
+	})
+}
+
+// readBlock 读取一个数据块中的所有K线
+func (f *binaryFile) readBlock(pair string, index int, buf []byte) ([]model.Candle, []byte, error) {
+	block := f.blocks[index]
+	length := binaryBlockLen(block.rows, len(f.metadata))
+	if cap(buf) < length {
+		buf = make([]byte, length)
+	}
+	buf = buf[:length]
+	if _, err := f.reader.ReadAt(buf, block.offset); err != nil {
+		return nil, buf, err
+	}
+
+	rows := block.rows
+	column := func(i, row int) uint64 {
+		return binary.LittleEndian.Uint64(buf[(i*rows+row)*8:])
+	}
+	float := func(i, row int) float64 {
+		return math.Float64frombits(column(i, row))
+	}
+	completeOffset := (binaryColumns + len(f.metadata)) * rows * 8
+
+	candles := make([]model.Candle, rows)
+	for row := range candles {
+		candle := model.Candle{
+			Pair:      pair,
+			Time:      time.Unix(0, int64(column(0, row))).UTC(),
+			UpdatedAt: time.Unix(0, int64(column(1, row))).UTC(),
+			Open:      float(2, row),
+			Close:     float(3, row),
+			Low:       float(4, row),
+			High:      float(5, row),
+			Volume:    float(6, row),
+			Complete:  buf[completeOffset+row] == 1,
+		}
+		if len(f.metadata) > 0 {
+			candle.Metadata = make(map[string]float64, len(f.metadata))
+			for i, name := range f.metadata {
+				candle.Metadata[name] = float(binaryColumns+i, row)
+			}
+		}
+		candles[row] = candle
+	}
+	return candles, buf, nil
+}
+
+/*
+BinaryFeed 是读取二进制K线文件的数据源，文件通过内存映射打开，创建时只读取索引，几乎没有加载时间。
+与CSVStreamFeed一样按需读取并重采样到需要的时间周期，查询时间范围时通过索引跳过之前的数据块。
+PairFeed.File是二进制文件的路径，可以由BinaryWriter、ConvertCSVToBinary或下载器生成。不再使用时调用Close释放映射。
+*/
+type BinaryFeed struct {
+	streamFeed
+	Feeds           map[string]PairFeed // Feeds 每个交易对的文件配置，键为交易对
+	targetTimeframe string              // targetTimeframe 目标时间周期，与NewCSVFeed的参数一致
+	files           map[string]*binaryFile
+}
+
+// NewBinaryFeed 映射所有交易对的二进制文件并读取索引
+func NewBinaryFeed(targetTimeframe string, feeds ...PairFeed) (*BinaryFeed, error) {
+	feed := &BinaryFeed{
+		Feeds:           make(map[string]PairFeed),
+		targetTimeframe: targetTimeframe,
+		files:           make(map[string]*binaryFile),
+	}
+	feed.streamFeed = newStreamFeed(feed.stream)
+
+	for _, pairFeed := range feeds {
+		if _, err := isLastCandlePeriod(time.Time{}, pairFeed.Timeframe, targetTimeframe); err != nil {
+			feed.Close()
+			return nil, err
+		}
+
+		file, err := openBinaryFile(pairFeed.File)
+		if err != nil {
+			feed.Close()
+			return nil, err
+		}
+		if old, ok := feed.files[pairFeed.Pair]; ok {
+			old.reader.Close()
+		}
+
+		feed.Feeds[pairFeed.Pair] = pairFeed
+		feed.files[pairFeed.Pair] = file
+
+		if pairFeed.Validation != nil {
+			report, err := validateStream(pairFeed, file.candles(pairFeed.Pair, 0))
+			feed.Reports[pairFeed.Pair] = report
+			if err != nil {
+				feed.Close()
+				return nil, fmt.Errorf("%s: %w", pairFeed.File, err)
+			}
+		}
+	}
+
+	return feed, nil
+}
+
+// Close 释放所有文件的内存映射，之后不能再读取数据
+func (b *BinaryFeed) Close() error {
+	var result error
+	for pair, file := range b.files {
+		if err := file.reader.Close(); err != nil && result == nil {
+			result = err
+		}
+		delete(b.files, pair)
+	}
+	return result
+}
+
+// stream 按数据块读取K线，start不为零时通过索引从包含start的数据块开始读取。
+// Heikin Ashi依赖之前所有的K线，这时仍然从头开始读取。
+func (b *BinaryFeed) stream(ctx context.Context, pair, timeframe string, start time.Time,
+	fn func(model.Candle) error) error {
+
+	feed, ok := b.Feeds[pair]
+	file := b.files[pair]
+	if !ok || file == nil {
+		return fmt.Errorf("%w: %s", ErrInsufficientData, pair)
+	}
+
+	block := 0
+	if !start.IsZero() && !feed.HeikinAshi {
+		block = file.search(start)
+	}
+
+	return pipeCandles(ctx, feed, timeframe, file.candles(pair, block), fn)
+}
+
+// candles 返回从第block个数据块开始按顺序读取K线的函数，用于pipeCandles
+func (f *binaryFile) candles(pair string, block int) func() (model.Candle, bool, error) {
+	var (
+		candles []model.Candle
+		buf     []byte
+		err     error
+	)
+	return func() (model.Candle, bool, error) {
+		for len(candles) == 0 {
+			if block >= len(f.blocks) {
+				return model.Candle{}, false, nil
+			}
+			if candles, buf, err = f.readBlock(pair, block, buf); err != nil {
+				return model.Candle{}, false, err
+			}
+			block++
+		}
+
+		candle := candles[0]
+		candles = candles[1:]
+		return candle, true, nil
+	}
+}
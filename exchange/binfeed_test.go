@@ -0,0 +1,154 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+func TestBinaryFeed(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// btc-1h.csv超过一个数据块的大小，会写入两个数据块
+	for _, name := range []string{"btc-1h", "eth-1h", "btc-1d-header"} {
+		require.NoError(t, ConvertCSVToBinary(filepath.Join("../testdata", name+".csv"),
+			filepath.Join(dir, name+BinaryFileExt)))
+	}
+
+	t.Run("same candles as csv feed", func(t *testing.T) {
+		csvFeed, err := NewCSVFeed("4h",
+			PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1h.csv", Timeframe: "1h"},
+			PairFeed{Pair: "ETHUSDT", File: "../testdata/eth-1h.csv", Timeframe: "1h", HeikinAshi: true},
+		)
+		require.NoError(t, err)
+		binaryFeed, err := NewBinaryFeed("4h",
+			PairFeed{Pair: "BTCUSDT", File: filepath.Join(dir, "btc-1h.bin"), Timeframe: "1h"},
+			PairFeed{Pair: "ETHUSDT", File: filepath.Join(dir, "eth-1h.bin"), Timeframe: "1h", HeikinAshi: true},
+		)
+		require.NoError(t, err)
+		defer binaryFeed.Close()
+
+		for _, pair := range []string{"BTCUSDT", "ETHUSDT"} {
+			for _, timeframe := range []string{"1h", "4h"} {
+				expected, err := collectCandles(csvFeed.CandlesSubscription(ctx, pair, timeframe))
+				require.NoError(t, err)
+				actual, err := collectCandles(binaryFeed.CandlesSubscription(ctx, pair, timeframe))
+				require.NoError(t, err)
+				require.Len(t, actual, len(expected))
+				require.Equal(t, expected, actual, "%s %s", pair, timeframe)
+			}
+		}
+	})
+
+	t.Run("metadata", func(t *testing.T) {
+		csvFeed, err := NewCSVFeed("1d", PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1d-header.csv",
+			Timeframe: "1d"})
+		require.NoError(t, err)
+		binaryFeed, err := NewBinaryFeed("1d", PairFeed{Pair: "BTCUSDT",
+			File: filepath.Join(dir, "btc-1d-header.bin"), Timeframe: "1d"})
+		require.NoError(t, err)
+		defer binaryFeed.Close()
+
+		expected, err := collectCandles(csvFeed.CandlesSubscription(ctx, "BTCUSDT", "1d"))
+		require.NoError(t, err)
+		actual, err := collectCandles(binaryFeed.CandlesSubscription(ctx, "BTCUSDT", "1d"))
+		require.NoError(t, err)
+		require.Equal(t, expected, actual)
+		assert.Equal(t, 1.1, actual[0].Metadata["lsr"])
+		assert.Equal(t, 2174544.0, actual[0].Metadata["trades"])
+	})
+
+	t.Run("by period uses index", func(t *testing.T) {
+		feed := PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1h.csv", Timeframe: "1h"}
+		csvFeed, err := NewCSVFeed("1d", feed)
+		require.NoError(t, err)
+		feed.File = filepath.Join(dir, "btc-1h.bin")
+		binaryFeed, err := NewBinaryFeed("1d", feed)
+		require.NoError(t, err)
+		defer binaryFeed.Close()
+
+		file := binaryFeed.files["BTCUSDT"]
+		require.Len(t, file.blocks, 2)
+
+		// 时间范围在第二个数据块中，直接从第二个数据块开始读取
+		start := file.blocks[1].first.Add(24 * time.Hour).Truncate(24 * time.Hour)
+		end := start.Add(48 * time.Hour)
+		assert.Equal(t, 1, file.search(start))
+
+		for _, timeframe := range []string{"1h", "1d"} {
+			expected, err := csvFeed.CandlesByPeriod(ctx, "BTCUSDT", timeframe, start, end)
+			require.NoError(t, err)
+			actual, err := binaryFeed.CandlesByPeriod(ctx, "BTCUSDT", timeframe, start, end)
+			require.NoError(t, err)
+			require.NotEmpty(t, actual)
+			require.Equal(t, expected, actual, timeframe)
+		}
+
+		all, err := collectCandles(binaryFeed.CandlesSubscription(ctx, "BTCUSDT", "1h"))
+		require.NoError(t, err)
+		limit, err := binaryFeed.CandlesByLimit(ctx, "BTCUSDT", "1h", 10)
+		require.NoError(t, err)
+		assert.Equal(t, all[:10], limit)
+	})
+
+	t.Run("write candles", func(t *testing.T) {
+		start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+		candles := []model.Candle{
+			{Time: start, UpdatedAt: start.Add(time.Second), Open: 1, Close: 2, Low: 0.5, High: 3, Volume: 10,
+				Metadata: map[string]float64{"funding": 0.01}},
+			{Time: start.Add(time.Minute), UpdatedAt: start.Add(time.Minute), Open: 2, Close: 1, Complete: true,
+				Metadata: map[string]float64{"funding": -0.02}},
+		}
+		path := filepath.Join(dir, "candles.bin")
+		require.NoError(t, WriteBinaryFile(path, candles, "funding"))
+
+		binaryFeed, err := NewBinaryFeed("1m", PairFeed{Pair: "BTCUSDT", File: path, Timeframe: "1m"})
+		require.NoError(t, err)
+		defer binaryFeed.Close()
+
+		actual, err := binaryFeed.CandlesByPeriod(ctx, "BTCUSDT", "1m", start, start.Add(time.Hour))
+		require.NoError(t, err)
+		require.Len(t, actual, 2)
+		for i := range candles {
+			candles[i].Pair = "BTCUSDT"
+			// 源时间周期与目标时间周期相同时，是否完成由时间周期决定
+			candles[i].Complete = true
+		}
+		assert.Equal(t, candles, actual)
+	})
+
+	t.Run("invalid files", func(t *testing.T) {
+		var buf bytes.Buffer
+		writer, err := NewBinaryWriter(&buf)
+		require.NoError(t, err)
+		start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+		require.NoError(t, writer.Write(model.Candle{Time: start}))
+		require.ErrorIs(t, writer.Write(model.Candle{Time: start.Add(-time.Minute)}), ErrUnsortedCandles)
+		require.NoError(t, writer.Close())
+
+		// 缺少文件尾的文件是没有写完的文件
+		path := filepath.Join(dir, "truncated.bin")
+		require.NoError(t, os.WriteFile(path, buf.Bytes()[:buf.Len()-1], 0o644))
+		_, err = NewBinaryFeed("1m", PairFeed{Pair: "BTCUSDT", File: path, Timeframe: "1m"})
+		require.ErrorIs(t, err, ErrInvalidBinaryFile)
+
+		_, err = NewBinaryFeed("1d", PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1h.csv", Timeframe: "1h"})
+		require.ErrorIs(t, err, ErrInvalidBinaryFile)
+
+		_, err = NewBinaryFeed("1d", PairFeed{Pair: "BTCUSDT", File: "invalid.bin", Timeframe: "1h"})
+		require.Error(t, err)
+
+		_, err = NewBinaryFeed("1y", PairFeed{Pair: "BTCUSDT", File: filepath.Join(dir, "btc-1h.bin"),
+			Timeframe: "1h"})
+		require.Error(t, err)
+	})
+}
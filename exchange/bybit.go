@@ -0,0 +1,1239 @@
+package exchange
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/jpillora/backoff"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/tools/log"
+)
+
+/*
+Bybit 通过Bybit的V5统一接口实现service.Exchange，同一个类型支持现货（spot）和USDT永续合约（linear），
+通过WithBybitCategory选择。Bybit没有官方的Go客户端，这里直接使用net/http和WebSocket调用接口：
+私有接口使用HMAC-SHA256签名，签名内容为 时间戳 + API Key + recvWindow + 查询字符串（GET）或请求体（POST）。
+
+Bybit的订单ID是字符串（合约订单是UUID），而model.Order的ExchangeID是int64：
+现货的订单ID是数字，直接作为ExchangeID，重启后也可以还原；合约订单下单时由本地生成ExchangeID，
+写入orderLinkId（"ninjabot-"加上ExchangeID），重启后按orderLinkId查询订单。调用方指定了ClientOrderID的
+合约订单只能通过stringExchangeID把UUID转换为整数，对应关系记录在内存中，重启后通过列出交易对最近的
+订单重新找到，找不到时返回ErrUnknownOrderID，而不是ErrOrderNotFound。
+*/
+
+// BybitCategory Bybit的产品类型。
+type BybitCategory string
+
+// Bybit支持的产品类型。
+const (
+	BybitCategorySpot   BybitCategory = "spot"   // 现货
+	BybitCategoryLinear BybitCategory = "linear" // USDT/USDC永续合约
+)
+
+const (
+	bybitAPIURL        = "https://api.bybit.com"
+	bybitTestnetAPIURL = "https://api-testnet.bybit.com"
+	bybitWsURL         = "wss://stream.bybit.com/v5/public"
+	bybitTestnetWsURL  = "wss://stream-testnet.bybit.com/v5/public"
+
+	bybitRecvWindow   = "5000"           // 签名请求的有效时间窗口（毫秒）
+	bybitPingInterval = 20 * time.Second // Bybit建议每20秒发送一次心跳
+	bybitMaxLimit     = 1000             // K线接口单次最多返回的数量
+	bybitOrderLimit   = 50               // 订单接口单次最多返回的数量
+
+	bybitErrLeverageNotModified = 110043 // 杠杆倍数没有变化，设置杠杆时忽略
+
+	bybitLinkPrefix = "ninjabot-" // 本地生成的orderLinkId前缀，后面是订单的ExchangeID
+)
+
+// ErrUnknownOrderID 表示找不到ExchangeID对应的Bybit订单ID，订单可能仍然存在，不能当作订单不存在处理。
+var ErrUnknownOrderID = errors.New("bybit order id unknown")
+
+// bybitErrors 将Bybit的错误码映射为通用错误，调用方可以用errors.Is判断。
+var bybitErrors = map[int]error{
+	110001: ErrOrderNotFound,     // 合约：订单不存在
+	170213: ErrOrderNotFound,     // 现货：订单不存在
+	110007: ErrInsufficientFunds, // 合约：可用余额不足
+	170131: ErrInsufficientFunds, // 现货：余额不足
+}
+
+// BybitError Bybit接口返回的错误，Code是Bybit的retCode。
+type BybitError struct {
+	Code    int
+	Message string
+}
+
+// Error 实现error接口。
+func (e *BybitError) Error() string {
+	return fmt.Sprintf("bybit error %d: %s", e.Code, e.Message)
+}
+
+// Unwrap 返回错误码对应的通用错误，没有对应的通用错误时返回nil。
+func (e *BybitError) Unwrap() error {
+	return bybitErrors[e.Code]
+}
+
+// Bybit 结构体封装了与Bybit交易所交互所需的配置和数据。
+type Bybit struct {
+	ctx         context.Context            // ctx 用于控制所有请求的取消和超时。
+	client      *http.Client               // client 执行REST请求的HTTP客户端。
+	assetsInfo  map[string]model.AssetInfo // assetsInfo 存储每个交易对的交易限制，用于校验和格式化订单。
+	settleCoins []string                   // settleCoins 合约的结算币种，查询持仓时使用。
+	timeOffset  time.Duration              // timeOffset 服务器时间与本地时间的差，用于签名的时间戳。
+
+	mtx      sync.Mutex       // mtx 保护orderIDs和lastID
+	orderIDs map[int64]string // orderIDs ExchangeID到Bybit订单ID的对应关系。
+	lastID   int64            // lastID 上一次本地生成的ExchangeID，保证生成的ID递增
+
+	Category   BybitCategory // Category 产品类型，默认为现货。
+	HeikinAshi bool          // HeikinAshi 是否将普通K线转换为Heikin Ashi K线。
+	Testnet    bool          // Testnet 是否使用Bybit的测试网络。
+
+	APIKey    string // APIKey 用户的Bybit API Key。
+	APISecret string // APISecret 用户的Bybit API Secret，用于签名。
+	APIURL    string // APIURL 自定义的REST接口地址，为空时使用Bybit的地址。
+	WsURL     string // WsURL 自定义的公共WebSocket地址（不包含产品类型），为空时使用Bybit的地址。
+
+	MetadataFetchers []MetadataFetchers // MetadataFetchers 在收到完整的K线后为K线添加额外的元数据。
+	PairOptions      []PairOption       // PairOptions 合约交易对的杠杆倍数，Bybit统一账户的保证金模式是账户级别的，MarginType不使用。
+}
+
+// BybitOption 定义了一个函数类型，用于通过不同的配置选项来定制化Bybit实例。
+type BybitOption func(*Bybit)
+
+// WithBybitCredentials 设置Bybit的API Key和Secret。
+func WithBybitCredentials(key, secret string) BybitOption {
+	return func(b *Bybit) {
+		b.APIKey = key
+		b.APISecret = secret
+	}
+}
+
+// WithBybitTestnet 使用Bybit的测试网络。
+func WithBybitTestnet() BybitOption {
+	return func(b *Bybit) {
+		b.Testnet = true
+	}
+}
+
+// WithBybitHeikinAshiCandle 启用Heikin Ashi蜡烛图转换。
+func WithBybitHeikinAshiCandle() BybitOption {
+	return func(b *Bybit) {
+		b.HeikinAshi = true
+	}
+}
+
+// WithBybitMetadataFetcher 在收到完整的K线后运行元数据提取器，为K线添加额外信息。
+func WithBybitMetadataFetcher(fetcher MetadataFetchers) BybitOption {
+	return func(b *Bybit) {
+		b.MetadataFetchers = append(b.MetadataFetchers, fetcher)
+	}
+}
+
+// WithBybitCategory 选择产品类型，现货或者永续合约。
+func WithBybitCategory(category BybitCategory) BybitOption {
+	return func(b *Bybit) {
+		b.Category = category
+	}
+}
+
+// WithBybitLeverage 设置合约交易对的杠杆倍数，只能用于永续合约。
+func WithBybitLeverage(pair string, leverage int) BybitOption {
+	return func(b *Bybit) {
+		b.PairOptions = append(b.PairOptions, PairOption{
+			Pair:     strings.ToUpper(pair),
+			Leverage: leverage,
+		})
+	}
+}
+
+// WithBybitEndpoint 使用自定义的REST接口和WebSocket地址，例如本地的模拟接口，为空的地址仍然使用Bybit的地址。
+func WithBybitEndpoint(apiURL, wsURL string) BybitOption {
+	return func(b *Bybit) {
+		b.APIURL = apiURL
+		b.WsURL = wsURL
+	}
+}
+
+// NewBybit 创建一个新的Bybit实例，检查与服务器的连接，加载交易对的交易限制并设置合约杠杆。
+func NewBybit(ctx context.Context, options ...BybitOption) (*Bybit, error) {
+	exchange := &Bybit{
+		ctx:      ctx,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		orderIDs: make(map[int64]string),
+		Category: BybitCategorySpot,
+	}
+
+	for _, option := range options {
+		option(exchange)
+	}
+
+	if exchange.Category != BybitCategorySpot && exchange.Category != BybitCategoryLinear {
+		return nil, fmt.Errorf("%w: bybit category %s", ErrNotSupported, exchange.Category)
+	}
+	if len(exchange.PairOptions) > 0 && exchange.Category != BybitCategoryLinear {
+		return nil, fmt.Errorf("%w: bybit leverage is only available for linear contracts", ErrNotSupported)
+	}
+
+	// 没有自定义地址时根据是否使用测试网选择Bybit的地址
+	if exchange.APIURL == "" {
+		exchange.APIURL = bybitAPIURL
+		if exchange.Testnet {
+			exchange.APIURL = bybitTestnetAPIURL
+		}
+	}
+	if exchange.WsURL == "" {
+		exchange.WsURL = bybitWsURL
+		if exchange.Testnet {
+			exchange.WsURL = bybitTestnetWsURL
+		}
+	}
+
+	// 查询服务器时间检查连接，同时记录本地时间的偏差，避免签名的时间戳超出recvWindow
+	var serverTime struct {
+		TimeNano string `json:"timeNano"`
+	}
+	if err := exchange.request(ctx, http.MethodGet, "/v5/market/time", nil, &serverTime); err != nil {
+		return nil, fmt.Errorf("bybit ping fail: %w", err)
+	}
+	if nano, err := strconv.ParseInt(serverTime.TimeNano, 10, 64); err == nil {
+		exchange.timeOffset = time.Until(time.Unix(0, nano))
+	}
+
+	if err := exchange.loadAssetsInfo(ctx); err != nil {
+		return nil, err
+	}
+
+	// 设置合约杠杆，杠杆没有变化时Bybit返回错误码110043，忽略
+	for _, option := range exchange.PairOptions {
+		leverage := strconv.Itoa(option.Leverage)
+		err := exchange.request(ctx, http.MethodPost, "/v5/position/set-leverage", map[string]interface{}{
+			"category":     string(exchange.Category),
+			"symbol":       option.Pair,
+			"buyLeverage":  leverage,
+			"sellLeverage": leverage,
+		}, nil)
+		if bybitErr, ok := err.(*BybitError); ok && bybitErr.Code == bybitErrLeverageNotModified {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log.Infof("[SETUP] Using Bybit exchange (%s)", exchange.Category)
+	return exchange, nil
+}
+
+// bybitInstrument 交易对信息接口返回的交易对，现货和合约的字段不完全相同。
+type bybitInstrument struct {
+	Symbol        string `json:"symbol"`
+	BaseCoin      string `json:"baseCoin"`
+	QuoteCoin     string `json:"quoteCoin"`
+	SettleCoin    string `json:"settleCoin"`
+	LotSizeFilter struct {
+		BasePrecision  string `json:"basePrecision"`  // 现货：数量的最小变动单位
+		QuotePrecision string `json:"quotePrecision"` // 现货：金额的最小变动单位
+		QtyStep        string `json:"qtyStep"`        // 合约：数量的最小变动单位
+		MinOrderQty    string `json:"minOrderQty"`
+		MaxOrderQty    string `json:"maxOrderQty"`
+	} `json:"lotSizeFilter"`
+	PriceFilter struct {
+		MinPrice string `json:"minPrice"`
+		MaxPrice string `json:"maxPrice"`
+		TickSize string `json:"tickSize"`
+	} `json:"priceFilter"`
+}
+
+// loadAssetsInfo 加载所有交易对的交易限制，合约的交易对信息是分页返回的。
+func (b *Bybit) loadAssetsInfo(ctx context.Context) error {
+	b.assetsInfo = make(map[string]model.AssetInfo)
+	settleCoins := make(map[string]bool)
+
+	cursor := ""
+	for {
+		params := map[string]interface{}{"category": string(b.Category), "limit": bybitMaxLimit}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+
+		var result struct {
+			List           []bybitInstrument `json:"list"`
+			NextPageCursor string            `json:"nextPageCursor"`
+		}
+		if err := b.request(ctx, http.MethodGet, "/v5/market/instruments-info", params, &result); err != nil {
+			return err
+		}
+
+		for _, instrument := range result.List {
+			// 现货的数量步长是basePrecision，合约是qtyStep
+			step := instrument.LotSizeFilter.QtyStep
+			if step == "" {
+				step = instrument.LotSizeFilter.BasePrecision
+			}
+
+			info := model.AssetInfo{
+				BaseAsset:          instrument.BaseCoin,
+				QuoteAsset:         instrument.QuoteCoin,
+				BaseAssetPrecision: stepPrecision(step),
+				QuotePrecision:     stepPrecision(instrument.PriceFilter.TickSize),
+			}
+			info.StepSize, _ = strconv.ParseFloat(step, 64)
+			info.MinQuantity, _ = strconv.ParseFloat(instrument.LotSizeFilter.MinOrderQty, 64)
+			info.MaxQuantity, _ = strconv.ParseFloat(instrument.LotSizeFilter.MaxOrderQty, 64)
+			info.TickSize, _ = strconv.ParseFloat(instrument.PriceFilter.TickSize, 64)
+			info.MinPrice, _ = strconv.ParseFloat(instrument.PriceFilter.MinPrice, 64)
+			info.MaxPrice, _ = strconv.ParseFloat(instrument.PriceFilter.MaxPrice, 64)
+
+			// 现货没有价格上下限，使用最小变动单位和最大值
+			if info.MinPrice == 0 {
+				info.MinPrice = info.TickSize
+			}
+			if info.MaxPrice == 0 {
+				info.MaxPrice = math.MaxFloat64
+			}
+
+			b.assetsInfo[instrument.Symbol] = info
+			if instrument.SettleCoin != "" {
+				settleCoins[instrument.SettleCoin] = true
+			}
+		}
+
+		if result.NextPageCursor == "" || len(result.List) == 0 {
+			break
+		}
+		cursor = result.NextPageCursor
+	}
+
+	b.settleCoins = make([]string, 0, len(settleCoins))
+	for coin := range settleCoins {
+		b.settleCoins = append(b.settleCoins, coin)
+	}
+	sort.Strings(b.settleCoins)
+	return nil
+}
+
+// bybitResponse Bybit接口统一的返回格式。
+type bybitResponse struct {
+	RetCode int             `json:"retCode"`
+	RetMsg  string          `json:"retMsg"`
+	Result  json.RawMessage `json:"result"`
+}
+
+// request 调用Bybit接口并将result解析到result中。GET请求的参数放在查询字符串中，POST请求的参数作为JSON请求体。
+// 设置了API Key时对请求签名，retCode不为0时返回BybitError。
+func (b *Bybit) request(ctx context.Context, method, path string, params map[string]interface{}, result interface{}) error {
+	var query, body string
+	if method == http.MethodGet {
+		values := url.Values{}
+		for key, value := range params {
+			values.Set(key, fmt.Sprint(value))
+		}
+		query = values.Encode()
+	} else {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		body = string(data)
+	}
+
+	endpoint := b.APIURL + path
+	if query != "" {
+		endpoint += "?" + query
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if b.APIKey != "" {
+		timestamp := strconv.FormatInt(time.Now().Add(b.timeOffset).UnixNano()/int64(time.Millisecond), 10)
+		req.Header.Set("X-BAPI-API-KEY", b.APIKey)
+		req.Header.Set("X-BAPI-TIMESTAMP", timestamp)
+		req.Header.Set("X-BAPI-RECV-WINDOW", bybitRecvWindow)
+		req.Header.Set("X-BAPI-SIGN", bybitSign(b.APISecret, timestamp+b.APIKey+bybitRecvWindow+query+body))
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var response bybitResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("bybit %s %s: status %d: %w", method, path, resp.StatusCode, err)
+	}
+	if response.RetCode != 0 {
+		return &BybitError{Code: response.RetCode, Message: response.RetMsg}
+	}
+	if result == nil || len(response.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
+// bybitSign 使用HMAC-SHA256计算签名，返回十六进制字符串。
+func bybitSign(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// LastQuote 获取指定交易对最近一根完整的1分钟K线的收盘价。
+func (b *Bybit) LastQuote(ctx context.Context, pair string) (float64, error) {
+	candles, err := b.CandlesByLimit(ctx, pair, "1m", 1)
+	if err != nil || len(candles) < 1 {
+		return 0, err
+	}
+	return candles[0].Close, nil
+}
+
+// AssetsInfo 根据交易对拿到资产信息。
+func (b *Bybit) AssetsInfo(pair string) model.AssetInfo {
+	return b.assetsInfo[pair]
+}
+
+// validate 校验交易对是否存在以及数量是否在交易所的限制之内。
+func (b *Bybit) validate(pair string, quantity float64) error {
+	info, ok := b.assetsInfo[pair]
+	if !ok {
+		return ErrInvalidAsset
+	}
+
+	if quantity > info.MaxQuantity || quantity < info.MinQuantity {
+		return &OrderError{
+			Err:      fmt.Errorf("%w: min: %f max: %f", ErrInvalidQuantity, info.MinQuantity, info.MaxQuantity),
+			Pair:     pair,
+			Quantity: quantity,
+		}
+	}
+	return nil
+}
+
+// formatPrice 按交易对的价格最小变动单位格式化价格。
+func (b *Bybit) formatPrice(pair string, value float64) string {
+	if info, ok := b.assetsInfo[pair]; ok {
+		value = common.AmountToLotSize(info.TickSize, info.QuotePrecision, value)
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// formatQuantity 按交易对的数量最小变动单位格式化数量。
+func (b *Bybit) formatQuantity(pair string, value float64) string {
+	if info, ok := b.assetsInfo[pair]; ok {
+		value = common.AmountToLotSize(info.StepSize, info.BaseAssetPrecision, value)
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// bybitSide 将订单方向转换为Bybit的格式（Buy/Sell）。
+func bybitSide(side model.SideType) string {
+	if side == model.SideTypeSell {
+		return "Sell"
+	}
+	return "Buy"
+}
+
+// bybitTimeInForce 将订单参数中的有效方式转换为Bybit的格式，只做挂单对应PostOnly，未指定时使用GTC。
+func bybitTimeInForce(params model.OrderParams) string {
+	switch params.TimeInForce {
+	case "":
+		return string(model.TimeInForceGTC)
+	case model.TimeInForceGTX:
+		return "PostOnly"
+	default:
+		return string(params.TimeInForce)
+	}
+}
+
+// orderBody 返回下单请求的公共参数。合约订单没有指定ClientOrderID时，用本地生成的ExchangeID作为orderLinkId。
+func (b *Bybit) orderBody(side model.SideType, pair, orderType string, params model.OrderParams) map[string]interface{} {
+	body := map[string]interface{}{
+		"category":  string(b.Category),
+		"symbol":    pair,
+		"side":      bybitSide(side),
+		"orderType": orderType,
+	}
+	switch {
+	case params.ClientOrderID != "":
+		body["orderLinkId"] = params.ClientOrderID
+	case b.Category != BybitCategorySpot:
+		body["orderLinkId"] = bybitLinkPrefix + strconv.FormatInt(b.newExchangeID(), 10)
+	}
+	return body
+}
+
+// newExchangeID 生成一个新的ExchangeID，使用纳秒时间戳，重启后也不会与之前的订单重复。
+func (b *Bybit) newExchangeID() int64 {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	id := time.Now().UnixNano()
+	if id <= b.lastID {
+		id = b.lastID + 1
+	}
+	b.lastID = id
+	return id
+}
+
+// createOrder 提交订单，返回订单的ExchangeID。
+func (b *Bybit) createOrder(body map[string]interface{}) (int64, error) {
+	var result struct {
+		OrderID     string `json:"orderId"`
+		OrderLinkID string `json:"orderLinkId"`
+	}
+	if err := b.request(b.ctx, http.MethodPost, "/v5/order/create", body, &result); err != nil {
+		return 0, err
+	}
+	return b.exchangeID(result.OrderID, result.OrderLinkID), nil
+}
+
+// CreateOrderOCO Bybit没有OCO订单接口，返回ErrNotSupported。
+func (b *Bybit) CreateOrderOCO(_ model.SideType, _ string,
+	_, _, _, _ float64, _ ...model.OrderOption) ([]model.Order, error) {
+	return nil, fmt.Errorf("%w: bybit oco order", ErrNotSupported)
+}
+
+// CreateOrderStop 创建止损卖单，价格跌破limit后以市价卖出。
+// 现货通过orderFilter=StopOrder创建条件单，合约通过triggerDirection=2（价格下跌触发）创建条件单。
+func (b *Bybit) CreateOrderStop(pair string, quantity float64, limit float64,
+	options ...model.OrderOption) (model.Order, error) {
+	if err := b.validate(pair, quantity); err != nil {
+		return model.Order{}, err
+	}
+
+	params := model.NewOrderParams(options...)
+	body := b.orderBody(model.SideTypeSell, pair, "Market", params)
+	body["qty"] = b.formatQuantity(pair, quantity)
+	body["triggerPrice"] = b.formatPrice(pair, limit)
+	if b.Category == BybitCategorySpot {
+		body["orderFilter"] = "StopOrder"
+	} else {
+		body["triggerDirection"] = 2
+	}
+
+	id, err := b.createOrder(body)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	now := time.Now()
+	result := model.Order{
+		ExchangeID: id,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		Pair:       pair,
+		Side:       model.SideTypeSell,
+		Type:       model.OrderTypeStopLoss,
+		Status:     model.OrderStatusTypeNew,
+		Price:      limit,
+		Quantity:   quantity,
+	}
+	params.Apply(&result)
+	return result, nil
+}
+
+// CreateOrderLimit 创建限价订单，只做挂单通过PostOnly有效方式实现。
+func (b *Bybit) CreateOrderLimit(side model.SideType, pair string,
+	quantity float64, limit float64, options ...model.OrderOption) (model.Order, error) {
+	if err := b.validate(pair, quantity); err != nil {
+		return model.Order{}, err
+	}
+
+	params := model.NewOrderParams(options...)
+	body := b.orderBody(side, pair, "Limit", params)
+	body["qty"] = b.formatQuantity(pair, quantity)
+	body["price"] = b.formatPrice(pair, limit)
+	body["timeInForce"] = bybitTimeInForce(params)
+
+	id, err := b.createOrder(body)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	orderType := model.OrderTypeLimit
+	if params.TimeInForce == model.TimeInForceGTX {
+		orderType = model.OrderTypeLimitMaker
+	}
+
+	// 下单接口只返回订单ID，价格和数量使用提交给交易所的值
+	now := time.Now()
+	result := model.Order{
+		ExchangeID: id,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		Pair:       pair,
+		Side:       side,
+		Type:       orderType,
+		Status:     model.OrderStatusTypeNew,
+		Price:      limit,
+		Quantity:   quantity,
+	}
+	// Bybit不支持按时间过期，过期时间记录在订单上，由Controller到期后撤单
+	params.Apply(&result)
+	return result, nil
+}
+
+// CreateOrderMarket 创建市价订单，quantity是基础资产的数量。
+// 下单接口不返回成交信息，下单后查询订单得到成交均价和成交数量。
+func (b *Bybit) CreateOrderMarket(side model.SideType, pair string, quantity float64,
+	options ...model.OrderOption) (model.Order, error) {
+	if err := b.validate(pair, quantity); err != nil {
+		return model.Order{}, err
+	}
+
+	params := model.NewOrderParams(options...)
+	body := b.orderBody(side, pair, "Market", params)
+	body["qty"] = b.formatQuantity(pair, quantity)
+	if b.Category == BybitCategorySpot {
+		// 现货市价买单默认按计价资产的金额下单，这里指定按基础资产的数量下单
+		body["marketUnit"] = "baseCoin"
+	}
+
+	id, err := b.createOrder(body)
+	if err != nil {
+		return model.Order{}, err
+	}
+	return b.Order(pair, id)
+}
+
+// CreateOrderMarketQuote 按计价资产的金额创建市价订单，例如花费100 USDT买入BTC，只支持现货。
+func (b *Bybit) CreateOrderMarketQuote(side model.SideType, pair string, quantity float64,
+	options ...model.OrderOption) (model.Order, error) {
+	if b.Category != BybitCategorySpot {
+		return model.Order{}, fmt.Errorf("%w: bybit %s market quote order", ErrNotSupported, b.Category)
+	}
+	if _, ok := b.assetsInfo[pair]; !ok {
+		return model.Order{}, ErrInvalidAsset
+	}
+
+	params := model.NewOrderParams(options...)
+	body := b.orderBody(side, pair, "Market", params)
+	body["qty"] = b.formatPrice(pair, quantity) // 金额按计价资产的精度格式化
+	body["marketUnit"] = "quoteCoin"
+
+	id, err := b.createOrder(body)
+	if err != nil {
+		return model.Order{}, err
+	}
+	return b.Order(pair, id)
+}
+
+// ReplaceOrder 修改挂单的价格和数量。Bybit的改单接口（/v5/order/amend）还没有接入，这里通过撤单后重新下单实现，
+// 撤单成功到新订单创建之间两个订单都不在交易所挂单。
+func (b *Bybit) ReplaceOrder(order model.Order, price, quantity float64) (model.Order, error) {
+	// 撤单前先校验新数量，避免原订单被撤掉后新订单因为数量不合法下单失败
+	if err := b.validate(order.Pair, quantity); err != nil {
+		return model.Order{}, err
+	}
+	return cancelReplace(b, order, price, quantity)
+}
+
+// Cancel 撤销订单，现货的条件单需要指定orderFilter。
+func (b *Bybit) Cancel(order model.Order) error {
+	id, err := b.orderID(order.Pair, order.ExchangeID)
+	if err != nil {
+		return err
+	}
+
+	body := map[string]interface{}{
+		"category": string(b.Category),
+		"symbol":   order.Pair,
+		"orderId":  id,
+	}
+	if b.Category == BybitCategorySpot &&
+		(order.Type == model.OrderTypeStopLoss || order.Type == model.OrderTypeStopLossLimit) {
+		body["orderFilter"] = "StopOrder"
+	}
+	return b.request(b.ctx, http.MethodPost, "/v5/order/cancel", body, nil)
+}
+
+// bybitOrder 订单接口返回的订单。
+type bybitOrder struct {
+	OrderID      string `json:"orderId"`
+	OrderLinkID  string `json:"orderLinkId"`
+	Symbol       string `json:"symbol"`
+	Price        string `json:"price"`
+	Qty          string `json:"qty"`
+	Side         string `json:"side"`
+	OrderStatus  string `json:"orderStatus"`
+	OrderType    string `json:"orderType"`
+	TimeInForce  string `json:"timeInForce"`
+	CumExecQty   string `json:"cumExecQty"`
+	CumExecValue string `json:"cumExecValue"`
+	TriggerPrice string `json:"triggerPrice"`
+	CreatedTime  string `json:"createdTime"`
+	UpdatedTime  string `json:"updatedTime"`
+}
+
+// listOrders 调用订单列表接口（realtime或history）。
+func (b *Bybit) listOrders(path string, params map[string]interface{}) ([]model.Order, error) {
+	params["category"] = string(b.Category)
+
+	var result struct {
+		List []bybitOrder `json:"list"`
+	}
+	if err := b.request(b.ctx, http.MethodGet, path, params, &result); err != nil {
+		return nil, err
+	}
+
+	orders := make([]model.Order, 0, len(result.List))
+	for _, order := range result.List {
+		orders = append(orders, b.newOrder(order))
+	}
+	return orders, nil
+}
+
+// Order 查询订单，先查询挂单和最近的订单，找不到时查询历史订单。
+func (b *Bybit) Order(pair string, id int64) (model.Order, error) {
+	orderID, err := b.orderID(pair, id)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	for _, path := range []string{"/v5/order/realtime", "/v5/order/history"} {
+		orders, err := b.listOrders(path, map[string]interface{}{"symbol": pair, "orderId": orderID})
+		if err != nil {
+			return model.Order{}, err
+		}
+		if len(orders) > 0 {
+			return orders[0], nil
+		}
+	}
+	return model.Order{}, ErrOrderNotFound
+}
+
+// Orders 返回交易对最近的limit个订单（包括挂单和历史订单），按创建时间从早到晚排序，与币安一致。
+func (b *Bybit) Orders(pair string, limit int) ([]model.Order, error) {
+	if limit <= 0 || limit > bybitOrderLimit {
+		limit = bybitOrderLimit
+	}
+
+	seen := make(map[int64]bool)
+	orders := make([]model.Order, 0)
+	for _, path := range []string{"/v5/order/realtime", "/v5/order/history"} {
+		result, err := b.listOrders(path, map[string]interface{}{"symbol": pair, "limit": limit})
+		if err != nil {
+			return nil, err
+		}
+		// 最近结束的订单可能同时出现在两个接口中
+		for _, order := range result {
+			if !seen[order.ExchangeID] {
+				seen[order.ExchangeID] = true
+				orders = append(orders, order)
+			}
+		}
+	}
+
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].CreatedAt.Before(orders[j].CreatedAt)
+	})
+	if len(orders) > limit {
+		orders = orders[len(orders)-limit:]
+	}
+	return orders, nil
+}
+
+// linkExchangeID 返回本地生成的orderLinkId中的ExchangeID，不是本地生成的返回false。
+func linkExchangeID(linkID string) (int64, bool) {
+	if !strings.HasPrefix(linkID, bybitLinkPrefix) {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(linkID, bybitLinkPrefix), 10, 64)
+	return id, err == nil && id > 0
+}
+
+// exchangeID 返回Bybit订单对应的ExchangeID，并记录对应关系。本地生成的orderLinkId中带有ExchangeID，
+// 其他订单由订单ID转换。
+func (b *Bybit) exchangeID(orderID, linkID string) int64 {
+	id, ok := linkExchangeID(linkID)
+	if !ok {
+		id = stringExchangeID(orderID)
+	}
+	b.mtx.Lock()
+	b.orderIDs[id] = orderID
+	b.mtx.Unlock()
+	return id
+}
+
+// lookupOrderID 返回内存中记录的ExchangeID对应的Bybit订单ID。
+func (b *Bybit) lookupOrderID(id int64) (string, bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	orderID, ok := b.orderIDs[id]
+	return orderID, ok
+}
+
+// orderID 返回ExchangeID对应的Bybit订单ID。现货的订单ID就是ExchangeID；合约订单在内存中没有记录时，
+// 先按本地生成的orderLinkId查询，再列出交易对最近的订单查找，仍然找不到时返回ErrUnknownOrderID。
+func (b *Bybit) orderID(pair string, id int64) (string, error) {
+	if orderID, ok := b.lookupOrderID(id); ok {
+		return orderID, nil
+	}
+	if b.Category == BybitCategorySpot {
+		return strconv.FormatInt(id, 10), nil
+	}
+
+	// 查询和列出订单时会记录订单ID的对应关系
+	linkID := bybitLinkPrefix + strconv.FormatInt(id, 10)
+	for _, path := range []string{"/v5/order/realtime", "/v5/order/history"} {
+		if _, err := b.listOrders(path, map[string]interface{}{"symbol": pair, "orderLinkId": linkID}); err != nil {
+			return "", err
+		}
+		if orderID, ok := b.lookupOrderID(id); ok {
+			return orderID, nil
+		}
+	}
+
+	// 调用方指定了ClientOrderID的订单，ExchangeID由订单ID转换而来，只能在最近的订单中查找
+	if _, err := b.Orders(pair, bybitOrderLimit); err != nil {
+		return "", err
+	}
+	if orderID, ok := b.lookupOrderID(id); ok {
+		return orderID, nil
+	}
+	return "", fmt.Errorf("%w: %d not in the last %d orders of %s", ErrUnknownOrderID, id, bybitOrderLimit, pair)
+}
+
+// clientOrderID 返回调用方指定的ClientOrderID，本地生成的orderLinkId不作为ClientOrderID。
+func clientOrderID(linkID string) string {
+	if _, ok := linkExchangeID(linkID); ok {
+		return ""
+	}
+	return linkID
+}
+
+// bybitOrderStatus 将Bybit的订单状态转换为内部的订单状态，等待触发的条件单视为挂单。
+func bybitOrderStatus(status string) model.OrderStatusType {
+	switch status {
+	case "PartiallyFilled":
+		return model.OrderStatusTypePartiallyFilled
+	case "Filled":
+		return model.OrderStatusTypeFilled
+	case "Cancelled", "PartiallyFilledCanceled", "Deactivated":
+		return model.OrderStatusTypeCanceled
+	case "Rejected":
+		return model.OrderStatusTypeRejected
+	default: // New、Untriggered、Triggered
+		return model.OrderStatusTypeNew
+	}
+}
+
+// newOrder 将Bybit的订单转换为内部订单模型，有成交时价格为成交均价，数量为成交数量。
+func (b *Bybit) newOrder(order bybitOrder) model.Order {
+	var price, quantity float64
+
+	cost, _ := strconv.ParseFloat(order.CumExecValue, 64)
+	executed, _ := strconv.ParseFloat(order.CumExecQty, 64)
+	trigger, _ := strconv.ParseFloat(order.TriggerPrice, 64)
+	if cost > 0 && executed > 0 {
+		price = cost / executed
+		quantity = executed
+	} else {
+		price, _ = strconv.ParseFloat(order.Price, 64)
+		quantity, _ = strconv.ParseFloat(order.Qty, 64)
+		// 尚未触发的条件市价单没有价格，使用触发价
+		if price == 0 {
+			price = trigger
+		}
+	}
+
+	limit := order.OrderType == "Limit"
+	orderType := model.OrderTypeMarket
+	switch {
+	case trigger > 0 && limit:
+		orderType = model.OrderTypeStopLossLimit
+	case trigger > 0:
+		orderType = model.OrderTypeStopLoss
+	case limit && order.TimeInForce == "PostOnly":
+		orderType = model.OrderTypeLimitMaker
+	case limit:
+		orderType = model.OrderTypeLimit
+	}
+
+	timeInForce := model.TimeInForceType(order.TimeInForce)
+	if order.TimeInForce == "PostOnly" {
+		timeInForce = model.TimeInForceGTX
+	}
+
+	side := model.SideTypeBuy
+	if order.Side == "Sell" {
+		side = model.SideTypeSell
+	}
+
+	createdAt, _ := strconv.ParseInt(order.CreatedTime, 10, 64)
+	updatedAt, _ := strconv.ParseInt(order.UpdatedTime, 10, 64)
+	return model.Order{
+		ExchangeID:    b.exchangeID(order.OrderID, order.OrderLinkID),
+		Pair:          order.Symbol,
+		CreatedAt:     time.Unix(0, createdAt*int64(time.Millisecond)),
+		UpdatedAt:     time.Unix(0, updatedAt*int64(time.Millisecond)),
+		Side:          side,
+		Type:          orderType,
+		Status:        bybitOrderStatus(order.OrderStatus),
+		Price:         price,
+		Quantity:      quantity,
+		ClientOrderID: clientOrderID(order.OrderLinkID),
+		TimeInForce:   timeInForce,
+	}
+}
+
+// Account 返回账户余额。现货返回统一账户中各币种的可用和冻结余额；
+// 合约与币安期货一致，持仓作为基础资产的余额（空头为负数），保证金币种作为其他余额。
+func (b *Bybit) Account() (model.Account, error) {
+	var wallet struct {
+		List []struct {
+			Coin []struct {
+				Coin          string `json:"coin"`
+				WalletBalance string `json:"walletBalance"`
+				Locked        string `json:"locked"`
+			} `json:"coin"`
+		} `json:"list"`
+	}
+	err := b.request(b.ctx, http.MethodGet, "/v5/account/wallet-balance",
+		map[string]interface{}{"accountType": "UNIFIED"}, &wallet)
+	if err != nil {
+		return model.Account{}, err
+	}
+
+	balances := make([]model.Balance, 0)
+	if b.Category == BybitCategoryLinear {
+		positions, err := b.positions()
+		if err != nil {
+			return model.Account{}, err
+		}
+		balances = append(balances, positions...)
+	}
+
+	for _, account := range wallet.List {
+		for _, coin := range account.Coin {
+			total, _ := strconv.ParseFloat(coin.WalletBalance, 64)
+			if total == 0 {
+				continue
+			}
+
+			balance := model.Balance{Asset: coin.Coin, Free: total}
+			if b.Category == BybitCategorySpot {
+				balance.Lock, _ = strconv.ParseFloat(coin.Locked, 64)
+				balance.Free = total - balance.Lock
+			}
+			balances = append(balances, balance)
+		}
+	}
+
+	return model.Account{Balances: balances}, nil
+}
+
+// positions 返回合约持仓，Bybit要求按结算币种查询。
+func (b *Bybit) positions() ([]model.Balance, error) {
+	balances := make([]model.Balance, 0)
+	for _, coin := range b.settleCoins {
+		var result struct {
+			List []struct {
+				Symbol   string `json:"symbol"`
+				Side     string `json:"side"`
+				Size     string `json:"size"`
+				Leverage string `json:"leverage"`
+			} `json:"list"`
+		}
+		err := b.request(b.ctx, http.MethodGet, "/v5/position/list",
+			map[string]interface{}{"category": string(b.Category), "settleCoin": coin}, &result)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, position := range result.List {
+			size, _ := strconv.ParseFloat(position.Size, 64)
+			if size == 0 {
+				continue
+			}
+			if position.Side == "Sell" {
+				size = -size
+			}
+
+			leverage, _ := strconv.ParseFloat(position.Leverage, 64)
+			asset := b.assetsInfo[position.Symbol].BaseAsset
+			if asset == "" {
+				asset, _ = SplitAssetQuote(position.Symbol)
+			}
+			balances = append(balances, model.Balance{Asset: asset, Free: size, Leverage: leverage})
+		}
+	}
+	return balances, nil
+}
+
+// Position 返回交易对基础资产和计价资产的总余额（可用加冻结）。
+func (b *Bybit) Position(pair string) (asset, quote float64, err error) {
+	info, ok := b.assetsInfo[pair]
+	if !ok {
+		return 0, 0, ErrInvalidAsset
+	}
+
+	acc, err := b.Account()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	assetBalance, quoteBalance := acc.Balance(info.BaseAsset, info.QuoteAsset)
+	return assetBalance.Free + assetBalance.Lock, quoteBalance.Free + quoteBalance.Lock, nil
+}
+
+// PositionInfo 返回交易对的持仓数量和杠杆倍数，合约持仓为负数时表示空头。
+func (b *Bybit) PositionInfo(pair string) (model.PositionInfo, error) {
+	info, ok := b.assetsInfo[pair]
+	if !ok {
+		return model.PositionInfo{}, ErrInvalidAsset
+	}
+
+	acc, err := b.Account()
+	if err != nil {
+		return model.PositionInfo{}, err
+	}
+	return accountPosition(acc, pair, info.BaseAsset), nil
+}
+
+// bybitIntervals 时间周期到Bybit K线周期的对应关系。
+var bybitIntervals = map[string]string{
+	"1m":  "1",
+	"3m":  "3",
+	"5m":  "5",
+	"15m": "15",
+	"30m": "30",
+	"1h":  "60",
+	"2h":  "120",
+	"4h":  "240",
+	"6h":  "360",
+	"12h": "720",
+	"1d":  "D",
+	"1w":  "W",
+	"1M":  "M",
+}
+
+// bybitInterval 将时间周期转换为Bybit的K线周期。
+func bybitInterval(period string) (string, error) {
+	interval, ok := bybitIntervals[period]
+	if !ok {
+		return "", fmt.Errorf("%w: bybit interval %s", ErrNotSupported, period)
+	}
+	return interval, nil
+}
+
+// klines 调用K线接口，Bybit按时间从新到旧返回，这里转换为从旧到新。
+func (b *Bybit) klines(ctx context.Context, pair, period string, params map[string]interface{}) ([]model.Candle, error) {
+	interval, err := bybitInterval(period)
+	if err != nil {
+		return nil, err
+	}
+	params["category"] = string(b.Category)
+	params["symbol"] = pair
+	params["interval"] = interval
+
+	var result struct {
+		List [][]string `json:"list"`
+	}
+	if err := b.request(ctx, http.MethodGet, "/v5/market/kline", params, &result); err != nil {
+		return nil, err
+	}
+
+	candles := make([]model.Candle, 0, len(result.List))
+	ha := model.NewHeikinAshi()
+	for i := len(result.List) - 1; i >= 0; i-- {
+		candle, err := bybitCandle(pair, result.List[i])
+		if err != nil {
+			return nil, err
+		}
+		if b.HeikinAshi {
+			candle = candle.ToHeikinAshi(ha)
+		}
+		candles = append(candles, candle)
+	}
+	return candles, nil
+}
+
+// CandlesByLimit 获取最近limit根完整的K线，多请求一根并丢弃最后一根可能不完整的K线。
+func (b *Bybit) CandlesByLimit(ctx context.Context, pair, period string, limit int) ([]model.Candle, error) {
+	candles, err := b.klines(ctx, pair, period, map[string]interface{}{
+		"limit": int(math.Min(float64(limit+1), bybitMaxLimit)),
+	})
+	if err != nil || len(candles) == 0 {
+		return candles, err
+	}
+	return candles[:len(candles)-1], nil
+}
+
+// CandlesByPeriod 获取指定时间范围内的K线。
+func (b *Bybit) CandlesByPeriod(ctx context.Context, pair, period string,
+	start, end time.Time) ([]model.Candle, error) {
+	return b.klines(ctx, pair, period, map[string]interface{}{
+		"start": start.UnixNano() / int64(time.Millisecond),
+		"end":   end.UnixNano() / int64(time.Millisecond),
+		"limit": bybitMaxLimit,
+	})
+}
+
+// bybitCandle 将K线接口返回的数组 [开始时间, 开盘价, 最高价, 最低价, 收盘价, 成交量, 成交额] 转换为K线。
+func bybitCandle(pair string, k []string) (model.Candle, error) {
+	if len(k) < 6 {
+		return model.Candle{}, fmt.Errorf("bybit: invalid kline %v", k)
+	}
+
+	start, err := strconv.ParseInt(k[0], 10, 64)
+	if err != nil {
+		return model.Candle{}, err
+	}
+
+	t := time.Unix(0, start*int64(time.Millisecond))
+	candle := model.Candle{Pair: pair, Time: t, UpdatedAt: t, Complete: true}
+	candle.Open, _ = strconv.ParseFloat(k[1], 64)
+	candle.High, _ = strconv.ParseFloat(k[2], 64)
+	candle.Low, _ = strconv.ParseFloat(k[3], 64)
+	candle.Close, _ = strconv.ParseFloat(k[4], 64)
+	candle.Volume, _ = strconv.ParseFloat(k[5], 64)
+	candle.Metadata = make(map[string]float64)
+	return candle, nil
+}
+
+// bybitWsMessage 公共WebSocket推送的消息，订阅结果和K线推送共用。
+type bybitWsMessage struct {
+	Op      string `json:"op"`
+	Success *bool  `json:"success"`
+	RetMsg  string `json:"ret_msg"`
+	Topic   string `json:"topic"`
+	Data    []struct {
+		Start     int64  `json:"start"`
+		Open      string `json:"open"`
+		Close     string `json:"close"`
+		High      string `json:"high"`
+		Low       string `json:"low"`
+		Volume    string `json:"volume"`
+		Confirm   bool   `json:"confirm"`
+		Timestamp int64  `json:"timestamp"`
+	} `json:"data"`
+}
+
+// CandlesSubscription 订阅K线推送，连接断开后按指数退避重连，与Binance.CandlesSubscription一致。
+func (b *Bybit) CandlesSubscription(ctx context.Context, pair, period string) (chan model.Candle, chan error) {
+	ccandle := make(chan model.Candle)
+	cerr := make(chan error)
+	ha := model.NewHeikinAshi()
+
+	// 连接协程中的发送在ctx取消后放弃，保证关闭通道前连接协程已经退出
+	sendErr := func(err error) {
+		select {
+		case cerr <- err:
+		case <-ctx.Done():
+		}
+	}
+
+	go func() {
+		ba := &backoff.Backoff{
+			Min: 100 * time.Millisecond,
+			Max: 1 * time.Second,
+		}
+
+		interval, err := bybitInterval(period)
+		if err != nil {
+			cerr <- err
+			close(cerr)
+			close(ccandle)
+			return
+		}
+
+		topic := fmt.Sprintf("kline.%s.%s", interval, pair)
+		subscribe, _ := json.Marshal(map[string]interface{}{"op": "subscribe", "args": []string{topic}})
+		ping, _ := json.Marshal(map[string]string{"op": "ping"})
+		endpoint := strings.TrimRight(b.WsURL, "/") + "/" + string(b.Category)
+
+		for {
+			done, stop, err := wsServeSubscribe(endpoint, subscribe, ping, bybitPingInterval, func(message []byte) {
+				var event bybitWsMessage
+				if err := json.Unmarshal(message, &event); err != nil {
+					sendErr(err)
+					return
+				}
+
+				// 订阅失败时报告错误，心跳的回复和其他消息直接忽略
+				if event.Op == "subscribe" && event.Success != nil && !*event.Success {
+					sendErr(fmt.Errorf("bybit subscribe %s: %s", topic, event.RetMsg))
+					return
+				}
+				if event.Topic != topic {
+					return
+				}
+
+				ba.Reset()
+				for _, k := range event.Data {
+					t := time.Unix(0, k.Start*int64(time.Millisecond))
+					candle := model.Candle{
+						Pair:      pair,
+						Time:      t,
+						UpdatedAt: time.Unix(0, k.Timestamp*int64(time.Millisecond)),
+						Complete:  k.Confirm,
+						Metadata:  make(map[string]float64),
+					}
+					candle.Open, _ = strconv.ParseFloat(k.Open, 64)
+					candle.Close, _ = strconv.ParseFloat(k.Close, 64)
+					candle.High, _ = strconv.ParseFloat(k.High, 64)
+					candle.Low, _ = strconv.ParseFloat(k.Low, 64)
+					candle.Volume, _ = strconv.ParseFloat(k.Volume, 64)
+
+					if candle.Complete && b.HeikinAshi {
+						candle = candle.ToHeikinAshi(ha)
+					}
+
+					if candle.Complete {
+						for _, fetcher := range b.MetadataFetchers {
+							key, value := fetcher(pair, candle.Time)
+							candle.Metadata[key] = value
+						}
+					}
+
+					select {
+					case ccandle <- candle:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}, sendErr)
+			if err != nil {
+				sendErr(err)
+				close(cerr)
+				close(ccandle)
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				// 先断开连接并等待连接协程退出，避免向已经关闭的通道发送
+				close(stop)
+				<-done
+				close(cerr)
+				close(ccandle)
+				return
+			case <-done:
+				time.Sleep(ba.Duration())
+			}
+		}
+	}()
+
+	return ccandle, cerr
+}
@@ -0,0 +1,533 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+const (
+	bybitTestKey    = "key"
+	bybitTestSecret = "secret"
+	bybitTestPrice  = 30000.0
+)
+
+// bybitStub 模拟Bybit V5接口的测试服务器，市价单以固定价格立即成交，限价单一直挂单。
+type bybitStub struct {
+	t        *testing.T
+	category string
+
+	mtx         sync.Mutex
+	orders      []bybitOrder
+	leverage    string
+	connections int
+}
+
+func newBybitStub(t *testing.T, category string) (*bybitStub, *httptest.Server) {
+	stub := &bybitStub{t: t, category: category, leverage: "1"}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	return stub, server
+}
+
+func (s *bybitStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/v5/public/") {
+		s.serveWs(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	require.NoError(s.t, err)
+
+	// 私有接口校验签名
+	if key := r.Header.Get("X-BAPI-API-KEY"); key != "" {
+		payload := r.Header.Get("X-BAPI-TIMESTAMP") + key + r.Header.Get("X-BAPI-RECV-WINDOW") + r.URL.RawQuery + string(body)
+		if key != bybitTestKey || r.Header.Get("X-BAPI-SIGN") != bybitSign(bybitTestSecret, payload) {
+			s.reply(w, 10004, "error sign!", nil)
+			return
+		}
+	}
+
+	params := make(map[string]interface{})
+	for key := range r.URL.Query() {
+		params[key] = r.URL.Query().Get(key)
+	}
+	if len(body) > 0 {
+		require.NoError(s.t, json.Unmarshal(body, &params))
+	}
+	if params["category"] != nil && params["category"] != s.category {
+		s.reply(w, 10001, "invalid category", nil)
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	switch r.URL.Path {
+	case "/v5/market/time":
+		s.reply(w, 0, "OK", map[string]string{"timeNano": strconv.FormatInt(time.Now().UnixNano(), 10)})
+	case "/v5/market/instruments-info":
+		s.instruments(w, params)
+	case "/v5/market/kline":
+		// 按时间从新到旧返回，最新的一根K线还没有结束
+		s.reply(w, 0, "OK", map[string]interface{}{"list": [][]string{
+			{"1700007200000", "102", "104", "101", "103", "12", "0"},
+			{"1700003600000", "101", "103", "100", "102", "11", "0"},
+			{"1700000000000", "100", "102", "99", "101", "10", "0"},
+		}})
+	case "/v5/order/create":
+		s.createOrder(w, params)
+	case "/v5/order/cancel":
+		for i, order := range s.orders {
+			if order.OrderID == params["orderId"] && order.OrderStatus == "New" {
+				s.orders[i].OrderStatus = "Cancelled"
+				s.reply(w, 0, "OK", map[string]string{"orderId": order.OrderID})
+				return
+			}
+		}
+		s.reply(w, 110001, "order not exists or too late to cancel", nil)
+	case "/v5/order/realtime", "/v5/order/history":
+		// realtime只返回挂单，history只返回已经结束的订单，按时间从新到旧
+		open := r.URL.Path == "/v5/order/realtime"
+		list := make([]bybitOrder, 0)
+		for i := len(s.orders) - 1; i >= 0; i-- {
+			order := s.orders[i]
+			if (order.OrderStatus == "New") != open {
+				continue
+			}
+			if id, ok := params["orderId"]; ok && id != order.OrderID {
+				continue
+			}
+			if id, ok := params["orderLinkId"]; ok && id != order.OrderLinkID {
+				continue
+			}
+			list = append(list, order)
+		}
+		s.reply(w, 0, "OK", map[string]interface{}{"list": list})
+	case "/v5/account/wallet-balance":
+		s.reply(w, 0, "OK", map[string]interface{}{"list": []interface{}{map[string]interface{}{
+			"coin": []map[string]string{
+				{"coin": "USDT", "walletBalance": "10000", "locked": "500"},
+				{"coin": "BTC", "walletBalance": "1", "locked": "0"},
+				{"coin": "ETH", "walletBalance": "0", "locked": "0"},
+			},
+		}}})
+	case "/v5/position/list":
+		require.Equal(s.t, "USDT", params["settleCoin"])
+		s.reply(w, 0, "OK", map[string]interface{}{"list": []map[string]string{
+			{"symbol": "BTCUSDT", "side": "Sell", "size": "0.5", "leverage": s.leverage},
+			{"symbol": "ETHUSDT", "side": "", "size": "0", "leverage": "1"},
+		}})
+	case "/v5/position/set-leverage":
+		if params["buyLeverage"] == s.leverage {
+			s.reply(w, 110043, "leverage not modified", nil)
+			return
+		}
+		s.leverage = params["buyLeverage"].(string)
+		s.reply(w, 0, "OK", nil)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *bybitStub) reply(w http.ResponseWriter, code int, message string, result interface{}) {
+	if result == nil {
+		result = map[string]interface{}{}
+	}
+	require.NoError(s.t, json.NewEncoder(w).Encode(map[string]interface{}{
+		"retCode": code,
+		"retMsg":  message,
+		"result":  result,
+		"time":    time.Now().UnixNano() / int64(time.Millisecond),
+	}))
+}
+
+// instruments 现货一次返回，合约分两页返回。
+func (s *bybitStub) instruments(w http.ResponseWriter, params map[string]interface{}) {
+	if s.category == string(BybitCategorySpot) {
+		s.reply(w, 0, "OK", map[string]interface{}{"list": []map[string]interface{}{{
+			"symbol":    "BTCUSDT",
+			"baseCoin":  "BTC",
+			"quoteCoin": "USDT",
+			"lotSizeFilter": map[string]string{
+				"basePrecision": "0.000001", "quotePrecision": "0.00000001",
+				"minOrderQty": "0.000048", "maxOrderQty": "71.73956243",
+			},
+			"priceFilter": map[string]string{"tickSize": "0.01"},
+		}}})
+		return
+	}
+
+	instrument := func(base string) map[string]interface{} {
+		return map[string]interface{}{
+			"symbol":     base + "USDT",
+			"baseCoin":   base,
+			"quoteCoin":  "USDT",
+			"settleCoin": "USDT",
+			"lotSizeFilter": map[string]string{
+				"qtyStep": "0.001", "minOrderQty": "0.001", "maxOrderQty": "100",
+			},
+			"priceFilter": map[string]string{"minPrice": "0.10", "maxPrice": "199999.80", "tickSize": "0.10"},
+		}
+	}
+	if params["cursor"] == "page2" {
+		s.reply(w, 0, "OK", map[string]interface{}{"list": []interface{}{instrument("ETH")}})
+		return
+	}
+	s.reply(w, 0, "OK", map[string]interface{}{"list": []interface{}{instrument("BTC")}, "nextPageCursor": "page2"})
+}
+
+// createOrder 现货订单ID是数字，合约订单ID是UUID。
+func (s *bybitStub) createOrder(w http.ResponseWriter, params map[string]interface{}) {
+	id := strconv.Itoa(1000 + len(s.orders))
+	if s.category == string(BybitCategoryLinear) {
+		id = fmt.Sprintf("%08d-58e8-4b6f-a2b4-4fda5b6b7c1e", len(s.orders))
+	}
+
+	linkID, _ := params["orderLinkId"].(string)
+	now := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond)+int64(len(s.orders)), 10)
+	order := bybitOrder{
+		OrderID:     id,
+		OrderLinkID: linkID,
+		Symbol:      params["symbol"].(string),
+		Side:        params["side"].(string),
+		OrderType:   params["orderType"].(string),
+		OrderStatus: "New",
+		Qty:         params["qty"].(string),
+		Price:       "0",
+		CreatedTime: now,
+		UpdatedTime: now,
+	}
+	if price, ok := params["price"]; ok {
+		order.Price = price.(string)
+		order.TimeInForce = params["timeInForce"].(string)
+	}
+	if trigger, ok := params["triggerPrice"]; ok {
+		order.TriggerPrice = trigger.(string)
+	}
+
+	// 没有触发价的市价单立即成交
+	if order.OrderType == "Market" && order.TriggerPrice == "" {
+		quantity, _ := strconv.ParseFloat(order.Qty, 64)
+		if params["marketUnit"] == "quoteCoin" {
+			quantity /= bybitTestPrice
+		}
+		order.OrderStatus = "Filled"
+		order.CumExecQty = strconv.FormatFloat(quantity, 'f', -1, 64)
+		order.CumExecValue = strconv.FormatFloat(quantity*bybitTestPrice, 'f', -1, 64)
+	}
+
+	s.orders = append(s.orders, order)
+	s.reply(w, 0, "OK", map[string]string{"orderId": order.OrderID, "orderLinkId": order.OrderLinkID})
+}
+
+// serveWs 第一次连接推送一根未完成的K线和一根完成的K线后断开，之后的连接推送一根完成的K线并保持连接。
+func (s *bybitStub) serveWs(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	require.NoError(s.t, err)
+	defer conn.Close()
+
+	var subscribe struct {
+		Op   string   `json:"op"`
+		Args []string `json:"args"`
+	}
+	require.NoError(s.t, conn.ReadJSON(&subscribe))
+	require.Equal(s.t, "subscribe", subscribe.Op)
+	topic := subscribe.Args[0]
+	if r.URL.Path != "/v5/public/"+s.category || topic != "kline.60.BTCUSDT" {
+		_ = conn.WriteJSON(map[string]interface{}{"op": "subscribe", "success": false, "ret_msg": "invalid topic"})
+		return
+	}
+	require.NoError(s.t, conn.WriteJSON(map[string]interface{}{"op": "subscribe", "success": true}))
+
+	s.mtx.Lock()
+	s.connections++
+	first := s.connections == 1
+	s.mtx.Unlock()
+
+	kline := func(start int64, close string, confirm bool) map[string]interface{} {
+		return map[string]interface{}{
+			"topic": topic,
+			"type":  "snapshot",
+			"data": []map[string]interface{}{{
+				"start": start, "open": "100", "close": close, "high": "110", "low": "90",
+				"volume": "5", "confirm": confirm, "timestamp": start + 1000,
+			}},
+		}
+	}
+
+	if first {
+		require.NoError(s.t, conn.WriteJSON(kline(1700000000000, "101", false)))
+		require.NoError(s.t, conn.WriteJSON(kline(1700000000000, "102", true)))
+		return
+	}
+
+	require.NoError(s.t, conn.WriteJSON(kline(1700003600000, "103", true)))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func newTestBybit(t *testing.T, server *httptest.Server, options ...BybitOption) *Bybit {
+	options = append([]BybitOption{
+		WithBybitCredentials(bybitTestKey, bybitTestSecret),
+		WithBybitEndpoint(server.URL, "ws"+strings.TrimPrefix(server.URL, "http")+"/v5/public"),
+	}, options...)
+	bybit, err := NewBybit(context.Background(), options...)
+	require.NoError(t, err)
+	return bybit
+}
+
+func TestBybit_Spot(t *testing.T) {
+	stub, server := newBybitStub(t, string(BybitCategorySpot))
+	bybit := newTestBybit(t, server)
+
+	t.Run("assets info", func(t *testing.T) {
+		info := bybit.AssetsInfo("BTCUSDT")
+		assert.Equal(t, "BTC", info.BaseAsset)
+		assert.Equal(t, "USDT", info.QuoteAsset)
+		assert.Equal(t, 0.000001, info.StepSize)
+		assert.Equal(t, 6, info.BaseAssetPrecision)
+		assert.Equal(t, 2, info.QuotePrecision)
+		assert.Equal(t, 0.01, info.MinPrice)
+		assert.Equal(t, "1.123456", bybit.formatQuantity("BTCUSDT", 1.1234567))
+
+		_, err := bybit.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 100, 1000)
+		require.ErrorIs(t, err, ErrInvalidQuantity)
+		_, err = bybit.CreateOrderLimit(model.SideTypeBuy, "ETHUSDT", 1, 1000)
+		require.ErrorIs(t, err, ErrInvalidAsset)
+	})
+
+	t.Run("candles", func(t *testing.T) {
+		candles, err := bybit.CandlesByLimit(context.Background(), "BTCUSDT", "1h", 2)
+		require.NoError(t, err)
+		require.Len(t, candles, 2)
+		assert.Equal(t, int64(1700000000), candles[0].Time.Unix())
+		assert.Equal(t, 101.0, candles[0].Close)
+		assert.Equal(t, 102.0, candles[1].Close)
+		assert.Equal(t, 11.0, candles[1].Volume)
+
+		candles, err = bybit.CandlesByPeriod(context.Background(), "BTCUSDT", "1h",
+			time.Unix(1700000000, 0), time.Unix(1700007200, 0))
+		require.NoError(t, err)
+		require.Len(t, candles, 3)
+
+		quote, err := bybit.LastQuote(context.Background(), "BTCUSDT")
+		require.NoError(t, err)
+		assert.Equal(t, 101.0, quote)
+
+		_, err = bybit.CandlesByLimit(context.Background(), "BTCUSDT", "10m", 2)
+		require.ErrorIs(t, err, ErrNotSupported)
+	})
+
+	t.Run("orders", func(t *testing.T) {
+		order, err := bybit.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 0.5)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeFilled, order.Status)
+		assert.Equal(t, model.OrderTypeMarket, order.Type)
+		assert.Equal(t, bybitTestPrice, order.Price)
+		assert.Equal(t, 0.5, order.Quantity)
+		assert.Equal(t, int64(1000), order.ExchangeID)
+
+		order, err = bybit.CreateOrderMarketQuote(model.SideTypeBuy, "BTCUSDT", 3000)
+		require.NoError(t, err)
+		assert.Equal(t, 0.1, order.Quantity)
+
+		limit, err := bybit.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 0.1, 25000.123,
+			model.WithClientOrderID("entry-1"), model.WithPostOnly())
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderTypeLimitMaker, limit.Type)
+		assert.Equal(t, model.OrderStatusTypeNew, limit.Status)
+		assert.Equal(t, "entry-1", limit.ClientOrderID)
+		assert.Equal(t, "25000.12", stub.orders[2].Price)
+		assert.Equal(t, "PostOnly", stub.orders[2].TimeInForce)
+
+		found, err := bybit.Order("BTCUSDT", limit.ExchangeID)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderTypeLimitMaker, found.Type)
+		assert.Equal(t, model.TimeInForceGTX, found.TimeInForce)
+		assert.Equal(t, 25000.12, found.Price)
+		assert.Equal(t, "entry-1", found.ClientOrderID)
+
+		stop, err := bybit.CreateOrderStop("BTCUSDT", 0.1, 20000)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderTypeStopLoss, stop.Type)
+		assert.Equal(t, "20000", stub.orders[3].TriggerPrice)
+
+		// 改单时撤销原订单并重新下单
+		replaced, err := bybit.ReplaceOrder(limit, 26000, 0.2)
+		require.NoError(t, err)
+		assert.Equal(t, limit.ExchangeID, *replaced.ReplacedID)
+		assert.Equal(t, 0.2, replaced.Quantity)
+
+		orders, err := bybit.Orders("BTCUSDT", 10)
+		require.NoError(t, err)
+		require.Len(t, orders, 5)
+		assert.Equal(t, int64(1000), orders[0].ExchangeID)
+		assert.Equal(t, replaced.ExchangeID, orders[4].ExchangeID)
+
+		// 撤销后的订单从历史订单中查询
+		found, err = bybit.Order("BTCUSDT", limit.ExchangeID)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeCanceled, found.Status)
+
+		err = bybit.Cancel(limit)
+		var bybitErr *BybitError
+		require.ErrorAs(t, err, &bybitErr)
+		require.ErrorIs(t, err, ErrOrderNotFound)
+
+		_, err = bybit.Order("BTCUSDT", 42)
+		require.ErrorIs(t, err, ErrOrderNotFound)
+
+		// 现货的订单ID就是ExchangeID，重启后直接查询
+		restarted := newTestBybit(t, server)
+		found, err = restarted.Order("BTCUSDT", stop.ExchangeID)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderTypeStopLoss, found.Type)
+
+		_, err = bybit.CreateOrderOCO(model.SideTypeSell, "BTCUSDT", 0.1, 40000, 20000, 19000)
+		require.ErrorIs(t, err, ErrNotSupported)
+	})
+
+	t.Run("account", func(t *testing.T) {
+		account, err := bybit.Account()
+		require.NoError(t, err)
+		require.Len(t, account.Balances, 2)
+		asset, quote := account.Balance("BTC", "USDT")
+		assert.Equal(t, 1.0, asset.Free)
+		assert.Equal(t, 9500.0, quote.Free)
+		assert.Equal(t, 500.0, quote.Lock)
+
+		asset2, quote2, err := bybit.Position("BTCUSDT")
+		require.NoError(t, err)
+		assert.Equal(t, 1.0, asset2)
+		assert.Equal(t, 10000.0, quote2)
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		_, err := NewBybit(context.Background(),
+			WithBybitCredentials(bybitTestKey, "wrong"),
+			WithBybitEndpoint(server.URL, ""))
+		var bybitErr *BybitError
+		require.ErrorAs(t, err, &bybitErr)
+		assert.Equal(t, 10004, bybitErr.Code)
+	})
+
+	t.Run("leverage on spot", func(t *testing.T) {
+		_, err := NewBybit(context.Background(),
+			WithBybitEndpoint(server.URL, ""),
+			WithBybitLeverage("btcusdt", 5))
+		require.ErrorIs(t, err, ErrNotSupported)
+	})
+}
+
+func TestBybit_Linear(t *testing.T) {
+	stub, server := newBybitStub(t, string(BybitCategoryLinear))
+	bybit := newTestBybit(t, server, WithBybitCategory(BybitCategoryLinear), WithBybitLeverage("btcusdt", 5))
+	assert.Equal(t, "5", stub.leverage)
+	assert.Equal(t, "ETH", bybit.AssetsInfo("ETHUSDT").BaseAsset)
+	assert.Equal(t, 3, bybit.AssetsInfo("BTCUSDT").BaseAssetPrecision)
+
+	// 杠杆没有变化时忽略交易所返回的错误
+	bybit = newTestBybit(t, server, WithBybitCategory(BybitCategoryLinear), WithBybitLeverage("BTCUSDT", 5))
+
+	order, err := bybit.CreateOrderMarket(model.SideTypeSell, "BTCUSDT", 0.5)
+	require.NoError(t, err)
+	assert.Equal(t, model.OrderStatusTypeFilled, order.Status)
+	assert.Equal(t, model.SideTypeSell, order.Side)
+	assert.Positive(t, order.ExchangeID)
+
+	limit, err := bybit.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 0.5, 25000)
+	require.NoError(t, err)
+	assert.Equal(t, model.TimeInForceGTC, model.TimeInForceType(stub.orders[1].TimeInForce))
+
+	_, err = bybit.CreateOrderStop("BTCUSDT", 0.5, 35000)
+	require.NoError(t, err)
+
+	// 没有指定ClientOrderID的订单使用本地生成的orderLinkId
+	assert.Equal(t, bybitLinkPrefix+strconv.FormatInt(limit.ExchangeID, 10), stub.orders[1].OrderLinkID)
+	assert.Empty(t, limit.ClientOrderID)
+
+	client, err := bybit.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 0.5, 24000, model.WithClientOrderID("entry-1"))
+	require.NoError(t, err)
+
+	// 新实例没有订单ID的对应关系，按orderLinkId找到原始的UUID
+	restarted := newTestBybit(t, server, WithBybitCategory(BybitCategoryLinear))
+	require.NoError(t, restarted.Cancel(limit))
+	found, err := restarted.Order("BTCUSDT", limit.ExchangeID)
+	require.NoError(t, err)
+	assert.Equal(t, model.OrderStatusTypeCanceled, found.Status)
+	assert.Equal(t, limit.ExchangeID, found.ExchangeID)
+	assert.Empty(t, found.ClientOrderID)
+
+	// 指定了ClientOrderID的订单通过列出最近的订单找到
+	restarted = newTestBybit(t, server, WithBybitCategory(BybitCategoryLinear))
+	found, err = restarted.Order("BTCUSDT", client.ExchangeID)
+	require.NoError(t, err)
+	assert.Equal(t, "entry-1", found.ClientOrderID)
+
+	// 找不到对应关系时不能当作订单不存在
+	_, err = restarted.Order("BTCUSDT", 42)
+	require.ErrorIs(t, err, ErrUnknownOrderID)
+	require.NotErrorIs(t, err, ErrOrderNotFound)
+
+	_, err = bybit.CreateOrderMarketQuote(model.SideTypeBuy, "BTCUSDT", 1000)
+	require.ErrorIs(t, err, ErrNotSupported)
+
+	account, err := bybit.Account()
+	require.NoError(t, err)
+	// 持仓排在钱包余额前面，空头持仓为负数
+	asset, quote := account.Balance("BTC", "USDT")
+	assert.Equal(t, -0.5, asset.Free)
+	assert.Equal(t, 5.0, asset.Leverage)
+	assert.Equal(t, 10000.0, quote.Free)
+}
+
+func TestBybit_CandlesSubscription(t *testing.T) {
+	_, server := newBybitStub(t, string(BybitCategorySpot))
+	bybit := newTestBybit(t, server, WithBybitMetadataFetcher(func(pair string, t time.Time) (string, float64) {
+		return "funding", 0.01
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	candles, errs := bybit.CandlesSubscription(ctx, "BTCUSDT", "1h")
+
+	candle := <-candles
+	assert.False(t, candle.Complete)
+	assert.Equal(t, 101.0, candle.Close)
+
+	candle = <-candles
+	assert.True(t, candle.Complete)
+	assert.Equal(t, 102.0, candle.Close)
+	assert.Equal(t, 0.01, candle.Metadata["funding"])
+
+	// 服务器断开连接后重新连接并继续推送
+	<-errs
+	candle = <-candles
+	assert.True(t, candle.Complete)
+	assert.Equal(t, 103.0, candle.Close)
+	assert.Equal(t, int64(1700003600), candle.Time.Unix())
+
+	t.Run("invalid interval", func(t *testing.T) {
+		_, errs := bybit.CandlesSubscription(ctx, "BTCUSDT", "10m")
+		require.ErrorIs(t, <-errs, ErrNotSupported)
+	})
+}
@@ -3,6 +3,8 @@ package exchange
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -47,6 +49,14 @@ func TestNewCSVFeed(t *testing.T) {
 		require.Equal(t, 86310.8, candle.Volume)
 		require.Equal(t, 1.1, candle.Metadata["lsr"])
 	})
+
+	t.Run("invalid price", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "invalid.csv")
+		require.NoError(t, os.WriteFile(file, []byte("1619395200,49066.76,54001.39,48753.44,54356.62,abc\n"), 0o644))
+
+		_, err := NewCSVFeed("1d", PairFeed{Timeframe: "1d", Pair: "BTCUSDT", File: file})
+		require.Error(t, err)
+	})
 }
 
 func TestCSVFeed_CandlesByLimit(t *testing.T) {
@@ -67,6 +77,25 @@ func TestCSVFeed_CandlesByLimit(t *testing.T) {
 	require.Equal(t, "2021-04-27 00:00:00", candle.Time.UTC().Format("2006-01-02 15:04:05"))
 }
 
+func TestCSVFeed_Between(t *testing.T) {
+	feed, err := NewCSVFeed("1d", PairFeed{
+		Timeframe: "1d",
+		Pair:      "BTCUSDT",
+		File:      "../testdata/btc-1d.csv",
+	})
+	require.NoError(t, err)
+
+	start := time.Date(2021, 4, 28, 0, 0, 0, 0, time.UTC)
+	feed.Between(start, start.AddDate(0, 0, 2))
+	candles := feed.CandlePairTimeFrame["BTCUSDT--1d"]
+	require.Len(t, candles, 3)
+	require.Equal(t, start, candles[0].Time)
+
+	// 零值时间不限制结束时间
+	feed.Between(start.AddDate(0, 0, 1), time.Time{})
+	require.Len(t, feed.CandlePairTimeFrame["BTCUSDT--1d"], 2)
+}
+
 func TestCSVFeed_resample(t *testing.T) {
 	t.Run("1h to 1d", func(t *testing.T) {
 		feed, err := NewCSVFeed(
@@ -0,0 +1,537 @@
+package exchange
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+// CSVTimeUnix 作为CSVSchema.TimeFormat时表示时间列是数字时间戳
+const CSVTimeUnix = "unix"
+
+// ErrUnknownCSVLayout 表示无法识别CSV文件的列，需要在CSVSchema中指定列名或表头
+var ErrUnknownCSVLayout = errors.New("unknown csv layout")
+
+/*
+CSVSchema 描述第三方CSV文件的格式，例如TradingView、Kaggle或其他交易所导出的数据。
+所有字段都是可选的，零值的字段会从文件中自动识别：
+
+  - 分隔符从第一行中出现次数最多的逗号、分号、制表符或竖线中选择
+  - 小数点默认为点，分隔符不是逗号并且数值中只出现逗号时使用逗号
+  - 列名不区分大小写并忽略空格和符号，"Open Time"、"open_time"和"opentime"相同，未指定时匹配常见的名称
+  - 数字时间戳按数量级识别为秒、毫秒、微秒或纳秒，时间字符串依次尝试ISO 8601等常见格式
+  - 没有表头的文件按列数识别ninjabot的6列格式和Binance的12列K线格式
+
+其他可以解析为数字的列和CSV的额外表头一样保存到Candle.Metadata。
+*/
+type CSVSchema struct {
+	Delimiter        rune           // Delimiter 字段分隔符
+	DecimalSeparator rune           // DecimalSeparator 小数点，使用逗号时数值中的点被当作千位分隔符
+	Header           []string       // Header 文件没有表头时每一列的名称
+	Time             string         // Time 开盘时间列
+	Open             string         // Open 开盘价列
+	Close            string         // Close 收盘价列
+	Low              string         // Low 最低价列
+	High             string         // High 最高价列
+	Volume           string         // Volume 成交量列
+	TimeFormat       string         // TimeFormat 时间的Go格式，如"2006-01-02 15:04:05"，CSVTimeUnix表示数字时间戳
+	TimeUnit         time.Duration  // TimeUnit 数字时间戳的单位
+	Location         *time.Location // Location 时间字符串没有时区时使用的时区，默认为UTC
+}
+
+// csvColumnNames 是自动识别时每个字段可以匹配的列名，列名已经去掉符号并转换为小写
+var csvColumnNames = map[string][]string{
+	"time":   {"time", "timestamp", "date", "datetime", "opentime", "timeopen", "starttime", "unix", "ts", "dateutc"},
+	"open":   {"open", "o", "openprice", "priceopen"},
+	"close":  {"close", "c", "closeprice", "priceclose", "last"},
+	"low":    {"low", "l", "lowprice", "pricelow"},
+	"high":   {"high", "h", "highprice", "pricehigh"},
+	"volume": {"volume", "vol", "v", "basevolume"},
+}
+
+// csvLayouts 是没有表头的文件按列数识别的格式
+var csvLayouts = map[int][]string{
+	6: {"time", "open", "close", "low", "high", "volume"},
+	12: {"open_time", "open", "high", "low", "close", "volume", "close_time", "quote_volume", "count",
+		"taker_buy_volume", "taker_buy_quote_volume", "ignore"},
+}
+
+// csvTimeLayouts 是自动识别时间字符串时依次尝试的格式
+var csvTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02 15:04:05",
+	"2006/01/02 15:04",
+	"2006/01/02",
+	"2006.01.02 15:04:05",
+	"2006.01.02 15:04",
+	"2006.01.02",
+	"02.01.2006 15:04:05",
+	"02.01.2006 15:04",
+	"02.01.2006",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// decimalCommaRegex 匹配使用逗号作为小数点的数值
+var decimalCommaRegex = regexp.MustCompile(`^-?\d+,\d+$`)
+
+// csvSampleRows 识别小数点时最多检查的数据行数
+const csvSampleRows = 20
+
+// DetectCSVSchema 读取CSV文件的开头识别文件格式，返回的CSVSchema填充了识别出的分隔符、小数点、列名和时间格式
+func DetectCSVSchema(file string, schema CSVSchema) (CSVSchema, error) {
+	source, err := openCSV(PairFeed{File: file, Schema: &schema})
+	if err != nil {
+		return CSVSchema{}, err
+	}
+	defer source.Close()
+	return source.parser.schema, nil
+}
+
+// csvParser 根据CSVSchema把CSV的数据行转换为K线
+type csvParser struct {
+	schema   CSVSchema
+	columns  [6]int   // columns 时间、开盘价、收盘价、最低价、最高价和成交量的列位置
+	metadata []string // metadata 附加列的名称
+	extra    []int    // extra 附加列的位置
+}
+
+// newCSVParser 根据表头和第一行数据确定每一列的位置和时间格式，header为空表示文件没有表头
+func newCSVParser(schema CSVSchema, header, sample []string) (*csvParser, error) {
+	if len(header) == 0 {
+		header = schema.Header
+	}
+	if len(header) == 0 {
+		header = csvLayouts[len(sample)]
+	}
+	if len(header) == 0 {
+		return nil, fmt.Errorf("%w: %d columns without header", ErrUnknownCSVLayout, len(sample))
+	}
+	if schema.DecimalSeparator == 0 {
+		schema.DecimalSeparator = '.'
+		if schema.Delimiter != ',' && hasDecimalComma([][]string{sample}) {
+			schema.DecimalSeparator = ','
+		}
+	}
+	if schema.Location == nil {
+		schema.Location = time.UTC
+	}
+	schema.Header = header
+
+	parser := &csvParser{schema: schema}
+	fields := []struct {
+		key  string
+		name *string
+	}{
+		{"time", &parser.schema.Time},
+		{"open", &parser.schema.Open},
+		{"close", &parser.schema.Close},
+		{"low", &parser.schema.Low},
+		{"high", &parser.schema.High},
+		{"volume", &parser.schema.Volume},
+	}
+
+	used := make(map[int]bool)
+	for i, field := range fields {
+		index := findCSVColumn(header, *field.name, csvColumnNames[field.key], used)
+		if index < 0 {
+			name := *field.name
+			if name == "" {
+				name = field.key
+			}
+			return nil, fmt.Errorf("%w: column %s not found in %v", ErrUnknownCSVLayout, name, header)
+		}
+		*field.name = header[index]
+		parser.columns[i] = index
+		used[index] = true
+	}
+
+	if len(sample) <= parser.columns[0] {
+		return nil, fmt.Errorf("%w: expected %d columns, got %d", ErrUnknownCSVLayout, len(header), len(sample))
+	}
+	if parser.schema.TimeFormat == "" {
+		parser.schema.TimeFormat = detectTimeFormat(strings.TrimSpace(sample[parser.columns[0]]),
+			parser.schema.Location)
+		if parser.schema.TimeFormat == "" {
+			return nil, fmt.Errorf("%w: unknown time format %q", ErrUnknownCSVLayout, sample[parser.columns[0]])
+		}
+	}
+
+	// 其他可以解析为数字的列保存到Metadata
+	for index, name := range header {
+		if used[index] || index >= len(sample) {
+			continue
+		}
+		if _, err := parser.parseFloat(sample[index]); err == nil {
+			parser.metadata = append(parser.metadata, name)
+			parser.extra = append(parser.extra, index)
+		}
+	}
+	return parser, nil
+}
+
+// findCSVColumn 查找列的位置，name不为空时只匹配name，否则依次匹配常见的列名，最后匹配以常见列名开头的列
+func findCSVColumn(header []string, name string, candidates []string, used map[int]bool) int {
+	if name != "" {
+		candidates = []string{normalizeCSVColumn(name)}
+	}
+
+	normalized := make([]string, len(header))
+	for i, column := range header {
+		normalized[i] = normalizeCSVColumn(column)
+	}
+
+	for _, candidate := range candidates {
+		for i, column := range normalized {
+			if !used[i] && column == candidate {
+				return i
+			}
+		}
+	}
+
+	// 例如"Volume_(BTC)"和"Volume USDT"
+	if name == "" {
+		for _, candidate := range candidates {
+			if len(candidate) < 3 {
+				continue
+			}
+			for i, column := range normalized {
+				if !used[i] && strings.HasPrefix(column, candidate) {
+					return i
+				}
+			}
+		}
+	}
+	return -1
+}
+
+// normalizeCSVColumn 把列名转换为小写并去掉空格和符号
+func normalizeCSVColumn(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// detectTimeFormat 根据第一个时间值识别时间格式，无法识别时返回空字符串
+func detectTimeFormat(value string, location *time.Location) string {
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return CSVTimeUnix
+	}
+	for _, layout := range csvTimeLayouts {
+		if _, err := time.ParseInLocation(layout, value, location); err == nil {
+			return layout
+		}
+	}
+	return ""
+}
+
+// parse 把一行数据转换为K线
+func (p *csvParser) parse(pair string, line []string) (model.Candle, error) {
+	if len(line) < len(p.schema.Header) {
+		return model.Candle{}, fmt.Errorf("expected %d columns, got %d: %v", len(p.schema.Header), len(line), line)
+	}
+
+	timestamp, err := p.parseTime(line[p.columns[0]])
+	if err != nil {
+		return model.Candle{}, err
+	}
+
+	candle := model.Candle{
+		Pair:      pair,
+		Time:      timestamp,
+		UpdatedAt: timestamp,
+		Complete:  true,
+	}
+	for i, value := range []*float64{&candle.Open, &candle.Close, &candle.Low, &candle.High, &candle.Volume} {
+		if *value, err = p.parseFloat(line[p.columns[i+1]]); err != nil {
+			return model.Candle{}, err
+		}
+	}
+
+	if len(p.metadata) > 0 {
+		candle.Metadata = make(map[string]float64, len(p.metadata))
+		for i, name := range p.metadata {
+			if candle.Metadata[name], err = p.parseFloat(line[p.extra[i]]); err != nil {
+				return model.Candle{}, err
+			}
+		}
+	}
+	return candle, nil
+}
+
+// parseFloat 按小数点的设置解析数值
+func (p *csvParser) parseFloat(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if p.schema.DecimalSeparator != '.' {
+		value = strings.ReplaceAll(value, ".", "")
+		value = strings.ReplaceAll(value, string(p.schema.DecimalSeparator), ".")
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// parseTime 按时间格式解析时间，数字时间戳没有指定单位时按数量级识别
+func (p *csvParser) parseTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if p.schema.TimeFormat != CSVTimeUnix {
+		t, err := time.ParseInLocation(p.schema.TimeFormat, value, p.schema.Location)
+		return t.UTC(), err
+	}
+	return parseUnixTime(value, p.schema.TimeUnit)
+}
+
+// parseUnixTime 解析数字时间戳，unit为0时按数量级识别秒、毫秒、微秒和纳秒
+func parseUnixTime(value string, unit time.Duration) (time.Time, error) {
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if unit == 0 {
+		switch abs := math.Abs(number); {
+		case abs < 1e11:
+			unit = time.Second
+		case abs < 1e14:
+			unit = time.Millisecond
+		case abs < 1e17:
+			unit = time.Microsecond
+		default:
+			unit = time.Nanosecond
+		}
+	}
+
+	// 整数时间戳直接计算，避免浮点数的精度误差
+	if integer, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(0, integer*int64(unit)).UTC(), nil
+	}
+	return time.Unix(0, int64(number*float64(unit))).UTC(), nil
+}
+
+// csvSource 是一个打开的CSV文件，按PairFeed的配置解析每一行
+type csvSource struct {
+	file     *os.File
+	reader   *csv.Reader
+	parser   *csvParser // parser 使用CSVSchema时的解析器，为空时使用ninjabot的CSV格式
+	metadata []string   // metadata 附加列的名称
+	first    []string   // first 已经读取但还没有返回的第一行数据
+
+	// 没有CSVSchema时的表头信息，与parseHeaders的返回值相同
+	headerMap         map[string]int
+	additionalHeaders []string
+	hasCustomHeaders  bool
+}
+
+// openCSV 打开CSV文件并读取表头和第一行数据。feed.Schema为空时使用ninjabot的CSV格式，
+// 否则按CSVSchema识别分隔符、表头和列的位置。文件开头的UTF-8 BOM会被忽略。
+func openCSV(feed PairFeed) (*csvSource, error) {
+	file, err := os.Open(feed.File)
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := newCSVSource(file, feed)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", feed.File, err)
+	}
+	return source, nil
+}
+
+func newCSVSource(file *os.File, feed PairFeed) (*csvSource, error) {
+	buffered := bufio.NewReader(file)
+	if bom, _ := buffered.Peek(3); bytes.Equal(bom, []byte{0xEF, 0xBB, 0xBF}) {
+		_, _ = buffered.Discard(3)
+	}
+
+	source := &csvSource{file: file, reader: csv.NewReader(buffered)}
+	source.reader.ReuseRecord = true
+
+	var schema CSVSchema
+	if feed.Schema != nil {
+		schema = *feed.Schema
+		if schema.Delimiter == 0 {
+			schema.Delimiter = detectDelimiter(buffered)
+		}
+		source.reader.Comma = schema.Delimiter
+		source.reader.FieldsPerRecord = -1
+		source.reader.TrimLeadingSpace = true
+	}
+
+	line, err := source.reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: empty file", ErrInsufficientData)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var header []string
+	if feed.Schema == nil {
+		source.headerMap, source.additionalHeaders, source.hasCustomHeaders = parseHeaders(line)
+		source.metadata = source.additionalHeaders
+		if !source.hasCustomHeaders {
+			source.first = line
+			return source, nil
+		}
+	} else if len(schema.Header) > 0 || !isCSVHeader(line, schema) {
+		source.first = line
+	} else {
+		header = append([]string(nil), line...)
+	}
+
+	if source.first == nil {
+		if line, err = source.reader.Read(); err == io.EOF {
+			return nil, fmt.Errorf("%w: empty file", ErrInsufficientData)
+		} else if err != nil {
+			return nil, err
+		}
+		source.first = line
+	}
+
+	if feed.Schema != nil {
+		// 第一行的数值可能都是整数，小数点需要参考后面的多行数据
+		if schema.DecimalSeparator == 0 && schema.Delimiter != ',' {
+			rows := append([][]string{source.first}, peekCSVRows(buffered, schema.Delimiter)...)
+			if hasDecimalComma(rows) {
+				schema.DecimalSeparator = ','
+			}
+		}
+		if source.parser, err = newCSVParser(schema, header, source.first); err != nil {
+			return nil, err
+		}
+		source.metadata = source.parser.metadata
+	}
+	return source, nil
+}
+
+// peekCSVRows 读取缓冲区中还没有解析的最多csvSampleRows行数据，不会移动读取位置
+func peekCSVRows(reader *bufio.Reader, delimiter rune) [][]string {
+	sample, _ := reader.Peek(4096)
+	// 最后一行可能被截断，只使用完整的行
+	if index := bytes.LastIndexByte(sample, '\n'); index >= 0 {
+		sample = sample[:index+1]
+	} else {
+		return nil
+	}
+
+	parser := csv.NewReader(bytes.NewReader(sample))
+	parser.Comma = delimiter
+	parser.FieldsPerRecord = -1
+	parser.TrimLeadingSpace = true
+	parser.LazyQuotes = true
+
+	var rows [][]string
+	for len(rows) < csvSampleRows {
+		line, err := parser.Read()
+		if err != nil {
+			break
+		}
+		rows = append(rows, line)
+	}
+	return rows
+}
+
+// hasDecimalComma 判断数据行中是否有使用逗号作为小数点的数值
+func hasDecimalComma(rows [][]string) bool {
+	for _, line := range rows {
+		for _, value := range line {
+			if decimalCommaRegex.MatchString(strings.TrimSpace(value)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// detectDelimiter 从第一行中选择出现次数最多的分隔符，默认为逗号
+func detectDelimiter(reader *bufio.Reader) rune {
+	sample, _ := reader.Peek(4096)
+	if index := bytes.IndexByte(sample, '\n'); index >= 0 {
+		sample = sample[:index]
+	}
+
+	delimiter, count := ',', 0
+	for _, candidate := range []rune{',', ';', '\t', '|'} {
+		if n := bytes.Count(sample, []byte(string(candidate))); n > count {
+			delimiter, count = candidate, n
+		}
+	}
+	return delimiter
+}
+
+// isCSVHeader 判断第一行是否是表头：有字段既不是数字也不是可以识别的时间
+func isCSVHeader(line []string, schema CSVSchema) bool {
+	location := schema.Location
+	if location == nil {
+		location = time.UTC
+	}
+	parser := &csvParser{schema: schema}
+	if parser.schema.DecimalSeparator == 0 {
+		parser.schema.DecimalSeparator = '.'
+	}
+
+	for _, value := range line {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if _, err := parser.parseFloat(value); err == nil {
+			continue
+		}
+		if _, err := strconv.ParseFloat(strings.ReplaceAll(value, ",", "."), 64); err == nil {
+			continue
+		}
+		if schema.TimeFormat != "" && schema.TimeFormat != CSVTimeUnix {
+			if _, err := time.ParseInLocation(schema.TimeFormat, value, location); err == nil {
+				continue
+			}
+		}
+		if detectTimeFormat(value, location) != "" {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// next 返回下一行数据转换后的K线，没有更多数据时返回io.EOF
+func (s *csvSource) next(pair string) (model.Candle, error) {
+	line := s.first
+	s.first = nil
+	if line == nil {
+		var err error
+		if line, err = s.reader.Read(); err != nil {
+			return model.Candle{}, err
+		}
+	}
+
+	if s.parser != nil {
+		return s.parser.parse(pair, line)
+	}
+	return parseCSVLine(pair, line, s.headerMap, s.additionalHeaders, s.hasCustomHeaders)
+}
+
+// Close 关闭文件
+func (s *csvSource) Close() error {
+	return s.file.Close()
+}
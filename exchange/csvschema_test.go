@@ -0,0 +1,146 @@
+package exchange
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSVSchema(t *testing.T) {
+	writeFile := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "candles.csv")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tt := []struct {
+		name     string
+		content  string
+		schema   CSVSchema
+		volume   float64
+		metadata map[string]float64
+		location *time.Location
+	}{
+		{
+			name: "tradingview",
+			content: "time,open,high,low,close,Volume,RSI\n" +
+				"1609459200,10,12,9,11,100,55.5\n" +
+				"1609462800,11,13,10,12,200,60\n",
+			volume:   100,
+			metadata: map[string]float64{"RSI": 55.5},
+		},
+		{
+			name: "kaggle",
+			content: "\xEF\xBB\xBFTimestamp,Open,High,Low,Close,Volume_(BTC),Volume_(Currency),Weighted_Price\n" +
+				"1609459200.0,10,12,9,11,100,1000,10.5\n" +
+				"1609462800.0,11,13,10,12,200,2000,11.5\n",
+			volume:   100,
+			metadata: map[string]float64{"Volume_(Currency)": 1000, "Weighted_Price": 10.5},
+		},
+		{
+			name: "semicolon and decimal comma",
+			content: "Datum;Eröffnung;Hoch;Tief;Schluss;Umsatz\n" +
+				"01.01.2021 00:00:00;10,0;12,0;9,0;11,0;1.000,5\n" +
+				"01.01.2021 01:00:00;11,0;13,0;10,0;12,0;2.000,5\n",
+			schema: CSVSchema{Time: "Datum", Open: "Eröffnung", High: "Hoch", Low: "Tief", Close: "Schluss",
+				Volume: "Umsatz"},
+			volume: 1000.5,
+		},
+		{
+			name: "decimal comma after integer rows",
+			content: "time;open;high;low;close;volume\n" +
+				"1609459200;10;12;9;11;100\n" +
+				"1609462800;11;13;10;12;200,5\n",
+			volume: 100,
+		},
+		{
+			name: "iso dates with timezone",
+			content: "date\topen\thigh\tlow\tclose\tvolume\n" +
+				"2021-01-01 01:00\t10\t12\t9\t11\t100\n" +
+				"2021-01-01 02:00\t11\t13\t10\t12\t200\n",
+			volume:   100,
+			location: time.FixedZone("CET", 3600),
+		},
+		{
+			name: "binance without header",
+			content: "1609459200000,10,12,9,11,100,1609462799999,1000,10,50,500,0\n" +
+				"1609462800000,11,13,10,12,200,1609466399999,2000,20,100,1000,0\n",
+			volume:   100,
+			metadata: map[string]float64{"quote_volume": 1000, "count": 10, "taker_buy_volume": 50},
+		},
+		{
+			name: "explicit header and unit",
+			content: "1609459200000000|10|11|9|12|100\n" +
+				"1609462800000000|11|12|10|13|200\n",
+			schema: CSVSchema{
+				Delimiter:  '|',
+				Header:     []string{"ts", "o", "c", "l", "h", "v"},
+				TimeFormat: CSVTimeUnix,
+				TimeUnit:   time.Microsecond,
+			},
+			volume: 100,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			schema := tc.schema
+			schema.Location = tc.location
+			feed := PairFeed{Pair: "BTCUSDT", File: writeFile(t, tc.content), Timeframe: "1h", Schema: &schema}
+
+			csvFeed, err := NewCSVFeed("1h", feed)
+			require.NoError(t, err)
+
+			candles := csvFeed.CandlePairTimeFrame["BTCUSDT--1h"]
+			require.Len(t, candles, 2)
+			assert.Equal(t, start, candles[0].Time)
+			assert.Equal(t, start.Add(time.Hour), candles[1].Time)
+			assert.Equal(t, 10.0, candles[0].Open)
+			assert.Equal(t, 11.0, candles[0].Close)
+			assert.Equal(t, 9.0, candles[0].Low)
+			assert.Equal(t, 12.0, candles[0].High)
+			assert.Equal(t, 12.0, candles[1].Close)
+			assert.Equal(t, tc.volume, candles[0].Volume)
+			for name, value := range tc.metadata {
+				assert.Equal(t, value, candles[0].Metadata[name], name)
+			}
+
+			// 流式数据源和二进制转换使用相同的解析
+			streamFeed, err := NewCSVStreamFeed("1h", feed)
+			require.NoError(t, err)
+			streamed, err := collectCandles(streamFeed.CandlesSubscription(context.Background(), "BTCUSDT", "1h"))
+			require.NoError(t, err)
+			assert.Equal(t, candles, streamed)
+
+			output := filepath.Join(t.TempDir(), "candles.bin")
+			require.NoError(t, ConvertCSVFeedToBinary(feed, output))
+		})
+	}
+
+	t.Run("detect schema", func(t *testing.T) {
+		file := writeFile(t, "Date;Open;High;Low;Close;Vol\n2021-01-01;1,5;2,5;1,0;2,0;10\n")
+		schema, err := DetectCSVSchema(file, CSVSchema{})
+		require.NoError(t, err)
+		assert.Equal(t, ';', schema.Delimiter)
+		assert.Equal(t, ',', schema.DecimalSeparator)
+		assert.Equal(t, "Date", schema.Time)
+		assert.Equal(t, "Vol", schema.Volume)
+		assert.Equal(t, "2006-01-02", schema.TimeFormat)
+	})
+
+	t.Run("unknown layout", func(t *testing.T) {
+		file := writeFile(t, "1609459200,10,12,9\n")
+		_, err := NewCSVFeed("1h", PairFeed{Pair: "BTCUSDT", File: file, Timeframe: "1h", Schema: &CSVSchema{}})
+		require.ErrorIs(t, err, ErrUnknownCSVLayout)
+
+		file = writeFile(t, "when,open,high,low,close,volume\n1609459200,10,12,9,11,100\n")
+		_, err = NewCSVFeed("1h", PairFeed{Pair: "BTCUSDT", File: file, Timeframe: "1h", Schema: &CSVSchema{}})
+		require.ErrorIs(t, err, ErrUnknownCSVLayout)
+	})
+}
@@ -0,0 +1,370 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/tools/log"
+)
+
+// errStopStream 在读取到需要的数据后提前结束读取文件
+var errStopStream = errors.New("stop stream")
+
+/*
+CSVStreamFeed 是按需读取CSV文件的数据源，适合多年的1分钟K线、几十个交易对这样的大数据量回测。
+
+与CSVFeed在创建时把所有交易对和时间周期的K线读入内存不同，CSVStreamFeed只在创建时检查文件和表头，
+每次订阅或者查询时才逐行读取文件，并在读取的同时重采样到需要的时间周期。CandlesSubscription使用无缓冲的通道，
+读取速度跟随回测的处理速度，内存占用与文件大小无关。多个交易对按时间合并由DataFeedSubscription完成。
+*/
+type CSVStreamFeed struct {
+	streamFeed
+	Feeds           map[string]PairFeed // Feeds 每个交易对的CSV文件配置，键为交易对
+	targetTimeframe string              // targetTimeframe 目标时间周期，与NewCSVFeed的参数一致
+}
+
+// NewCSVStreamFeed 创建按需读取CSV文件的数据源，只检查文件是否可以读取、表头和时间周期是否有效，不会读取全部数据
+func NewCSVStreamFeed(targetTimeframe string, feeds ...PairFeed) (*CSVStreamFeed, error) {
+	feed := &CSVStreamFeed{
+		Feeds:           make(map[string]PairFeed),
+		targetTimeframe: targetTimeframe,
+	}
+	feed.streamFeed = newStreamFeed(feed.stream)
+
+	for _, pairFeed := range feeds {
+		if _, err := isLastCandlePeriod(time.Time{}, pairFeed.Timeframe, targetTimeframe); err != nil {
+			return nil, err
+		}
+
+		// 读取第一根K线检查文件格式
+		feed.Feeds[pairFeed.Pair] = pairFeed
+		err := feed.stream(context.Background(), pairFeed.Pair, pairFeed.Timeframe, time.Time{},
+			func(model.Candle) error {
+				return errStopStream
+			})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", pairFeed.File, err)
+		}
+
+		if pairFeed.Validation != nil {
+			if err := feed.validate(pairFeed); err != nil {
+				return nil, fmt.Errorf("%s: %w", pairFeed.File, err)
+			}
+		}
+	}
+
+	return feed, nil
+}
+
+// validate 读取整个文件校验源K线，结果保存到Reports
+func (c *CSVStreamFeed) validate(feed PairFeed) error {
+	source, err := openCSV(feed)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	report, err := validateStream(feed, source.candles(feed.Pair))
+	c.Reports[feed.Pair] = report
+	return err
+}
+
+// stream 逐行读取交易对的CSV文件，CSV文件无法按时间定位，start之前的K线同样会被读取
+func (c *CSVStreamFeed) stream(ctx context.Context, pair, timeframe string, _ time.Time,
+	fn func(model.Candle) error) error {
+
+	feed, ok := c.Feeds[pair]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrInsufficientData, pair)
+	}
+
+	source, err := openCSV(feed)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	return pipeCandles(ctx, feed, timeframe, source.candles(feed.Pair), fn)
+}
+
+// candles 返回按顺序读取源K线的函数，用于pipeCandles
+func (s *csvSource) candles(pair string) func() (model.Candle, bool, error) {
+	return func() (model.Candle, bool, error) {
+		candle, err := s.next(pair)
+		if err == io.EOF {
+			return model.Candle{}, false, nil
+		}
+		return candle, err == nil, err
+	}
+}
+
+/*
+streamFeed 是按需读取K线的数据源的公共部分，CSVStreamFeed和BinaryFeed只需要提供按时间顺序读取K线的stream函数。
+stream读取交易对重采样到timeframe后的K线并依次调用fn，start之前的K线可以跳过也可以照常返回；
+fn返回errStopStream时stream提前结束并返回nil。
+*/
+type streamFeed struct {
+	stream func(ctx context.Context, pair, timeframe string, start time.Time, fn func(model.Candle) error) error
+
+	// Reports 配置了Validation的交易对的校验结果，以交易对名称为键。创建数据源时会完整读取一次这些交易对的数据，
+	// 之后每次读取时按相同的配置修复
+	Reports map[string]ValidationReport
+
+	mtx      sync.Mutex
+	consumed map[string]int // consumed CandlesByLimit已经取走的K线数量，键为交易对和时间周期
+}
+
+func newStreamFeed(stream func(ctx context.Context, pair, timeframe string, start time.Time,
+	fn func(model.Candle) error) error) streamFeed {
+	return streamFeed{stream: stream, Reports: make(map[string]ValidationReport), consumed: make(map[string]int)}
+}
+
+// AssetsInfo 返回交易对的资产信息，与CSVFeed相同
+func (s *streamFeed) AssetsInfo(pair string) model.AssetInfo {
+	return CSVFeed{}.AssetsInfo(pair)
+}
+
+// LastQuote 文件数据源没有实时报价
+func (s *streamFeed) LastQuote(_ context.Context, _ string) (float64, error) {
+	return 0, errors.New("invalid operation")
+}
+
+// CandlesByPeriod 读取开盘时间在[start, end]范围内的K线，读取到end之后的K线时停止读取文件，end为零值时读取到文件末尾
+func (s *streamFeed) CandlesByPeriod(ctx context.Context, pair, timeframe string,
+	start, end time.Time) ([]model.Candle, error) {
+
+	candles := make([]model.Candle, 0)
+	err := s.stream(ctx, pair, timeframe, start, func(candle model.Candle) error {
+		if !end.IsZero() && candle.Time.After(end) {
+			return errStopStream
+		}
+		if !candle.Time.Before(start) {
+			candles = append(candles, candle)
+		}
+		return nil
+	})
+	return candles, err
+}
+
+// CandlesByLimit 返回下一批limit根K线，与CSVFeed一样，取走的K线不会再出现在CandlesSubscription中
+func (s *streamFeed) CandlesByLimit(ctx context.Context, pair, timeframe string,
+	limit int) ([]model.Candle, error) {
+
+	key := CSVFeed{}.feedTimeframeKey(pair, timeframe)
+	s.mtx.Lock()
+	skip := s.consumed[key]
+	s.mtx.Unlock()
+
+	candles := make([]model.Candle, 0, limit)
+	index := 0
+	err := s.stream(ctx, pair, timeframe, time.Time{}, func(candle model.Candle) error {
+		if index++; index <= skip {
+			return nil
+		}
+		if candles = append(candles, candle); len(candles) == limit {
+			return errStopStream
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(candles) < limit {
+		return nil, fmt.Errorf("%w: %s", ErrInsufficientData, pair)
+	}
+
+	s.mtx.Lock()
+	s.consumed[key] = skip + limit
+	s.mtx.Unlock()
+	return candles, nil
+}
+
+// CandlesSubscription 按顺序读取并发送K线，所有K线发送完后关闭通道。
+// 通道没有缓冲，接收方处理完一根K线后才会读取下一根，ctx取消时停止读取。
+func (s *streamFeed) CandlesSubscription(ctx context.Context, pair, timeframe string) (chan model.Candle,
+	chan error) {
+
+	ccandle := make(chan model.Candle)
+	cerr := make(chan error)
+
+	go func() {
+		defer close(cerr)
+		defer close(ccandle)
+
+		key := CSVFeed{}.feedTimeframeKey(pair, timeframe)
+		s.mtx.Lock()
+		skip := s.consumed[key]
+		s.mtx.Unlock()
+
+		index := 0
+		err := s.stream(ctx, pair, timeframe, time.Time{}, func(candle model.Candle) error {
+			if index++; index <= skip {
+				return nil
+			}
+			select {
+			case ccandle <- candle:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+
+		if err != nil && !errors.Is(err, context.Canceled) {
+			select {
+			case cerr <- err:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return ccandle, cerr
+}
+
+// pipeCandles 依次读取next返回的源K线，按配置校验修复、转换为Heikin Ashi并重采样到timeframe后调用fn。
+// next没有更多K线时返回false，fn返回errStopStream时提前结束并返回nil。
+func pipeCandles(ctx context.Context, feed PairFeed, timeframe string, next func() (model.Candle, bool, error),
+	fn func(model.Candle) error) error {
+
+	var validator *candleValidator
+	if feed.Validation != nil {
+		// Strict在创建数据源时已经检查过
+		options := *feed.Validation
+		options.Strict = false
+		var err error
+		if validator, err = newCandleValidator(feed.Pair, feed.Timeframe, options); err != nil {
+			return err
+		}
+	}
+
+	ha := model.NewHeikinAshi()
+	source, err := NewResampler(feed.Timeframe, timeframe, feed.Alignment)
+	if err != nil {
+		return err
+	}
+	resampler := &candleResampler{resampler: source}
+	emit := func(candle model.Candle) error {
+		if feed.HeikinAshi {
+			candle = candle.ToHeikinAshi(ha)
+		}
+		if candle, ok := resampler.push(candle); ok {
+			return fn(candle)
+		}
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		candle, ok, err := next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+
+		if validator == nil {
+			err = emit(candle)
+		} else {
+			for _, candle := range validator.push(candle) {
+				if err = emit(candle); err != nil {
+					break
+				}
+			}
+		}
+		if errors.Is(err, errStopStream) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if validator != nil {
+		for _, candle := range validator.finish() {
+			if err := emit(candle); errors.Is(err, errStopStream) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+
+	if candle, ok := resampler.flush(); ok {
+		if err := fn(candle); err != nil && !errors.Is(err, errStopStream) {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateStream 读取交易对的全部源K线并按feed.Validation校验，返回校验结果，Strict时没有通过校验返回错误
+func validateStream(feed PairFeed, next func() (model.Candle, bool, error)) (ValidationReport, error) {
+	validator, err := newCandleValidator(feed.Pair, feed.Timeframe, *feed.Validation)
+	if err != nil {
+		return ValidationReport{}, err
+	}
+	for {
+		candle, ok, err := next()
+		if err != nil {
+			return ValidationReport{}, err
+		}
+		if !ok {
+			break
+		}
+		validator.push(candle)
+	}
+	validator.finish()
+
+	if len(validator.report.Issues) > 0 {
+		log.Warnf("[VALIDATION] %s %s: %s", feed.Pair, feed.Timeframe, validator.report.summary())
+	}
+	return validator.report, validator.err()
+}
+
+/*
+candleResampler 在读取的同时把K线从源时间周期重采样到目标时间周期，结果与CSVFeed.resample相同：
+跳过第一个完整周期之前的K线，周期内的每根K线都会输出一根不完整的合并K线，周期的最后一根K线标记为完整，
+数据末尾不完整的周期被丢弃。因为需要知道是否还有下一根K线才能决定是否丢弃，输出比输入晚一根K线。
+*/
+type candleResampler struct {
+	resampler *Resampler
+	pending   *model.Candle // pending 已经处理但还没有输出的K线
+}
+
+// push 处理一根源K线，返回上一根处理好的K线
+func (r *candleResampler) push(candle model.Candle) (model.Candle, bool) {
+	candle, ok := r.resampler.Update(candle)
+	if !ok {
+		return model.Candle{}, false
+	}
+	if r.resampler.same {
+		candle.Complete = true
+	}
+
+	var (
+		result model.Candle
+		ready  bool
+	)
+	if r.pending != nil {
+		result, ready = *r.pending, true
+	}
+	r.pending = &candle
+	return result, ready
+}
+
+// flush 返回最后一根K线，不完整的周期被丢弃
+func (r *candleResampler) flush() (model.Candle, bool) {
+	if r.pending == nil || !r.pending.Complete {
+		return model.Candle{}, false
+	}
+	candle := *r.pending
+	r.pending = nil
+	return candle, true
+}
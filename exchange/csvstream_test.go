@@ -0,0 +1,172 @@
+package exchange
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+)
+
+// collectCandles 读取订阅的所有K线，返回第一个错误
+func collectCandles(ccandle chan model.Candle, cerr chan error) ([]model.Candle, error) {
+	var candles []model.Candle
+	for candle := range ccandle {
+		candles = append(candles, candle)
+	}
+	for err := range cerr {
+		return candles, err
+	}
+	return candles, nil
+}
+
+func TestCSVStreamFeed(t *testing.T) {
+	ctx := context.Background()
+	feeds := []PairFeed{
+		{Pair: "BTCUSDT", File: "../testdata/btc-1h.csv", Timeframe: "1h"},
+		{Pair: "ETHUSDT", File: "../testdata/eth-1h.csv", Timeframe: "1h", HeikinAshi: true},
+	}
+
+	t.Run("same candles as csv feed", func(t *testing.T) {
+		csvFeed, err := NewCSVFeed("4h", feeds...)
+		require.NoError(t, err)
+		streamFeed, err := NewCSVStreamFeed("4h", feeds...)
+		require.NoError(t, err)
+
+		for _, pair := range []string{"BTCUSDT", "ETHUSDT"} {
+			for _, timeframe := range []string{"1h", "4h"} {
+				expected, err := collectCandles(csvFeed.CandlesSubscription(ctx, pair, timeframe))
+				require.NoError(t, err)
+				actual, err := collectCandles(streamFeed.CandlesSubscription(ctx, pair, timeframe))
+				require.NoError(t, err)
+				require.NotEmpty(t, actual)
+				require.Equal(t, expected, actual, "%s %s", pair, timeframe)
+			}
+		}
+	})
+
+	t.Run("custom headers", func(t *testing.T) {
+		feed := PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1d-header.csv", Timeframe: "1d"}
+		csvFeed, err := NewCSVFeed("1d", feed)
+		require.NoError(t, err)
+		streamFeed, err := NewCSVStreamFeed("1d", feed)
+		require.NoError(t, err)
+
+		expected, err := collectCandles(csvFeed.CandlesSubscription(ctx, "BTCUSDT", "1d"))
+		require.NoError(t, err)
+		actual, err := collectCandles(streamFeed.CandlesSubscription(ctx, "BTCUSDT", "1d"))
+		require.NoError(t, err)
+		require.Equal(t, expected, actual)
+		assert.Equal(t, 1.1, actual[0].Metadata["lsr"])
+	})
+
+	t.Run("by period and limit", func(t *testing.T) {
+		csvFeed, err := NewCSVFeed("1d", feeds[0])
+		require.NoError(t, err)
+		feed, err := NewCSVStreamFeed("1d", feeds[0])
+		require.NoError(t, err)
+
+		start := time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC)
+		expected, err := csvFeed.CandlesByPeriod(ctx, "BTCUSDT", "1d", start, start.AddDate(0, 0, 2))
+		require.NoError(t, err)
+		candles, err := feed.CandlesByPeriod(ctx, "BTCUSDT", "1d", start, start.AddDate(0, 0, 2))
+		require.NoError(t, err)
+		require.NotEmpty(t, candles)
+		assert.Equal(t, expected, candles)
+		assert.Equal(t, start, candles[0].Time)
+
+		all, err := collectCandles(feed.CandlesSubscription(ctx, "BTCUSDT", "1d"))
+		require.NoError(t, err)
+		limit, err := feed.CandlesByLimit(ctx, "BTCUSDT", "1d", 2)
+		require.NoError(t, err)
+		assert.Equal(t, all[:2], limit)
+
+		// 已经取走的K线不会再出现在订阅中
+		rest, err := collectCandles(feed.CandlesSubscription(ctx, "BTCUSDT", "1d"))
+		require.NoError(t, err)
+		assert.Equal(t, all[2:], rest)
+
+		_, err = feed.CandlesByLimit(ctx, "BTCUSDT", "1d", len(all))
+		require.ErrorIs(t, err, ErrInsufficientData)
+		_, err = feed.CandlesByLimit(ctx, "ETHUSDT", "1d", 1)
+		require.ErrorIs(t, err, ErrInsufficientData)
+	})
+
+	t.Run("cancel subscription", func(t *testing.T) {
+		feed, err := NewCSVStreamFeed("1h", feeds[0])
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(ctx)
+		ccandle, cerr := feed.CandlesSubscription(ctx, "BTCUSDT", "1h")
+		<-ccandle
+		cancel()
+		for range ccandle {
+		}
+		for err := range cerr {
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("invalid feeds", func(t *testing.T) {
+		_, err := NewCSVStreamFeed("1d", PairFeed{Pair: "BTCUSDT", File: "invalid.csv", Timeframe: "1h"})
+		require.Error(t, err)
+
+		_, err = NewCSVStreamFeed("1y", feeds[0])
+		require.Error(t, err)
+
+		empty := filepath.Join(t.TempDir(), "empty.csv")
+		require.NoError(t, os.WriteFile(empty, nil, 0o644))
+		_, err = NewCSVStreamFeed("1d", PairFeed{Pair: "BTCUSDT", File: empty, Timeframe: "1h"})
+		require.ErrorIs(t, err, ErrInsufficientData)
+	})
+}
+
+// streamExchange 使用CSVStreamFeed作为交易所的数据源
+type streamExchange struct {
+	service.Exchange
+	feed *CSVStreamFeed
+}
+
+func (s streamExchange) CandlesSubscription(ctx context.Context, pair, timeframe string) (chan model.Candle,
+	chan error) {
+	return s.feed.CandlesSubscription(ctx, pair, timeframe)
+}
+
+func TestDataFeedSubscription_merge(t *testing.T) {
+	feed, err := NewCSVStreamFeed("4h",
+		PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1h.csv", Timeframe: "1h"},
+		PairFeed{Pair: "ETHUSDT", File: "../testdata/eth-1h.csv", Timeframe: "1h"},
+	)
+	require.NoError(t, err)
+
+	var candles []model.Candle
+	consumer := func(candle model.Candle) {
+		candles = append(candles, candle)
+	}
+
+	dataFeed := NewDataFeed(streamExchange{feed: feed})
+	dataFeed.Subscribe("BTCUSDT", "4h", consumer, false)
+	dataFeed.Subscribe("ETHUSDT", "4h", consumer, true)
+	dataFeed.Start(true)
+
+	// 两个交易对按时间合并，ETHUSDT只收到完整的K线
+	require.NotEmpty(t, candles)
+	pairs := map[string]int{}
+	for i, candle := range candles {
+		pairs[candle.Pair]++
+		if candle.Pair == "ETHUSDT" {
+			require.True(t, candle.Complete)
+		}
+		if i > 0 {
+			require.False(t, candle.Less(candles[i-1]), "candle %d out of order", i)
+		}
+	}
+	assert.Len(t, pairs, 2)
+	assert.Greater(t, pairs["BTCUSDT"], pairs["ETHUSDT"])
+}
@@ -0,0 +1,430 @@
+package exchange
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+	"github.com/rodrigo-brito/ninjabot/tools/log"
+)
+
+// depthResyncInterval 是两次获取订单簿快照的最小间隔，避免连续出现缺口时频繁请求快照
+const depthResyncInterval = time.Second
+
+// OrderBookSource 提供交易对当前的订单簿，DepthFeed实现了这个接口，PaperWallet通过它按订单簿深度成交
+type OrderBookSource interface {
+	OrderBook(pair string) (model.OrderBook, bool)
+}
+
+// depthStream 是一个交易对的订单簿和增量更新数据流
+type depthStream struct {
+	book     *model.OrderBook
+	updates  chan model.DepthUpdate
+	errs     chan error
+	next     *model.DepthUpdate // next 回测时已经读取但时间还没到的更新
+	lastSync time.Time          // lastSync 上一次获取快照的时间
+}
+
+// DepthFeedOption 是DepthFeed的配置函数
+type DepthFeedOption func(*DepthFeed)
+
+// WithDepthLimit 配置获取快照时每一边的档位数量，默认1000
+func WithDepthLimit(limit int) DepthFeedOption {
+	return func(feed *DepthFeed) {
+		feed.limit = limit
+	}
+}
+
+/*
+DepthFeed 订阅交易对的订单簿增量更新并维护本地订单簿。
+
+实时模式下调用Start，每个交易对一个goroutine应用更新；订单簿还没有快照或者更新ID出现缺口时，
+通过DepthSnapshot重新获取快照（两次快照间隔至少1秒），快照同样会发送给订阅者，录制的文件因此可以直接回放。
+回测时不调用Start，而是在处理每根K线之前调用Advance，把订单簿推进到K线收盘的时间；
+回放的数据中出现缺口时订单簿被清空，直到数据中的下一个快照。
+*/
+type DepthFeed struct {
+	ctx         context.Context
+	feeder      service.DepthFeeder
+	limit       int
+	live        bool
+	mtx         sync.Mutex
+	streams     map[string]*depthStream
+	subscribers map[string][]func(model.DepthUpdate)
+}
+
+// NewDepthFeed 创建一个订单簿数据源，feeder可以是交易所（如Binance）或者DepthReplay
+func NewDepthFeed(ctx context.Context, feeder service.DepthFeeder, options ...DepthFeedOption) *DepthFeed {
+	feed := &DepthFeed{
+		ctx:         ctx,
+		feeder:      feeder,
+		limit:       1000,
+		streams:     make(map[string]*depthStream),
+		subscribers: make(map[string][]func(model.DepthUpdate)),
+	}
+	for _, option := range options {
+		option(feed)
+	}
+	return feed
+}
+
+// Subscribe 订阅交易对应用到订单簿的每一个快照和增量更新，需要在Start之前调用
+func (f *DepthFeed) Subscribe(pair string, fn func(update model.DepthUpdate)) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.subscribers[pair] = append(f.subscribers[pair], fn)
+}
+
+// Connect 订阅交易对的增量更新，已经订阅的交易对会被忽略
+func (f *DepthFeed) Connect(pairs ...string) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	for _, pair := range pairs {
+		if _, ok := f.streams[pair]; ok {
+			continue
+		}
+		updates, errs := f.feeder.DepthSubscription(f.ctx, pair)
+		f.streams[pair] = &depthStream{book: model.NewOrderBook(pair), updates: updates, errs: errs}
+	}
+}
+
+// Start 实时模式下开始应用增量更新，直到ctx结束或者数据流关闭
+func (f *DepthFeed) Start() {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.live = true
+	for pair, stream := range f.streams {
+		go func(pair string, stream *depthStream) {
+			for {
+				select {
+				case update, ok := <-stream.updates:
+					if !ok {
+						return
+					}
+					f.apply(pair, stream, update)
+				case err, ok := <-stream.errs:
+					if !ok {
+						stream.errs = nil
+						continue
+					}
+					log.Error("depthFeed/start: ", err)
+				case <-f.ctx.Done():
+					return
+				}
+			}
+		}(pair, stream)
+	}
+	log.Infof("Depth feed connected.")
+}
+
+// Advance 回测时把交易对的订单簿推进到t，应用时间不晚于t的所有更新
+func (f *DepthFeed) Advance(pair string, t time.Time) {
+	f.mtx.Lock()
+	stream, ok := f.streams[pair]
+	f.mtx.Unlock()
+	if !ok {
+		return
+	}
+
+	for {
+		if stream.next == nil {
+			if stream.updates == nil {
+				return
+			}
+			select {
+			case update, ok := <-stream.updates:
+				if !ok {
+					stream.updates = nil
+					return
+				}
+				stream.next = &update
+			case err, ok := <-stream.errs:
+				if !ok {
+					stream.errs = nil
+					continue
+				}
+				log.Error("depthFeed/advance: ", err)
+				continue
+			case <-f.ctx.Done():
+				return
+			}
+		}
+
+		if stream.next.Time.After(t) {
+			return
+		}
+		f.apply(pair, stream, *stream.next)
+		stream.next = nil
+	}
+}
+
+// OrderBook 返回交易对当前订单簿的副本，还没有快照时返回false
+func (f *DepthFeed) OrderBook(pair string) (model.OrderBook, bool) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	stream, ok := f.streams[pair]
+	if !ok || !stream.book.Synced() {
+		return model.OrderBook{}, false
+	}
+	return stream.book.Copy(0), true
+}
+
+// apply 把更新应用到订单簿，需要时重新获取快照，然后通知订阅者
+func (f *DepthFeed) apply(pair string, stream *depthStream, update model.DepthUpdate) {
+	f.mtx.Lock()
+	applied := make([]model.DepthUpdate, 0, 2)
+
+	err := stream.book.Apply(update)
+	if errors.Is(err, model.ErrOrderBookNotSynced) || errors.Is(err, model.ErrOrderBookGap) {
+		stream.book.Reset()
+
+		if f.resyncDue(stream) {
+			// 获取快照是REST请求，请求期间不持有锁，避免阻塞其他交易对的更新和OrderBook。
+			// 每个交易对的更新只由一个goroutine应用，重新加锁后订单簿仍然是清空的状态
+			f.mtx.Unlock()
+			snapshot, ok := f.fetchSnapshot(pair)
+			f.mtx.Lock()
+
+			if ok && stream.book.Apply(snapshot) == nil {
+				applied = append(applied, snapshot)
+				err = stream.book.Apply(update)
+			}
+		}
+	}
+
+	switch {
+	case err == nil && stream.book.Synced():
+		applied = append(applied, update)
+	case err != nil && !errors.Is(err, model.ErrOrderBookNotSynced):
+		log.Warnf("depthFeed/apply: %v", err)
+		stream.book.Reset()
+	}
+	subscribers := f.subscribers[pair]
+	f.mtx.Unlock()
+
+	for _, update := range applied {
+		for _, fn := range subscribers {
+			fn(update)
+		}
+	}
+}
+
+// resyncDue 实时模式下距离上一次快照超过depthResyncInterval时记录时间并返回true，调用时需要持有f.mtx
+func (f *DepthFeed) resyncDue(stream *depthStream) bool {
+	if !f.live || time.Since(stream.lastSync) < depthResyncInterval {
+		return false
+	}
+	stream.lastSync = time.Now()
+	return true
+}
+
+// fetchSnapshot 重新获取交易对的订单簿快照，调用时不能持有f.mtx
+func (f *DepthFeed) fetchSnapshot(pair string) (model.DepthUpdate, bool) {
+	snapshot, err := f.feeder.DepthSnapshot(f.ctx, pair, f.limit)
+	if err != nil {
+		log.Errorf("depthFeed/resync: %s: %v", pair, err)
+		return model.DepthUpdate{}, false
+	}
+	snapshot.Pair = pair
+	snapshot.Snapshot = true
+	return snapshot, true
+}
+
+// DepthRecorder 把订单簿的快照和增量更新按JSON Lines格式写入文件，文件名以.gz结尾时使用gzip压缩。
+// 录制的文件可以由DepthReplay回放。
+type DepthRecorder struct {
+	mtx     sync.Mutex
+	file    *os.File
+	gzip    *gzip.Writer
+	writer  *bufio.Writer
+	encoder *json.Encoder
+	closed  bool
+}
+
+// NewDepthRecorder 创建文件并返回一个DepthRecorder，已有的文件会被覆盖
+func NewDepthRecorder(path string) (*DepthRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	recorder := &DepthRecorder{file: file}
+	var writer io.Writer = file
+	if strings.HasSuffix(path, ".gz") {
+		recorder.gzip = gzip.NewWriter(file)
+		writer = recorder.gzip
+	}
+	recorder.writer = bufio.NewWriter(writer)
+	recorder.encoder = json.NewEncoder(recorder.writer)
+	return recorder, nil
+}
+
+// Record 写入一个快照或增量更新，可以直接作为DepthFeed的订阅函数
+func (r *DepthRecorder) Record(update model.DepthUpdate) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	// 关闭之后数据流中剩余的更新被丢弃
+	if r.closed {
+		return
+	}
+	if err := r.encoder.Encode(update); err != nil {
+		log.Errorf("depthRecorder/record: %v", err)
+	}
+}
+
+// Close 写入缓冲的数据并关闭文件
+func (r *DepthRecorder) Close() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.closed = true
+	if err := r.writer.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	if r.gzip != nil {
+		if err := r.gzip.Close(); err != nil {
+			r.file.Close()
+			return err
+		}
+	}
+	return r.file.Close()
+}
+
+// DepthPairFeed 是一个交易对录制的订单簿文件，按时间顺序排列
+type DepthPairFeed struct {
+	Pair  string
+	Files []string
+}
+
+// DepthReplay 回放DepthRecorder录制的文件，实现了service.DepthFeeder，用于回测时的DepthFeed
+type DepthReplay struct {
+	feeds map[string][]string
+}
+
+// NewDepthReplay 创建一个DepthReplay，文件不存在时返回错误
+func NewDepthReplay(feeds ...DepthPairFeed) (*DepthReplay, error) {
+	replay := &DepthReplay{feeds: make(map[string][]string)}
+	for _, feed := range feeds {
+		for _, path := range feed.Files {
+			if _, err := os.Stat(path); err != nil {
+				return nil, err
+			}
+		}
+		replay.feeds[feed.Pair] = append(replay.feeds[feed.Pair], feed.Files...)
+	}
+	return replay, nil
+}
+
+// DepthSnapshot 返回文件中交易对的第一个快照，limit大于0时只包含前limit档
+func (r *DepthReplay) DepthSnapshot(_ context.Context, pair string, limit int) (model.DepthUpdate, error) {
+	var snapshot *model.DepthUpdate
+	err := r.read(pair, func(update model.DepthUpdate) bool {
+		if update.Snapshot {
+			snapshot = &update
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return model.DepthUpdate{}, err
+	}
+	if snapshot == nil {
+		return model.DepthUpdate{}, ErrInsufficientData
+	}
+
+	book := model.NewOrderBook(pair)
+	if err := book.Apply(*snapshot); err != nil {
+		return model.DepthUpdate{}, err
+	}
+	return book.Snapshot(limit), nil
+}
+
+// DepthSubscription 按文件中的顺序推送交易对的所有快照和增量更新，回放结束后关闭通道
+func (r *DepthReplay) DepthSubscription(ctx context.Context, pair string) (chan model.DepthUpdate, chan error) {
+	cupdate := make(chan model.DepthUpdate)
+	cerr := make(chan error)
+
+	go func() {
+		defer close(cerr)
+		defer close(cupdate)
+
+		err := r.read(pair, func(update model.DepthUpdate) bool {
+			select {
+			case cupdate <- update:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if err != nil {
+			select {
+			case cerr <- err:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return cupdate, cerr
+}
+
+// read 按顺序读取交易对的文件，fn返回false时停止，文件中其他交易对的更新被跳过
+func (r *DepthReplay) read(pair string, fn func(update model.DepthUpdate) bool) error {
+	for _, path := range r.feeds[pair] {
+		next, err := readDepthFile(path, pair, fn)
+		if err != nil || !next {
+			return err
+		}
+	}
+	return nil
+}
+
+// readDepthFile 读取一个录制的文件，返回是否继续读取下一个文件
+func readDepthFile(path, pair string, fn func(update model.DepthUpdate) bool) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return false, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(reader))
+	for {
+		var update model.DepthUpdate
+		if err := decoder.Decode(&update); err == io.EOF {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		if update.Pair != "" && update.Pair != pair {
+			continue
+		}
+		update.Pair = pair
+		if !fn(update) {
+			return false, nil
+		}
+	}
+}
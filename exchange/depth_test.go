@@ -0,0 +1,139 @@
+package exchange
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+func TestDepthFeed(t *testing.T) {
+	start := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	snapshot := model.DepthUpdate{
+		Pair:         "BTCUSDT",
+		Time:         start,
+		LastUpdateID: 10,
+		Bids:         []model.PriceLevel{{Price: 100, Quantity: 1}, {Price: 99, Quantity: 2}},
+		Asks:         []model.PriceLevel{{Price: 101, Quantity: 1}, {Price: 102, Quantity: 3}},
+		Snapshot:     true,
+	}
+	diff := func(first, last int64, seconds int, bids ...model.PriceLevel) model.DepthUpdate {
+		return model.DepthUpdate{Pair: "BTCUSDT", Time: start.Add(time.Duration(seconds) * time.Second),
+			FirstUpdateID: first, LastUpdateID: last, Bids: bids}
+	}
+
+	t.Run("record and replay", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "depth.jsonl.gz")
+		recorder, err := NewDepthRecorder(path)
+		require.NoError(t, err)
+		recorder.Record(snapshot)
+		recorder.Record(model.DepthUpdate{Pair: "ETHUSDT", Time: start, Snapshot: true})
+		recorder.Record(diff(11, 12, 1, model.PriceLevel{Price: 100, Quantity: 5}))
+		recorder.Record(diff(13, 14, 2, model.PriceLevel{Price: 100, Quantity: 0}))
+		require.NoError(t, recorder.Close())
+
+		replay, err := NewDepthReplay(DepthPairFeed{Pair: "BTCUSDT", Files: []string{path}})
+		require.NoError(t, err)
+
+		first, err := replay.DepthSnapshot(context.Background(), "BTCUSDT", 1)
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), first.LastUpdateID)
+		assert.Equal(t, []model.PriceLevel{{Price: 100, Quantity: 1}}, first.Bids)
+
+		feed := NewDepthFeed(context.Background(), replay)
+		var updates []model.DepthUpdate
+		feed.Subscribe("BTCUSDT", func(update model.DepthUpdate) {
+			updates = append(updates, update)
+		})
+		feed.Connect("BTCUSDT")
+
+		_, ok := feed.OrderBook("BTCUSDT")
+		require.False(t, ok)
+
+		feed.Advance("BTCUSDT", start.Add(time.Second))
+		book, ok := feed.OrderBook("BTCUSDT")
+		require.True(t, ok)
+		assert.Equal(t, 5.0, book.Bids[0].Quantity)
+		assert.Len(t, updates, 2)
+
+		feed.Advance("BTCUSDT", start.Add(time.Minute))
+		book, ok = feed.OrderBook("BTCUSDT")
+		require.True(t, ok)
+		assert.Equal(t, 99.0, book.Bids[0].Price)
+		assert.Equal(t, int64(14), book.LastUpdateID)
+
+		// 数据已经回放完毕
+		feed.Advance("BTCUSDT", start.Add(time.Hour))
+		assert.Len(t, updates, 3)
+	})
+
+	t.Run("live sync", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		feeder := &fakeDepthFeeder{updates: make(chan model.DepthUpdate), snapshot: snapshot}
+		feed := NewDepthFeed(ctx, feeder)
+		// 获取快照期间不持有锁，可以读取订单簿
+		feeder.onSnapshot = func() {
+			_, ok := feed.OrderBook("BTCUSDT")
+			assert.False(t, ok)
+		}
+		feed.Connect("BTCUSDT")
+		feed.Start()
+
+		// 第一个增量更新触发快照，快照之前的部分被忽略
+		feeder.updates <- diff(9, 11, 1, model.PriceLevel{Price: 100, Quantity: 3})
+		feeder.updates <- diff(12, 12, 2, model.PriceLevel{Price: 99.5, Quantity: 1})
+		require.Eventually(t, func() bool {
+			book, ok := feed.OrderBook("BTCUSDT")
+			return ok && book.LastUpdateID == 12
+		}, time.Second, 10*time.Millisecond)
+
+		book, _ := feed.OrderBook("BTCUSDT")
+		assert.Equal(t, []model.PriceLevel{{Price: 100, Quantity: 3}, {Price: 99.5, Quantity: 1},
+			{Price: 99, Quantity: 2}}, book.Bids)
+		assert.Equal(t, 1, feeder.snapshots())
+
+		// 缺口在1秒内不会再次获取快照，订单簿被清空
+		feeder.updates <- diff(20, 21, 3)
+		require.Eventually(t, func() bool {
+			_, ok := feed.OrderBook("BTCUSDT")
+			return !ok
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, 1, feeder.snapshots())
+	})
+}
+
+type fakeDepthFeeder struct {
+	mtx        sync.Mutex
+	updates    chan model.DepthUpdate
+	snapshot   model.DepthUpdate
+	count      int
+	onSnapshot func() // onSnapshot 获取快照时调用，用于检查请求期间是否持有锁
+}
+
+func (f *fakeDepthFeeder) DepthSnapshot(_ context.Context, _ string, _ int) (model.DepthUpdate, error) {
+	if f.onSnapshot != nil {
+		f.onSnapshot()
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.count++
+	return f.snapshot, nil
+}
+
+func (f *fakeDepthFeeder) DepthSubscription(_ context.Context, _ string) (chan model.DepthUpdate, chan error) {
+	return f.updates, make(chan error)
+}
+
+func (f *fakeDepthFeeder) snapshots() int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.count
+}
@@ -6,8 +6,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
 	"strings"
-	"sync"
 
 	// 使用StudioSol/set库提供集合功能，这里用于管理字符串集合。
 	"github.com/StudioSol/set"
@@ -24,11 +26,46 @@ import (
 
 // 定义一些通用的错误类型。
 var (
-	ErrInvalidQuantity   = errors.New("invalid quantity")             //无效数量
-	ErrInsufficientFunds = errors.New("insufficient funds or locked") //当用户的账户余额不足以完成交易或者资金被锁定时返回的错误
-	ErrInvalidAsset      = errors.New("invalid asset")                //无效的资产交易时返回的错误
+	ErrInvalidQuantity   = errors.New("invalid quantity")              //无效数量
+	ErrInsufficientFunds = errors.New("insufficient funds or locked")  //当用户的账户余额不足以完成交易或者资金被锁定时返回的错误
+	ErrInvalidAsset      = errors.New("invalid asset")                 //无效的资产交易时返回的错误
+	ErrOrderWouldMatch   = errors.New("order would immediately match") //只做挂单（post-only）的订单在下单时会立即成交，被拒绝
+	ErrOrderNotFound     = errors.New("order not found")               //订单不存在
+	ErrNotReplaceable    = errors.New("order is not replaceable")      //订单已成交、已取消或者不是限价单，不能改单
+	ErrNotSupported      = errors.New("not supported by exchange")     //交易所不支持的订单类型或者功能
 )
 
+// stringExchangeID 将交易所返回的字符串订单ID转换为ExchangeID。纯数字的订单ID直接转换为整数，
+// 其他订单ID（例如UUID）使用FNV哈希得到一个稳定的正整数，同一个订单ID每次转换的结果相同。
+func stringExchangeID(orderID string) int64 {
+	if id, err := strconv.ParseInt(orderID, 10, 64); err == nil && id > 0 {
+		return id
+	}
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(orderID))
+	return int64(hash.Sum64() & math.MaxInt64)
+}
+
+// stepPrecision 根据交易所返回的最小变动单位（例如"0.001"）计算小数位数。
+func stepPrecision(step string) int {
+	index := strings.IndexByte(step, '.')
+	if index < 0 {
+		return 0
+	}
+	return len(strings.TrimRight(step[index+1:], "0"))
+}
+
+// accountPosition 根据账户余额生成持仓详情，用于没有单独持仓接口的交易所：基础资产的余额作为持仓数量，
+// 负数表示空头，杠杆倍数来自余额，其他字段交易所不提供，保持为0。
+func accountPosition(acc model.Account, pair, asset string) model.PositionInfo {
+	balance, _ := acc.Balance(asset, "")
+	position := model.NewPositionInfo(pair, balance.Free+balance.Lock)
+	if balance.Leverage > 0 {
+		position.Leverage = balance.Leverage
+	}
+	return position
+}
+
 // DataFeed 是市场数据的通道，包含了数据和错误两个通道。
 type DataFeed struct {
 	Data chan model.Candle // 数据通道，传输蜡烛图数据。
@@ -37,10 +74,12 @@ type DataFeed struct {
 
 // DataFeedSubscription 是数据订阅的结构体，管理各种数据订阅。
 type DataFeedSubscription struct {
-	exchange                service.Exchange          // 交易所的接口。
-	Feeds                   *set.LinkedHashSetString  // 订阅的数据源集合{订阅的标识符可能更具体，如 "BTC/USD-1h", "EOS/USD-24h", "ETH/USD-1d" 等}。当 Feeds 字段被声明为 *set.LinkedHashSetString 类型时，它指向一个集合（Set）数据结构，这个集合专门用于存储不重复的字符串值。
-	DataFeeds               map[string]*DataFeed      // 数据源的映射，意思就是键是一个键是如BTC/USD-1h"值呢这个结构体包含了实际的市场数据通道和错误通道。
-	SubscriptionsByDataFeed map[string][]Subscription // 订阅信息的映射，键是如BTC/USD-1h" 通常用来指代每小时更新一次的比特币对美元的价格数据 值是Subscription 类型 。比如onCandleClose 为 true 然后把开盘价收盘价传给handleCandleClose 函数 然后打印出来。
+	exchange                service.Exchange           // 交易所的接口。
+	Feeds                   *set.LinkedHashSetString   // 订阅的数据源集合{订阅的标识符可能更具体，如 "BTC/USD-1h", "EOS/USD-24h", "ETH/USD-1d" 等}。当 Feeds 字段被声明为 *set.LinkedHashSetString 类型时，它指向一个集合（Set）数据结构，这个集合专门用于存储不重复的字符串值。
+	DataFeeds               map[string]*DataFeed       // 数据源的映射，意思就是键是一个键是如BTC/USD-1h"值呢这个结构体包含了实际的市场数据通道和错误通道。
+	SubscriptionsByDataFeed map[string][]Subscription  // 订阅信息的映射，键是如BTC/USD-1h" 通常用来指代每小时更新一次的比特币对美元的价格数据 值是Subscription 类型 。比如onCandleClose 为 true 然后把开盘价收盘价传给handleCandleClose 函数 然后打印出来。
+	resamplers              map[string][]feedResampler // 以源数据源的键为键，由源数据源重采样得到的数据源
+	resampled               map[string]bool            // 由重采样得到、不需要向交易所订阅的数据源
 }
 
 // Subscription 是对数据源的具体订阅定义。比如onCandleClose 为 true 然后把开盘价收盘价传给handleCandleClose 函数 然后打印出来
@@ -50,6 +89,12 @@ type Subscription struct {
 	consumer      DataFeedConsumer // 数据消费者，当新的蜡烛图数据到来时会被调用。
 }
 
+// feedResampler 把源数据源的K线重采样后发送给key数据源的订阅者
+type feedResampler struct {
+	key       string
+	resampler *Resampler
+}
+
 // OrderError 是订单错误的定义，包括错误信息、交易对和数量。如下订单是发现错误的交易对（"BTC/USD"）、以及试图交易的数量（0.5比特币）数量不足
 type OrderError struct {
 	Err      error   // 错误信息。
@@ -62,6 +107,32 @@ func (o *OrderError) Error() string {
 	return fmt.Sprintf("order error: %v", o.Err)
 }
 
+// Unwrap 返回原始错误，使 errors.Is 可以判断订单错误的具体原因。
+func (o *OrderError) Unwrap() error {
+	return o.Err
+}
+
+// cancelReplace 以先撤单再下单的方式实现改单，用于不支持原子改单的交易所。
+// 撤单失败时原订单不受影响；撤单成功但新订单创建失败时返回的错误会说明原订单已经被取消，
+// 调用方需要重新查询原订单的状态。
+func cancelReplace(broker service.Broker, order model.Order, price, quantity float64) (model.Order, error) {
+	if !order.IsReplaceable() {
+		return model.Order{}, &OrderError{Err: ErrNotReplaceable, Pair: order.Pair, Quantity: quantity}
+	}
+
+	if err := broker.Cancel(order); err != nil {
+		return model.Order{}, err
+	}
+
+	replacement, err := broker.CreateOrderLimit(order.Side, order.Pair, quantity, price, order.ReplaceOptions()...)
+	if err != nil {
+		return model.Order{}, fmt.Errorf("order %d canceled but replacement failed: %w", order.ExchangeID, err)
+	}
+
+	replacement.ReplacedID = &order.ExchangeID
+	return replacement, nil
+}
+
 // DataFeedConsumer 是一个函数类型，用于处理接收到的蜡烛图数据。DataFeedConsumer不是一个具体的函数，而是一个函数类型。任何具有相同参数列表（一个model.Candle类型的参数）和相同返回类型（没有返回值）的函数都被认为是这个类型的实例
 type DataFeedConsumer func(model.Candle)
 
@@ -72,6 +143,8 @@ func NewDataFeed(exchange service.Exchange) *DataFeedSubscription {
 		Feeds:                   set.NewLinkedHashSetString(),    // 初始化字符串集合。为它的Feeds字段初始化一个空的、有序的、不允许重复的字符串集合不允许订阅重复的交易对
 		DataFeeds:               make(map[string]*DataFeed),      // 初始化数据源映射。
 		SubscriptionsByDataFeed: make(map[string][]Subscription), // 初始化订阅信息映射。
+		resamplers:              make(map[string][]feedResampler),
+		resampled:               make(map[string]bool),
 	}
 }
 
@@ -99,6 +172,26 @@ func (d *DataFeedSubscription) Subscribe(pair, timeframe string, consumer DataFe
 	})
 }
 
+// Resample 让交易对timeframe周期的订阅由sourceTimeframe的K线按alignment重采样得到，不再向交易所订阅timeframe，
+// 用于交易所不支持的时间周期（如45m）或者从同一个数据流得到多个时间周期。需要在Connect之前调用。
+// 实时数据中未完成的源K线也会输出一根未完成的目标周期K线，第一个完整周期之前的K线被跳过。
+func (d *DataFeedSubscription) Resample(pair, sourceTimeframe, timeframe string, alignment Alignment) error {
+	// 相同的周期会把K线重采样回自己的订阅
+	if err := ValidateResample(sourceTimeframe, timeframe); err != nil {
+		return err
+	}
+	resampler, err := NewResampler(sourceTimeframe, timeframe, alignment)
+	if err != nil {
+		return err
+	}
+
+	source, target := d.feedKey(pair, sourceTimeframe), d.feedKey(pair, timeframe)
+	d.Feeds.Add(source)
+	d.resampled[target] = true
+	d.resamplers[source] = append(d.resamplers[source], feedResampler{key: target, resampler: resampler})
+	return nil
+}
+
 // Preload 方法用于预加载一系列蜡烛图数据。
 // 预加载一系列蜡烛图数据" 这个表达的意思是，在交易系统正式开始实时数据处理或交易前，先将历史的蜡烛图数据（即历史的价格变动数据）加载到系统中。这些蜡烛图数据通常包含了每个指定时间段（如一小时或一天）的开盘价、收盘价、最高价和最低价等信息。例如，如果一个交易策略需要基于过去30天的日平均数据来预测未来的价格趋势，那么在系统开始运行前，必须先加载这30天的历史数据。
 func (d *DataFeedSubscription) Preload(pair, timeframe string, candles []model.Candle) {
@@ -127,6 +220,15 @@ func (d *DataFeedSubscription) Preload(pair, timeframe string, candles []model.C
 		for _, subscription := range d.SubscriptionsByDataFeed[key] {
 			subscription.consumer(candle)
 		}
+
+		// 预加载的源K线同样用于重采样的数据源，只发送完整的K线
+		for _, feed := range d.resamplers[key] {
+			if resampled, ok := feed.resampler.Update(candle); ok && resampled.Complete {
+				for _, subscription := range d.SubscriptionsByDataFeed[feed.key] {
+					subscription.consumer(resampled)
+				}
+			}
+		}
 	}
 }
 
@@ -135,6 +237,10 @@ func (d *DataFeedSubscription) Connect() {
 	log.Infof("Connecting to the exchange.")
 	//使用Iter()方法或类似的迭代器模式，可以帮助您更方便地遍历和查看复杂数据结构中的内容
 	for feed := range d.Feeds.Iter() {
+		// 重采样的数据源由源数据源的K线生成
+		if d.resampled[feed] {
+			continue
+		}
 		pair, timeframe := d.pairTimeframeFromKey(feed) // 解析出交易对和时间范围。
 		// 这个调用返回两个通道：ccandle用于接收蜡烛图数据，cerr用于接收可能发生的错误
 		ccandle, cerr := d.exchange.CandlesSubscription(context.Background(), pair, timeframe)
@@ -147,12 +253,17 @@ func (d *DataFeedSubscription) Connect() {
 }
 
 // Start 方法启动数据订阅服务。有一个数据订阅服务被启动，同时还有其他任务需要执行。不想等待loadSync  传入false 可以启动订阅的同时执行其他任务 ，如果true，则必须等待订阅完成，才能执行其他任务
+// loadSync为true时（回测）在当前协程中按时间顺序合并所有数据源，每个数据源只缓存一根K线，消费者按时间顺序收到K线。
 func (d *DataFeedSubscription) Start(loadSync bool) {
-	d.Connect()               // 建立连接。
-	wg := new(sync.WaitGroup) // 使用WaitGroup等待所有订阅处理完毕。
+	d.Connect() // 建立连接。
+	if loadSync {
+		log.Infof("Data feed connected.")
+		d.merge()
+		return
+	}
+
 	// 遍历得到一个一个map 键是key 值是 feed 指向DataFeeds指针里面包含一个数据通道还有一个错误通道
 	for key, feed := range d.DataFeeds {
-		wg.Add(1) // 为每个数据订阅增加WaitGroup计数。
 		go func(key string, feed *DataFeed) {
 			// 无线循环监听通道
 			for {
@@ -161,16 +272,9 @@ func (d *DataFeedSubscription) Start(loadSync bool) {
 				// 尝试从数据通道Data读取蜡烛图数据
 				case candle, ok := <-feed.Data:
 					if !ok {
-						wg.Done() // 如果数据通道被关闭，递减WaitGroup计数。结束协程
-						return
-					}
-					// 对于每个键对应的订阅，如果满足条件则执行消费者函数。
-					for _, subscription := range d.SubscriptionsByDataFeed[key] {
-						if subscription.onCandleClose && !candle.Complete {
-							continue // 如果订阅者想要一个完整的蜡烛图（闭市），但是不完整 则跳过继续循环。
-						}
-						subscription.consumer(candle) // 拿到（subscription.onCandleClose=true）闭市且 图形完整则执行消费者函数。
+						return // 如果数据通道被关闭，结束协程
 					}
+					d.dispatch(key, candle)
 					// 监听错误通道，如果有错就打印出来
 				case err := <-feed.Err:
 					if err != nil {
@@ -182,8 +286,71 @@ func (d *DataFeedSubscription) Start(loadSync bool) {
 	}
 
 	log.Infof("Data feed connected.")
-	// 有一个数据订阅服务被启动，同时还有其他任务需要执行。不想等待loadSync  传入false 可以启动订阅的同时执行其他任务 ，如果true，则必须等待订阅完成，才能执行其他任务
-	if loadSync {
-		wg.Wait() // 如果loadSync为true，则等待所有订阅处理完毕。
+}
+
+// dispatch 把蜡烛图发送给数据源的所有订阅者
+func (d *DataFeedSubscription) dispatch(key string, candle model.Candle) {
+	// 对于每个键对应的订阅，如果满足条件则执行消费者函数。
+	for _, subscription := range d.SubscriptionsByDataFeed[key] {
+		if subscription.onCandleClose && !candle.Complete {
+			continue // 如果订阅者想要一个完整的蜡烛图（闭市），但是不完整 则跳过继续循环。
+		}
+		subscription.consumer(candle) // 拿到（subscription.onCandleClose=true）闭市且 图形完整则执行消费者函数。
+	}
+
+	// 重采样器只在源数据源的协程中使用，不需要加锁
+	for _, feed := range d.resamplers[key] {
+		if resampled, ok := feed.resampler.Update(candle); ok {
+			d.dispatch(feed.key, resampled)
+		}
+	}
+}
+
+// feedCandle 是合并数据源时优先队列中的元素，记录蜡烛图来自哪个数据源
+type feedCandle struct {
+	model.Candle
+	key string
+}
+
+// Less 按蜡烛图的顺序比较，与直接把蜡烛图放入优先队列的顺序相同
+func (f feedCandle) Less(j model.Item) bool {
+	return f.Candle.Less(j.(feedCandle).Candle)
+}
+
+// merge 按时间顺序合并所有数据源直到全部结束。每个数据源的蜡烛图本身是有序的，
+// 优先队列中只保存每个数据源的下一根蜡烛图，取出最早的一根发送给订阅者后再读取该数据源的下一根，内存占用与数据量无关。
+func (d *DataFeedSubscription) merge() {
+	queue := model.NewPriorityQueue(nil)
+	for key, feed := range d.DataFeeds {
+		if candle, ok := d.next(feed); ok {
+			queue.Push(feedCandle{Candle: candle, key: key})
+		}
+	}
+
+	for queue.Len() > 0 {
+		item := queue.Pop().(feedCandle)
+		d.dispatch(item.key, item.Candle)
+		if candle, ok := d.next(d.DataFeeds[item.key]); ok {
+			queue.Push(feedCandle{Candle: candle, key: item.key})
+		}
+	}
+}
+
+// next 读取数据源的下一根蜡烛图，期间收到的错误只记录日志，数据通道关闭时返回false
+func (d *DataFeedSubscription) next(feed *DataFeed) (model.Candle, bool) {
+	for {
+		select {
+		case candle, ok := <-feed.Data:
+			return candle, ok
+		case err, ok := <-feed.Err:
+			if !ok {
+				// 错误通道已经关闭，之后只等待数据通道
+				feed.Err = nil
+				continue
+			}
+			if err != nil {
+				log.Error("dataFeedSubscription/start: ", err)
+			}
+		}
 	}
 }
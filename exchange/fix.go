@@ -0,0 +1,644 @@
+package exchange
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rodrigo-brito/ninjabot/tools/log"
+)
+
+/*
+FIX 4.4会话层的最小实现，只包含交易需要的部分：
+消息格式是 tag=value 用SOH（0x01）分隔，头部依次是BeginString(8)、BodyLength(9)、MsgType(35)，最后是CheckSum(10)。
+会话建立时发送Logon(A)并重置序号（ResetSeqNumFlag=Y），之后双方各自维护发送和接收的序号：
+  - 收到的序号大于期望值时发送ResendRequest(2)，丢弃这条消息，等待对方按顺序重发；
+  - 收到的序号小于期望值且不是重发（PossDupFlag=Y）时序号已经无法对齐，发送Logout(5)断开；
+  - 对方的ResendRequest按原样重发保存的业务消息（PossDupFlag=Y），会话消息用SequenceReset(4)的GapFill跳过；
+  - 一个心跳间隔内没有发送消息时发送Heartbeat(0)，超过心跳间隔没有收到消息时发送TestRequest(1)，两个间隔仍然没有消息时断开连接。
+*/
+
+// FIX会话的错误。
+var (
+	ErrFIXNotConnected = errors.New("fix: session not logged on")        // 会话没有建立或者已经断开
+	ErrFIXTimeout      = errors.New("fix: timeout waiting for response") // 在超时时间内没有收到对方的回复
+)
+
+const (
+	fixBeginString = "FIX.4.4"
+	fixSOH         = '\x01'
+	fixTimeFormat  = "20060102-15:04:05.000"
+)
+
+// 用到的FIX标签。
+const (
+	fixTagAccount         = 1
+	fixTagAvgPx           = 6
+	fixTagBeginSeqNo      = 7
+	fixTagBeginString     = 8
+	fixTagBodyLength      = 9
+	fixTagCheckSum        = 10
+	fixTagClOrdID         = 11
+	fixTagCumQty          = 14
+	fixTagEndSeqNo        = 16
+	fixTagExecID          = 17
+	fixTagExecInst        = 18
+	fixTagLastPx          = 31
+	fixTagLastQty         = 32
+	fixTagMsgSeqNum       = 34
+	fixTagMsgType         = 35
+	fixTagNewSeqNo        = 36
+	fixTagOrderID         = 37
+	fixTagOrderQty        = 38
+	fixTagOrdStatus       = 39
+	fixTagOrdType         = 40
+	fixTagOrigClOrdID     = 41
+	fixTagPossDupFlag     = 43
+	fixTagPrice           = 44
+	fixTagRefSeqNum       = 45
+	fixTagSenderCompID    = 49
+	fixTagSendingTime     = 52
+	fixTagSide            = 54
+	fixTagSymbol          = 55
+	fixTagTargetCompID    = 56
+	fixTagText            = 58
+	fixTagTimeInForce     = 59
+	fixTagTransactTime    = 60
+	fixTagEncryptMethod   = 98
+	fixTagStopPx          = 99
+	fixTagCxlRejReason    = 102
+	fixTagOrdRejReason    = 103
+	fixTagHeartBtInt      = 108
+	fixTagTestReqID       = 112
+	fixTagOrigSendingTime = 122
+	fixTagGapFillFlag     = 123
+	fixTagExpireTime      = 126
+	fixTagResetSeqNumFlag = 141
+	fixTagExecType        = 150
+	fixTagLeavesQty       = 151
+	fixTagCashOrderQty    = 152
+	fixTagUsername        = 553
+	fixTagPassword        = 554
+)
+
+// 用到的FIX消息类型。
+const (
+	fixMsgHeartbeat          = "0"
+	fixMsgTestRequest        = "1"
+	fixMsgResendRequest      = "2"
+	fixMsgReject             = "3"
+	fixMsgSequenceReset      = "4"
+	fixMsgLogout             = "5"
+	fixMsgExecutionReport    = "8"
+	fixMsgOrderCancelReject  = "9"
+	fixMsgLogon              = "A"
+	fixMsgNewOrderSingle     = "D"
+	fixMsgOrderCancelRequest = "F"
+	fixMsgOrderStatusRequest = "H"
+)
+
+// fixField 消息中的一个字段。
+type fixField struct {
+	Tag   int
+	Value string
+}
+
+// fixMessage 一条FIX消息，fields按顺序保存除BeginString、BodyLength和CheckSum以外的字段。
+type fixMessage struct {
+	fields []fixField
+}
+
+// newFIXMessage 创建指定类型的消息。
+func newFIXMessage(msgType string) *fixMessage {
+	return &fixMessage{fields: []fixField{{Tag: fixTagMsgType, Value: msgType}}}
+}
+
+// Set 设置字段的值，字段已经存在时替换，否则追加到末尾。
+func (m *fixMessage) Set(tag int, value string) *fixMessage {
+	for i := range m.fields {
+		if m.fields[i].Tag == tag {
+			m.fields[i].Value = value
+			return m
+		}
+	}
+	m.fields = append(m.fields, fixField{Tag: tag, Value: value})
+	return m
+}
+
+// Has 判断消息是否包含字段。
+func (m *fixMessage) Has(tag int) bool {
+	for _, field := range m.fields {
+		if field.Tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Get 返回字段的值，不存在时返回空字符串。
+func (m *fixMessage) Get(tag int) string {
+	for _, field := range m.fields {
+		if field.Tag == tag {
+			return field.Value
+		}
+	}
+	return ""
+}
+
+// Int 返回整数字段的值，不存在或者格式错误时返回0。
+func (m *fixMessage) Int(tag int) int {
+	value, _ := strconv.Atoi(m.Get(tag))
+	return value
+}
+
+// Float 返回数值字段的值，不存在或者格式错误时返回0。
+func (m *fixMessage) Float(tag int) float64 {
+	value, _ := strconv.ParseFloat(m.Get(tag), 64)
+	return value
+}
+
+// Type 返回消息类型。
+func (m *fixMessage) Type() string {
+	return m.Get(fixTagMsgType)
+}
+
+// clone 复制消息，重发时修改副本的头部不影响保存的原始消息。
+func (m *fixMessage) clone() *fixMessage {
+	return &fixMessage{fields: append([]fixField(nil), m.fields...)}
+}
+
+// Bytes 编码为完整的消息，自动计算BodyLength和CheckSum。
+func (m *fixMessage) Bytes() []byte {
+	var body bytes.Buffer
+	for _, field := range m.fields {
+		body.WriteString(strconv.Itoa(field.Tag))
+		body.WriteByte('=')
+		body.WriteString(field.Value)
+		body.WriteByte(fixSOH)
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "8=%s\x019=%d\x01", fixBeginString, body.Len())
+	message.Write(body.Bytes())
+	fmt.Fprintf(&message, "10=%03d\x01", fixChecksum(message.Bytes()))
+	return message.Bytes()
+}
+
+// String 返回用|代替SOH的消息，用于日志。
+func (m *fixMessage) String() string {
+	return string(bytes.ReplaceAll(m.Bytes(), []byte{fixSOH}, []byte{'|'}))
+}
+
+// fixChecksum 计算所有字节之和对256取模。
+func fixChecksum(data []byte) int {
+	var sum int
+	for _, b := range data {
+		sum += int(b)
+	}
+	return sum % 256
+}
+
+// readFIXMessage 从连接中读取一条完整的消息：先读取BeginString和BodyLength，再按长度读取消息体和CheckSum。
+func readFIXMessage(reader *bufio.Reader) ([]byte, error) {
+	begin, err := reader.ReadBytes(fixSOH)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(begin, []byte("8=")) {
+		return nil, fmt.Errorf("fix: unexpected field %q", begin)
+	}
+
+	length, err := reader.ReadBytes(fixSOH)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(length, []byte("9=")) {
+		return nil, fmt.Errorf("fix: missing body length, got %q", length)
+	}
+	size, err := strconv.Atoi(string(length[2 : len(length)-1]))
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("fix: invalid body length %q", length)
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+	checksum, err := reader.ReadBytes(fixSOH)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, 0, len(begin)+len(length)+size+len(checksum))
+	raw = append(raw, begin...)
+	raw = append(raw, length...)
+	raw = append(raw, body...)
+	return append(raw, checksum...), nil
+}
+
+// parseFIXMessage 解析一条完整的消息，校验BeginString、BodyLength和CheckSum。
+func parseFIXMessage(raw []byte) (*fixMessage, error) {
+	if len(raw) == 0 || raw[len(raw)-1] != fixSOH {
+		return nil, fmt.Errorf("fix: message not terminated by SOH")
+	}
+
+	message := &fixMessage{}
+	var begin string
+	var length, checksum, bodyStart, bodyEnd int
+	offset := 0
+	for _, part := range bytes.Split(raw[:len(raw)-1], []byte{fixSOH}) {
+		end := offset + len(part) + 1
+		index := bytes.IndexByte(part, '=')
+		if index <= 0 {
+			return nil, fmt.Errorf("fix: invalid field %q", part)
+		}
+		tag, err := strconv.Atoi(string(part[:index]))
+		if err != nil {
+			return nil, fmt.Errorf("fix: invalid tag %q", part)
+		}
+		value := string(part[index+1:])
+
+		switch tag {
+		case fixTagBeginString:
+			begin = value
+		case fixTagBodyLength:
+			length, _ = strconv.Atoi(value)
+			bodyStart = end
+		case fixTagCheckSum:
+			checksum, _ = strconv.Atoi(value)
+			bodyEnd = offset
+		default:
+			message.fields = append(message.fields, fixField{Tag: tag, Value: value})
+		}
+		offset = end
+	}
+
+	if begin != fixBeginString {
+		return nil, fmt.Errorf("fix: unsupported begin string %q", begin)
+	}
+	if bodyEnd-bodyStart != length {
+		return nil, fmt.Errorf("fix: body length %d does not match %d", length, bodyEnd-bodyStart)
+	}
+	if sum := fixChecksum(raw[:bodyEnd]); sum != checksum {
+		return nil, fmt.Errorf("fix: checksum %03d does not match %03d", checksum, sum)
+	}
+	if message.Type() == "" {
+		return nil, fmt.Errorf("fix: missing message type")
+	}
+	return message, nil
+}
+
+// fixSession 一个已经连接的FIX会话，负责序号、心跳、重发和登出，业务消息交给handler处理。
+type fixSession struct {
+	conn      net.Conn
+	sender    string
+	target    string
+	heartbeat time.Duration
+	handler   func(*fixMessage) // 处理业务消息，在读取协程中调用
+
+	mtx       sync.Mutex          // mtx 保护以下字段并保证消息按序号顺序写入
+	outSeq    int                 // 下一条发送消息的序号
+	inSeq     int                 // 期望收到的下一条消息的序号
+	sent      map[int]*fixMessage // 已经发送的业务消息，用于对方的ResendRequest
+	lastSent  time.Time
+	lastRecv  time.Time
+	testSent  bool // 已经发送TestRequest，等待对方的Heartbeat
+	resending bool // 已经发送ResendRequest，等待对方重发
+	loggedOn  bool
+	loggedOut bool // 已经发送Logout
+
+	logon chan struct{} // 收到对方的Logon后关闭
+	done  chan struct{} // 连接断开后关闭
+	err   error         // 连接断开的原因
+}
+
+// newFIXSession 在已经建立的连接上创建会话，调用Logon完成登录。
+func newFIXSession(conn net.Conn, sender, target string, heartbeat time.Duration,
+	handler func(*fixMessage)) *fixSession {
+	now := time.Now()
+	return &fixSession{
+		conn:      conn,
+		sender:    sender,
+		target:    target,
+		heartbeat: heartbeat,
+		handler:   handler,
+		outSeq:    1,
+		inSeq:     1,
+		sent:      make(map[int]*fixMessage),
+		lastSent:  now,
+		lastRecv:  now,
+		logon:     make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Logon 启动读取和心跳协程，发送Logon并等待对方确认。
+func (s *fixSession) Logon(username, password string, timeout time.Duration) error {
+	go s.read()
+	go s.keepalive()
+
+	logon := newFIXMessage(fixMsgLogon).
+		Set(fixTagEncryptMethod, "0").
+		Set(fixTagHeartBtInt, strconv.Itoa(int(s.heartbeat/time.Second))).
+		Set(fixTagResetSeqNumFlag, "Y")
+	if username != "" {
+		logon.Set(fixTagUsername, username)
+	}
+	if password != "" {
+		logon.Set(fixTagPassword, password)
+	}
+	if err := s.Send(logon); err != nil {
+		s.Close(err)
+		return err
+	}
+
+	select {
+	case <-s.logon:
+		return nil
+	case <-s.done:
+		return fmt.Errorf("fix logon: %w", s.Err())
+	case <-time.After(timeout):
+		s.Close(ErrFIXTimeout)
+		return fmt.Errorf("fix logon: %w", ErrFIXTimeout)
+	}
+}
+
+// Send 填写头部并发送消息，业务消息保存下来用于重发。
+func (s *fixSession) Send(message *fixMessage) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	select {
+	case <-s.done:
+		return ErrFIXNotConnected
+	default:
+	}
+
+	message.Set(fixTagSenderCompID, s.sender).
+		Set(fixTagTargetCompID, s.target).
+		Set(fixTagMsgSeqNum, strconv.Itoa(s.outSeq)).
+		Set(fixTagSendingTime, time.Now().UTC().Format(fixTimeFormat))
+	if !fixAdminMessage(message.Type()) {
+		s.sent[s.outSeq] = message.clone()
+	}
+	s.outSeq++
+	return s.write(message)
+}
+
+// write 写入连接，调用方需要持有锁。
+func (s *fixSession) write(message *fixMessage) error {
+	s.lastSent = time.Now()
+	_ = s.conn.SetWriteDeadline(time.Now().Add(s.heartbeat))
+	if _, err := s.conn.Write(message.Bytes()); err != nil {
+		go s.Close(err)
+		return err
+	}
+	return nil
+}
+
+// Done 返回连接断开后关闭的通道。
+func (s *fixSession) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err 返回连接断开的原因。
+func (s *fixSession) Err() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.err
+}
+
+// LoggedOn 判断会话是否已经登录并且没有断开。
+func (s *fixSession) LoggedOn() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	select {
+	case <-s.done:
+		return false
+	default:
+		return s.loggedOn
+	}
+}
+
+// Logout 发送Logout，等待对方确认后断开连接。
+func (s *fixSession) Logout(timeout time.Duration) {
+	s.mtx.Lock()
+	s.loggedOut = true
+	s.mtx.Unlock()
+
+	if err := s.Send(newFIXMessage(fixMsgLogout)); err == nil {
+		select {
+		case <-s.done:
+			return
+		case <-time.After(timeout):
+		}
+	}
+	s.Close(nil)
+}
+
+// Close 关闭连接，只有第一次调用的原因会被记录。
+func (s *fixSession) Close(err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	if err == nil {
+		err = io.EOF
+	}
+	s.err = err
+	_ = s.conn.Close()
+	close(s.done)
+}
+
+// read 读取并处理消息，直到连接断开。
+func (s *fixSession) read() {
+	reader := bufio.NewReader(s.conn)
+	for {
+		raw, err := readFIXMessage(reader)
+		if err != nil {
+			s.Close(err)
+			return
+		}
+
+		message, err := parseFIXMessage(raw)
+		if err != nil {
+			// 格式错误的消息无法确认序号，忽略后由对方的重发或者心跳检测恢复
+			log.Warnf("fix: %v", err)
+			continue
+		}
+
+		if app := s.process(message); app {
+			s.handler(message)
+		}
+	}
+}
+
+// process 处理会话层的逻辑，返回消息是否需要交给handler处理。
+func (s *fixSession) process(message *fixMessage) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.lastRecv = time.Now()
+	s.testSent = false
+
+	msgType := message.Type()
+	seq := message.Int(fixTagMsgSeqNum)
+
+	// 不带GapFill的SequenceReset直接重置期望的序号，不检查消息自己的序号
+	if msgType == fixMsgSequenceReset && message.Get(fixTagGapFillFlag) != "Y" {
+		if next := message.Int(fixTagNewSeqNo); next > s.inSeq {
+			s.inSeq = next
+		}
+		return false
+	}
+
+	switch {
+	case seq > s.inSeq && msgType != fixMsgLogout:
+		// 中间的消息丢失，请求对方从期望的序号开始重发，这条消息会在重发中再次收到
+		if !s.resending {
+			s.resending = true
+			request := newFIXMessage(fixMsgResendRequest).
+				Set(fixTagBeginSeqNo, strconv.Itoa(s.inSeq)).
+				Set(fixTagEndSeqNo, "0")
+			s.sendLocked(request)
+		}
+		return false
+	case seq < s.inSeq:
+		if message.Get(fixTagPossDupFlag) == "Y" {
+			return false // 已经处理过的重发消息
+		}
+		s.sendLocked(newFIXMessage(fixMsgLogout).
+			Set(fixTagText, fmt.Sprintf("MsgSeqNum too low, expecting %d but received %d", s.inSeq, seq)))
+		go s.Close(fmt.Errorf("fix: sequence number %d lower than expected %d", seq, s.inSeq))
+		return false
+	}
+
+	s.inSeq = seq + 1
+	s.resending = false
+
+	switch msgType {
+	case fixMsgLogon:
+		if !s.loggedOn {
+			s.loggedOn = true
+			close(s.logon)
+		}
+	case fixMsgHeartbeat:
+	case fixMsgTestRequest:
+		s.sendLocked(newFIXMessage(fixMsgHeartbeat).Set(fixTagTestReqID, message.Get(fixTagTestReqID)))
+	case fixMsgResendRequest:
+		s.resend(message.Int(fixTagBeginSeqNo), message.Int(fixTagEndSeqNo))
+	case fixMsgSequenceReset:
+		if next := message.Int(fixTagNewSeqNo); next > s.inSeq {
+			s.inSeq = next
+		}
+	case fixMsgReject:
+		log.Errorf("fix: message %s rejected: %s", message.Get(fixTagRefSeqNum), message.Get(fixTagText))
+	case fixMsgLogout:
+		// 对方主动登出时回复Logout，自己发起的登出收到确认后断开
+		if !s.loggedOut {
+			s.sendLocked(newFIXMessage(fixMsgLogout))
+		}
+		go s.Close(fmt.Errorf("fix: logout %s", message.Get(fixTagText)))
+	default:
+		return true
+	}
+	return false
+}
+
+// sendLocked 在持有锁时发送会话消息，会话消息不保存用于重发。
+func (s *fixSession) sendLocked(message *fixMessage) {
+	message.Set(fixTagSenderCompID, s.sender).
+		Set(fixTagTargetCompID, s.target).
+		Set(fixTagMsgSeqNum, strconv.Itoa(s.outSeq)).
+		Set(fixTagSendingTime, time.Now().UTC().Format(fixTimeFormat))
+	s.outSeq++
+	_ = s.write(message)
+}
+
+// resend 按对方的ResendRequest重发[begin, end]之间的消息，end为0表示到最后一条。
+// 业务消息原样重发并标记PossDupFlag，连续的会话消息合并为一个GapFill。
+func (s *fixSession) resend(begin, end int) {
+	last := s.outSeq - 1
+	if end == 0 || end > last {
+		end = last
+	}
+
+	gapFill := func(from, next int) {
+		if from >= next {
+			return
+		}
+		_ = s.write(newFIXMessage(fixMsgSequenceReset).
+			Set(fixTagSenderCompID, s.sender).
+			Set(fixTagTargetCompID, s.target).
+			Set(fixTagMsgSeqNum, strconv.Itoa(from)).
+			Set(fixTagPossDupFlag, "Y").
+			Set(fixTagSendingTime, time.Now().UTC().Format(fixTimeFormat)).
+			Set(fixTagGapFillFlag, "Y").
+			Set(fixTagNewSeqNo, strconv.Itoa(next)))
+	}
+
+	gap := begin
+	for seq := begin; seq <= end; seq++ {
+		original, ok := s.sent[seq]
+		if !ok {
+			continue
+		}
+		gapFill(gap, seq)
+		gap = seq + 1
+
+		message := original.clone()
+		message.Set(fixTagPossDupFlag, "Y").
+			Set(fixTagOrigSendingTime, original.Get(fixTagSendingTime)).
+			Set(fixTagSendingTime, time.Now().UTC().Format(fixTimeFormat))
+		_ = s.write(message)
+	}
+	gapFill(gap, end+1)
+}
+
+// keepalive 按心跳间隔发送Heartbeat，检测对方是否仍然在线。
+func (s *fixSession) keepalive() {
+	ticker := time.NewTicker(s.heartbeat / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.mtx.Lock()
+			idle := now.Sub(s.lastRecv)
+			if idle > 2*s.heartbeat {
+				s.mtx.Unlock()
+				s.Close(fmt.Errorf("fix: no message received in %s", idle.Round(time.Second)))
+				return
+			}
+			if now.Sub(s.lastSent) >= s.heartbeat {
+				s.sendLocked(newFIXMessage(fixMsgHeartbeat))
+			}
+			if idle > s.heartbeat && !s.testSent {
+				s.testSent = true
+				s.sendLocked(newFIXMessage(fixMsgTestRequest).Set(fixTagTestReqID, strconv.FormatInt(now.UnixNano(), 10)))
+			}
+			s.mtx.Unlock()
+		}
+	}
+}
+
+// fixAdminMessage 判断是否是会话层的消息，会话消息在重发时用GapFill跳过。
+func fixAdminMessage(msgType string) bool {
+	switch msgType {
+	case fixMsgHeartbeat, fixMsgTestRequest, fixMsgResendRequest, fixMsgReject,
+		fixMsgSequenceReset, fixMsgLogout, fixMsgLogon:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,1001 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jpillora/backoff"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+	"github.com/rodrigo-brito/ninjabot/tools/log"
+)
+
+/*
+FIX 通过FIX 4.4会话实现service.Broker，用于只提供FIX接口的交易场所和主经纪商。
+下单使用NewOrderSingle(D)，撤单使用OrderCancelRequest(F)，订单状态完全由对方推送的ExecutionReport(8)驱动，
+OrderCancelReject(9)表示撤单失败。FIX没有行情接口，K线等市场数据交给WithFIXFeeder设置的Feeder，
+所以设置了Feeder的FIX实现完整的service.Exchange。
+
+ExchangeID由下单时的ClOrdID得到：没有指定客户端订单ID时ClOrdID是递增的数字，ExchangeID就是这个数字；
+指定了客户端订单ID时ClOrdID就是客户端订单ID，ExchangeID通过stringExchangeID转换。
+FIX 4.4没有统一的资金查询消息，账户余额是本地账本：初始余额由WithFIXBalance设置，之后按成交回报更新，
+挂单占用的资金计入Lock。会话断开后按指数退避重连，重连后对所有挂单发送OrderStatusRequest(H)同步断开期间的变化。
+*/
+
+// fixOrdRejErrors 将ExecutionReport的OrdRejReason映射为通用错误。
+var fixOrdRejErrors = map[string]error{
+	"1":  ErrInvalidAsset,      // Unknown symbol
+	"3":  ErrInsufficientFunds, // Order exceeds limit
+	"5":  ErrOrderNotFound,     // Unknown order
+	"11": ErrNotSupported,      // Unsupported order characteristic
+	"13": ErrInvalidQuantity,   // Incorrect quantity
+}
+
+// fixCxlRejErrors 将OrderCancelReject的CxlRejReason映射为通用错误。
+var fixCxlRejErrors = map[string]error{
+	"1": ErrOrderNotFound, // Unknown order
+}
+
+// FIXError 对方拒绝订单（OrdStatus=8）或者拒绝撤单（OrderCancelReject）。
+type FIXError struct {
+	MsgType string // 拒绝的消息类型，ExecutionReport或者OrderCancelReject
+	Reason  string // OrdRejReason或者CxlRejReason
+	Text    string
+}
+
+// Error 实现error接口。
+func (e *FIXError) Error() string {
+	return fmt.Sprintf("fix reject (reason %s): %s", e.Reason, e.Text)
+}
+
+// Unwrap 返回拒绝原因对应的通用错误，没有对应的通用错误时返回nil。
+func (e *FIXError) Unwrap() error {
+	if e.MsgType == fixMsgOrderCancelReject {
+		return fixCxlRejErrors[e.Reason]
+	}
+	return fixOrdRejErrors[e.Reason]
+}
+
+// fixOrder 本地记录的订单状态，Order中的Price和Quantity是下单的价格和数量。
+type fixOrder struct {
+	model.Order
+	clOrdID   string  // 下单时的ClOrdID
+	orderID   string  // 对方分配的OrderID
+	symbol    string  // FIX中的Symbol
+	ordStatus string  // 最近一次回报的OrdStatus
+	cumQty    float64 // 累计成交数量
+	avgPx     float64 // 成交均价
+	text      string  // 最近一次回报的Text
+	reason    string  // 被拒绝时的OrdRejReason
+}
+
+// model 返回内部订单模型，有成交时价格和数量是成交均价和成交数量，与其他交易所一致。
+func (o *fixOrder) model() model.Order {
+	order := o.Order
+	if o.cumQty > 0 {
+		order.Price = o.avgPx
+		order.Quantity = o.cumQty
+	}
+	return order
+}
+
+// fixQueue 一个订单订阅者的无界队列，读取协程不会因为订阅者处理慢而阻塞。
+type fixQueue struct {
+	mtx    sync.Mutex
+	orders []model.Order
+	errs   []error
+	signal chan struct{}
+}
+
+// push 加入订单或者错误并唤醒订阅协程。
+func (q *fixQueue) push(order *model.Order, err error) {
+	q.mtx.Lock()
+	if order != nil {
+		q.orders = append(q.orders, *order)
+	}
+	if err != nil {
+		q.errs = append(q.errs, err)
+	}
+	q.mtx.Unlock()
+
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// take 取出队列中所有的订单和错误。
+func (q *fixQueue) take() ([]model.Order, []error) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	orders, errs := q.orders, q.errs
+	q.orders, q.errs = nil, nil
+	return orders, errs
+}
+
+// FIX 结构体封装了FIX会话和本地记录的订单与余额。
+type FIX struct {
+	ctx     context.Context // ctx 取消时登出会话
+	session *fixSession     // session 当前的会话，重连时替换
+
+	mtx      sync.Mutex                  // mtx 保护以下字段
+	orders   map[string]*fixOrder        // orders ClOrdID到订单的对应关系
+	ids      map[int64]string            // ids ExchangeID到ClOrdID的对应关系
+	requests map[string]string           // requests 撤单请求的ClOrdID到原订单ClOrdID的对应关系
+	waiters  map[string]chan *fixMessage // waiters 等待回报的下单或撤单请求，按请求的ClOrdID索引
+	balances map[string]float64          // balances 每种资产的总余额，包括挂单占用的部分
+	pairs    map[string]string           // pairs Symbol到交易对的对应关系
+	queues   map[*fixQueue]struct{}      // queues 订单更新的订阅者
+	lastID   int64                       // lastID 最近一次生成的ClOrdID
+
+	Address      string        // Address 对方的地址，host:port
+	SenderCompID string        // SenderCompID 己方的CompID
+	TargetCompID string        // TargetCompID 对方的CompID
+	Username     string        // Username Logon中的用户名，为空时不发送
+	Password     string        // Password Logon中的密码，为空时不发送
+	AccountID    string        // AccountID 下单时的账户（Account字段），为空时不发送
+	HeartBtInt   time.Duration // HeartBtInt 心跳间隔，默认30秒
+	Timeout      time.Duration // Timeout 等待登录确认和订单回报的超时时间，默认10秒
+
+	Symbols map[string]string // Symbols 交易对到FIX Symbol的对应关系，没有设置的交易对直接使用交易对名称
+	Feeder  service.Feeder    // Feeder 提供市场数据，为空时Feeder的方法返回ErrNotSupported
+}
+
+// FIXOption 定义了一个函数类型，用于通过不同的配置选项来定制化FIX实例。
+type FIXOption func(*FIX)
+
+// WithFIXCredentials 设置Logon中的用户名（Username）和密码（Password）。
+func WithFIXCredentials(username, password string) FIXOption {
+	return func(f *FIX) {
+		f.Username = username
+		f.Password = password
+	}
+}
+
+// WithFIXAccount 设置下单时的账户（Account字段）。
+func WithFIXAccount(account string) FIXOption {
+	return func(f *FIX) {
+		f.AccountID = account
+	}
+}
+
+// WithFIXHeartbeat 设置心跳间隔，FIX的心跳间隔以秒为单位。
+func WithFIXHeartbeat(interval time.Duration) FIXOption {
+	return func(f *FIX) {
+		f.HeartBtInt = interval
+	}
+}
+
+// WithFIXTimeout 设置等待登录确认和订单回报的超时时间。
+func WithFIXTimeout(timeout time.Duration) FIXOption {
+	return func(f *FIX) {
+		f.Timeout = timeout
+	}
+}
+
+// WithFIXSymbol 设置交易对在FIX中的Symbol，例如BTCUSDT对应BTC/USDT。
+func WithFIXSymbol(pair, symbol string) FIXOption {
+	return func(f *FIX) {
+		f.Symbols[strings.ToUpper(pair)] = symbol
+	}
+}
+
+// WithFIXBalance 设置资产的初始余额，FIX没有统一的资金查询，账户余额从初始余额开始按成交更新。
+func WithFIXBalance(asset string, amount float64) FIXOption {
+	return func(f *FIX) {
+		f.balances[strings.ToUpper(asset)] = amount
+	}
+}
+
+// WithFIXFeeder 设置提供市场数据的Feeder，例如币安的行情，设置后FIX实现完整的service.Exchange。
+func WithFIXFeeder(feeder service.Feeder) FIXOption {
+	return func(f *FIX) {
+		f.Feeder = feeder
+	}
+}
+
+// NewFIX 连接address并登录FIX会话，ctx取消时登出。会话断开后在后台自动重连。
+func NewFIX(ctx context.Context, address, senderCompID, targetCompID string, options ...FIXOption) (*FIX, error) {
+	exchange := &FIX{
+		ctx:          ctx,
+		orders:       make(map[string]*fixOrder),
+		ids:          make(map[int64]string),
+		requests:     make(map[string]string),
+		waiters:      make(map[string]chan *fixMessage),
+		balances:     make(map[string]float64),
+		pairs:        make(map[string]string),
+		queues:       make(map[*fixQueue]struct{}),
+		Address:      address,
+		SenderCompID: senderCompID,
+		TargetCompID: targetCompID,
+		HeartBtInt:   30 * time.Second,
+		Timeout:      10 * time.Second,
+		Symbols:      make(map[string]string),
+	}
+
+	for _, option := range options {
+		option(exchange)
+	}
+
+	if exchange.HeartBtInt < time.Second {
+		return nil, fmt.Errorf("fix: heartbeat interval must be at least 1s")
+	}
+	for pair, symbol := range exchange.Symbols {
+		exchange.pairs[symbol] = pair
+	}
+
+	if err := exchange.connect(); err != nil {
+		return nil, err
+	}
+	go exchange.maintain()
+
+	log.Infof("[SETUP] Using FIX session %s -> %s (%s)", senderCompID, targetCompID, address)
+	return exchange, nil
+}
+
+// connect 建立连接并登录，替换当前的会话。
+func (f *FIX) connect() error {
+	conn, err := net.DialTimeout("tcp", f.Address, f.Timeout)
+	if err != nil {
+		return fmt.Errorf("fix connect: %w", err)
+	}
+
+	session := newFIXSession(conn, f.SenderCompID, f.TargetCompID, f.HeartBtInt, f.onMessage)
+	if err := session.Logon(f.Username, f.Password, f.Timeout); err != nil {
+		return err
+	}
+
+	f.mtx.Lock()
+	f.session = session
+	f.mtx.Unlock()
+	return nil
+}
+
+// maintain 会话断开后按指数退避重连，重连后同步挂单的状态，ctx取消时登出。
+func (f *FIX) maintain() {
+	ba := &backoff.Backoff{
+		Min: 100 * time.Millisecond,
+		Max: 10 * time.Second,
+	}
+
+	for {
+		session := f.current()
+		select {
+		case <-f.ctx.Done():
+			session.Logout(f.Timeout)
+			return
+		case <-session.Done():
+		}
+
+		err := fmt.Errorf("fix session disconnected: %w", session.Err())
+		log.Warn(err)
+		f.publish(nil, err)
+
+		for {
+			select {
+			case <-f.ctx.Done():
+				return
+			case <-time.After(ba.Duration()):
+			}
+
+			if err := f.connect(); err != nil {
+				log.Warnf("fix reconnect: %v", err)
+				continue
+			}
+			ba.Reset()
+			f.syncOpenOrders()
+			break
+		}
+	}
+}
+
+// current 返回当前的会话。
+func (f *FIX) current() *fixSession {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.session
+}
+
+// send 通过当前的会话发送消息。
+func (f *FIX) send(message *fixMessage) error {
+	session := f.current()
+	if !session.LoggedOn() {
+		return ErrFIXNotConnected
+	}
+	return session.Send(message)
+}
+
+// syncOpenOrders 对所有挂单发送OrderStatusRequest，对方通过ExecutionReport返回最新的状态。
+func (f *FIX) syncOpenOrders() {
+	f.mtx.Lock()
+	var requests []*fixMessage
+	for _, order := range f.orders {
+		if !fixOpenStatus(order.Status) {
+			continue
+		}
+		request := newFIXMessage(fixMsgOrderStatusRequest).
+			Set(fixTagClOrdID, order.clOrdID).
+			Set(fixTagSymbol, order.symbol).
+			Set(fixTagSide, fixSide(order.Side))
+		if order.orderID != "" {
+			request.Set(fixTagOrderID, order.orderID)
+		}
+		requests = append(requests, request)
+	}
+	f.mtx.Unlock()
+
+	for _, request := range requests {
+		if err := f.send(request); err != nil {
+			log.Warnf("fix order status request: %v", err)
+			return
+		}
+	}
+}
+
+// symbol 返回交易对在FIX中的Symbol。
+func (f *FIX) symbol(pair string) string {
+	if symbol, ok := f.Symbols[pair]; ok {
+		return symbol
+	}
+	return pair
+}
+
+// pair 返回FIX Symbol对应的交易对。
+func (f *FIX) pair(symbol string) string {
+	if pair, ok := f.pairs[symbol]; ok {
+		return pair
+	}
+	return symbol
+}
+
+// nextClOrdID 生成递增的数字ClOrdID，从当前的微秒时间开始，重启后也不会与之前的ClOrdID重复。
+func (f *FIX) nextClOrdID() string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	id := time.Now().UnixNano() / int64(time.Microsecond)
+	if id <= f.lastID {
+		id = f.lastID + 1
+	}
+	f.lastID = id
+	return strconv.FormatInt(id, 10)
+}
+
+// fixSide 将订单方向转换为FIX的Side。
+func fixSide(side model.SideType) string {
+	if side == model.SideTypeSell {
+		return "2"
+	}
+	return "1"
+}
+
+// fixTimeInForce 将订单参数转换为FIX的TimeInForce和ExecInst，只做挂单使用ExecInst=6（Participate don't initiate）。
+func fixTimeInForce(message *fixMessage, params model.OrderParams) model.TimeInForceType {
+	timeInForce := params.TimeInForce
+	if timeInForce == "" {
+		timeInForce = model.TimeInForceGTC
+	}
+
+	switch {
+	case params.ExpireAt != nil:
+		message.Set(fixTagTimeInForce, "6") // Good Till Date
+		message.Set(fixTagExpireTime, params.ExpireAt.UTC().Format(fixTimeFormat))
+	case timeInForce == model.TimeInForceIOC:
+		message.Set(fixTagTimeInForce, "3")
+	case timeInForce == model.TimeInForceFOK:
+		message.Set(fixTagTimeInForce, "4")
+	default:
+		message.Set(fixTagTimeInForce, "1")
+	}
+	if timeInForce == model.TimeInForceGTX {
+		message.Set(fixTagExecInst, "6")
+	}
+	return timeInForce
+}
+
+// fixOrderStatus 将FIX的OrdStatus转换为内部的订单状态。
+func fixOrderStatus(status string) model.OrderStatusType {
+	switch status {
+	case "1":
+		return model.OrderStatusTypePartiallyFilled
+	case "2", "B": // Filled、Calculated
+		return model.OrderStatusTypeFilled
+	case "3", "4": // Done for day、Canceled
+		return model.OrderStatusTypeCanceled
+	case "6":
+		return model.OrderStatusTypePendingCancel
+	case "8":
+		return model.OrderStatusTypeRejected
+	case "C":
+		return model.OrderStatusTypeExpired
+	default: // New、Pending New、Pending Replace、Suspended等
+		return model.OrderStatusTypeNew
+	}
+}
+
+// fixOpenStatus 判断订单是否仍在挂单中。
+func fixOpenStatus(status model.OrderStatusType) bool {
+	return status == model.OrderStatusTypeNew || status == model.OrderStatusTypePartiallyFilled ||
+		status == model.OrderStatusTypePendingCancel
+}
+
+// fixTime 解析FIX的UTC时间，格式错误时返回当前时间。
+func fixTime(value string) time.Time {
+	for _, layout := range []string{fixTimeFormat, "20060102-15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Now()
+}
+
+// onMessage 处理对方发送的业务消息，在会话的读取协程中调用。
+func (f *FIX) onMessage(message *fixMessage) {
+	switch message.Type() {
+	case fixMsgExecutionReport:
+		f.onExecutionReport(message)
+	case fixMsgOrderCancelReject:
+		f.mtx.Lock()
+		waiter := f.waiters[message.Get(fixTagClOrdID)]
+		f.mtx.Unlock()
+		notify(waiter, message)
+	default:
+		log.Debugf("fix: ignored message %s", message)
+	}
+}
+
+// onExecutionReport 根据ExecutionReport更新订单状态和余额，通知等待的请求和订阅者。
+func (f *FIX) onExecutionReport(message *fixMessage) {
+	f.mtx.Lock()
+
+	clOrdID := message.Get(fixTagClOrdID)
+	original := clOrdID
+	if id, ok := f.requests[clOrdID]; ok {
+		original = id
+	} else if _, ok := f.orders[clOrdID]; !ok && message.Has(fixTagOrigClOrdID) {
+		original = message.Get(fixTagOrigClOrdID)
+	}
+
+	order, ok := f.orders[original]
+	if !ok {
+		// 不是本实例提交的订单（例如手动下单或者重启前的订单），按回报中的信息记录
+		order = f.newOrder(original, message)
+	}
+
+	if id := message.Get(fixTagOrderID); id != "" && id != "NONE" {
+		order.orderID = id
+	}
+
+	// 按这次回报的成交数量更新余额，没有LastQty时用累计成交数量的变化计算
+	lastQty, lastPx := message.Float(fixTagLastQty), message.Float(fixTagLastPx)
+	cumQty, avgPx := message.Float(fixTagCumQty), message.Float(fixTagAvgPx)
+	if lastQty <= 0 && cumQty > order.cumQty {
+		lastQty = cumQty - order.cumQty
+		lastPx = (cumQty*avgPx - order.cumQty*order.avgPx) / lastQty
+	}
+	if lastQty > 0 && cumQty >= order.cumQty {
+		f.settle(order, lastQty, lastPx)
+	}
+	if cumQty >= order.cumQty {
+		order.cumQty, order.avgPx = cumQty, avgPx
+	}
+
+	order.ordStatus = message.Get(fixTagOrdStatus)
+	order.Status = fixOrderStatus(order.ordStatus)
+	order.UpdatedAt = fixTime(message.Get(fixTagTransactTime))
+	order.text = message.Get(fixTagText)
+	order.reason = message.Get(fixTagOrdRejReason)
+
+	update := order.model()
+	waiter := f.waiters[clOrdID]
+	f.mtx.Unlock()
+
+	notify(waiter, message)
+	f.publish(&update, nil)
+}
+
+// notify 唤醒等待回报的请求，请求已经返回或者通道已满时直接丢弃，请求会重新读取订单的状态。
+func notify(waiter chan *fixMessage, message *fixMessage) {
+	if waiter == nil {
+		return
+	}
+	select {
+	case waiter <- message:
+	default:
+	}
+}
+
+// newOrder 根据回报记录一个本地没有的订单，调用方需要持有锁。
+func (f *FIX) newOrder(clOrdID string, message *fixMessage) *fixOrder {
+	order := &fixOrder{
+		Order: model.Order{
+			ExchangeID:  stringExchangeID(clOrdID),
+			Pair:        f.pair(message.Get(fixTagSymbol)),
+			Side:        model.SideTypeBuy,
+			Type:        model.OrderTypeLimit,
+			Price:       message.Float(fixTagPrice),
+			Quantity:    message.Float(fixTagOrderQty),
+			CreatedAt:   fixTime(message.Get(fixTagTransactTime)),
+			TimeInForce: model.TimeInForceGTC,
+		},
+		clOrdID: clOrdID,
+		symbol:  message.Get(fixTagSymbol),
+	}
+	if message.Get(fixTagSide) == "2" {
+		order.Side = model.SideTypeSell
+	}
+	switch message.Get(fixTagOrdType) {
+	case "1":
+		order.Type = model.OrderTypeMarket
+	case "3":
+		order.Type = model.OrderTypeStopLoss
+	case "4":
+		order.Type = model.OrderTypeStopLossLimit
+	}
+	f.orders[clOrdID] = order
+	f.ids[order.ExchangeID] = clOrdID
+	return order
+}
+
+// settle 按成交更新本地余额，调用方需要持有锁。
+func (f *FIX) settle(order *fixOrder, quantity, price float64) {
+	info := f.AssetsInfo(order.Pair)
+	if order.Side == model.SideTypeBuy {
+		f.balances[info.BaseAsset] += quantity
+		f.balances[info.QuoteAsset] -= quantity * price
+	} else {
+		f.balances[info.BaseAsset] -= quantity
+		f.balances[info.QuoteAsset] += quantity * price
+	}
+}
+
+// publish 将订单更新或者错误加入所有订阅者的队列。
+func (f *FIX) publish(order *model.Order, err error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	for queue := range f.queues {
+		queue.push(order, err)
+	}
+}
+
+// OrderSubscription 订阅ExecutionReport带来的订单更新，会话断开时发送错误，ctx取消时关闭通道。
+func (f *FIX) OrderSubscription(ctx context.Context) (chan model.Order, chan error) {
+	corder := make(chan model.Order)
+	cerr := make(chan error)
+	queue := &fixQueue{signal: make(chan struct{}, 1)}
+
+	f.mtx.Lock()
+	f.queues[queue] = struct{}{}
+	f.mtx.Unlock()
+
+	go func() {
+		defer close(cerr)
+		defer close(corder)
+		defer func() {
+			f.mtx.Lock()
+			delete(f.queues, queue)
+			f.mtx.Unlock()
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-queue.signal:
+			}
+
+			orders, errs := queue.take()
+			for _, err := range errs {
+				select {
+				case cerr <- err:
+				case <-ctx.Done():
+					return
+				}
+			}
+			for _, order := range orders {
+				select {
+				case corder <- order:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return corder, cerr
+}
+
+// submit 发送NewOrderSingle并等待回报，done判断收到的回报是否已经可以返回。
+// 订单被拒绝时返回FIXError，超时时返回ErrFIXTimeout。
+func (f *FIX) submit(order model.Order, message *fixMessage, params model.OrderParams,
+	done func(ordStatus string) bool) (model.Order, error) {
+	if order.Quantity <= 0 && !message.Has(fixTagCashOrderQty) {
+		return model.Order{}, &OrderError{Err: ErrInvalidQuantity, Pair: order.Pair, Quantity: order.Quantity}
+	}
+
+	clOrdID := params.ClientOrderID
+	if clOrdID == "" {
+		clOrdID = f.nextClOrdID()
+	}
+
+	f.mtx.Lock()
+	if existing, ok := f.orders[clOrdID]; ok {
+		// 重复提交相同客户端订单ID的订单返回已存在的订单
+		f.mtx.Unlock()
+		return existing.model(), nil
+	}
+
+	now := time.Now()
+	order.ExchangeID = stringExchangeID(clOrdID)
+	order.Status = model.OrderStatusTypeNew
+	order.CreatedAt, order.UpdatedAt = now, now
+	params.Apply(&order)
+	local := &fixOrder{Order: order, clOrdID: clOrdID, symbol: f.symbol(order.Pair), ordStatus: "A"}
+	f.orders[clOrdID] = local
+	f.ids[order.ExchangeID] = clOrdID
+	waiter := make(chan *fixMessage, 16)
+	f.waiters[clOrdID] = waiter
+	f.mtx.Unlock()
+
+	defer func() {
+		f.mtx.Lock()
+		delete(f.waiters, clOrdID)
+		f.mtx.Unlock()
+	}()
+
+	message.Set(fixTagClOrdID, clOrdID).
+		Set(fixTagSymbol, local.symbol).
+		Set(fixTagSide, fixSide(order.Side)).
+		Set(fixTagTransactTime, now.UTC().Format(fixTimeFormat))
+	if f.AccountID != "" {
+		message.Set(fixTagAccount, f.AccountID)
+	}
+	if order.Quantity > 0 {
+		message.Set(fixTagOrderQty, strconv.FormatFloat(order.Quantity, 'f', -1, 64))
+	}
+
+	if err := f.send(message); err != nil {
+		f.forget(clOrdID)
+		return model.Order{}, err
+	}
+
+	timeout := time.After(f.Timeout)
+	for {
+		select {
+		case <-waiter:
+		case <-timeout:
+			return model.Order{}, fmt.Errorf("fix order %s: %w", clOrdID, ErrFIXTimeout)
+		}
+
+		f.mtx.Lock()
+		status, result := local.ordStatus, local.model()
+		reject := &FIXError{MsgType: fixMsgExecutionReport, Reason: local.reason, Text: local.text}
+		f.mtx.Unlock()
+
+		if status == "8" {
+			return model.Order{}, &OrderError{Err: reject, Pair: order.Pair, Quantity: order.Quantity}
+		}
+		if done(status) {
+			return result, nil
+		}
+	}
+}
+
+// forget 删除没有发送成功的订单。
+func (f *FIX) forget(clOrdID string) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if order, ok := f.orders[clOrdID]; ok {
+		delete(f.ids, order.ExchangeID)
+		delete(f.orders, clOrdID)
+	}
+}
+
+// fixAccepted 挂单类订单收到确认（不再是Pending New）后返回。
+func fixAccepted(status string) bool {
+	return status != "A"
+}
+
+// fixFinished 市价单等到订单结束后返回，Controller需要成交均价。
+func fixFinished(status string) bool {
+	switch status {
+	case "2", "3", "4", "B", "C":
+		return true
+	}
+	return false
+}
+
+// CreateOrderOCO FIX 4.4没有标准的OCO订单（ContingencyType在FIX 5.0才加入），返回ErrNotSupported。
+func (f *FIX) CreateOrderOCO(_ model.SideType, _ string, _, _, _, _ float64,
+	_ ...model.OrderOption) ([]model.Order, error) {
+	return nil, fmt.Errorf("%w: fix oco order", ErrNotSupported)
+}
+
+// CreateOrderLimit 创建限价单，只做挂单通过ExecInst=6实现，指定过期时间时使用GTD。
+func (f *FIX) CreateOrderLimit(side model.SideType, pair string, quantity float64, limit float64,
+	options ...model.OrderOption) (model.Order, error) {
+	params := model.NewOrderParams(options...)
+	message := newFIXMessage(fixMsgNewOrderSingle).
+		Set(fixTagOrdType, "2").
+		Set(fixTagPrice, strconv.FormatFloat(limit, 'f', -1, 64))
+
+	order := model.Order{
+		Pair:     pair,
+		Side:     side,
+		Type:     model.OrderTypeLimit,
+		Price:    limit,
+		Quantity: quantity,
+	}
+	order.TimeInForce = fixTimeInForce(message, params)
+	if order.TimeInForce == model.TimeInForceGTX {
+		order.Type = model.OrderTypeLimitMaker
+	}
+	return f.submit(order, message, params, fixAccepted)
+}
+
+// CreateOrderMarket 创建市价单，等待订单成交后返回成交均价。
+func (f *FIX) CreateOrderMarket(side model.SideType, pair string, quantity float64,
+	options ...model.OrderOption) (model.Order, error) {
+	order := model.Order{
+		Pair:     pair,
+		Side:     side,
+		Type:     model.OrderTypeMarket,
+		Quantity: quantity,
+	}
+	message := newFIXMessage(fixMsgNewOrderSingle).Set(fixTagOrdType, "1")
+	return f.submit(order, message, model.NewOrderParams(options...), fixFinished)
+}
+
+// CreateOrderMarketQuote 按计价资产的金额（CashOrderQty）创建市价单，需要对方支持CashOrderQty。
+func (f *FIX) CreateOrderMarketQuote(side model.SideType, pair string, quote float64,
+	options ...model.OrderOption) (model.Order, error) {
+	if quote <= 0 {
+		return model.Order{}, &OrderError{Err: ErrInvalidQuantity, Pair: pair, Quantity: quote}
+	}
+
+	order := model.Order{
+		Pair: pair,
+		Side: side,
+		Type: model.OrderTypeMarket,
+	}
+	message := newFIXMessage(fixMsgNewOrderSingle).
+		Set(fixTagOrdType, "1").
+		Set(fixTagCashOrderQty, strconv.FormatFloat(quote, 'f', -1, 64))
+	return f.submit(order, message, model.NewOrderParams(options...), fixFinished)
+}
+
+// CreateOrderStop 创建止损卖单（OrdType=3），价格跌破limit后以市价卖出。
+func (f *FIX) CreateOrderStop(pair string, quantity float64, limit float64,
+	options ...model.OrderOption) (model.Order, error) {
+	order := model.Order{
+		Pair:        pair,
+		Side:        model.SideTypeSell,
+		Type:        model.OrderTypeStopLoss,
+		Price:       limit,
+		Quantity:    quantity,
+		Stop:        &limit,
+		TimeInForce: model.TimeInForceGTC,
+	}
+	message := newFIXMessage(fixMsgNewOrderSingle).
+		Set(fixTagOrdType, "3").
+		Set(fixTagStopPx, strconv.FormatFloat(limit, 'f', -1, 64)).
+		Set(fixTagTimeInForce, "1")
+	return f.submit(order, message, model.NewOrderParams(options...), fixAccepted)
+}
+
+// Cancel 发送OrderCancelRequest并等待订单被撤销，撤单被拒绝时返回FIXError。
+func (f *FIX) Cancel(order model.Order) error {
+	f.mtx.Lock()
+	local, ok := f.orders[f.ids[order.ExchangeID]]
+	if !ok {
+		f.mtx.Unlock()
+		return ErrOrderNotFound
+	}
+	request := newFIXMessage(fixMsgOrderCancelRequest).
+		Set(fixTagOrigClOrdID, local.clOrdID).
+		Set(fixTagSymbol, local.symbol).
+		Set(fixTagSide, fixSide(local.Side)).
+		Set(fixTagOrderQty, strconv.FormatFloat(local.Quantity, 'f', -1, 64))
+	if local.orderID != "" {
+		request.Set(fixTagOrderID, local.orderID)
+	}
+	f.mtx.Unlock()
+
+	clOrdID := f.nextClOrdID()
+	waiter := make(chan *fixMessage, 16)
+	f.mtx.Lock()
+	f.requests[clOrdID] = local.clOrdID
+	f.waiters[clOrdID] = waiter
+	f.mtx.Unlock()
+
+	defer func() {
+		f.mtx.Lock()
+		delete(f.waiters, clOrdID)
+		f.mtx.Unlock()
+	}()
+
+	request.Set(fixTagClOrdID, clOrdID).Set(fixTagTransactTime, time.Now().UTC().Format(fixTimeFormat))
+	if err := f.send(request); err != nil {
+		return err
+	}
+
+	timeout := time.After(f.Timeout)
+	for {
+		select {
+		case message := <-waiter:
+			if message.Type() == fixMsgOrderCancelReject {
+				return &FIXError{
+					MsgType: fixMsgOrderCancelReject,
+					Reason:  message.Get(fixTagCxlRejReason),
+					Text:    message.Get(fixTagText),
+				}
+			}
+			f.mtx.Lock()
+			status := local.ordStatus
+			f.mtx.Unlock()
+			if status == "4" || status == "C" {
+				return nil
+			}
+		case <-timeout:
+			return fmt.Errorf("fix cancel %s: %w", local.clOrdID, ErrFIXTimeout)
+		}
+	}
+}
+
+// ReplaceOrder 以先撤单再下单的方式改单，新订单有新的ClOrdID，与其他交易所的改单一致。
+func (f *FIX) ReplaceOrder(order model.Order, price, quantity float64) (model.Order, error) {
+	return cancelReplace(f, order, price, quantity)
+}
+
+// HandlesExpiry 表示设置了过期时间的订单以GTD提交，由对方处理过期，Controller不需要再按本地时间撤单。
+func (f *FIX) HandlesExpiry() bool {
+	return true
+}
+
+// Order 返回本地记录的订单状态，订单状态由对方推送的ExecutionReport更新。
+func (f *FIX) Order(_ string, id int64) (model.Order, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	order, ok := f.orders[f.ids[id]]
+	if !ok {
+		return model.Order{}, ErrOrderNotFound
+	}
+	return order.model(), nil
+}
+
+// Orders 返回交易对最近的limit个订单，按创建时间从旧到新排列。
+func (f *FIX) Orders(pair string, limit int) ([]model.Order, error) {
+	f.mtx.Lock()
+	orders := make([]model.Order, 0)
+	for _, order := range f.orders {
+		if order.Pair == pair {
+			orders = append(orders, order.model())
+		}
+	}
+	f.mtx.Unlock()
+
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].CreatedAt.Before(orders[j].CreatedAt)
+	})
+	if limit > 0 && len(orders) > limit {
+		orders = orders[len(orders)-limit:]
+	}
+	return orders, nil
+}
+
+// Account 返回本地账本的余额，挂单占用的资金计入Lock：买单占用计价资产，卖单占用基础资产。
+func (f *FIX) Account() (model.Account, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	locked := make(map[string]float64)
+	for _, order := range f.orders {
+		if !fixOpenStatus(order.Status) || order.Type == model.OrderTypeMarket {
+			continue
+		}
+		info := f.AssetsInfo(order.Pair)
+		leaves := order.Quantity - order.cumQty
+		if order.Side == model.SideTypeBuy {
+			locked[info.QuoteAsset] += leaves * order.Price
+		} else {
+			locked[info.BaseAsset] += leaves
+		}
+	}
+
+	balances := make([]model.Balance, 0, len(f.balances))
+	for asset, total := range f.balances {
+		lock := math.Min(locked[asset], math.Max(total, 0))
+		balances = append(balances, model.Balance{Asset: asset, Free: total - lock, Lock: lock})
+	}
+	sort.Slice(balances, func(i, j int) bool {
+		return balances[i].Asset < balances[j].Asset
+	})
+	return model.Account{Balances: balances}, nil
+}
+
+// Position 返回交易对的基础资产和计价资产的总余额。
+func (f *FIX) Position(pair string) (asset, quote float64, err error) {
+	info := f.AssetsInfo(pair)
+	acc, err := f.Account()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	assetBalance, quoteBalance := acc.Balance(info.BaseAsset, info.QuoteAsset)
+	return assetBalance.Free + assetBalance.Lock, quoteBalance.Free + quoteBalance.Lock, nil
+}
+
+// PositionInfo 返回交易对的持仓数量，FIX会话只提供余额，没有开仓均价和杠杆。
+func (f *FIX) PositionInfo(pair string) (model.PositionInfo, error) {
+	info := f.AssetsInfo(pair)
+	acc, err := f.Account()
+	if err != nil {
+		return model.PositionInfo{}, err
+	}
+	return accountPosition(acc, pair, info.BaseAsset), nil
+}
+
+// AssetsInfo 返回Feeder提供的交易对信息，没有Feeder时与PaperWallet一样不限制价格和数量。
+func (f *FIX) AssetsInfo(pair string) model.AssetInfo {
+	if f.Feeder != nil {
+		return f.Feeder.AssetsInfo(pair)
+	}
+
+	asset, quote := SplitAssetQuote(pair)
+	return model.AssetInfo{
+		BaseAsset:          asset,
+		QuoteAsset:         quote,
+		MaxPrice:           math.MaxFloat64,
+		MaxQuantity:        math.MaxFloat64,
+		StepSize:           0.00000001,
+		TickSize:           0.00000001,
+		QuotePrecision:     8,
+		BaseAssetPrecision: 8,
+	}
+}
+
+// LastQuote 返回Feeder提供的最新价格。
+func (f *FIX) LastQuote(ctx context.Context, pair string) (float64, error) {
+	if f.Feeder == nil {
+		return 0, fmt.Errorf("%w: fix has no market data feeder", ErrNotSupported)
+	}
+	return f.Feeder.LastQuote(ctx, pair)
+}
+
+// CandlesByPeriod 返回Feeder提供的K线。
+func (f *FIX) CandlesByPeriod(ctx context.Context, pair, period string,
+	start, end time.Time) ([]model.Candle, error) {
+	if f.Feeder == nil {
+		return nil, fmt.Errorf("%w: fix has no market data feeder", ErrNotSupported)
+	}
+	return f.Feeder.CandlesByPeriod(ctx, pair, period, start, end)
+}
+
+// CandlesByLimit 返回Feeder提供的最近limit根K线。
+func (f *FIX) CandlesByLimit(ctx context.Context, pair, period string, limit int) ([]model.Candle, error) {
+	if f.Feeder == nil {
+		return nil, fmt.Errorf("%w: fix has no market data feeder", ErrNotSupported)
+	}
+	return f.Feeder.CandlesByLimit(ctx, pair, period, limit)
+}
+
+// CandlesSubscription 订阅Feeder提供的K线，没有Feeder时发送ErrNotSupported并关闭通道。
+func (f *FIX) CandlesSubscription(ctx context.Context, pair, period string) (chan model.Candle, chan error) {
+	if f.Feeder != nil {
+		return f.Feeder.CandlesSubscription(ctx, pair, period)
+	}
+
+	ccandle := make(chan model.Candle)
+	cerr := make(chan error, 1)
+	cerr <- fmt.Errorf("%w: fix has no market data feeder", ErrNotSupported)
+	close(cerr)
+	close(ccandle)
+	return ccandle, cerr
+}
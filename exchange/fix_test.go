@@ -0,0 +1,562 @@
+package exchange
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+)
+
+var (
+	_ service.Exchange      = &FIX{}
+	_ service.OrderLister   = &FIX{}
+	_ service.OrderStreamer = &FIX{}
+)
+
+const fixTestPrice = 30000.0
+
+// fixTestOrder 测试交易场所中的订单。
+type fixTestOrder struct {
+	clOrdID string
+	orderID string
+	symbol  string
+	side    string
+	qty     float64
+	price   float64
+	cumQty  float64
+	status  string
+}
+
+// fixAcceptor 模拟FIX交易场所的测试服务器：市价单以固定价格立即成交，限价单一直挂单，数量超过100的订单被拒绝。
+type fixAcceptor struct {
+	t        *testing.T
+	listener net.Listener
+
+	mtx      sync.Mutex
+	conn     net.Conn
+	outSeq   int
+	inSeq    int
+	sent     map[int]*fixMessage
+	drop     bool // 下一条消息不写入连接，模拟消息丢失
+	logons   int
+	orders   map[string]*fixTestOrder
+	received []*fixMessage // 收到的所有业务消息和会话消息
+}
+
+func newFIXAcceptor(t *testing.T) *fixAcceptor {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	acceptor := &fixAcceptor{t: t, listener: listener, orders: make(map[string]*fixTestOrder)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go acceptor.serve(conn)
+		}
+	}()
+	return acceptor
+}
+
+func (a *fixAcceptor) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		raw, err := readFIXMessage(reader)
+		if err != nil {
+			return
+		}
+		message, err := parseFIXMessage(raw)
+		require.NoError(a.t, err)
+		require.Equal(a.t, "CLIENT", message.Get(fixTagSenderCompID))
+		require.Equal(a.t, "VENUE", message.Get(fixTagTargetCompID))
+
+		a.mtx.Lock()
+		a.received = append(a.received, message)
+		if message.Type() == fixMsgLogon {
+			if message.Get(fixTagUsername) != "user" || message.Get(fixTagPassword) != "pass" {
+				a.conn, a.outSeq, a.sent = conn, 1, make(map[int]*fixMessage)
+				a.send(newFIXMessage(fixMsgLogout).Set(fixTagText, "invalid credentials"))
+				a.mtx.Unlock()
+				return
+			}
+			require.Equal(a.t, "Y", message.Get(fixTagResetSeqNumFlag))
+			a.conn, a.outSeq, a.inSeq, a.sent = conn, 1, 1, make(map[int]*fixMessage)
+			a.logons++
+		}
+		if message.Get(fixTagPossDupFlag) != "Y" {
+			require.Equal(a.t, a.inSeq, message.Int(fixTagMsgSeqNum))
+			a.inSeq++
+		}
+		a.handle(message)
+		a.mtx.Unlock()
+	}
+}
+
+// handle 处理收到的消息，调用方需要持有锁。
+func (a *fixAcceptor) handle(message *fixMessage) {
+	switch message.Type() {
+	case fixMsgLogon:
+		a.send(newFIXMessage(fixMsgLogon).Set(fixTagEncryptMethod, "0").
+			Set(fixTagHeartBtInt, message.Get(fixTagHeartBtInt)))
+	case fixMsgTestRequest:
+		a.send(newFIXMessage(fixMsgHeartbeat).Set(fixTagTestReqID, message.Get(fixTagTestReqID)))
+	case fixMsgResendRequest:
+		begin, end := message.Int(fixTagBeginSeqNo), a.outSeq-1
+		for seq := begin; seq <= end; seq++ {
+			resend := a.sent[seq].clone()
+			resend.Set(fixTagPossDupFlag, "Y")
+			_, err := a.conn.Write(resend.Bytes())
+			require.NoError(a.t, err)
+		}
+	case fixMsgLogout:
+		a.send(newFIXMessage(fixMsgLogout))
+	case fixMsgNewOrderSingle:
+		if message.Get(fixTagPossDupFlag) == "Y" {
+			return
+		}
+		a.newOrder(message)
+	case fixMsgOrderCancelRequest:
+		order, ok := a.orders[message.Get(fixTagOrigClOrdID)]
+		if !ok || (order.status != "0" && order.status != "1") {
+			reason := "1"
+			if ok {
+				reason = "0" // Too late to cancel
+			}
+			a.send(newFIXMessage(fixMsgOrderCancelReject).
+				Set(fixTagClOrdID, message.Get(fixTagClOrdID)).
+				Set(fixTagOrigClOrdID, message.Get(fixTagOrigClOrdID)).
+				Set(fixTagOrderID, "NONE").
+				Set(fixTagOrdStatus, "8").
+				Set(fixTagCxlRejReason, reason).
+				Set(fixTagText, "cancel rejected"))
+			return
+		}
+		require.Equal(a.t, order.orderID, message.Get(fixTagOrderID))
+		order.status = "4"
+		a.report(order, "4", message.Get(fixTagClOrdID))
+	case fixMsgOrderStatusRequest:
+		if order, ok := a.orders[message.Get(fixTagClOrdID)]; ok {
+			a.report(order, "I", "")
+		}
+	}
+}
+
+// newOrder 处理NewOrderSingle，调用方需要持有锁。
+func (a *fixAcceptor) newOrder(message *fixMessage) {
+	order := &fixTestOrder{
+		clOrdID: message.Get(fixTagClOrdID),
+		orderID: "V" + strconv.Itoa(len(a.orders)+1),
+		symbol:  message.Get(fixTagSymbol),
+		side:    message.Get(fixTagSide),
+		qty:     message.Float(fixTagOrderQty),
+		price:   message.Float(fixTagPrice),
+		status:  "A",
+	}
+	if cash := message.Float(fixTagCashOrderQty); cash > 0 {
+		order.qty = cash / fixTestPrice
+	}
+	a.orders[order.clOrdID] = order
+
+	if order.qty > 100 {
+		order.status = "8"
+		a.send(a.execution(order, "8", "").Set(fixTagOrdRejReason, "3").Set(fixTagText, "order exceeds limit"))
+		return
+	}
+
+	if message.Get(fixTagOrdType) == "1" {
+		order.status = "0"
+		a.report(order, "0", "")
+		a.fill(order, order.qty, fixTestPrice)
+		return
+	}
+
+	a.report(order, "A", "")
+	order.status = "0"
+	a.report(order, "0", "")
+}
+
+// fill 成交订单的一部分，调用方需要持有锁。
+func (a *fixAcceptor) fill(order *fixTestOrder, qty, price float64) {
+	order.cumQty += qty
+	order.status = "1"
+	if order.cumQty >= order.qty {
+		order.status = "2"
+	}
+	a.send(a.execution(order, "F", "").
+		Set(fixTagLastQty, strconv.FormatFloat(qty, 'f', -1, 64)).
+		Set(fixTagLastPx, strconv.FormatFloat(price, 'f', -1, 64)))
+}
+
+// report 发送订单当前状态的回报。
+func (a *fixAcceptor) report(order *fixTestOrder, execType, clOrdID string) {
+	a.send(a.execution(order, execType, clOrdID))
+}
+
+// execution 创建ExecutionReport，clOrdID不为空时是撤单请求的回报。
+func (a *fixAcceptor) execution(order *fixTestOrder, execType, clOrdID string) *fixMessage {
+	status := order.status
+	if execType == "A" {
+		status = "A"
+	}
+	message := newFIXMessage(fixMsgExecutionReport).
+		Set(fixTagOrderID, order.orderID).
+		Set(fixTagClOrdID, order.clOrdID).
+		Set(fixTagExecID, strconv.Itoa(a.outSeq)).
+		Set(fixTagExecType, execType).
+		Set(fixTagOrdStatus, status).
+		Set(fixTagSymbol, order.symbol).
+		Set(fixTagSide, order.side).
+		Set(fixTagOrderQty, strconv.FormatFloat(order.qty, 'f', -1, 64)).
+		Set(fixTagCumQty, strconv.FormatFloat(order.cumQty, 'f', -1, 64)).
+		Set(fixTagLeavesQty, strconv.FormatFloat(order.qty-order.cumQty, 'f', -1, 64)).
+		Set(fixTagTransactTime, time.Now().UTC().Format(fixTimeFormat))
+	if order.cumQty > 0 {
+		message.Set(fixTagAvgPx, strconv.FormatFloat(fixTestPrice, 'f', -1, 64))
+	}
+	if clOrdID != "" {
+		message.Set(fixTagClOrdID, clOrdID).Set(fixTagOrigClOrdID, order.clOrdID)
+	}
+	return message
+}
+
+// send 填写头部并发送消息，调用方需要持有锁。drop为true时消息只保存不写入，模拟丢失。
+func (a *fixAcceptor) send(message *fixMessage) {
+	message.Set(fixTagSenderCompID, "VENUE").
+		Set(fixTagTargetCompID, "CLIENT").
+		Set(fixTagMsgSeqNum, strconv.Itoa(a.outSeq)).
+		Set(fixTagSendingTime, time.Now().UTC().Format(fixTimeFormat))
+	a.sent[a.outSeq] = message.clone()
+	a.outSeq++
+
+	if a.drop {
+		a.drop = false
+		return
+	}
+	_, _ = a.conn.Write(message.Bytes())
+}
+
+// do 在持有锁时执行f，测试中用于主动发送消息。
+func (a *fixAcceptor) do(f func()) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	f()
+}
+
+// messages 返回收到的指定类型的消息。
+func (a *fixAcceptor) messages(msgType string) []*fixMessage {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	var result []*fixMessage
+	for _, message := range a.received {
+		if message.Type() == msgType {
+			result = append(result, message)
+		}
+	}
+	return result
+}
+
+func newTestFIX(t *testing.T, acceptor *fixAcceptor, options ...FIXOption) *FIX {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	options = append([]FIXOption{
+		WithFIXCredentials("user", "pass"),
+		WithFIXAccount("ACC"),
+		WithFIXSymbol("BTCUSDT", "BTC/USDT"),
+		WithFIXBalance("USDT", 100000),
+		WithFIXBalance("BTC", 1),
+		WithFIXHeartbeat(time.Second),
+		WithFIXTimeout(2 * time.Second),
+	}, options...)
+	fix, err := NewFIX(ctx, acceptor.listener.Addr().String(), "CLIENT", "VENUE", options...)
+	require.NoError(t, err)
+	return fix
+}
+
+func TestFIXMessage(t *testing.T) {
+	message := newFIXMessage(fixMsgNewOrderSingle).
+		Set(fixTagClOrdID, "1").
+		Set(fixTagSymbol, "BTC/USDT").
+		Set(fixTagSymbol, "ETH/USDT")
+
+	raw := message.Bytes()
+	assert.Equal(t, "8=FIX.4.4|9=22|35=D|11=1|55=ETH/USDT|10=175|", message.String())
+
+	parsed, err := parseFIXMessage(raw)
+	require.NoError(t, err)
+	assert.Equal(t, fixMsgNewOrderSingle, parsed.Type())
+	assert.Equal(t, "ETH/USDT", parsed.Get(fixTagSymbol))
+	assert.Equal(t, 1, parsed.Int(fixTagClOrdID))
+	assert.False(t, parsed.Has(fixTagPrice))
+
+	t.Run("read from stream", func(t *testing.T) {
+		reader := bufio.NewReader(bytes.NewReader(append(append([]byte{}, raw...), raw...)))
+		for i := 0; i < 2; i++ {
+			read, err := readFIXMessage(reader)
+			require.NoError(t, err)
+			assert.Equal(t, raw, read)
+		}
+	})
+
+	t.Run("invalid checksum", func(t *testing.T) {
+		invalid := append([]byte{}, raw...)
+		copy(invalid[len(invalid)-4:], "000")
+		_, err := parseFIXMessage(invalid)
+		require.Error(t, err)
+	})
+
+	t.Run("invalid body length", func(t *testing.T) {
+		_, err := parseFIXMessage([]byte("8=FIX.4.4\x019=5\x0135=0\x0110=000\x01"))
+		require.Error(t, err)
+	})
+}
+
+func TestFIX_Orders(t *testing.T) {
+	acceptor := newFIXAcceptor(t)
+	fix := newTestFIX(t, acceptor)
+
+	t.Run("limit", func(t *testing.T) {
+		order, err := fix.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 29000)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeNew, order.Status)
+		assert.Equal(t, model.OrderTypeLimit, order.Type)
+		assert.Equal(t, model.TimeInForceGTC, order.TimeInForce)
+
+		request := acceptor.messages(fixMsgNewOrderSingle)[0]
+		assert.Equal(t, "BTC/USDT", request.Get(fixTagSymbol))
+		assert.Equal(t, "ACC", request.Get(fixTagAccount))
+		assert.Equal(t, "29000", request.Get(fixTagPrice))
+		assert.Equal(t, "1", request.Get(fixTagTimeInForce))
+		assert.Equal(t, order.ExchangeID, int64(request.Int(fixTagClOrdID)))
+
+		account, err := fix.Account()
+		require.NoError(t, err)
+		_, quote := account.Balance("BTC", "USDT")
+		assert.Equal(t, 71000.0, quote.Free)
+		assert.Equal(t, 29000.0, quote.Lock)
+
+		require.NoError(t, fix.Cancel(order))
+		order, err = fix.Order("BTCUSDT", order.ExchangeID)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeCanceled, order.Status)
+
+		// 已经撤销的订单再次撤单被拒绝
+		err = fix.Cancel(order)
+		var fixErr *FIXError
+		require.ErrorAs(t, err, &fixErr)
+		assert.Equal(t, "0", fixErr.Reason)
+
+		_, err = fix.Order("BTCUSDT", 42)
+		require.ErrorIs(t, err, ErrOrderNotFound)
+		require.ErrorIs(t, fix.Cancel(model.Order{ExchangeID: 42}), ErrOrderNotFound)
+	})
+
+	t.Run("post only and expire", func(t *testing.T) {
+		expire := time.Now().Add(time.Hour)
+		order, err := fix.CreateOrderLimit(model.SideTypeSell, "BTCUSDT", 0.5, 31000,
+			model.WithPostOnly(), model.WithExpireAt(expire))
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderTypeLimitMaker, order.Type)
+		assert.Equal(t, model.TimeInForceGTX, order.TimeInForce)
+		assert.True(t, fix.HandlesExpiry())
+
+		requests := acceptor.messages(fixMsgNewOrderSingle)
+		request := requests[len(requests)-1]
+		assert.Equal(t, "6", request.Get(fixTagExecInst))
+		assert.Equal(t, "6", request.Get(fixTagTimeInForce))
+		assert.Equal(t, expire.UTC().Format(fixTimeFormat), request.Get(fixTagExpireTime))
+
+		replaced, err := fix.ReplaceOrder(order, 32000, 0.4)
+		require.NoError(t, err)
+		assert.Equal(t, order.ExchangeID, *replaced.ReplacedID)
+		assert.Equal(t, 32000.0, replaced.Price)
+		require.NoError(t, fix.Cancel(replaced))
+	})
+
+	t.Run("market", func(t *testing.T) {
+		order, err := fix.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 0.5)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeFilled, order.Status)
+		assert.Equal(t, fixTestPrice, order.Price)
+		assert.Equal(t, 0.5, order.Quantity)
+
+		order, err = fix.CreateOrderMarketQuote(model.SideTypeSell, "BTCUSDT", 3000)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeFilled, order.Status)
+		assert.InDelta(t, 0.1, order.Quantity, 1e-9)
+
+		asset, quote, err := fix.Position("BTCUSDT")
+		require.NoError(t, err)
+		assert.InDelta(t, 1.4, asset, 1e-9)
+		assert.InDelta(t, 100000-15000+3000, quote, 1e-6)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		_, err := fix.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1000, 29000)
+		require.ErrorIs(t, err, ErrInsufficientFunds)
+		var fixErr *FIXError
+		require.ErrorAs(t, err, &fixErr)
+		assert.Equal(t, "order exceeds limit", fixErr.Text)
+
+		_, err = fix.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 0)
+		require.ErrorIs(t, err, ErrInvalidQuantity)
+	})
+
+	t.Run("client order id", func(t *testing.T) {
+		order, err := fix.CreateOrderStop("BTCUSDT", 0.2, 25000, model.WithClientOrderID("stop-1"))
+		require.NoError(t, err)
+		assert.Equal(t, stringExchangeID("stop-1"), order.ExchangeID)
+		assert.Equal(t, model.OrderTypeStopLoss, order.Type)
+		assert.Equal(t, 25000.0, *order.Stop)
+
+		requests := len(acceptor.messages(fixMsgNewOrderSingle))
+		again, err := fix.CreateOrderStop("BTCUSDT", 0.2, 25000, model.WithClientOrderID("stop-1"))
+		require.NoError(t, err)
+		assert.Equal(t, order.ExchangeID, again.ExchangeID)
+		assert.Len(t, acceptor.messages(fixMsgNewOrderSingle), requests)
+
+		request := acceptor.messages(fixMsgNewOrderSingle)[requests-1]
+		assert.Equal(t, "3", request.Get(fixTagOrdType))
+		assert.Equal(t, "25000", request.Get(fixTagStopPx))
+	})
+
+	t.Run("not supported", func(t *testing.T) {
+		_, err := fix.CreateOrderOCO(model.SideTypeSell, "BTCUSDT", 1, 31000, 29000, 28900)
+		require.ErrorIs(t, err, ErrNotSupported)
+
+		_, err = fix.LastQuote(context.Background(), "BTCUSDT")
+		require.ErrorIs(t, err, ErrNotSupported)
+		_, errs := fix.CandlesSubscription(context.Background(), "BTCUSDT", "1m")
+		require.ErrorIs(t, <-errs, ErrNotSupported)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		orders, err := fix.Orders("BTCUSDT", 2)
+		require.NoError(t, err)
+		require.Len(t, orders, 2)
+		assert.Equal(t, model.OrderStatusTypeRejected, orders[0].Status)
+		assert.Equal(t, model.OrderTypeStopLoss, orders[1].Type)
+	})
+}
+
+func TestFIX_Session(t *testing.T) {
+	acceptor := newFIXAcceptor(t)
+	fix := newTestFIX(t, acceptor)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates, errs := fix.OrderSubscription(ctx)
+
+	order, err := fix.CreateOrderLimit(model.SideTypeSell, "BTCUSDT", 1, 31000)
+	require.NoError(t, err)
+	assert.Equal(t, model.OrderStatusTypeNew, (<-updates).Status) // Pending New
+	assert.Equal(t, model.OrderStatusTypeNew, (<-updates).Status)
+
+	t.Run("resend lost message", func(t *testing.T) {
+		// 第一次成交回报丢失，第二次成交回报的序号不连续，客户端请求重发后按顺序收到两次成交
+		acceptor.do(func() {
+			local := acceptor.orders[strconv.FormatInt(order.ExchangeID, 10)]
+			acceptor.drop = true
+			acceptor.fill(local, 0.25, fixTestPrice)
+			acceptor.fill(local, 0.25, fixTestPrice)
+		})
+
+		update := <-updates
+		assert.Equal(t, model.OrderStatusTypePartiallyFilled, update.Status)
+		assert.Equal(t, 0.25, update.Quantity)
+		update = <-updates
+		assert.Equal(t, 0.5, update.Quantity)
+
+		require.Len(t, acceptor.messages(fixMsgResendRequest), 1)
+		account, err := fix.Account()
+		require.NoError(t, err)
+		base, quote := account.Balance("BTC", "USDT")
+		assert.Equal(t, 0.5, base.Free+base.Lock)
+		assert.Equal(t, 0.5, base.Lock)
+		assert.Equal(t, 115000.0, quote.Free)
+	})
+
+	t.Run("answer resend request and test request", func(t *testing.T) {
+		acceptor.do(func() {
+			acceptor.send(newFIXMessage(fixMsgResendRequest).
+				Set(fixTagBeginSeqNo, "1").
+				Set(fixTagEndSeqNo, "0"))
+			acceptor.send(newFIXMessage(fixMsgTestRequest).Set(fixTagTestReqID, "ping-1"))
+		})
+
+		require.Eventually(t, func() bool {
+			for _, heartbeat := range acceptor.messages(fixMsgHeartbeat) {
+				if heartbeat.Get(fixTagTestReqID) == "ping-1" {
+					return true
+				}
+			}
+			return false
+		}, time.Second, 10*time.Millisecond)
+
+		// Logon和ResendRequest用GapFill跳过，下单消息原样重发
+		gapFill := acceptor.messages(fixMsgSequenceReset)
+		require.NotEmpty(t, gapFill)
+		assert.Equal(t, "1", gapFill[0].Get(fixTagMsgSeqNum))
+		assert.Equal(t, "Y", gapFill[0].Get(fixTagGapFillFlag))
+		assert.Equal(t, "2", gapFill[0].Get(fixTagNewSeqNo))
+
+		requests := acceptor.messages(fixMsgNewOrderSingle)
+		require.Len(t, requests, 2)
+		assert.Equal(t, "Y", requests[1].Get(fixTagPossDupFlag))
+		assert.Equal(t, requests[0].Get(fixTagSendingTime), requests[1].Get(fixTagOrigSendingTime))
+		assert.Equal(t, requests[0].Get(fixTagMsgSeqNum), requests[1].Get(fixTagMsgSeqNum))
+	})
+
+	t.Run("heartbeat", func(t *testing.T) {
+		count := len(acceptor.messages(fixMsgHeartbeat))
+		require.Eventually(t, func() bool {
+			return len(acceptor.messages(fixMsgHeartbeat)) > count
+		}, 3*time.Second, 50*time.Millisecond)
+	})
+
+	t.Run("reconnect", func(t *testing.T) {
+		acceptor.do(func() {
+			_ = acceptor.conn.Close()
+		})
+		require.Error(t, <-errs)
+
+		// 重连后查询挂单的状态
+		require.Eventually(t, func() bool {
+			return len(acceptor.messages(fixMsgOrderStatusRequest)) == 1
+		}, 3*time.Second, 10*time.Millisecond)
+		update := <-updates
+		assert.Equal(t, order.ExchangeID, update.ExchangeID)
+		assert.Equal(t, model.OrderStatusTypePartiallyFilled, update.Status)
+
+		require.NoError(t, fix.Cancel(order))
+		order, err = fix.Order("BTCUSDT", order.ExchangeID)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeCanceled, order.Status)
+		acceptor.do(func() {
+			assert.Equal(t, 2, acceptor.logons)
+		})
+	})
+}
+
+func TestFIX_Logon(t *testing.T) {
+	acceptor := newFIXAcceptor(t)
+	_, err := NewFIX(context.Background(), acceptor.listener.Addr().String(), "CLIENT", "VENUE",
+		WithFIXCredentials("user", "invalid"), WithFIXTimeout(time.Second))
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, ErrFIXTimeout))
+}
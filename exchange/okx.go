@@ -0,0 +1,1250 @@
+package exchange
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/jpillora/backoff"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/tools/log"
+)
+
+/*
+OKX 通过OKX的V5接口实现service.Exchange，同一个类型支持现货（SPOT）和U本位永续合约（SWAP），通过WithOKXInstType选择。
+私有接口的签名为 Base64(HMAC-SHA256(secret, 时间戳 + 请求方法 + 请求路径（包含查询字符串） + 请求体))，同时需要API的Passphrase。
+
+交易对的对应关系来自交易所的产品信息：ninjabot的BTCUSDT对应现货的BTC-USDT和永续合约的BTC-USDT-SWAP。
+永续合约按张下单，每张合约的面值是ctVal个基础资产，AssetsInfo、下单数量和持仓都换算为基础资产的数量，与币安期货保持一致。
+
+OCO订单和止损单通过OKX的策略委托（algo order）实现，策略委托有自己的algoId：
+OCO订单的两条腿共用一个algoId，止盈腿的ExchangeID由algoId转换得到，止损腿的ExchangeID由algoId加上":sl"后缀转换得到，
+两条腿的GroupID相同。ExchangeID到订单ID或者策略委托ID的对应关系记录在内存中，找不到时通过列出交易对最近的订单重新建立。
+*/
+
+// OKXInstType OKX的产品类型。
+type OKXInstType string
+
+// OKX支持的产品类型。
+const (
+	OKXInstTypeSpot OKXInstType = "SPOT" // 现货
+	OKXInstTypeSwap OKXInstType = "SWAP" // 永续合约
+)
+
+const (
+	okxAPIURL    = "https://www.okx.com"
+	okxWsURL     = "wss://ws.okx.com:8443/ws/v5/business"
+	okxDemoWsURL = "wss://wspap.okx.com:8443/ws/v5/business"
+
+	okxPingInterval = 25 * time.Second // 30秒没有消息时OKX会断开连接
+	okxCandleLimit  = 300              // K线接口单次最多返回的数量
+	okxHistoryLimit = 100              // 历史K线接口单次最多返回的数量
+	okxOrderLimit   = 100              // 订单接口单次最多返回的数量
+)
+
+// okxErrors 将OKX的错误码映射为通用错误，调用方可以用errors.Is判断。
+var okxErrors = map[string]error{
+	"51603": ErrOrderNotFound,     // 订单不存在
+	"51400": ErrOrderNotFound,     // 撤单失败，订单已经成交、已经撤销或者不存在
+	"51008": ErrInsufficientFunds, // 余额不足
+}
+
+// OKXError OKX接口返回的错误，下单等接口的错误码是返回数据中的sCode。
+type OKXError struct {
+	Code    string
+	Message string
+}
+
+// Error 实现error接口。
+func (e *OKXError) Error() string {
+	return fmt.Sprintf("okx error %s: %s", e.Code, e.Message)
+}
+
+// Unwrap 返回错误码对应的通用错误，没有对应的通用错误时返回nil。
+func (e *OKXError) Unwrap() error {
+	return okxErrors[e.Code]
+}
+
+// okxInstrument 交易对对应的OKX产品。
+type okxInstrument struct {
+	ID            string  // 产品ID，例如BTC-USDT-SWAP
+	ContractValue float64 // 每张合约的面值，现货为1
+	SizePrecision int     // 下单数量（张数）的小数位数
+}
+
+// okxRef ExchangeID对应的OKX订单，普通订单只有OrdID，策略委托有AlgoID，Leg区分OCO订单的止盈腿（tp）和止损腿（sl）。
+type okxRef struct {
+	OrdID  string
+	AlgoID string
+	Leg    string
+}
+
+// OKX 结构体封装了与OKX交易所交互所需的配置和数据。
+type OKX struct {
+	ctx         context.Context            // ctx 用于控制所有请求的取消和超时。
+	client      *http.Client               // client 执行REST请求的HTTP客户端。
+	assetsInfo  map[string]model.AssetInfo // assetsInfo 存储每个交易对的交易限制，数量以基础资产计。
+	instruments map[string]okxInstrument   // instruments 交易对到OKX产品的对应关系。
+	pairs       map[string]string          // pairs OKX产品ID到交易对的对应关系。
+	timeOffset  time.Duration              // timeOffset 服务器时间与本地时间的差，用于签名的时间戳。
+
+	mtx  sync.Mutex       // mtx 保护refs
+	refs map[int64]okxRef // refs ExchangeID到OKX订单的对应关系。
+
+	InstType   OKXInstType // InstType 产品类型，默认为现货。
+	HeikinAshi bool        // HeikinAshi 是否将普通K线转换为Heikin Ashi K线。
+	Testnet    bool        // Testnet 是否使用OKX的模拟盘。
+
+	APIKey     string // APIKey 用户的OKX API Key。
+	APISecret  string // APISecret 用户的OKX API Secret，用于签名。
+	Passphrase string // Passphrase 创建API Key时设置的密码。
+	APIURL     string // APIURL 自定义的REST接口地址，为空时使用OKX的地址。
+	WsURL      string // WsURL 自定义的K线WebSocket地址，为空时使用OKX的地址。
+
+	MetadataFetchers []MetadataFetchers // MetadataFetchers 在收到完整的K线后为K线添加额外的元数据。
+	PairOptions      []PairOption       // PairOptions 永续合约交易对的杠杆倍数和保证金模式。
+}
+
+// OKXOption 定义了一个函数类型，用于通过不同的配置选项来定制化OKX实例。
+type OKXOption func(*OKX)
+
+// WithOKXCredentials 设置OKX的API Key、Secret和Passphrase。
+func WithOKXCredentials(key, secret, passphrase string) OKXOption {
+	return func(o *OKX) {
+		o.APIKey = key
+		o.APISecret = secret
+		o.Passphrase = passphrase
+	}
+}
+
+// WithOKXTestnet 使用OKX的模拟盘。
+func WithOKXTestnet() OKXOption {
+	return func(o *OKX) {
+		o.Testnet = true
+	}
+}
+
+// WithOKXHeikinAshiCandle 启用Heikin Ashi蜡烛图转换。
+func WithOKXHeikinAshiCandle() OKXOption {
+	return func(o *OKX) {
+		o.HeikinAshi = true
+	}
+}
+
+// WithOKXMetadataFetcher 在收到完整的K线后运行元数据提取器，为K线添加额外信息。
+func WithOKXMetadataFetcher(fetcher MetadataFetchers) OKXOption {
+	return func(o *OKX) {
+		o.MetadataFetchers = append(o.MetadataFetchers, fetcher)
+	}
+}
+
+// WithOKXInstType 选择产品类型，现货或者永续合约。
+func WithOKXInstType(instType OKXInstType) OKXOption {
+	return func(o *OKX) {
+		o.InstType = instType
+	}
+}
+
+// WithOKXLeverage 设置永续合约交易对的杠杆倍数和保证金模式，只能用于永续合约。
+func WithOKXLeverage(pair string, leverage int, marginType MarginType) OKXOption {
+	return func(o *OKX) {
+		o.PairOptions = append(o.PairOptions, PairOption{
+			Pair:       strings.ToUpper(pair),
+			Leverage:   leverage,
+			MarginType: marginType,
+		})
+	}
+}
+
+// WithOKXEndpoint 使用自定义的REST接口和WebSocket地址，例如本地的模拟接口，为空的地址仍然使用OKX的地址。
+func WithOKXEndpoint(apiURL, wsURL string) OKXOption {
+	return func(o *OKX) {
+		o.APIURL = apiURL
+		o.WsURL = wsURL
+	}
+}
+
+// NewOKX 创建一个新的OKX实例，检查与服务器的连接，加载产品信息并设置合约杠杆。
+func NewOKX(ctx context.Context, options ...OKXOption) (*OKX, error) {
+	exchange := &OKX{
+		ctx:      ctx,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		refs:     make(map[int64]okxRef),
+		InstType: OKXInstTypeSpot,
+		APIURL:   okxAPIURL,
+	}
+
+	for _, option := range options {
+		option(exchange)
+	}
+
+	if exchange.InstType != OKXInstTypeSpot && exchange.InstType != OKXInstTypeSwap {
+		return nil, fmt.Errorf("%w: okx instrument type %s", ErrNotSupported, exchange.InstType)
+	}
+	if len(exchange.PairOptions) > 0 && exchange.InstType != OKXInstTypeSwap {
+		return nil, fmt.Errorf("%w: okx leverage is only available for swap", ErrNotSupported)
+	}
+
+	if exchange.WsURL == "" {
+		exchange.WsURL = okxWsURL
+		if exchange.Testnet {
+			exchange.WsURL = okxDemoWsURL
+		}
+	}
+
+	// 查询服务器时间检查连接，同时记录本地时间的偏差，避免签名的时间戳过期
+	var serverTime []struct {
+		Ts string `json:"ts"`
+	}
+	if err := exchange.request(ctx, http.MethodGet, "/api/v5/public/time", nil, nil, &serverTime); err != nil {
+		return nil, fmt.Errorf("okx ping fail: %w", err)
+	}
+	if len(serverTime) > 0 {
+		if ts, err := strconv.ParseInt(serverTime[0].Ts, 10, 64); err == nil {
+			exchange.timeOffset = time.Until(time.Unix(0, ts*int64(time.Millisecond)))
+		}
+	}
+
+	if err := exchange.loadInstruments(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, option := range exchange.PairOptions {
+		instrument, err := exchange.instrument(option.Pair)
+		if err != nil {
+			return nil, err
+		}
+		err = exchange.request(ctx, http.MethodPost, "/api/v5/account/set-leverage", nil, map[string]interface{}{
+			"instId":  instrument.ID,
+			"lever":   strconv.Itoa(option.Leverage),
+			"mgnMode": okxMarginMode(option.MarginType),
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log.Infof("[SETUP] Using OKX exchange (%s)", exchange.InstType)
+	return exchange, nil
+}
+
+// okxMarginMode 将保证金模式转换为OKX的格式，默认为全仓。
+func okxMarginMode(marginType MarginType) string {
+	if marginType == MarginTypeIsolated {
+		return "isolated"
+	}
+	return "cross"
+}
+
+// loadInstruments 加载产品信息，建立交易对和产品ID的对应关系，永续合约只加载U本位（linear）合约。
+func (o *OKX) loadInstruments(ctx context.Context) error {
+	var result []struct {
+		InstID    string `json:"instId"`
+		BaseCcy   string `json:"baseCcy"`
+		QuoteCcy  string `json:"quoteCcy"`
+		SettleCcy string `json:"settleCcy"`
+		CtType    string `json:"ctType"`
+		CtVal     string `json:"ctVal"`
+		CtValCcy  string `json:"ctValCcy"`
+		LotSz     string `json:"lotSz"`
+		MinSz     string `json:"minSz"`
+		MaxLmtSz  string `json:"maxLmtSz"`
+		TickSz    string `json:"tickSz"`
+		State     string `json:"state"`
+	}
+	err := o.request(ctx, http.MethodGet, "/api/v5/public/instruments",
+		map[string]interface{}{"instType": string(o.InstType)}, nil, &result)
+	if err != nil {
+		return err
+	}
+
+	o.assetsInfo = make(map[string]model.AssetInfo)
+	o.instruments = make(map[string]okxInstrument)
+	o.pairs = make(map[string]string)
+	for _, item := range result {
+		base, quote := item.BaseCcy, item.QuoteCcy
+		contractValue, precision := 1.0, 0
+		if o.InstType == OKXInstTypeSwap {
+			if item.CtType != "linear" {
+				continue
+			}
+			// 永续合约没有baseCcy和quoteCcy，标的资产是面值的币种，计价资产是结算币种
+			base, quote = item.CtValCcy, item.SettleCcy
+			contractValue, _ = strconv.ParseFloat(item.CtVal, 64)
+			precision = stepPrecision(item.CtVal)
+		}
+
+		lot, _ := strconv.ParseFloat(item.LotSz, 64)
+		minSize, _ := strconv.ParseFloat(item.MinSz, 64)
+		maxSize, _ := strconv.ParseFloat(item.MaxLmtSz, 64)
+		tick, _ := strconv.ParseFloat(item.TickSz, 64)
+
+		// 合约的数量限制是张数，乘以面值换算为基础资产的数量
+		basePrecision := stepPrecision(item.LotSz) + precision
+		info := model.AssetInfo{
+			BaseAsset:          base,
+			QuoteAsset:         quote,
+			BaseAssetPrecision: basePrecision,
+			QuotePrecision:     stepPrecision(item.TickSz),
+			StepSize:           roundFloat(lot*contractValue, basePrecision),
+			MinQuantity:        roundFloat(minSize*contractValue, basePrecision),
+			MaxQuantity:        roundFloat(maxSize*contractValue, basePrecision),
+			TickSize:           tick,
+			MinPrice:           tick,
+			MaxPrice:           math.MaxFloat64,
+		}
+
+		pair := base + quote
+		o.assetsInfo[pair] = info
+		o.instruments[pair] = okxInstrument{
+			ID:            item.InstID,
+			ContractValue: contractValue,
+			SizePrecision: stepPrecision(item.LotSz),
+		}
+		o.pairs[item.InstID] = pair
+	}
+	return nil
+}
+
+// roundFloat 保留precision位小数，消除换算时的浮点误差。
+func roundFloat(value float64, precision int) float64 {
+	scale := math.Pow10(precision)
+	return math.Round(value*scale) / scale
+}
+
+// InstrumentID 返回交易对对应的OKX产品ID，例如现货的BTCUSDT对应BTC-USDT。
+func (o *OKX) InstrumentID(pair string) (string, error) {
+	instrument, err := o.instrument(pair)
+	return instrument.ID, err
+}
+
+// Pair 返回OKX产品ID对应的交易对，例如BTC-USDT-SWAP对应BTCUSDT，未知的产品返回空字符串。
+func (o *OKX) Pair(instID string) string {
+	return o.pairs[instID]
+}
+
+func (o *OKX) instrument(pair string) (okxInstrument, error) {
+	instrument, ok := o.instruments[pair]
+	if !ok {
+		return okxInstrument{}, ErrInvalidAsset
+	}
+	return instrument, nil
+}
+
+// okxResponse OKX接口统一的返回格式。
+type okxResponse struct {
+	Code string          `json:"code"`
+	Msg  string          `json:"msg"`
+	Data json.RawMessage `json:"data"`
+}
+
+// request 调用OKX接口并将data解析到result中。query是查询字符串参数，body不为空时作为JSON请求体。
+// 设置了API Key时对请求签名，使用模拟盘时添加x-simulated-trading请求头，code不为0时返回OKXError。
+func (o *OKX) request(ctx context.Context, method, path string, query map[string]interface{},
+	body interface{}, result interface{}) error {
+	requestPath := path
+	if len(query) > 0 {
+		values := url.Values{}
+		for key, value := range query {
+			values.Set(key, fmt.Sprint(value))
+		}
+		requestPath += "?" + values.Encode()
+	}
+
+	var payload string
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = string(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, o.APIURL+requestPath, strings.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.Testnet {
+		req.Header.Set("x-simulated-trading", "1")
+	}
+
+	if o.APIKey != "" {
+		timestamp := time.Now().Add(o.timeOffset).UTC().Format("2006-01-02T15:04:05.000Z")
+		req.Header.Set("OK-ACCESS-KEY", o.APIKey)
+		req.Header.Set("OK-ACCESS-TIMESTAMP", timestamp)
+		req.Header.Set("OK-ACCESS-PASSPHRASE", o.Passphrase)
+		req.Header.Set("OK-ACCESS-SIGN", okxSign(o.APISecret, timestamp+method+requestPath+payload))
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var response okxResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("okx %s %s: status %d: %w", method, path, resp.StatusCode, err)
+	}
+
+	// 下单、撤单等接口失败时具体的错误码在返回数据的sCode中
+	var items []struct {
+		SCode string `json:"sCode"`
+		SMsg  string `json:"sMsg"`
+	}
+	_ = json.Unmarshal(response.Data, &items)
+	for _, item := range items {
+		if item.SCode != "" && item.SCode != "0" {
+			return &OKXError{Code: item.SCode, Message: item.SMsg}
+		}
+	}
+	if response.Code != "0" {
+		return &OKXError{Code: response.Code, Message: response.Msg}
+	}
+
+	if result == nil || len(response.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(response.Data, result)
+}
+
+// okxSign 使用HMAC-SHA256计算签名，返回Base64字符串。
+func okxSign(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// LastQuote 获取指定交易对最近一根完整的1分钟K线的收盘价。
+func (o *OKX) LastQuote(ctx context.Context, pair string) (float64, error) {
+	candles, err := o.CandlesByLimit(ctx, pair, "1m", 1)
+	if err != nil || len(candles) < 1 {
+		return 0, err
+	}
+	return candles[0].Close, nil
+}
+
+// AssetsInfo 根据交易对拿到资产信息。
+func (o *OKX) AssetsInfo(pair string) model.AssetInfo {
+	return o.assetsInfo[pair]
+}
+
+// validate 校验交易对是否存在以及数量是否在交易所的限制之内。
+func (o *OKX) validate(pair string, quantity float64) error {
+	info, ok := o.assetsInfo[pair]
+	if !ok {
+		return ErrInvalidAsset
+	}
+
+	if quantity > info.MaxQuantity || quantity < info.MinQuantity {
+		return &OrderError{
+			Err:      fmt.Errorf("%w: min: %f max: %f", ErrInvalidQuantity, info.MinQuantity, info.MaxQuantity),
+			Pair:     pair,
+			Quantity: quantity,
+		}
+	}
+	return nil
+}
+
+// formatPrice 按交易对的价格最小变动单位格式化价格。
+func (o *OKX) formatPrice(pair string, value float64) string {
+	if info, ok := o.assetsInfo[pair]; ok {
+		value = common.AmountToLotSize(info.TickSize, info.QuotePrecision, value)
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// formatSize 将基础资产的数量格式化为下单数量，永续合约换算为张数。
+func (o *OKX) formatSize(pair string, value float64) string {
+	if info, ok := o.assetsInfo[pair]; ok {
+		value = common.AmountToLotSize(info.StepSize, info.BaseAssetPrecision, value)
+	}
+	if instrument, ok := o.instruments[pair]; ok && instrument.ContractValue != 1 {
+		value = roundFloat(value/instrument.ContractValue, instrument.SizePrecision)
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// quantity 将OKX返回的数量（永续合约为张数）换算为基础资产的数量。
+func (o *OKX) quantity(pair, size string) float64 {
+	value, _ := strconv.ParseFloat(size, 64)
+	if instrument, ok := o.instruments[pair]; ok && instrument.ContractValue != 1 {
+		info := o.assetsInfo[pair]
+		value = roundFloat(value*instrument.ContractValue, info.BaseAssetPrecision)
+	}
+	return value
+}
+
+// tradeMode 返回下单的交易模式，现货为cash，永续合约使用交易对配置的保证金模式。
+func (o *OKX) tradeMode(pair string) string {
+	if o.InstType == OKXInstTypeSpot {
+		return "cash"
+	}
+	for _, option := range o.PairOptions {
+		if option.Pair == pair {
+			return okxMarginMode(option.MarginType)
+		}
+	}
+	return okxMarginMode(MarginTypeCrossed)
+}
+
+// orderBody 返回下单请求的公共参数。
+func (o *OKX) orderBody(side model.SideType, pair, orderType string, params model.OrderParams) (map[string]interface{}, error) {
+	instrument, err := o.instrument(pair)
+	if err != nil {
+		return nil, err
+	}
+
+	body := map[string]interface{}{
+		"instId":  instrument.ID,
+		"tdMode":  o.tradeMode(pair),
+		"side":    strings.ToLower(string(side)),
+		"ordType": orderType,
+	}
+	if params.ClientOrderID != "" {
+		body["clOrdId"] = params.ClientOrderID
+	}
+	return body, nil
+}
+
+// createOrder 提交普通订单，返回ExchangeID。
+func (o *OKX) createOrder(body map[string]interface{}) (int64, error) {
+	var result []struct {
+		OrdID string `json:"ordId"`
+	}
+	if err := o.request(o.ctx, http.MethodPost, "/api/v5/trade/order", nil, body, &result); err != nil {
+		return 0, err
+	}
+	if len(result) == 0 {
+		return 0, fmt.Errorf("okx: empty order response")
+	}
+	return o.register(result[0].OrdID, okxRef{OrdID: result[0].OrdID}), nil
+}
+
+// okxOrderType 根据有效方式选择OKX的限价单类型，只做挂单对应post_only。
+func okxOrderType(params model.OrderParams) string {
+	switch params.TimeInForce {
+	case model.TimeInForceGTX:
+		return "post_only"
+	case model.TimeInForceIOC:
+		return "ioc"
+	case model.TimeInForceFOK:
+		return "fok"
+	default:
+		return "limit"
+	}
+}
+
+// CreateOrderLimit 创建限价订单，IOC、FOK和只做挂单通过OKX的订单类型实现。
+func (o *OKX) CreateOrderLimit(side model.SideType, pair string,
+	quantity float64, limit float64, options ...model.OrderOption) (model.Order, error) {
+	if err := o.validate(pair, quantity); err != nil {
+		return model.Order{}, err
+	}
+
+	params := model.NewOrderParams(options...)
+	body, err := o.orderBody(side, pair, okxOrderType(params), params)
+	if err != nil {
+		return model.Order{}, err
+	}
+	body["sz"] = o.formatSize(pair, quantity)
+	body["px"] = o.formatPrice(pair, limit)
+
+	id, err := o.createOrder(body)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	orderType := model.OrderTypeLimit
+	if params.TimeInForce == model.TimeInForceGTX {
+		orderType = model.OrderTypeLimitMaker
+	}
+
+	// 下单接口只返回订单ID，价格和数量使用提交给交易所的值
+	now := time.Now()
+	result := model.Order{
+		ExchangeID: id,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		Pair:       pair,
+		Side:       side,
+		Type:       orderType,
+		Status:     model.OrderStatusTypeNew,
+		Price:      limit,
+		Quantity:   quantity,
+	}
+	// OKX不支持按时间过期，过期时间记录在订单上，由Controller到期后撤单
+	params.Apply(&result)
+	if result.TimeInForce == "" {
+		result.TimeInForce = model.TimeInForceGTC
+	}
+	return result, nil
+}
+
+// CreateOrderMarket 创建市价订单，quantity是基础资产的数量。
+// 下单接口不返回成交信息，下单后查询订单得到成交均价和成交数量。
+func (o *OKX) CreateOrderMarket(side model.SideType, pair string, quantity float64,
+	options ...model.OrderOption) (model.Order, error) {
+	if err := o.validate(pair, quantity); err != nil {
+		return model.Order{}, err
+	}
+
+	body, err := o.orderBody(side, pair, "market", model.NewOrderParams(options...))
+	if err != nil {
+		return model.Order{}, err
+	}
+	body["sz"] = o.formatSize(pair, quantity)
+	if o.InstType == OKXInstTypeSpot {
+		// 现货市价买单默认按计价资产的金额下单，这里指定按基础资产的数量下单
+		body["tgtCcy"] = "base_ccy"
+	}
+
+	id, err := o.createOrder(body)
+	if err != nil {
+		return model.Order{}, err
+	}
+	return o.Order(pair, id)
+}
+
+// CreateOrderMarketQuote 按计价资产的金额创建市价订单，例如花费100 USDT买入BTC，只支持现货。
+func (o *OKX) CreateOrderMarketQuote(side model.SideType, pair string, quantity float64,
+	options ...model.OrderOption) (model.Order, error) {
+	if o.InstType != OKXInstTypeSpot {
+		return model.Order{}, fmt.Errorf("%w: okx %s market quote order", ErrNotSupported, o.InstType)
+	}
+
+	body, err := o.orderBody(side, pair, "market", model.NewOrderParams(options...))
+	if err != nil {
+		return model.Order{}, err
+	}
+	body["sz"] = o.formatPrice(pair, quantity) // 金额按计价资产的精度格式化
+	body["tgtCcy"] = "quote_ccy"
+
+	id, err := o.createOrder(body)
+	if err != nil {
+		return model.Order{}, err
+	}
+	return o.Order(pair, id)
+}
+
+// ReplaceOrder 修改挂单的价格和数量。OKX的改单接口（amend-order）还没有接入，这里通过撤单后重新下单实现，
+// 撤单成功到新订单创建之间两个订单都不在交易所挂单。
+func (o *OKX) ReplaceOrder(order model.Order, price, quantity float64) (model.Order, error) {
+	// 撤单前先校验新数量，避免原订单被撤掉后新订单因为数量不合法下单失败
+	if err := o.validate(order.Pair, quantity); err != nil {
+		return model.Order{}, err
+	}
+	return cancelReplace(o, order, price, quantity)
+}
+
+// Cancel 撤销订单，策略委托（OCO订单和止损单）撤销整个策略委托。
+func (o *OKX) Cancel(order model.Order) error {
+	instrument, err := o.instrument(order.Pair)
+	if err != nil {
+		return err
+	}
+
+	ref, err := o.ref(order.Pair, order.ExchangeID)
+	if err != nil {
+		return err
+	}
+
+	if ref.AlgoID != "" {
+		return o.request(o.ctx, http.MethodPost, "/api/v5/trade/cancel-algos", nil, []map[string]string{
+			{"algoId": ref.AlgoID, "instId": instrument.ID},
+		}, nil)
+	}
+	return o.request(o.ctx, http.MethodPost, "/api/v5/trade/cancel-order", nil, map[string]string{
+		"instId": instrument.ID,
+		"ordId":  ref.OrdID,
+	}, nil)
+}
+
+// okxOrder 订单接口返回的普通订单。
+type okxOrder struct {
+	InstID    string `json:"instId"`
+	OrdID     string `json:"ordId"`
+	ClOrdID   string `json:"clOrdId"`
+	AlgoID    string `json:"algoId"` // 策略委托触发后提交的订单对应的策略委托ID
+	Px        string `json:"px"`
+	Sz        string `json:"sz"`
+	OrdType   string `json:"ordType"`
+	Side      string `json:"side"`
+	State     string `json:"state"`
+	AccFillSz string `json:"accFillSz"`
+	AvgPx     string `json:"avgPx"`
+	CTime     string `json:"cTime"`
+	UTime     string `json:"uTime"`
+}
+
+// Order 查询订单，策略委托返回ExchangeID对应的那条腿。
+func (o *OKX) Order(pair string, id int64) (model.Order, error) {
+	instrument, err := o.instrument(pair)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	ref, err := o.ref(pair, id)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	if ref.AlgoID != "" {
+		var result []okxAlgoOrder
+		err := o.request(o.ctx, http.MethodGet, "/api/v5/trade/order-algo",
+			map[string]interface{}{"algoId": ref.AlgoID}, nil, &result)
+		if err != nil {
+			return model.Order{}, err
+		}
+		for _, algo := range result {
+			for _, order := range o.newAlgoOrders(algo) {
+				if order.ExchangeID == id {
+					return order, nil
+				}
+			}
+		}
+		return model.Order{}, ErrOrderNotFound
+	}
+
+	var result []okxOrder
+	err = o.request(o.ctx, http.MethodGet, "/api/v5/trade/order",
+		map[string]interface{}{"instId": instrument.ID, "ordId": ref.OrdID}, nil, &result)
+	if err != nil {
+		return model.Order{}, err
+	}
+	if len(result) == 0 {
+		return model.Order{}, ErrOrderNotFound
+	}
+	return o.newOrder(result[0]), nil
+}
+
+// Orders 返回交易对最近的limit个订单，包括普通订单和策略委托，按创建时间从早到晚排序，与币安一致。
+func (o *OKX) Orders(pair string, limit int) ([]model.Order, error) {
+	instrument, err := o.instrument(pair)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > okxOrderLimit {
+		limit = okxOrderLimit
+	}
+
+	query := func() map[string]interface{} {
+		return map[string]interface{}{"instType": string(o.InstType), "instId": instrument.ID, "limit": limit}
+	}
+
+	orders := make([]model.Order, 0)
+	for _, path := range []string{"/api/v5/trade/orders-pending", "/api/v5/trade/orders-history"} {
+		var result []okxOrder
+		if err := o.request(o.ctx, http.MethodGet, path, query(), nil, &result); err != nil {
+			return nil, err
+		}
+		for _, order := range result {
+			// 策略委托提交的订单已经体现在策略委托中，不重复返回
+			if order.AlgoID == "" {
+				orders = append(orders, o.newOrder(order))
+			}
+		}
+	}
+
+	algos, err := o.algoOrders(query)
+	if err != nil {
+		return nil, err
+	}
+	orders = append(orders, algos...)
+
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].CreatedAt.Before(orders[j].CreatedAt)
+	})
+	if len(orders) > limit {
+		orders = orders[len(orders)-limit:]
+	}
+	return orders, nil
+}
+
+// register 记录ExchangeID对应的OKX订单。
+func (o *OKX) register(key string, ref okxRef) int64 {
+	id := stringExchangeID(key)
+	o.mtx.Lock()
+	o.refs[id] = ref
+	o.mtx.Unlock()
+	return id
+}
+
+// ref 返回ExchangeID对应的OKX订单，内存中没有记录时通过列出交易对最近的订单查找。
+func (o *OKX) ref(pair string, id int64) (okxRef, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		o.mtx.Lock()
+		ref, ok := o.refs[id]
+		o.mtx.Unlock()
+		if ok {
+			return ref, nil
+		}
+
+		if attempt == 0 {
+			// 列出订单时会记录所有订单的对应关系
+			if _, err := o.Orders(pair, okxOrderLimit); err != nil {
+				return okxRef{}, err
+			}
+		}
+	}
+	return okxRef{}, ErrOrderNotFound
+}
+
+// okxOrderStatus 将OKX的订单状态转换为内部的订单状态。
+func okxOrderStatus(state string) model.OrderStatusType {
+	switch state {
+	case "partially_filled":
+		return model.OrderStatusTypePartiallyFilled
+	case "filled":
+		return model.OrderStatusTypeFilled
+	case "canceled", "mmp_canceled":
+		return model.OrderStatusTypeCanceled
+	default: // live
+		return model.OrderStatusTypeNew
+	}
+}
+
+// okxTime 将OKX的毫秒时间戳字符串转换为时间。
+func okxTime(value string) time.Time {
+	ms, _ := strconv.ParseInt(value, 10, 64)
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// newOrder 将OKX的普通订单转换为内部订单模型，有成交时价格为成交均价，数量为成交数量。
+func (o *OKX) newOrder(order okxOrder) model.Order {
+	pair := o.pairs[order.InstID]
+
+	price, _ := strconv.ParseFloat(order.AvgPx, 64)
+	quantity := o.quantity(pair, order.AccFillSz)
+	if price == 0 || quantity == 0 {
+		price, _ = strconv.ParseFloat(order.Px, 64)
+		quantity = o.quantity(pair, order.Sz)
+	}
+
+	orderType, timeInForce := model.OrderTypeLimit, model.TimeInForceGTC
+	switch order.OrdType {
+	case "market", "optimal_limit_ioc":
+		orderType, timeInForce = model.OrderTypeMarket, ""
+	case "post_only":
+		orderType, timeInForce = model.OrderTypeLimitMaker, model.TimeInForceGTX
+	case "ioc":
+		timeInForce = model.TimeInForceIOC
+	case "fok":
+		timeInForce = model.TimeInForceFOK
+	}
+
+	return model.Order{
+		ExchangeID:    o.register(order.OrdID, okxRef{OrdID: order.OrdID}),
+		Pair:          pair,
+		CreatedAt:     okxTime(order.CTime),
+		UpdatedAt:     okxTime(order.UTime),
+		Side:          model.SideType(strings.ToUpper(order.Side)),
+		Type:          orderType,
+		Status:        okxOrderStatus(order.State),
+		Price:         price,
+		Quantity:      quantity,
+		ClientOrderID: order.ClOrdID,
+		TimeInForce:   timeInForce,
+	}
+}
+
+// Account 返回账户余额。现货返回各币种的可用和冻结余额；
+// 永续合约与币安期货一致，持仓作为基础资产的余额（空头为负数），保证金币种作为其他余额。
+func (o *OKX) Account() (model.Account, error) {
+	var result []struct {
+		Details []struct {
+			Ccy       string `json:"ccy"`
+			CashBal   string `json:"cashBal"`
+			AvailBal  string `json:"availBal"`
+			FrozenBal string `json:"frozenBal"`
+		} `json:"details"`
+	}
+	if err := o.request(o.ctx, http.MethodGet, "/api/v5/account/balance", nil, nil, &result); err != nil {
+		return model.Account{}, err
+	}
+
+	balances := make([]model.Balance, 0)
+	if o.InstType == OKXInstTypeSwap {
+		positions, err := o.positions()
+		if err != nil {
+			return model.Account{}, err
+		}
+		balances = append(balances, positions...)
+	}
+
+	for _, account := range result {
+		for _, detail := range account.Details {
+			balance := model.Balance{Asset: detail.Ccy}
+			if o.InstType == OKXInstTypeSpot {
+				balance.Free, _ = strconv.ParseFloat(detail.AvailBal, 64)
+				balance.Lock, _ = strconv.ParseFloat(detail.FrozenBal, 64)
+			} else {
+				balance.Free, _ = strconv.ParseFloat(detail.CashBal, 64)
+			}
+			if balance.Free == 0 && balance.Lock == 0 {
+				continue
+			}
+			balances = append(balances, balance)
+		}
+	}
+
+	return model.Account{Balances: balances}, nil
+}
+
+// positions 返回永续合约的持仓，张数换算为基础资产的数量。
+func (o *OKX) positions() ([]model.Balance, error) {
+	var result []struct {
+		InstID  string `json:"instId"`
+		Pos     string `json:"pos"`
+		PosSide string `json:"posSide"`
+		Lever   string `json:"lever"`
+	}
+	err := o.request(o.ctx, http.MethodGet, "/api/v5/account/positions",
+		map[string]interface{}{"instType": string(o.InstType)}, nil, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make([]model.Balance, 0)
+	for _, position := range result {
+		pair, ok := o.pairs[position.InstID]
+		if !ok {
+			continue
+		}
+
+		// 单向持仓模式下pos带符号，双向持仓模式下空头的pos为正数
+		size := o.quantity(pair, position.Pos)
+		if position.PosSide == "short" {
+			size = -math.Abs(size)
+		}
+		if size == 0 {
+			continue
+		}
+
+		leverage, _ := strconv.ParseFloat(position.Lever, 64)
+		balances = append(balances, model.Balance{
+			Asset:    o.assetsInfo[pair].BaseAsset,
+			Free:     size,
+			Leverage: leverage,
+		})
+	}
+	return balances, nil
+}
+
+// Position 返回交易对基础资产和计价资产的总余额（可用加冻结）。
+func (o *OKX) Position(pair string) (asset, quote float64, err error) {
+	info, ok := o.assetsInfo[pair]
+	if !ok {
+		return 0, 0, ErrInvalidAsset
+	}
+
+	acc, err := o.Account()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	assetBalance, quoteBalance := acc.Balance(info.BaseAsset, info.QuoteAsset)
+	return assetBalance.Free + assetBalance.Lock, quoteBalance.Free + quoteBalance.Lock, nil
+}
+
+// PositionInfo 返回交易对的持仓数量和杠杆倍数，合约持仓为负数时表示空头。
+func (o *OKX) PositionInfo(pair string) (model.PositionInfo, error) {
+	info, ok := o.assetsInfo[pair]
+	if !ok {
+		return model.PositionInfo{}, ErrInvalidAsset
+	}
+
+	acc, err := o.Account()
+	if err != nil {
+		return model.PositionInfo{}, err
+	}
+	return accountPosition(acc, pair, info.BaseAsset), nil
+}
+
+// okxBars 时间周期到OKX K线周期的对应关系，6小时及以上的周期使用UTC时间划分，与其他交易所一致。
+var okxBars = map[string]string{
+	"1m":  "1m",
+	"3m":  "3m",
+	"5m":  "5m",
+	"15m": "15m",
+	"30m": "30m",
+	"1h":  "1H",
+	"2h":  "2H",
+	"4h":  "4H",
+	"6h":  "6Hutc",
+	"12h": "12Hutc",
+	"1d":  "1Dutc",
+	"1w":  "1Wutc",
+	"1M":  "1Mutc",
+}
+
+// okxBar 将时间周期转换为OKX的K线周期。
+func okxBar(period string) (string, error) {
+	bar, ok := okxBars[period]
+	if !ok {
+		return "", fmt.Errorf("%w: okx bar %s", ErrNotSupported, period)
+	}
+	return bar, nil
+}
+
+// candles 调用K线接口，OKX按时间从新到旧返回，这里转换为从旧到新。
+func (o *OKX) candles(ctx context.Context, path, pair, period string, query map[string]interface{}) ([]model.Candle, error) {
+	instrument, err := o.instrument(pair)
+	if err != nil {
+		return nil, err
+	}
+	bar, err := okxBar(period)
+	if err != nil {
+		return nil, err
+	}
+	query["instId"] = instrument.ID
+	query["bar"] = bar
+
+	var result [][]string
+	if err := o.request(ctx, http.MethodGet, path, query, nil, &result); err != nil {
+		return nil, err
+	}
+
+	candles := make([]model.Candle, 0, len(result))
+	for i := len(result) - 1; i >= 0; i-- {
+		candle, err := okxCandle(pair, result[i])
+		if err != nil {
+			return nil, err
+		}
+		candles = append(candles, candle)
+	}
+	return candles, nil
+}
+
+// heikinAshi 启用Heikin Ashi时按时间顺序转换K线。
+func (o *OKX) heikinAshi(candles []model.Candle) []model.Candle {
+	if !o.HeikinAshi {
+		return candles
+	}
+	ha := model.NewHeikinAshi()
+	for i := range candles {
+		candles[i] = candles[i].ToHeikinAshi(ha)
+	}
+	return candles
+}
+
+// CandlesByLimit 获取最近limit根完整的K线，多请求一根并丢弃还没有结束的K线。
+func (o *OKX) CandlesByLimit(ctx context.Context, pair, period string, limit int) ([]model.Candle, error) {
+	candles, err := o.candles(ctx, "/api/v5/market/candles", pair, period, map[string]interface{}{
+		"limit": int(math.Min(float64(limit+1), okxCandleLimit)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	complete := make([]model.Candle, 0, len(candles))
+	for _, candle := range candles {
+		if candle.Complete {
+			complete = append(complete, candle)
+		}
+	}
+	if len(complete) > limit {
+		complete = complete[len(complete)-limit:]
+	}
+	return o.heikinAshi(complete), nil
+}
+
+// CandlesByPeriod 获取指定时间范围内的K线。历史K线接口每次最多返回100根，
+// 从结束时间开始通过after参数向前翻页，直到覆盖开始时间。
+func (o *OKX) CandlesByPeriod(ctx context.Context, pair, period string,
+	start, end time.Time) ([]model.Candle, error) {
+	startMs := start.UnixNano() / int64(time.Millisecond)
+	after := end.UnixNano()/int64(time.Millisecond) + 1 // after返回早于该时间的K线
+
+	pages := make([][]model.Candle, 0)
+	for {
+		page, err := o.candles(ctx, "/api/v5/market/history-candles", pair, period, map[string]interface{}{
+			"after": after,
+			"limit": okxHistoryLimit,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			break
+		}
+		pages = append(pages, page)
+
+		oldest := page[0].Time.UnixNano() / int64(time.Millisecond)
+		if len(page) < okxHistoryLimit || oldest <= startMs {
+			break
+		}
+		after = oldest
+	}
+
+	// 后请求的页面时间更早，倒序拼接后按时间从旧到新排列
+	candles := make([]model.Candle, 0)
+	for i := len(pages) - 1; i >= 0; i-- {
+		for _, candle := range pages[i] {
+			if !candle.Time.Before(start) {
+				candles = append(candles, candle)
+			}
+		}
+	}
+	return o.heikinAshi(candles), nil
+}
+
+// okxCandle 将K线数组 [开始时间, 开盘价, 最高价, 最低价, 收盘价, 成交量, ..., 是否结束] 转换为K线。
+func okxCandle(pair string, k []string) (model.Candle, error) {
+	if len(k) < 6 {
+		return model.Candle{}, fmt.Errorf("okx: invalid candle %v", k)
+	}
+
+	start, err := strconv.ParseInt(k[0], 10, 64)
+	if err != nil {
+		return model.Candle{}, err
+	}
+
+	t := time.Unix(0, start*int64(time.Millisecond))
+	candle := model.Candle{Pair: pair, Time: t, UpdatedAt: t, Complete: true}
+	candle.Open, _ = strconv.ParseFloat(k[1], 64)
+	candle.High, _ = strconv.ParseFloat(k[2], 64)
+	candle.Low, _ = strconv.ParseFloat(k[3], 64)
+	candle.Close, _ = strconv.ParseFloat(k[4], 64)
+	candle.Volume, _ = strconv.ParseFloat(k[5], 64)
+	if len(k) > 8 {
+		candle.Complete = k[8] == "1"
+	}
+	candle.Metadata = make(map[string]float64)
+	return candle, nil
+}
+
+// okxWsMessage K线WebSocket推送的消息，订阅结果和K线推送共用。
+type okxWsMessage struct {
+	Event string `json:"event"`
+	Code  string `json:"code"`
+	Msg   string `json:"msg"`
+	Arg   struct {
+		Channel string `json:"channel"`
+		InstID  string `json:"instId"`
+	} `json:"arg"`
+	Data [][]string `json:"data"`
+}
+
+// CandlesSubscription 订阅K线推送，连接断开后按指数退避重连，与Binance.CandlesSubscription一致。
+func (o *OKX) CandlesSubscription(ctx context.Context, pair, period string) (chan model.Candle, chan error) {
+	ccandle := make(chan model.Candle)
+	cerr := make(chan error)
+	ha := model.NewHeikinAshi()
+
+	// 连接协程中的发送在ctx取消后放弃，保证关闭通道前连接协程已经退出
+	sendErr := func(err error) {
+		select {
+		case cerr <- err:
+		case <-ctx.Done():
+		}
+	}
+
+	go func() {
+		ba := &backoff.Backoff{
+			Min: 100 * time.Millisecond,
+			Max: 1 * time.Second,
+		}
+
+		instrument, err := o.instrument(pair)
+		var bar string
+		if err == nil {
+			bar, err = okxBar(period)
+		}
+		if err != nil {
+			cerr <- err
+			close(cerr)
+			close(ccandle)
+			return
+		}
+
+		instID, channel := instrument.ID, "candle"+bar
+		subscribe, _ := json.Marshal(map[string]interface{}{
+			"op":   "subscribe",
+			"args": []map[string]string{{"channel": channel, "instId": instID}},
+		})
+
+		for {
+			done, stop, err := wsServeSubscribe(o.WsURL, subscribe, []byte("ping"), okxPingInterval, func(message []byte) {
+				// 心跳的回复是纯文本的pong
+				if string(message) == "pong" {
+					return
+				}
+
+				var event okxWsMessage
+				if err := json.Unmarshal(message, &event); err != nil {
+					sendErr(err)
+					return
+				}
+				if event.Event == "error" {
+					sendErr(&OKXError{Code: event.Code, Message: event.Msg})
+					return
+				}
+				if event.Arg.Channel != channel || event.Arg.InstID != instID {
+					return
+				}
+
+				ba.Reset()
+				for _, k := range event.Data {
+					candle, err := okxCandle(pair, k)
+					if err != nil {
+						sendErr(err)
+						continue
+					}
+					candle.UpdatedAt = time.Now()
+
+					if candle.Complete && o.HeikinAshi {
+						candle = candle.ToHeikinAshi(ha)
+					}
+
+					if candle.Complete {
+						for _, fetcher := range o.MetadataFetchers {
+							key, value := fetcher(pair, candle.Time)
+							candle.Metadata[key] = value
+						}
+					}
+
+					select {
+					case ccandle <- candle:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}, sendErr)
+			if err != nil {
+				sendErr(err)
+				close(cerr)
+				close(ccandle)
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				// 先断开连接并等待连接协程退出，避免向已经关闭的通道发送
+				close(stop)
+				<-done
+				close(cerr)
+				close(ccandle)
+				return
+			case <-done:
+				time.Sleep(ba.Duration())
+			}
+		}
+	}()
+
+	return ccandle, cerr
+}
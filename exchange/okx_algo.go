@@ -0,0 +1,259 @@
+package exchange
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+/*
+OKX的OCO订单和止损单是策略委托（algo order）：策略委托在触发前不占用订单，触发后由交易所提交一个普通订单。
+策略委托的状态：live（等待触发）、effective（已触发并提交了订单）、canceled（已撤销）、order_failed（触发后下单失败）。
+已触发的策略委托按提交的订单返回状态和成交价格，OCO订单中没有触发的另一条腿视为已撤销。
+*/
+
+// okxAlgoOrder 策略委托接口返回的策略委托。
+type okxAlgoOrder struct {
+	InstID      string `json:"instId"`
+	AlgoID      string `json:"algoId"`
+	AlgoClOrdID string `json:"algoClOrdId"`
+	OrdType     string `json:"ordType"`
+	Side        string `json:"side"`
+	Sz          string `json:"sz"`
+	State       string `json:"state"`
+	TpTriggerPx string `json:"tpTriggerPx"`
+	TpOrdPx     string `json:"tpOrdPx"`
+	SlTriggerPx string `json:"slTriggerPx"`
+	SlOrdPx     string `json:"slOrdPx"`
+	ActualSide  string `json:"actualSide"` // 触发的是止盈（tp）还是止损（sl）
+	OrdID       string `json:"ordId"`      // 触发后提交的订单ID
+	CTime       string `json:"cTime"`
+	UTime       string `json:"uTime"`
+}
+
+// createAlgoOrder 提交策略委托，返回策略委托ID。
+func (o *OKX) createAlgoOrder(body map[string]interface{}) (string, error) {
+	var result []struct {
+		AlgoID string `json:"algoId"`
+	}
+	if err := o.request(o.ctx, http.MethodPost, "/api/v5/trade/order-algo", nil, body, &result); err != nil {
+		return "", err
+	}
+	if len(result) == 0 {
+		return "", fmt.Errorf("okx: empty algo order response")
+	}
+	return result[0].AlgoID, nil
+}
+
+// CreateOrderOCO 通过OKX的OCO策略委托创建OCO订单：价格达到price时以price提交止盈限价单，
+// 价格达到stop时以stopLimit提交止损限价单，一条腿触发后另一条腿自动撤销。
+func (o *OKX) CreateOrderOCO(side model.SideType, pair string,
+	quantity, price, stop, stopLimit float64, options ...model.OrderOption) ([]model.Order, error) {
+	if err := o.validate(pair, quantity); err != nil {
+		return nil, err
+	}
+
+	params := model.NewOrderParams(options...)
+	body, err := o.orderBody(side, pair, "oco", model.OrderParams{})
+	if err != nil {
+		return nil, err
+	}
+	body["sz"] = o.formatSize(pair, quantity)
+	body["tpTriggerPx"] = o.formatPrice(pair, price)
+	body["tpOrdPx"] = o.formatPrice(pair, price)
+	body["slTriggerPx"] = o.formatPrice(pair, stop)
+	body["slOrdPx"] = o.formatPrice(pair, stopLimit)
+	if params.ClientOrderID != "" {
+		body["algoClOrdId"] = params.ClientOrderID
+	}
+
+	algoID, err := o.createAlgoOrder(body)
+	if err != nil {
+		return nil, err
+	}
+
+	legs := o.newAlgoOrders(okxAlgoOrder{
+		InstID:      body["instId"].(string),
+		AlgoID:      algoID,
+		AlgoClOrdID: params.ClientOrderID,
+		OrdType:     "oco",
+		Side:        strings.ToLower(string(side)),
+		Sz:          body["sz"].(string),
+		State:       "live",
+		TpTriggerPx: body["tpTriggerPx"].(string),
+		TpOrdPx:     body["tpOrdPx"].(string),
+		SlTriggerPx: body["slTriggerPx"].(string),
+		SlOrdPx:     body["slOrdPx"].(string),
+	})
+	for i := range legs {
+		params.Apply(&legs[i])
+	}
+	return legs, nil
+}
+
+// CreateOrderStop 通过OKX的单向止盈止损策略委托创建止损卖单，价格跌破limit后以市价卖出。
+func (o *OKX) CreateOrderStop(pair string, quantity float64, limit float64,
+	options ...model.OrderOption) (model.Order, error) {
+	if err := o.validate(pair, quantity); err != nil {
+		return model.Order{}, err
+	}
+
+	params := model.NewOrderParams(options...)
+	body, err := o.orderBody(model.SideTypeSell, pair, "conditional", model.OrderParams{})
+	if err != nil {
+		return model.Order{}, err
+	}
+	body["sz"] = o.formatSize(pair, quantity)
+	body["slTriggerPx"] = o.formatPrice(pair, limit)
+	body["slOrdPx"] = "-1" // -1表示触发后提交市价单
+	if params.ClientOrderID != "" {
+		body["algoClOrdId"] = params.ClientOrderID
+	}
+
+	algoID, err := o.createAlgoOrder(body)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	legs := o.newAlgoOrders(okxAlgoOrder{
+		InstID:      body["instId"].(string),
+		AlgoID:      algoID,
+		AlgoClOrdID: params.ClientOrderID,
+		OrdType:     "conditional",
+		Side:        "sell",
+		Sz:          body["sz"].(string),
+		State:       "live",
+		SlTriggerPx: body["slTriggerPx"].(string),
+		SlOrdPx:     "-1",
+	})
+	result := legs[0]
+	params.Apply(&result)
+	return result, nil
+}
+
+// algoOrders 返回交易对等待触发的和已经结束的OCO订单和止损单。
+// 历史策略委托必须指定状态，分别查询已触发和已撤销的策略委托。
+func (o *OKX) algoOrders(query func() map[string]interface{}) ([]model.Order, error) {
+	requests := []struct {
+		path  string
+		state string
+	}{
+		{"/api/v5/trade/orders-algo-pending", ""},
+		{"/api/v5/trade/orders-algo-history", "effective"},
+		{"/api/v5/trade/orders-algo-history", "canceled"},
+	}
+
+	orders := make([]model.Order, 0)
+	for _, request := range requests {
+		params := query()
+		delete(params, "instType")
+		params["ordType"] = "conditional,oco"
+		if request.state != "" {
+			params["state"] = request.state
+		}
+
+		var result []okxAlgoOrder
+		if err := o.request(o.ctx, http.MethodGet, request.path, params, nil, &result); err != nil {
+			return nil, err
+		}
+		for _, algo := range result {
+			orders = append(orders, o.newAlgoOrders(algo)...)
+		}
+	}
+	return orders, nil
+}
+
+// okxAlgoStatus 将策略委托的状态转换为内部的订单状态，已触发的状态由提交的订单决定。
+func okxAlgoStatus(state string) model.OrderStatusType {
+	switch state {
+	case "canceled":
+		return model.OrderStatusTypeCanceled
+	case "order_failed":
+		return model.OrderStatusTypeRejected
+	case "effective":
+		return model.OrderStatusTypeFilled
+	default: // live、pause、partially_effective
+		return model.OrderStatusTypeNew
+	}
+}
+
+// newAlgoOrders 将策略委托转换为内部订单模型，止损单返回一个订单，OCO订单返回止盈和止损两个订单。
+func (o *OKX) newAlgoOrders(algo okxAlgoOrder) []model.Order {
+	pair := o.pairs[algo.InstID]
+	quantity := o.quantity(pair, algo.Sz)
+	status := okxAlgoStatus(algo.State)
+
+	// 已触发的策略委托查询提交的订单，使用订单的状态、成交均价和成交数量
+	var triggered *model.Order
+	if algo.State == "effective" && algo.OrdID != "" {
+		if order, err := o.Order(pair, o.register(algo.OrdID, okxRef{OrdID: algo.OrdID})); err == nil {
+			triggered = &order
+		}
+	}
+
+	var groupID *int64
+	if algo.OrdType == "oco" {
+		id := stringExchangeID(algo.AlgoID)
+		groupID = &id
+	}
+
+	leg := func(name, trigger, price string) model.Order {
+		key := algo.AlgoID
+		if name == "sl" && algo.OrdType == "oco" {
+			key += ":sl"
+		}
+
+		stop, _ := strconv.ParseFloat(trigger, 64)
+		limit, _ := strconv.ParseFloat(price, 64)
+
+		order := model.Order{
+			ExchangeID:    o.register(key, okxRef{AlgoID: algo.AlgoID, Leg: name}),
+			Pair:          pair,
+			CreatedAt:     okxTime(algo.CTime),
+			UpdatedAt:     okxTime(algo.UTime),
+			Side:          model.SideType(strings.ToUpper(algo.Side)),
+			Status:        status,
+			Price:         limit,
+			Quantity:      quantity,
+			GroupID:       groupID,
+			ClientOrderID: algo.AlgoClOrdID,
+			TimeInForce:   model.TimeInForceGTC,
+		}
+
+		switch {
+		case name == "tp":
+			order.Type = model.OrderTypeLimitMaker
+		case price == "-1":
+			// 触发后提交市价单，价格记为触发价
+			order.Type = model.OrderTypeStopLoss
+			order.Price = stop
+			order.Stop = &stop
+		default:
+			order.Type = model.OrderTypeStopLossLimit
+			order.Stop = &stop
+		}
+
+		if status == model.OrderStatusTypeFilled {
+			if algo.ActualSide != "" && algo.ActualSide != name {
+				// OCO订单中没有触发的一条腿被交易所撤销
+				order.Status = model.OrderStatusTypeCanceled
+			} else if triggered != nil {
+				order.Status = triggered.Status
+				order.Price = triggered.Price
+				order.Quantity = triggered.Quantity
+			}
+		}
+		return order
+	}
+
+	if algo.OrdType == "oco" {
+		return []model.Order{
+			leg("tp", algo.TpTriggerPx, algo.TpOrdPx),
+			leg("sl", algo.SlTriggerPx, algo.SlOrdPx),
+		}
+	}
+	return []model.Order{leg("sl", algo.SlTriggerPx, algo.SlOrdPx)}
+}
@@ -0,0 +1,600 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+const (
+	okxTestKey        = "key"
+	okxTestSecret     = "secret"
+	okxTestPassphrase = "passphrase"
+	okxTestPrice      = 30000.0
+	okxTestStart      = int64(1700000000000) // 历史K线的开始时间
+)
+
+// okxStub 模拟OKX V5接口的测试服务器，市价单以固定价格立即成交，限价单和策略委托一直等待，直到测试调用trigger。
+type okxStub struct {
+	t        *testing.T
+	instType string
+
+	mtx      sync.Mutex
+	orders   []okxOrder
+	algos    []okxAlgoOrder
+	leverage map[string]string
+	nextID   int64
+}
+
+func newOKXStub(t *testing.T, instType string) (*okxStub, *httptest.Server) {
+	stub := &okxStub{t: t, instType: instType, leverage: make(map[string]string), nextID: 500000000000000000}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	return stub, server
+}
+
+func (s *okxStub) id() string {
+	s.nextID++
+	return strconv.FormatInt(s.nextID, 10)
+}
+
+func (s *okxStub) now() string {
+	// 每次调用递增，保证订单的创建时间不同
+	return strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond)+s.nextID%1000, 10)
+}
+
+func (s *okxStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/ws/v5/business" {
+		s.serveWs(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	require.NoError(s.t, err)
+
+	// 私有接口校验签名和Passphrase
+	if key := r.Header.Get("OK-ACCESS-KEY"); key != "" {
+		path := r.URL.Path
+		if r.URL.RawQuery != "" {
+			path += "?" + r.URL.RawQuery
+		}
+		payload := r.Header.Get("OK-ACCESS-TIMESTAMP") + r.Method + path + string(body)
+		if key != okxTestKey || r.Header.Get("OK-ACCESS-PASSPHRASE") != okxTestPassphrase ||
+			r.Header.Get("OK-ACCESS-SIGN") != okxSign(okxTestSecret, payload) {
+			s.reply(w, "50113", "Invalid Sign", nil)
+			return
+		}
+	}
+
+	query := r.URL.Query()
+	params := make(map[string]string)
+	if len(body) > 0 && body[0] == '{' {
+		require.NoError(s.t, json.Unmarshal(body, &params))
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	switch r.Method + " " + r.URL.Path {
+	case "GET /api/v5/public/time":
+		s.reply(w, "0", "", []map[string]string{{"ts": strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)}})
+	case "GET /api/v5/public/instruments":
+		s.instruments(w, query.Get("instType"))
+	case "POST /api/v5/account/set-leverage":
+		s.leverage[params["instId"]] = params["lever"] + "/" + params["mgnMode"]
+		s.reply(w, "0", "", []map[string]string{params})
+	case "GET /api/v5/market/candles":
+		// 按时间从新到旧返回，最新的一根K线还没有结束
+		s.reply(w, "0", "", [][]string{
+			{"1700007200000", "102", "104", "101", "103", "12", "0", "0", "0"},
+			{"1700003600000", "101", "103", "100", "102", "11", "0", "0", "1"},
+			{"1700000000000", "100", "102", "99", "101", "10", "0", "0", "1"},
+		})
+	case "GET /api/v5/market/history-candles":
+		s.historyCandles(w, query)
+	case "POST /api/v5/trade/order":
+		s.createOrder(w, params)
+	case "GET /api/v5/trade/order":
+		for _, order := range s.orders {
+			if order.OrdID == query.Get("ordId") {
+				s.reply(w, "0", "", []okxOrder{order})
+				return
+			}
+		}
+		s.reply(w, "51603", "Order does not exist", nil)
+	case "GET /api/v5/trade/orders-pending", "GET /api/v5/trade/orders-history":
+		pending := strings.HasSuffix(r.URL.Path, "pending")
+		list := make([]okxOrder, 0)
+		for i := len(s.orders) - 1; i >= 0; i-- {
+			if (s.orders[i].State == "live") == pending && s.orders[i].InstID == query.Get("instId") {
+				list = append(list, s.orders[i])
+			}
+		}
+		s.reply(w, "0", "", list)
+	case "POST /api/v5/trade/cancel-order":
+		for i, order := range s.orders {
+			if order.OrdID == params["ordId"] && order.State == "live" {
+				s.orders[i].State = "canceled"
+				s.reply(w, "0", "", []map[string]string{{"ordId": order.OrdID, "sCode": "0"}})
+				return
+			}
+		}
+		s.reply(w, "1", "", []map[string]string{{"ordId": params["ordId"], "sCode": "51400", "sMsg": "Cancellation failed"}})
+	case "POST /api/v5/trade/order-algo":
+		s.createAlgo(w, params)
+	case "GET /api/v5/trade/order-algo":
+		for _, algo := range s.algos {
+			if algo.AlgoID == query.Get("algoId") {
+				s.reply(w, "0", "", []okxAlgoOrder{algo})
+				return
+			}
+		}
+		s.reply(w, "51603", "Order does not exist", nil)
+	case "GET /api/v5/trade/orders-algo-pending", "GET /api/v5/trade/orders-algo-history":
+		require.Equal(s.t, "conditional,oco", query.Get("ordType"))
+		state := query.Get("state")
+		if state == "" {
+			state = "live"
+		}
+		list := make([]okxAlgoOrder, 0)
+		for i := len(s.algos) - 1; i >= 0; i-- {
+			if s.algos[i].State == state && s.algos[i].InstID == query.Get("instId") {
+				list = append(list, s.algos[i])
+			}
+		}
+		s.reply(w, "0", "", list)
+	case "POST /api/v5/trade/cancel-algos":
+		var items []map[string]string
+		require.NoError(s.t, json.Unmarshal(body, &items))
+		for i, algo := range s.algos {
+			if algo.AlgoID == items[0]["algoId"] && algo.State == "live" {
+				s.algos[i].State = "canceled"
+				s.reply(w, "0", "", []map[string]string{{"algoId": algo.AlgoID, "sCode": "0"}})
+				return
+			}
+		}
+		s.reply(w, "1", "", []map[string]string{{"sCode": "51400", "sMsg": "Cancellation failed"}})
+	case "GET /api/v5/account/balance":
+		s.reply(w, "0", "", []map[string]interface{}{{"details": []map[string]string{
+			{"ccy": "USDT", "cashBal": "10000", "availBal": "9500", "frozenBal": "500"},
+			{"ccy": "BTC", "cashBal": "1", "availBal": "1", "frozenBal": "0"},
+			{"ccy": "ETH", "cashBal": "0", "availBal": "0", "frozenBal": "0"},
+		}}})
+	case "GET /api/v5/account/positions":
+		s.reply(w, "0", "", []map[string]string{
+			{"instId": "BTC-USDT-SWAP", "pos": "-3", "posSide": "net", "lever": "5"},
+			{"instId": "BTC-USD-SWAP", "pos": "10", "posSide": "net", "lever": "3"},
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *okxStub) reply(w http.ResponseWriter, code, message string, data interface{}) {
+	if data == nil {
+		data = []interface{}{}
+	}
+	require.NoError(s.t, json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": code,
+		"msg":  message,
+		"data": data,
+	}))
+}
+
+// instruments 永续合约包含一个币本位合约，适配器应该忽略。
+func (s *okxStub) instruments(w http.ResponseWriter, instType string) {
+	require.Equal(s.t, s.instType, instType)
+	if instType == string(OKXInstTypeSpot) {
+		s.reply(w, "0", "", []map[string]string{{
+			"instId": "BTC-USDT", "baseCcy": "BTC", "quoteCcy": "USDT",
+			"lotSz": "0.00000001", "minSz": "0.00001", "maxLmtSz": "10000", "tickSz": "0.1",
+		}})
+		return
+	}
+	s.reply(w, "0", "", []map[string]string{
+		{
+			"instId": "BTC-USDT-SWAP", "ctType": "linear", "ctVal": "0.01", "ctValCcy": "BTC", "settleCcy": "USDT",
+			"lotSz": "0.1", "minSz": "0.1", "maxLmtSz": "100000", "tickSz": "0.1",
+		},
+		{
+			"instId": "BTC-USD-SWAP", "ctType": "inverse", "ctVal": "100", "ctValCcy": "USD", "settleCcy": "BTC",
+			"lotSz": "1", "minSz": "1", "maxLmtSz": "100000", "tickSz": "0.1",
+		},
+	})
+}
+
+// historyCandles 从okxTestStart开始有250根1小时K线，返回早于after的limit根，按时间从新到旧。
+func (s *okxStub) historyCandles(w http.ResponseWriter, query map[string][]string) {
+	after, _ := strconv.ParseInt(query["after"][0], 10, 64)
+	limit, _ := strconv.Atoi(query["limit"][0])
+	require.LessOrEqual(s.t, limit, okxHistoryLimit)
+
+	list := make([][]string, 0)
+	for i := 249; i >= 0 && len(list) < limit; i-- {
+		ts := okxTestStart + int64(i)*time.Hour.Milliseconds()
+		if ts >= after {
+			continue
+		}
+		price := strconv.Itoa(100 + i)
+		list = append(list, []string{strconv.FormatInt(ts, 10), price, price, price, price, "1", "0", "0", "1"})
+	}
+	s.reply(w, "0", "", list)
+}
+
+func (s *okxStub) createOrder(w http.ResponseWriter, params map[string]string) {
+	if params["clOrdId"] == "reject" {
+		s.reply(w, "1", "", []map[string]string{{"sCode": "51008", "sMsg": "Insufficient balance"}})
+		return
+	}
+
+	order := okxOrder{
+		InstID:  params["instId"],
+		OrdID:   s.id(),
+		ClOrdID: params["clOrdId"],
+		Px:      params["px"],
+		Sz:      params["sz"],
+		OrdType: params["ordType"],
+		Side:    params["side"],
+		State:   "live",
+		CTime:   s.now(),
+	}
+	order.UTime = order.CTime
+
+	// 永续合约记录交易模式，测试中检查
+	if s.instType == string(OKXInstTypeSwap) {
+		order.ClOrdID = params["tdMode"]
+	} else {
+		require.Equal(s.t, "cash", params["tdMode"])
+	}
+
+	if order.OrdType == "market" {
+		size, _ := strconv.ParseFloat(order.Sz, 64)
+		if params["tgtCcy"] == "quote_ccy" {
+			size /= okxTestPrice
+		}
+		order.State = "filled"
+		order.AccFillSz = strconv.FormatFloat(size, 'f', -1, 64)
+		order.AvgPx = strconv.FormatFloat(okxTestPrice, 'f', -1, 64)
+	}
+
+	s.orders = append(s.orders, order)
+	s.reply(w, "0", "", []map[string]string{{"ordId": order.OrdID, "clOrdId": order.ClOrdID, "sCode": "0"}})
+}
+
+func (s *okxStub) createAlgo(w http.ResponseWriter, params map[string]string) {
+	algo := okxAlgoOrder{
+		InstID:      params["instId"],
+		AlgoID:      s.id(),
+		AlgoClOrdID: params["algoClOrdId"],
+		OrdType:     params["ordType"],
+		Side:        params["side"],
+		Sz:          params["sz"],
+		State:       "live",
+		TpTriggerPx: params["tpTriggerPx"],
+		TpOrdPx:     params["tpOrdPx"],
+		SlTriggerPx: params["slTriggerPx"],
+		SlOrdPx:     params["slOrdPx"],
+		CTime:       s.now(),
+	}
+	algo.UTime = algo.CTime
+	s.algos = append(s.algos, algo)
+	s.reply(w, "0", "", []map[string]string{{"algoId": algo.AlgoID, "sCode": "0"}})
+}
+
+// trigger 触发策略委托，提交的订单以price全部成交。
+func (s *okxStub) trigger(algoID, side, price string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for i, algo := range s.algos {
+		if algo.AlgoID != algoID {
+			continue
+		}
+		order := okxOrder{
+			InstID:    algo.InstID,
+			OrdID:     s.id(),
+			AlgoID:    algoID,
+			Px:        price,
+			Sz:        algo.Sz,
+			OrdType:   "limit",
+			Side:      algo.Side,
+			State:     "filled",
+			AccFillSz: algo.Sz,
+			AvgPx:     price,
+			CTime:     s.now(),
+		}
+		order.UTime = order.CTime
+		s.orders = append(s.orders, order)
+
+		s.algos[i].State = "effective"
+		s.algos[i].ActualSide = side
+		s.algos[i].OrdID = order.OrdID
+	}
+}
+
+// serveWs 推送一次pong、一根未完成的K线和一根完成的K线，然后保持连接。
+func (s *okxStub) serveWs(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	require.NoError(s.t, err)
+	defer conn.Close()
+
+	var subscribe struct {
+		Op   string              `json:"op"`
+		Args []map[string]string `json:"args"`
+	}
+	require.NoError(s.t, conn.ReadJSON(&subscribe))
+	arg := subscribe.Args[0]
+	if arg["channel"] != "candle1H" || arg["instId"] != "BTC-USDT" {
+		_ = conn.WriteJSON(map[string]string{"event": "error", "code": "60018", "msg": "Invalid channel"})
+		return
+	}
+	require.NoError(s.t, conn.WriteJSON(map[string]interface{}{"event": "subscribe", "arg": arg}))
+	require.NoError(s.t, conn.WriteMessage(websocket.TextMessage, []byte("pong")))
+
+	for _, k := range [][]string{
+		{"1700000000000", "100", "110", "90", "101", "5", "0", "0", "0"},
+		{"1700000000000", "100", "110", "90", "102", "6", "0", "0", "1"},
+	} {
+		require.NoError(s.t, conn.WriteJSON(map[string]interface{}{"arg": arg, "data": [][]string{k}}))
+	}
+
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func newTestOKX(t *testing.T, server *httptest.Server, options ...OKXOption) *OKX {
+	options = append([]OKXOption{
+		WithOKXCredentials(okxTestKey, okxTestSecret, okxTestPassphrase),
+		WithOKXEndpoint(server.URL, "ws"+strings.TrimPrefix(server.URL, "http")+"/ws/v5/business"),
+	}, options...)
+	okx, err := NewOKX(context.Background(), options...)
+	require.NoError(t, err)
+	return okx
+}
+
+func TestOKX_Spot(t *testing.T) {
+	stub, server := newOKXStub(t, string(OKXInstTypeSpot))
+	okx := newTestOKX(t, server)
+
+	t.Run("instruments", func(t *testing.T) {
+		id, err := okx.InstrumentID("BTCUSDT")
+		require.NoError(t, err)
+		assert.Equal(t, "BTC-USDT", id)
+		assert.Equal(t, "BTCUSDT", okx.Pair("BTC-USDT"))
+
+		_, err = okx.InstrumentID("ETHUSDT")
+		require.ErrorIs(t, err, ErrInvalidAsset)
+
+		info := okx.AssetsInfo("BTCUSDT")
+		assert.Equal(t, "BTC", info.BaseAsset)
+		assert.Equal(t, "USDT", info.QuoteAsset)
+		assert.Equal(t, 0.00001, info.MinQuantity)
+		assert.Equal(t, 8, info.BaseAssetPrecision)
+		assert.Equal(t, 1, info.QuotePrecision)
+		assert.Equal(t, "123.4", okx.formatPrice("BTCUSDT", 123.456))
+
+		_, err = okx.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 0.000001, 1000)
+		require.ErrorIs(t, err, ErrInvalidQuantity)
+	})
+
+	t.Run("candles", func(t *testing.T) {
+		candles, err := okx.CandlesByLimit(context.Background(), "BTCUSDT", "1h", 2)
+		require.NoError(t, err)
+		require.Len(t, candles, 2)
+		assert.Equal(t, int64(1700000000), candles[0].Time.Unix())
+		assert.Equal(t, 102.0, candles[1].Close)
+
+		quote, err := okx.LastQuote(context.Background(), "BTCUSDT")
+		require.NoError(t, err)
+		assert.Equal(t, 102.0, quote)
+
+		// 需要翻页才能取到开始时间的K线
+		start := time.Unix(0, (okxTestStart+10*time.Hour.Milliseconds())*int64(time.Millisecond))
+		end := start.Add(159 * time.Hour)
+		candles, err = okx.CandlesByPeriod(context.Background(), "BTCUSDT", "1h", start, end)
+		require.NoError(t, err)
+		require.Len(t, candles, 160)
+		assert.Equal(t, start, candles[0].Time)
+		assert.Equal(t, end, candles[159].Time)
+		assert.Equal(t, 110.0, candles[0].Close)
+
+		_, err = okx.CandlesByLimit(context.Background(), "BTCUSDT", "10m", 2)
+		require.ErrorIs(t, err, ErrNotSupported)
+	})
+
+	t.Run("orders", func(t *testing.T) {
+		order, err := okx.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 0.5)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeFilled, order.Status)
+		assert.Equal(t, model.OrderTypeMarket, order.Type)
+		assert.Equal(t, okxTestPrice, order.Price)
+		assert.Equal(t, 0.5, order.Quantity)
+
+		order, err = okx.CreateOrderMarketQuote(model.SideTypeBuy, "BTCUSDT", 3000)
+		require.NoError(t, err)
+		assert.Equal(t, 0.1, order.Quantity)
+
+		limit, err := okx.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 0.1, 25000,
+			model.WithClientOrderID("entry1"), model.WithPostOnly())
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderTypeLimitMaker, limit.Type)
+		assert.Equal(t, "post_only", stub.orders[2].OrdType)
+
+		found, err := okx.Order("BTCUSDT", limit.ExchangeID)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeNew, found.Status)
+		assert.Equal(t, model.TimeInForceGTX, found.TimeInForce)
+		assert.Equal(t, "entry1", found.ClientOrderID)
+		assert.Equal(t, 25000.0, found.Price)
+
+		require.NoError(t, okx.Cancel(limit))
+		found, err = okx.Order("BTCUSDT", limit.ExchangeID)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeCanceled, found.Status)
+
+		err = okx.Cancel(limit)
+		var okxErr *OKXError
+		require.ErrorAs(t, err, &okxErr)
+		assert.Equal(t, "51400", okxErr.Code)
+		require.ErrorIs(t, err, ErrOrderNotFound)
+
+		_, err = okx.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 0.1, 25000, model.WithClientOrderID("reject"))
+		require.ErrorIs(t, err, ErrInsufficientFunds)
+
+		_, err = okx.Order("BTCUSDT", 42)
+		require.ErrorIs(t, err, ErrOrderNotFound)
+	})
+
+	t.Run("oco", func(t *testing.T) {
+		legs, err := okx.CreateOrderOCO(model.SideTypeSell, "BTCUSDT", 0.5, 35000, 28000, 27900,
+			model.WithClientOrderID("exit1"))
+		require.NoError(t, err)
+		require.Len(t, legs, 2)
+		assert.Equal(t, *legs[0].GroupID, *legs[1].GroupID)
+		assert.NotEqual(t, legs[0].ExchangeID, legs[1].ExchangeID)
+		assert.Equal(t, model.OrderTypeLimitMaker, legs[0].Type)
+		assert.Equal(t, 35000.0, legs[0].Price)
+		assert.Equal(t, model.OrderTypeStopLossLimit, legs[1].Type)
+		assert.Equal(t, 27900.0, legs[1].Price)
+		assert.Equal(t, 28000.0, *legs[1].Stop)
+		assert.Equal(t, "exit1", legs[1].ClientOrderID)
+
+		algo := stub.algos[0]
+		assert.Equal(t, "oco", algo.OrdType)
+		assert.Equal(t, "35000", algo.TpTriggerPx)
+		assert.Equal(t, "27900", algo.SlOrdPx)
+
+		// 止盈触发后止盈腿成交，止损腿被撤销
+		stub.trigger(algo.AlgoID, "tp", "35000")
+
+		// 新实例没有ExchangeID的对应关系，通过列出订单重新建立
+		restarted := newTestOKX(t, server)
+		tp, err := restarted.Order("BTCUSDT", legs[0].ExchangeID)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeFilled, tp.Status)
+		assert.Equal(t, 35000.0, tp.Price)
+		assert.Equal(t, 0.5, tp.Quantity)
+
+		sl, err := restarted.Order("BTCUSDT", legs[1].ExchangeID)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeCanceled, sl.Status)
+
+		// 策略委托提交的订单不单独出现在订单列表中
+		orders, err := restarted.Orders("BTCUSDT", 0)
+		require.NoError(t, err)
+		require.Len(t, orders, 5)
+		assert.Equal(t, legs[1].ExchangeID, orders[4].ExchangeID)
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		stop, err := okx.CreateOrderStop("BTCUSDT", 0.5, 28000)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderTypeStopLoss, stop.Type)
+		assert.Equal(t, 28000.0, stop.Price)
+		assert.Equal(t, "-1", stub.algos[1].SlOrdPx)
+
+		require.NoError(t, okx.Cancel(stop))
+		found, err := okx.Order("BTCUSDT", stop.ExchangeID)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeCanceled, found.Status)
+	})
+
+	t.Run("account", func(t *testing.T) {
+		account, err := okx.Account()
+		require.NoError(t, err)
+		require.Len(t, account.Balances, 2)
+
+		asset, quote, err := okx.Position("BTCUSDT")
+		require.NoError(t, err)
+		assert.Equal(t, 1.0, asset)
+		assert.Equal(t, 10000.0, quote)
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		_, err := NewOKX(context.Background(),
+			WithOKXCredentials(okxTestKey, "wrong", okxTestPassphrase),
+			WithOKXEndpoint(server.URL, ""))
+		var okxErr *OKXError
+		require.ErrorAs(t, err, &okxErr)
+		assert.Equal(t, "50113", okxErr.Code)
+	})
+}
+
+func TestOKX_Swap(t *testing.T) {
+	stub, server := newOKXStub(t, string(OKXInstTypeSwap))
+	okx := newTestOKX(t, server,
+		WithOKXInstType(OKXInstTypeSwap),
+		WithOKXLeverage("btcusdt", 5, MarginTypeIsolated))
+	assert.Equal(t, "5/isolated", stub.leverage["BTC-USDT-SWAP"])
+
+	// 只加载U本位合约，数量限制换算为基础资产
+	assert.Equal(t, "BTCUSDT", okx.Pair("BTC-USDT-SWAP"))
+	assert.Empty(t, okx.Pair("BTC-USD-SWAP"))
+	info := okx.AssetsInfo("BTCUSDT")
+	assert.Equal(t, 0.001, info.StepSize)
+	assert.Equal(t, 0.001, info.MinQuantity)
+	assert.Equal(t, 3, info.BaseAssetPrecision)
+
+	order, err := okx.CreateOrderMarket(model.SideTypeSell, "BTCUSDT", 0.035)
+	require.NoError(t, err)
+	assert.Equal(t, "3.5", stub.orders[0].Sz)
+	assert.Equal(t, "isolated", stub.orders[0].ClOrdID)
+	assert.Equal(t, model.SideTypeSell, order.Side)
+	assert.Equal(t, 0.035, order.Quantity)
+	assert.Equal(t, okxTestPrice, order.Price)
+
+	_, err = okx.CreateOrderMarketQuote(model.SideTypeBuy, "BTCUSDT", 1000)
+	require.ErrorIs(t, err, ErrNotSupported)
+
+	account, err := okx.Account()
+	require.NoError(t, err)
+	asset, quote := account.Balance("BTC", "USDT")
+	assert.Equal(t, -0.03, asset.Free)
+	assert.Equal(t, 5.0, asset.Leverage)
+	assert.Equal(t, 10000.0, quote.Free)
+
+	_, err = NewOKX(context.Background(),
+		WithOKXEndpoint(server.URL, ""),
+		WithOKXLeverage("BTCUSDT", 5, MarginTypeCrossed))
+	require.ErrorIs(t, err, ErrNotSupported)
+}
+
+func TestOKX_CandlesSubscription(t *testing.T) {
+	_, server := newOKXStub(t, string(OKXInstTypeSpot))
+	okx := newTestOKX(t, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	candles, _ := okx.CandlesSubscription(ctx, "BTCUSDT", "1h")
+	candle := <-candles
+	assert.False(t, candle.Complete)
+	assert.Equal(t, 101.0, candle.Close)
+
+	candle = <-candles
+	assert.True(t, candle.Complete)
+	assert.Equal(t, 102.0, candle.Close)
+	assert.Equal(t, 6.0, candle.Volume)
+
+	_, errs := okx.CandlesSubscription(ctx, "BTCUSDT", "1d")
+	err := <-errs
+	var okxErr *OKXError
+	require.ErrorAs(t, err, &okxErr)
+	assert.Equal(t, "60018", okxErr.Code)
+}
@@ -6,9 +6,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/adshao/go-binance/v2"
 	"github.com/adshao/go-binance/v2/futures"
+
+	"github.com/rodrigo-brito/ninjabot/model"
 )
 
 /*
@@ -50,7 +54,10 @@ func init() {
 
 // SplitAssetQuote 函数接收一个交易对的名称（例如 "BTCUSDT"）作为输入。
 // 它从全局映射 pairAssetQuoteMap 中查找与给定交易对相对应的 AssetQuote 结构体。
+// 指定了交易所的交易对（例如 "okx:BTCUSDT"）按去掉交易所名称后的交易对查找。
 func SplitAssetQuote(pair string) (asset string, quote string) {
+	_, pair = SplitVenuePair(pair)
+
 	// 从 pairAssetQuoteMap 映射中获取交易对名称对应的 AssetQuote 数据。
 	data := pairAssetQuoteMap[pair]
 
@@ -59,6 +66,42 @@ func SplitAssetQuote(pair string) (asset string, quote string) {
 	return data.Asset, data.Quote
 }
 
+// PairsByQuote 返回内置交易对列表中报价资产为quote的所有交易对，按名称排序。
+// 例如 PairsByQuote("USDT") 返回所有USDT交易对，用于批量下载等需要遍历交易对的场景。
+func PairsByQuote(quote string) []string {
+	var result []string
+	for pair, info := range pairAssetQuoteMap {
+		if info.Quote == quote {
+			result = append(result, pair)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// pairsByQuote 返回交易所交易对信息中报价资产为quote的所有交易对，按名称排序。
+func pairsByQuote(assetsInfo map[string]model.AssetInfo, quote string) []string {
+	var result []string
+	for pair, info := range assetsInfo {
+		if info.QuoteAsset == quote {
+			result = append(result, pair)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// VenueSeparator 分隔交易所名称和交易对，策略可以用 "okx:BTCUSDT" 指定交易对在哪个交易所交易，见Router。
+const VenueSeparator = ":"
+
+// SplitVenuePair 将 "okx:BTCUSDT" 拆分为交易所名称和交易对，没有指定交易所时venue为空。
+func SplitVenuePair(pair string) (venue, symbol string) {
+	if index := strings.Index(pair, VenueSeparator); index > 0 {
+		return pair[:index], pair[index+len(VenueSeparator):]
+	}
+	return "", pair
+}
+
 // updateParisFile 更新本地的 pairs.json 文件，以包含最新的交易对数据。
 func updateParisFile() error {
 	// 创建一个新的Binance客户端实例，用于访问API。此处"API ", "Secret "没有API密钥和秘密提供给客户端。
@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
 )
 
 func TestSplitAssetQuote(t *testing.T) {
@@ -16,6 +18,7 @@ func TestSplitAssetQuote(t *testing.T) {
 		{"ETHBTC", "ETH", "BTC"},
 		{"BTCBUSD", "BTC", "BUSD"},
 		{"1000SHIBBUSD", "1000SHIB", "BUSD"},
+		{"okx:BTCUSDT", "BTC", "USDT"},
 	}
 
 	for _, tc := range tt {
@@ -27,6 +30,35 @@ func TestSplitAssetQuote(t *testing.T) {
 	}
 }
 
+func TestSplitVenuePair(t *testing.T) {
+	venue, pair := SplitVenuePair("okx:BTCUSDT")
+	require.Equal(t, "okx", venue)
+	require.Equal(t, "BTCUSDT", pair)
+
+	venue, pair = SplitVenuePair("BTCUSDT")
+	require.Empty(t, venue)
+	require.Equal(t, "BTCUSDT", pair)
+}
+
+func TestPairsByQuote(t *testing.T) {
+	pairs := PairsByQuote("USDT")
+	require.Contains(t, pairs, "BTCUSDT")
+	require.NotContains(t, pairs, "ETHBTC")
+	require.IsIncreasing(t, pairs)
+	require.Empty(t, PairsByQuote("UNKNOWN"))
+}
+
+func TestBinanceFuture_PairsByQuote(t *testing.T) {
+	// 合约市场的交易对列表来自交易所，不包含只在现货市场存在的交易对
+	future := &BinanceFuture{assetsInfo: map[string]model.AssetInfo{
+		"BTCUSDT":      {BaseAsset: "BTC", QuoteAsset: "USDT"},
+		"1000PEPEUSDT": {BaseAsset: "1000PEPE", QuoteAsset: "USDT"},
+		"BTCBUSD":      {BaseAsset: "BTC", QuoteAsset: "BUSD"},
+	}}
+	require.Equal(t, []string{"1000PEPEUSDT", "BTCUSDT"}, future.PairsByQuote("USDT"))
+	require.Empty(t, future.PairsByQuote("BTC"))
+}
+
 func TestUpdatePairFile(t *testing.T) {
 	t.Skip() // it is not a test, just utility function to update pairs list
 	err := updateParisFile()
@@ -2,7 +2,6 @@ package exchange
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"math"
 	"strings"
@@ -22,6 +21,13 @@ type assetInfo struct {
 	Lock float64 // 锁定数量，指因交易（如挂单）而暂时不可用的资产部分。
 }
 
+// fundsLock 记录挂单时validateFunds对资产做出的改变，订单取消或过期时据此原样退回。
+type fundsLock struct {
+	asset, quote         string
+	assetFree, assetLock float64
+	quoteFree, quoteLock float64
+}
+
 // AssetValue 结构体用于记录某一时刻资产的价值。
 type AssetValue struct {
 	Time  time.Time // 记录价值的时间点。
@@ -47,6 +53,8 @@ type PaperWallet struct {
 	fistCandle    map[string]model.Candle // 每个货币对的第一个蜡烛图数据，计算的是最初开盘的数据
 	assetValues   map[string][]AssetValue // assetValues存储的正是每个交易货币在不同时间点上的价格信息。
 	equityValues  []AssetValue            // 存储的信息包括了钱包或投资组合总价值随时间的任何变化，无论是盈利（赚）还是亏损（亏）
+	locks         map[int64]*fundsLock    // 未成交订单锁定的资金，键为订单的ExchangeID，OCO的两个订单共用一个记录
+	depth         OrderBookSource         // 订单簿数据源，配置后市价单和IOC/FOK限价单按订单簿深度成交
 }
 
 // AssetsInfo 方法接收一个货币对字符串（如"BTC/USD"）作为参数，并返回该货币对的相关资产信息。
@@ -97,6 +105,14 @@ func WithDataFeed(feeder service.Feeder) PaperWalletOption {
 	}
 }
 
+// WithPaperDepth 配置订单簿数据源（例如DepthFeed）。有订单簿时市价单和IOC/FOK限价单逐档吃单，按成交均价成交，
+// 超过订单簿深度的市价单按最差一档的价格成交；挂单仍然按K线的最高价和最低价判断是否成交。
+func WithPaperDepth(source OrderBookSource) PaperWalletOption {
+	return func(wallet *PaperWallet) {
+		wallet.depth = source
+	}
+}
+
 // NewPaperWallet 创建并初始化一个新的 PaperWallet 实例。 参数: ctx: 上下文，用于管理和取消长时间运行的操作。 baseCoin: 钱包的基础货币标识符，用于计价和资产评估。 options: 一个或多个配置选项，允许灵活地初始化钱包的不同方面。
 func NewPaperWallet(ctx context.Context, baseCoin string, options ...PaperWalletOption) *PaperWallet {
 	// 使用提供的参数和默认值初始化 PaperWallet 结构体。
@@ -112,6 +128,7 @@ func NewPaperWallet(ctx context.Context, baseCoin string, options ...PaperWallet
 		volume:        make(map[string]float64),      // 初始化交易量映射
 		assetValues:   make(map[string][]AssetValue), // 初始化资产价值记录
 		equityValues:  make([]AssetValue, 0),         // 初始化总权益价值记录
+		locks:         make(map[int64]*fundsLock),    // 初始化挂单锁定资金记录
 	}
 
 	// 应用所有配置选项到钱包实例。
@@ -406,6 +423,59 @@ func (p *PaperWallet) validateFunds(side model.SideType, pair string, amount, va
 	return nil
 }
 
+// lockFunds 为挂单锁定资金，并记录锁定前后资产的变化，以便订单取消或过期时退回。
+func (p *PaperWallet) lockFunds(side model.SideType, pair string, amount, value float64) (*fundsLock, error) {
+	asset, quote := SplitAssetQuote(pair)
+	before := func(name string) assetInfo {
+		if info, ok := p.assets[name]; ok {
+			return *info
+		}
+		return assetInfo{}
+	}
+	assetBefore, quoteBefore := before(asset), before(quote)
+
+	if err := p.validateFunds(side, pair, amount, value, false); err != nil {
+		return nil, err
+	}
+
+	return &fundsLock{
+		asset:     asset,
+		quote:     quote,
+		assetFree: p.assets[asset].Free - assetBefore.Free,
+		assetLock: p.assets[asset].Lock - assetBefore.Lock,
+		quoteFree: p.assets[quote].Free - quoteBefore.Free,
+		quoteLock: p.assets[quote].Lock - quoteBefore.Lock,
+	}, nil
+}
+
+// releaseFunds 退回订单锁定的资金，OCO订单的所有关联订单一并清除记录。
+func (p *PaperWallet) releaseFunds(exchangeID int64) {
+	lock, ok := p.locks[exchangeID]
+	if !ok {
+		return
+	}
+
+	p.assets[lock.asset].Free -= lock.assetFree
+	p.assets[lock.asset].Lock -= lock.assetLock
+	p.assets[lock.quote].Free -= lock.quoteFree
+	p.assets[lock.quote].Lock -= lock.quoteLock
+	p.forgetLock(lock)
+}
+
+// forgetLock 删除锁定记录，订单成交时资金已经被消耗，不需要退回。
+func (p *PaperWallet) forgetLock(lock *fundsLock) {
+	for id, item := range p.locks {
+		if item == lock {
+			delete(p.locks, id)
+		}
+	}
+}
+
+// HandlesExpiry 表示PaperWallet按K线时间自行处理订单过期，Controller不需要再按本地时间过期订单。
+func (p *PaperWallet) HandlesExpiry() bool {
+	return true
+}
+
 // updateAveragePrice 根据交易的方向、货币对、数量和价值更新平均价格。
 func (p *PaperWallet) updateAveragePrice(side model.SideType, pair string, amount, value float64) {
 	//actualQty 表示交易前的实际持仓数量，初始化为0.0 意味着在交易之前没有任何持仓。随着交易的执行，actualQty 会根据交易的方向和数量进行更新，以反映交易执行后的最新持仓数量。
@@ -519,6 +589,14 @@ func (p *PaperWallet) OnCandle(candle model.Candle) {
 			continue
 		}
 
+		// 到达过期时间仍未成交的订单置为过期，并退回锁定的资金
+		if order.IsExpired(candle.Time) {
+			p.orders[i].Status = model.OrderStatusTypeExpired
+			p.orders[i].UpdatedAt = candle.Time
+			p.releaseFunds(order.ExchangeID)
+			continue
+		}
+
 		// 这段代码的作用是检查模拟交易钱包（PaperWallet）中是否已经记录了特定货币对（例如BTC/USDT）的交易量。如果没有（即之前没有对该货币对进行任何交易），则初始化该货币对的交易量为0 ，确保每个交易对都有一个交易量0
 		if _, ok := p.volume[candle.Pair]; !ok {
 			p.volume[candle.Pair] = 0
@@ -540,6 +618,10 @@ func (p *PaperWallet) OnCandle(candle model.Candle) {
 			p.orders[i].UpdatedAt = candle.Time
 			// 更新订单状态为 完全成交：订单所有数量已成交
 			p.orders[i].Status = model.OrderStatusTypeFilled
+			// 成交后锁定的资金已被消耗，删除记录
+			if lock, ok := p.locks[order.ExchangeID]; ok {
+				p.forgetLock(lock)
+			}
 
 			// 更新账户中的资产数量和平均价格。
 			p.updateAveragePrice(order.Side, order.Pair, order.Quantity, order.Price)
@@ -598,6 +680,10 @@ func (p *PaperWallet) OnCandle(candle model.Candle) {
 			p.orders[i].UpdatedAt = candle.Time
 			// 更新订单状态为已填充。
 			p.orders[i].Status = model.OrderStatusTypeFilled
+			// 成交后锁定的资金已被消耗，OCO的另一单也不需要再退回
+			if lock, ok := p.locks[order.ExchangeID]; ok {
+				p.forgetLock(lock)
+			}
 
 			// 更新账户中的资产数量和平均价格。
 			p.updateAveragePrice(order.Side, order.Pair, order.Quantity, orderPrice)
@@ -692,9 +778,38 @@ func (p *PaperWallet) Position(pair string) (asset, quote float64, err error) {
 	return assetBalance.Free + assetBalance.Lock, quoteBalance.Free + quoteBalance.Lock, nil
 }
 
+// PositionInfo 返回交易对在模拟钱包中的持仓详情。基础资产余额为负数时表示空头，开仓均价来自
+// avgLongPrice/avgShortPrice，标记价格为最后一根K线的收盘价。模拟钱包不使用杠杆，全部名义价值都作为保证金，
+// 所以多头不会被强平，空头在价格涨到开仓均价的两倍时亏完保证金。
+func (p *PaperWallet) PositionInfo(pair string) (model.PositionInfo, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	assetTick, _ := SplitAssetQuote(pair)
+	info, ok := p.assets[assetTick]
+	if !ok {
+		return model.NewPositionInfo(pair, 0), nil
+	}
+
+	position := model.NewPositionInfo(pair, info.Free+info.Lock)
+	switch position.Side {
+	case model.PositionSideTypeLong:
+		position.EntryPrice = p.avgLongPrice[pair]
+	case model.PositionSideTypeShort:
+		position.EntryPrice = p.avgShortPrice[pair]
+		position.LiquidationPrice = 2 * position.EntryPrice
+	}
+
+	position.MarkPrice = p.lastCandle[pair].Close
+	position.UnrealizedPnL = position.PnL(position.MarkPrice)
+	position.Margin = position.Quantity * position.EntryPrice
+	position.UpdatedAt = p.lastCandle[pair].Time
+	return position, nil
+}
+
 // 这个方法用于创建一个OCO（One Cancels the Other）订单，即一个订单成交后会取消另一个订单。方法参数包括订单方向（买入或卖出）、交易对、数量、价格、止损价和止损限价。
 func (p *PaperWallet) CreateOrderOCO(side model.SideType, pair string,
-	size, price, stop, stopLimit float64) ([]model.Order, error) {
+	size, price, stop, stopLimit float64, options ...model.OrderOption) ([]model.Order, error) {
 	// 锁定钱包，确保同时只有一个操作可以修改钱包的状态。
 	p.Lock()
 	defer p.Unlock()
@@ -705,7 +820,7 @@ func (p *PaperWallet) CreateOrderOCO(side model.SideType, pair string,
 	}
 
 	// 验证资金是否足够下单。
-	err := p.validateFunds(side, pair, size, price, false)
+	lock, err := p.lockFunds(side, pair, size, price)
 	if err != nil {
 		return nil, err
 	}
@@ -744,8 +859,18 @@ func (p *PaperWallet) CreateOrderOCO(side model.SideType, pair string,
 		RefPrice:   p.lastCandle[pair].Close, //止损单的参考价值的是蜡烛图最新更新的收盘价
 	}
 
+	// 应用订单参数，止损单的客户端订单ID加上"-stop"后缀以便区分
+	params := model.NewOrderParams(options...)
+	params.Apply(&limitMaker)
+	if params.ClientOrderID != "" {
+		params.ClientOrderID += "-stop"
+	}
+	params.Apply(&stopOrder)
+
 	// 将生成的订单添加到钱包的订单列表中。
 	p.orders = append(p.orders, limitMaker, stopOrder)
+	p.locks[limitMaker.ExchangeID] = lock
+	p.locks[stopOrder.ExchangeID] = lock
 
 	// 返回生成的两个订单。
 	return []model.Order{limitMaker, stopOrder}, nil
@@ -753,7 +878,7 @@ func (p *PaperWallet) CreateOrderOCO(side model.SideType, pair string,
 
 // CreateOrderLimit 创建限价订单。side 表示订单的交易方向（买入或卖出）。pair 表示交易对。size 表示订单的数量。limit 表示订单的限价。返回创建的订单和可能出现的错误。
 func (p *PaperWallet) CreateOrderLimit(side model.SideType, pair string,
-	size float64, limit float64) (model.Order, error) {
+	size float64, limit float64, options ...model.OrderOption) (model.Order, error) {
 
 	// 在进行操作前加锁以确保数据一致性，操作完成后解锁。
 	p.Lock()
@@ -764,8 +889,23 @@ func (p *PaperWallet) CreateOrderLimit(side model.SideType, pair string,
 		return model.Order{}, ErrInvalidQuantity
 	}
 
+	params := model.NewOrderParams(options...)
+
+	crossed := p.isCrossed(side, pair, limit)
+
+	switch params.TimeInForce {
+	case model.TimeInForceGTX:
+		// 只做挂单的订单不能立即成交
+		if crossed {
+			return model.Order{}, &OrderError{Err: ErrOrderWouldMatch, Pair: pair, Quantity: size}
+		}
+	case model.TimeInForceIOC, model.TimeInForceFOK:
+		// 没有订单簿时IOC和FOK都是要么按当前价格全部成交，要么直接过期
+		return p.createOrderImmediate(side, pair, size, limit, crossed, params)
+	}
+
 	// 验证资金是否充足以进行交易。
-	err := p.validateFunds(side, pair, size, limit, false)
+	lock, err := p.lockFunds(side, pair, size, limit)
 	if err != nil {
 		return model.Order{}, err
 	}
@@ -782,26 +922,160 @@ func (p *PaperWallet) CreateOrderLimit(side model.SideType, pair string,
 		Price:      limit,
 		Quantity:   size,
 	}
+	params.Apply(&order)
 
 	// 将新订单添加到订单列表中。
 	p.orders = append(p.orders, order)
+	p.locks[order.ExchangeID] = lock
 
 	// 返回创建的订单和没有错误。
 	return order, nil
 }
 
+// isCrossed 判断限价单在当前价格下是否会立即成交，有订单簿时与对手方的最优价格比较，没有价格数据时视为不会成交。
+func (p *PaperWallet) isCrossed(side model.SideType, pair string, limit float64) bool {
+	if book, ok := p.orderBook(pair); ok {
+		bid, _ := book.BestBid()
+		ask, _ := book.BestAsk()
+		return (side == model.SideTypeBuy && limit >= ask.Price) ||
+			(side == model.SideTypeSell && limit <= bid.Price)
+	}
+
+	candle, ok := p.lastCandle[pair]
+	return ok && ((side == model.SideTypeBuy && limit >= candle.Close) ||
+		(side == model.SideTypeSell && limit <= candle.Close))
+}
+
+// ReplaceOrder 修改挂单的价格和数量。模拟钱包中改单是原子操作：先退回原订单锁定的资金，
+// 再为新订单锁定资金，资金不足时恢复原订单的锁定并返回错误，原订单保持不变。
+// 成功时原订单被置为CANCELED，返回的新订单通过ReplacedID关联原订单。
+func (p *PaperWallet) ReplaceOrder(order model.Order, price, quantity float64) (model.Order, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if quantity <= 0 || price <= 0 {
+		return model.Order{}, ErrInvalidQuantity
+	}
+
+	index := -1
+	for i, o := range p.orders {
+		if o.ExchangeID == order.ExchangeID {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return model.Order{}, ErrOrderNotFound
+	}
+
+	original := p.orders[index]
+	if !original.IsReplaceable() {
+		return model.Order{}, &OrderError{Err: ErrNotReplaceable, Pair: original.Pair, Quantity: quantity}
+	}
+
+	params := model.NewOrderParams(original.ReplaceOptions()...)
+	if params.TimeInForce == model.TimeInForceGTX && p.isCrossed(original.Side, original.Pair, price) {
+		return model.Order{}, &OrderError{Err: ErrOrderWouldMatch, Pair: original.Pair, Quantity: quantity}
+	}
+
+	// 先退回原订单的资金，新订单可以复用这部分资金
+	p.releaseFunds(original.ExchangeID)
+	lock, err := p.lockFunds(original.Side, original.Pair, quantity, price)
+	if err != nil {
+		// 资金不足，恢复原订单的锁定，相当于改单从未发生
+		restore, restoreErr := p.lockFunds(original.Side, original.Pair, original.Quantity, original.Price)
+		if restoreErr != nil {
+			log.Errorf("paperwallet/replace: failed to restore funds of order %d: %v", original.ExchangeID, restoreErr)
+		} else {
+			p.locks[original.ExchangeID] = restore
+		}
+		return model.Order{}, err
+	}
+
+	now := p.lastCandle[original.Pair].Time
+	p.orders[index].Status = model.OrderStatusTypeCanceled
+	p.orders[index].UpdatedAt = now
+
+	replacement := model.Order{
+		ExchangeID: p.ID(),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		Pair:       original.Pair,
+		Side:       original.Side,
+		Type:       original.Type,
+		Status:     model.OrderStatusTypeNew,
+		Price:      price,
+		Quantity:   quantity,
+		ReplacedID: &original.ExchangeID,
+	}
+	params.Apply(&replacement)
+
+	p.orders = append(p.orders, replacement)
+	p.locks[replacement.ExchangeID] = lock
+	return replacement, nil
+}
+
+// createOrderImmediate 处理IOC/FOK限价单：能成交时按当前收盘价（不差于限价）立即成交，否则订单直接过期。
+// 有订单簿时只吃不差于限价的档位：FOK不能全部成交时过期，IOC成交可以成交的部分，订单数量为实际成交数量。
+func (p *PaperWallet) createOrderImmediate(side model.SideType, pair string, size, limit float64,
+	crossed bool, params model.OrderParams) (model.Order, error) {
+
+	price, filled := p.lastCandle[pair].Close, size
+	if book, ok := p.orderBook(pair); ok && crossed {
+		price, filled = book.Fill(side, size, limit)
+		crossed = filled > 0 && (filled >= size || params.TimeInForce == model.TimeInForceIOC)
+	}
+
+	if !crossed {
+		order := model.Order{
+			ExchangeID: p.ID(),
+			CreatedAt:  p.lastCandle[pair].Time,
+			UpdatedAt:  p.lastCandle[pair].Time,
+			Pair:       pair,
+			Side:       side,
+			Type:       model.OrderTypeLimit,
+			Status:     model.OrderStatusTypeExpired,
+			Price:      limit,
+			Quantity:   size,
+		}
+		params.Apply(&order)
+		p.orders = append(p.orders, order)
+		return order, nil
+	}
+
+	order, err := p.createOrderFilled(side, pair, filled, price)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	order.Type = model.OrderTypeLimit
+	params.Apply(&order)
+	p.orders[len(p.orders)-1] = order
+	return order, nil
+}
+
 // CreateOrderMarket 创建市价订单。
-func (p *PaperWallet) CreateOrderMarket(side model.SideType, pair string, size float64) (model.Order, error) {
+func (p *PaperWallet) CreateOrderMarket(side model.SideType, pair string, size float64,
+	options ...model.OrderOption) (model.Order, error) {
 	// 在进行操作前加锁以确保数据一致性，操作完成后解锁。
 	p.Lock()
 	defer p.Unlock()
 
 	// 调用 createOrderMarket 方法创建市价订单并返回结果。
-	return p.createOrderMarket(side, pair, size)
+	order, err := p.createOrderMarket(side, pair, size)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	// 市价单立即成交，只需要记录客户端订单ID
+	model.NewOrderParams(options...).Apply(&order)
+	p.orders[len(p.orders)-1] = order
+	return order, nil
 }
 
 // CreateOrderStop 创建止损限价订单。
-func (p *PaperWallet) CreateOrderStop(pair string, size float64, limit float64) (model.Order, error) {
+func (p *PaperWallet) CreateOrderStop(pair string, size float64, limit float64,
+	options ...model.OrderOption) (model.Order, error) {
 	// 在进行操作前加锁以确保数据一致性，操作完成后解锁。
 	p.Lock()
 	defer p.Unlock()
@@ -812,7 +1086,7 @@ func (p *PaperWallet) CreateOrderStop(pair string, size float64, limit float64)
 	}
 
 	// 验证资金是否足够下单。
-	err := p.validateFunds(model.SideTypeSell, pair, size, limit, false)
+	lock, err := p.lockFunds(model.SideTypeSell, pair, size, limit)
 	if err != nil {
 		return model.Order{}, err
 	}
@@ -830,11 +1104,70 @@ func (p *PaperWallet) CreateOrderStop(pair string, size float64, limit float64)
 		Stop:       &limit,
 		Quantity:   size,
 	}
+	model.NewOrderParams(options...).Apply(&order)
+
 	// 将订单添加到钱包中的订单列表中。
 	p.orders = append(p.orders, order)
+	p.locks[order.ExchangeID] = lock
 	return order, nil
 }
 
+// orderBook 返回交易对当前的订单簿，没有配置订单簿数据源或订单簿任何一边为空时返回false。
+func (p *PaperWallet) orderBook(pair string) (model.OrderBook, bool) {
+	if p.depth == nil {
+		return model.OrderBook{}, false
+	}
+	book, ok := p.depth.OrderBook(pair)
+	if !ok || len(book.Bids) == 0 || len(book.Asks) == 0 {
+		return model.OrderBook{}, false
+	}
+	return book, true
+}
+
+// marketPrice 返回数量为size的市价单的成交均价。有订单簿时逐档吃单，超过订单簿深度的部分按最差一档的价格成交，
+// 否则按最后一根K线的收盘价成交。
+func (p *PaperWallet) marketPrice(side model.SideType, pair string, size float64) float64 {
+	book, ok := p.orderBook(pair)
+	if !ok {
+		return p.lastCandle[pair].Close
+	}
+
+	price, filled := book.Fill(side, size, 0)
+	if filled < size {
+		levels := book.Asks
+		if side == model.SideTypeSell {
+			levels = book.Bids
+		}
+		worst := levels[len(levels)-1].Price
+		price = (price*filled + worst*(size-filled)) / size
+	}
+	return price
+}
+
+// marketQuantity 返回花费（或换得）quote数量的计价货币对应的基础货币数量，有订单簿时逐档计算，规则与marketPrice相同。
+func (p *PaperWallet) marketQuantity(side model.SideType, pair string, quote float64) float64 {
+	book, ok := p.orderBook(pair)
+	if !ok {
+		return quote / p.lastCandle[pair].Close
+	}
+
+	levels := book.Asks
+	if side == model.SideTypeSell {
+		levels = book.Bids
+	}
+
+	var quantity float64
+	for _, level := range levels {
+		value := level.Price * level.Quantity
+		if value >= quote {
+			return quantity + quote/level.Price
+		}
+		quantity += level.Quantity
+		quote -= value
+	}
+	return quantity + quote/levels[len(levels)-1].Price
+}
+
 // createOrderMarket 创建市价订单。
 func (p *PaperWallet) createOrderMarket(side model.SideType, pair string, size float64) (model.Order, error) {
 	// 如果订单数量为零，则返回错误。
@@ -842,8 +1175,13 @@ func (p *PaperWallet) createOrderMarket(side model.SideType, pair string, size f
 		return model.Order{}, ErrInvalidQuantity
 	}
 
+	return p.createOrderFilled(side, pair, size, p.marketPrice(side, pair, size))
+}
+
+// createOrderFilled 按给定的成交均价创建一个已经全部成交的订单。
+func (p *PaperWallet) createOrderFilled(side model.SideType, pair string, size, price float64) (model.Order, error) {
 	// 验证资金是否足够下单。
-	err := p.validateFunds(side, pair, size, p.lastCandle[pair].Close, true)
+	err := p.validateFunds(side, pair, size, price, true)
 	if err != nil {
 		return model.Order{}, err
 	}
@@ -853,8 +1191,8 @@ func (p *PaperWallet) createOrderMarket(side model.SideType, pair string, size f
 		p.volume[pair] = 0
 	}
 
-	// 更新交易对的成交量。 = 原成交量+ 交易对最新蜡烛图的收盘价*交易对订单数量
-	p.volume[pair] += p.lastCandle[pair].Close * size
+	// 更新交易对的成交量。 = 原成交量+ 成交价格*交易对订单数量
+	p.volume[pair] += price * size
 
 	// 创建市价订单。
 	order := model.Order{
@@ -865,7 +1203,7 @@ func (p *PaperWallet) createOrderMarket(side model.SideType, pair string, size f
 		Side:       side,
 		Type:       model.OrderTypeMarket,
 		Status:     model.OrderStatusTypeFilled,
-		Price:      p.lastCandle[pair].Close,
+		Price:      price,
 		Quantity:   size,
 	}
 
@@ -877,7 +1215,8 @@ func (p *PaperWallet) createOrderMarket(side model.SideType, pair string, size f
 
 // CreateOrderMarketQuote 创建一个以报价货币数量为基准的市价订单
 // 在这个CreateOrderMarketQuote方法内部先把报价资产(如USDT)总数转化中基础资产(如btc)总数，然后调用createOrderMarket创建市场订单
-func (p *PaperWallet) CreateOrderMarketQuote(side model.SideType, pair string, quoteQuantity float64) (model.Order, error) {
+func (p *PaperWallet) CreateOrderMarketQuote(side model.SideType, pair string, quoteQuantity float64,
+	options ...model.OrderOption) (model.Order, error) {
 	// 锁定钱包以确保线程安全，函数结束时解锁。
 	p.Lock()
 	defer p.Unlock()
@@ -885,13 +1224,20 @@ func (p *PaperWallet) CreateOrderMarketQuote(side model.SideType, pair string, q
 	// 获取交易对的资产信息。
 	info := p.AssetsInfo(pair)
 
-	// 计算基础货币的数量，使用普通货币的数量除以当前蜡烛图的收盘价，并根据交易对的最小交易量和基础货币精度进行取整。common.AmountToLotSize 规范货币的规则比如最小精度等
+	// 计算基础货币的数量，使用普通货币的数量除以当前蜡烛图的收盘价（有订单簿时逐档计算），并根据交易对的最小交易量和基础货币精度进行取整。common.AmountToLotSize 规范货币的规则比如最小精度等
 	//如1个USDT购买比特币（BTC），当前蜡烛图的BTC/USDT的收盘价为50 基础资产数量 = 1 USDT / 50 USDT/BTC = 0.02 BTC
 	//quantity 是指购买基础资产的数量，经过 common.AmountToLotSize 函数处理后，确保符合所需的最小交易量要求和基础资产的精度要求。
-	quantity := common.AmountToLotSize(info.StepSize, info.BaseAssetPrecision, quoteQuantity/p.lastCandle[pair].Close)
+	quantity := common.AmountToLotSize(info.StepSize, info.BaseAssetPrecision, p.marketQuantity(side, pair, quoteQuantity))
 
 	// 调用内部函数创建市价订单。
-	return p.createOrderMarket(side, pair, quantity)
+	order, err := p.createOrderMarket(side, pair, quantity)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	model.NewOrderParams(options...).Apply(&order)
+	p.orders[len(p.orders)-1] = order
+	return order, nil
 }
 
 // 这个方法用于取消指定的订单。
@@ -900,9 +1246,19 @@ func (p *PaperWallet) Cancel(order model.Order) error {
 	p.Lock()
 	defer p.Unlock()
 
+	// 与交易所一致，取消OCO中的一个订单时同组的订单一起取消
+	sameGroup := func(o model.Order) bool {
+		return order.GroupID != nil && o.GroupID != nil && *o.GroupID == *order.GroupID &&
+			o.Status == model.OrderStatusTypeNew
+	}
+
 	// 遍历钱包中的订单列表，寻找与传入订单相同交易所ID的订单，并将其状态设为已取消。
 	for i, o := range p.orders {
-		if o.ExchangeID == order.ExchangeID {
+		if o.ExchangeID == order.ExchangeID || sameGroup(o) {
+			// 只有未成交的订单才退回锁定的资金
+			if o.Status == model.OrderStatusTypeNew {
+				p.releaseFunds(o.ExchangeID)
+			}
 			p.orders[i].Status = model.OrderStatusTypeCanceled
 		}
 	}
@@ -918,7 +1274,25 @@ func (p *PaperWallet) Order(_ string, id int64) (model.Order, error) {
 		}
 	}
 	// 如果未找到匹配的订单，则返回一个空订单和相应的错误信息。
-	return model.Order{}, errors.New("order not found")
+	return model.Order{}, ErrOrderNotFound
+}
+
+// Orders 返回指定交易对最近的limit个订单，按创建顺序排列，limit小于等于0时返回全部订单。
+func (p *PaperWallet) Orders(pair string, limit int) ([]model.Order, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	orders := make([]model.Order, 0)
+	for _, order := range p.orders {
+		if order.Pair == pair {
+			orders = append(orders, order)
+		}
+	}
+
+	if limit > 0 && len(orders) > limit {
+		orders = orders[len(orders)-limit:]
+	}
+	return orders, nil
 }
 
 // CandlesByPeriod 用于获取指定时间段内给定货币对的K线（蜡烛图）数据。
@@ -935,6 +1309,17 @@ func (p *PaperWallet) CandlesByLimit(ctx context.Context, pair, period string, l
 	return p.feeder.CandlesByLimit(ctx, pair, period, limit)
 }
 
+// CandleCount 返回数据源中交易对在timeframe周期的K线数量，数据源无法提前知道总数（如流式数据源）时返回false。
+func (p *PaperWallet) CandleCount(pair, timeframe string) (int, bool) {
+	counter, ok := p.feeder.(interface {
+		CandleCount(pair, timeframe string) (int, bool)
+	})
+	if !ok {
+		return 0, false
+	}
+	return counter.CandleCount(pair, timeframe)
+}
+
 // CandlesSubscription 创建一个实时订阅，用于获取指定货币对和时间框架的K线数据。
 func (p *PaperWallet) CandlesSubscription(ctx context.Context, pair, timeframe string) (chan model.Candle, chan error) {
 	// 通过调用PaperWallet实例的feeder属性的CandlesSubscription方法来实现订阅。
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/rodrigo-brito/ninjabot/model"
@@ -191,6 +192,81 @@ func TestPaperWallet_OrderLimit(t *testing.T) {
 	})
 }
 
+func TestPaperWallet_TimeInForce(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("post only", func(t *testing.T) {
+		wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 100))
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Time: start, Close: 50})
+
+		_, err := wallet.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 60, model.WithPostOnly())
+		require.ErrorIs(t, err, ErrOrderWouldMatch)
+		require.Equal(t, 100.0, wallet.assets["USDT"].Free)
+
+		order, err := wallet.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 40, model.WithPostOnly())
+		require.NoError(t, err)
+		require.Equal(t, model.TimeInForceGTX, order.TimeInForce)
+		require.Equal(t, model.OrderStatusTypeNew, order.Status)
+	})
+
+	t.Run("immediate or cancel", func(t *testing.T) {
+		wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 100))
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Time: start, Close: 50})
+
+		order, err := wallet.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 40,
+			model.WithTimeInForce(model.TimeInForceIOC))
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeExpired, order.Status)
+		require.Equal(t, 100.0, wallet.assets["USDT"].Free)
+		require.Equal(t, 0.0, wallet.assets["USDT"].Lock)
+
+		order, err = wallet.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 60,
+			model.WithTimeInForce(model.TimeInForceFOK), model.WithClientOrderID("fok"))
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeFilled, order.Status)
+		require.Equal(t, model.OrderTypeLimit, order.Type)
+		require.Equal(t, "fok", order.ClientOrderID)
+		require.Equal(t, 50.0, order.Price)
+		require.Equal(t, 50.0, wallet.assets["USDT"].Free)
+		require.Equal(t, 1.0, wallet.assets["BTC"].Free)
+	})
+
+	t.Run("good till time", func(t *testing.T) {
+		wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 100))
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Time: start, Close: 50})
+
+		order, err := wallet.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 40,
+			model.WithExpireAt(start.Add(time.Hour)))
+		require.NoError(t, err)
+		require.Equal(t, 60.0, wallet.assets["USDT"].Free)
+		require.Equal(t, 40.0, wallet.assets["USDT"].Lock)
+
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Time: start.Add(30 * time.Minute), Close: 45})
+		order, err = wallet.Order("BTCUSDT", order.ExchangeID)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeNew, order.Status)
+
+		// expired before the price reached the limit, funds are released
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Time: start.Add(time.Hour), Close: 30})
+		order, err = wallet.Order("BTCUSDT", order.ExchangeID)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeExpired, order.Status)
+		require.Equal(t, 100.0, wallet.assets["USDT"].Free)
+		require.Equal(t, 0.0, wallet.assets["USDT"].Lock)
+	})
+
+	t.Run("cancel releases funds", func(t *testing.T) {
+		wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 100))
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Time: start, Close: 50})
+
+		order, err := wallet.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 40)
+		require.NoError(t, err)
+		require.NoError(t, wallet.Cancel(order))
+		require.Equal(t, 100.0, wallet.assets["USDT"].Free)
+		require.Equal(t, 0.0, wallet.assets["USDT"].Lock)
+	})
+}
+
 func TestPaperWallet_OrderMarket(t *testing.T) {
 	wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 100))
 	wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Close: 50})
@@ -552,3 +628,182 @@ func TestUpdateAveragePrice(t *testing.T) {
 	})
 
 }
+
+func TestPaperWallet_ReplaceOrder(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("replace price and quantity", func(t *testing.T) {
+		wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 100))
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Time: start, Close: 50})
+
+		order, err := wallet.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 40, model.WithPostOnly())
+		require.NoError(t, err)
+		require.Equal(t, 60.0, wallet.assets["USDT"].Free)
+
+		replacement, err := wallet.ReplaceOrder(order, 45, 2)
+		require.NoError(t, err)
+		require.NotEqual(t, order.ExchangeID, replacement.ExchangeID)
+		require.Equal(t, order.ExchangeID, *replacement.ReplacedID)
+		require.Equal(t, model.OrderStatusTypeNew, replacement.Status)
+		require.Equal(t, model.TimeInForceGTX, replacement.TimeInForce)
+		require.Equal(t, 45.0, replacement.Price)
+		require.Equal(t, 2.0, replacement.Quantity)
+		require.Equal(t, 10.0, wallet.assets["USDT"].Free)
+		require.Equal(t, 90.0, wallet.assets["USDT"].Lock)
+
+		original, err := wallet.Order("BTCUSDT", order.ExchangeID)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeCanceled, original.Status)
+
+		// 原订单已经被替换，不能再次改单
+		_, err = wallet.ReplaceOrder(original, 45, 1)
+		require.ErrorIs(t, err, ErrNotReplaceable)
+
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Time: start.Add(time.Minute), Close: 44, Low: 44})
+		filled, err := wallet.Order("BTCUSDT", replacement.ExchangeID)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeFilled, filled.Status)
+		require.Equal(t, 2.0, wallet.assets["BTC"].Free)
+		require.Equal(t, 10.0, wallet.assets["USDT"].Free)
+	})
+
+	t.Run("insufficient funds keeps original", func(t *testing.T) {
+		wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 100))
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Time: start, Close: 50})
+
+		order, err := wallet.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 40)
+		require.NoError(t, err)
+
+		_, err = wallet.ReplaceOrder(order, 40, 3)
+		require.ErrorIs(t, err, ErrInsufficientFunds)
+
+		original, err := wallet.Order("BTCUSDT", order.ExchangeID)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeNew, original.Status)
+		require.Equal(t, 60.0, wallet.assets["USDT"].Free)
+		require.Equal(t, 40.0, wallet.assets["USDT"].Lock)
+
+		// 原订单的资金锁定已经恢复，撤单后全部退回
+		require.NoError(t, wallet.Cancel(original))
+		require.Equal(t, 100.0, wallet.assets["USDT"].Free)
+		require.Equal(t, 0.0, wallet.assets["USDT"].Lock)
+	})
+
+	t.Run("post only would match", func(t *testing.T) {
+		wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 100))
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Time: start, Close: 50})
+
+		order, err := wallet.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 40, model.WithPostOnly())
+		require.NoError(t, err)
+
+		_, err = wallet.ReplaceOrder(order, 55, 1)
+		require.ErrorIs(t, err, ErrOrderWouldMatch)
+
+		_, err = wallet.ReplaceOrder(model.Order{ExchangeID: 999}, 40, 1)
+		require.ErrorIs(t, err, ErrOrderNotFound)
+	})
+}
+
+func TestPaperWallet_PositionInfo(t *testing.T) {
+	t.Run("flat", func(t *testing.T) {
+		wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 1000))
+		position, err := wallet.PositionInfo("BTCUSDT")
+		require.NoError(t, err)
+		require.False(t, position.Open())
+		require.Equal(t, "BTCUSDT", position.Pair)
+	})
+
+	t.Run("long", func(t *testing.T) {
+		wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 1000))
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Close: 100})
+		_, err := wallet.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 2)
+		require.NoError(t, err)
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Close: 110, High: 110, Low: 100})
+
+		position, err := wallet.PositionInfo("BTCUSDT")
+		require.NoError(t, err)
+		require.Equal(t, model.PositionSideTypeLong, position.Side)
+		require.Equal(t, 2.0, position.Quantity)
+		require.Equal(t, 100.0, position.EntryPrice)
+		require.Equal(t, 110.0, position.MarkPrice)
+		require.Equal(t, 20.0, position.UnrealizedPnL)
+		require.Equal(t, 200.0, position.Margin)
+		require.Equal(t, 0.0, position.LiquidationPrice)
+	})
+
+	t.Run("short", func(t *testing.T) {
+		wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 1000))
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Close: 100})
+		_, err := wallet.CreateOrderMarket(model.SideTypeSell, "BTCUSDT", 1)
+		require.NoError(t, err)
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Close: 110, High: 110, Low: 100})
+
+		position, err := wallet.PositionInfo("BTCUSDT")
+		require.NoError(t, err)
+		require.Equal(t, model.PositionSideTypeShort, position.Side)
+		require.Equal(t, 1.0, position.Quantity)
+		require.Equal(t, -1.0, position.Size())
+		require.Equal(t, -10.0, position.UnrealizedPnL)
+		require.Equal(t, 200.0, position.LiquidationPrice)
+	})
+}
+
+func TestPaperWallet_Depth(t *testing.T) {
+	book := model.NewOrderBook("BTCUSDT")
+	require.NoError(t, book.Apply(model.DepthUpdate{
+		Bids:     []model.PriceLevel{{Price: 99, Quantity: 1}, {Price: 98, Quantity: 1}},
+		Asks:     []model.PriceLevel{{Price: 101, Quantity: 1}, {Price: 102, Quantity: 1}},
+		Snapshot: true,
+	}))
+	newWallet := func() *PaperWallet {
+		wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 10000),
+			WithPaperDepth(orderBooks{"BTCUSDT": *book}))
+		wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Time: time.Now(), Close: 100, Complete: true})
+		return wallet
+	}
+
+	t.Run("market", func(t *testing.T) {
+		wallet := newWallet()
+		order, err := wallet.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 2)
+		require.NoError(t, err)
+		assert.Equal(t, 101.5, order.Price)
+
+		// 超过订单簿深度的部分按最差一档成交
+		order, err = wallet.CreateOrderMarket(model.SideTypeSell, "BTCUSDT", 3)
+		require.NoError(t, err)
+		assert.InDelta(t, (99.0+98+98)/3, order.Price, 1e-9)
+
+		order, err = wallet.CreateOrderMarketQuote(model.SideTypeBuy, "BTCUSDT", 203)
+		require.NoError(t, err)
+		assert.InDelta(t, 2.0, order.Quantity, 1e-8)
+		assert.InDelta(t, 101.5, order.Price, 1e-6)
+	})
+
+	t.Run("immediate or cancel", func(t *testing.T) {
+		wallet := newWallet()
+		order, err := wallet.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 2, 101,
+			model.WithTimeInForce(model.TimeInForceIOC))
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeFilled, order.Status)
+		assert.Equal(t, 1.0, order.Quantity)
+		assert.Equal(t, 101.0, order.Price)
+
+		order, err = wallet.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 2, 101,
+			model.WithTimeInForce(model.TimeInForceFOK))
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeExpired, order.Status)
+
+		// 收盘价100，但订单簿的卖价101，限价100的买单不能成交
+		order, err = wallet.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 100,
+			model.WithTimeInForce(model.TimeInForceIOC))
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeExpired, order.Status)
+	})
+}
+
+type orderBooks map[string]model.OrderBook
+
+func (b orderBooks) OrderBook(pair string) (model.OrderBook, bool) {
+	book, ok := b[pair]
+	return book, ok
+}
@@ -0,0 +1,364 @@
+package exchange
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rodrigo-brito/ninjabot/tools/log"
+)
+
+/*
+RateLimiter 在客户端按交易所的限制控制REST请求，避免多交易对的机器人频繁轮询订单、查询账户时超过请求权重被封禁IP。
+每个请求按接口计算权重，下单请求同时计算下单次数，超过任意一个时间窗口的限制时请求会排队等待到窗口重置。
+响应中的 X-Mbx-Used-Weight-* 和 X-Mbx-Order-Count-* 是服务器统计的用量，同一个IP的其他程序的请求也计算在内，
+RateLimiter 会用服务器的用量更新本地的用量；收到429或者418时按 Retry-After 暂停所有请求。
+同一个IP的多个交易所实例（例如现货和期货）可以共享一个RateLimiter，也可以分别使用各自的默认限制。
+*/
+
+// RateLimit 一个时间窗口的请求限制。
+type RateLimit struct {
+	Interval time.Duration // Interval 时间窗口，按整点对齐，例如每分钟
+	Limit    int           // Limit 时间窗口内的请求权重或者下单次数上限
+}
+
+// RateEndpoint 一个接口的请求权重。
+type RateEndpoint struct {
+	Weight int  // Weight 请求权重
+	Order  bool // Order 是否计算下单次数
+}
+
+// RateUsage 一个时间窗口当前的用量。
+type RateUsage struct {
+	Name     string        // Name "weight"表示请求权重，"orders"表示下单次数
+	Interval time.Duration // Interval 时间窗口
+	Used     int           // Used 当前窗口已经使用的权重或者次数
+	Limit    int           // Limit 当前窗口的上限
+}
+
+// RateStats RateLimiter的统计信息。
+type RateStats struct {
+	Requests    int64         // Requests 发送的请求数量
+	Throttled   int64         // Throttled 需要等待的请求数量
+	Waited      time.Duration // Waited 所有请求等待的总时间
+	Rejected    int64         // Rejected 服务器返回429或者418的次数
+	BannedUntil time.Time     // BannedUntil 服务器要求暂停请求的截止时间
+}
+
+// rateWindow 一个按整点对齐的固定时间窗口。
+type rateWindow struct {
+	RateLimit
+	start time.Time // start 当前窗口的开始时间
+	used  int       // used 当前窗口已经使用的权重或者次数
+}
+
+// reset 窗口到期时重置用量。
+func (w *rateWindow) reset(now time.Time) {
+	if now.Sub(w.start) >= w.Interval {
+		w.start = now.Truncate(w.Interval)
+		w.used = 0
+	}
+}
+
+// delay 返回使用cost之前需要等待的时间，超过上限的单个请求在窗口为空时允许发送。
+func (w *rateWindow) delay(now time.Time, cost int) time.Duration {
+	w.reset(now)
+	if cost == 0 || w.used == 0 || w.used+cost <= w.Limit {
+		return 0
+	}
+	return w.start.Add(w.Interval).Sub(now)
+}
+
+// RateLimiter 按请求权重和下单次数限制REST请求，可以在多个客户端之间共享。
+type RateLimiter struct {
+	mtx           sync.Mutex
+	weights       []*rateWindow           // weights 请求权重的时间窗口
+	orders        []*rateWindow           // orders 下单次数的时间窗口
+	endpoints     map[string]RateEndpoint // endpoints 接口的请求权重，键为"方法 路径"或者"路径"
+	defaultWeight int                     // defaultWeight 没有配置的接口的请求权重
+	stats         RateStats               // stats 统计信息
+	now           func() time.Time        // now 当前时间，测试时可以替换
+}
+
+// RateLimiterOption 定义了一个函数类型，用于通过不同的配置选项来定制化RateLimiter实例。
+type RateLimiterOption func(*RateLimiter)
+
+// WithWeightLimit 添加一个请求权重的时间窗口，例如每分钟6000。
+func WithWeightLimit(interval time.Duration, limit int) RateLimiterOption {
+	return func(r *RateLimiter) {
+		r.weights = append(r.weights, &rateWindow{RateLimit: RateLimit{Interval: interval, Limit: limit}})
+	}
+}
+
+// WithOrderLimit 添加一个下单次数的时间窗口，例如每10秒50次。
+func WithOrderLimit(interval time.Duration, limit int) RateLimiterOption {
+	return func(r *RateLimiter) {
+		r.orders = append(r.orders, &rateWindow{RateLimit: RateLimit{Interval: interval, Limit: limit}})
+	}
+}
+
+// WithEndpointWeight 设置接口的请求权重，endpoint为"GET /api/v3/order"或者"/api/v3/order"，带方法的配置优先。
+func WithEndpointWeight(endpoint string, weight int, order bool) RateLimiterOption {
+	return func(r *RateLimiter) {
+		r.endpoints[endpoint] = RateEndpoint{Weight: weight, Order: order}
+	}
+}
+
+// WithDefaultWeight 设置没有配置的接口的请求权重，默认为1。
+func WithDefaultWeight(weight int) RateLimiterOption {
+	return func(r *RateLimiter) {
+		r.defaultWeight = weight
+	}
+}
+
+// NewRateLimiter 创建一个RateLimiter，没有添加时间窗口时不限制请求，只统计用量。
+func NewRateLimiter(options ...RateLimiterOption) *RateLimiter {
+	limiter := &RateLimiter{
+		endpoints:     make(map[string]RateEndpoint),
+		defaultWeight: 1,
+		now:           time.Now,
+	}
+	for _, option := range options {
+		option(limiter)
+	}
+	return limiter
+}
+
+// NewBinanceRateLimiter 创建币安现货的默认限制：每分钟6000权重，每10秒50次、每天160000次下单，
+// 常用接口的权重来自币安的接口文档，options可以覆盖默认配置。
+func NewBinanceRateLimiter(options ...RateLimiterOption) *RateLimiter {
+	return NewRateLimiter(append([]RateLimiterOption{
+		WithWeightLimit(time.Minute, 6000),
+		WithOrderLimit(10*time.Second, 50),
+		WithOrderLimit(24*time.Hour, 160000),
+		WithEndpointWeight("/api/v3/exchangeInfo", 20, false),
+		WithEndpointWeight("/api/v3/klines", 2, false),
+		WithEndpointWeight("/api/v3/account", 20, false),
+		WithEndpointWeight("GET /api/v3/order", 4, false),
+		WithEndpointWeight("POST /api/v3/order", 1, true),
+		WithEndpointWeight("POST /api/v3/order/oco", 1, true),
+		WithEndpointWeight("POST /api/v3/order/cancelReplace", 1, true),
+		WithEndpointWeight("/api/v3/openOrders", 6, false),
+		WithEndpointWeight("/api/v3/allOrders", 20, false),
+		WithEndpointWeight("/api/v3/orderList", 4, false),
+	}, options...)...)
+}
+
+// NewBinanceFutureRateLimiter 创建币安U本位合约的默认限制：每分钟2400权重，每10秒300次、每分钟1200次下单，
+// 常用接口的权重来自币安的接口文档，options可以覆盖默认配置。
+func NewBinanceFutureRateLimiter(options ...RateLimiterOption) *RateLimiter {
+	return NewRateLimiter(append([]RateLimiterOption{
+		WithWeightLimit(time.Minute, 2400),
+		WithOrderLimit(10*time.Second, 300),
+		WithOrderLimit(time.Minute, 1200),
+		WithEndpointWeight("/fapi/v1/exchangeInfo", 1, false),
+		WithEndpointWeight("/fapi/v1/klines", 5, false),
+		WithEndpointWeight("/fapi/v2/account", 5, false),
+		WithEndpointWeight("/fapi/v2/positionRisk", 5, false),
+		WithEndpointWeight("GET /fapi/v1/order", 1, false),
+		WithEndpointWeight("POST /fapi/v1/order", 1, true),
+		WithEndpointWeight("POST /fapi/v1/batchOrders", 5, true),
+		WithEndpointWeight("/fapi/v1/openOrders", 1, false),
+		WithEndpointWeight("/fapi/v1/allOrders", 5, false),
+	}, options...)...)
+}
+
+// endpoint 返回请求的权重和是否计算下单次数。
+func (r *RateLimiter) endpoint(method, path string) RateEndpoint {
+	if endpoint, ok := r.endpoints[method+" "+path]; ok {
+		return endpoint
+	}
+	if endpoint, ok := r.endpoints[path]; ok {
+		return endpoint
+	}
+	return RateEndpoint{Weight: r.defaultWeight}
+}
+
+// Wait 等待到可以发送权重为weight的请求，order为true时同时计算一次下单，ctx取消时返回ctx的错误。
+func (r *RateLimiter) Wait(ctx context.Context, weight int, order bool) error {
+	var waited time.Duration
+	for {
+		r.mtx.Lock()
+		now := r.now()
+		delay := r.stats.BannedUntil.Sub(now)
+		for _, window := range r.weights {
+			if d := window.delay(now, weight); d > delay {
+				delay = d
+			}
+		}
+		if order {
+			for _, window := range r.orders {
+				if d := window.delay(now, 1); d > delay {
+					delay = d
+				}
+			}
+		}
+
+		if delay <= 0 {
+			for _, window := range r.weights {
+				window.used += weight
+			}
+			if order {
+				for _, window := range r.orders {
+					window.used++
+				}
+			}
+			r.stats.Requests++
+			if waited > 0 {
+				r.stats.Throttled++
+				r.stats.Waited += waited
+			}
+			r.mtx.Unlock()
+			return nil
+		}
+		r.mtx.Unlock()
+
+		log.Debugf("rate limit: waiting %s", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+			waited += delay
+		}
+	}
+}
+
+// Observe 用响应头中服务器统计的用量更新本地的用量，收到429或者418时按Retry-After暂停请求。
+func (r *RateLimiter) Observe(header http.Header, status int) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	now := r.now()
+	for key, values := range header {
+		if len(values) == 0 {
+			continue
+		}
+		key = strings.ToUpper(key)
+
+		var windows []*rateWindow
+		var interval string
+		switch {
+		case strings.HasPrefix(key, "X-MBX-USED-WEIGHT-"):
+			windows, interval = r.weights, strings.TrimPrefix(key, "X-MBX-USED-WEIGHT-")
+		case strings.HasPrefix(key, "X-MBX-ORDER-COUNT-"):
+			windows, interval = r.orders, strings.TrimPrefix(key, "X-MBX-ORDER-COUNT-")
+		default:
+			continue
+		}
+
+		duration, ok := parseRateInterval(interval)
+		used, err := strconv.Atoi(values[0])
+		if !ok || err != nil {
+			continue
+		}
+		for _, window := range windows {
+			if window.Interval == duration {
+				window.reset(now)
+				if used > window.used {
+					window.used = used
+				}
+			}
+		}
+	}
+
+	if status == http.StatusTooManyRequests || status == http.StatusTeapot {
+		r.stats.Rejected++
+		retry := time.Minute
+		if seconds, err := strconv.Atoi(header.Get("Retry-After")); err == nil && seconds > 0 {
+			retry = time.Duration(seconds) * time.Second
+		}
+		if until := now.Add(retry); until.After(r.stats.BannedUntil) {
+			r.stats.BannedUntil = until
+		}
+		log.Warnf("rate limit: server rejected request with status %d, pausing until %s",
+			status, r.stats.BannedUntil.Format(time.RFC3339))
+	}
+}
+
+// parseRateInterval 解析响应头中的时间窗口，例如"1M"、"10S"、"1D"。
+func parseRateInterval(interval string) (time.Duration, bool) {
+	if len(interval) < 2 {
+		return 0, false
+	}
+	value, err := strconv.Atoi(interval[:len(interval)-1])
+	if err != nil {
+		return 0, false
+	}
+
+	switch strings.ToUpper(interval[len(interval)-1:]) {
+	case "S":
+		return time.Duration(value) * time.Second, true
+	case "M":
+		return time.Duration(value) * time.Minute, true
+	case "H":
+		return time.Duration(value) * time.Hour, true
+	case "D":
+		return time.Duration(value) * 24 * time.Hour, true
+	}
+	return 0, false
+}
+
+// Usage 返回所有时间窗口当前的用量。
+func (r *RateLimiter) Usage() []RateUsage {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	now := r.now()
+	usage := make([]RateUsage, 0, len(r.weights)+len(r.orders))
+	for _, window := range r.weights {
+		window.reset(now)
+		usage = append(usage, RateUsage{Name: "weight", Interval: window.Interval, Used: window.used,
+			Limit: window.Limit})
+	}
+	for _, window := range r.orders {
+		window.reset(now)
+		usage = append(usage, RateUsage{Name: "orders", Interval: window.Interval, Used: window.used,
+			Limit: window.Limit})
+	}
+	return usage
+}
+
+// Stats 返回RateLimiter的统计信息。
+func (r *RateLimiter) Stats() RateStats {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.stats
+}
+
+// Transport 返回一个在发送请求之前等待RateLimiter的http.RoundTripper，base为nil时使用http.DefaultTransport。
+func (r *RateLimiter) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &rateLimitTransport{limiter: r, base: base}
+}
+
+// Client 返回一个使用RateLimiter的http.Client。
+func (r *RateLimiter) Client() *http.Client {
+	return &http.Client{Transport: r.Transport(nil)}
+}
+
+// rateLimitTransport 在发送请求之前等待RateLimiter，收到响应之后更新用量。
+type rateLimitTransport struct {
+	limiter *RateLimiter
+	base    http.RoundTripper
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := t.limiter.endpoint(req.Method, req.URL.Path)
+	if err := t.limiter.Wait(req.Context(), endpoint.Weight, endpoint.Order); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.limiter.Observe(resp.Header, resp.StatusCode)
+	return resp, nil
+}
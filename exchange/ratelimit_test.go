@@ -0,0 +1,129 @@
+package exchange
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter_Wait(t *testing.T) {
+	t.Run("weight", func(t *testing.T) {
+		limiter := NewRateLimiter(WithWeightLimit(200*time.Millisecond, 10))
+		require.NoError(t, limiter.Wait(context.Background(), 6, false))
+
+		start := time.Now()
+		require.NoError(t, limiter.Wait(context.Background(), 6, false))
+		require.Greater(t, time.Since(start), time.Duration(0))
+
+		stats := limiter.Stats()
+		require.Equal(t, int64(2), stats.Requests)
+		require.Equal(t, int64(1), stats.Throttled)
+		require.Equal(t, []RateUsage{{Name: "weight", Interval: 200 * time.Millisecond, Used: 6, Limit: 10}},
+			limiter.Usage())
+	})
+
+	t.Run("orders", func(t *testing.T) {
+		limiter := NewRateLimiter(WithWeightLimit(time.Minute, 100), WithOrderLimit(time.Hour, 1))
+		require.NoError(t, limiter.Wait(context.Background(), 1, true))
+
+		// 查询请求不计算下单次数
+		require.NoError(t, limiter.Wait(context.Background(), 1, false))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, limiter.Wait(ctx, 1, true), context.DeadlineExceeded)
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		limiter := NewRateLimiter()
+		for i := 0; i < 100; i++ {
+			require.NoError(t, limiter.Wait(context.Background(), 100, true))
+		}
+		require.Equal(t, int64(100), limiter.Stats().Requests)
+	})
+}
+
+func TestRateLimiter_Observe(t *testing.T) {
+	limiter := NewBinanceRateLimiter()
+
+	header := http.Header{}
+	header.Set("X-Mbx-Used-Weight-1m", "5000")
+	header.Set("X-Mbx-Order-Count-10s", "7")
+	header.Set("X-Mbx-Used-Weight", "5000")
+	limiter.Observe(header, http.StatusOK)
+
+	usage := limiter.Usage()
+	require.Equal(t, 5000, usage[0].Used)
+	require.Equal(t, 7, usage[1].Used)
+	require.Equal(t, 0, usage[2].Used)
+
+	// 服务器的用量比本地少时保留本地的用量
+	header.Set("X-Mbx-Used-Weight-1m", "10")
+	limiter.Observe(header, http.StatusOK)
+	require.Equal(t, 5000, limiter.Usage()[0].Used)
+
+	header = http.Header{}
+	header.Set("Retry-After", "2")
+	limiter.Observe(header, http.StatusTooManyRequests)
+	stats := limiter.Stats()
+	require.Equal(t, int64(1), stats.Rejected)
+	require.WithinDuration(t, time.Now().Add(2*time.Second), stats.BannedUntil, time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, limiter.Wait(ctx, 1, false), context.DeadlineExceeded)
+}
+
+func TestRateLimiter_Transport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-MBX-USED-WEIGHT-1M", "42")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	limiter := NewRateLimiter(
+		WithWeightLimit(time.Minute, 100),
+		WithOrderLimit(time.Minute, 10),
+		WithEndpointWeight("GET /api/v3/order", 4, false),
+		WithEndpointWeight("POST /api/v3/order", 1, true),
+	)
+	client := limiter.Client()
+
+	resp, err := client.Post(server.URL+"/api/v3/order", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	usage := limiter.Usage()
+	require.Equal(t, 42, usage[0].Used)
+	require.Equal(t, 1, usage[1].Used)
+
+	resp, err = client.Get(server.URL + "/api/v3/order")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	usage = limiter.Usage()
+	require.Equal(t, 46, usage[0].Used)
+	require.Equal(t, 1, usage[1].Used)
+}
+
+func TestParseRateInterval(t *testing.T) {
+	for interval, expected := range map[string]time.Duration{
+		"1M":  time.Minute,
+		"10S": 10 * time.Second,
+		"1D":  24 * time.Hour,
+		"1h":  time.Hour,
+	} {
+		duration, ok := parseRateInterval(interval)
+		require.True(t, ok, interval)
+		require.Equal(t, expected, duration, interval)
+	}
+
+	_, ok := parseRateInterval("M")
+	require.False(t, ok)
+	_, ok = parseRateInterval("1W")
+	require.False(t, ok)
+}
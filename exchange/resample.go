@@ -0,0 +1,302 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/xhit/go-str2duration/v2"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+var (
+	// ErrInvalidTimeframe 表示无法解析的时间周期
+	ErrInvalidTimeframe = errors.New("invalid timeframe")
+	// ErrSameTimeframe 表示重采样的源周期与目标周期相同
+	ErrSameTimeframe = errors.New("source and target timeframes are the same")
+)
+
+// TimeframeUnit 是时间周期的单位
+type TimeframeUnit int
+
+const (
+	UnitSecond TimeframeUnit = iota
+	UnitMinute
+	UnitHour
+	UnitDay
+	UnitWeek
+	UnitMonth
+)
+
+// timeframeRegex 匹配"数量+单位"格式的时间周期，M表示月，与Binance的1M相同
+var timeframeRegex = regexp.MustCompile(`^(\d+)(s|m|h|d|w|M)$`)
+
+var timeframeUnits = map[string]TimeframeUnit{
+	"s": UnitSecond, "m": UnitMinute, "h": UnitHour, "d": UnitDay, "w": UnitWeek, "M": UnitMonth,
+}
+
+var timeframeDurations = map[TimeframeUnit]time.Duration{
+	UnitSecond: time.Second,
+	UnitMinute: time.Minute,
+	UnitHour:   time.Hour,
+	UnitDay:    24 * time.Hour,
+	UnitWeek:   7 * 24 * time.Hour,
+	UnitMonth:  30 * 24 * time.Hour,
+}
+
+// Timeframe 是解析后的时间周期，如3m、45m、6h、3d、1w和1M（月）
+type Timeframe struct {
+	Count int
+	Unit  TimeframeUnit
+}
+
+// ParseTimeframe 解析时间周期，除了"数量+单位"的格式，也支持"1h30m"这样的组合，组合的周期按秒计算
+func ParseTimeframe(timeframe string) (Timeframe, error) {
+	if match := timeframeRegex.FindStringSubmatch(timeframe); match != nil {
+		count, err := strconv.Atoi(match[1])
+		if err == nil && count > 0 {
+			return Timeframe{Count: count, Unit: timeframeUnits[match[2]]}, nil
+		}
+	} else if duration, err := str2duration.ParseDuration(timeframe); err == nil && duration >= time.Second {
+		return Timeframe{Count: int(duration / time.Second), Unit: UnitSecond}, nil
+	}
+	return Timeframe{}, fmt.Errorf("%w: %s", ErrInvalidTimeframe, timeframe)
+}
+
+// Duration 返回周期的长度，月按30天计算，只用于估算K线的数量
+func (t Timeframe) Duration() time.Duration {
+	return time.Duration(t.Count) * timeframeDurations[t.Unit]
+}
+
+// Next 返回t之后一个周期的时间，日、周和月按t所在时区的日历计算
+func (t Timeframe) Next(tm time.Time) time.Time {
+	switch t.Unit {
+	case UnitDay:
+		return tm.AddDate(0, 0, t.Count)
+	case UnitWeek:
+		return tm.AddDate(0, 0, 7*t.Count)
+	case UnitMonth:
+		return tm.AddDate(0, t.Count, 0)
+	}
+	return tm.Add(t.Duration())
+}
+
+/*
+Alignment 决定重采样时周期的边界，零值与原来的行为相同：按UTC对齐，周线从周日开始。
+
+  - 秒、分钟和小时周期从交易日的开始按当地时间划分，不能整除一天的周期（如7m、13h）在交易日结束时截断；
+    超过一天的周期（如36h）按1970-01-01以来的时间对齐，不会截断。夏令时切换所在的周期变短或变长，之后的边界不变
+  - 多日周期（如3d）按1970-01-01以来的天数对齐，与Binance的3d相同
+  - 周线从WeekStart开始，多周的周期按1970年以来的周数对齐
+  - 月线从每月1日开始，多月的周期按公元0年以来的月数对齐，3M对应季度
+*/
+type Alignment struct {
+	Location     *time.Location // Location 周期对齐的时区，默认UTC
+	SessionStart time.Duration  // SessionStart 交易日开始相对于零点的偏移，如外汇的17h
+	WeekStart    time.Weekday   // WeekStart 周线开始的星期，默认周日
+}
+
+func (a Alignment) location() *time.Location {
+	if a.Location == nil {
+		return time.UTC
+	}
+	return a.Location
+}
+
+// PeriodStart 返回t所在周期的开始时间
+func (t Timeframe) PeriodStart(tm time.Time, alignment Alignment) time.Time {
+	location := alignment.location()
+	shifted := tm.In(location).Add(-alignment.SessionStart)
+	year, month, day := shifted.Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, location)
+
+	var start time.Time
+	switch t.Unit {
+	case UnitDay:
+		start = midnight.AddDate(0, 0, -floorMod(epochDays(year, month, day), t.Count))
+	case UnitWeek:
+		offset := (int(shifted.Weekday()) - int(alignment.WeekStart) + 7) % 7
+		start = midnight.AddDate(0, 0, -offset)
+		if t.Count > 1 {
+			weeks := floorDiv(epochDays(start.Date()), 7)
+			start = start.AddDate(0, 0, -7*floorMod(weeks, t.Count))
+		}
+	case UnitMonth:
+		months := year*12 + int(month) - 1
+		months -= floorMod(months, t.Count)
+		start = time.Date(months/12, time.Month(months%12+1), 1, 0, 0, 0, 0, location)
+	default:
+		// 按当地时间划分周期，夏令时切换时周期的边界不会偏移，切换所在的周期相应地变短或变长
+		period := t.Duration()
+		wall := wallClock(shifted)
+		offset := wall.Sub(wallClock(midnight)) % period
+		if period > 24*time.Hour {
+			// 超过一天的周期（如36h）按1970-01-01以来的时间对齐，与多日周期相同
+			if offset = wall.Sub(time.Unix(0, 0).UTC()) % period; offset < 0 {
+				offset += period
+			}
+		}
+		// 周期内没有时区偏移的变化时直接减去偏移，重复的当地时间（夏令时结束）也能得到正确的结果
+		target := wall.Add(-offset)
+		if start = shifted.Add(-offset); !wallClock(start).Equal(target) {
+			start = time.Date(target.Year(), target.Month(), target.Day(), target.Hour(), target.Minute(),
+				target.Second(), target.Nanosecond(), location)
+		}
+	}
+	return start.Add(alignment.SessionStart)
+}
+
+// wallClock 返回t在所在时区的日期和时间，时区设为UTC，用于按当地时间计算
+func wallClock(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// epochDays 返回日期距离1970-01-01的天数
+func epochDays(year int, month time.Month, day int) int {
+	return int(time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
+
+func floorDiv(a, b int) int {
+	return int(math.Floor(float64(a) / float64(b)))
+}
+
+func floorMod(a, b int) int {
+	return a - floorDiv(a, b)*b
+}
+
+/*
+Resampler 把源时间周期的K线合并为目标时间周期的K线，用于CSVFeed、流式数据源、下载器和实时订阅。
+
+每根源K线输出一根目标周期的K线：开盘时间是目标周期的开始，开盘价来自周期内的第一根K线，最高价、最低价和成交量
+累计到当前K线，周期的最后一根源K线输出的K线标记为完整。第一个完整周期之前的K线被跳过。
+未完成的源K线（实时数据的更新）与周期内已完成的K线合并后输出，但不会计入之后的K线。
+开盘时间不晚于上一根已完成K线的重复或迟到的K线被忽略。
+*/
+type Resampler struct {
+	from      Timeframe
+	to        Timeframe
+	same      bool // same 源周期与目标周期相同，K线不需要合并
+	alignment Alignment
+	started   bool          // started 是否已经找到第一个完整周期的开始
+	closed    *model.Candle // closed 当前周期内已完成的源K线合并的结果
+	last      time.Time     // last 最后一根已完成的源K线的开盘时间
+}
+
+// NewResampler 创建从from周期到to周期的重采样器
+func NewResampler(from, to string, alignment Alignment) (*Resampler, error) {
+	source, err := ParseTimeframe(from)
+	if err != nil {
+		return nil, err
+	}
+	target, err := ParseTimeframe(to)
+	if err != nil {
+		return nil, err
+	}
+	return &Resampler{from: source, to: target, same: from == to, alignment: alignment}, nil
+}
+
+// ValidateResample 检查能否把from周期的K线重采样为to周期，周期无效时返回ErrInvalidTimeframe，
+// 两个周期相同时返回ErrSameTimeframe
+func ValidateResample(from, to string) error {
+	source, err := ParseTimeframe(from)
+	if err != nil {
+		return err
+	}
+	target, err := ParseTimeframe(to)
+	if err != nil {
+		return err
+	}
+	if source == target {
+		return fmt.Errorf("%w: %s", ErrSameTimeframe, to)
+	}
+	return nil
+}
+
+// IsLast 开盘时间为t的源K线是否是目标周期的最后一根K线
+func (r *Resampler) IsLast(t time.Time) bool {
+	if r.same {
+		return true
+	}
+	next := r.from.Next(t.In(r.alignment.location()))
+	return r.to.PeriodStart(next, r.alignment).Equal(next)
+}
+
+// IsFirst 开盘时间为t的源K线是否是目标周期的第一根K线
+func (r *Resampler) IsFirst(t time.Time) bool {
+	return r.same || r.to.PeriodStart(t, r.alignment).Equal(t)
+}
+
+// Update 处理一根源K线，返回合并后的目标周期K线，第一个完整周期之前返回false
+func (r *Resampler) Update(candle model.Candle) (model.Candle, bool) {
+	if r.same {
+		return candle, true
+	}
+
+	if !r.started {
+		if !r.IsFirst(candle.Time) {
+			return model.Candle{}, false
+		}
+		r.started = true
+	}
+
+	// 重复或迟到的K线已经计入之前的结果
+	if !r.last.IsZero() && !candle.Time.After(r.last) {
+		return model.Candle{}, false
+	}
+
+	start := r.to.PeriodStart(candle.Time, r.alignment).UTC()
+	if r.closed != nil && !r.closed.Time.Equal(start) {
+		// 上一个周期缺少最后一根K线，不再合并
+		r.closed = nil
+	}
+
+	result := candle
+	result.Time = start
+	if r.closed != nil {
+		result.Open = r.closed.Open
+		result.High = math.Max(r.closed.High, candle.High)
+		result.Low = math.Min(r.closed.Low, candle.Low)
+		result.Volume += r.closed.Volume
+	}
+
+	result.Complete = false
+	if candle.Complete {
+		r.last = candle.Time
+		result.Complete = r.IsLast(candle.Time)
+		if result.Complete {
+			r.closed = nil
+		} else {
+			closed := result
+			r.closed = &closed
+		}
+	}
+	return result, true
+}
+
+// resampleCandles 把按时间排序的源K线重采样到目标周期，数据末尾不完整的K线被丢弃
+func resampleCandles(candles []model.Candle, from, to string, alignment Alignment) ([]model.Candle, error) {
+	resampler, err := NewResampler(from, to, alignment)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]model.Candle, 0, len(candles))
+	for _, candle := range candles {
+		if resampled, ok := resampler.Update(candle); ok {
+			if from == to {
+				resampled.Complete = true
+			}
+			result = append(result, resampled)
+		}
+	}
+
+	if len(result) > 0 && !result[len(result)-1].Complete {
+		result = result[:len(result)-1]
+	}
+	return result, nil
+}
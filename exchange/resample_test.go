@@ -0,0 +1,231 @@
+package exchange
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+func TestParseTimeframe(t *testing.T) {
+	tt := []struct {
+		timeframe string
+		expected  Timeframe
+		duration  time.Duration
+	}{
+		{"1s", Timeframe{1, UnitSecond}, time.Second},
+		{"3m", Timeframe{3, UnitMinute}, 3 * time.Minute},
+		{"45m", Timeframe{45, UnitMinute}, 45 * time.Minute},
+		{"6h", Timeframe{6, UnitHour}, 6 * time.Hour},
+		{"3d", Timeframe{3, UnitDay}, 72 * time.Hour},
+		{"2w", Timeframe{2, UnitWeek}, 14 * 24 * time.Hour},
+		{"1M", Timeframe{1, UnitMonth}, 30 * 24 * time.Hour},
+		{"1h30m", Timeframe{5400, UnitSecond}, 90 * time.Minute},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.timeframe, func(t *testing.T) {
+			timeframe, err := ParseTimeframe(tc.timeframe)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, timeframe)
+			assert.Equal(t, tc.duration, timeframe.Duration())
+		})
+	}
+
+	for _, timeframe := range []string{"", "1y", "0m", "batata", "-1h"} {
+		_, err := ParseTimeframe(timeframe)
+		require.ErrorIs(t, err, ErrInvalidTimeframe, timeframe)
+	}
+}
+
+func TestTimeframe_PeriodStart(t *testing.T) {
+	saoPaulo := time.FixedZone("BRT", -3*60*60)
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	date := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tt := []struct {
+		timeframe string
+		alignment Alignment
+		time      time.Time
+		start     time.Time
+	}{
+		{"3m", Alignment{}, date(2021, 5, 13, 10, 7), date(2021, 5, 13, 10, 6)},
+		{"45m", Alignment{}, date(2021, 5, 13, 1, 40), date(2021, 5, 13, 1, 30)},
+		{"45m", Alignment{}, date(2021, 5, 13, 23, 59), date(2021, 5, 13, 23, 15)},
+		{"7m", Alignment{}, date(2021, 5, 13, 23, 59), date(2021, 5, 13, 23, 55)},
+		{"6h", Alignment{}, date(2021, 5, 13, 17, 0), date(2021, 5, 13, 12, 0)},
+		{"6h", Alignment{SessionStart: 2 * time.Hour}, date(2021, 5, 13, 1, 0), date(2021, 5, 12, 20, 0)},
+		{"1d", Alignment{Location: saoPaulo}, date(2021, 5, 13, 2, 0), date(2021, 5, 12, 3, 0)},
+		{"1d", Alignment{SessionStart: 17 * time.Hour}, date(2021, 5, 13, 16, 0), date(2021, 5, 12, 17, 0)},
+		{"3d", Alignment{}, date(2021, 5, 13, 10, 0), date(2021, 5, 12, 0, 0)},
+		{"1w", Alignment{}, date(2021, 5, 13, 10, 0), date(2021, 5, 9, 0, 0)},
+		{"1w", Alignment{WeekStart: time.Monday}, date(2021, 5, 13, 10, 0), date(2021, 5, 10, 0, 0)},
+		{"1w", Alignment{WeekStart: time.Monday}, date(2021, 5, 9, 10, 0), date(2021, 5, 3, 0, 0)},
+		{"2w", Alignment{WeekStart: time.Monday}, date(2021, 5, 13, 10, 0), date(2021, 5, 3, 0, 0)},
+		{"1M", Alignment{}, date(2021, 5, 13, 10, 0), date(2021, 5, 1, 0, 0)},
+		{"3M", Alignment{}, date(2021, 5, 13, 10, 0), date(2021, 4, 1, 0, 0)},
+		{"1M", Alignment{Location: saoPaulo}, date(2021, 6, 1, 1, 0), date(2021, 5, 1, 3, 0)},
+		// 超过一天的小时和分钟周期按1970-01-01对齐，不在零点截断
+		{"36h", Alignment{}, date(2021, 5, 13, 10, 0), date(2021, 5, 12, 0, 0)},
+		{"36h", Alignment{}, date(2021, 5, 13, 13, 0), date(2021, 5, 13, 12, 0)},
+		{"2880m", Alignment{}, date(2021, 5, 14, 10, 0), date(2021, 5, 13, 0, 0)},
+		// 夏令时切换的当天按当地时间划分：3月28日05:30 CEST在04:00开始的周期，10月31日05:30 CET同样
+		{"4h", Alignment{Location: berlin}, date(2021, 3, 28, 3, 30), date(2021, 3, 28, 2, 0)},
+		{"4h", Alignment{Location: berlin}, date(2021, 10, 31, 4, 30), date(2021, 10, 31, 3, 0)},
+		// 重复的02:30 CET属于00:00 CEST开始的周期
+		{"4h", Alignment{Location: berlin}, date(2021, 10, 31, 1, 30), date(2021, 10, 30, 22, 0)},
+		{"1h", Alignment{Location: berlin}, date(2021, 10, 31, 1, 30), date(2021, 10, 31, 1, 0)},
+	}
+
+	for _, tc := range tt {
+		t.Run(fmt.Sprintf("%s %s", tc.timeframe, tc.time), func(t *testing.T) {
+			timeframe, err := ParseTimeframe(tc.timeframe)
+			require.NoError(t, err)
+			assert.True(t, tc.start.Equal(timeframe.PeriodStart(tc.time, tc.alignment)),
+				"expected %s, got %s", tc.start, timeframe.PeriodStart(tc.time, tc.alignment))
+		})
+	}
+}
+
+func TestResampler(t *testing.T) {
+	start := time.Date(2021, 5, 13, 0, 0, 0, 0, time.UTC)
+	candle := func(minute int, price float64, complete bool) model.Candle {
+		return model.Candle{
+			Pair:     "BTCUSDT",
+			Time:     start.Add(time.Duration(minute) * time.Minute),
+			Open:     price,
+			Close:    price,
+			Low:      price - 1,
+			High:     price + 1,
+			Volume:   1,
+			Complete: complete,
+		}
+	}
+
+	t.Run("live updates", func(t *testing.T) {
+		resampler, err := NewResampler("15m", "45m", Alignment{})
+		require.NoError(t, err)
+
+		// 第一个完整周期之前的K线被跳过
+		_, ok := resampler.Update(candle(-15, 5, true))
+		require.False(t, ok)
+
+		result, ok := resampler.Update(candle(0, 10, false))
+		require.True(t, ok)
+		assert.Equal(t, start, result.Time)
+		assert.False(t, result.Complete)
+
+		result, ok = resampler.Update(candle(0, 12, true))
+		require.True(t, ok)
+		assert.Equal(t, 12.0, result.Open)
+		assert.False(t, result.Complete)
+
+		// 未完成的K线不会计入之后的K线
+		result, ok = resampler.Update(candle(15, 20, false))
+		require.True(t, ok)
+		assert.Equal(t, 2.0, result.Volume)
+		assert.Equal(t, 21.0, result.High)
+
+		_, _ = resampler.Update(candle(15, 14, true))
+		result, ok = resampler.Update(candle(30, 13, true))
+		require.True(t, ok)
+		assert.Equal(t, start, result.Time)
+		assert.Equal(t, 12.0, result.Open)
+		assert.Equal(t, 13.0, result.Close)
+		assert.Equal(t, 15.0, result.High)
+		assert.Equal(t, 11.0, result.Low)
+		assert.Equal(t, 3.0, result.Volume)
+		assert.True(t, result.Complete)
+
+		result, ok = resampler.Update(candle(45, 30, true))
+		require.True(t, ok)
+		assert.Equal(t, start.Add(45*time.Minute), result.Time)
+		assert.Equal(t, 30.0, result.Open)
+		assert.Equal(t, 1.0, result.Volume)
+	})
+
+	t.Run("csv feed", func(t *testing.T) {
+		for _, timeframe := range []string{"3h", "6h", "3d", "1M"} {
+			feed, err := NewCSVFeed(timeframe, PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1h.csv",
+				Timeframe: "1h", Validation: &ValidationOptions{Dedupe: true}})
+			require.NoError(t, err)
+
+			target, err := ParseTimeframe(timeframe)
+			require.NoError(t, err)
+
+			source := feed.CandlePairTimeFrame["BTCUSDT--1h"]
+			candles := feed.CandlePairTimeFrame["BTCUSDT--"+timeframe]
+			complete := 0
+			for _, candle := range candles {
+				if !candle.Complete {
+					continue
+				}
+				complete++
+
+				// 完整的K线等于周期内所有源K线的合并
+				end := target.Next(candle.Time)
+				expected := model.Candle{Low: candle.Low + 1}
+				count := 0
+				for _, s := range source {
+					if s.Time.Before(candle.Time) || !s.Time.Before(end) {
+						continue
+					}
+					if count == 0 {
+						expected.Open = s.Open
+					}
+					expected.Close = s.Close
+					expected.High = math.Max(expected.High, s.High)
+					expected.Low = math.Min(expected.Low, s.Low)
+					expected.Volume += s.Volume
+					count++
+				}
+				assert.Equal(t, expected.Open, candle.Open, "%s %s", timeframe, candle.Time)
+				assert.Equal(t, expected.Close, candle.Close)
+				assert.Equal(t, expected.High, candle.High)
+				assert.Equal(t, expected.Low, candle.Low)
+				assert.InDelta(t, expected.Volume, candle.Volume, 1e-6)
+			}
+			assert.NotZero(t, complete, timeframe)
+		}
+	})
+
+	t.Run("data feed subscription", func(t *testing.T) {
+		feed, err := NewCSVStreamFeed("1h", PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1h.csv",
+			Timeframe: "1h"})
+		require.NoError(t, err)
+		expected, err := NewCSVFeed("4h", PairFeed{Pair: "BTCUSDT", File: "../testdata/btc-1h.csv",
+			Timeframe: "1h"})
+		require.NoError(t, err)
+
+		var hourly, resampled []model.Candle
+		dataFeed := NewDataFeed(streamExchange{feed: feed})
+		require.NoError(t, dataFeed.Resample("BTCUSDT", "1h", "4h", Alignment{}))
+		dataFeed.Subscribe("BTCUSDT", "1h", func(candle model.Candle) {
+			hourly = append(hourly, candle)
+		}, false)
+		dataFeed.Subscribe("BTCUSDT", "4h", func(candle model.Candle) {
+			resampled = append(resampled, candle)
+		}, false)
+		dataFeed.Start(true)
+
+		// 4h的订阅不会向交易所订阅，由1h的K线生成
+		assert.Len(t, dataFeed.DataFeeds, 1)
+		assert.Len(t, hourly, len(expected.CandlePairTimeFrame["BTCUSDT--1h"]))
+		// 实时订阅不会丢弃数据末尾不完整的K线
+		candles := expected.CandlePairTimeFrame["BTCUSDT--4h"]
+		require.GreaterOrEqual(t, len(resampled), len(candles))
+		assert.Equal(t, candles, resampled[:len(candles)])
+
+		require.ErrorIs(t, dataFeed.Resample("BTCUSDT", "1h", "1y", Alignment{}), ErrInvalidTimeframe)
+		require.ErrorIs(t, dataFeed.Resample("BTCUSDT", "1h", "1h", Alignment{}), ErrSameTimeframe)
+	})
+}
@@ -0,0 +1,587 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+)
+
+/*
+Router 将多个交易所组合为一个service.Exchange，NewBot只接受一个交易所，通过Router可以把不同的交易对放到不同的交易所，
+也可以在同一个机器人中同时交易多个交易所的同一个交易对（例如套利）。
+
+交易对按以下顺序找到交易所：
+  - 指定了交易所的交易对，例如 "okx:BTCUSDT"，在okx交易BTCUSDT，策略可以直接选择交易所；
+  - WithRoute设置的交易对路由；
+  - 默认交易所，即第一个添加的交易所，可以通过WithDefaultVenue修改。
+
+不同交易所的订单ID可能相同，而storage按ExchangeID查找订单，所以Router返回的ExchangeID在高位带有交易所的编号：
+ExchangeID = (交易所编号+1) << 56 | 交易所的订单ID。交易所的订单ID超过56位时（例如字符串订单ID的哈希），
+只保留低56位，完整的订单ID记录在内存中；重启后内存中的记录丢失时，通过交易所的OrderLister列出最近的订单重新找到。
+Account返回所有交易所的余额，Balance.Venue是余额所在的交易所，可以用Account.Venue按交易所筛选。
+*/
+
+// Router的错误。
+var (
+	ErrUnknownVenue = errors.New("unknown venue") // 交易对或者订单ID对应的交易所不存在
+)
+
+const (
+	routerIDBits    = 56                       // 交易所订单ID占用的位数
+	routerIDMask    = 1<<routerIDBits - 1      // 交易所订单ID的掩码
+	routerMaxVenues = 1<<(63-routerIDBits) - 1 // 最多支持的交易所数量
+	routerListLimit = 1000                     // 重新查找订单ID时列出的订单数量
+)
+
+// routerVenue Router中的一个交易所。
+type routerVenue struct {
+	name     string
+	exchange service.Exchange
+}
+
+// Router 按交易对将请求路由到不同的交易所。
+type Router struct {
+	venues       []routerVenue  // venues 按添加顺序排列的交易所，下标就是交易所编号
+	index        map[string]int // index 交易所名称到编号的对应关系
+	routes       map[string]int // routes 交易对到交易所编号的路由
+	defaultVenue string         // defaultVenue 默认交易所的名称
+
+	mtx   sync.Mutex        // mtx 保护ids和pairs
+	ids   map[int64]int64   // ids 超过56位的交易所订单ID，Router的ExchangeID到交易所订单ID的对应关系
+	pairs map[string]string // pairs 交易所和交易所中的交易对到调用方使用的交易对的对应关系，用于订单推送
+	err   error             // err 创建Router时的配置错误
+}
+
+// RouterOption 定义了一个函数类型，用于通过不同的配置选项来定制化Router实例。
+type RouterOption func(*Router)
+
+// WithVenue 添加一个交易所，name用于交易对中指定交易所（"name:PAIR"）和Balance.Venue，不能包含":"。
+func WithVenue(name string, exchange service.Exchange) RouterOption {
+	return func(r *Router) {
+		if _, ok := r.index[name]; ok || name == "" || strings.Contains(name, VenueSeparator) {
+			r.err = fmt.Errorf("router: invalid or duplicated venue name %q", name)
+			return
+		}
+		r.index[name] = len(r.venues)
+		r.venues = append(r.venues, routerVenue{name: name, exchange: exchange})
+	}
+}
+
+// WithRoute 将没有指定交易所的交易对路由到venue，需要在WithVenue之后使用。
+func WithRoute(pair, venue string) RouterOption {
+	return func(r *Router) {
+		index, ok := r.index[venue]
+		if !ok {
+			r.err = fmt.Errorf("router: route %s: %w %s", pair, ErrUnknownVenue, venue)
+			return
+		}
+		r.routes[strings.ToUpper(pair)] = index
+	}
+}
+
+// WithDefaultVenue 设置没有路由的交易对使用的交易所，默认为第一个添加的交易所。
+func WithDefaultVenue(venue string) RouterOption {
+	return func(r *Router) {
+		r.defaultVenue = venue
+	}
+}
+
+// NewRouter 创建一个Router，至少需要添加一个交易所。
+func NewRouter(options ...RouterOption) (*Router, error) {
+	router := &Router{
+		index:  make(map[string]int),
+		routes: make(map[string]int),
+		ids:    make(map[int64]int64),
+		pairs:  make(map[string]string),
+	}
+
+	for _, option := range options {
+		option(router)
+	}
+
+	if router.err != nil {
+		return nil, router.err
+	}
+	if len(router.venues) == 0 {
+		return nil, fmt.Errorf("router: at least one venue is required")
+	}
+	if len(router.venues) > routerMaxVenues {
+		return nil, fmt.Errorf("router: at most %d venues are supported", routerMaxVenues)
+	}
+	if router.defaultVenue == "" {
+		router.defaultVenue = router.venues[0].name
+	}
+	if _, ok := router.index[router.defaultVenue]; !ok {
+		return nil, fmt.Errorf("router: default %w %s", ErrUnknownVenue, router.defaultVenue)
+	}
+	return router, nil
+}
+
+// Venues 返回所有交易所的名称，按添加顺序排列。
+func (r *Router) Venues() []string {
+	names := make([]string, 0, len(r.venues))
+	for _, venue := range r.venues {
+		names = append(names, venue.name)
+	}
+	return names
+}
+
+// Venue 返回交易对对应的交易所名称。
+func (r *Router) Venue(pair string) (string, error) {
+	index, _, err := r.route(pair)
+	if err != nil {
+		return "", err
+	}
+	return r.venues[index].name, nil
+}
+
+// route 返回交易对对应的交易所编号和交易所中的交易对，并记录调用方使用的交易对。
+func (r *Router) route(pair string) (int, string, error) {
+	venue, symbol := SplitVenuePair(pair)
+
+	var index int
+	switch {
+	case venue != "":
+		var ok bool
+		if index, ok = r.index[venue]; !ok {
+			return 0, "", fmt.Errorf("%w %s", ErrUnknownVenue, venue)
+		}
+	default:
+		var ok bool
+		if index, ok = r.routes[symbol]; !ok {
+			index = r.index[r.defaultVenue]
+		}
+	}
+
+	r.mtx.Lock()
+	r.pairs[r.venues[index].name+VenueSeparator+symbol] = pair
+	r.mtx.Unlock()
+	return index, symbol, nil
+}
+
+// callerPair 返回交易所推送的订单在调用方使用的交易对，没有记录时路由到这个交易所的交易对不带交易所名称。
+func (r *Router) callerPair(index int, symbol string) string {
+	name := r.venues[index].name
+	r.mtx.Lock()
+	pair, ok := r.pairs[name+VenueSeparator+symbol]
+	r.mtx.Unlock()
+	if ok {
+		return pair
+	}
+
+	if routed, ok := r.routes[symbol]; (ok && routed == index) || (!ok && name == r.defaultVenue) {
+		return symbol
+	}
+	return name + VenueSeparator + symbol
+}
+
+// encodeID 将交易所的订单ID转换为带有交易所编号的ExchangeID。
+func (r *Router) encodeID(index int, id int64) int64 {
+	encoded := int64(index+1)<<routerIDBits | id&routerIDMask
+	if id < 0 || id > routerIDMask {
+		r.mtx.Lock()
+		r.ids[encoded] = id
+		r.mtx.Unlock()
+	}
+	return encoded
+}
+
+// decodeID 返回ExchangeID对应的交易所编号和交易所的订单ID，ok为false表示高位被截断并且内存中没有记录。
+func (r *Router) decodeID(id int64) (index int, original int64, ok bool, err error) {
+	index = int(id>>routerIDBits) - 1
+	if index < 0 || index >= len(r.venues) {
+		return 0, 0, false, fmt.Errorf("%w for order %d", ErrUnknownVenue, id)
+	}
+
+	r.mtx.Lock()
+	original, ok = r.ids[id]
+	r.mtx.Unlock()
+	if ok {
+		return index, original, true, nil
+	}
+	return index, id & routerIDMask, false, nil
+}
+
+// encodeOrder 将交易所返回的订单转换为Router的订单：交易对改为调用方使用的交易对，订单ID带上交易所编号。
+func (r *Router) encodeOrder(index int, pair string, order model.Order) model.Order {
+	order.Pair = pair
+	order.ExchangeID = r.encodeID(index, order.ExchangeID)
+	if order.GroupID != nil {
+		group := r.encodeID(index, *order.GroupID)
+		order.GroupID = &group
+	}
+	if order.ReplacedID != nil {
+		replaced := r.encodeID(index, *order.ReplacedID)
+		order.ReplacedID = &replaced
+	}
+	return order
+}
+
+// decodeOrder 将Router的订单转换为交易所的订单，用于撤单和改单。
+func (r *Router) decodeOrder(order model.Order) (int, model.Order, error) {
+	index, id, _, err := r.decodeID(order.ExchangeID)
+	if err != nil {
+		return 0, model.Order{}, err
+	}
+
+	_, order.Pair = SplitVenuePair(order.Pair)
+	order.ExchangeID = id
+	if order.GroupID != nil {
+		_, group, _, _ := r.decodeID(*order.GroupID)
+		order.GroupID = &group
+	}
+	if order.ReplacedID != nil {
+		_, replaced, _, _ := r.decodeID(*order.ReplacedID)
+		order.ReplacedID = &replaced
+	}
+	return index, order, nil
+}
+
+// findOrder 查找高位被截断并且内存中没有记录的订单（例如重启之后），通过交易所的OrderLister列出最近的订单，
+// 找到低56位相同的订单并重新记录完整的订单ID。
+func (r *Router) findOrder(index int, symbol string, id int64) (model.Order, error) {
+	lister, ok := r.venues[index].exchange.(service.OrderLister)
+	if !ok {
+		return model.Order{}, ErrOrderNotFound
+	}
+
+	orders, err := lister.Orders(symbol, routerListLimit)
+	if err != nil {
+		return model.Order{}, err
+	}
+	for _, order := range orders {
+		if order.ExchangeID&routerIDMask == id {
+			return order, nil
+		}
+	}
+	return model.Order{}, ErrOrderNotFound
+}
+
+// Account 返回所有交易所的余额，Balance.Venue为余额所在的交易所。
+func (r *Router) Account() (model.Account, error) {
+	var account model.Account
+	for _, venue := range r.venues {
+		acc, err := venue.exchange.Account()
+		if err != nil {
+			return model.Account{}, fmt.Errorf("%s: %w", venue.name, err)
+		}
+		for _, balance := range acc.Balances {
+			balance.Venue = venue.name
+			account.Balances = append(account.Balances, balance)
+		}
+	}
+	return account, nil
+}
+
+// Position 返回交易对在对应交易所的持仓。
+func (r *Router) Position(pair string) (asset, quote float64, err error) {
+	index, symbol, err := r.route(pair)
+	if err != nil {
+		return 0, 0, err
+	}
+	return r.venues[index].exchange.Position(symbol)
+}
+
+// PositionInfo 返回交易对在对应交易所的持仓详情，Pair保持调用方使用的名称。
+func (r *Router) PositionInfo(pair string) (model.PositionInfo, error) {
+	index, symbol, err := r.route(pair)
+	if err != nil {
+		return model.PositionInfo{}, err
+	}
+
+	position, err := r.venues[index].exchange.PositionInfo(symbol)
+	if err != nil {
+		return model.PositionInfo{}, err
+	}
+	position.Pair = pair
+	return position, nil
+}
+
+// Order 根据ExchangeID中的交易所编号查询订单。
+func (r *Router) Order(pair string, id int64) (model.Order, error) {
+	index, original, known, err := r.decodeID(id)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	_, symbol := SplitVenuePair(pair)
+	order, err := r.venues[index].exchange.Order(symbol, original)
+	if err != nil && !known && errors.Is(err, ErrOrderNotFound) {
+		order, err = r.findOrder(index, symbol, original)
+	}
+	if err != nil {
+		return model.Order{}, err
+	}
+	return r.encodeOrder(index, pair, order), nil
+}
+
+// Orders 列出交易对在对应交易所最近的订单，交易所没有实现OrderLister时返回ErrNotSupported。
+func (r *Router) Orders(pair string, limit int) ([]model.Order, error) {
+	index, symbol, err := r.route(pair)
+	if err != nil {
+		return nil, err
+	}
+
+	lister, ok := r.venues[index].exchange.(service.OrderLister)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s does not list orders", ErrNotSupported, r.venues[index].name)
+	}
+	orders, err := lister.Orders(symbol, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range orders {
+		orders[i] = r.encodeOrder(index, pair, orders[i])
+	}
+	return orders, nil
+}
+
+// CreateOrderOCO 在交易对对应的交易所创建OCO订单。
+func (r *Router) CreateOrderOCO(side model.SideType, pair string, size, price, stop, stopLimit float64,
+	options ...model.OrderOption) ([]model.Order, error) {
+	index, symbol, err := r.route(pair)
+	if err != nil {
+		return nil, err
+	}
+
+	orders, err := r.venues[index].exchange.CreateOrderOCO(side, symbol, size, price, stop, stopLimit, options...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range orders {
+		orders[i] = r.encodeOrder(index, pair, orders[i])
+	}
+	return orders, nil
+}
+
+// CreateOrderLimit 在交易对对应的交易所创建限价单。
+func (r *Router) CreateOrderLimit(side model.SideType, pair string, size float64, limit float64,
+	options ...model.OrderOption) (model.Order, error) {
+	return r.create(pair, func(venue service.Exchange, symbol string) (model.Order, error) {
+		return venue.CreateOrderLimit(side, symbol, size, limit, options...)
+	})
+}
+
+// CreateOrderMarket 在交易对对应的交易所创建市价单。
+func (r *Router) CreateOrderMarket(side model.SideType, pair string, size float64,
+	options ...model.OrderOption) (model.Order, error) {
+	return r.create(pair, func(venue service.Exchange, symbol string) (model.Order, error) {
+		return venue.CreateOrderMarket(side, symbol, size, options...)
+	})
+}
+
+// CreateOrderMarketQuote 在交易对对应的交易所按计价资产的金额创建市价单。
+func (r *Router) CreateOrderMarketQuote(side model.SideType, pair string, quote float64,
+	options ...model.OrderOption) (model.Order, error) {
+	return r.create(pair, func(venue service.Exchange, symbol string) (model.Order, error) {
+		return venue.CreateOrderMarketQuote(side, symbol, quote, options...)
+	})
+}
+
+// CreateOrderStop 在交易对对应的交易所创建止损单。
+func (r *Router) CreateOrderStop(pair string, quantity float64, limit float64,
+	options ...model.OrderOption) (model.Order, error) {
+	return r.create(pair, func(venue service.Exchange, symbol string) (model.Order, error) {
+		return venue.CreateOrderStop(symbol, quantity, limit, options...)
+	})
+}
+
+// create 找到交易对对应的交易所，下单并转换返回的订单。
+func (r *Router) create(pair string,
+	create func(venue service.Exchange, symbol string) (model.Order, error)) (model.Order, error) {
+	index, symbol, err := r.route(pair)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	order, err := create(r.venues[index].exchange, symbol)
+	if err != nil {
+		return model.Order{}, err
+	}
+	return r.encodeOrder(index, pair, order), nil
+}
+
+// Cancel 根据ExchangeID中的交易所编号撤单。
+func (r *Router) Cancel(order model.Order) error {
+	index, original, err := r.decodeOrder(order)
+	if err != nil {
+		return err
+	}
+	return r.venues[index].exchange.Cancel(original)
+}
+
+// ReplaceOrder 根据ExchangeID中的交易所编号改单，新订单在同一个交易所。
+func (r *Router) ReplaceOrder(order model.Order, price, quantity float64) (model.Order, error) {
+	index, original, err := r.decodeOrder(order)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	replacement, err := r.venues[index].exchange.ReplaceOrder(original, price, quantity)
+	if err != nil {
+		return model.Order{}, err
+	}
+	return r.encodeOrder(index, order.Pair, replacement), nil
+}
+
+// HandlesExpiry 只有所有交易所都自行处理订单过期时，Controller才不需要按本地时间撤单。
+func (r *Router) HandlesExpiry() bool {
+	for _, venue := range r.venues {
+		handler, ok := venue.exchange.(interface{ HandlesExpiry() bool })
+		if !ok || !handler.HandlesExpiry() {
+			return false
+		}
+	}
+	return true
+}
+
+// EmulatesOCO 只要有一个交易所用独立订单模拟OCO，Controller就需要在订单组的一个订单成交后撤销其他订单。
+// 订单组ID和订单ID一样带有交易所编号，不同交易所的订单组不会混在一起。
+func (r *Router) EmulatesOCO() bool {
+	for _, venue := range r.venues {
+		emulator, ok := venue.exchange.(interface{ EmulatesOCO() bool })
+		if ok && emulator.EmulatesOCO() {
+			return true
+		}
+	}
+	return false
+}
+
+// OrderSubscription 合并所有支持订单推送的交易所的订单更新，所有交易所的推送都结束后关闭通道。
+// 没有交易所支持订单推送时立即关闭通道，Controller回退到轮询。
+func (r *Router) OrderSubscription(ctx context.Context) (chan model.Order, chan error) {
+	corder := make(chan model.Order)
+	cerr := make(chan error)
+
+	var wg sync.WaitGroup
+	for index, venue := range r.venues {
+		streamer, ok := venue.exchange.(service.OrderStreamer)
+		if !ok {
+			continue
+		}
+
+		orders, errs := streamer.OrderSubscription(ctx)
+		wg.Add(1)
+		go func(index int, name string) {
+			defer wg.Done()
+			for orders != nil || errs != nil {
+				select {
+				case order, ok := <-orders:
+					if !ok {
+						orders = nil
+						continue
+					}
+					order = r.encodeOrder(index, r.callerPair(index, order.Pair), order)
+					select {
+					case corder <- order:
+					case <-ctx.Done():
+						return
+					}
+				case err, ok := <-errs:
+					if !ok {
+						errs = nil
+						continue
+					}
+					select {
+					case cerr <- fmt.Errorf("%s: %w", name, err):
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}(index, venue.name)
+	}
+
+	go func() {
+		wg.Wait()
+		close(cerr)
+		close(corder)
+	}()
+
+	return corder, cerr
+}
+
+// AssetsInfo 返回交易对在对应交易所的信息，交易所不存在时返回空的信息。
+func (r *Router) AssetsInfo(pair string) model.AssetInfo {
+	index, symbol, err := r.route(pair)
+	if err != nil {
+		return model.AssetInfo{}
+	}
+	return r.venues[index].exchange.AssetsInfo(symbol)
+}
+
+// LastQuote 返回交易对在对应交易所的最新价格。
+func (r *Router) LastQuote(ctx context.Context, pair string) (float64, error) {
+	index, symbol, err := r.route(pair)
+	if err != nil {
+		return 0, err
+	}
+	return r.venues[index].exchange.LastQuote(ctx, symbol)
+}
+
+// CandlesByPeriod 返回交易对在对应交易所的K线，K线的交易对为调用方使用的交易对。
+func (r *Router) CandlesByPeriod(ctx context.Context, pair, period string,
+	start, end time.Time) ([]model.Candle, error) {
+	index, symbol, err := r.route(pair)
+	if err != nil {
+		return nil, err
+	}
+
+	candles, err := r.venues[index].exchange.CandlesByPeriod(ctx, symbol, period, start, end)
+	for i := range candles {
+		candles[i].Pair = pair
+	}
+	return candles, err
+}
+
+// CandlesByLimit 返回交易对在对应交易所最近的limit根K线，K线的交易对为调用方使用的交易对。
+func (r *Router) CandlesByLimit(ctx context.Context, pair, period string, limit int) ([]model.Candle, error) {
+	index, symbol, err := r.route(pair)
+	if err != nil {
+		return nil, err
+	}
+
+	candles, err := r.venues[index].exchange.CandlesByLimit(ctx, symbol, period, limit)
+	for i := range candles {
+		candles[i].Pair = pair
+	}
+	return candles, err
+}
+
+// CandlesSubscription 订阅交易对在对应交易所的K线，K线的交易对为调用方使用的交易对。
+func (r *Router) CandlesSubscription(ctx context.Context, pair, timeframe string) (chan model.Candle, chan error) {
+	index, symbol, err := r.route(pair)
+	if err != nil {
+		ccandle := make(chan model.Candle)
+		cerr := make(chan error, 1)
+		cerr <- err
+		close(cerr)
+		close(ccandle)
+		return ccandle, cerr
+	}
+
+	candles, errs := r.venues[index].exchange.CandlesSubscription(ctx, symbol, timeframe)
+	if symbol == pair {
+		return candles, errs
+	}
+
+	ccandle := make(chan model.Candle)
+	go func() {
+		defer close(ccandle)
+		for candle := range candles {
+			candle.Pair = pair
+			select {
+			case ccandle <- candle:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ccandle, errs
+}
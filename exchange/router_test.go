@@ -0,0 +1,235 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+// streamWallet 为PaperWallet增加订单推送，用于测试Router合并订单更新。
+type streamWallet struct {
+	*PaperWallet
+	orders chan model.Order
+}
+
+func (s streamWallet) OrderSubscription(_ context.Context) (chan model.Order, chan error) {
+	cerr := make(chan error)
+	close(cerr)
+	return s.orders, cerr
+}
+
+// ocoWallet 模拟没有原生OCO的交易所：两个订单共用同一个订单组ID指针，订单组ID超过56位。
+type ocoWallet struct {
+	*PaperWallet
+}
+
+func (o ocoWallet) EmulatesOCO() bool {
+	return true
+}
+
+func (o ocoWallet) CreateOrderOCO(side model.SideType, pair string, size, price, stop, _ float64,
+	_ ...model.OrderOption) ([]model.Order, error) {
+	group := int64(1<<60 + 7)
+	return []model.Order{
+		{ExchangeID: group, Pair: pair, Side: side, Type: model.OrderTypeLimitMaker, Price: price,
+			Quantity: size, Status: model.OrderStatusTypeNew, GroupID: &group},
+		{ExchangeID: group + 1, Pair: pair, Side: side, Type: model.OrderTypeStopLoss, Price: stop,
+			Quantity: size, Status: model.OrderStatusTypeNew, GroupID: &group},
+	}, nil
+}
+
+func TestNewRouter(t *testing.T) {
+	wallet := NewPaperWallet(context.Background(), "USDT", WithPaperAsset("USDT", 0))
+
+	t.Run("no venues", func(t *testing.T) {
+		_, err := NewRouter()
+		require.Error(t, err)
+	})
+
+	t.Run("invalid venue", func(t *testing.T) {
+		_, err := NewRouter(WithVenue("a:b", wallet))
+		require.Error(t, err)
+
+		_, err = NewRouter(WithVenue("a", wallet), WithVenue("a", wallet))
+		require.Error(t, err)
+	})
+
+	t.Run("unknown route", func(t *testing.T) {
+		_, err := NewRouter(WithVenue("a", wallet), WithRoute("BTCUSDT", "b"))
+		require.ErrorIs(t, err, ErrUnknownVenue)
+
+		_, err = NewRouter(WithVenue("a", wallet), WithDefaultVenue("b"))
+		require.ErrorIs(t, err, ErrUnknownVenue)
+	})
+
+	t.Run("routes", func(t *testing.T) {
+		router, err := NewRouter(WithVenue("a", wallet), WithVenue("b", wallet),
+			WithRoute("ETHUSDT", "b"))
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "b"}, router.Venues())
+
+		for pair, expected := range map[string]string{
+			"BTCUSDT":   "a",
+			"ETHUSDT":   "b",
+			"b:BTCUSDT": "b",
+			"a:ETHUSDT": "a",
+		} {
+			venue, err := router.Venue(pair)
+			require.NoError(t, err)
+			require.Equal(t, expected, venue, pair)
+		}
+
+		_, err = router.Venue("c:BTCUSDT")
+		require.ErrorIs(t, err, ErrUnknownVenue)
+	})
+}
+
+func TestRouter_Orders(t *testing.T) {
+	ctx := context.Background()
+	walletA := NewPaperWallet(ctx, "USDT", WithPaperAsset("USDT", 1000))
+	walletA.lastCandle["BTCUSDT"] = model.Candle{Pair: "BTCUSDT", Close: 100}
+	walletB := NewPaperWallet(ctx, "USDT", WithPaperAsset("USDT", 500))
+	walletB.lastCandle["BTCUSDT"] = model.Candle{Pair: "BTCUSDT", Close: 110}
+
+	router, err := NewRouter(WithVenue("a", walletA), WithVenue("b", walletB))
+	require.NoError(t, err)
+
+	t.Run("unique ids", func(t *testing.T) {
+		orderA, err := router.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 1)
+		require.NoError(t, err)
+		orderB, err := router.CreateOrderMarket(model.SideTypeBuy, "b:BTCUSDT", 1)
+		require.NoError(t, err)
+
+		// 两个交易所的订单ID都是1，Router返回的订单ID不同
+		require.NotEqual(t, orderA.ExchangeID, orderB.ExchangeID)
+		require.Equal(t, "BTCUSDT", orderA.Pair)
+		require.Equal(t, "b:BTCUSDT", orderB.Pair)
+		require.Equal(t, 100.0, orderA.Price)
+		require.Equal(t, 110.0, orderB.Price)
+
+		order, err := router.Order("b:BTCUSDT", orderB.ExchangeID)
+		require.NoError(t, err)
+		require.Equal(t, orderB.ExchangeID, order.ExchangeID)
+		require.Equal(t, 110.0, order.Price)
+	})
+
+	t.Run("cancel and replace", func(t *testing.T) {
+		order, err := router.CreateOrderLimit(model.SideTypeBuy, "b:BTCUSDT", 1, 100)
+		require.NoError(t, err)
+
+		replaced, err := router.ReplaceOrder(order, 90, 2)
+		require.NoError(t, err)
+		require.Equal(t, "b:BTCUSDT", replaced.Pair)
+		require.NotNil(t, replaced.ReplacedID)
+		require.Equal(t, order.ExchangeID, *replaced.ReplacedID)
+
+		require.NoError(t, router.Cancel(replaced))
+		replaced, err = router.Order("b:BTCUSDT", replaced.ExchangeID)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusTypeCanceled, replaced.Status)
+
+		orders, err := router.Orders("b:BTCUSDT", 10)
+		require.NoError(t, err)
+		for _, order := range orders {
+			venue, _, _, err := router.decodeID(order.ExchangeID)
+			require.NoError(t, err)
+			require.Equal(t, 1, venue)
+		}
+
+		_, err = router.Order("BTCUSDT", 1)
+		require.ErrorIs(t, err, ErrUnknownVenue)
+	})
+
+	t.Run("account", func(t *testing.T) {
+		account, err := router.Account()
+		require.NoError(t, err)
+
+		asset, _ := account.Venue("a").Balance("BTC", "USDT")
+		require.Equal(t, 1.0, asset.Free)
+		_, quote := account.Venue("b").Balance("BTC", "USDT")
+		require.Equal(t, 390.0, quote.Free)
+
+		position, _, err := router.Position("b:BTCUSDT")
+		require.NoError(t, err)
+		require.Equal(t, 1.0, position)
+
+		info, err := router.PositionInfo("b:BTCUSDT")
+		require.NoError(t, err)
+		require.Equal(t, "b:BTCUSDT", info.Pair)
+		require.Equal(t, model.PositionSideTypeLong, info.Side)
+		require.Equal(t, 1.0, info.Quantity)
+	})
+
+	t.Run("large ids", func(t *testing.T) {
+		id := int64(1<<62 + 5)
+		encoded := router.encodeID(1, id)
+		require.Equal(t, int64(2<<routerIDBits|5), encoded)
+
+		venue, original, ok, err := router.decodeID(encoded)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, 1, venue)
+		require.Equal(t, id, original)
+	})
+}
+
+func TestRouter_EmulatesOCO(t *testing.T) {
+	ctx := context.Background()
+	wallet := NewPaperWallet(ctx, "USDT", WithPaperAsset("USDT", 1000))
+	emulated := ocoWallet{PaperWallet: NewPaperWallet(ctx, "USDT", WithPaperAsset("USDT", 0))}
+
+	router, err := NewRouter(WithVenue("a", wallet))
+	require.NoError(t, err)
+	require.False(t, router.EmulatesOCO())
+
+	router, err = NewRouter(WithVenue("a", wallet), WithVenue("b", emulated))
+	require.NoError(t, err)
+	require.True(t, router.EmulatesOCO())
+
+	orders, err := router.CreateOrderOCO(model.SideTypeSell, "b:BTCUSDT", 1, 120, 90, 0)
+	require.NoError(t, err)
+	require.Len(t, orders, 2)
+
+	// 两个订单的订单组ID相同，带有交易所编号，并且和订单ID一样编码，Controller可以按订单组撤销另一个订单
+	require.NotNil(t, orders[0].GroupID)
+	require.NotNil(t, orders[1].GroupID)
+	require.Equal(t, *orders[0].GroupID, *orders[1].GroupID)
+	require.Equal(t, orders[0].ExchangeID, *orders[0].GroupID)
+	require.Equal(t, 1, int(*orders[0].GroupID>>routerIDBits)-1)
+
+	// 解码后恢复交易所的订单组ID
+	index, original, err := router.decodeOrder(orders[1])
+	require.NoError(t, err)
+	require.Equal(t, 1, index)
+	require.Equal(t, int64(1<<60+7), *original.GroupID)
+	require.Equal(t, int64(1<<60+8), original.ExchangeID)
+}
+
+func TestRouter_OrderSubscription(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream := streamWallet{
+		PaperWallet: NewPaperWallet(ctx, "USDT", WithPaperAsset("USDT", 0)),
+		orders:      make(chan model.Order),
+	}
+	router, err := NewRouter(WithVenue("a", NewPaperWallet(ctx, "USDT", WithPaperAsset("USDT", 0))), WithVenue("b", stream))
+	require.NoError(t, err)
+
+	orders, _ := router.OrderSubscription(ctx)
+	stream.orders <- model.Order{ExchangeID: 3, Pair: "ETHUSDT"}
+
+	order := <-orders
+	require.Equal(t, "b:ETHUSDT", order.Pair)
+	venue, id, _, err := router.decodeID(order.ExchangeID)
+	require.NoError(t, err)
+	require.Equal(t, 1, venue)
+	require.Equal(t, int64(3), id)
+
+	close(stream.orders)
+	_, ok := <-orders
+	require.False(t, ok)
+}
@@ -0,0 +1,301 @@
+package simulator
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+/*
+合约接口同样由PaperWallet撮合：PaperWallet中基础资产的余额（可以为负数，表示空头）作为单向持仓返回，
+其他资产作为保证金资产返回。杠杆倍数只记录下来用于账户接口，不影响撮合。标记价格是最后一根K线的收盘价。
+*/
+
+// futuresExchangeInfo 返回合约交易对信息，交易限制来自PaperWallet。
+func (s *Server) futuresExchangeInfo(_ url.Values) (interface{}, error) {
+	info := futures.ExchangeInfo{Timezone: "UTC", ServerTime: millis(s.now)}
+	for _, pair := range s.pairs {
+		asset := s.wallet.AssetsInfo(pair)
+		info.Symbols = append(info.Symbols, futures.Symbol{
+			Symbol:             pair,
+			Pair:               pair,
+			ContractType:       futures.ContractTypePerpetual,
+			Status:             "TRADING",
+			BaseAsset:          asset.BaseAsset,
+			BaseAssetPrecision: asset.BaseAssetPrecision,
+			QuoteAsset:         asset.QuoteAsset,
+			QuotePrecision:     asset.QuotePrecision,
+			MarginAsset:        asset.QuoteAsset,
+			Filters:            symbolFilters(asset),
+		})
+	}
+	return info, nil
+}
+
+// futuresPremiumIndex 返回合约交易对的标记价格。
+func (s *Server) futuresPremiumIndex(params url.Values) (interface{}, error) {
+	pair := params.Get("symbol")
+	candles, ok := s.candles[pair]
+	if !ok || s.cursor[pair] == 0 {
+		return nil, errInvalidSymbol
+	}
+
+	last := candles[s.cursor[pair]-1]
+	return futures.PremiumIndex{
+		Symbol:    pair,
+		MarkPrice: formatFloat(last.Close),
+		Time:      millis(s.now),
+	}, nil
+}
+
+// futuresCreateOrder 合约下单。
+func (s *Server) futuresCreateOrder(params url.Values) (interface{}, error) {
+	order, err := s.createOrder(params)
+	if err != nil {
+		return nil, err
+	}
+	return futuresOrderResponse(order), nil
+}
+
+// futuresGetOrder 查询合约订单。
+func (s *Server) futuresGetOrder(params url.Values) (interface{}, error) {
+	order, err := s.findOrder(params)
+	if err != nil {
+		return nil, err
+	}
+	return futuresOrder(order), nil
+}
+
+// futuresCancelOrder 撤销合约订单。
+func (s *Server) futuresCancelOrder(params url.Values) (interface{}, error) {
+	order, err := s.cancelOrder(params)
+	if err != nil {
+		return nil, err
+	}
+	return futuresOrderResponse(order), nil
+}
+
+// futuresListOrders 列出合约交易对最近的订单。
+func (s *Server) futuresListOrders(params url.Values) (interface{}, error) {
+	orders, err := s.listOrders(params)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*futures.Order, 0, len(orders))
+	for _, order := range orders {
+		result = append(result, futuresOrder(order))
+	}
+	return result, nil
+}
+
+// futuresAccount 返回合约账户，交易对基础资产的余额作为持仓，其他资产作为保证金资产。
+func (s *Server) futuresAccount(_ url.Values) (interface{}, error) {
+	account, err := s.wallet.Account()
+	if err != nil {
+		return nil, err
+	}
+
+	result := futures.Account{
+		CanTrade:   true,
+		UpdateTime: millis(s.now),
+		Assets:     make([]*futures.AccountAsset, 0),
+		Positions:  make([]*futures.AccountPosition, 0),
+	}
+
+	positions := make(map[string]string, len(s.pairs))
+	for _, pair := range s.pairs {
+		asset, _ := exchange.SplitAssetQuote(pair)
+		positions[asset] = pair
+	}
+
+	for _, balance := range account.Balances {
+		total := formatFloat(balance.Free + balance.Lock)
+		pair, ok := positions[balance.Asset]
+		if !ok {
+			result.Assets = append(result.Assets, &futures.AccountAsset{
+				Asset:            balance.Asset,
+				WalletBalance:    total,
+				MarginBalance:    total,
+				AvailableBalance: formatFloat(balance.Free),
+				UpdateTime:       millis(s.now),
+			})
+			continue
+		}
+
+		leverage, ok := s.leverage[pair]
+		if !ok {
+			leverage = 1
+		}
+		result.Positions = append(result.Positions, &futures.AccountPosition{
+			Symbol:       pair,
+			Leverage:     strconv.Itoa(leverage),
+			PositionSide: futures.PositionSideTypeBoth,
+			PositionAmt:  total,
+			MaxNotional:  maxNotional,
+			UpdateTime:   millis(s.now),
+		})
+	}
+	return result, nil
+}
+
+// futuresPositionRisk 返回合约持仓风险，开仓均价、未实现盈亏和强平价来自PaperWallet，杠杆倍数只是记录下来的值。
+func (s *Server) futuresPositionRisk(params url.Values) (interface{}, error) {
+	pairs := s.pairs
+	if pair := params.Get("symbol"); pair != "" {
+		if _, ok := s.candles[pair]; !ok {
+			return nil, errInvalidSymbol
+		}
+		pairs = []string{pair}
+	}
+
+	result := make([]*futures.PositionRisk, 0, len(pairs))
+	for _, pair := range pairs {
+		position, err := s.wallet.PositionInfo(pair)
+		if err != nil {
+			return nil, err
+		}
+
+		leverage, ok := s.leverage[pair]
+		if !ok {
+			leverage = 1
+		}
+		result = append(result, &futures.PositionRisk{
+			Symbol:           pair,
+			PositionAmt:      formatFloat(position.Size()),
+			EntryPrice:       formatFloat(position.EntryPrice),
+			MarkPrice:        formatFloat(position.MarkPrice),
+			UnRealizedProfit: formatFloat(position.UnrealizedPnL),
+			LiquidationPrice: formatFloat(position.LiquidationPrice),
+			Leverage:         strconv.Itoa(leverage),
+			MarginType:       "cross",
+			IsolatedMargin:   "0",
+			MaxNotionalValue: maxNotional,
+			PositionSide:     string(futures.PositionSideTypeBoth),
+			Notional:         formatFloat(position.Size() * position.MarkPrice),
+		})
+	}
+	return result, nil
+}
+
+// changeLeverage 记录交易对的杠杆倍数。
+func (s *Server) changeLeverage(params url.Values) (interface{}, error) {
+	pair := params.Get("symbol")
+	if _, ok := s.candles[pair]; !ok {
+		return nil, errInvalidSymbol
+	}
+
+	leverage := intParam(params, "leverage", 1)
+	s.leverage[pair] = leverage
+	return futures.SymbolLeverage{Leverage: leverage, MaxNotionalValue: maxNotional, Symbol: pair}, nil
+}
+
+// changeMarginType 修改保证金模式，模拟器不区分全仓和逐仓。
+func (s *Server) changeMarginType(params url.Values) (interface{}, error) {
+	if _, ok := s.candles[params.Get("symbol")]; !ok {
+		return nil, errInvalidSymbol
+	}
+	return map[string]interface{}{"code": 200, "msg": "success"}, nil
+}
+
+// changePositionMode 修改持仓模式，模拟器只记录订单的持仓方向，不区分单向和双向持仓。
+func (s *Server) changePositionMode(_ url.Values) (interface{}, error) {
+	return map[string]interface{}{"code": 200, "msg": "success"}, nil
+}
+
+// futuresOrderResponse 将订单转换为合约下单接口的返回格式。
+func futuresOrderResponse(order model.Order) futures.CreateOrderResponse {
+	quantity, cost := executed(order)
+	return futures.CreateOrderResponse{
+		Symbol:           order.Pair,
+		OrderID:          order.ExchangeID,
+		ClientOrderID:    order.ClientOrderID,
+		Price:            formatFloat(order.Price),
+		OrigQuantity:     formatFloat(order.Quantity),
+		ExecutedQuantity: formatFloat(quantity),
+		CumQuote:         formatFloat(cost),
+		Status:           futures.OrderStatusType(order.Status),
+		StopPrice:        formatFloat(stopPrice(order)),
+		TimeInForce:      futures.TimeInForceType(order.TimeInForce),
+		Type:             futures.OrderType(order.Type),
+		Side:             futures.SideType(order.Side),
+		UpdateTime:       millis(order.UpdatedAt),
+		AvgPrice:         averagePrice(order),
+		PositionSide:     positionSide(order),
+	}
+}
+
+// futuresOrder 将订单转换为合约订单接口的格式。
+func futuresOrder(order model.Order) *futures.Order {
+	quantity, cost := executed(order)
+	return &futures.Order{
+		Symbol:           order.Pair,
+		OrderID:          order.ExchangeID,
+		ClientOrderID:    order.ClientOrderID,
+		Price:            formatFloat(order.Price),
+		OrigQuantity:     formatFloat(order.Quantity),
+		ExecutedQuantity: formatFloat(quantity),
+		CumQuantity:      formatFloat(quantity),
+		CumQuote:         formatFloat(cost),
+		Status:           futures.OrderStatusType(order.Status),
+		TimeInForce:      futures.TimeInForceType(order.TimeInForce),
+		Type:             futures.OrderType(order.Type),
+		Side:             futures.SideType(order.Side),
+		StopPrice:        formatFloat(stopPrice(order)),
+		Time:             millis(order.CreatedAt),
+		UpdateTime:       millis(order.UpdatedAt),
+		AvgPrice:         averagePrice(order),
+		OrigType:         string(order.Type),
+		PositionSide:     positionSide(order),
+	}
+}
+
+// futuresOrderEvent 将订单转换为合约用户数据流的订单更新事件。
+func futuresOrderEvent(order model.Order, now time.Time) map[string]interface{} {
+	quantity, _ := executed(order)
+	return map[string]interface{}{
+		"e": string(futures.UserDataEventTypeOrderTradeUpdate),
+		"E": millis(now),
+		"T": millis(order.UpdatedAt),
+		"o": map[string]interface{}{
+			"s":  order.Pair,
+			"c":  order.ClientOrderID,
+			"S":  string(order.Side),
+			"o":  string(order.Type),
+			"f":  string(order.TimeInForce),
+			"q":  formatFloat(order.Quantity),
+			"p":  formatFloat(order.Price),
+			"ap": averagePrice(order),
+			"sp": formatFloat(stopPrice(order)),
+			"x":  executionType(order),
+			"X":  string(order.Status),
+			"i":  order.ExchangeID,
+			"z":  formatFloat(quantity),
+			"T":  millis(order.UpdatedAt),
+			"ot": string(order.Type),
+			"ps": string(positionSide(order)),
+		},
+	}
+}
+
+// positionSide 返回订单的持仓方向，没有指定持仓方向的订单属于单向持仓（BOTH）。
+func positionSide(order model.Order) futures.PositionSideType {
+	if order.PositionSide == "" {
+		return futures.PositionSideTypeBoth
+	}
+	return futures.PositionSideType(order.PositionSide)
+}
+
+// averagePrice 返回订单的成交均价，未成交时返回0。
+func averagePrice(order model.Order) string {
+	quantity, cost := executed(order)
+	if quantity == 0 {
+		return "0"
+	}
+	return formatFloat(cost / quantity)
+}
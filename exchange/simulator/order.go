@@ -0,0 +1,251 @@
+package simulator
+
+import (
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/adshao/go-binance/v2/common"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+// 与币安一致的错误码，适配器可以像处理真实交易所的错误一样处理模拟器的错误。
+var (
+	errInvalidSymbol    = &common.APIError{Code: -1121, Message: "Invalid symbol."}
+	errInvalidOrderType = &common.APIError{Code: -1116, Message: "Invalid orderType."}
+	errUnknownOrder     = &common.APIError{Code: -2011, Message: "Unknown order sent."}
+	errOrderNotExist    = &common.APIError{Code: -2013, Message: "Order does not exist."}
+)
+
+// rejected 将PaperWallet拒绝下单的错误转换为币安的下单失败错误。
+func rejected(err error) error {
+	return &common.APIError{Code: -2010, Message: err.Error()}
+}
+
+// createOrder 根据币安的下单参数调用PaperWallet下单，现货和合约共用。
+func (s *Server) createOrder(params url.Values) (model.Order, error) {
+	pair := params.Get("symbol")
+	if _, ok := s.candles[pair]; !ok {
+		return model.Order{}, errInvalidSymbol
+	}
+
+	side := model.SideType(params.Get("side"))
+	quantity := floatParam(params, "quantity")
+	price := floatParam(params, "price")
+
+	// 合约的closePosition订单平掉整个仓位，数量为当前持仓的数量
+	if params.Get("closePosition") == "true" {
+		position, _, err := s.wallet.Position(pair)
+		if err != nil {
+			return model.Order{}, rejected(err)
+		}
+		quantity = math.Abs(position)
+	}
+
+	options := make([]model.OrderOption, 0)
+	if id := params.Get("newClientOrderId"); id != "" {
+		options = append(options, model.WithClientOrderID(id))
+	}
+	if timeInForce := params.Get("timeInForce"); timeInForce != "" {
+		options = append(options, model.WithTimeInForce(model.TimeInForceType(timeInForce)))
+	}
+	// 双向持仓模式的持仓方向只记录在订单上，PaperWallet仍然按净持仓撮合
+	if side := model.PositionSideType(params.Get("positionSide")); side == model.PositionSideTypeLong ||
+		side == model.PositionSideTypeShort {
+		options = append(options, model.WithPositionSide(side))
+	}
+
+	var (
+		order model.Order
+		err   error
+	)
+	switch model.OrderType(params.Get("type")) {
+	case model.OrderTypeLimit:
+		order, err = s.wallet.CreateOrderLimit(side, pair, quantity, price, options...)
+	case model.OrderTypeLimitMaker:
+		order, err = s.wallet.CreateOrderLimit(side, pair, quantity, price, append(options, model.WithPostOnly())...)
+	case model.OrderTypeMarket:
+		if quote := params.Get("quoteOrderQty"); quote != "" {
+			order, err = s.wallet.CreateOrderMarketQuote(side, pair, floatParam(params, "quoteOrderQty"), options...)
+		} else {
+			order, err = s.wallet.CreateOrderMarket(side, pair, quantity, options...)
+		}
+	case model.OrderTypeStopLoss, model.OrderTypeStopLossLimit, model.OrderType("STOP_MARKET"):
+		// PaperWallet只支持卖出止损单，触发价优先使用stopPrice
+		if side != model.SideTypeSell {
+			return model.Order{}, &common.APIError{Code: -2010, Message: "Only sell stop orders are supported."}
+		}
+		stop := floatParam(params, "stopPrice")
+		if stop == 0 {
+			stop = price
+		}
+		order, err = s.wallet.CreateOrderStop(pair, quantity, stop, options...)
+	case model.OrderType("TAKE_PROFIT_MARKET"):
+		// PaperWallet没有止盈单，以触发价挂限价单模拟，价格到达触发价时成交
+		order, err = s.wallet.CreateOrderLimit(side, pair, quantity, floatParam(params, "stopPrice"), options...)
+	default:
+		return model.Order{}, errInvalidOrderType
+	}
+	if err != nil {
+		return model.Order{}, rejected(err)
+	}
+	return order, nil
+}
+
+// findOrder 根据orderId或origClientOrderId查找订单。
+func (s *Server) findOrder(params url.Values) (model.Order, error) {
+	pair := params.Get("symbol")
+	if _, ok := s.candles[pair]; !ok {
+		return model.Order{}, errInvalidSymbol
+	}
+
+	if id := params.Get("orderId"); id != "" {
+		exchangeID, _ := strconv.ParseInt(id, 10, 64)
+		order, err := s.wallet.Order(pair, exchangeID)
+		if err != nil || order.Pair != pair {
+			return model.Order{}, errOrderNotExist
+		}
+		return order, nil
+	}
+
+	clientOrderID := params.Get("origClientOrderId")
+	orders, _ := s.wallet.Orders(pair, 0)
+	for _, order := range orders {
+		if clientOrderID != "" && order.ClientOrderID == clientOrderID {
+			return order, nil
+		}
+	}
+	return model.Order{}, errOrderNotExist
+}
+
+// cancelOrder 撤销挂单，已经结束的订单与币安一样返回Unknown order错误。
+func (s *Server) cancelOrder(params url.Values) (model.Order, error) {
+	order, err := s.findOrder(params)
+	if err != nil {
+		return model.Order{}, errUnknownOrder
+	}
+	if order.Status != model.OrderStatusTypeNew && order.Status != model.OrderStatusTypePartiallyFilled {
+		return model.Order{}, errUnknownOrder
+	}
+
+	if err := s.wallet.Cancel(order); err != nil {
+		return model.Order{}, rejected(err)
+	}
+	return s.wallet.Order(order.Pair, order.ExchangeID)
+}
+
+// listOrders 返回交易对最近的订单。
+func (s *Server) listOrders(params url.Values) ([]model.Order, error) {
+	pair := params.Get("symbol")
+	if _, ok := s.candles[pair]; !ok {
+		return nil, errInvalidSymbol
+	}
+	return s.wallet.Orders(pair, intParam(params, "limit", defaultLimit))
+}
+
+// executed 返回订单的成交数量和成交金额，PaperWallet的订单只有全部成交和未成交两种情况。
+func executed(order model.Order) (quantity, cost float64) {
+	if order.Status != model.OrderStatusTypeFilled {
+		return 0, 0
+	}
+	return order.Quantity, order.Quantity * order.Price
+}
+
+// executionType 返回订单状态对应的执行类型。
+func executionType(order model.Order) string {
+	switch order.Status {
+	case model.OrderStatusTypeFilled, model.OrderStatusTypePartiallyFilled:
+		return "TRADE"
+	case model.OrderStatusTypeCanceled:
+		return "CANCELED"
+	case model.OrderStatusTypeExpired:
+		return "EXPIRED"
+	case model.OrderStatusTypeRejected:
+		return "REJECTED"
+	default:
+		return "NEW"
+	}
+}
+
+// stopPrice 返回订单的触发价，没有触发价时返回0。
+func stopPrice(order model.Order) float64 {
+	if order.Stop == nil {
+		return 0
+	}
+	return *order.Stop
+}
+
+// kline 将K线转换为币安K线接口返回的数组格式。
+func kline(candle model.Candle, duration time.Duration) []interface{} {
+	return []interface{}{
+		millis(candle.Time),
+		formatFloat(candle.Open),
+		formatFloat(candle.High),
+		formatFloat(candle.Low),
+		formatFloat(candle.Close),
+		formatFloat(candle.Volume),
+		millis(candle.Time.Add(duration)) - 1,
+		formatFloat(candle.Volume * candle.Close),
+		0,
+		"0",
+		"0",
+		"0",
+	}
+}
+
+// klineEvent 将已完成的K线转换为WebSocket推送的K线事件。
+func klineEvent(candle model.Candle, timeframe string, duration time.Duration) map[string]interface{} {
+	return map[string]interface{}{
+		"e": "kline",
+		"E": millis(candle.Time.Add(duration)),
+		"s": candle.Pair,
+		"k": map[string]interface{}{
+			"t": millis(candle.Time),
+			"T": millis(candle.Time.Add(duration)) - 1,
+			"s": candle.Pair,
+			"i": timeframe,
+			"o": formatFloat(candle.Open),
+			"c": formatFloat(candle.Close),
+			"h": formatFloat(candle.High),
+			"l": formatFloat(candle.Low),
+			"v": formatFloat(candle.Volume),
+			"x": true,
+		},
+	}
+}
+
+// floatParam 解析浮点数参数，参数不存在或格式错误时返回0。
+func floatParam(params url.Values, key string) float64 {
+	value, _ := strconv.ParseFloat(params.Get(key), 64)
+	return value
+}
+
+// intParam 解析整数参数，参数不存在或不是正数时返回默认值。
+func intParam(params url.Values, key string, fallback int) int {
+	value, err := strconv.Atoi(params.Get(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// timeParam 解析毫秒时间戳参数，参数不存在时返回默认值。
+func timeParam(params url.Values, key string, fallback time.Time) time.Time {
+	value, err := strconv.ParseInt(params.Get(key), 10, 64)
+	if err != nil {
+		return fallback
+	}
+	return time.Unix(0, value*int64(time.Millisecond))
+}
+
+// millis 返回毫秒时间戳。
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// formatFloat 将浮点数格式化为币安接口使用的字符串。
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,425 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/gorilla/websocket"
+	"github.com/xhit/go-str2duration/v2"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/tools/log"
+)
+
+/*
+Server 是一个本地的币安交易所模拟器，实现了NewBinance和NewBinanceFuture用到的现货/合约REST接口，
+以及K线和用户数据的WebSocket推送。撮合和余额由PaperWallet负责，行情来自CSV数据源。
+真实的适配器通过WithBinanceEndpoint/WithBinanceFutureEndpoint连接模拟器，不需要访问网络：
+
+	sim, _ := simulator.New(wallet, feed, "1h")
+	server := httptest.NewServer(sim)
+	binance, _ := exchange.NewBinance(ctx, exchange.WithBinanceEndpoint(server.URL, simulator.WsURL(server.URL)))
+
+模拟器不会自己推进时间，每调用一次Next推送下一根K线，Run按固定间隔推进，适合在预发环境中使用。
+请求的签名不做校验。
+*/
+
+// ErrNoData 表示数据源中没有指定时间周期的K线。
+var ErrNoData = errors.New("simulator: no candles for timeframe")
+
+const (
+	wsPath         = "/ws/"           // WebSocket连接的路径前缀
+	wsWriteTimeout = 5 * time.Second  // WebSocket写超时，避免不读取消息的客户端阻塞模拟器
+	defaultLimit   = 500              // K线和订单列表的默认数量，与币安一致
+	maxNotional    = "1000000000.000" // 合约杠杆接口返回的最大名义价值
+)
+
+// market 区分现货和合约接口，两者的请求和推送格式不同。
+type market int
+
+const (
+	marketSpot market = iota
+	marketFutures
+)
+
+// route 处理一个REST接口，返回的结果会被编码为JSON。
+type route func(params url.Values) (interface{}, error)
+
+// Server 是币安交易所模拟器，实现了http.Handler，可以用httptest.NewServer或http.ListenAndServe启动。
+type Server struct {
+	mtx       sync.Mutex
+	wallet    *exchange.PaperWallet
+	timeframe string
+	duration  time.Duration
+	pairs     []string
+	candles   map[string][]model.Candle // 每个交易对的全部K线
+	cursor    map[string]int            // 每个交易对已经推送的K线数量
+	now       time.Time                 // 模拟器的当前时间，即最后一根推送K线的时间
+
+	routes     map[string]route                    // 以"METHOD /path"为键的REST接口
+	leverage   map[string]int                      // 合约交易对的杠杆倍数
+	listenKeys map[string]market                   // 用户数据流的listenKey
+	conns      map[string]map[*websocket.Conn]bool // 以stream或listenKey为键的WebSocket连接
+	published  map[int64]model.Order               // 已经推送过的订单状态，用于判断订单是否有变化
+	keyCounter int64
+	upgrader   websocket.Upgrader
+}
+
+// New 创建模拟器，wallet负责撮合和余额，feed提供timeframe周期的K线，feed中的每个交易对都可以交易。
+func New(wallet *exchange.PaperWallet, feed *exchange.CSVFeed, timeframe string) (*Server, error) {
+	duration, err := str2duration.ParseDuration(timeframe)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		wallet:     wallet,
+		timeframe:  timeframe,
+		duration:   duration,
+		candles:    make(map[string][]model.Candle),
+		cursor:     make(map[string]int),
+		leverage:   make(map[string]int),
+		listenKeys: make(map[string]market),
+		conns:      make(map[string]map[*websocket.Conn]bool),
+		published:  make(map[int64]model.Order),
+	}
+
+	for pair := range feed.Feeds {
+		candles, err := feed.CandlesByPeriod(context.Background(), pair, timeframe, time.Time{}, time.Unix(1<<40, 0))
+		if err != nil {
+			return nil, err
+		}
+		if len(candles) == 0 {
+			return nil, fmt.Errorf("%w: %s %s", ErrNoData, pair, timeframe)
+		}
+		s.pairs = append(s.pairs, pair)
+		s.candles[pair] = candles
+	}
+	sort.Strings(s.pairs)
+
+	s.routes = map[string]route{
+		"GET /api/v3/ping":              s.ping,
+		"GET /api/v3/exchangeInfo":      s.spotExchangeInfo,
+		"GET /api/v3/klines":            s.klines,
+		"POST /api/v3/order":            s.spotCreateOrder,
+		"GET /api/v3/order":             s.spotGetOrder,
+		"DELETE /api/v3/order":          s.spotCancelOrder,
+		"POST /api/v3/order/oco":        s.spotCreateOCO,
+		"GET /api/v3/allOrders":         s.spotListOrders,
+		"GET /api/v3/account":           s.spotAccount,
+		"POST /api/v3/userDataStream":   s.startUserStream(marketSpot),
+		"PUT /api/v3/userDataStream":    s.keepaliveUserStream,
+		"DELETE /api/v3/userDataStream": s.closeUserStream,
+
+		"GET /fapi/v1/ping":               s.ping,
+		"GET /fapi/v1/exchangeInfo":       s.futuresExchangeInfo,
+		"GET /fapi/v1/klines":             s.klines,
+		"GET /fapi/v1/premiumIndex":       s.futuresPremiumIndex,
+		"POST /fapi/v1/order":             s.futuresCreateOrder,
+		"GET /fapi/v1/order":              s.futuresGetOrder,
+		"DELETE /fapi/v1/order":           s.futuresCancelOrder,
+		"GET /fapi/v1/allOrders":          s.futuresListOrders,
+		"GET /fapi/v2/account":            s.futuresAccount,
+		"GET /fapi/v2/positionRisk":       s.futuresPositionRisk,
+		"POST /fapi/v1/leverage":          s.changeLeverage,
+		"POST /fapi/v1/marginType":        s.changeMarginType,
+		"POST /fapi/v1/positionSide/dual": s.changePositionMode,
+		"POST /fapi/v1/listenKey":         s.startUserStream(marketFutures),
+		"PUT /fapi/v1/listenKey":          s.keepaliveUserStream,
+		"DELETE /fapi/v1/listenKey":       s.closeUserStream,
+	}
+
+	return s, nil
+}
+
+// WsURL 根据模拟器的HTTP地址返回WebSocket地址，用于WithBinanceEndpoint的wsURL参数。
+func WsURL(serverURL string) string {
+	return "ws" + strings.TrimPrefix(serverURL, "http") + strings.TrimSuffix(wsPath, "/")
+}
+
+// Next 为每个交易对推送下一根K线：先交给PaperWallet撮合挂单，再推送K线和订单变化。
+// 所有交易对的数据都推送完后返回false。
+func (s *Server) Next() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	advanced := false
+	for _, pair := range s.pairs {
+		index := s.cursor[pair]
+		if index >= len(s.candles[pair]) {
+			continue
+		}
+
+		candle := s.candles[pair][index]
+		s.cursor[pair] = index + 1
+		if candle.Time.After(s.now) {
+			s.now = candle.Time
+		}
+		advanced = true
+
+		s.wallet.OnCandle(candle)
+		s.publishKline(candle)
+	}
+
+	s.publishOrders()
+	return advanced
+}
+
+// Run 每隔interval推送一次K线，直到数据用完或者ctx被取消。
+func (s *Server) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.Next() {
+				return
+			}
+		}
+	}
+}
+
+// Connections 返回当前的WebSocket连接数量，测试中可以用来等待客户端完成订阅。
+func (s *Server) Connections() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var count int
+	for _, conns := range s.conns {
+		count += len(conns)
+	}
+	return count
+}
+
+// Close 断开所有WebSocket连接。
+func (s *Server) Close() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for stream, conns := range s.conns {
+		for conn := range conns {
+			_ = conn.Close()
+		}
+		delete(s.conns, stream)
+	}
+}
+
+// ServeHTTP 处理REST请求和WebSocket连接。
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, wsPath) {
+		s.serveWs(w, r, strings.TrimPrefix(r.URL.Path, wsPath))
+		return
+	}
+
+	params, err := parseParams(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, &common.APIError{Code: -1100, Message: err.Error()})
+		return
+	}
+
+	handler, ok := s.routes[r.Method+" "+r.URL.Path]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, &common.APIError{Code: -1000, Message: "Unknown endpoint."})
+		return
+	}
+
+	s.mtx.Lock()
+	result, err := handler(params)
+	// 下单和撤单都可能改变订单状态，每个请求之后推送变化的订单
+	s.publishOrders()
+	s.mtx.Unlock()
+
+	if err != nil {
+		var apiErr *common.APIError
+		if !errors.As(err, &apiErr) {
+			apiErr = &common.APIError{Code: -1000, Message: err.Error()}
+		}
+		writeJSON(w, http.StatusBadRequest, apiErr)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// serveWs 建立WebSocket连接，stream为K线流（如btcusdt@kline_1h）或者用户数据流的listenKey。
+func (s *Server) serveWs(w http.ResponseWriter, r *http.Request, stream string) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Errorf("simulator: websocket upgrade: %v", err)
+		return
+	}
+
+	s.mtx.Lock()
+	if s.conns[stream] == nil {
+		s.conns[stream] = make(map[*websocket.Conn]bool)
+	}
+	s.conns[stream][conn] = true
+	s.mtx.Unlock()
+
+	// 客户端不会发送消息，读取只是为了发现连接断开
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	s.mtx.Lock()
+	delete(s.conns[stream], conn)
+	s.mtx.Unlock()
+	_ = conn.Close()
+}
+
+// broadcast 向stream的所有连接发送消息，写入失败的连接会被断开。调用方需要持有锁。
+func (s *Server) broadcast(stream string, message interface{}) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Errorf("simulator: encode message: %v", err)
+		return
+	}
+
+	for conn := range s.conns[stream] {
+		_ = conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			delete(s.conns[stream], conn)
+			_ = conn.Close()
+		}
+	}
+}
+
+// publishKline 推送一根已完成的K线，现货和合约的K线推送格式相同。
+func (s *Server) publishKline(candle model.Candle) {
+	stream := fmt.Sprintf("%s@kline_%s", strings.ToLower(candle.Pair), s.timeframe)
+	s.broadcast(stream, klineEvent(candle, s.timeframe, s.duration))
+}
+
+// publishOrders 推送状态发生变化的订单，新订单也会推送一次，与币安的执行回报一致。调用方需要持有锁。
+func (s *Server) publishOrders() {
+	for _, pair := range s.pairs {
+		orders, _ := s.wallet.Orders(pair, 0)
+		for _, order := range orders {
+			last, ok := s.published[order.ExchangeID]
+			if ok && last.Status == order.Status && last.Quantity == order.Quantity && last.Price == order.Price {
+				continue
+			}
+			s.published[order.ExchangeID] = order
+
+			for key, market := range s.listenKeys {
+				if market == marketSpot {
+					s.broadcast(key, spotOrderEvent(order, s.now))
+				} else {
+					s.broadcast(key, futuresOrderEvent(order, s.now))
+				}
+			}
+		}
+	}
+}
+
+// ping 用于检查连接。
+func (s *Server) ping(_ url.Values) (interface{}, error) {
+	return struct{}{}, nil
+}
+
+// klines 返回已经推送过的K线，与币安一样，没有指定结束时间时最后一根是未完成的K线。
+func (s *Server) klines(params url.Values) (interface{}, error) {
+	pair := params.Get("symbol")
+	candles, ok := s.candles[pair]
+	if !ok {
+		return nil, errInvalidSymbol
+	}
+	if params.Get("interval") != s.timeframe {
+		return nil, &common.APIError{Code: -1120, Message: "Invalid interval."}
+	}
+
+	start := timeParam(params, "startTime", time.Time{})
+	end := timeParam(params, "endTime", s.now)
+	limit := intParam(params, "limit", defaultLimit)
+
+	result := make([][]interface{}, 0)
+	for _, candle := range candles[:s.cursor[pair]] {
+		if candle.Time.Before(start) || candle.Time.After(end) {
+			continue
+		}
+		result = append(result, kline(candle, s.duration))
+	}
+
+	// 未完成的K线只包含上一根K线的收盘价，不会泄露之后的行情
+	if params.Get("endTime") == "" && s.cursor[pair] > 0 {
+		last := candles[s.cursor[pair]-1]
+		result = append(result, kline(model.Candle{
+			Time:  last.Time.Add(s.duration),
+			Open:  last.Close,
+			Close: last.Close,
+			High:  last.Close,
+			Low:   last.Close,
+		}, s.duration))
+	}
+
+	if len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+	return result, nil
+}
+
+// startUserStream 创建用户数据流的listenKey。
+func (s *Server) startUserStream(market market) route {
+	return func(_ url.Values) (interface{}, error) {
+		s.keyCounter++
+		key := fmt.Sprintf("simulator-listen-key-%d", s.keyCounter)
+		s.listenKeys[key] = market
+		return map[string]string{"listenKey": key}, nil
+	}
+}
+
+// keepaliveUserStream 续期listenKey，模拟器中的listenKey不会过期。
+func (s *Server) keepaliveUserStream(params url.Values) (interface{}, error) {
+	if _, ok := s.listenKeys[params.Get("listenKey")]; !ok {
+		return nil, &common.APIError{Code: -1125, Message: "This listenKey does not exist."}
+	}
+	return struct{}{}, nil
+}
+
+// closeUserStream 关闭用户数据流。
+func (s *Server) closeUserStream(params url.Values) (interface{}, error) {
+	delete(s.listenKeys, params.Get("listenKey"))
+	return struct{}{}, nil
+}
+
+// parseParams 合并URL中的参数和表单中的参数，币安的签名接口把参数放在请求体中，包括DELETE请求。
+func parseParams(r *http.Request) (url.Values, error) {
+	params := r.URL.Query()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range form {
+		params[key] = append(params[key], values...)
+	}
+	return params, nil
+}
+
+// writeJSON 将value编码为JSON写入响应。
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Errorf("simulator: encode response: %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package simulator
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+func newTestServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+
+	feed, err := exchange.NewCSVFeed("1h", exchange.PairFeed{
+		Pair:      "BTCUSDT",
+		File:      "../../testdata/btc-1h.csv",
+		Timeframe: "1h",
+	})
+	require.NoError(t, err)
+
+	wallet := exchange.NewPaperWallet(context.Background(), "USDT", exchange.WithPaperAsset("USDT", 100000))
+	sim, err := New(wallet, feed, "1h")
+	require.NoError(t, err)
+
+	server := httptest.NewServer(sim)
+	t.Cleanup(func() {
+		sim.Close()
+		server.Close()
+	})
+	return sim, server
+}
+
+func TestServer_Binance(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sim, server := newTestServer(t)
+	for i := 0; i < 3; i++ {
+		require.True(t, sim.Next())
+	}
+
+	binance, err := exchange.NewBinance(ctx, exchange.WithBinanceEndpoint(server.URL, WsURL(server.URL)))
+	require.NoError(t, err)
+	assert.Equal(t, "BTC", binance.AssetsInfo("BTCUSDT").BaseAsset)
+
+	t.Run("candles", func(t *testing.T) {
+		candles, err := binance.CandlesByLimit(ctx, "BTCUSDT", "1h", 2)
+		require.NoError(t, err)
+		require.Len(t, candles, 2)
+		assert.Equal(t, sim.candles["BTCUSDT"][1].Time, candles[0].Time.UTC())
+		assert.Equal(t, sim.candles["BTCUSDT"][2].Close, candles[1].Close)
+
+		_, err = binance.CandlesByLimit(ctx, "BTCUSDT", "1m", 2)
+		require.Error(t, err)
+	})
+
+	t.Run("orders", func(t *testing.T) {
+		price := sim.candles["BTCUSDT"][2].Close
+
+		order, err := binance.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 1)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeFilled, order.Status)
+		assert.Equal(t, price, order.Price)
+
+		asset, quote, err := binance.Position("BTCUSDT")
+		require.NoError(t, err)
+		assert.Equal(t, 1.0, asset)
+		assert.InDelta(t, 100000-price, quote, 1e-6)
+
+		limit, err := binance.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 0.5, price/2,
+			model.WithClientOrderID("sim-limit"))
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeNew, limit.Status)
+		assert.Equal(t, "sim-limit", limit.ClientOrderID)
+
+		found, err := binance.Order("BTCUSDT", limit.ExchangeID)
+		require.NoError(t, err)
+		assert.Equal(t, price/2, found.Price)
+
+		orders, err := binance.Orders("BTCUSDT", 10)
+		require.NoError(t, err)
+		require.Len(t, orders, 2)
+
+		require.NoError(t, binance.Cancel(limit))
+		found, err = binance.Order("BTCUSDT", limit.ExchangeID)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeCanceled, found.Status)
+
+		// 已经撤销的订单再次撤单，返回与币安相同的错误码
+		err = binance.Cancel(limit)
+		var apiErr *common.APIError
+		require.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, errUnknownOrder.Code, apiErr.Code)
+
+		_, err = binance.Order("BTCUSDT", 999)
+		require.Error(t, err)
+
+		oco, err := binance.CreateOrderOCO(model.SideTypeSell, "BTCUSDT", 1, price*2, price/2, price/2)
+		require.NoError(t, err)
+		require.Len(t, oco, 2)
+		assert.Equal(t, *oco[0].GroupID, *oco[1].GroupID)
+	})
+
+	t.Run("subscriptions", func(t *testing.T) {
+		candles, _ := binance.CandlesSubscription(ctx, "BTCUSDT", "1h")
+		updates, _ := binance.OrderSubscription(ctx)
+		require.Eventually(t, func() bool {
+			return sim.Connections() == 2
+		}, time.Second, 10*time.Millisecond)
+
+		// 下单后交易所推送新订单
+		next := sim.candles["BTCUSDT"][3]
+		order, err := binance.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 0.1, next.Close)
+		require.NoError(t, err)
+		update := <-updates
+		assert.Equal(t, order.ExchangeID, update.ExchangeID)
+		assert.Equal(t, model.OrderStatusTypeNew, update.Status)
+
+		// 下一根K线的收盘价不高于限价，订单成交
+		go sim.Next()
+		candle := <-candles
+		assert.True(t, candle.Complete)
+		assert.Equal(t, next.Close, candle.Close)
+		assert.Equal(t, next.Time, candle.Time.UTC())
+
+		update = <-updates
+		assert.Equal(t, order.ExchangeID, update.ExchangeID)
+		assert.Equal(t, model.OrderStatusTypeFilled, update.Status)
+		assert.Equal(t, 0.1, update.Quantity)
+	})
+}
+
+func TestServer_BinanceFuture(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sim, server := newTestServer(t)
+	require.True(t, sim.Next())
+
+	binance, err := exchange.NewBinanceFuture(ctx,
+		exchange.WithBinanceFutureEndpoint(server.URL, WsURL(server.URL)),
+		exchange.WithBinanceFutureLeverage("BTCUSDT", 5, exchange.MarginTypeIsolated))
+	require.NoError(t, err)
+
+	price := sim.candles["BTCUSDT"][0].Close
+	order, err := binance.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 2)
+	require.NoError(t, err)
+	assert.Equal(t, model.OrderStatusTypeFilled, order.Status)
+	assert.Equal(t, price, order.Price)
+
+	account, err := binance.Account()
+	require.NoError(t, err)
+	asset, quote := account.Balance("BTC", "USDT")
+	assert.Equal(t, 2.0, asset.Free)
+	assert.Equal(t, 5.0, asset.Leverage)
+	assert.InDelta(t, 100000-2*price, quote.Free, 1e-6)
+
+	limit, err := binance.CreateOrderLimit(model.SideTypeSell, "BTCUSDT", 1, price*2)
+	require.NoError(t, err)
+	require.NoError(t, binance.Cancel(limit))
+
+	orders, err := binance.Orders("BTCUSDT", 10)
+	require.NoError(t, err)
+	require.Len(t, orders, 2)
+	assert.Equal(t, model.OrderStatusTypeCanceled, orders[1].Status)
+
+	t.Run("position info", func(t *testing.T) {
+		position, err := binance.PositionInfo("BTCUSDT")
+		require.NoError(t, err)
+		assert.Equal(t, model.PositionSideTypeLong, position.Side)
+		assert.Equal(t, 2.0, position.Quantity)
+		assert.Equal(t, price, position.EntryPrice)
+		assert.Equal(t, price, position.MarkPrice)
+		assert.Equal(t, 5.0, position.Leverage)
+		assert.InDelta(t, 2*price/5, position.Margin, 1e-6)
+		assert.Zero(t, position.UnrealizedPnL)
+	})
+
+	t.Run("hedge mode", func(t *testing.T) {
+		hedge, err := exchange.NewBinanceFuture(ctx,
+			exchange.WithBinanceFutureEndpoint(server.URL, WsURL(server.URL)),
+			exchange.WithBinanceFutureHedgeMode())
+		require.NoError(t, err)
+
+		// 没有指定持仓方向时按买卖方向开仓，平仓订单需要指定持仓方向
+		order, err := hedge.CreateOrderMarket(model.SideTypeSell, "BTCUSDT", 0.5)
+		require.NoError(t, err)
+		assert.Equal(t, model.PositionSideTypeShort, order.PositionSide)
+
+		order, err = hedge.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 0.5,
+			model.WithPositionSide(model.PositionSideTypeShort))
+		require.NoError(t, err)
+		assert.Equal(t, model.PositionSideTypeShort, order.PositionSide)
+
+		found, err := hedge.Order("BTCUSDT", order.ExchangeID)
+		require.NoError(t, err)
+		assert.Equal(t, model.PositionSideTypeShort, found.PositionSide)
+	})
+
+	t.Run("market quote", func(t *testing.T) {
+		order, err := binance.CreateOrderMarketQuote(model.SideTypeBuy, "BTCUSDT", price*0.5)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeFilled, order.Status)
+		assert.InDelta(t, 0.5, order.Quantity, 1e-6)
+	})
+
+	t.Run("oco", func(t *testing.T) {
+		// 止损价高于下一根K线的最低价，推进K线后止损单成交，止盈单需要由Controller撤销
+		stop := sim.candles["BTCUSDT"][1].Low + 1
+		orders, err := binance.CreateOrderOCO(model.SideTypeSell, "BTCUSDT", 1, price*2, stop, stop)
+		require.NoError(t, err)
+		require.Len(t, orders, 2)
+		require.NotNil(t, orders[0].GroupID)
+		assert.Equal(t, orders[0].GroupID, orders[1].GroupID)
+		assert.Equal(t, stop, *orders[1].Stop)
+		assert.True(t, binance.EmulatesOCO())
+
+		require.True(t, sim.Next())
+		stopLoss, err := binance.Order("BTCUSDT", orders[1].ExchangeID)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeFilled, stopLoss.Status)
+		takeProfit, err := binance.Order("BTCUSDT", orders[0].ExchangeID)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderStatusTypeNew, takeProfit.Status)
+		require.NoError(t, binance.Cancel(takeProfit))
+
+		// 数量为0时平掉整个仓位
+		orders, err = binance.CreateOrderOCO(model.SideTypeSell, "BTCUSDT", 0, price*2, price/2, price/2)
+		require.NoError(t, err)
+		assert.Equal(t, 1.5, orders[0].Quantity)
+	})
+}
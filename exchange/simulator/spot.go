@@ -0,0 +1,240 @@
+package simulator
+
+import (
+	"math"
+	"net/url"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+// spotExchangeInfo 返回现货交易对信息，交易限制来自PaperWallet。
+func (s *Server) spotExchangeInfo(_ url.Values) (interface{}, error) {
+	info := binance.ExchangeInfo{Timezone: "UTC", ServerTime: millis(s.now)}
+	for _, pair := range s.pairs {
+		asset := s.wallet.AssetsInfo(pair)
+		info.Symbols = append(info.Symbols, binance.Symbol{
+			Symbol:                     pair,
+			Status:                     "TRADING",
+			BaseAsset:                  asset.BaseAsset,
+			BaseAssetPrecision:         asset.BaseAssetPrecision,
+			QuoteAsset:                 asset.QuoteAsset,
+			QuotePrecision:             asset.QuotePrecision,
+			QuoteAssetPrecision:        asset.QuotePrecision,
+			OrderTypes:                 []string{"LIMIT", "LIMIT_MAKER", "MARKET", "STOP_LOSS", "STOP_LOSS_LIMIT"},
+			OcoAllowed:                 true,
+			QuoteOrderQtyMarketAllowed: true,
+			IsSpotTradingAllowed:       true,
+			Filters:                    symbolFilters(asset),
+		})
+	}
+	return info, nil
+}
+
+// symbolFilters 返回交易对的数量和价格限制，格式与币安一致。
+func symbolFilters(asset model.AssetInfo) []map[string]interface{} {
+	return []map[string]interface{}{
+		{
+			"filterType": string(binance.SymbolFilterTypeLotSize),
+			"minQty":     formatFloat(asset.MinQuantity),
+			"maxQty":     formatFloat(math.Min(asset.MaxQuantity, math.MaxInt64)),
+			"stepSize":   formatFloat(asset.StepSize),
+		},
+		{
+			"filterType": string(binance.SymbolFilterTypePriceFilter),
+			"minPrice":   formatFloat(asset.MinPrice),
+			"maxPrice":   formatFloat(math.Min(asset.MaxPrice, math.MaxInt64)),
+			"tickSize":   formatFloat(asset.TickSize),
+		},
+	}
+}
+
+// spotCreateOrder 现货下单。
+func (s *Server) spotCreateOrder(params url.Values) (interface{}, error) {
+	order, err := s.createOrder(params)
+	if err != nil {
+		return nil, err
+	}
+
+	quantity, cost := executed(order)
+	return binance.CreateOrderResponse{
+		Symbol:                   order.Pair,
+		OrderID:                  order.ExchangeID,
+		ClientOrderID:            order.ClientOrderID,
+		TransactTime:             millis(order.UpdatedAt),
+		Price:                    formatFloat(order.Price),
+		OrigQuantity:             formatFloat(order.Quantity),
+		ExecutedQuantity:         formatFloat(quantity),
+		CummulativeQuoteQuantity: formatFloat(cost),
+		Status:                   binance.OrderStatusType(order.Status),
+		TimeInForce:              binance.TimeInForceType(order.TimeInForce),
+		Type:                     binance.OrderType(order.Type),
+		Side:                     binance.SideType(order.Side),
+	}, nil
+}
+
+// spotCreateOCO 现货OCO下单，限价单和止损单共用一个订单组。
+func (s *Server) spotCreateOCO(params url.Values) (interface{}, error) {
+	pair := params.Get("symbol")
+	if _, ok := s.candles[pair]; !ok {
+		return nil, errInvalidSymbol
+	}
+
+	options := make([]model.OrderOption, 0)
+	if id := params.Get("limitClientOrderId"); id != "" {
+		options = append(options, model.WithClientOrderID(id))
+	}
+
+	orders, err := s.wallet.CreateOrderOCO(model.SideType(params.Get("side")), pair,
+		floatParam(params, "quantity"), floatParam(params, "price"),
+		floatParam(params, "stopPrice"), floatParam(params, "stopLimitPrice"), options...)
+	if err != nil {
+		return nil, rejected(err)
+	}
+
+	response := binance.CreateOCOResponse{
+		OrderListID:       *orders[0].GroupID,
+		ContingencyType:   "OCO",
+		ListStatusType:    "EXEC_STARTED",
+		ListOrderStatus:   "EXECUTING",
+		ListClientOrderID: params.Get("listClientOrderId"),
+		TransactionTime:   millis(orders[0].CreatedAt),
+		Symbol:            pair,
+	}
+	for _, order := range orders {
+		response.Orders = append(response.Orders, &binance.OCOOrder{
+			Symbol:        pair,
+			OrderID:       order.ExchangeID,
+			ClientOrderID: order.ClientOrderID,
+		})
+		response.OrderReports = append(response.OrderReports, &binance.OCOOrderReport{
+			Symbol:                   pair,
+			OrderID:                  order.ExchangeID,
+			OrderListID:              *order.GroupID,
+			ClientOrderID:            order.ClientOrderID,
+			TransactionTime:          millis(order.CreatedAt),
+			Price:                    formatFloat(order.Price),
+			OrigQuantity:             formatFloat(order.Quantity),
+			ExecutedQuantity:         "0",
+			CummulativeQuoteQuantity: "0",
+			Status:                   binance.OrderStatusType(order.Status),
+			TimeInForce:              binance.TimeInForceTypeGTC,
+			Type:                     binance.OrderType(order.Type),
+			Side:                     binance.SideType(order.Side),
+			StopPrice:                formatFloat(stopPrice(order)),
+		})
+	}
+	return response, nil
+}
+
+// spotGetOrder 查询现货订单。
+func (s *Server) spotGetOrder(params url.Values) (interface{}, error) {
+	order, err := s.findOrder(params)
+	if err != nil {
+		return nil, err
+	}
+	return spotOrder(order), nil
+}
+
+// spotCancelOrder 撤销现货订单。
+func (s *Server) spotCancelOrder(params url.Values) (interface{}, error) {
+	order, err := s.cancelOrder(params)
+	if err != nil {
+		return nil, err
+	}
+	return spotOrder(order), nil
+}
+
+// spotListOrders 列出现货交易对最近的订单。
+func (s *Server) spotListOrders(params url.Values) (interface{}, error) {
+	orders, err := s.listOrders(params)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*binance.Order, 0, len(orders))
+	for _, order := range orders {
+		result = append(result, spotOrder(order))
+	}
+	return result, nil
+}
+
+// spotAccount 返回现货账户余额。
+func (s *Server) spotAccount(_ url.Values) (interface{}, error) {
+	account, err := s.wallet.Account()
+	if err != nil {
+		return nil, err
+	}
+
+	result := binance.Account{
+		CanTrade:    true,
+		UpdateTime:  uint64(millis(s.now)),
+		AccountType: "SPOT",
+		Balances:    make([]binance.Balance, 0, len(account.Balances)),
+		Permissions: []string{"SPOT"},
+	}
+	for _, balance := range account.Balances {
+		result.Balances = append(result.Balances, binance.Balance{
+			Asset:  balance.Asset,
+			Free:   formatFloat(balance.Free),
+			Locked: formatFloat(balance.Lock),
+		})
+	}
+	return result, nil
+}
+
+// spotOrder 将订单转换为币安现货订单接口的格式。
+func spotOrder(order model.Order) *binance.Order {
+	quantity, cost := executed(order)
+
+	listID := int64(-1)
+	if order.GroupID != nil {
+		listID = *order.GroupID
+	}
+
+	return &binance.Order{
+		Symbol:                   order.Pair,
+		OrderID:                  order.ExchangeID,
+		OrderListId:              listID,
+		ClientOrderID:            order.ClientOrderID,
+		Price:                    formatFloat(order.Price),
+		OrigQuantity:             formatFloat(order.Quantity),
+		ExecutedQuantity:         formatFloat(quantity),
+		CummulativeQuoteQuantity: formatFloat(cost),
+		Status:                   binance.OrderStatusType(order.Status),
+		TimeInForce:              binance.TimeInForceType(order.TimeInForce),
+		Type:                     binance.OrderType(order.Type),
+		Side:                     binance.SideType(order.Side),
+		StopPrice:                formatFloat(stopPrice(order)),
+		Time:                     millis(order.CreatedAt),
+		UpdateTime:               millis(order.UpdatedAt),
+		IsWorking:                true,
+	}
+}
+
+// spotOrderEvent 将订单转换为现货用户数据流的执行回报，回报的字段平铺在事件中。
+func spotOrderEvent(order model.Order, now time.Time) map[string]interface{} {
+	quantity, cost := executed(order)
+	return map[string]interface{}{
+		"e": string(binance.UserDataEventTypeExecutionReport),
+		"E": millis(now),
+		"s": order.Pair,
+		"c": order.ClientOrderID,
+		"S": string(order.Side),
+		"o": string(order.Type),
+		"f": string(order.TimeInForce),
+		"q": formatFloat(order.Quantity),
+		"p": formatFloat(order.Price),
+		"P": formatFloat(stopPrice(order)),
+		"x": executionType(order),
+		"X": string(order.Status),
+		"i": order.ExchangeID,
+		"z": formatFloat(quantity),
+		"Z": formatFloat(cost),
+		"T": millis(order.UpdatedAt),
+		"O": millis(order.CreatedAt),
+		"w": order.Status == model.OrderStatusTypeNew,
+	}
+}
@@ -0,0 +1,467 @@
+package exchange
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+)
+
+// TickHeader 是ninjabot成交文件的表头，时间是毫秒时间戳
+var TickHeader = []string{"time", "price", "quantity", "buyer_maker", "id"}
+
+// tickColumns 是成交文件中各列的位置，id和buyer_maker不存在时为-1
+type tickColumns struct {
+	time, price, quantity, buyerMaker, id int
+}
+
+// tickColumnNames 是成交文件表头中各列可能的名称，包括币安数据下载站的逐笔成交和归集成交文件
+var tickColumnNames = map[string][]string{
+	"time":        {"time", "timestamp", "transact_time", "trade_time", "t"},
+	"price":       {"price", "p"},
+	"quantity":    {"quantity", "qty", "amount", "size", "q"},
+	"buyer_maker": {"buyer_maker", "is_buyer_maker", "isbuyermaker", "m"},
+	"id":          {"id", "agg_trade_id", "trade_id", "a"},
+}
+
+// tickLayouts 是没有表头的成交文件按列数识别的格式
+var tickLayouts = map[int]tickColumns{
+	// 币安归集成交：agg_trade_id,price,quantity,first_trade_id,last_trade_id,transact_time,is_buyer_maker,is_best_match
+	8: {time: 5, price: 1, quantity: 2, buyerMaker: 6, id: 0},
+	// 币安现货逐笔成交：id,price,qty,quote_qty,time,is_buyer_maker,is_best_match
+	7: {time: 4, price: 1, quantity: 2, buyerMaker: 5, id: 0},
+	// 币安合约逐笔成交：id,price,qty,quote_qty,time,is_buyer_maker
+	6: {time: 4, price: 1, quantity: 2, buyerMaker: 5, id: 0},
+	// ninjabot成交文件：time,price,quantity,buyer_maker,id
+	5: {time: 0, price: 1, quantity: 2, buyerMaker: 3, id: 4},
+	3: {time: 0, price: 1, quantity: 2, buyerMaker: -1, id: -1},
+}
+
+// tickSource 是一个打开的成交文件
+type tickSource struct {
+	file    *os.File
+	reader  *csv.Reader
+	columns tickColumns
+	first   []string // first 已经读取但还没有返回的第一行数据
+}
+
+// openTicks 打开成交文件，按表头或列数识别格式，时间戳按数量级识别秒、毫秒和微秒
+func openTicks(path string) (*tickSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := newTickSource(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return source, nil
+}
+
+func newTickSource(file *os.File) (*tickSource, error) {
+	buffered := bufio.NewReader(file)
+	if bom, _ := buffered.Peek(3); bytes.Equal(bom, []byte{0xEF, 0xBB, 0xBF}) {
+		_, _ = buffered.Discard(3)
+	}
+
+	source := &tickSource{file: file, reader: csv.NewReader(buffered)}
+	source.reader.FieldsPerRecord = -1
+	source.reader.TrimLeadingSpace = true
+
+	line, err := source.reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: empty file", ErrInsufficientData)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 第一列是数字时没有表头
+	if _, err := strconv.ParseFloat(strings.TrimSpace(line[0]), 64); err == nil {
+		columns, ok := tickLayouts[len(line)]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown trade layout with %d columns", ErrUnknownCSVLayout, len(line))
+		}
+		source.columns = columns
+		source.first = append([]string(nil), line...)
+		return source, nil
+	}
+
+	indexes := make(map[string]int)
+	for i, name := range line {
+		name = strings.ToLower(strings.TrimSpace(name))
+		for column, names := range tickColumnNames {
+			for _, candidate := range names {
+				if _, ok := indexes[column]; !ok && name == candidate {
+					indexes[column] = i
+				}
+			}
+		}
+	}
+	for _, column := range []string{"time", "price", "quantity"} {
+		if _, ok := indexes[column]; !ok {
+			return nil, fmt.Errorf("%w: missing %s column in %v", ErrUnknownCSVLayout, column, line)
+		}
+	}
+
+	source.columns = tickColumns{time: indexes["time"], price: indexes["price"], quantity: indexes["quantity"],
+		buyerMaker: -1, id: -1}
+	if index, ok := indexes["buyer_maker"]; ok {
+		source.columns.buyerMaker = index
+	}
+	if index, ok := indexes["id"]; ok {
+		source.columns.id = index
+	}
+	return source, nil
+}
+
+// next 读取下一笔成交，文件结束时返回io.EOF
+func (s *tickSource) next(pair string) (model.Tick, error) {
+	line := s.first
+	s.first = nil
+	if line == nil {
+		var err error
+		if line, err = s.reader.Read(); err != nil {
+			return model.Tick{}, err
+		}
+	}
+	return s.parse(pair, line)
+}
+
+func (s *tickSource) parse(pair string, line []string) (model.Tick, error) {
+	columns := s.columns
+	for _, index := range []int{columns.time, columns.price, columns.quantity, columns.buyerMaker, columns.id} {
+		if index >= len(line) {
+			return model.Tick{}, fmt.Errorf("invalid trade line: %v", line)
+		}
+	}
+
+	var (
+		tick = model.Tick{Pair: pair}
+		err  error
+	)
+	if tick.Time, err = parseUnixTime(strings.TrimSpace(line[columns.time]), 0); err != nil {
+		return model.Tick{}, err
+	}
+	if tick.Price, err = strconv.ParseFloat(strings.TrimSpace(line[columns.price]), 64); err != nil {
+		return model.Tick{}, err
+	}
+	if tick.Quantity, err = strconv.ParseFloat(strings.TrimSpace(line[columns.quantity]), 64); err != nil {
+		return model.Tick{}, err
+	}
+	if columns.buyerMaker >= 0 {
+		if tick.BuyerMaker, err = strconv.ParseBool(strings.TrimSpace(line[columns.buyerMaker])); err != nil {
+			return model.Tick{}, err
+		}
+	}
+	if columns.id >= 0 {
+		if tick.ID, err = strconv.ParseInt(strings.TrimSpace(line[columns.id]), 10, 64); err != nil {
+			return model.Tick{}, err
+		}
+	}
+	return tick, nil
+}
+
+// Close 关闭文件
+func (s *tickSource) Close() error {
+	return s.file.Close()
+}
+
+// ReadTicksCSV 读取成交文件中的全部成交，支持ninjabot的成交文件和币安数据下载站的逐笔成交、归集成交文件
+func ReadTicksCSV(path, pair string) ([]model.Tick, error) {
+	source, err := openTicks(path)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+
+	ticks := make([]model.Tick, 0)
+	for {
+		tick, err := source.next(pair)
+		if err == io.EOF {
+			return ticks, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		ticks = append(ticks, tick)
+	}
+}
+
+// WriteTicksCSV 以ninjabot成交文件的格式写入成交，header为true时先写入表头
+func WriteTicksCSV(w io.Writer, header bool, ticks []model.Tick) error {
+	writer := csv.NewWriter(w)
+	if header {
+		if err := writer.Write(TickHeader); err != nil {
+			return err
+		}
+	}
+
+	for _, tick := range ticks {
+		err := writer.Write([]string{
+			strconv.FormatInt(tick.Time.UnixNano()/int64(time.Millisecond), 10),
+			strconv.FormatFloat(tick.Price, 'f', -1, 64),
+			strconv.FormatFloat(tick.Quantity, 'f', -1, 64),
+			strconv.FormatBool(tick.BuyerMaker),
+			strconv.FormatInt(tick.ID, 10),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// TickPairFeed 是一个交易对的成交文件配置
+type TickPairFeed struct {
+	Pair      string
+	Files     []string  // Files 按时间顺序排列的成交文件，如每天一个文件
+	Alignment Alignment // Alignment 时间K线的周期对齐方式
+}
+
+/*
+TickFeed 是由成交文件生成K线的回测数据源，时间周期可以是普通的时间周期，也可以是BarSpec描述的非时间K线，
+如"tick:500"、"volume:100"、"dollar:1e6"、"renko:50"和"range:100"。
+
+与CSVStreamFeed一样，每次订阅或查询时逐行读取成交文件并生成K线，只输出完整的K线，数据末尾不完整的K线被丢弃。
+*/
+type TickFeed struct {
+	streamFeed
+	Feeds map[string]TickPairFeed // Feeds 每个交易对的成交文件，键为交易对
+}
+
+// NewTickFeed 创建由成交文件生成K线的数据源，只检查文件是否可以读取
+func NewTickFeed(feeds ...TickPairFeed) (*TickFeed, error) {
+	feed := &TickFeed{Feeds: make(map[string]TickPairFeed)}
+	feed.streamFeed = newStreamFeed(feed.stream)
+
+	for _, pairFeed := range feeds {
+		if len(pairFeed.Files) == 0 {
+			return nil, fmt.Errorf("%w: no trade files for %s", ErrInsufficientData, pairFeed.Pair)
+		}
+		for _, path := range pairFeed.Files {
+			source, err := openTicks(path)
+			if err != nil {
+				return nil, err
+			}
+			_, err = source.next(pairFeed.Pair)
+			source.Close()
+			if err != nil && err != io.EOF {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		feed.Feeds[pairFeed.Pair] = pairFeed
+	}
+	return feed, nil
+}
+
+// stream 依次读取交易对的成交文件并生成K线，不同的起点会生成不同的非时间K线，start之前的成交同样会被读取
+func (t *TickFeed) stream(ctx context.Context, pair, timeframe string, _ time.Time,
+	fn func(model.Candle) error) error {
+
+	feed, ok := t.Feeds[pair]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrInsufficientData, pair)
+	}
+
+	aggregator, err := NewBarAggregator(pair, timeframe, feed.Alignment)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range feed.Files {
+		err := t.streamFile(ctx, path, pair, aggregator, fn)
+		if errors.Is(err, errStopStream) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *TickFeed) streamFile(ctx context.Context, path, pair string, aggregator *BarAggregator,
+	fn func(model.Candle) error) error {
+
+	source, err := openTicks(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		tick, err := source.next(pair)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		for _, candle := range aggregator.Update(tick) {
+			if err := fn(candle); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+/*
+TickBarFeed 让实时交易所支持由成交生成的K线：带有类型前缀的时间周期（见IsBarSpec）订阅交易所的实时成交并生成K线，
+其他时间周期直接使用交易所的K线接口。可以作为service.Exchange传给DataFeedSubscription和NinjaBot。
+
+实时订阅只输出完整的K线，时间K线在下一个周期的第一笔成交到达时完成。
+*/
+type TickBarFeed struct {
+	service.Exchange
+	ticks     service.TickFeeder
+	alignment Alignment
+	lookback  time.Duration
+}
+
+// TickBarOption 是TickBarFeed的配置
+type TickBarOption func(*TickBarFeed)
+
+// WithTickBarAlignment 设置时间K线的周期对齐方式
+func WithTickBarAlignment(alignment Alignment) TickBarOption {
+	return func(feed *TickBarFeed) {
+		feed.alignment = alignment
+	}
+}
+
+// WithTickBarLookback 设置CandlesByLimit获取历史成交的时间范围，默认1小时
+func WithTickBarLookback(lookback time.Duration) TickBarOption {
+	return func(feed *TickBarFeed) {
+		feed.lookback = lookback
+	}
+}
+
+// NewTickBarFeed 创建支持由成交生成K线的交易所，ticks通常就是exchange本身，如*Binance
+func NewTickBarFeed(exchange service.Exchange, ticks service.TickFeeder, options ...TickBarOption) *TickBarFeed {
+	feed := &TickBarFeed{Exchange: exchange, ticks: ticks, lookback: time.Hour}
+	for _, option := range options {
+		option(feed)
+	}
+	return feed
+}
+
+// CandlesByPeriod 获取时间范围内的成交并生成K线
+func (t *TickBarFeed) CandlesByPeriod(ctx context.Context, pair, timeframe string,
+	start, end time.Time) ([]model.Candle, error) {
+
+	if !IsBarSpec(timeframe) {
+		return t.Exchange.CandlesByPeriod(ctx, pair, timeframe, start, end)
+	}
+
+	aggregator, err := NewBarAggregator(pair, timeframe, t.alignment)
+	if err != nil {
+		return nil, err
+	}
+	ticks, err := t.ticks.TicksByPeriod(ctx, pair, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	candles := make([]model.Candle, 0)
+	for _, tick := range ticks {
+		candles = append(candles, aggregator.Update(tick)...)
+	}
+	return candles, nil
+}
+
+// CandlesByLimit 由最近lookback时间内的成交生成K线，返回最后limit根，成交不足时返回ErrInsufficientData
+func (t *TickBarFeed) CandlesByLimit(ctx context.Context, pair, timeframe string,
+	limit int) ([]model.Candle, error) {
+
+	if !IsBarSpec(timeframe) {
+		return t.Exchange.CandlesByLimit(ctx, pair, timeframe, limit)
+	}
+
+	end := time.Now()
+	candles, err := t.CandlesByPeriod(ctx, pair, timeframe, end.Add(-t.lookback), end)
+	if err != nil {
+		return nil, err
+	}
+	if len(candles) < limit {
+		return nil, fmt.Errorf("%w: %s %s: %d candles in the last %s", ErrInsufficientData, pair, timeframe,
+			len(candles), t.lookback)
+	}
+	return candles[len(candles)-limit:], nil
+}
+
+// CandlesSubscription 订阅实时成交并生成K线
+func (t *TickBarFeed) CandlesSubscription(ctx context.Context, pair, timeframe string) (chan model.Candle,
+	chan error) {
+
+	if !IsBarSpec(timeframe) {
+		return t.Exchange.CandlesSubscription(ctx, pair, timeframe)
+	}
+
+	ccandle := make(chan model.Candle)
+	cerr := make(chan error)
+
+	aggregator, err := NewBarAggregator(pair, timeframe, t.alignment)
+	if err != nil {
+		go func() {
+			cerr <- err
+			close(cerr)
+			close(ccandle)
+		}()
+		return ccandle, cerr
+	}
+
+	go func() {
+		defer close(cerr)
+		defer close(ccandle)
+
+		ctick, ctickErr := t.ticks.TicksSubscription(ctx, pair)
+		for {
+			select {
+			case tick, ok := <-ctick:
+				if !ok {
+					return
+				}
+				for _, candle := range aggregator.Update(tick) {
+					select {
+					case ccandle <- candle:
+					case <-ctx.Done():
+						return
+					}
+				}
+			case err, ok := <-ctickErr:
+				if !ok {
+					ctickErr = nil
+					continue
+				}
+				select {
+				case cerr <- err:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ccandle, cerr
+}
@@ -0,0 +1,493 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xhit/go-str2duration/v2"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+// ErrInvalidCandles 表示K线数据没有通过校验
+var ErrInvalidCandles = errors.New("invalid candles")
+
+// CandleIssueType 是K线数据问题的类型
+type CandleIssueType string
+
+const (
+	CandleIssueDuplicate   CandleIssueType = "duplicate"    // 重复的开盘时间
+	CandleIssueOutOfOrder  CandleIssueType = "out_of_order" // 开盘时间早于前一根K线
+	CandleIssueGap         CandleIssueType = "gap"          // 两根K线之间缺少数据
+	CandleIssueZeroVolume  CandleIssueType = "zero_volume"  // 连续的零成交量K线
+	CandleIssueInvalidOHLC CandleIssueType = "invalid_ohlc" // 价格不一致，如最高价低于最低价或收盘价超出范围
+)
+
+// CandleIssues 是报告中问题类型的顺序
+var CandleIssues = []CandleIssueType{
+	CandleIssueDuplicate,
+	CandleIssueOutOfOrder,
+	CandleIssueGap,
+	CandleIssueZeroVolume,
+	CandleIssueInvalidOHLC,
+}
+
+// CandleIssue 是一个数据问题，Index是K线在原始数据中的位置（从0开始），缺口的位置是缺口前的最后一根K线
+type CandleIssue struct {
+	Type    CandleIssueType
+	Index   int
+	Time    time.Time
+	Count   int    // Count 缺口缺少的K线数量或连续零成交量K线的数量，其他问题为1
+	Message string // Message 问题的说明
+}
+
+// ValidationOptions 是K线校验和修复的配置，修复按DropInvalid、Sort、Dedupe、FillGaps的顺序进行。
+type ValidationOptions struct {
+	Dedupe      bool // Dedupe 删除重复的K线，保留最后一根
+	Sort        bool // Sort 按开盘时间排序
+	FillGaps    bool // FillGaps 使用前一根K线的收盘价填充缺口，填充的K线开高低收相同、成交量为0
+	DropInvalid bool // DropInvalid 删除价格不一致的K线
+
+	// MinZeroVolumeRun 报告的连续零成交量K线的最小数量，默认为1
+	MinZeroVolumeRun int
+	// Strict 修复后仍然有问题时返回ErrInvalidCandles，零成交量不视为错误
+	Strict bool
+}
+
+// RepairAll 返回启用所有修复的配置
+func RepairAll() ValidationOptions {
+	return ValidationOptions{Dedupe: true, Sort: true, FillGaps: true, DropInvalid: true}
+}
+
+func (o ValidationOptions) repair() bool {
+	return o.Dedupe || o.Sort || o.FillGaps || o.DropInvalid
+}
+
+// ValidationReport 是一个交易对K线数据的校验结果
+type ValidationReport struct {
+	Pair      string
+	Timeframe string
+	Candles   int                     // Candles 校验的K线数量
+	Issues    []CandleIssue           // Issues 修复前发现的问题
+	Counts    map[CandleIssueType]int // Counts 每种问题的数量
+	Removed   int                     // Removed 修复时删除的K线数量
+	Filled    int                     // Filled 修复时填充的K线数量
+}
+
+// Valid 报告中没有零成交量以外的问题时返回true
+func (r ValidationReport) Valid() bool {
+	for issue, count := range r.Counts {
+		if issue != CandleIssueZeroVolume && count > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Print 打印每种问题的数量和前limit个问题的位置，limit小于等于0时打印所有问题
+func (r ValidationReport) Print(w io.Writer, limit int) {
+	fmt.Fprintf(w, "%s %s: %d candles\n", r.Pair, r.Timeframe, r.Candles)
+	for _, issue := range CandleIssues {
+		fmt.Fprintf(w, "  %-13s %d\n", issue, r.Counts[issue])
+	}
+	if r.Removed > 0 || r.Filled > 0 {
+		fmt.Fprintf(w, "  repaired: %d removed, %d filled\n", r.Removed, r.Filled)
+	}
+
+	for i, issue := range r.Issues {
+		if limit > 0 && i >= limit {
+			fmt.Fprintf(w, "  ... %d more\n", len(r.Issues)-limit)
+			break
+		}
+		fmt.Fprintf(w, "  #%d %s %s: %s\n", issue.Index, issue.Time.Format(time.RFC3339), issue.Type, issue.Message)
+	}
+}
+
+func (r ValidationReport) String() string {
+	var builder strings.Builder
+	r.Print(&builder, 10)
+	return builder.String()
+}
+
+func (r *ValidationReport) add(issue CandleIssue) {
+	if issue.Count == 0 {
+		issue.Count = 1
+	}
+	r.Issues = append(r.Issues, issue)
+	r.Counts[issue.Type] += issue.Count
+}
+
+// ValidateCandles 检查K线中的重复、乱序、缺口、连续零成交量和价格不一致的问题，timeframe用于检查缺口。
+// 启用修复时返回修复后的K线，否则返回原来的K线。candles不会被修改。
+func ValidateCandles(candles []model.Candle, pair, timeframe string,
+	options ValidationOptions) ([]model.Candle, ValidationReport, error) {
+
+	interval, err := str2duration.ParseDuration(timeframe)
+	if err != nil {
+		return nil, ValidationReport{}, err
+	}
+
+	report := ValidationReport{
+		Pair:      pair,
+		Timeframe: timeframe,
+		Candles:   len(candles),
+		Counts:    make(map[CandleIssueType]int),
+	}
+	if options.MinZeroVolumeRun <= 0 {
+		options.MinZeroVolumeRun = 1
+	}
+
+	var (
+		seen    = make(map[int64]int, len(candles))
+		latest  time.Time
+		invalid = make(map[int]bool)
+		zeroRun int
+	)
+
+	for i, candle := range candles {
+		if first, ok := seen[candle.Time.UnixNano()]; ok {
+			report.add(CandleIssue{Type: CandleIssueDuplicate, Index: i, Time: candle.Time,
+				Message: fmt.Sprintf("same time as #%d", first)})
+		} else {
+			seen[candle.Time.UnixNano()] = i
+			if i > 0 && candle.Time.Before(latest) {
+				report.add(CandleIssue{Type: CandleIssueOutOfOrder, Index: i, Time: candle.Time,
+					Message: fmt.Sprintf("before %s", latest.Format(time.RFC3339))})
+			}
+		}
+		if candle.Time.After(latest) || i == 0 {
+			latest = candle.Time
+		}
+
+		if message := checkOHLC(candle); message != "" {
+			invalid[i] = true
+			report.add(CandleIssue{Type: CandleIssueInvalidOHLC, Index: i, Time: candle.Time, Message: message})
+		}
+
+		// 连续的零成交量K线作为一个问题报告，位置是第一根K线
+		if candle.Volume == 0 {
+			zeroRun++
+		}
+		if zeroRun > 0 && (candle.Volume != 0 || i == len(candles)-1) {
+			end := i
+			if candle.Volume == 0 {
+				end = i + 1
+			}
+			if zeroRun >= options.MinZeroVolumeRun {
+				start := end - zeroRun
+				report.add(CandleIssue{Type: CandleIssueZeroVolume, Index: start, Time: candles[start].Time,
+					Count: zeroRun, Message: fmt.Sprintf("%d candles without volume", zeroRun)})
+			}
+			zeroRun = 0
+		}
+	}
+
+	// 缺口按排序去重后的K线检查，乱序的K线不会被报告为缺口
+	indexes := make([]int, 0, len(seen))
+	for _, index := range seen {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		return candles[indexes[i]].Time.Before(candles[indexes[j]].Time)
+	})
+	for i := 1; i < len(indexes); i++ {
+		prev, current := candles[indexes[i-1]].Time, candles[indexes[i]].Time
+		if missing := int(current.Sub(prev)/interval) - 1; missing > 0 {
+			report.add(CandleIssue{Type: CandleIssueGap, Index: indexes[i-1], Time: prev, Count: missing,
+				Message: fmt.Sprintf("%d candles missing until %s", missing, current.Format(time.RFC3339))})
+		}
+	}
+
+	sort.SliceStable(report.Issues, func(i, j int) bool {
+		return report.Issues[i].Index < report.Issues[j].Index
+	})
+
+	result := candles
+	if options.repair() {
+		result = repairCandles(candles, interval, invalid, options, &report)
+	}
+
+	if options.Strict {
+		remaining := report
+		if options.repair() {
+			// 修复后重新校验，只报告没有修复的问题
+			_, remaining, _ = ValidateCandles(result, pair, timeframe, ValidationOptions{})
+		}
+		if !remaining.Valid() {
+			return nil, report, fmt.Errorf("%w: %s %s: %s", ErrInvalidCandles, pair, timeframe,
+				remaining.summary())
+		}
+	}
+	return result, report, nil
+}
+
+// summary 返回一行的问题数量，用于错误信息
+func (r ValidationReport) summary() string {
+	var parts []string
+	for _, issue := range CandleIssues {
+		if count := r.Counts[issue]; count > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", count, issue))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// checkOHLC 检查价格是否一致，返回问题的说明，没有问题时返回空字符串
+func checkOHLC(candle model.Candle) string {
+	for _, value := range []float64{candle.Open, candle.Close, candle.Low, candle.High, candle.Volume} {
+		if math.IsNaN(value) || math.IsInf(value, 0) || value < 0 {
+			return fmt.Sprintf("invalid value %v", value)
+		}
+	}
+	switch {
+	case candle.High < candle.Low:
+		return fmt.Sprintf("high %v < low %v", candle.High, candle.Low)
+	case candle.Open > candle.High || candle.Open < candle.Low:
+		return fmt.Sprintf("open %v outside [%v, %v]", candle.Open, candle.Low, candle.High)
+	case candle.Close > candle.High || candle.Close < candle.Low:
+		return fmt.Sprintf("close %v outside [%v, %v]", candle.Close, candle.Low, candle.High)
+	}
+	return ""
+}
+
+// repairCandles 按配置修复K线，返回新的切片
+func repairCandles(candles []model.Candle, interval time.Duration, invalid map[int]bool,
+	options ValidationOptions, report *ValidationReport) []model.Candle {
+
+	result := make([]model.Candle, 0, len(candles))
+	for i, candle := range candles {
+		if options.DropInvalid && invalid[i] {
+			continue
+		}
+		result = append(result, candle)
+	}
+
+	if options.Sort {
+		sort.SliceStable(result, func(i, j int) bool {
+			return result[i].Time.Before(result[j].Time)
+		})
+	}
+
+	if options.Dedupe {
+		// 保留最后出现的K线，通常是后来修正的数据
+		last := make(map[int64]int, len(result))
+		for i, candle := range result {
+			last[candle.Time.UnixNano()] = i
+		}
+		deduped := result[:0]
+		for i, candle := range result {
+			if last[candle.Time.UnixNano()] == i {
+				deduped = append(deduped, candle)
+			}
+		}
+		result = deduped
+	}
+
+	if options.FillGaps && len(result) > 0 {
+		filled := make([]model.Candle, 0, len(result))
+		filled = append(filled, result[0])
+		for _, candle := range result[1:] {
+			prev := filled[len(filled)-1]
+			for t := prev.Time.Add(interval); t.Before(candle.Time) && prev.Time.Before(candle.Time); t = t.Add(interval) {
+				filled = append(filled, model.Candle{
+					Pair:      prev.Pair,
+					Time:      t,
+					UpdatedAt: t,
+					Open:      prev.Close,
+					Close:     prev.Close,
+					Low:       prev.Close,
+					High:      prev.Close,
+					Complete:  true,
+				})
+				report.Filled++
+			}
+			filled = append(filled, candle)
+		}
+		result = filled
+	}
+
+	report.Removed = len(candles) + report.Filled - len(result)
+	return result
+}
+
+/*
+candleValidator 在读取的同时校验和修复按时间顺序读取的K线，供CSVStreamFeed和BinaryFeed使用，内存占用与数据量无关。
+流式读取无法回看之前的数据，与ValidateCandles有以下不同：只有与最晚的K线时间相同的K线才报告为重复，
+更早的重复时间报告为乱序；乱序的K线无法移动到正确的位置，启用Sort或Dedupe时直接删除。
+*/
+type candleValidator struct {
+	options  ValidationOptions
+	interval time.Duration
+	report   ValidationReport
+	check    *candleValidator // check Strict时校验修复后的K线，只报告没有修复的问题
+
+	index       int           // index 下一根源K线的位置
+	latest      time.Time     // latest 已经读取的最晚的开盘时间
+	latestIndex int           // latestIndex 第一根开盘时间为latest的K线的位置
+	emitted     time.Time     // emitted 已经输出的最晚的开盘时间，填充缺口从这里开始
+	pending     *model.Candle // pending 等待输出的K线，启用Dedupe时会被后面相同时间的K线替换
+
+	zeroRun   int // zeroRun 当前连续零成交量K线的数量
+	zeroStart int
+	zeroTime  time.Time
+}
+
+func newCandleValidator(pair, timeframe string, options ValidationOptions) (*candleValidator, error) {
+	interval, err := str2duration.ParseDuration(timeframe)
+	if err != nil {
+		return nil, err
+	}
+	if options.MinZeroVolumeRun <= 0 {
+		options.MinZeroVolumeRun = 1
+	}
+
+	validator := &candleValidator{
+		options:  options,
+		interval: interval,
+		report: ValidationReport{
+			Pair:      pair,
+			Timeframe: timeframe,
+			Counts:    make(map[CandleIssueType]int),
+		},
+	}
+	if options.Strict && options.repair() {
+		validator.check, _ = newCandleValidator(pair, timeframe, ValidationOptions{})
+	}
+	return validator, nil
+}
+
+// push 校验一根源K线，返回可以输出的K线，修复时可能为空或者包含填充的K线
+func (v *candleValidator) push(candle model.Candle) []model.Candle {
+	index := v.index
+	v.index++
+	v.report.Candles++
+
+	// 连续的零成交量K线在结束时作为一个问题报告，位置是第一根K线
+	if candle.Volume == 0 {
+		if v.zeroRun == 0 {
+			v.zeroStart, v.zeroTime = index, candle.Time
+		}
+		v.zeroRun++
+	} else {
+		v.flushZeroVolume()
+	}
+
+	keep := true
+	if message := checkOHLC(candle); message != "" {
+		v.report.add(CandleIssue{Type: CandleIssueInvalidOHLC, Index: index, Time: candle.Time, Message: message})
+		keep = !v.options.DropInvalid
+	}
+
+	replace := false
+	switch {
+	case index == 0 || candle.Time.After(v.latest):
+		if missing := int(candle.Time.Sub(v.latest)/v.interval) - 1; index > 0 && missing > 0 {
+			v.report.add(CandleIssue{Type: CandleIssueGap, Index: v.latestIndex, Time: v.latest, Count: missing,
+				Message: fmt.Sprintf("%d candles missing until %s", missing, candle.Time.Format(time.RFC3339))})
+		}
+		v.latest, v.latestIndex = candle.Time, index
+	case candle.Time.Equal(v.latest):
+		v.report.add(CandleIssue{Type: CandleIssueDuplicate, Index: index, Time: candle.Time,
+			Message: fmt.Sprintf("same time as #%d", v.latestIndex)})
+		replace = v.options.Dedupe
+	default:
+		v.report.add(CandleIssue{Type: CandleIssueOutOfOrder, Index: index, Time: candle.Time,
+			Message: fmt.Sprintf("before %s", v.latest.Format(time.RFC3339))})
+		keep = keep && !v.options.Sort && !v.options.Dedupe
+	}
+
+	if !keep {
+		v.report.Removed++
+		return nil
+	}
+	// 保留最后出现的K线，通常是后来修正的数据
+	if replace && v.pending != nil && v.pending.Time.Equal(candle.Time) {
+		v.pending = &candle
+		v.report.Removed++
+		return nil
+	}
+
+	var ready []model.Candle
+	if v.pending != nil {
+		prev := *v.pending
+		ready = append(ready, prev)
+		if v.options.FillGaps && !v.emitted.IsZero() {
+			for t := v.emitted.Add(v.interval); t.Before(candle.Time); t = t.Add(v.interval) {
+				ready = append(ready, model.Candle{
+					Pair:      prev.Pair,
+					Time:      t,
+					UpdatedAt: t,
+					Open:      prev.Close,
+					Close:     prev.Close,
+					Low:       prev.Close,
+					High:      prev.Close,
+					Complete:  true,
+				})
+				v.report.Filled++
+			}
+		}
+	}
+	if v.emitted.IsZero() || candle.Time.After(v.emitted) {
+		v.emitted = candle.Time
+	}
+	v.pending = &candle
+	return v.verify(ready)
+}
+
+// finish 结束校验，返回最后一根等待输出的K线
+func (v *candleValidator) finish() []model.Candle {
+	v.flushZeroVolume()
+	sort.SliceStable(v.report.Issues, func(i, j int) bool {
+		return v.report.Issues[i].Index < v.report.Issues[j].Index
+	})
+
+	var ready []model.Candle
+	if v.pending != nil {
+		ready = append(ready, *v.pending)
+		v.pending = nil
+	}
+	ready = v.verify(ready)
+	if v.check != nil {
+		v.check.finish()
+	}
+	return ready
+}
+
+// err 在finish之后调用，Strict时如果有没有修复的问题返回ErrInvalidCandles
+func (v *candleValidator) err() error {
+	if !v.options.Strict {
+		return nil
+	}
+	remaining := v.report
+	if v.check != nil {
+		remaining = v.check.report
+	}
+	if !remaining.Valid() {
+		return fmt.Errorf("%w: %s %s: %s", ErrInvalidCandles, v.report.Pair, v.report.Timeframe,
+			remaining.summary())
+	}
+	return nil
+}
+
+// verify Strict时把修复后的K线交给check重新校验
+func (v *candleValidator) verify(candles []model.Candle) []model.Candle {
+	if v.check != nil {
+		for _, candle := range candles {
+			v.check.push(candle)
+		}
+	}
+	return candles
+}
+
+func (v *candleValidator) flushZeroVolume() {
+	if v.zeroRun >= v.options.MinZeroVolumeRun {
+		v.report.add(CandleIssue{Type: CandleIssueZeroVolume, Index: v.zeroStart, Time: v.zeroTime,
+			Count: v.zeroRun, Message: fmt.Sprintf("%d candles without volume", v.zeroRun)})
+	}
+	v.zeroRun = 0
+}
@@ -0,0 +1,204 @@
+package exchange
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+func TestValidateCandles(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	candle := func(hour int, price, volume float64) model.Candle {
+		return model.Candle{
+			Pair:   "BTCUSDT",
+			Time:   start.Add(time.Duration(hour) * time.Hour),
+			Open:   price,
+			Close:  price,
+			Low:    price - 1,
+			High:   price + 1,
+			Volume: volume,
+		}
+	}
+
+	bad := candle(6, 15, 1)
+	bad.High = bad.Low - 1
+
+	// 0 1 3(乱序的2) 2 2(重复) 4 5(零成交量) 6(价格错误) 9(缺少7和8)
+	candles := []model.Candle{
+		candle(0, 10, 1),
+		candle(1, 11, 1),
+		candle(3, 13, 1),
+		candle(2, 12, 1),
+		candle(2, 12.5, 1),
+		candle(4, 14, 0),
+		candle(5, 14, 0),
+		bad,
+		candle(9, 19, 1),
+	}
+
+	t.Run("report", func(t *testing.T) {
+		result, report, err := ValidateCandles(candles, "BTCUSDT", "1h", ValidationOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, candles, result)
+		assert.False(t, report.Valid())
+		assert.Equal(t, 9, report.Candles)
+		assert.Equal(t, map[CandleIssueType]int{
+			CandleIssueDuplicate:   1,
+			CandleIssueOutOfOrder:  1,
+			CandleIssueGap:         2,
+			CandleIssueZeroVolume:  2,
+			CandleIssueInvalidOHLC: 1,
+		}, report.Counts)
+
+		locations := make(map[CandleIssueType]int)
+		for _, issue := range report.Issues {
+			locations[issue.Type] = issue.Index
+		}
+		assert.Equal(t, map[CandleIssueType]int{
+			CandleIssueOutOfOrder:  3,
+			CandleIssueDuplicate:   4,
+			CandleIssueZeroVolume:  5,
+			CandleIssueInvalidOHLC: 7,
+			CandleIssueGap:         7,
+		}, locations)
+		assert.Contains(t, report.String(), "2 candles missing")
+	})
+
+	t.Run("zero volume run", func(t *testing.T) {
+		_, report, err := ValidateCandles(candles, "BTCUSDT", "1h", ValidationOptions{MinZeroVolumeRun: 3})
+		require.NoError(t, err)
+		assert.Zero(t, report.Counts[CandleIssueZeroVolume])
+	})
+
+	t.Run("repair", func(t *testing.T) {
+		input := append([]model.Candle(nil), candles...)
+		result, report, err := ValidateCandles(candles, "BTCUSDT", "1h", ValidationOptions{
+			Dedupe:      true,
+			Sort:        true,
+			FillGaps:    true,
+			DropInvalid: true,
+			Strict:      true,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, input, candles)
+		assert.Equal(t, 2, report.Removed)
+		assert.Equal(t, 3, report.Filled)
+
+		require.Len(t, result, 10)
+		for i, candle := range result {
+			assert.Equal(t, start.Add(time.Duration(i)*time.Hour), candle.Time)
+		}
+		assert.Equal(t, 12.5, result[2].Close)
+		// 缺口使用前一根K线的收盘价填充
+		for _, candle := range result[6:9] {
+			assert.Equal(t, 14.0, candle.Open)
+			assert.Equal(t, 14.0, candle.High)
+			assert.Zero(t, candle.Volume)
+		}
+
+		_, again, err := ValidateCandles(result, "BTCUSDT", "1h", ValidationOptions{})
+		require.NoError(t, err)
+		assert.True(t, again.Valid())
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		_, _, err := ValidateCandles(candles, "BTCUSDT", "1h", ValidationOptions{Sort: true, Strict: true})
+		require.ErrorIs(t, err, ErrInvalidCandles)
+	})
+
+	t.Run("csv feed", func(t *testing.T) {
+		dir := t.TempDir()
+		file := filepath.Join(dir, "btc.csv")
+		require.NoError(t, WriteCSVFile(file, candles))
+
+		feed, err := NewCSVFeed("1h", PairFeed{Pair: "BTCUSDT", File: file, Timeframe: "1h"})
+		require.NoError(t, err)
+		assert.Empty(t, feed.Reports)
+
+		options := RepairAll()
+		feed, err = NewCSVFeed("1h", PairFeed{Pair: "BTCUSDT", File: file, Timeframe: "1h", Validation: &options})
+		require.NoError(t, err)
+		assert.Equal(t, 1, feed.Reports["BTCUSDT"].Counts[CandleIssueDuplicate])
+		assert.Len(t, feed.CandlePairTimeFrame["BTCUSDT--1h"], 10)
+
+		options.Strict = true
+		options.FillGaps = false
+		_, err = NewCSVFeed("1h", PairFeed{Pair: "BTCUSDT", File: file, Timeframe: "1h", Validation: &options})
+		require.ErrorIs(t, err, ErrInvalidCandles)
+	})
+
+	t.Run("stream feeds", func(t *testing.T) {
+		ctx := context.Background()
+		dir := t.TempDir()
+		csvFile := filepath.Join(dir, "btc.csv")
+		require.NoError(t, WriteCSVFile(csvFile, candles))
+
+		options := RepairAll()
+		feed := PairFeed{Pair: "BTCUSDT", File: csvFile, Timeframe: "1h", Validation: &options}
+		streamFeed, err := NewCSVStreamFeed("1h", feed)
+		require.NoError(t, err)
+		// 流式读取时3之后的2报告为乱序，1和3之间报告为缺口
+		assert.Equal(t, map[CandleIssueType]int{
+			CandleIssueOutOfOrder:  2,
+			CandleIssueGap:         3,
+			CandleIssueZeroVolume:  2,
+			CandleIssueInvalidOHLC: 1,
+		}, streamFeed.Reports["BTCUSDT"].Counts)
+
+		result, err := collectCandles(streamFeed.CandlesSubscription(ctx, "BTCUSDT", "1h"))
+		require.NoError(t, err)
+		require.Len(t, result, 10)
+		for i, candle := range result {
+			assert.Equal(t, start.Add(time.Duration(i)*time.Hour), candle.Time)
+		}
+
+		// 二进制文件中的K线已经排序，重复的K线保留最后一根
+		sorted := []model.Candle{candles[0], candles[1], candles[3], candles[4], candles[2], candles[5],
+			candles[6], candles[7], candles[8]}
+		binFile := filepath.Join(dir, "btc.bin")
+		require.NoError(t, WriteBinaryFile(binFile, sorted))
+		feed.File = binFile
+		binaryFeed, err := NewBinaryFeed("1h", feed)
+		require.NoError(t, err)
+		defer binaryFeed.Close()
+		assert.Equal(t, 1, binaryFeed.Reports["BTCUSDT"].Counts[CandleIssueDuplicate])
+		assert.Zero(t, binaryFeed.Reports["BTCUSDT"].Counts[CandleIssueOutOfOrder])
+
+		result, err = collectCandles(binaryFeed.CandlesSubscription(ctx, "BTCUSDT", "1h"))
+		require.NoError(t, err)
+		require.Len(t, result, 10)
+		assert.Equal(t, 12.5, result[2].Close)
+		_, again, err := ValidateCandles(result, "BTCUSDT", "1h", ValidationOptions{})
+		require.NoError(t, err)
+		assert.True(t, again.Valid())
+
+		options.Strict = true
+		options.FillGaps = false
+		_, err = NewCSVStreamFeed("1h", PairFeed{Pair: "BTCUSDT", File: csvFile, Timeframe: "1h",
+			Validation: &options})
+		require.ErrorIs(t, err, ErrInvalidCandles)
+		_, err = NewBinaryFeed("1h", feed)
+		require.ErrorIs(t, err, ErrInvalidCandles)
+	})
+
+	t.Run("write csv", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "btc.csv")
+		source := append([]model.Candle(nil), candles[:2]...)
+		source[0].Metadata = map[string]float64{"rsi": 50.5}
+		source[1].Metadata = map[string]float64{"rsi": 60}
+		require.NoError(t, WriteCSVFile(file, source, "rsi"))
+
+		content, err := os.ReadFile(file)
+		require.NoError(t, err)
+		assert.Equal(t, "time,open,close,low,high,volume,rsi\n"+
+			"1609459200,10,10,9,11,1,50.5\n"+
+			"1609462800,11,11,10,12,1,60\n", string(content))
+	})
+}
@@ -0,0 +1,86 @@
+package exchange
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+go-binance的WebSocket地址是包内常量，只能通过UseTestnet在正式网和测试网之间切换。
+配置了自定义的WebSocket地址（例如本地的交易所模拟器）时，使用wsServe直接连接，消息的解析方式与go-binance保持一致。
+其他交易所（例如Bybit）需要在连接后发送订阅消息并定时发送心跳，使用wsServeSubscribe。
+*/
+
+// wsServe 连接endpoint并在协程中读取消息，每条消息调用一次handler。
+// 连接断开或读取出错时调用errHandler并关闭doneC；关闭stopC可以主动断开连接。
+func wsServe(endpoint string, handler func(message []byte), errHandler func(error)) (doneC, stopC chan struct{}, err error) {
+	return wsServeSubscribe(endpoint, nil, nil, 0, handler, errHandler)
+}
+
+// wsServeSubscribe 与wsServe相同，连接成功后先发送subscribe消息，并且每隔interval发送一次ping消息保持连接。
+// subscribe或ping为空时不发送对应的消息。
+func wsServeSubscribe(endpoint string, subscribe, ping []byte, interval time.Duration,
+	handler func(message []byte), errHandler func(error)) (doneC, stopC chan struct{}, err error) {
+	conn, _, err := websocket.DefaultDialer.Dial(endpoint, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if subscribe != nil {
+		if err := conn.WriteMessage(websocket.TextMessage, subscribe); err != nil {
+			_ = conn.Close()
+			return nil, nil, err
+		}
+	}
+
+	doneC = make(chan struct{})
+	stopC = make(chan struct{})
+
+	go func() {
+		// 心跳只在这个协程中发送，订阅消息已经在启动协程前发送，连接上不会有并发写入
+		var tick <-chan time.Time
+		if ping != nil && interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		// 主动断开时关闭连接，让下面的ReadMessage返回
+		for {
+			select {
+			case <-stopC:
+				_ = conn.Close()
+				return
+			case <-doneC:
+				_ = conn.Close()
+				return
+			case <-tick:
+				if err := conn.WriteMessage(websocket.TextMessage, ping); err != nil {
+					// 写入失败说明连接已经断开，读取协程会报告错误
+					_ = conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	go func() {
+		defer close(doneC)
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				select {
+				case <-stopC:
+					// 主动断开的连接不需要报告错误
+				default:
+					errHandler(err)
+				}
+				return
+			}
+			handler(message)
+		}
+	}()
+
+	return doneC, stopC, nil
+}
@@ -0,0 +1,75 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// ConditionType 定义条件单的触发方向，即价格向上穿越还是向下穿越触发价。
+type ConditionType string
+
+// ConditionalStatusType 定义条件单在本地引擎中的状态。
+type ConditionalStatusType string
+
+// 以下是 ConditionType 的可能值。
+var (
+	ConditionTypeAbove ConditionType = "ABOVE" // 价格达到或高于触发价时触发，例如突破买入、空头止损、多头止盈
+	ConditionTypeBelow ConditionType = "BELOW" // 价格达到或低于触发价时触发，例如多头止损、跌破卖出、空头止盈
+)
+
+// 以下是 ConditionalStatusType 的可能值。
+var (
+	ConditionalStatusPending   ConditionalStatusType = "PENDING"   // 等待触发
+	ConditionalStatusTriggered ConditionalStatusType = "TRIGGERED" // 已触发，并成功向交易所提交了订单
+	ConditionalStatusCanceled  ConditionalStatusType = "CANCELED"  // 触发前被取消
+	ConditionalStatusFailed    ConditionalStatusType = "FAILED"    // 已触发，但提交订单失败
+)
+
+// ConditionalOrder 是由本地引擎模拟的条件单。价格满足触发条件后，
+// 会以市价单（Limit为0）或限价单（Limit大于0）的形式提交到交易所，买卖方向均可。
+type ConditionalOrder struct {
+	ID          int64                 `json:"id"`           // 本地生成的条件单ID
+	Pair        string                `json:"pair"`         // 交易对
+	Side        SideType              `json:"side"`         // 触发后提交订单的方向
+	Condition   ConditionType         `json:"condition"`    // 触发方向
+	Trigger     float64               `json:"trigger"`      // 触发价
+	Limit       float64               `json:"limit"`        // 触发后提交的限价，为0时提交市价单
+	Quantity    float64               `json:"quantity"`     // 订单数量
+	Status      ConditionalStatusType `json:"status"`       // 条件单状态
+	CreatedAt   time.Time             `json:"created_at"`   // 创建时间
+	CandleTime  time.Time             `json:"candle_time"`  // 创建时最新K线的开盘时间，这根K线之后的推送只用最新价检查
+	TriggeredAt time.Time             `json:"triggered_at"` // 触发时间
+	Order       *Order                `json:"order"`        // 触发后提交的订单
+	Err         error                 `json:"-"`            // 提交订单失败时的错误
+}
+
+// OrderType 返回与条件单语义对应的订单类型，便于日志和通知展示。
+// 卖出时向下触发视为止损，向上触发视为止盈；买入时方向相反。
+func (o ConditionalOrder) OrderType() OrderType {
+	stop := (o.Side == SideTypeSell) == (o.Condition == ConditionTypeBelow)
+	switch {
+	case stop && o.Limit > 0:
+		return OrderTypeStopLossLimit
+	case stop:
+		return OrderTypeStopLoss
+	case o.Limit > 0:
+		return OrderTypeTakeProfitLimit
+	default:
+		return OrderTypeTakeProfit
+	}
+}
+
+// IsTriggered 判断给定的价格区间[low, high]是否满足触发条件。
+// 对于报价，low和high传入同一个价格即可。
+func (o ConditionalOrder) IsTriggered(low, high float64) bool {
+	if o.Condition == ConditionTypeAbove {
+		return high >= o.Trigger
+	}
+	return low <= o.Trigger
+}
+
+// String 方法提供了条件单信息的字符串表示，便于打印和记录。
+func (o ConditionalOrder) String() string {
+	return fmt.Sprintf("[%s] %s %s | ID: %d, Type: %s, %s $%f, %f x $%f",
+		o.Status, o.Side, o.Pair, o.ID, o.OrderType(), o.Condition, o.Trigger, o.Quantity, o.Limit)
+}
@@ -29,6 +29,7 @@ type Balance struct {
 	Free     float64 // 可用余额 标识资产数量
 	Lock     float64 // 锁定余额这可能是因为你用这部分BTC作为了某个未平仓合约的保证金，或者你已经下了一个尚未成交的卖出订单。
 	Leverage float64 // 杠杆倍数
+	Venue    string  // 余额所在的交易所，只有通过Router使用多个交易所时才设置
 }
 
 // AssetInfo 定义了资产信息的结构
@@ -62,6 +63,9 @@ type Dataframe struct {
 
 	// 自定义用户元数据
 	Metadata map[string]Series[float64]
+
+	// OrderBook 是K线收盘时的订单簿副本，只有配置了深度数据源时才有值
+	OrderBook *OrderBook
 }
 
 // Sample 方法用于从Dataframe中抽取最近的N个数据点作为一个新的Dataframe
@@ -82,6 +86,7 @@ func (df Dataframe) Sample(positions int) Dataframe {
 		Time:       df.Time[start:],
 		LastUpdate: df.LastUpdate,
 		Metadata:   make(map[string]Series[float64]),
+		OrderBook:  df.OrderBook,
 	}
 
 	for key := range df.Metadata {
@@ -107,6 +112,16 @@ type Candle struct {
 	Metadata map[string]float64
 }
 
+// Tick 是一笔成交（逐笔或归集成交），用于从原始成交生成K线和非时间K线（成交笔数、成交量、成交额、Renko、价格区间）
+type Tick struct {
+	Pair       string    // 交易对
+	ID         int64     // 成交ID，交易所的归集成交ID或逐笔成交ID
+	Time       time.Time // 成交时间
+	Price      float64   // 成交价格
+	Quantity   float64   // 成交数量
+	BuyerMaker bool      // 买方是否是挂单方，为true时是主动卖出的成交
+}
+
 // Empty 方法用于判断一个K线是否为空
 func (c Candle) Empty() bool {
 	return c.Pair == "" && c.Close == 0 && c.Open == 0 && c.Volume == 0
@@ -208,6 +223,17 @@ func (a Account) Balance(assetTick, quoteTick string) (Balance, Balance) {
 	return assetBalance, quoteBalance
 }
 
+// Venue 返回指定交易所的余额，用于多个交易所的账户中按交易所查询余额。
+func (a Account) Venue(venue string) Account {
+	balances := make([]Balance, 0)
+	for _, balance := range a.Balances {
+		if balance.Venue == venue {
+			balances = append(balances, balance)
+		}
+	}
+	return Account{Balances: balances}
+}
+
 // Equity 方法计算并返回账户的总权益
 func (a Account) Equity() float64 {
 	var total float64
@@ -45,6 +45,16 @@ func TestAccount_Balance(t *testing.T) {
 	assetBalance, quoteBalance := account.Balance("A", "B")
 	require.Equal(t, Balance{Asset: "A", Free: 1.2, Lock: 1.0}, assetBalance)
 	require.Equal(t, Balance{Asset: "B", Free: 1.1, Lock: 1.3}, quoteBalance)
+
+	t.Run("venue", func(t *testing.T) {
+		account := Account{Balances: []Balance{
+			{Asset: "A", Free: 1, Venue: "binance"},
+			{Asset: "A", Free: 2, Venue: "okx"},
+		}}
+		assetBalance, _ := account.Venue("okx").Balance("A", "B")
+		require.Equal(t, 2.0, assetBalance.Free)
+		require.Empty(t, account.Venue("bybit").Balances)
+	})
 }
 
 func TestHeikinAshi_CalculateHeikinAshi(t *testing.T) {
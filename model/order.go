@@ -14,6 +14,9 @@ type OrderType string
 // OrderStatusType 定义订单的状态，如新建、部分成交、完全成交等。
 type OrderStatusType string
 
+// TimeInForceType 定义订单的有效方式，即订单在未成交时保留多久。
+type TimeInForceType string
+
 // 以下是 SideType 的可能值，表示买入或卖出。
 var (
 	SideTypeBuy  SideType = "BUY"
@@ -40,6 +43,15 @@ var (
 	OrderStatusTypePendingCancel   OrderStatusType = "PENDING_CANCEL"   // 取消中：取消订单的请求已提交，等待处理
 	OrderStatusTypeRejected        OrderStatusType = "REJECTED"         // 已拒绝：订单因某些原因被交易所拒绝
 	OrderStatusTypeExpired         OrderStatusType = "EXPIRED"          // 已过期：订单在成交前已过期
+	OrderStatusTypeVanished        OrderStatusType = "VANISHED"         // 已消失：本地记录为挂单，但交易所中已经查询不到，由对账器标记
+)
+
+// 以下是 TimeInForceType 的可能值。
+var (
+	TimeInForceGTC TimeInForceType = "GTC" // 一直有效直到成交或取消（Good Till Cancel），默认值
+	TimeInForceIOC TimeInForceType = "IOC" // 立即成交，未成交的部分马上取消（Immediate Or Cancel）
+	TimeInForceFOK TimeInForceType = "FOK" // 全部立即成交，否则整单取消（Fill Or Kill）
+	TimeInForceGTX TimeInForceType = "GTX" // 只做挂单（Post Only），如果下单时会立即成交则被拒绝
 )
 
 // Order 定义了订单的结构，包括订单的基本信息和状态。
@@ -60,6 +72,13 @@ type Order struct {
 	Stop    *float64 `db:"stop" json:"stop"`         // 止损价格
 	GroupID *int64   `db:"group_id" json:"group_id"` // 订单组ID，用于将多个订单关联在一起
 
+	ClientOrderID string          `db:"client_order_id" json:"client_order_id"` // 调用方指定的订单ID，用于幂等提交和日志关联
+	TimeInForce   TimeInForceType `db:"time_in_force" json:"time_in_force"`     // 订单有效方式，如GTC、IOC、FOK、GTX
+	ExpireAt      *time.Time      `db:"expire_at" json:"expire_at"`             // 订单过期时间，到期未成交的限价单会被置为EXPIRED
+	ReplacedID    *int64          `db:"replaced_id" json:"replaced_id"`         // 改单时被替换的原订单的交易所ID，用于追溯改单链路
+
+	PositionSide PositionSideType `db:"position_side" json:"position_side"` // 双向持仓模式下订单所属的持仓方向（LONG/SHORT），单向持仓为空
+
 	// 以下字段仅用于内部使用，不持久化到数据库
 	RefPrice    float64 `json:"ref_price" gorm:"-"`    // 参考价格，用于内部计算，列如在执行止损订单时，可能需要比较订单的止损价格与当前市场价格或参考价格来确定是否触发止损条件。
 	Profit      float64 `json:"profit" gorm:"-"`       // 利润，用于内部计算
@@ -69,6 +88,115 @@ type Order struct {
 
 // String 方法提供了订单信息的字符串表示，便于打印和记录。
 func (o Order) String() string {
-	return fmt.Sprintf("[%s] %s %s | ID: %d, Type: %s, %f x $%f (~$%.f)",
+	description := fmt.Sprintf("[%s] %s %s | ID: %d, Type: %s, %f x $%f (~$%.f)",
 		o.Status, o.Side, o.Pair, o.ID, o.Type, o.Quantity, o.Price, o.Quantity*o.Price)
+	// 只有调用方指定了客户端订单ID时才追加，保持原有格式不变
+	if o.ClientOrderID != "" {
+		description += fmt.Sprintf(", ClientID: %s", o.ClientOrderID)
+	}
+	if o.PositionSide != "" {
+		description += fmt.Sprintf(", Position: %s", o.PositionSide)
+	}
+	return description
+}
+
+// OrderParams 是创建订单时的可选参数，所有下单方法都通过 OrderOption 接收。
+type OrderParams struct {
+	TimeInForce   TimeInForceType  // 订单有效方式，为空时由交易所使用默认值（GTC）
+	ExpireAt      *time.Time       // 过期时间，只对限价类订单生效
+	ClientOrderID string           // 客户端订单ID
+	PositionSide  PositionSideType // 双向持仓模式下的持仓方向，为空时表示单向持仓
+}
+
+// OrderOption 是配置订单参数的函数类型。
+type OrderOption func(*OrderParams)
+
+// WithTimeInForce 设置订单的有效方式。
+func WithTimeInForce(timeInForce TimeInForceType) OrderOption {
+	return func(params *OrderParams) {
+		params.TimeInForce = timeInForce
+	}
+}
+
+// WithPostOnly 设置订单只做挂单，等价于 WithTimeInForce(TimeInForceGTX)。
+func WithPostOnly() OrderOption {
+	return WithTimeInForce(TimeInForceGTX)
+}
+
+// WithExpireAt 设置订单的过期时间（Good Till Time），到期后未成交的订单会被置为EXPIRED。
+func WithExpireAt(expireAt time.Time) OrderOption {
+	return func(params *OrderParams) {
+		params.ExpireAt = &expireAt
+	}
+}
+
+// WithClientOrderID 设置调用方指定的订单ID，重复提交相同ID的订单会返回已存在的订单。
+func WithClientOrderID(id string) OrderOption {
+	return func(params *OrderParams) {
+		params.ClientOrderID = id
+	}
+}
+
+// WithPositionSide 设置订单所属的持仓方向，用于合约的双向持仓模式：
+// 开多为BUY+LONG，平多为SELL+LONG，开空为SELL+SHORT，平空为BUY+SHORT。
+func WithPositionSide(side PositionSideType) OrderOption {
+	return func(params *OrderParams) {
+		params.PositionSide = side
+	}
+}
+
+// NewOrderParams 应用所有选项并返回订单参数。
+func NewOrderParams(options ...OrderOption) OrderParams {
+	var params OrderParams
+	for _, option := range options {
+		option(&params)
+	}
+	return params
+}
+
+// Apply 将订单参数写入订单，交易所返回的值优先。
+func (p OrderParams) Apply(order *Order) {
+	if order.ClientOrderID == "" {
+		order.ClientOrderID = p.ClientOrderID
+	}
+	if order.TimeInForce == "" {
+		order.TimeInForce = p.TimeInForce
+	}
+	if order.ExpireAt == nil {
+		order.ExpireAt = p.ExpireAt
+	}
+	if order.PositionSide == "" {
+		order.PositionSide = p.PositionSide
+	}
+}
+
+// IsExpired 判断订单在给定时间是否已经过期。
+func (o Order) IsExpired(now time.Time) bool {
+	return o.ExpireAt != nil && !now.Before(*o.ExpireAt)
+}
+
+// IsReplaceable 判断订单是否可以改单，只有仍在挂单中的限价单（OCO订单除外）才能修改价格和数量。
+func (o Order) IsReplaceable() bool {
+	pending := o.Status == OrderStatusTypeNew || o.Status == OrderStatusTypePartiallyFilled
+	limit := o.Type == OrderTypeLimit || o.Type == OrderTypeLimitMaker
+	return pending && limit && o.GroupID == nil
+}
+
+// ReplaceOptions 返回改单时需要沿用的订单参数，新订单保持原订单的有效方式和过期时间。
+// 客户端订单ID不沿用，避免重复提交检查返回已经被取消的原订单。
+func (o Order) ReplaceOptions() []OrderOption {
+	var options []OrderOption
+	switch {
+	case o.TimeInForce != "":
+		options = append(options, WithTimeInForce(o.TimeInForce))
+	case o.Type == OrderTypeLimitMaker:
+		options = append(options, WithPostOnly())
+	}
+	if o.ExpireAt != nil {
+		options = append(options, WithExpireAt(*o.ExpireAt))
+	}
+	if o.PositionSide != "" {
+		options = append(options, WithPositionSide(o.PositionSide))
+	}
+	return options
 }
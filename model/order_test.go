@@ -22,3 +22,26 @@ func TestOrder_String(t *testing.T) {
 	}
 	require.Equal(t, "[FILLED] SELL BNBUSDT | ID: 1, Type: LIMIT, 1.000000 x $10.000000 (~$10)", order.String())
 }
+
+func TestOrder_ClientOrderID(t *testing.T) {
+	expireAt := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	params := NewOrderParams(WithClientOrderID("my-order"), WithPostOnly(), WithExpireAt(expireAt))
+
+	order := Order{
+		ID:       1,
+		Pair:     "BNBUSDT",
+		Side:     SideTypeBuy,
+		Type:     OrderTypeLimit,
+		Status:   OrderStatusTypeNew,
+		Price:    10,
+		Quantity: 1,
+	}
+	params.Apply(&order)
+
+	require.Equal(t, "my-order", order.ClientOrderID)
+	require.Equal(t, TimeInForceGTX, order.TimeInForce)
+	require.Equal(t, "[NEW] BUY BNBUSDT | ID: 1, Type: LIMIT, 1.000000 x $10.000000 (~$10), ClientID: my-order",
+		order.String())
+	require.False(t, order.IsExpired(expireAt.Add(-time.Second)))
+	require.True(t, order.IsExpired(expireAt))
+}
@@ -0,0 +1,285 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrOrderBookGap 表示增量更新与订单簿之间缺少更新，需要重新获取快照
+	ErrOrderBookGap = errors.New("order book gap")
+	// ErrOrderBookNotSynced 表示订单簿还没有快照，不能应用增量更新
+	ErrOrderBookNotSynced = errors.New("order book not synced")
+)
+
+// PriceLevel 是订单簿中的一个价格档位，增量更新中数量为0表示删除这个档位
+type PriceLevel struct {
+	Price    float64 `json:"p"`
+	Quantity float64 `json:"q"`
+}
+
+/*
+DepthUpdate 是订单簿的快照或增量更新，与币安的深度推送相同：
+增量更新包含[FirstUpdateID, LastUpdateID]范围内的所有变化，档位的数量是变化后的数量而不是变化量。
+快照的FirstUpdateID为0，LastUpdateID是快照对应的最后一次更新。
+*/
+type DepthUpdate struct {
+	Pair          string       `json:"pair"`
+	Time          time.Time    `json:"time"`
+	FirstUpdateID int64        `json:"first"`
+	LastUpdateID  int64        `json:"last"`
+	Bids          []PriceLevel `json:"bids"`
+	Asks          []PriceLevel `json:"asks"`
+	Snapshot      bool         `json:"snapshot,omitempty"`
+}
+
+/*
+OrderBook 是一个交易对的订单簿，买单按价格从高到低排列，卖单按价格从低到高排列。
+
+先应用快照，之后的增量更新需要与上一次更新连续：LastUpdateID不大于订单簿的更新被忽略，
+FirstUpdateID大于订单簿LastUpdateID+1时返回ErrOrderBookGap。更新ID都为0时不检查连续性，用于没有更新ID的数据源。
+*/
+type OrderBook struct {
+	Pair         string
+	Time         time.Time    // Time 最后一次更新的时间
+	LastUpdateID int64        // LastUpdateID 最后一次更新的ID
+	Bids         []PriceLevel // Bids 买单，价格从高到低
+	Asks         []PriceLevel // Asks 卖单，价格从低到高
+	synced       bool
+}
+
+// NewOrderBook 创建一个空的订单簿，需要先应用快照
+func NewOrderBook(pair string) *OrderBook {
+	return &OrderBook{Pair: pair}
+}
+
+// Synced 订单簿是否已经应用了快照
+func (b *OrderBook) Synced() bool {
+	return b.synced
+}
+
+// Reset 清空订单簿，之后需要重新应用快照
+func (b *OrderBook) Reset() {
+	b.Bids, b.Asks = nil, nil
+	b.LastUpdateID = 0
+	b.synced = false
+}
+
+// Apply 应用快照或增量更新
+func (b *OrderBook) Apply(update DepthUpdate) error {
+	if update.Snapshot {
+		b.Bids = sortLevels(update.Bids, true)
+		b.Asks = sortLevels(update.Asks, false)
+		b.LastUpdateID = update.LastUpdateID
+		b.Time = update.Time
+		b.synced = true
+		return nil
+	}
+
+	if !b.synced {
+		return fmt.Errorf("%w: %s", ErrOrderBookNotSynced, b.Pair)
+	}
+	if update.FirstUpdateID != 0 || update.LastUpdateID != 0 {
+		if update.LastUpdateID <= b.LastUpdateID {
+			return nil
+		}
+		if update.FirstUpdateID > b.LastUpdateID+1 {
+			return fmt.Errorf("%w: %s: expected update %d, got %d", ErrOrderBookGap, b.Pair,
+				b.LastUpdateID+1, update.FirstUpdateID)
+		}
+		b.LastUpdateID = update.LastUpdateID
+	}
+
+	for _, level := range update.Bids {
+		b.Bids = updateLevel(b.Bids, level, true)
+	}
+	for _, level := range update.Asks {
+		b.Asks = updateLevel(b.Asks, level, false)
+	}
+	b.Time = update.Time
+	return nil
+}
+
+// Snapshot 返回订单簿当前状态的快照，levels大于0时只包含前levels档
+func (b *OrderBook) Snapshot(levels int) DepthUpdate {
+	return DepthUpdate{
+		Pair:         b.Pair,
+		Time:         b.Time,
+		LastUpdateID: b.LastUpdateID,
+		Bids:         topLevels(b.Bids, levels),
+		Asks:         topLevels(b.Asks, levels),
+		Snapshot:     true,
+	}
+}
+
+// Copy 返回订单簿的副本，levels大于0时只包含前levels档
+func (b *OrderBook) Copy(levels int) OrderBook {
+	book := *b
+	book.Bids = topLevels(b.Bids, levels)
+	book.Asks = topLevels(b.Asks, levels)
+	return book
+}
+
+// BestBid 返回最高的买价，没有买单时返回false
+func (b *OrderBook) BestBid() (PriceLevel, bool) {
+	if len(b.Bids) == 0 {
+		return PriceLevel{}, false
+	}
+	return b.Bids[0], true
+}
+
+// BestAsk 返回最低的卖价，没有卖单时返回false
+func (b *OrderBook) BestAsk() (PriceLevel, bool) {
+	if len(b.Asks) == 0 {
+		return PriceLevel{}, false
+	}
+	return b.Asks[0], true
+}
+
+// MidPrice 返回最高买价和最低卖价的平均值，任何一边没有订单时返回0
+func (b *OrderBook) MidPrice() float64 {
+	bid, okBid := b.BestBid()
+	ask, okAsk := b.BestAsk()
+	if !okBid || !okAsk {
+		return 0
+	}
+	return (bid.Price + ask.Price) / 2
+}
+
+// Spread 返回买卖价差，任何一边没有订单时返回0
+func (b *OrderBook) Spread() float64 {
+	bid, okBid := b.BestBid()
+	ask, okAsk := b.BestAsk()
+	if !okBid || !okAsk {
+		return 0
+	}
+	return ask.Price - bid.Price
+}
+
+// SpreadBps 返回价差相对于中间价的基点数
+func (b *OrderBook) SpreadBps() float64 {
+	mid := b.MidPrice()
+	if mid == 0 {
+		return 0
+	}
+	return b.Spread() / mid * 10000
+}
+
+// MicroPrice 返回按最优档位数量加权的价格，买单数量越多越接近卖价
+func (b *OrderBook) MicroPrice() float64 {
+	bid, okBid := b.BestBid()
+	ask, okAsk := b.BestAsk()
+	if !okBid || !okAsk || bid.Quantity+ask.Quantity == 0 {
+		return b.MidPrice()
+	}
+	return (bid.Price*ask.Quantity + ask.Price*bid.Quantity) / (bid.Quantity + ask.Quantity)
+}
+
+// Imbalance 返回前levels档买卖数量的不平衡度，范围[-1, 1]，正数表示买单更多，levels小于等于0时使用所有档位
+func (b *OrderBook) Imbalance(levels int) float64 {
+	bids := b.Depth(SideTypeBuy, levels)
+	asks := b.Depth(SideTypeSell, levels)
+	if bids+asks == 0 {
+		return 0
+	}
+	return (bids - asks) / (bids + asks)
+}
+
+// Depth 返回一边前levels档的总数量，side为买时是买单，levels小于等于0时使用所有档位
+func (b *OrderBook) Depth(side SideType, levels int) float64 {
+	var total float64
+	for _, level := range topLevels(b.side(side), levels) {
+		total += level.Quantity
+	}
+	return total
+}
+
+/*
+Fill 模拟一个数量为quantity的市价单吃掉对手方的档位，返回成交均价和成交数量，买单吃卖单，卖单吃买单。
+limit大于0时只成交不差于limit的档位。对手方的数量不足时成交数量小于quantity，订单簿本身不会被修改。
+*/
+func (b *OrderBook) Fill(side SideType, quantity, limit float64) (price, filled float64) {
+	levels := b.Asks
+	if side == SideTypeSell {
+		levels = b.Bids
+	}
+
+	var value float64
+	for _, level := range levels {
+		if filled >= quantity {
+			break
+		}
+		if limit > 0 && ((side == SideTypeBuy && level.Price > limit) ||
+			(side == SideTypeSell && level.Price < limit)) {
+			break
+		}
+		amount := math.Min(level.Quantity, quantity-filled)
+		value += amount * level.Price
+		filled += amount
+	}
+	if filled == 0 {
+		return 0, 0
+	}
+	return value / filled, filled
+}
+
+func (b *OrderBook) side(side SideType) []PriceLevel {
+	if side == SideTypeBuy {
+		return b.Bids
+	}
+	return b.Asks
+}
+
+// sortLevels 复制并排序档位，去掉数量为0的档位
+func sortLevels(levels []PriceLevel, descending bool) []PriceLevel {
+	result := make([]PriceLevel, 0, len(levels))
+	for _, level := range levels {
+		if level.Quantity > 0 {
+			result = append(result, level)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if descending {
+			return result[i].Price > result[j].Price
+		}
+		return result[i].Price < result[j].Price
+	})
+	return result
+}
+
+// updateLevel 按价格更新档位，数量为0时删除
+func updateLevel(levels []PriceLevel, level PriceLevel, descending bool) []PriceLevel {
+	index := sort.Search(len(levels), func(i int) bool {
+		if descending {
+			return levels[i].Price <= level.Price
+		}
+		return levels[i].Price >= level.Price
+	})
+
+	exists := index < len(levels) && levels[index].Price == level.Price
+	switch {
+	case level.Quantity <= 0 && exists:
+		return append(levels[:index], levels[index+1:]...)
+	case level.Quantity <= 0:
+		return levels
+	case exists:
+		levels[index].Quantity = level.Quantity
+		return levels
+	}
+
+	levels = append(levels, PriceLevel{})
+	copy(levels[index+1:], levels[index:])
+	levels[index] = level
+	return levels
+}
+
+// topLevels 复制前levels档，levels小于等于0时复制所有档位
+func topLevels(levels []PriceLevel, count int) []PriceLevel {
+	if count <= 0 || count > len(levels) {
+		count = len(levels)
+	}
+	return append([]PriceLevel(nil), levels[:count]...)
+}
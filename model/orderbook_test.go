@@ -0,0 +1,87 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderBook(t *testing.T) {
+	snapshot := DepthUpdate{
+		LastUpdateID: 10,
+		Bids:         []PriceLevel{{Price: 99, Quantity: 2}, {Price: 100, Quantity: 1}, {Price: 98, Quantity: 0}},
+		Asks:         []PriceLevel{{Price: 102, Quantity: 3}, {Price: 101, Quantity: 1}},
+		Snapshot:     true,
+	}
+
+	t.Run("apply", func(t *testing.T) {
+		book := NewOrderBook("BTCUSDT")
+		require.ErrorIs(t, book.Apply(DepthUpdate{FirstUpdateID: 1, LastUpdateID: 2}), ErrOrderBookNotSynced)
+
+		require.NoError(t, book.Apply(snapshot))
+		assert.Equal(t, []PriceLevel{{Price: 100, Quantity: 1}, {Price: 99, Quantity: 2}}, book.Bids)
+		assert.Equal(t, []PriceLevel{{Price: 101, Quantity: 1}, {Price: 102, Quantity: 3}}, book.Asks)
+
+		// 快照之前的更新被忽略
+		require.NoError(t, book.Apply(DepthUpdate{FirstUpdateID: 5, LastUpdateID: 10,
+			Bids: []PriceLevel{{Price: 100, Quantity: 0}}}))
+		assert.Len(t, book.Bids, 2)
+
+		// 跨过快照的更新：修改、插入和删除档位
+		require.NoError(t, book.Apply(DepthUpdate{FirstUpdateID: 8, LastUpdateID: 12,
+			Bids: []PriceLevel{{Price: 100, Quantity: 0}, {Price: 99.5, Quantity: 4}},
+			Asks: []PriceLevel{{Price: 101.5, Quantity: 2}, {Price: 102, Quantity: 5}, {Price: 103, Quantity: 0}}}))
+		assert.Equal(t, []PriceLevel{{Price: 99.5, Quantity: 4}, {Price: 99, Quantity: 2}}, book.Bids)
+		assert.Equal(t, []PriceLevel{{Price: 101, Quantity: 1}, {Price: 101.5, Quantity: 2}, {Price: 102, Quantity: 5}},
+			book.Asks)
+		assert.Equal(t, int64(12), book.LastUpdateID)
+
+		require.ErrorIs(t, book.Apply(DepthUpdate{FirstUpdateID: 14, LastUpdateID: 15}), ErrOrderBookGap)
+
+		book.Reset()
+		assert.False(t, book.Synced())
+		assert.Empty(t, book.Bids)
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		book := NewOrderBook("BTCUSDT")
+		require.NoError(t, book.Apply(snapshot))
+
+		assert.Equal(t, 100.5, book.MidPrice())
+		assert.Equal(t, 1.0, book.Spread())
+		assert.InDelta(t, 99.5, book.SpreadBps(), 0.1)
+		assert.Equal(t, 100.5, book.MicroPrice())
+		assert.Equal(t, 0.0, book.Imbalance(1))
+		assert.InDelta(t, (3.0-4.0)/7.0, book.Imbalance(0), 1e-9)
+		assert.Equal(t, 3.0, book.Depth(SideTypeBuy, 0))
+
+		empty := NewOrderBook("BTCUSDT")
+		assert.Equal(t, 0.0, empty.MidPrice())
+		assert.Equal(t, 0.0, empty.Imbalance(5))
+	})
+
+	t.Run("fill", func(t *testing.T) {
+		book := NewOrderBook("BTCUSDT")
+		require.NoError(t, book.Apply(snapshot))
+
+		price, filled := book.Fill(SideTypeBuy, 2, 0)
+		assert.Equal(t, 2.0, filled)
+		assert.Equal(t, 101.5, price)
+
+		price, filled = book.Fill(SideTypeSell, 10, 0)
+		assert.Equal(t, 3.0, filled)
+		assert.InDelta(t, (100+2*99)/3.0, price, 1e-9)
+
+		// 限价只成交不差于限价的档位
+		price, filled = book.Fill(SideTypeBuy, 2, 101)
+		assert.Equal(t, 1.0, filled)
+		assert.Equal(t, 101.0, price)
+
+		// 副本与原订单簿互不影响
+		copied := book.Copy(1)
+		copied.Bids[0].Quantity = 42
+		assert.Len(t, copied.Asks, 1)
+		assert.Equal(t, 1.0, book.Bids[0].Quantity)
+	})
+}
@@ -0,0 +1,91 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// PositionSideType 定义持仓方向。
+type PositionSideType string
+
+// 以下是 PositionSideType 的可能值。
+var (
+	PositionSideTypeLong  PositionSideType = "LONG"  // 多头持仓
+	PositionSideTypeShort PositionSideType = "SHORT" // 空头持仓
+)
+
+// OpenSide 返回在这个持仓方向上开仓的订单方向，多头为BUY，空头为SELL，反方向的订单是平仓单。
+func (s PositionSideType) OpenSide() SideType {
+	if s == PositionSideTypeShort {
+		return SideTypeSell
+	}
+	return SideTypeBuy
+}
+
+// PositionInfo 是交易所中某个交易对的持仓详情。现货只能提供数量（以及行情价），
+// 合约还会提供开仓均价、未实现盈亏、杠杆、保证金和强平价，交易所不提供的字段为0。
+type PositionInfo struct {
+	Pair             string           `json:"pair"`              // 交易对
+	Side             PositionSideType `json:"side"`              // 持仓方向，没有持仓时为空
+	Quantity         float64          `json:"quantity"`          // 持仓数量，总是非负数，方向见Side
+	EntryPrice       float64          `json:"entry_price"`       // 开仓均价
+	MarkPrice        float64          `json:"mark_price"`        // 标记价格，现货为最新成交价
+	UnrealizedPnL    float64          `json:"unrealized_pnl"`    // 按标记价格计算的未实现盈亏
+	Leverage         float64          `json:"leverage"`          // 杠杆倍数，现货为1
+	Margin           float64          `json:"margin"`            // 持仓占用的保证金
+	Isolated         bool             `json:"isolated"`          // 是否为逐仓模式
+	LiquidationPrice float64          `json:"liquidation_price"` // 强平价，不会被强平或者交易所不提供时为0
+	UpdatedAt        time.Time        `json:"updated_at"`        // 更新时间
+}
+
+// NewPositionInfo 根据带符号的持仓数量创建持仓信息，正数为多头，负数为空头，0表示没有持仓。
+func NewPositionInfo(pair string, size float64) PositionInfo {
+	position := PositionInfo{Pair: pair, Quantity: size, Leverage: 1}
+	switch {
+	case size > 0:
+		position.Side = PositionSideTypeLong
+	case size < 0:
+		position.Side = PositionSideTypeShort
+		position.Quantity = -size
+	}
+	return position
+}
+
+// Open 判断是否持有仓位。
+func (p PositionInfo) Open() bool {
+	return p.Side != "" && p.Quantity > 0
+}
+
+// Size 返回带符号的持仓数量，空头为负数，与Broker.Position返回的基础资产数量一致。
+func (p PositionInfo) Size() float64 {
+	if p.Side == PositionSideTypeShort {
+		return -p.Quantity
+	}
+	return p.Quantity
+}
+
+// Notional 返回按标记价格计算的持仓名义价值，没有标记价格时使用开仓均价。
+func (p PositionInfo) Notional() float64 {
+	price := p.MarkPrice
+	if price == 0 {
+		price = p.EntryPrice
+	}
+	return p.Quantity * price
+}
+
+// PnL 计算持仓按price平仓时的盈亏，多头价格上涨盈利，空头价格下跌盈利。
+func (p PositionInfo) PnL(price float64) float64 {
+	if p.EntryPrice == 0 {
+		return 0
+	}
+	return (price - p.EntryPrice) * p.Size()
+}
+
+// String 返回持仓的可读描述，用于日志和通知。
+func (p PositionInfo) String() string {
+	if !p.Open() {
+		return fmt.Sprintf("[%s] FLAT", p.Pair)
+	}
+	return fmt.Sprintf("[%s] %s %f @ %f | Leverage: %.0fx | PnL: %.2f | Liquidation: %f",
+		p.Pair, p.Side, p.Quantity, p.EntryPrice, p.Leverage, p.UnrealizedPnL, p.LiquidationPrice)
+}
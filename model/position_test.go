@@ -0,0 +1,37 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPositionInfo(t *testing.T) {
+	t.Run("long", func(t *testing.T) {
+		position := NewPositionInfo("BTCUSDT", 2)
+		position.EntryPrice = 100
+		require.True(t, position.Open())
+		require.Equal(t, PositionSideTypeLong, position.Side)
+		require.Equal(t, 2.0, position.Size())
+		require.Equal(t, 20.0, position.PnL(110))
+		require.Equal(t, 200.0, position.Notional())
+	})
+
+	t.Run("short", func(t *testing.T) {
+		position := NewPositionInfo("BTCUSDT", -2)
+		position.EntryPrice = 100
+		position.MarkPrice = 90
+		require.Equal(t, PositionSideTypeShort, position.Side)
+		require.Equal(t, 2.0, position.Quantity)
+		require.Equal(t, -2.0, position.Size())
+		require.Equal(t, 20.0, position.PnL(90))
+		require.Equal(t, 180.0, position.Notional())
+	})
+
+	t.Run("flat", func(t *testing.T) {
+		position := NewPositionInfo("BTCUSDT", 0)
+		require.False(t, position.Open())
+		require.Equal(t, 0.0, position.PnL(100))
+		require.Equal(t, "[BTCUSDT] FLAT", position.String())
+	})
+}
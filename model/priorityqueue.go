@@ -74,7 +74,7 @@ func (q *PriorityQueue) Pop() Item {
 	q.length--
 	if q.length > 0 {
 		// 将最后一个元素移动到顶部，并执行下沉操作
-		q.data[0] = q.data[q.length-1]
+		q.data[0] = q.data[q.length]
 		q.down(0)
 	}
 	// 移除并返回顶部元素
@@ -42,3 +42,20 @@ func TestPriorityQueue_Len(t *testing.T) {
 	pq = NewPriorityQueue([]Item{Candle{Pair: "A"}})
 	require.Equal(t, 1, pq.Len())
 }
+
+func TestPriorityQueue_PopPush(t *testing.T) {
+	now := time.Now()
+	pq := NewPriorityQueue(nil)
+	pq.Push(Candle{Time: now.Add(2 * time.Minute), Close: 3})
+	pq.Push(Candle{Time: now, Close: 1})
+	pq.Push(Candle{Time: now.Add(3 * time.Minute), Close: 4})
+
+	// 弹出之后最后一个元素移动到堆顶，不能丢失或重复
+	require.Equal(t, 1.0, pq.Pop().(Candle).Close)
+	pq.Push(Candle{Time: now.Add(time.Minute), Close: 2})
+	require.Equal(t, 3, pq.Len())
+	require.Equal(t, 2.0, pq.Pop().(Candle).Close)
+	require.Equal(t, 3.0, pq.Pop().(Candle).Close)
+	require.Equal(t, 4.0, pq.Pop().(Candle).Close)
+	require.Nil(t, pq.Pop())
+}
@@ -5,8 +5,11 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"math"
 	"os"
+	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/aybabtme/uniplot/histogram"
 
@@ -14,6 +17,7 @@ import (
 	"github.com/rodrigo-brito/ninjabot/model"
 	"github.com/rodrigo-brito/ninjabot/notification"
 	"github.com/rodrigo-brito/ninjabot/order"
+	"github.com/rodrigo-brito/ninjabot/parquet"
 	"github.com/rodrigo-brito/ninjabot/service"
 	"github.com/rodrigo-brito/ninjabot/storage"
 	"github.com/rodrigo-brito/ninjabot/strategy"
@@ -47,6 +51,11 @@ type CandleSubscriber interface {
 	OnCandle(model.Candle)
 }
 
+// candleCounter 由可以在回放之前知道K线总数的数据源实现，例如K线都在内存中的CSVFeed
+type candleCounter interface {
+	CandleCount(pair, timeframe string) (int, bool)
+}
+
 // NinjaBot 结构体定义了机器人的主体结构
 type NinjaBot struct {
 	// 这是一个存储接口，用于数据的持久化。这可能包括保存交易数据、用户设置或其他重要信息。
@@ -70,6 +79,15 @@ type NinjaBot struct {
 	orderFeed             *order.Feed                     // 订单更新订阅源
 	dataFeed              *exchange.DataFeedSubscription  // 数据订阅源，订阅交易所的数据流
 	paperWallet           *exchange.PaperWallet           // 模拟钱包，用于回测和模拟交易
+	depthFeed             *exchange.DepthFeed             // 订单簿数据源，不为空时策略可以通过dataframe.OrderBook读取订单簿
+
+	reconcileInterval time.Duration // 与交易所对账的间隔，为0时不对账
+
+	resampleTimeframe string             // 不为空时策略的K线由这个时间周期的K线重采样得到
+	resampleAlignment exchange.Alignment // 重采样时周期的边界
+
+	backtestProgress *progressbar.ProgressBar // 回测进度条
+	resultsDir       string                   // 不为空时回测结束后把交易明细和权益曲线以Parquet格式导出到这个目录
 
 	backtest bool // 一个标志，指示机器人是否处于回测模式。在回测模式下，机器人不会执行实际的交易命令，而是通过历史数据来测试策略的表现。
 }
@@ -117,6 +135,13 @@ func NewBot(ctx context.Context, settings model.Settings, exch service.Exchange,
 		option(bot)
 	}
 
+	// 检查重采样的配置，源周期不能与策略的时间周期相同
+	if bot.resampleTimeframe != "" {
+		if err := exchange.ValidateResample(bot.resampleTimeframe, str.Timeframe()); err != nil {
+			return nil, err
+		}
+	}
+
 	var err error
 	//检查是否已经有一个存储接口被设置到 NinjaBot 实例中。如果 storage 字段是 nil，意味着还没有任何存储方式被指定。
 	// 检查 bot.storage 是否已经初始化。如果 bot.storage 是 nil，表示尚未设置任何存储接口。
@@ -134,6 +159,14 @@ func NewBot(ctx context.Context, settings model.Settings, exch service.Exchange,
 	// 初始化订单控制器，管理订单的生命周期。
 	bot.orderController = order.NewController(ctx, exch, bot.storage, bot.orderFeed)
 
+	// 回测时订单全部由机器人自己创建，不需要对账
+	if bot.reconcileInterval > 0 && !bot.backtest {
+		bot.orderController.SetReconcile(order.ReconcileConfig{
+			Interval: bot.reconcileInterval,
+			Pairs:    settings.Pairs,
+		})
+	}
+
 	// 如果 Telegram 通知被启用，则设置并注册 Telegram 服务。
 	if settings.Telegram.Enabled {
 		//使用notification.NewTelegram函数来创建一个新的Telegram通知服务，这个函数接受两个参数：一个订单控制器（bot.orderController）和机器人的设置（settings）
@@ -228,6 +261,40 @@ func (n *NinjaBot) SubscribeCandle(subscriptions ...CandleSubscriber) {
 	}
 }
 
+// WithResample 订阅交易对sourceTimeframe的K线，按alignment重采样为策略的时间周期，
+// 用于交易所不支持的时间周期，如用15m的K线合成45m，或者按其他时区和交易日划分日线。预热数据同样由源K线重采样得到。
+func WithResample(sourceTimeframe string, alignment exchange.Alignment) Option {
+	return func(bot *NinjaBot) {
+		bot.resampleTimeframe = sourceTimeframe
+		bot.resampleAlignment = alignment
+	}
+}
+
+// WithDepthFeed 为策略提供订单簿：处理每根K线之前把交易对的订单簿副本放入dataframe.OrderBook。
+// 回测时订单簿按录制的数据推进到K线收盘的时间，实时运行时由DepthFeed持续更新。
+// 需要模拟钱包按订单簿成交时，同一个DepthFeed还要通过exchange.WithPaperDepth传给PaperWallet。
+func WithDepthFeed(feed *exchange.DepthFeed) Option {
+	return func(bot *NinjaBot) {
+		bot.depthFeed = feed
+	}
+}
+
+// WithOrderReconciliation 启用定期对账：按interval间隔比较交易所的订单、持仓与本地存储，
+// 导入手动下的订单，标记消失的订单，并通过通知器报告余额偏差。
+func WithOrderReconciliation(interval time.Duration) Option {
+	return func(bot *NinjaBot) {
+		bot.reconcileInterval = interval
+	}
+}
+
+// WithResultsExport 回测结束后把交易明细（trades.parquet）和模拟钱包的权益曲线（equity.parquet）导出到dir，
+// 目录不存在时自动创建，导出的文件可以直接用pandas、DuckDB等工具分析。
+func WithResultsExport(dir string) Option {
+	return func(bot *NinjaBot) {
+		bot.resultsDir = dir
+	}
+}
+
 // WithOrderSubscription 为机器人添加一个订单更新的订阅者。
 func WithOrderSubscription(subscriber OrderSubscriber) Option {
 	// 返回一个符合 Option 类型（func(*NinjaBot)）的函数
@@ -412,22 +479,67 @@ func (n *NinjaBot) onCandle(candle model.Candle) {
 // processCandle 处理从队列中获取的K线数据，更新钱包和策略控制器的状态。
 // candle 是从队列中获取的K线数据。
 func (n *NinjaBot) processCandle(candle model.Candle) {
+	// 先更新订单簿，模拟钱包和策略看到的都是K线收盘时的订单簿
+	if n.depthFeed != nil {
+		n.updateOrderBook(candle)
+	}
+
 	// 如果虚拟钱包paperWallet实例存在，传入真实的k线数据更新它的状态，如检查订单状态，更新订单状态和交易量，更新虚拟账户中的资产数量和平均价格 等
 	if n.paperWallet != nil {
 		n.paperWallet.OnCandle(candle)
 	}
 
+	// 订单控制器先于策略处理K线：之前创建的条件单用这根K线的价格区间检查，
+	// 策略处理这根K线时新建的条件单要等到下一根K线才会检查，避免用到未来数据
+	if candle.Complete {
+		// 让订单控制器处理完整的K线，更新收盘价
+		n.orderController.OnCandle(candle)
+	} else {
+		// 未完成的K线只用于检查条件单是否触发
+		n.orderController.OnPartialCandle(candle)
+	}
+
 	// 更新对应交易对的策略控制器，处理部分完成的K线，K线数据通常在特定时间间隔结束时被认为是完成的，比如一分钟、一小时等。但在实际交易中，可能需要在K线完全形成之前做出反应，特别是在高频交易或某些需要快速响应市场变动的策略中。OnPartialCandle 方法就是用于这种情况，它允许策略在K线数据还在形成中时就开始处理和分析这些数据。通过这种方法，交易机器人可以更快地响应市场变化，不必等到完整的K线数据形成后才做出决策。这对于捕捉短暂的市场机会尤其重要。
 	n.strategiesControllers[candle.Pair].OnPartialCandle(candle)
 	// 如果K线完全完成（比如时间完结或达到其它完成条件）
 	if candle.Complete {
 		// 更新策略控制器状态，处理完整的K线，这个方法是根据k线完全形成而分析的，策略控制器可能会分析K线数据的特征（如价格变动、交易量等），并根据策略算法决定是否保持当前持仓、买入或卖出。例如，策略可能会在检测到价格突破支持线时决定买入。
 		n.strategiesControllers[candle.Pair].OnCandle(candle)
-		// 让订单控制器处理完整的K线，更新收盘价
-		n.orderController.OnCandle(candle)
 	}
 }
 
+// updateOrderBook 回测时把订单簿推进到K线收盘的时间，然后把订单簿副本交给交易对的策略控制器
+func (n *NinjaBot) updateOrderBook(candle model.Candle) {
+	if n.backtest {
+		n.depthFeed.Advance(candle.Pair, n.candleCloseTime(candle))
+	}
+
+	if book, ok := n.depthFeed.OrderBook(candle.Pair); ok {
+		n.strategiesControllers[candle.Pair].OnOrderBook(&book)
+	} else {
+		n.strategiesControllers[candle.Pair].OnOrderBook(nil)
+	}
+}
+
+// candleCloseTime 返回K线收盘的时间。成交生成的K线（如tick:500）的UpdatedAt是最后一笔成交的时间，
+// 只有一笔成交时与开盘时间相同；时间K线的UpdatedAt通常等于开盘时间，收盘时间是下一个周期的开始。
+func (n *NinjaBot) candleCloseTime(candle model.Candle) time.Time {
+	timeframe := n.strategy.Timeframe()
+	if exchange.IsBarSpec(timeframe) || candle.UpdatedAt.After(candle.Time) {
+		// 同一时间完成的多根K线开盘时间依次加1纳秒，可能晚于UpdatedAt
+		if candle.UpdatedAt.After(candle.Time) {
+			return candle.UpdatedAt
+		}
+		return candle.Time
+	}
+
+	period, err := exchange.ParseTimeframe(timeframe)
+	if err != nil {
+		return candle.Time
+	}
+	return period.Next(candle.Time)
+}
+
 // 处理缓存中待处理的K线数据
 func (n *NinjaBot) processCandles() {
 	// 遍历从优先队列中弹出的每一个项
@@ -437,41 +549,55 @@ func (n *NinjaBot) processCandles() {
 	}
 }
 
-// 开始回测过程并创建进度条
-func (n *NinjaBot) backtestCandles() {
-	// 记录开始回测的日志信息
-	log.Info("[SETUP] Starting backtesting")
-
-	// 创建一个进度条，长度设置为优先队列中的元素数量
-	progressBar := progressbar.Default(int64(n.priorityQueueCandle.Len()))
+// backtestCandles 返回回测需要处理的K线总数，数据源无法提前知道总数或者K线由重采样得到时返回-1
+func (n *NinjaBot) backtestCandles() int64 {
+	counter, ok := n.exchange.(candleCounter)
+	if !ok || n.resampleTimeframe != "" {
+		return -1
+	}
 
-	// 当优先队列中还有元素时，持续处理
-	for n.priorityQueueCandle.Len() > 0 {
-		// 从优先队列中弹出一个元素
-		item := n.priorityQueueCandle.Pop()
+	var total int64
+	for _, pair := range n.settings.Pairs {
+		count, ok := counter.CandleCount(pair, n.strategy.Timeframe())
+		if !ok {
+			return -1
+		}
+		total += int64(count)
+	}
+	return total
+}
 
-		// 将弹出的元素类型断言为model.Candle
-		candle := item.(model.Candle)
+// backtestCandle 在回测时处理数据源按时间顺序推送的K线并更新进度条。
+// 数据源在回测时同步推送K线，不需要先把全部K线放入优先队列，流式数据源的内存占用不会随着数据量增长。
+func (n *NinjaBot) backtestCandle(candle model.Candle) {
+	n.processCandle(candle)
 
-		// 如果虚拟钱包存在，则使用当前的K线数据更新虚拟钱包的状态
-		if n.paperWallet != nil {
-			n.paperWallet.OnCandle(candle)
-		}
+	// 更新进度条，每处理完一个K线数据，进度条增加1
+	if err := n.backtestProgress.Add(1); err != nil {
+		// 如果更新进度条失败，记录警告日志
+		log.Warnf("update progressbar fail: %v", err)
+	}
+}
 
-		// 更新对应交易对的策略控制器，处理部分完成的K线
-		n.strategiesControllers[candle.Pair].OnPartialCandle(candle)
+// exportResults 把回测的交易明细和权益曲线写入resultsDir
+func (n *NinjaBot) exportResults() error {
+	if err := os.MkdirAll(n.resultsDir, 0o755); err != nil {
+		return err
+	}
 
-		// 如果K线数据已经完整，处理完整的K线
-		if candle.Complete {
-			n.strategiesControllers[candle.Pair].OnCandle(candle)
-		}
+	if err := parquet.WriteTrades(filepath.Join(n.resultsDir, "trades.parquet"), n.orderController.Trades()); err != nil {
+		return fmt.Errorf("export trades: %w", err)
+	}
 
-		// 更新进度条，每处理完一个K线数据，进度条增加1
-		if err := progressBar.Add(1); err != nil {
-			// 如果更新进度条失败，记录警告日志
-			log.Warnf("update progressbar fail: %v", err)
+	if n.paperWallet != nil {
+		err := parquet.WriteEquity(filepath.Join(n.resultsDir, "equity.parquet"), n.paperWallet.EquityValues())
+		if err != nil {
+			return fmt.Errorf("export equity: %w", err)
 		}
 	}
+
+	log.Infof("[SETUP] Results exported to %s", n.resultsDir)
+	return nil
 }
 
 // 在NinjaBot启动之前，我们需要加载必要的数据来填充策略指标
@@ -483,7 +609,7 @@ func (n *NinjaBot) preload(ctx context.Context, pair string) error {
 	}
 
 	// 从交易所获取限定数量的K线数据，数量由策略的时间框架和预热期决定
-	candles, err := n.exchange.CandlesByLimit(ctx, pair, n.strategy.Timeframe(), n.strategy.WarmupPeriod())
+	candles, err := n.warmupCandles(ctx, pair)
 	if err != nil {
 		// 如果获取数据时出现错误，返回错误
 		return err
@@ -501,6 +627,41 @@ func (n *NinjaBot) preload(ctx context.Context, pair string) error {
 	return nil
 }
 
+// warmupCandles 获取策略预热期的K线，配置了重采样时获取足够数量的源K线并重采样，只返回完整的K线
+func (n *NinjaBot) warmupCandles(ctx context.Context, pair string) ([]model.Candle, error) {
+	if n.resampleTimeframe == "" {
+		return n.exchange.CandlesByLimit(ctx, pair, n.strategy.Timeframe(), n.strategy.WarmupPeriod())
+	}
+
+	source, err := exchange.ParseTimeframe(n.resampleTimeframe)
+	if err != nil {
+		return nil, err
+	}
+	target, err := exchange.ParseTimeframe(n.strategy.Timeframe())
+	if err != nil {
+		return nil, err
+	}
+	resampler, err := exchange.NewResampler(n.resampleTimeframe, n.strategy.Timeframe(), n.resampleAlignment)
+	if err != nil {
+		return nil, err
+	}
+
+	// 多获取一个周期的源K线，第一个完整周期之前的K线会被跳过
+	ratio := int(math.Ceil(float64(target.Duration()) / float64(source.Duration())))
+	sourceCandles, err := n.exchange.CandlesByLimit(ctx, pair, n.resampleTimeframe, (n.strategy.WarmupPeriod()+1)*ratio)
+	if err != nil {
+		return nil, err
+	}
+
+	candles := make([]model.Candle, 0, n.strategy.WarmupPeriod())
+	for _, candle := range sourceCandles {
+		if resampled, ok := resampler.Update(candle); ok && resampled.Complete {
+			candles = append(candles, resampled)
+		}
+	}
+	return candles, nil
+}
+
 // Run 会初始化策略控制器、订单控制器、预加载数据并启动机器人
 func (n *NinjaBot) Run(ctx context.Context) error {
 	// 遍历设定中的所有交易对
@@ -515,7 +676,18 @@ func (n *NinjaBot) Run(ctx context.Context) error {
 		}
 
 		// 作用是订阅指定的交易对和时间框架到一个数据源，使得每当有新的K线数据到S来时，就会立即调用 n.onCandle 函数来处理这些数据，而不需等待K线完全关闭。这允许策略能够快速响应市场变化，从而及时执行交易决策。
-		n.dataFeed.Subscribe(pair, n.strategy.Timeframe(), n.onCandle, false)
+		// 回测时数据源已经按时间顺序推送K线，直接处理
+		consumer := n.onCandle
+		if n.backtest {
+			consumer = n.backtestCandle
+		}
+		if n.resampleTimeframe != "" {
+			if err := n.dataFeed.Resample(pair, n.resampleTimeframe, n.strategy.Timeframe(),
+				n.resampleAlignment); err != nil {
+				return err
+			}
+		}
+		n.dataFeed.Subscribe(pair, n.strategy.Timeframe(), consumer, false)
 
 		// 启动策略控制器就是为每个交易对激活对应的交易策略，使其能够开始监测市场并执行交易操作
 		n.strategiesControllers[pair].Start()
@@ -532,12 +704,33 @@ func (n *NinjaBot) Run(ctx context.Context) error {
 		n.telegram.Start()
 	}
 
-	// 启动数据流，就是可以源源不断的从交易所中拿到新k数据，给机器人处理
+	// 如果当前处于回测环境，数据流会按照历史数据的时间顺序逐步回放，并根据策略逻辑进行交易决策，从而模拟真实市场环境下的交易情况。
+	// 数据源的K线都在内存中时进度条显示K线总数，流式数据源无法提前知道总数，进度条只显示已经处理的数量
+	if n.backtest {
+		log.Info("[SETUP] Starting backtesting")
+		n.backtestProgress = progressbar.Default(n.backtestCandles())
+	}
+
+	// 订阅订单簿，实时运行时由DepthFeed在后台更新，回测时在处理K线时推进
+	if n.depthFeed != nil {
+		n.depthFeed.Connect(n.settings.Pairs...)
+		if !n.backtest {
+			n.depthFeed.Start()
+		}
+	}
+
+	// 启动数据流，就是可以源源不断的从交易所中拿到新k数据，给机器人处理，回测时处理完所有数据后才返回
 	n.dataFeed.Start(n.backtest)
 
-	// 如果当前处于回测环境，则调用 n.backtestCandles() 方法来执行回测过程。在回测过程中，机器人会按照历史数据的时间顺序逐步回放，并根据策略逻辑进行交易决策，从而模拟真实市场环境下的交易情况。
 	if n.backtest {
-		n.backtestCandles()
+		if err := n.backtestProgress.Close(); err != nil {
+			log.Warnf("close progressbar fail: %v", err)
+		}
+		if n.resultsDir != "" {
+			if err := n.exportResults(); err != nil {
+				return err
+			}
+		}
 	} else {
 		//n.backtest 为 false，表示当前处于生产环境，则调用 n.processCandles() 方法来处理实时K线数据。在生产环境中，机器人会不断地接收实时市场数据，并根据最新的K线数据进行实时的交易决策和操作。
 		n.processCandles()
@@ -2,7 +2,10 @@ package ninjabot
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/rodrigo-brito/ninjabot/strategy"
 
@@ -11,6 +14,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
 	"github.com/rodrigo-brito/ninjabot/service"
 	"github.com/rodrigo-brito/ninjabot/storage"
 )
@@ -59,6 +63,7 @@ func TestMarketOrder(t *testing.T) {
 	storage, err := storage.FromMemory()
 	require.NoError(t, err)
 
+	resultsDir := filepath.Join(t.TempDir(), "results")
 	strategy := new(fakeStrategy)
 	csvFeed, err := exchange.NewCSVFeed(
 		strategy.Timeframe(),
@@ -93,10 +98,18 @@ func TestMarketOrder(t *testing.T) {
 		WithStorage(storage),
 		WithBacktest(paperWallet),
 		WithLogLevel(log.ErrorLevel),
+		WithResultsExport(resultsDir),
 	)
 	require.NoError(t, err)
 	require.NoError(t, bot.Run(ctx))
 
+	// 回测结束后导出交易明细和权益曲线
+	for _, name := range []string{"trades.parquet", "equity.parquet"} {
+		info, err := os.Stat(filepath.Join(resultsDir, name))
+		require.NoError(t, err)
+		require.Positive(t, info.Size())
+	}
+
 	assets, quote, err := bot.paperWallet.Position("BTCUSDT")
 	require.NoError(t, err)
 	require.Equal(t, assets, 0.0)
@@ -114,3 +127,81 @@ func TestMarketOrder(t *testing.T) {
 
 	bot.Summary()
 }
+
+func TestBacktest_StreamFeed(t *testing.T) {
+	ctx := context.Background()
+	feeds := []exchange.PairFeed{
+		{Pair: "BTCUSDT", File: "testdata/btc-1h.csv", Timeframe: "1h"},
+		{Pair: "ETHUSDT", File: "testdata/eth-1h.csv", Timeframe: "1h"},
+	}
+
+	backtest := func(feeder service.Feeder) *NinjaBot {
+		storage, err := storage.FromMemory()
+		require.NoError(t, err)
+
+		paperWallet := exchange.NewPaperWallet(ctx, "USDT",
+			exchange.WithPaperAsset("USDT", 10000),
+			exchange.WithDataFeed(feeder),
+		)
+		bot, err := NewBot(ctx, Settings{Pairs: []string{"BTCUSDT", "ETHUSDT"}},
+			paperWallet,
+			new(fakeStrategy),
+			WithStorage(storage),
+			WithBacktest(paperWallet),
+			WithLogLevel(log.ErrorLevel),
+		)
+		require.NoError(t, err)
+		require.NoError(t, bot.Run(ctx))
+		return bot
+	}
+
+	csvFeed, err := exchange.NewCSVFeed("1d", feeds...)
+	require.NoError(t, err)
+	streamFeed, err := exchange.NewCSVStreamFeed("1d", feeds...)
+	require.NoError(t, err)
+
+	// 流式数据源的回测结果与一次读入内存的CSVFeed相同
+	expected := backtest(csvFeed)
+	actual := backtest(streamFeed)
+	for _, pair := range []string{"BTCUSDT", "ETHUSDT"} {
+		require.Equal(t, expected.orderController.Results[pair].Win(), actual.orderController.Results[pair].Win())
+		require.Equal(t, expected.orderController.Results[pair].Lose(), actual.orderController.Results[pair].Lose())
+	}
+	require.InDelta(t, 5340.224, actual.orderController.Results["BTCUSDT"].Profit(), 0.001)
+
+	// 内存中的CSVFeed可以提前知道K线总数，进度条在回测结束时走完；流式数据源不知道总数
+	require.Equal(t, 8616, expected.backtestProgress.GetMax())
+	require.Equal(t, 1.0, expected.backtestProgress.State().CurrentPercent)
+	require.Equal(t, int64(-1), actual.backtestCandles())
+
+	// 重采样的源周期不能与策略的时间周期相同
+	_, err = NewBot(ctx, Settings{Pairs: []string{"BTCUSDT"}}, exchange.NewPaperWallet(ctx, "USDT",
+		exchange.WithPaperAsset("USDT", 0)),
+		new(fakeStrategy), WithResample("1d", exchange.Alignment{}))
+	require.ErrorIs(t, err, exchange.ErrSameTimeframe)
+}
+
+// barStrategy 使用成交笔数K线的策略
+type barStrategy struct {
+	fakeStrategy
+}
+
+func (e barStrategy) Timeframe() string {
+	return "tick:500"
+}
+
+func TestNinjaBot_candleCloseTime(t *testing.T) {
+	open := time.Date(2021, 1, 31, 0, 0, 0, 0, time.UTC)
+
+	daily := &NinjaBot{strategy: new(fakeStrategy)}
+	require.Equal(t, open.AddDate(0, 0, 1), daily.candleCloseTime(model.Candle{Time: open, UpdatedAt: open}))
+	require.Equal(t, open.Add(time.Hour),
+		daily.candleCloseTime(model.Candle{Time: open, UpdatedAt: open.Add(time.Hour)}))
+
+	// 成交K线没有固定的周期，只有一笔成交时收盘时间就是开盘时间
+	bars := &NinjaBot{strategy: new(barStrategy)}
+	require.Equal(t, open, bars.candleCloseTime(model.Candle{Time: open, UpdatedAt: open}))
+	require.Equal(t, open.Add(time.Minute),
+		bars.candleCloseTime(model.Candle{Time: open, UpdatedAt: open.Add(time.Minute)}))
+	require.Equal(t, open.Add(1), bars.candleCloseTime(model.Candle{Time: open.Add(1), UpdatedAt: open}))
+}
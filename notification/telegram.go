@@ -245,6 +245,22 @@ func (t telegram) BalanceHandle(m *tb.Message) {
 		// 将基础资产交易对，资产数量，基础资产价值和报价的信息添加到消息字符串中，这表示将新的内容追加到已存在的message字符串变量中。
 		//就是添加到文本的末尾message := "I have a " message += "dream." fmt.Println(message) // 输出：I have a dream.
 		message += fmt.Sprintf("%s: `%.4f` ≅ `%.2f` %s \n", assetPair, assetSize, assetValue, quotePair)
+
+		// 有开仓均价的持仓（合约或模拟钱包）再显示方向、杠杆、未实现盈亏和强平价
+		position, err := t.orderController.PositionInfo(pair)
+		if err != nil {
+			log.Error(err)
+			t.OnError(err)
+			return
+		}
+		if position.Open() && position.EntryPrice > 0 {
+			message += fmt.Sprintf("  %s `%.4f` @ `%.2f` x%.0f | PnL: `%.2f`",
+				position.Side, position.Quantity, position.EntryPrice, position.Leverage, position.UnrealizedPnL)
+			if position.LiquidationPrice > 0 {
+				message += fmt.Sprintf(" | Liq: `%.2f`", position.LiquidationPrice)
+			}
+			message += "\n"
+		}
 	}
 
 	// 遍历报价的价值 如usdt，并将报价的价值添加到消息字符串中
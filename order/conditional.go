@@ -0,0 +1,172 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+var (
+	// ErrConditionalNotFound 表示条件单不存在，或者已经触发/取消。
+	ErrConditionalNotFound = errors.New("conditional order not found")
+	// ErrInvalidConditional 表示条件单参数不合法。
+	ErrInvalidConditional = errors.New("invalid conditional order")
+)
+
+/*
+条件单引擎在本地模拟止损、止盈和突破单：Controller 在收到K线（包括未完成的K线）时检查价格是否穿越触发价，
+满足条件后再通过普通的市价单或限价单接口提交到交易所。这样即使交易所（或PaperWallet）不支持
+买入止损、止盈等订单类型，策略也可以使用相同的逻辑。
+*/
+
+// CreateOrderConditional 创建一个条件单。condition为ConditionTypeAbove时价格达到或高于trigger触发，
+// ConditionTypeBelow时价格达到或低于trigger触发；limit为0时触发后提交市价单，否则提交限价单。
+func (c *Controller) CreateOrderConditional(side model.SideType, pair string, size float64,
+	condition model.ConditionType, trigger, limit float64) (model.ConditionalOrder, error) {
+
+	if size <= 0 || trigger <= 0 || limit < 0 {
+		return model.ConditionalOrder{}, fmt.Errorf("%w: size=%f trigger=%f limit=%f",
+			ErrInvalidConditional, size, trigger, limit)
+	}
+
+	if condition != model.ConditionTypeAbove && condition != model.ConditionTypeBelow {
+		return model.ConditionalOrder{}, fmt.Errorf("%w: unknown condition %s", ErrInvalidConditional, condition)
+	}
+
+	c.conditionalMtx.Lock()
+	defer c.conditionalMtx.Unlock()
+
+	c.conditionalSeq++
+	conditional := &model.ConditionalOrder{
+		ID:        c.conditionalSeq,
+		Pair:      pair,
+		Side:      side,
+		Condition: condition,
+		Trigger:   trigger,
+		Limit:     limit,
+		Quantity:  size,
+		Status:    model.ConditionalStatusPending,
+		CreatedAt: time.Now(),
+		// 策略在处理K线时创建的条件单不能再用同一根K线的最高价和最低价检查，否则就是用到了未来数据
+		CandleTime: c.candleTime[pair],
+	}
+	c.conditionals[conditional.ID] = conditional
+
+	log.Infof("[CONDITIONAL CREATED] %s", conditional)
+	return *conditional, nil
+}
+
+// CancelConditional 取消一个尚未触发的条件单。
+func (c *Controller) CancelConditional(id int64) error {
+	c.conditionalMtx.Lock()
+	defer c.conditionalMtx.Unlock()
+
+	conditional, ok := c.conditionals[id]
+	if !ok {
+		return fmt.Errorf("%w: id=%d", ErrConditionalNotFound, id)
+	}
+
+	conditional.Status = model.ConditionalStatusCanceled
+	delete(c.conditionals, id)
+
+	log.Infof("[CONDITIONAL CANCELED] %s", conditional)
+	return nil
+}
+
+// ConditionalOrders 返回指定交易对所有等待触发的条件单，按ID排序。pair为空时返回全部交易对。
+func (c *Controller) ConditionalOrders(pair string) []model.ConditionalOrder {
+	c.conditionalMtx.Lock()
+	defer c.conditionalMtx.Unlock()
+
+	orders := make([]model.ConditionalOrder, 0, len(c.conditionals))
+	for _, conditional := range c.conditionals {
+		if pair == "" || conditional.Pair == pair {
+			orders = append(orders, *conditional)
+		}
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].ID < orders[j].ID
+	})
+	return orders
+}
+
+// candleRange 返回K线覆盖的价格区间。部分数据源只提供收盘价，此时用收盘价补齐最高价和最低价。
+func candleRange(candle model.Candle) (low, high float64) {
+	low, high = candle.Low, math.Max(candle.High, candle.Close)
+	if low == 0 || low > candle.Close {
+		low = candle.Close
+	}
+	return low, high
+}
+
+// checkConditionals 检查指定交易对的条件单，K线的价格区间满足条件时提交订单。
+// 创建之后开盘的K线用整根K线的最高价和最低价检查；创建条件单的那根K线（实盘中同一根K线会多次推送）
+// 的最高价和最低价可能发生在创建之前，只用收盘价（即这次推送时的最新价）检查。回测时条件单在完整K线
+// 处理之后创建，下一次检查已经是新的K线，所以不会用到同一根K线的数据。
+// 提交订单需要获取Controller的mtx锁，所以先在conditionalMtx保护下摘出触发的条件单，再逐个提交。
+func (c *Controller) checkConditionals(candle model.Candle) {
+	low, high := candleRange(candle)
+
+	c.conditionalMtx.Lock()
+	c.candleTime[candle.Pair] = candle.Time
+
+	var triggered []*model.ConditionalOrder
+	for id, conditional := range c.conditionals {
+		if conditional.Pair != candle.Pair {
+			continue
+		}
+		if candle.Time.After(conditional.CandleTime) {
+			if !conditional.IsTriggered(low, high) {
+				continue
+			}
+		} else if !conditional.IsTriggered(candle.Close, candle.Close) {
+			continue
+		}
+		triggered = append(triggered, conditional)
+		delete(c.conditionals, id)
+	}
+	c.conditionalMtx.Unlock()
+
+	// 按创建顺序提交，保证结果可重复
+	sort.Slice(triggered, func(i, j int) bool {
+		return triggered[i].ID < triggered[j].ID
+	})
+
+	for _, conditional := range triggered {
+		c.submitConditional(conditional)
+	}
+}
+
+// submitConditional 将触发的条件单以市价单或限价单提交到交易所。
+func (c *Controller) submitConditional(conditional *model.ConditionalOrder) {
+	conditional.TriggeredAt = time.Now()
+	log.Infof("[CONDITIONAL TRIGGERED] %s", conditional)
+
+	var (
+		order model.Order
+		err   error
+	)
+	if conditional.Limit > 0 {
+		order, err = c.CreateOrderLimit(conditional.Side, conditional.Pair, conditional.Quantity, conditional.Limit)
+	} else {
+		order, err = c.CreateOrderMarket(conditional.Side, conditional.Pair, conditional.Quantity)
+	}
+
+	if err != nil {
+		// 下单方法内部已经通知过错误，这里只记录条件单的状态
+		conditional.Status = model.ConditionalStatusFailed
+		conditional.Err = err
+		log.Errorf("[CONDITIONAL FAILED] %s: %v", conditional, err)
+		return
+	}
+
+	conditional.Status = model.ConditionalStatusTriggered
+	conditional.Order = &order
+}
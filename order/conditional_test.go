@@ -0,0 +1,170 @@
+package order
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/storage"
+)
+
+func TestController_Conditional(t *testing.T) {
+	newController := func(t *testing.T) (*Controller, *exchange.PaperWallet) {
+		storage, err := storage.FromMemory()
+		require.NoError(t, err)
+		ctx := context.Background()
+		wallet := exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 3000))
+		return NewController(ctx, wallet, storage, NewOrderFeed()), wallet
+	}
+
+	t.Run("breakout buy with market order", func(t *testing.T) {
+		controller, wallet := newController(t)
+
+		candle := model.Candle{Pair: "BTCUSDT", Close: 1000, Low: 950, High: 1050}
+		wallet.OnCandle(candle)
+		controller.OnCandle(candle)
+
+		conditional, err := controller.CreateOrderConditional(model.SideTypeBuy, "BTCUSDT", 1,
+			model.ConditionTypeAbove, 1100, 0)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderTypeStopLoss, conditional.OrderType())
+
+		// price did not reach the trigger
+		candle = model.Candle{Pair: "BTCUSDT", Close: 1080, Low: 1000, High: 1090}
+		wallet.OnCandle(candle)
+		controller.OnPartialCandle(candle)
+		require.Len(t, controller.ConditionalOrders("BTCUSDT"), 1)
+		assert.Nil(t, controller.position["BTCUSDT"])
+
+		// breakout
+		candle = model.Candle{Pair: "BTCUSDT", Close: 1200, Low: 1080, High: 1210, Complete: true}
+		wallet.OnCandle(candle)
+		controller.OnCandle(candle)
+		require.Empty(t, controller.ConditionalOrders(""))
+		require.NotNil(t, controller.position["BTCUSDT"])
+		assert.Equal(t, 1200.0, controller.position["BTCUSDT"].AvgPrice)
+		assert.Equal(t, 1.0, controller.position["BTCUSDT"].Quantity)
+	})
+
+	t.Run("stop limit sell from partial candles", func(t *testing.T) {
+		controller, wallet := newController(t)
+
+		candle := model.Candle{Pair: "BTCUSDT", Close: 1000, Low: 1000, High: 1000}
+		wallet.OnCandle(candle)
+		_, err := controller.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 1)
+		require.NoError(t, err)
+
+		conditional, err := controller.CreateOrderConditional(model.SideTypeSell, "BTCUSDT", 1,
+			model.ConditionTypeBelow, 900, 890)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderTypeStopLossLimit, conditional.OrderType())
+
+		candle = model.Candle{Pair: "BTCUSDT", Time: time.Now(), Close: 950, Low: 950, High: 1000}
+		controller.OnPartialCandle(candle)
+		require.Len(t, controller.ConditionalOrders("BTCUSDT"), 1)
+
+		candle.Close, candle.Low = 895, 895
+		controller.OnPartialCandle(candle)
+		require.Empty(t, controller.ConditionalOrders("BTCUSDT"))
+
+		orders, err := controller.storage.Orders(storage.WithPair("BTCUSDT"))
+		require.NoError(t, err)
+		require.Len(t, orders, 2)
+		assert.Equal(t, model.OrderTypeLimit, orders[1].Type)
+		assert.Equal(t, model.SideTypeSell, orders[1].Side)
+		assert.Equal(t, 890.0, orders[1].Price)
+	})
+
+	t.Run("no trigger on the candle that created it", func(t *testing.T) {
+		controller, wallet := newController(t)
+		start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		candle := model.Candle{Pair: "BTCUSDT", Time: start, Close: 1000, Low: 900, High: 1100, Complete: true}
+		wallet.OnCandle(candle)
+		controller.OnCandle(candle)
+		_, err := controller.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 1)
+		require.NoError(t, err)
+
+		// stop inside the range of the current candle
+		_, err = controller.CreateOrderConditional(model.SideTypeSell, "BTCUSDT", 1,
+			model.ConditionTypeBelow, 950, 0)
+		require.NoError(t, err)
+
+		// the range of the same candle happened before the conditional, only the latest price counts
+		candle.Close, candle.Complete = 960, false
+		wallet.OnCandle(candle)
+		controller.OnPartialCandle(candle)
+		candle.Complete = true
+		controller.OnCandle(candle)
+		require.Len(t, controller.ConditionalOrders("BTCUSDT"), 1)
+
+		// next candle reaches the stop
+		candle = model.Candle{Pair: "BTCUSDT", Time: start.Add(time.Hour), Close: 940, Low: 930, High: 970,
+			Complete: true}
+		wallet.OnCandle(candle)
+		controller.OnCandle(candle)
+		require.Empty(t, controller.ConditionalOrders("BTCUSDT"))
+		orders, err := controller.storage.Orders(storage.WithPair("BTCUSDT"))
+		require.NoError(t, err)
+		require.Len(t, orders, 2)
+		assert.Equal(t, model.SideTypeSell, orders[1].Side)
+	})
+
+	t.Run("partial update crosses the trigger after creation", func(t *testing.T) {
+		controller, wallet := newController(t)
+		start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		candle := model.Candle{Pair: "BTCUSDT", Time: start, Close: 1000, Low: 900, High: 1100}
+		wallet.OnCandle(candle)
+		controller.OnPartialCandle(candle)
+		_, err := controller.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 1)
+		require.NoError(t, err)
+
+		// created mid-candle, the low of 900 was reached before it
+		_, err = controller.CreateOrderConditional(model.SideTypeSell, "BTCUSDT", 1,
+			model.ConditionTypeBelow, 950, 0)
+		require.NoError(t, err)
+
+		candle.Close = 980
+		wallet.OnCandle(candle)
+		controller.OnPartialCandle(candle)
+		require.Len(t, controller.ConditionalOrders("BTCUSDT"), 1)
+
+		// a later update of the same candle crosses the stop
+		candle.Close = 940
+		wallet.OnCandle(candle)
+		controller.OnPartialCandle(candle)
+		require.Empty(t, controller.ConditionalOrders("BTCUSDT"))
+		orders, err := controller.storage.Orders(storage.WithPair("BTCUSDT"))
+		require.NoError(t, err)
+		require.Len(t, orders, 2)
+		assert.Equal(t, model.SideTypeSell, orders[1].Side)
+	})
+
+	t.Run("cancel and validation", func(t *testing.T) {
+		controller, _ := newController(t)
+
+		_, err := controller.CreateOrderConditional(model.SideTypeBuy, "BTCUSDT", 0, model.ConditionTypeAbove, 10, 0)
+		require.ErrorIs(t, err, ErrInvalidConditional)
+
+		_, err = controller.CreateOrderConditional(model.SideTypeBuy, "BTCUSDT", 1, "INVALID", 10, 0)
+		require.ErrorIs(t, err, ErrInvalidConditional)
+
+		conditional, err := controller.CreateOrderConditional(model.SideTypeBuy, "BTCUSDT", 1,
+			model.ConditionTypeAbove, 10, 0)
+		require.NoError(t, err)
+
+		require.NoError(t, controller.CancelConditional(conditional.ID))
+		require.ErrorIs(t, controller.CancelConditional(conditional.ID), ErrConditionalNotFound)
+
+		controller.OnPartialCandle(model.Candle{Pair: "BTCUSDT", Time: time.Now(), Close: 20})
+		orders, err := controller.storage.Orders()
+		require.NoError(t, err)
+		assert.Empty(t, orders)
+	})
+}
@@ -2,12 +2,15 @@ package order
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rodrigo-brito/ninjabot/exchange"
@@ -31,6 +34,7 @@ type summary struct {
 	LoseShort        []float64 // 亏损的空仓交易金额列表,如果一个交易者卖出价值100美元的股票，但随后股票价格上涨到110美元时被迫回购，造成10美元的亏损，那么这次交易的亏损金额就会被记录在LoseShort列表中。
 	LoseShortPercent []float64 // 亏损的空仓交易百分比列表,例如，如果一个交易者以100美元的价格卖出了某资产，但在之后以110美元的价格回购该资产，造成了10%的亏损，那么这次交易的亏损百分比就会被记录在LoseShortPercent列表中。
 	Volume           float64   // 在这个交易对上交易的总量,这个字段记录了在给定的交易对上所有交易的总量，无论是买入还是卖出。
+	Trades           []Result  // 按平仓时间顺序记录的每笔已实现交易结果，用于导出交易明细
 }
 
 // Win方法返回所有获利交易的金额列表，包括多仓和空仓。
@@ -66,6 +70,45 @@ func (s summary) Profit() float64 {
 	return profit
 }
 
+// LongProfit 方法计算所有多仓交易的总利润。
+func (s summary) LongProfit() float64 {
+	profit := 0.0
+	for _, value := range append(s.WinLong, s.LoseLong...) {
+		profit += value
+	}
+	return profit
+}
+
+// ShortProfit 方法计算所有空仓交易的总利润。
+func (s summary) ShortProfit() float64 {
+	profit := 0.0
+	for _, value := range append(s.WinShort, s.LoseShort...) {
+		profit += value
+	}
+	return profit
+}
+
+// add 将一笔已实现的交易结果按盈亏和多空方向记录到对应的统计列表中。
+func (s *summary) add(result *Result) {
+	s.Trades = append(s.Trades, *result)
+
+	long := result.Side == model.SideTypeBuy
+	switch {
+	case result.ProfitPercent >= 0 && long:
+		s.WinLong = append(s.WinLong, result.ProfitValue)
+		s.WinLongPercent = append(s.WinLongPercent, result.ProfitPercent)
+	case result.ProfitPercent >= 0:
+		s.WinShort = append(s.WinShort, result.ProfitValue)
+		s.WinShortPercent = append(s.WinShortPercent, result.ProfitPercent)
+	case long:
+		s.LoseLong = append(s.LoseLong, result.ProfitValue)
+		s.LoseLongPercent = append(s.LoseLongPercent, result.ProfitPercent)
+	default:
+		s.LoseShort = append(s.LoseShort, result.ProfitValue)
+		s.LoseShortPercent = append(s.LoseShortPercent, result.ProfitPercent)
+	}
+}
+
 // SQN 方法计算系统的 SQN（System Quality Number）值。
 // 这个SQN()方法通过计算交易数量、平均利润和利润的标准差，来评估交易系统的质量。SQN值越高，表示交易系统的性能越好，因为它意味着系统能够在较小的波动性下实现较高的平均利润。
 func (s summary) SQN() float64 {
@@ -192,7 +235,10 @@ func (s summary) String() string {
 		{"Payoff", fmt.Sprintf("%.1f", s.Payoff()*100)},        // 支付比率（可能是代码中遗漏的方法）
 		{"Pr.Fact", fmt.Sprintf("%.1f", s.Payoff()*100)},       // 盈亏比（此处可能有误，应该是盈亏因子）
 		{"Profit", fmt.Sprintf("%.4f %s", s.Profit(), quote)},  // 总盈利
-		{"Volume", fmt.Sprintf("%.4f %s", s.Volume, quote)},    // 交易量
+		// 多仓和空仓分别统计的交易次数和盈利，双向持仓模式下可以分别评估两个方向的表现
+		{"Long", fmt.Sprintf("%d | %.4f %s", len(s.WinLong)+len(s.LoseLong), s.LongProfit(), quote)},
+		{"Short", fmt.Sprintf("%d | %.4f %s", len(s.WinShort)+len(s.LoseShort), s.ShortProfit(), quote)},
+		{"Volume", fmt.Sprintf("%.4f %s", s.Volume, quote)}, // 交易量
 	}
 	//data是一个二维切片（[][]string），其中每个元素（一个[]string切片）代表表格的一行数据。
 	//使用AppendBulk(data)方法，就可以一次性将多行数据添加到表格中，而不需要逐行添加。这对于处理大量数据时可以提高效率。
@@ -255,20 +301,25 @@ const (
 
 // Result结构体用于存储交易的结果数据。
 type Result struct {
-	Pair          string         // 交易对。
-	ProfitPercent float64        // 盈利百分比。
-	ProfitValue   float64        // 盈利金额。
-	Side          model.SideType // 交易方向（买入/卖出）。
-	Duration      time.Duration  // 交易持续时间。
-	CreatedAt     time.Time      // 结果创建时间。
+	Pair          string                 // 交易对。
+	ProfitPercent float64                // 盈利百分比。
+	ProfitValue   float64                // 盈利金额。
+	Side          model.SideType         // 交易方向（买入/卖出）。
+	PositionSide  model.PositionSideType // 双向持仓模式下的持仓方向，单向持仓为空。
+	Duration      time.Duration          // 交易持续时间。
+	CreatedAt     time.Time              // 结果创建时间。
 }
 
+// ErrPositionExceeded 表示双向持仓模式下平仓数量超过了头寸数量，超出的部分没有计入任何头寸。
+var ErrPositionExceeded = errors.New("order quantity exceeds position")
+
 // Position结构体用于描述一个交易头寸的详细信息。交易头寸的意思就是，交易的方向，数量，价格，时间
 type Position struct {
-	Side      model.SideType // 头寸方向（买入/卖出）。
-	AvgPrice  float64        // 平均价格。
-	Quantity  float64        // 数量。
-	CreatedAt time.Time      // 头寸创建时间。
+	Side         model.SideType         // 头寸方向（买入/卖出）。
+	PositionSide model.PositionSideType // 双向持仓模式下的持仓方向（LONG/SHORT），单向持仓为空。
+	AvgPrice     float64                // 平均价格。
+	Quantity     float64                // 数量。
+	CreatedAt    time.Time              // 头寸创建时间。
 }
 
 // Update方法接收一个指向Order的指针作为参数，返回一个指向Result的指针和一个布尔值finished，表示头寸是否已结束。
@@ -276,7 +327,8 @@ type Position struct {
 // 现在买入2个BTC ，2个BTC就是我的头寸，当我出售一个后，还剩下一个，我的头寸还剩下1个BTC ，头寸里面有买卖方向：我是通过做多还是做空，买入这个BTC的这个例子是通过做多来的，数量：几个BTC，创建这个头寸的时间，就是买入这2个btc的时间
 //
 // 订单的意思就是我通过买入或者卖出这个资产，通过指令，买入的话呢就会创造一个新的头寸资产，卖出的话呢就会减少，
-func (p *Position) Update(order *model.Order) (result *Result, finished bool) {
+// 双向持仓模式下平仓数量超过头寸时，整个头寸被平掉，同时返回ErrPositionExceeded说明超出的数量被忽略。
+func (p *Position) Update(order *model.Order) (result *Result, finished bool, err error) {
 	// 通常情况下，交易的价格是订单的价格。
 	price := order.Price
 
@@ -295,9 +347,14 @@ func (p *Position) Update(order *model.Order) (result *Result, finished bool) {
 		p.Quantity += order.Quantity
 	} else {
 		// 如果订单的方向与头寸方向相反，处理平仓和反向开仓的情况。
-		if p.Quantity == order.Quantity {
+		if p.Quantity == order.Quantity || (p.PositionSide != "" && order.Quantity > p.Quantity) {
 			// 如果数量相等，表示完全平仓，头寸结束。
+			// 双向持仓模式下多仓和空仓互相独立，平仓数量超过头寸时只会平掉整个头寸，不会反向开仓。
 			finished = true
+			if order.Quantity > p.Quantity {
+				err = fmt.Errorf("%w: %s %s closing %f of %f, surplus %f ignored", ErrPositionExceeded,
+					order.Pair, p.PositionSide, order.Quantity, p.Quantity, order.Quantity-p.Quantity)
+			}
 		} else if p.Quantity > order.Quantity {
 			// 如果头寸的数量大于订单的数量，减少头寸的数量。
 			p.Quantity -= order.Quantity
@@ -318,10 +375,14 @@ func (p *Position) Update(order *model.Order) (result *Result, finished bool) {
 		// 计算盈亏比例。当前的订单价格 - 平均购买头寸的价格 )/平均购买头寸的价格 = 盈亏比例 如果比例 为正，意思就是获得了盈利， 为负的话就是亏损了
 		//假设您的平均购买价格是8000美元，现在的订单价格是9000美元 (9000-8000)/8000 = 0.125 转换为百分比，即12.5%的盈利。
 		order.Profit = (price - p.AvgPrice) / p.AvgPrice
+		// 空头头寸价格下跌才是盈利，盈亏方向与多头相反
+		if p.Side == model.SideTypeSell {
+			order.Profit = -order.Profit
+		}
 		// 盈亏金额=(当前订单价格−平均购买头寸的价格)×交易数量
 		// 假设您持有100BTC的头寸，平均购买价格是$8000/BTC。现在，价格上涨到$9000/BTC，您打算卖出50BTC。
 		// (9000-8000) x 50 = 1000×50=$50,000 你的盈利是$50,000。
-		order.ProfitValue = (price - p.AvgPrice) * quantity
+		order.ProfitValue = order.Profit * p.AvgPrice * quantity
 
 		// 创建一个Result对象，记录交易结果。
 		result = &Result{
@@ -333,17 +394,30 @@ func (p *Position) Update(order *model.Order) (result *Result, finished bool) {
 			ProfitPercent: order.Profit,
 			ProfitValue:   order.ProfitValue,
 			Side:          p.Side,
+			PositionSide:  p.PositionSide,
 		}
 
 		// 返回交易结果和头寸是否结束的标志。
 		//果头寸被完全平仓或通过订单被反向开仓（即头寸方向改变，并且新的头寸量小于等于订单量），那么这个值会被设置为true，表示头寸已经结束，不再持有任何资产。如果头寸没有被完全平仓，这个值会是false，表示头寸仍然存在。
-		return result, finished
+		return result, finished, err
 	}
 
 	// 如果订单的方向与头寸方向相同，不需要返回特殊的交易结果，返回nil和false。
 	//订单的方向（买入或卖出）与头寸的方向相同时，这意味着该操作是在增加现有头寸的量（如果是买入操作）或减少但不完全平仓（如果是卖出操作），而不是在关闭或反向开仓。在这种情况下，返回nil和false
 	//返回nil**代表没有特殊的交易结果需要记录或处理。这是因为头寸本质上没有发生质的改变，只是数量上的增减
-	return nil, false
+	return nil, false, nil
+}
+
+// expiryHandler 由自行处理订单过期的交易所实现，例如按K线时间过期订单的PaperWallet。
+// 其他交易所的订单过期时间由Controller按本地时间检查，到期后撤单。
+type expiryHandler interface {
+	HandlesExpiry() bool
+}
+
+// ocoEmulator 由没有原生OCO订单、用同一订单组的两个独立订单模拟OCO的交易所实现，例如币安合约。
+// 交易所不会自动撤销另一个订单，订单组中一个订单成交后由Controller撤销同一订单组中仍在挂单的订单。
+type ocoEmulator interface {
+	EmulatesOCO() bool
 }
 
 // Controller结构体将多个组件和服务整合在一起，管理交易逻辑的执行流程，包括交易操作、数据存储、实时数据订阅和通知发送等功能，形成了一个交易系统的核心部分。
@@ -362,6 +436,19 @@ type Controller struct {
 	status         Status              // 控制器的当前状态。
 
 	position map[string]*Position // 存储每个标的的当前头寸信息。 是一个映射，用于存储所有活跃的头寸信息，键是交易对的标识，值是对应的Position对象。
+
+	conditionalMtx sync.Mutex                        // 条件单专用锁，触发后提交订单时需要获取mtx，因此不能复用mtx
+	conditionals   map[int64]*model.ConditionalOrder // 等待触发的条件单，键是条件单ID
+	conditionalSeq int64                             // 条件单ID计数器
+	candleTime     map[string]time.Time              // 每个交易对最新K线的开盘时间，由conditionalMtx保护
+
+	streaming          int32         // 是否正在接收交易所推送的订单更新，通过atomic读写，1表示是
+	streamPollInterval time.Duration // 接收推送时兜底轮询的间隔
+	lastPoll           time.Time     // 上一次轮询订单的时间
+
+	reconcile              ReconcileConfig    // 对账器配置
+	reconcileBaseline      map[string]float64 // 每个交易对交易所持仓与本地推算持仓的基准差值
+	reconcileQuoteBaseline map[string]float64 // 每个计价资产交易所余额与本地推算余额的基准差值
 }
 
 // NewController 是Controller的构造函数，用于初始化一个Controller实例。
@@ -378,9 +465,30 @@ func NewController(ctx context.Context, exchange service.Exchange, storage stora
 		tickerInterval: time.Second, //表示定时器触发的间隔时间，默认设置为1秒。
 		finish:         make(chan bool),
 		position:       make(map[string]*Position),
+		conditionals:   make(map[int64]*model.ConditionalOrder),
+		candleTime:     make(map[string]time.Time),
+
+		streamPollInterval:     30 * time.Second, // 接收推送时每30秒轮询一次，防止漏掉推送
+		reconcileBaseline:      make(map[string]float64),
+		reconcileQuoteBaseline: make(map[string]float64),
 	}
 }
 
+// Trades 返回所有交易对已实现的交易结果，按平仓时间排序
+func (c *Controller) Trades() []Result {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	trades := make([]Result, 0)
+	for _, summary := range c.Results {
+		trades = append(trades, summary.Trades...)
+	}
+	sort.SliceStable(trades, func(i, j int) bool {
+		return trades[i].CreatedAt.Before(trades[j].CreatedAt)
+	})
+	return trades
+}
+
 // SetNotifier 方法用于设置Controller的通知器组件，允许Controller在需要时发送通知。
 func (c *Controller) SetNotifier(notifier service.Notifier) {
 	c.notifier = notifier
@@ -390,28 +498,46 @@ func (c *Controller) SetNotifier(notifier service.Notifier) {
 func (c *Controller) OnCandle(candle model.Candle) {
 	// 更新指定交易对的最新收盘价。
 	c.lastPrice[candle.Pair] = candle.Close
+	// 检查条件单是否触发
+	c.checkConditionals(candle)
+}
+
+// OnPartialCandle 处理尚未完成的K线，只用于检查条件单，不更新最新收盘价。
+func (c *Controller) OnPartialCandle(candle model.Candle) {
+	c.checkConditionals(candle)
 }
 
 // updatePosition 根据新订单信息更新或创建头寸。
 func (c *Controller) updatePosition(o *model.Order) {
-	// 尝试获取指定交易对的当前头寸。
-	position, ok := c.position[o.Pair]
+	// 尝试获取指定交易对的当前头寸，双向持仓模式下多仓和空仓分别记录。
+	key := positionKey(o.Pair, o.PositionSide)
+	position, ok := c.position[key]
 	if !ok {
+		// 双向持仓模式下，只有开仓方向的订单才会创建头寸，没有头寸时的平仓订单直接忽略。
+		if o.PositionSide != "" && o.Side != o.PositionSide.OpenSide() {
+			return
+		}
+
 		// 如果头寸不存在，则创建一个新的头寸并初始化它的基本信息。
-		c.position[o.Pair] = &Position{
-			AvgPrice:  o.Price,     // 设置头寸的平均价格为订单价格。
-			Quantity:  o.Quantity,  // 设置头寸的数量为订单数量。
-			CreatedAt: o.CreatedAt, // 记录头寸的创建时间。
-			Side:      o.Side,      // 设置头寸的方向（买或卖）。
+		c.position[key] = &Position{
+			AvgPrice:     o.Price,        // 设置头寸的平均价格为订单价格。
+			Quantity:     o.Quantity,     // 设置头寸的数量为订单数量。
+			CreatedAt:    o.CreatedAt,    // 记录头寸的创建时间。
+			Side:         o.Side,         // 设置头寸的方向（买或卖）。
+			PositionSide: o.PositionSide, // 记录双向持仓模式下的持仓方向。
 		}
 		return // 头寸创建后直接返回。
 	}
 
 	// 如果头寸存在，使用新订单信息更新头寸，并检查是否已平仓。
-	result, closed := position.Update(o)
+	result, closed, err := position.Update(o)
+	if err != nil {
+		// 超出的数量可能来自手动下单或者本地漏记的订单，通知用户核对交易所的持仓
+		c.notifyError(err)
+	}
 	if closed {
 		// 如果头寸已平仓，从头寸列表中删除该头寸。它从c.position映射中移除了对应的交易对（o.Pair）条目。
-		delete(c.position, o.Pair)
+		delete(c.position, key)
 	}
 
 	// 如果有更新结果，根据结果的盈亏情况进行处理。
@@ -420,6 +546,7 @@ func (c *Controller) updatePosition(o *model.Order) {
 		// 根据盈亏百分比和买卖方向，更新统计数据。
 		// 分为盈利和亏损两种情况，进一步分为买入和卖出两种交易方向。
 		// 根据不同情况，将盈亏值和百分比分别追加到对应的统计列表中。
+		c.Results[o.Pair].add(result)
 
 		// 根据订单信息，从交易对中分离出报价币种。
 		_, quote := exchange.SplitAssetQuote(o.Pair)
@@ -429,8 +556,14 @@ func (c *Controller) updatePosition(o *model.Order) {
 					[PROFIT] 100.00 USD (5 %)
 			          `交易结果`
 		*/
+		// 双向持仓模式下在通知中标明平仓的是多仓还是空仓
+		side := ""
+		if result.PositionSide != "" {
+			side = " " + string(result.PositionSide)
+		}
 		c.notify(fmt.Sprintf(
-			"[PROFIT] %f %s (%f %%)\n`%s`",
+			"[PROFIT]%s %f %s (%f %%)\n`%s`",
+			side,
 			result.ProfitValue,
 			quote,
 			result.ProfitPercent*100,
@@ -439,6 +572,14 @@ func (c *Controller) updatePosition(o *model.Order) {
 	}
 }
 
+// positionKey 返回头寸在Controller中的键，双向持仓模式下同一交易对的多仓和空仓使用不同的键。
+func positionKey(pair string, side model.PositionSideType) string {
+	if side == "" {
+		return pair
+	}
+	return pair + ":" + string(side)
+}
+
 // notify 是一个负责发送通知消息的方法。
 func (c *Controller) notify(message string) {
 	// 首先，通过日志系统记录传入的消息。这可以帮助开发者在查看日志文件时了解系统状态和重要事件。
@@ -509,20 +650,23 @@ func (c *Controller) updateOrders() {
 			continue                                                                 // 跳过当前订单，处理下一个订单
 		}
 
-		// 检查交易所返回的订单状态是否与数据库中存储的订单状态相同。如果相同，则说明订单状态没有发生变化，无需进行更新，直接跳过处理下一个订单。
-		if excOrder.Status == order.Status {
-			continue
-		}
-		//这行代码确实将交易所返回的订单的ID（excOrder.ID）设置为与数据库中对应订单的ID（order.ID）相同。这确保了在数据库中正确识别需要更新的订单。
-		excOrder.ID = order.ID                 // 确保更新后的订单有正确的内部ID
-		err = c.storage.UpdateOrder(&excOrder) // 将从交易所返回的订单更新后，保存到数据库中
-		if err != nil {
-			c.notifyError(err) // 如果更新订单失败，发送错误通知
-			continue           // 并继续处理下一个订单
+		// 交易所只知道自己的字段，保留本地记录的客户端订单ID、过期时间等信息
+		mergeLocalFields(&excOrder, *order)
+
+		// 交易所不支持按时间过期时，到期仍未成交的订单由Controller撤单
+		if c.shouldExpire(excOrder) {
+			if err := c.exchange.Cancel(excOrder); err != nil {
+				c.notifyError(err)
+				continue
+			}
+			excOrder.Status = model.OrderStatusTypeExpired
 		}
 
-		log.Infof("[ORDER %s] %s", excOrder.Status, excOrder) // 记录订单状态更新的日志
-		updatedOrders = append(updatedOrders, excOrder)       // 将更新后的订单添加到切片中
+		if !c.storeOrderUpdate(order, &excOrder) {
+			continue
+		}
+		c.cancelSiblings(excOrder)
+		updatedOrders = append(updatedOrders, excOrder) // 将更新后的订单添加到切片中
 	}
 
 	// 处理所有更新后的订单
@@ -533,6 +677,177 @@ func (c *Controller) updateOrders() {
 	}
 }
 
+// storeOrderUpdate 将交易所返回的订单状态保存到存储中，状态没有变化或者保存失败时返回false。
+func (c *Controller) storeOrderUpdate(order *model.Order, excOrder *model.Order) bool {
+	// 检查交易所返回的订单状态是否与数据库中存储的订单状态相同。如果相同，则说明订单状态没有发生变化，无需进行更新，直接跳过处理下一个订单。
+	if excOrder.Status == order.Status {
+		return false
+	}
+	//这行代码确实将交易所返回的订单的ID（excOrder.ID）设置为与数据库中对应订单的ID（order.ID）相同。这确保了在数据库中正确识别需要更新的订单。
+	excOrder.ID = order.ID                                  // 确保更新后的订单有正确的内部ID
+	if err := c.storage.UpdateOrder(excOrder); err != nil { // 将从交易所返回的订单更新后，保存到数据库中
+		c.notifyError(err) // 如果更新订单失败，发送错误通知
+		return false
+	}
+
+	log.Infof("[ORDER %s] %s", excOrder.Status, excOrder) // 记录订单状态更新的日志
+	return true
+}
+
+// onOrderUpdate 处理交易所推送的订单更新。本地没有记录的订单直接忽略，交给对账器导入。
+func (c *Controller) onOrderUpdate(excOrder model.Order) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	orders, err := c.storage.Orders(storage.WithExchangeID(excOrder.ExchangeID), storage.WithPair(excOrder.Pair))
+	if err != nil {
+		c.notifyError(err)
+		return
+	}
+	if len(orders) == 0 {
+		log.Debugf("[ORDER] Ignoring update of unknown order %s", excOrder)
+		return
+	}
+
+	order := orders[0]
+	mergeLocalFields(&excOrder, *order)
+	if !c.storeOrderUpdate(order, &excOrder) {
+		return
+	}
+	c.cancelSiblings(excOrder)
+
+	c.processTrade(&excOrder)
+	c.orderFeed.Publish(excOrder, false)
+}
+
+// subscribeOrders 订阅交易所推送的订单更新，订阅结束后Controller回退到按tickerInterval轮询。
+func (c *Controller) subscribeOrders(streamer service.OrderStreamer) {
+	orders, errs := streamer.OrderSubscription(c.ctx)
+	atomic.StoreInt32(&c.streaming, 1)
+	log.Info("[ORDER] Subscribed to exchange order updates")
+
+	go func() {
+		defer atomic.StoreInt32(&c.streaming, 0)
+		for {
+			select {
+			case order, ok := <-orders:
+				if !ok {
+					log.Warn("[ORDER] Order stream closed, falling back to polling")
+					return
+				}
+				c.onOrderUpdate(order)
+			case err, ok := <-errs:
+				if !ok {
+					log.Warn("[ORDER] Order stream closed, falling back to polling")
+					return
+				}
+				log.Error("orderController/stream: ", err)
+			}
+		}
+	}()
+}
+
+// shouldPoll 判断这次定时器触发时是否需要轮询订单。有订单推送时只需要每隔streamPollInterval轮询一次作为兜底。
+func (c *Controller) shouldPoll(now time.Time) bool {
+	if atomic.LoadInt32(&c.streaming) == 1 && now.Sub(c.lastPoll) < c.streamPollInterval {
+		return false
+	}
+	c.lastPoll = now
+	return true
+}
+
+// mergeLocalFields 将只保存在本地的订单字段补充到交易所返回的订单中。
+func mergeLocalFields(excOrder *model.Order, order model.Order) {
+	if excOrder.ClientOrderID == "" {
+		excOrder.ClientOrderID = order.ClientOrderID
+	}
+	if excOrder.TimeInForce == "" {
+		excOrder.TimeInForce = order.TimeInForce
+	}
+	if excOrder.ExpireAt == nil {
+		excOrder.ExpireAt = order.ExpireAt
+	}
+	if excOrder.GroupID == nil {
+		excOrder.GroupID = order.GroupID
+	}
+	if excOrder.Stop == nil {
+		excOrder.Stop = order.Stop
+	}
+	if excOrder.ReplacedID == nil {
+		excOrder.ReplacedID = order.ReplacedID
+	}
+}
+
+// cancelSiblings 在模拟OCO的交易所中，订单组的一个订单成交后撤销同一订单组中仍在挂单的订单。
+// 这里只向交易所撤单，被撤销订单的状态和其他订单一样由之后的轮询或者推送更新。
+func (c *Controller) cancelSiblings(order model.Order) {
+	if order.Status != model.OrderStatusTypeFilled || order.GroupID == nil {
+		return
+	}
+	if emulator, ok := c.exchange.(ocoEmulator); !ok || !emulator.EmulatesOCO() {
+		return
+	}
+
+	siblings, err := c.storage.Orders(
+		storage.WithPair(order.Pair),
+		storage.WithStatusIn(model.OrderStatusTypeNew, model.OrderStatusTypePartiallyFilled),
+		func(sibling model.Order) bool {
+			return sibling.GroupID != nil && *sibling.GroupID == *order.GroupID &&
+				sibling.ExchangeID != order.ExchangeID
+		},
+	)
+	if err != nil {
+		c.notifyError(err)
+		return
+	}
+
+	for _, sibling := range siblings {
+		log.Infof("[ORDER] Canceling %s, order %d of group %d was filled", sibling, order.ExchangeID, *order.GroupID)
+		if err := c.exchange.Cancel(*sibling); err != nil {
+			c.notifyError(err)
+		}
+	}
+}
+
+// shouldExpire 判断订单是否需要由Controller按本地时间过期。
+func (c *Controller) shouldExpire(order model.Order) bool {
+	if handler, ok := c.exchange.(expiryHandler); ok && handler.HandlesExpiry() {
+		return false
+	}
+
+	pending := order.Status == model.OrderStatusTypeNew || order.Status == model.OrderStatusTypePartiallyFilled
+	return pending && order.IsExpired(time.Now())
+}
+
+// existingOrders 根据客户端订单ID查找已经提交过的订单，实现幂等提交。
+// OCO订单会返回同一订单组的所有订单；没有指定客户端订单ID时返回nil。
+func (c *Controller) existingOrders(params model.OrderParams) ([]model.Order, error) {
+	if params.ClientOrderID == "" {
+		return nil, nil
+	}
+
+	orders, err := c.storage.Orders(storage.WithClientOrderID(params.ClientOrderID))
+	if err != nil || len(orders) == 0 {
+		return nil, err
+	}
+
+	if orders[0].GroupID != nil {
+		first := *orders[0]
+		orders, err = c.storage.Orders(func(order model.Order) bool {
+			return order.GroupID != nil && *order.GroupID == *first.GroupID && order.Pair == first.Pair
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := make([]model.Order, 0, len(orders))
+	for _, order := range orders {
+		result = append(result, *order)
+	}
+	return result, nil
+}
+
 // Status 返回控制器当前的运行状态。
 func (c *Controller) Status() Status {
 	return c.status
@@ -552,12 +867,28 @@ func (c *Controller) Start() {
 		go func() {
 			// 创建一个定时器，根据结构体c.tickerInterval参数来触发时间间隔
 			//定时器 (time.Ticker) 到达设定的时间间隔时，就会向他的通道发送时间，这个就相当于信号，然后开始更新订单，stopChan 控制停止信号你可以从程序的任何地方（主goroutine或其他goroutine）发送一个信号到这个通道，以通知接收方（在这个例子中是监听 stopChan 的goroutine）停止执行，如stopChan <- true，然后就终止
+			// 交易所支持推送订单更新时订阅推送，轮询只作为兜底
+			if streamer, ok := c.exchange.(service.OrderStreamer); ok {
+				c.subscribeOrders(streamer)
+			}
+
 			ticker := time.NewTicker(c.tickerInterval)
+			// 没有配置对账间隔时reconcileC为nil，select永远不会选中它
+			var reconcileC <-chan time.Time
+			if reconciler := c.newReconcileTicker(); reconciler != nil {
+				reconcileC = reconciler.C
+				defer reconciler.Stop()
+			}
 			for {
 				select {
 				// 当定时器的通道接收到信号时，调用updateOrders方法更新订单
-				case <-ticker.C:
-					c.updateOrders()
+				case now := <-ticker.C:
+					if c.shouldPoll(now) {
+						c.updateOrders()
+					}
+				// 定期与交易所对账
+				case <-reconcileC:
+					c.runReconcile()
 				// 如果收到停止信号，停止定时器并退出协程
 				case <-c.finish:
 					ticker.Stop()
@@ -600,6 +931,11 @@ func (c *Controller) Position(pair string) (asset, quote float64, err error) {
 	return c.exchange.Position(pair)
 }
 
+// 调用交易所PositionInfo接口，返回持仓方向、开仓均价、杠杆和强平价等持仓详情
+func (c *Controller) PositionInfo(pair string) (model.PositionInfo, error) {
+	return c.exchange.PositionInfo(pair)
+}
+
 // 调用交易所LastQuote 接口返回最新报价
 func (c *Controller) LastQuote(pair string) (float64, error) {
 	return c.exchange.LastQuote(c.ctx, pair)
@@ -623,15 +959,21 @@ func (c *Controller) Order(pair string, id int64) (model.Order, error) {
 
 // Controller 结构体负责创建OCO订单，并处理与订单相关的逻辑，例如加锁以避免并发问题、记录日志、错误处理和订单数据的存储与发布。 size订单数量，price float64: 目标价格， stop 止损价，stopLimit止损限价
 // （一单成交即取消另一单）确实是这样工作的。在金融交易中，OCO（One Cancels the Other）订单包括两个订单：一个止盈单和一个止损单。这两个订单同时下达，但是一旦其中一个条件被触发并且订单成交，另一个订单将自动被取消。
-func (c *Controller) CreateOrderOCO(side model.SideType, pair string, size, price, stop, stopLimit float64) ([]model.Order, error) {
+func (c *Controller) CreateOrderOCO(side model.SideType, pair string, size, price, stop, stopLimit float64,
+	options ...model.OrderOption) ([]model.Order, error) {
 	c.mtx.Lock()         // 加锁，确保同时只有一个操作可以修改控制器的状态
 	defer c.mtx.Unlock() // 函数执行结束时解锁，无论是正常结束还是由于错误提前返回
 
+	// 相同客户端订单ID的订单已经提交过，直接返回已有的订单
+	if existing, err := c.existingOrders(model.NewOrderParams(options...)); err != nil || len(existing) > 0 {
+		return existing, err
+	}
+
 	log.Infof("[ORDER] Creating OCO order for %s", pair) // 记录日志，表示正在为指定交易对创建OCO订单
 
 	// 调用exchange的CreateOrderOCO方法创建OCO订单
 	// 此处传递订单参数：方向（买/卖）、交易对、订单大小、价格、止损价格和止损限价
-	orders, err := c.exchange.CreateOrderOCO(side, pair, size, price, stop, stopLimit)
+	orders, err := c.exchange.CreateOrderOCO(side, pair, size, price, stop, stopLimit, options...)
 	if err != nil {
 		c.notifyError(err) // 如果创建订单时出现错误，调用notifyError方法通知错误
 		return nil, err    // 返回错误，中断函数执行
@@ -653,15 +995,25 @@ func (c *Controller) CreateOrderOCO(side model.SideType, pair string, size, pric
 }
 
 // CreateOrderLimit 方法在 Controller 结构体中用于创建一个限价订单 limit限价
-func (c *Controller) CreateOrderLimit(side model.SideType, pair string, size, limit float64) (model.Order, error) {
+func (c *Controller) CreateOrderLimit(side model.SideType, pair string, size, limit float64,
+	options ...model.OrderOption) (model.Order, error) {
 	c.mtx.Lock()         // 加锁，防止同时对Controller对象的并发修改
 	defer c.mtx.Unlock() // 确保在函数退出时释放锁，无论是通过正常返回还是因为错误提前退出
 
+	// 相同客户端订单ID的订单已经提交过，直接返回已有的订单
+	if existing, err := c.existingOrders(model.NewOrderParams(options...)); err != nil || len(existing) > 0 {
+		if err != nil {
+			return model.Order{}, err
+		}
+		log.Infof("[ORDER] Order already submitted: %s", existing[0])
+		return existing[0], nil
+	}
+
 	// 使用日志记录正在创建限价订单的信息，包括订单的方向（买/卖）、交易对
 	log.Infof("[ORDER] Creating LIMIT %s order for %s", side, pair)
 
 	// 调用交易所接口创建限价订单，传入订单方向、交易对、数量和限价
-	order, err := c.exchange.CreateOrderLimit(side, pair, size, limit)
+	order, err := c.exchange.CreateOrderLimit(side, pair, size, limit, options...)
 	if err != nil {
 		// 如果创建订单过程中发生错误，记录并通知错误，然后返回一个空的Order对象和错误信息
 		c.notifyError(err)
@@ -688,15 +1040,25 @@ func (c *Controller) CreateOrderLimit(side model.SideType, pair string, size, li
 }
 
 // CreateOrderMarketQuote 方法的目的是在交易系统中创建一个基于市场报价的订单amount 金额
-func (c *Controller) CreateOrderMarketQuote(side model.SideType, pair string, amount float64) (model.Order, error) {
+func (c *Controller) CreateOrderMarketQuote(side model.SideType, pair string, amount float64,
+	options ...model.OrderOption) (model.Order, error) {
 	c.mtx.Lock()         // 加锁以保证在创建订单过程中的线程安全
 	defer c.mtx.Unlock() // 使用defer确保函数结束时解锁，即使是在返回错误时也能确保锁被释放
 
+	// 相同客户端订单ID的订单已经提交过，直接返回已有的订单
+	if existing, err := c.existingOrders(model.NewOrderParams(options...)); err != nil || len(existing) > 0 {
+		if err != nil {
+			return model.Order{}, err
+		}
+		log.Infof("[ORDER] Order already submitted: %s", existing[0])
+		return existing[0], nil
+	}
+
 	// 记录日志，表示正在创建市价订单，包括订单的方向和交易对
 	log.Infof("[ORDER] Creating MARKET %s order for %s", side, pair)
 
 	// 调用交易所接口创建市价订单，传入订单的方向、交易对和金额
-	order, err := c.exchange.CreateOrderMarketQuote(side, pair, amount)
+	order, err := c.exchange.CreateOrderMarketQuote(side, pair, amount, options...)
 	if err != nil {
 		// 如果在创建订单时出现错误，通过notifyError方法记录并通知错误，然后返回空订单和错误信息
 		c.notifyError(err)
@@ -726,15 +1088,25 @@ func (c *Controller) CreateOrderMarketQuote(side model.SideType, pair string, am
 }
 
 // CreateOrderMarket 方法的作用是在交易系统中创建一个市价订单
-func (c *Controller) CreateOrderMarket(side model.SideType, pair string, size float64) (model.Order, error) {
+func (c *Controller) CreateOrderMarket(side model.SideType, pair string, size float64,
+	options ...model.OrderOption) (model.Order, error) {
 	c.mtx.Lock()         // 在操作开始时加锁，以保证并发操作的线程安全
 	defer c.mtx.Unlock() // 确保在函数结束时释放锁，无论函数是正常结束还是由于中途返回错误
 
+	// 相同客户端订单ID的订单已经提交过，直接返回已有的订单
+	if existing, err := c.existingOrders(model.NewOrderParams(options...)); err != nil || len(existing) > 0 {
+		if err != nil {
+			return model.Order{}, err
+		}
+		log.Infof("[ORDER] Order already submitted: %s", existing[0])
+		return existing[0], nil
+	}
+
 	// 记录日志，表示正在创建市价订单，这里会显示订单的方向（买入/卖出）和交易对
 	log.Infof("[ORDER] Creating MARKET %s order for %s", side, pair)
 
 	// 调用与交易所交互的接口来创建市价订单，传入订单方向、交易对和订单大小
-	order, err := c.exchange.CreateOrderMarket(side, pair, size)
+	order, err := c.exchange.CreateOrderMarket(side, pair, size, options...)
 	if err != nil {
 		// 如果在创建订单的过程中出现错误，使用notifyError方法记录和通知错误
 		c.notifyError(err)
@@ -764,15 +1136,25 @@ func (c *Controller) CreateOrderMarket(side model.SideType, pair string, size fl
 }
 
 // CreateOrderStop 方法在 Controller 结构体中用于创建一个止损订单（Stop Order）
-func (c *Controller) CreateOrderStop(pair string, size float64, limit float64) (model.Order, error) {
+func (c *Controller) CreateOrderStop(pair string, size float64, limit float64,
+	options ...model.OrderOption) (model.Order, error) {
 	c.mtx.Lock()         // 在操作开始时加锁，以保证并发操作的线程安全
 	defer c.mtx.Unlock() // 使用 defer 确保在函数结束时释放锁，无论函数是正常结束还是由于中途返回错误
 
+	// 相同客户端订单ID的订单已经提交过，直接返回已有的订单
+	if existing, err := c.existingOrders(model.NewOrderParams(options...)); err != nil || len(existing) > 0 {
+		if err != nil {
+			return model.Order{}, err
+		}
+		log.Infof("[ORDER] Order already submitted: %s", existing[0])
+		return existing[0], nil
+	}
+
 	// 记录日志，表示正在为特定的货币对创建止损订单
 	log.Infof("[ORDER] Creating STOP order for %s", pair)
 
 	// 调用与交易所交互的接口来创建止损订单，传入货币对、订单大小和止损价格
-	order, err := c.exchange.CreateOrderStop(pair, size, limit)
+	order, err := c.exchange.CreateOrderStop(pair, size, limit, options...)
 	if err != nil {
 		// 如果在创建订单的过程中出现错误，使用 notifyError 方法记录和通知错误
 		c.notifyError(err)
@@ -797,6 +1179,76 @@ func (c *Controller) CreateOrderStop(pair string, size float64, limit float64) (
 	return order, nil
 }
 
+// ReplaceOrder 修改挂单的价格和数量。交易所改单成功后，本地记录的原订单被置为CANCELED，
+// 新订单保存到存储中并通过ReplacedID关联原订单；交易所原地修改订单（ExchangeID不变）时直接更新本地记录。
+// 改单失败时会重新查询原订单，避免撤单成功但新订单创建失败时本地仍然认为原订单在挂单中。
+func (c *Controller) ReplaceOrder(order model.Order, price, quantity float64) (model.Order, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	log.Infof("[ORDER] Replacing order %d for %s", order.ExchangeID, order.Pair)
+
+	replacement, err := c.exchange.ReplaceOrder(order, price, quantity)
+	if err != nil {
+		c.notifyError(err)
+		c.syncCanceledOrder(order)
+		return model.Order{}, err
+	}
+
+	if replacement.ExchangeID == order.ExchangeID {
+		mergeLocalFields(&replacement, order)
+		replacement.ID = order.ID
+		if err := c.storage.UpdateOrder(&replacement); err != nil {
+			c.notifyError(err)
+			return model.Order{}, err
+		}
+		go c.orderFeed.Publish(replacement, false)
+
+		log.Infof("[ORDER MODIFIED] %s", replacement)
+		return replacement, nil
+	}
+
+	// 原订单已经在交易所被取消
+	order.Status = model.OrderStatusTypeCanceled
+	if err := c.storage.UpdateOrder(&order); err != nil {
+		c.notifyError(err)
+		return model.Order{}, err
+	}
+
+	if err := c.storage.CreateOrder(&replacement); err != nil {
+		c.notifyError(err)
+		return model.Order{}, err
+	}
+
+	go c.orderFeed.Publish(order, false)
+	go c.orderFeed.Publish(replacement, true)
+
+	log.Infof("[ORDER REPLACED] %s", replacement)
+	return replacement, nil
+}
+
+// syncCanceledOrder 查询交易所中订单的最新状态，订单已经被取消或过期时同步到本地存储。
+// 成交的订单不在这里处理，留给updateOrders统一计算头寸和盈亏。
+func (c *Controller) syncCanceledOrder(order model.Order) {
+	excOrder, err := c.exchange.Order(order.Pair, order.ExchangeID)
+	if err != nil {
+		log.WithField("id", order.ExchangeID).Error("orderController/replace: ", err)
+		return
+	}
+
+	if excOrder.Status != model.OrderStatusTypeCanceled && excOrder.Status != model.OrderStatusTypeExpired {
+		return
+	}
+
+	mergeLocalFields(&excOrder, order)
+	excOrder.ID = order.ID
+	if err := c.storage.UpdateOrder(&excOrder); err != nil {
+		c.notifyError(err)
+		return
+	}
+	go c.orderFeed.Publish(excOrder, false)
+}
+
 // 这个 Cancel 方法是 Controller 结构体中用于取消一个订单的函数
 func (c *Controller) Cancel(order model.Order) error {
 	c.mtx.Lock()         // 在开始操作之前加锁，以确保并发操作时的数据一致性和线程安全
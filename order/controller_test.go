@@ -177,6 +177,17 @@ func TestController_updatePosition(t *testing.T) {
 		assert.Equal(t, model.SideTypeSell, controller.position["BTCUSDT"].Side)
 		assert.Equal(t, 1500.0, controller.position["BTCUSDT"].AvgPrice)
 		assert.Equal(t, 1.0, controller.position["BTCUSDT"].Quantity)
+
+		// 价格下跌后平掉空仓是盈利
+		wallet.OnCandle(model.Candle{Time: time.Now(), Pair: "BTCUSDT", Close: 1200, Low: 1200})
+		order, err := controller.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 1)
+		require.NoError(t, err)
+
+		assert.Nil(t, controller.position["BTCUSDT"])
+		assert.InDelta(t, 0.2, order.Profit, 1e-9)
+		assert.InDelta(t, 300.0, order.ProfitValue, 1e-9)
+		require.Len(t, controller.Results["BTCUSDT"].WinShort, 1)
+		assert.InDelta(t, 300.0, controller.Results["BTCUSDT"].WinShort[0], 1e-9)
 	})
 }
 
@@ -222,3 +233,306 @@ func TestController_Position(t *testing.T) {
 	assert.Equal(t, 1.0, asset)
 	assert.Equal(t, 1500.0, quote)
 }
+
+func TestController_HedgePosition(t *testing.T) {
+	storage, err := storage.FromMemory()
+	require.NoError(t, err)
+	ctx := context.Background()
+	wallet := exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 3000))
+	controller := NewController(ctx, wallet, storage, NewOrderFeed())
+	wallet.OnCandle(model.Candle{Time: time.Now(), Pair: "BTCUSDT", Close: 1000})
+
+	// 同一交易对同时持有多仓和空仓
+	_, err = controller.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 1,
+		model.WithPositionSide(model.PositionSideTypeLong))
+	require.NoError(t, err)
+	_, err = controller.CreateOrderMarket(model.SideTypeSell, "BTCUSDT", 1,
+		model.WithPositionSide(model.PositionSideTypeShort))
+	require.NoError(t, err)
+
+	require.Len(t, controller.position, 2)
+	assert.Equal(t, model.SideTypeBuy, controller.position["BTCUSDT:LONG"].Side)
+	assert.Equal(t, model.SideTypeSell, controller.position["BTCUSDT:SHORT"].Side)
+
+	// 平仓数量超过头寸时只平掉空仓，不会反向开多
+	wallet.OnCandle(model.Candle{Time: time.Now(), Pair: "BTCUSDT", Close: 1500})
+	order, err := controller.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 1,
+		model.WithPositionSide(model.PositionSideTypeShort))
+	require.NoError(t, err)
+	assert.Equal(t, model.PositionSideTypeShort, order.PositionSide)
+	assert.Equal(t, -500.0, order.ProfitValue)
+	assert.Equal(t, -0.5, order.Profit)
+	assert.Nil(t, controller.position["BTCUSDT:SHORT"])
+
+	_, err = controller.CreateOrderMarket(model.SideTypeSell, "BTCUSDT", 1,
+		model.WithPositionSide(model.PositionSideTypeLong))
+	require.NoError(t, err)
+	assert.Empty(t, controller.position)
+
+	// 没有头寸时的平仓订单不会创建新的头寸
+	_, err = controller.CreateOrderMarket(model.SideTypeSell, "BTCUSDT", 0.5,
+		model.WithPositionSide(model.PositionSideTypeLong))
+	require.NoError(t, err)
+	assert.Empty(t, controller.position)
+
+	results := controller.Results["BTCUSDT"]
+	assert.Equal(t, []float64{500}, results.WinLong)
+	assert.Equal(t, []float64{-500}, results.LoseShort)
+	assert.Equal(t, 500.0, results.LongProfit())
+	assert.Equal(t, -500.0, results.ShortProfit())
+	assert.Zero(t, results.Profit())
+}
+
+func TestPosition_UpdateHedgeSurplus(t *testing.T) {
+	position := &Position{Side: model.SideTypeSell, PositionSide: model.PositionSideTypeShort,
+		AvgPrice: 1000, Quantity: 1}
+
+	order := &model.Order{Pair: "BTCUSDT", Side: model.SideTypeBuy, PositionSide: model.PositionSideTypeShort,
+		Type: model.OrderTypeMarket, Price: 900, Quantity: 1.5}
+	result, finished, err := position.Update(order)
+	require.ErrorIs(t, err, ErrPositionExceeded)
+	assert.True(t, finished)
+	require.NotNil(t, result)
+
+	// 只有头寸范围内的数量计入盈亏
+	assert.InDelta(t, 100.0, result.ProfitValue, 1e-9)
+	assert.Equal(t, model.SideTypeSell, position.Side)
+
+	// 数量相等时正常平仓
+	position = &Position{Side: model.SideTypeSell, PositionSide: model.PositionSideTypeShort,
+		AvgPrice: 1000, Quantity: 1}
+	order.Quantity = 1
+	_, finished, err = position.Update(order)
+	require.NoError(t, err)
+	assert.True(t, finished)
+}
+
+func TestController_ClientOrderID(t *testing.T) {
+	storage, err := storage.FromMemory()
+	require.NoError(t, err)
+	ctx := context.Background()
+	wallet := exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 3000))
+	controller := NewController(ctx, wallet, storage, NewOrderFeed())
+
+	lastCandle := model.Candle{Time: time.Now(), Pair: "BTCUSDT", Close: 1500, Low: 1500}
+	wallet.OnCandle(lastCandle)
+	controller.OnCandle(lastCandle)
+
+	first, err := controller.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 1000,
+		model.WithClientOrderID("entry-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "entry-1", first.ClientOrderID)
+
+	// 重复提交同一个客户端订单ID，不应该重复下单
+	second, err := controller.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 1000,
+		model.WithClientOrderID("entry-1"))
+	require.NoError(t, err)
+	assert.Equal(t, first.ExchangeID, second.ExchangeID)
+
+	orders, err := controller.storage.Orders()
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+}
+
+func TestController_ReplaceOrder(t *testing.T) {
+	db, err := storage.FromMemory()
+	require.NoError(t, err)
+	ctx := context.Background()
+	wallet := exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 3000))
+	controller := NewController(ctx, wallet, db, NewOrderFeed())
+
+	lastCandle := model.Candle{Time: time.Now(), Pair: "BTCUSDT", Close: 1500, Low: 1500}
+	wallet.OnCandle(lastCandle)
+	controller.OnCandle(lastCandle)
+
+	order, err := controller.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 1000)
+	require.NoError(t, err)
+
+	replacement, err := controller.ReplaceOrder(order, 1100, 1)
+	require.NoError(t, err)
+	require.Equal(t, order.ExchangeID, *replacement.ReplacedID)
+
+	original, err := db.Orders(func(o model.Order) bool {
+		return o.ID == order.ID
+	})
+	require.NoError(t, err)
+	require.Len(t, original, 1)
+	assert.Equal(t, model.OrderStatusTypeCanceled, original[0].Status)
+
+	linked, err := db.Orders(storage.WithReplacedID(order.ExchangeID))
+	require.NoError(t, err)
+	require.Len(t, linked, 1)
+	assert.Equal(t, replacement.ID, linked[0].ID)
+	assert.Equal(t, 1100.0, linked[0].Price)
+
+	// 资金不足时改单失败，原订单保持挂单
+	_, err = controller.ReplaceOrder(replacement, 1100, 10)
+	require.Error(t, err)
+	pending, err := db.Orders(storage.WithStatus(model.OrderStatusTypeNew))
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, replacement.ID, pending[0].ID)
+}
+
+// amendWallet 模拟原地改单的交易所，改单后订单的ExchangeID不变
+type amendWallet struct {
+	*exchange.PaperWallet
+}
+
+func (a amendWallet) ReplaceOrder(order model.Order, price, quantity float64) (model.Order, error) {
+	order.Price, order.Quantity = price, quantity
+	order.ExpireAt = nil
+	return order, nil
+}
+
+func TestController_ReplaceOrderInPlace(t *testing.T) {
+	db, err := storage.FromMemory()
+	require.NoError(t, err)
+	ctx := context.Background()
+	wallet := exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 3000))
+	controller := NewController(ctx, amendWallet{wallet}, db, NewOrderFeed())
+
+	lastCandle := model.Candle{Time: time.Now(), Pair: "BTCUSDT", Close: 1500, Low: 1500}
+	wallet.OnCandle(lastCandle)
+	controller.OnCandle(lastCandle)
+
+	expireAt := time.Now().Add(time.Hour)
+	order, err := controller.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 1000, model.WithExpireAt(expireAt))
+	require.NoError(t, err)
+
+	modified, err := controller.ReplaceOrder(order, 1100, 0.5)
+	require.NoError(t, err)
+	assert.Equal(t, order.ID, modified.ID)
+	assert.Nil(t, modified.ReplacedID)
+
+	orders, err := db.Orders()
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, model.OrderStatusTypeNew, orders[0].Status)
+	assert.Equal(t, 1100.0, orders[0].Price)
+	assert.Equal(t, 0.5, orders[0].Quantity)
+	require.NotNil(t, orders[0].ExpireAt)
+	assert.True(t, expireAt.Equal(*orders[0].ExpireAt))
+}
+
+// streamWallet 在PaperWallet之上模拟交易所推送订单更新
+type streamWallet struct {
+	*exchange.PaperWallet
+	orders chan model.Order
+	errs   chan error
+}
+
+func (s streamWallet) OrderSubscription(context.Context) (chan model.Order, chan error) {
+	return s.orders, s.errs
+}
+
+func TestController_OrderStream(t *testing.T) {
+	db, err := storage.FromMemory()
+	require.NoError(t, err)
+	ctx := context.Background()
+	wallet := streamWallet{
+		PaperWallet: exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 3000)),
+		orders:      make(chan model.Order),
+		errs:        make(chan error),
+	}
+	controller := NewController(ctx, wallet, db, NewOrderFeed())
+
+	candle := model.Candle{Time: time.Now(), Pair: "BTCUSDT", Close: 1500, Low: 1500}
+	wallet.OnCandle(candle)
+	controller.OnCandle(candle)
+
+	order, err := controller.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 1000)
+	require.NoError(t, err)
+
+	controller.subscribeOrders(wallet)
+	now := time.Now()
+	require.True(t, controller.shouldPoll(now))
+	require.False(t, controller.shouldPoll(now.Add(time.Second)))
+
+	// 交易所推送成交回报，Controller不需要轮询就更新订单和头寸
+	wallet.OnCandle(model.Candle{Time: time.Now(), Pair: "BTCUSDT", Close: 1000, Low: 1000})
+	filled, err := wallet.Order("BTCUSDT", order.ExchangeID)
+	require.NoError(t, err)
+	wallet.orders <- filled
+	// 未知订单被忽略
+	wallet.orders <- model.Order{ExchangeID: 999, Pair: "BTCUSDT", Status: model.OrderStatusTypeFilled}
+
+	orders, err := db.Orders(storage.WithStatus(model.OrderStatusTypeFilled))
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, order.ID, orders[0].ID)
+	controller.mtx.Lock()
+	assert.Equal(t, 1.0, controller.position["BTCUSDT"].Quantity)
+	controller.mtx.Unlock()
+
+	// 推送中断后回退到轮询
+	close(wallet.orders)
+	require.Eventually(t, func() bool {
+		return controller.shouldPoll(now.Add(2 * time.Second))
+	}, time.Second, 10*time.Millisecond)
+}
+
+// ocoWallet 在PaperWallet之上用两个独立订单模拟OCO，交易所不会自动撤销另一个订单
+type ocoWallet struct {
+	*exchange.PaperWallet
+}
+
+func (o ocoWallet) CreateOrderOCO(side model.SideType, pair string, size, price, stop, _ float64,
+	_ ...model.OrderOption) ([]model.Order, error) {
+	takeProfit, err := o.CreateOrderLimit(side, pair, size, price)
+	if err != nil {
+		return nil, err
+	}
+	stopLoss, err := o.CreateOrderStop(pair, size, stop)
+	if err != nil {
+		return nil, err
+	}
+	takeProfit.GroupID = &takeProfit.ExchangeID
+	stopLoss.GroupID = &takeProfit.ExchangeID
+	return []model.Order{takeProfit, stopLoss}, nil
+}
+
+func (o ocoWallet) EmulatesOCO() bool {
+	return true
+}
+
+func TestController_EmulatedOCO(t *testing.T) {
+	db, err := storage.FromMemory()
+	require.NoError(t, err)
+	ctx := context.Background()
+	wallet := ocoWallet{PaperWallet: exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 3000))}
+	controller := NewController(ctx, wallet, db, NewOrderFeed())
+
+	candle := model.Candle{Time: time.Now(), Pair: "BTCUSDT", Close: 1000, Low: 1000, High: 1000}
+	wallet.OnCandle(candle)
+	controller.OnCandle(candle)
+	_, err = controller.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 1)
+	require.NoError(t, err)
+
+	orders, err := controller.CreateOrderOCO(model.SideTypeSell, "BTCUSDT", 1, 1200, 900, 900)
+	require.NoError(t, err)
+	require.Len(t, orders, 2)
+
+	// 止损单成交后，Controller撤销同一订单组的止盈单
+	wallet.OnCandle(model.Candle{Time: time.Now(), Pair: "BTCUSDT", Close: 850, Low: 850, High: 1000})
+	controller.updateOrders()
+	takeProfit, err := wallet.Order("BTCUSDT", orders[0].ExchangeID)
+	require.NoError(t, err)
+	assert.Equal(t, model.OrderStatusTypeCanceled, takeProfit.Status)
+
+	controller.updateOrders()
+	stored, err := db.Orders(storage.WithExchangeID(orders[0].ExchangeID))
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	assert.Equal(t, model.OrderStatusTypeCanceled, stored[0].Status)
+	stored, err = db.Orders(storage.WithExchangeID(orders[1].ExchangeID))
+	require.NoError(t, err)
+	assert.Equal(t, model.OrderStatusTypeFilled, stored[0].Status)
+}
+
+func TestSummary_String(t *testing.T) {
+	s := summary{Pair: "BTCUSDT", WinLong: []float64{100}, LoseShort: []float64{-40, -10}}
+	table := s.String()
+	assert.Contains(t, table, "| Long    | 1 | 100.0000 USDT |")
+	assert.Contains(t, table, "| Short   | 2 | -50.0000 USDT |")
+}
@@ -0,0 +1,347 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+	"github.com/rodrigo-brito/ninjabot/storage"
+)
+
+// ErrReconcileUnsupported 表示交易所不支持列出订单，无法与本地存储对账。
+var ErrReconcileUnsupported = errors.New("exchange does not support order listing")
+
+const (
+	defaultReconcileLimit     = 100  // 每个交易对默认从交易所拉取的订单数量
+	defaultReconcileTolerance = 1e-8 // 默认允许的余额偏差，用于忽略浮点误差
+)
+
+/*
+updateOrders 只会轮询本地存储中仍在挂单的订单，手动在交易所下的订单，或者程序崩溃时漏记的订单都不会被发现。
+对账器定期把交易所的订单列表和余额与本地存储比较：
+  - 交易所中存在、本地没有的订单会被导入存储，挂单中的订单之后由 updateOrders 跟踪；
+  - 本地仍在挂单、交易所没有列出、并且查询时返回 ErrOrderNotFound 的订单被标记为 VANISHED，
+    查询返回其他错误时订单保持原状态，错误记录在对账结果中；
+  - 交易所持仓或计价资产的变化与本地已成交订单推算的变化不一致时，通过通知器报告余额偏差。
+REST请求在加锁之前完成，加锁后只读写本地存储，对账不会长时间阻塞下单。
+*/
+
+// ReconcileConfig 是对账器的配置。
+// 计价资产由多个交易对共用时，需要把这些交易对都加入Pairs，否则其他交易对的成交会被报告为计价资产偏差。
+type ReconcileConfig struct {
+	Interval       time.Duration // 对账间隔，为0时不启动定时对账，只能手动调用Reconcile
+	Pairs          []string      // 需要对账的交易对
+	Limit          int           // 每个交易对从交易所拉取的最近订单数量，默认100
+	Tolerance      float64       // 允许的余额偏差（基础资产数量），默认1e-8
+	QuoteTolerance float64       // 允许的计价资产偏差，订单不记录手续费，需要覆盖手续费的影响，默认1e-8
+}
+
+// ReconcileReport 是一个交易对的对账结果。
+type ReconcileReport struct {
+	Pair          string        // 交易对
+	Imported      []model.Order // 从交易所导入的订单
+	Vanished      []model.Order // 在交易所中消失的订单
+	Errors        []error       // 查询订单失败的错误，对应的订单保持原状态，等待下一次对账
+	Expected      float64       // 根据本地订单推算的持仓数量
+	Actual        float64       // 交易所返回的持仓数量
+	ExpectedQuote float64       // 根据本地订单推算的计价资产数量，包含所有同计价资产的交易对
+	ActualQuote   float64       // 交易所返回的计价资产数量
+}
+
+// Drift 返回交易所持仓与本地推算持仓之间的偏差。
+func (r ReconcileReport) Drift() float64 {
+	return r.Actual - r.Expected
+}
+
+// QuoteDrift 返回交易所计价资产与本地推算数量之间的偏差。
+func (r ReconcileReport) QuoteDrift() float64 {
+	return r.ActualQuote - r.ExpectedQuote
+}
+
+// reconcileSnapshot 是对账前从交易所拉取的数据。拉取时不持有Controller的锁，避免REST请求阻塞下单和订单更新。
+type reconcileSnapshot struct {
+	pair      string
+	orders    []model.Order  // 交易所最近的订单
+	missing   map[int64]bool // 交易所确认不存在的订单，键是交易所订单ID
+	errors    []error        // 查询订单失败的错误
+	asset     float64        // 交易所返回的持仓数量
+	quote     float64        // 交易所返回的计价资产数量
+	quoteTick string         // 计价资产
+}
+
+// SetReconcile 设置对账器，需要在Start之前调用。
+func (c *Controller) SetReconcile(config ReconcileConfig) {
+	if config.Limit <= 0 {
+		config.Limit = defaultReconcileLimit
+	}
+	if config.Tolerance <= 0 {
+		config.Tolerance = defaultReconcileTolerance
+	}
+	if config.QuoteTolerance <= 0 {
+		config.QuoteTolerance = defaultReconcileTolerance
+	}
+	c.reconcile = config
+}
+
+// Reconcile 将交易所的订单和余额与本地存储对账，返回每个交易对的对账结果。
+// 第一次对账时记录交易所持仓与本地订单的差值作为基准，之后只报告相对基准的偏差。
+func (c *Controller) Reconcile() ([]ReconcileReport, error) {
+	lister, ok := c.exchange.(service.OrderLister)
+	if !ok {
+		return nil, ErrReconcileUnsupported
+	}
+
+	// 先在锁外拉取交易所数据，再加锁与本地存储比较
+	snapshots := make([]reconcileSnapshot, 0, len(c.reconcile.Pairs))
+	for _, pair := range c.reconcile.Pairs {
+		snapshot, err := c.fetchReconcile(lister, pair)
+		if err != nil {
+			return nil, fmt.Errorf("reconcile %s: %w", pair, err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	reports := make([]ReconcileReport, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		report, err := c.reconcilePair(snapshot)
+		if err != nil {
+			return reports, fmt.Errorf("reconcile %s: %w", snapshot.pair, err)
+		}
+		reports = append(reports, report)
+	}
+
+	if err := c.checkQuoteDrift(reports, snapshots); err != nil {
+		return reports, fmt.Errorf("reconcile: %w", err)
+	}
+
+	return reports, nil
+}
+
+// fetchReconcile 从交易所拉取单个交易对的订单和余额，并查询本地挂单中不在订单列表里的订单。
+// 只有交易所明确返回ErrOrderNotFound的订单才会被记为不存在，其他错误记录下来，订单保持原状态。
+func (c *Controller) fetchReconcile(lister service.OrderLister, pair string) (reconcileSnapshot, error) {
+	_, quoteTick := exchange.SplitAssetQuote(pair)
+	snapshot := reconcileSnapshot{pair: pair, missing: make(map[int64]bool), quoteTick: quoteTick}
+
+	var err error
+	snapshot.orders, err = lister.Orders(pair, c.reconcile.Limit)
+	if err != nil {
+		return snapshot, err
+	}
+
+	stored, err := c.storage.Orders(storage.WithPair(pair))
+	if err != nil {
+		return snapshot, err
+	}
+
+	listed := make(map[int64]bool, len(snapshot.orders))
+	for _, excOrder := range snapshot.orders {
+		listed[excOrder.ExchangeID] = true
+	}
+
+	for _, order := range stored {
+		if !isPending(order.Status) || listed[order.ExchangeID] {
+			continue
+		}
+
+		// 订单可能只是不在最近的列表中，查询得到的订单交给updateOrders更新状态
+		_, err := c.exchange.Order(order.Pair, order.ExchangeID)
+		switch {
+		case err == nil:
+		case errors.Is(err, exchange.ErrOrderNotFound):
+			snapshot.missing[order.ExchangeID] = true
+		default:
+			snapshot.errors = append(snapshot.errors, fmt.Errorf("order %d: %w", order.ExchangeID, err))
+		}
+	}
+
+	snapshot.asset, snapshot.quote, err = c.exchange.Position(pair)
+	if err != nil {
+		return snapshot, err
+	}
+
+	return snapshot, nil
+}
+
+// reconcilePair 用拉取到的交易所数据对单个交易对进行对账，调用方需要持有c.mtx。
+func (c *Controller) reconcilePair(snapshot reconcileSnapshot) (ReconcileReport, error) {
+	report := ReconcileReport{Pair: snapshot.pair, Errors: snapshot.errors}
+
+	// 拉取数据期间本地可能有新订单，重新读取存储
+	stored, err := c.storage.Orders(storage.WithPair(snapshot.pair))
+	if err != nil {
+		return report, err
+	}
+
+	known := make(map[int64]bool, len(stored))
+	for _, order := range stored {
+		known[order.ExchangeID] = true
+	}
+
+	// 导入本地不存在的订单
+	for _, excOrder := range snapshot.orders {
+		if known[excOrder.ExchangeID] {
+			continue
+		}
+
+		excOrder.ID = 0
+		if err := c.storage.CreateOrder(&excOrder); err != nil {
+			return report, err
+		}
+		log.Infof("[RECONCILE] Imported order %s", excOrder)
+		report.Imported = append(report.Imported, excOrder)
+	}
+
+	// 标记在交易所中消失的挂单
+	for _, order := range stored {
+		if !isPending(order.Status) || !snapshot.missing[order.ExchangeID] {
+			continue
+		}
+
+		order.Status = model.OrderStatusTypeVanished
+		if err := c.storage.UpdateOrder(order); err != nil {
+			return report, err
+		}
+		log.Warnf("[RECONCILE] Order vanished %s", order)
+		report.Vanished = append(report.Vanished, *order)
+	}
+
+	for _, err := range report.Errors {
+		c.notifyError(fmt.Errorf("reconcile %s: %w", snapshot.pair, err))
+	}
+
+	if err := c.checkDrift(&report, snapshot.asset); err != nil {
+		return report, err
+	}
+
+	if len(report.Imported) > 0 || len(report.Vanished) > 0 {
+		c.notify(fmt.Sprintf("[RECONCILE] %s: %d orders imported, %d orders vanished",
+			snapshot.pair, len(report.Imported), len(report.Vanished)))
+	}
+
+	return report, nil
+}
+
+// netFilled 返回交易对已成交订单的基础资产和计价资产净变化。
+func (c *Controller) netFilled(pair string) (asset, quote float64, err error) {
+	filled, err := c.storage.Orders(storage.WithPair(pair), storage.WithStatus(model.OrderStatusTypeFilled))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, order := range filled {
+		if order.Side == model.SideTypeBuy {
+			asset += order.Quantity
+			quote -= order.Quantity * order.Price
+		} else {
+			asset -= order.Quantity
+			quote += order.Quantity * order.Price
+		}
+	}
+	return asset, quote, nil
+}
+
+// checkDrift 比较交易所持仓与本地已成交订单推算的持仓，偏差超过容忍度时通知并更新基准。
+func (c *Controller) checkDrift(report *ReconcileReport, actual float64) error {
+	net, _, err := c.netFilled(report.Pair)
+	if err != nil {
+		return err
+	}
+
+	// 第一次对账时记录基准，账户原有的持仓不算作偏差
+	baseline, ok := c.reconcileBaseline[report.Pair]
+	if !ok {
+		baseline = actual - net
+		c.reconcileBaseline[report.Pair] = baseline
+	}
+
+	report.Actual = actual
+	report.Expected = baseline + net
+
+	if math.Abs(report.Drift()) > c.reconcile.Tolerance {
+		c.notify(fmt.Sprintf("[RECONCILE] Balance drift for %s: exchange %f, expected %f (%+f)",
+			report.Pair, report.Actual, report.Expected, report.Drift()))
+		// 偏差已经报告过，以当前持仓作为新的基准，避免重复通知
+		c.reconcileBaseline[report.Pair] = actual - net
+	}
+
+	return nil
+}
+
+// checkQuoteDrift 按计价资产汇总所有交易对已成交订单的资金变化，与交易所的计价资产余额比较。
+// 计价资产由多个交易对共用，不能按交易对单独比较。
+func (c *Controller) checkQuoteDrift(reports []ReconcileReport, snapshots []reconcileSnapshot) error {
+	actual := make(map[string]float64)
+	net := make(map[string]float64)
+	var quotes []string
+	for _, snapshot := range snapshots {
+		_, quote, err := c.netFilled(snapshot.pair)
+		if err != nil {
+			return err
+		}
+		if _, ok := actual[snapshot.quoteTick]; !ok {
+			quotes = append(quotes, snapshot.quoteTick)
+		}
+		actual[snapshot.quoteTick] = snapshot.quote
+		net[snapshot.quoteTick] += quote
+	}
+
+	expected := make(map[string]float64, len(quotes))
+	for _, quoteTick := range quotes {
+		baseline, ok := c.reconcileQuoteBaseline[quoteTick]
+		if !ok {
+			baseline = actual[quoteTick] - net[quoteTick]
+			c.reconcileQuoteBaseline[quoteTick] = baseline
+		}
+		expected[quoteTick] = baseline + net[quoteTick]
+
+		drift := actual[quoteTick] - expected[quoteTick]
+		if math.Abs(drift) > c.reconcile.QuoteTolerance {
+			c.notify(fmt.Sprintf("[RECONCILE] Balance drift for %s: exchange %f, expected %f (%+f)",
+				quoteTick, actual[quoteTick], expected[quoteTick], drift))
+			c.reconcileQuoteBaseline[quoteTick] = actual[quoteTick] - net[quoteTick]
+		}
+	}
+
+	for i := range reports {
+		quoteTick := snapshots[i].quoteTick
+		reports[i].ActualQuote = actual[quoteTick]
+		reports[i].ExpectedQuote = expected[quoteTick]
+	}
+	return nil
+}
+
+// newReconcileTicker 创建对账定时器，没有配置对账或者交易所不支持列出订单时返回nil。
+func (c *Controller) newReconcileTicker() *time.Ticker {
+	if c.reconcile.Interval <= 0 || len(c.reconcile.Pairs) == 0 {
+		return nil
+	}
+	if _, ok := c.exchange.(service.OrderLister); !ok {
+		log.Warnf("[RECONCILE] disabled: %v", ErrReconcileUnsupported)
+		return nil
+	}
+	return time.NewTicker(c.reconcile.Interval)
+}
+
+// runReconcile 由定时器调用，执行一次对账并记录错误。
+func (c *Controller) runReconcile() {
+	if _, err := c.Reconcile(); err != nil {
+		c.notifyError(err)
+	}
+}
+
+// isPending 判断订单是否仍在交易所挂单中。
+func isPending(status model.OrderStatusType) bool {
+	return status == model.OrderStatusTypeNew ||
+		status == model.OrderStatusTypePartiallyFilled ||
+		status == model.OrderStatusTypePendingCancel
+}
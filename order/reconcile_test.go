@@ -0,0 +1,137 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/storage"
+)
+
+func TestController_Reconcile(t *testing.T) {
+	db, err := storage.FromMemory()
+	require.NoError(t, err)
+	ctx := context.Background()
+	wallet := exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 3000))
+	controller := NewController(ctx, wallet, db, NewOrderFeed())
+	controller.SetReconcile(ReconcileConfig{Pairs: []string{"BTCUSDT"}})
+
+	candle := model.Candle{Time: time.Now(), Pair: "BTCUSDT", Close: 1000, Low: 1000}
+	wallet.OnCandle(candle)
+	controller.OnCandle(candle)
+
+	order, err := controller.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 1, 900)
+	require.NoError(t, err)
+
+	// 绕过Controller直接在交易所下单，相当于手动下单
+	manual, err := wallet.CreateOrderLimit(model.SideTypeBuy, "BTCUSDT", 0.5, 800)
+	require.NoError(t, err)
+	_, err = wallet.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 0.5)
+	require.NoError(t, err)
+
+	// 本地有记录，但交易所中不存在的订单
+	ghost := &model.Order{ExchangeID: 999, Pair: "BTCUSDT", Side: model.SideTypeBuy,
+		Type: model.OrderTypeLimit, Status: model.OrderStatusTypeNew, Price: 700, Quantity: 1}
+	require.NoError(t, db.CreateOrder(ghost))
+
+	t.Run("import unknown and mark vanished", func(t *testing.T) {
+		reports, err := controller.Reconcile()
+		require.NoError(t, err)
+		require.Len(t, reports, 1)
+
+		report := reports[0]
+		require.Len(t, report.Imported, 2)
+		assert.Equal(t, manual.ExchangeID, report.Imported[0].ExchangeID)
+		require.Len(t, report.Vanished, 1)
+		assert.Equal(t, ghost.ID, report.Vanished[0].ID)
+		assert.Equal(t, 0.0, report.Drift())
+
+		orders, err := db.Orders(storage.WithStatus(model.OrderStatusTypeVanished))
+		require.NoError(t, err)
+		require.Len(t, orders, 1)
+
+		pending, err := db.Orders(storage.WithStatus(model.OrderStatusTypeNew))
+		require.NoError(t, err)
+		require.Len(t, pending, 2)
+		assert.Equal(t, order.ExchangeID, pending[0].ExchangeID)
+		assert.Equal(t, manual.ExchangeID, pending[1].ExchangeID)
+
+		// 再次对账不会重复导入
+		reports, err = controller.Reconcile()
+		require.NoError(t, err)
+		assert.Empty(t, reports[0].Imported)
+		assert.Empty(t, reports[0].Vanished)
+	})
+
+	t.Run("balance drift", func(t *testing.T) {
+		_, err := wallet.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 0.25)
+		require.NoError(t, err)
+		_, err = wallet.CreateOrderMarket(model.SideTypeBuy, "BTCUSDT", 0.1)
+		require.NoError(t, err)
+
+		// 只拉取最近一个订单，前一个手动订单没有被导入，持仓出现偏差
+		controller.SetReconcile(ReconcileConfig{Pairs: []string{"BTCUSDT"}, Limit: 1})
+		reports, err := controller.Reconcile()
+		require.NoError(t, err)
+		require.Len(t, reports[0].Imported, 1)
+		assert.InDelta(t, 0.25, reports[0].Drift(), 1e-9)
+		assert.InDelta(t, -250, reports[0].QuoteDrift(), 1e-9)
+
+		// 偏差已经报告过，之后以新的持仓为基准
+		reports, err = controller.Reconcile()
+		require.NoError(t, err)
+		assert.InDelta(t, 0.0, reports[0].Drift(), 1e-9)
+		assert.InDelta(t, 0.0, reports[0].QuoteDrift(), 1e-9)
+	})
+}
+
+// unreachableWallet 查询指定订单时返回网络错误，模拟交易所暂时不可用
+type unreachableWallet struct {
+	*exchange.PaperWallet
+	unreachable int64
+}
+
+func (w unreachableWallet) Order(pair string, id int64) (model.Order, error) {
+	if id == w.unreachable {
+		return model.Order{}, errors.New("connection reset")
+	}
+	return w.PaperWallet.Order(pair, id)
+}
+
+func TestController_ReconcileOrderError(t *testing.T) {
+	db, err := storage.FromMemory()
+	require.NoError(t, err)
+	ctx := context.Background()
+	wallet := exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 3000))
+	controller := NewController(ctx, unreachableWallet{PaperWallet: wallet, unreachable: 999}, db, NewOrderFeed())
+	controller.SetReconcile(ReconcileConfig{Pairs: []string{"BTCUSDT"}})
+	wallet.OnCandle(model.Candle{Time: time.Now(), Pair: "BTCUSDT", Close: 1000, Low: 1000})
+
+	unknown := &model.Order{ExchangeID: 999, Pair: "BTCUSDT", Side: model.SideTypeBuy,
+		Type: model.OrderTypeLimit, Status: model.OrderStatusTypeNew, Price: 700, Quantity: 1}
+	ghost := &model.Order{ExchangeID: 998, Pair: "BTCUSDT", Side: model.SideTypeBuy,
+		Type: model.OrderTypeLimit, Status: model.OrderStatusTypeNew, Price: 700, Quantity: 1}
+	require.NoError(t, db.CreateOrder(unknown))
+	require.NoError(t, db.CreateOrder(ghost))
+
+	reports, err := controller.Reconcile()
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+
+	// 只有交易所确认不存在的订单被标记为消失，查询失败的订单保持原状态
+	require.Len(t, reports[0].Vanished, 1)
+	assert.Equal(t, ghost.ID, reports[0].Vanished[0].ID)
+	require.Len(t, reports[0].Errors, 1)
+	assert.ErrorContains(t, reports[0].Errors[0], "connection reset")
+
+	pending, err := db.Orders(storage.WithStatus(model.OrderStatusTypeNew))
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, unknown.ID, pending[0].ID)
+}
@@ -0,0 +1,398 @@
+/*
+Package parquet 提供K线数据和回测结果的Parquet格式导入导出。
+
+读取时通过Columns把Parquet文件中的列映射到K线字段，其余的数值列和CSV的额外表头一样保存到Candle.Metadata，
+读取后的K线与CSV文件一样由exchange.CSVFeed提供给回测。写入的K线文件使用与CSV相同的列名，
+回测的交易明细和权益曲线也可以导出为Parquet，方便在数据分析工具中使用。
+*/
+package parquet
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	pq "github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/types"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+// parallel 读写Parquet文件时并发处理的数量
+const parallel = 4
+
+// Columns 是Parquet文件中K线字段对应的列名称，为空的字段使用默认的列名，列名匹配不区分大小写
+type Columns struct {
+	Time   string // Time 开盘时间列，默认为time
+	Open   string // Open 开盘价列，默认为open
+	Close  string // Close 收盘价列，默认为close
+	Low    string // Low 最低价列，默认为low
+	High   string // High 最高价列，默认为high
+	Volume string // Volume 成交量列，默认为volume
+
+	// TimeUnit 时间列是普通整数或浮点数时的单位，默认为秒。TIMESTAMP、DATE、INT96和字符串类型的时间列会自动转换
+	TimeUnit time.Duration
+}
+
+// withDefaults 返回填充了默认列名的列映射
+func (c Columns) withDefaults() Columns {
+	defaults := func(value *string, name string) {
+		if *value == "" {
+			*value = name
+		}
+	}
+	defaults(&c.Time, "time")
+	defaults(&c.Open, "open")
+	defaults(&c.Close, "close")
+	defaults(&c.Low, "low")
+	defaults(&c.High, "high")
+	defaults(&c.Volume, "volume")
+	if c.TimeUnit <= 0 {
+		c.TimeUnit = time.Second
+	}
+	return c
+}
+
+// PairFeed 是一个交易对的Parquet文件配置，File是Parquet文件的路径
+type PairFeed struct {
+	exchange.PairFeed
+	Columns Columns // Columns 列名映射
+}
+
+// NewFeed 从Parquet文件创建与exchange.NewCSVFeed相同的数据源，K线会转换为Heikin Ashi并重采样到targetTimeframe
+func NewFeed(targetTimeframe string, feeds ...PairFeed) (*exchange.CSVFeed, error) {
+	feed, err := exchange.NewCSVFeed(targetTimeframe)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pairFeed := range feeds {
+		candles, err := ReadCandles(pairFeed.File, pairFeed.Pair, pairFeed.Columns)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", pairFeed.File, err)
+		}
+		if err := feed.AddCandles(pairFeed.PairFeed, targetTimeframe, candles); err != nil {
+			return nil, err
+		}
+	}
+	return feed, nil
+}
+
+// column 是Parquet文件中的一个顶层列
+type column struct {
+	name    string
+	path    string
+	element *pq.SchemaElement
+}
+
+// ReadCandles 读取Parquet文件中的所有K线，K线按文件中的顺序返回
+func ReadCandles(path, pair string, columns Columns) ([]model.Candle, error) {
+	file, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pr, err := reader.NewParquetColumnReader(file, parallel)
+	if err != nil {
+		return nil, err
+	}
+	defer pr.ReadStop()
+
+	// 只读取根节点下的基本类型列，嵌套的列会被忽略
+	var fileColumns []column
+	handler := pr.SchemaHandler
+	for i := 1; i < len(handler.SchemaElements); i++ {
+		element := handler.SchemaElements[i]
+		path := handler.IndexMap[int32(i)]
+		if element.GetNumChildren() > 0 || strings.Count(path, "\x01") != 1 {
+			continue
+		}
+		fileColumns = append(fileColumns, column{name: handler.GetExName(i), path: path, element: element})
+	}
+
+	find := func(name string) (column, error) {
+		for _, c := range fileColumns {
+			if c.name == name {
+				return c, nil
+			}
+		}
+		for _, c := range fileColumns {
+			if strings.EqualFold(c.name, name) {
+				return c, nil
+			}
+		}
+		return column{}, fmt.Errorf("column %s not found", name)
+	}
+
+	columns = columns.withDefaults()
+	rows := pr.GetNumRows()
+	read := func(c column) ([]interface{}, error) {
+		values, _, _, err := pr.ReadColumnByPath(c.path, rows)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", c.name, err)
+		}
+		if int64(len(values)) != rows {
+			return nil, fmt.Errorf("column %s: expected %d values, got %d", c.name, rows, len(values))
+		}
+		return values, nil
+	}
+
+	timeColumn, err := find(columns.Time)
+	if err != nil {
+		return nil, err
+	}
+	timeValues, err := read(timeColumn)
+	if err != nil {
+		return nil, err
+	}
+
+	candles := make([]model.Candle, rows)
+	for i, value := range timeValues {
+		t, err := toTime(value, timeColumn.element, columns.TimeUnit)
+		if err != nil {
+			return nil, fmt.Errorf("column %s row %d: %w", timeColumn.name, i, err)
+		}
+		candles[i] = model.Candle{Pair: pair, Time: t, UpdatedAt: t, Complete: true}
+	}
+
+	fields := []struct {
+		name  string
+		value func(*model.Candle) *float64
+	}{
+		{columns.Open, func(c *model.Candle) *float64 { return &c.Open }},
+		{columns.Close, func(c *model.Candle) *float64 { return &c.Close }},
+		{columns.Low, func(c *model.Candle) *float64 { return &c.Low }},
+		{columns.High, func(c *model.Candle) *float64 { return &c.High }},
+		{columns.Volume, func(c *model.Candle) *float64 { return &c.Volume }},
+	}
+	used := map[string]bool{timeColumn.name: true}
+	for _, field := range fields {
+		c, err := find(field.name)
+		if err != nil {
+			return nil, err
+		}
+		used[c.name] = true
+
+		values, err := read(c)
+		if err != nil {
+			return nil, err
+		}
+		for i, value := range values {
+			if *field.value(&candles[i]), err = toFloat(value, c.element); err != nil {
+				return nil, fmt.Errorf("column %s row %d: %w", c.name, i, err)
+			}
+		}
+	}
+
+	// 其余的数值列保存到Metadata，与CSV文件的额外表头一致
+	for _, c := range fileColumns {
+		if used[c.name] || !isNumeric(c.element) {
+			continue
+		}
+
+		values, err := read(c)
+		if err != nil {
+			return nil, err
+		}
+		for i, value := range values {
+			number, err := toFloat(value, c.element)
+			if err != nil {
+				return nil, fmt.Errorf("column %s row %d: %w", c.name, i, err)
+			}
+			if candles[i].Metadata == nil {
+				candles[i].Metadata = make(map[string]float64)
+			}
+			candles[i].Metadata[c.name] = number
+		}
+	}
+
+	return candles, nil
+}
+
+// isNumeric 判断列是否可以转换为浮点数
+func isNumeric(element *pq.SchemaElement) bool {
+	if element.ConvertedType != nil || element.LogicalType != nil {
+		return element.GetConvertedType() == pq.ConvertedType_DECIMAL ||
+			element.GetConvertedType() == pq.ConvertedType_INT_8 ||
+			element.GetConvertedType() == pq.ConvertedType_INT_16 ||
+			element.GetConvertedType() == pq.ConvertedType_INT_32 ||
+			element.GetConvertedType() == pq.ConvertedType_INT_64 ||
+			element.GetConvertedType() == pq.ConvertedType_UINT_8 ||
+			element.GetConvertedType() == pq.ConvertedType_UINT_16 ||
+			element.GetConvertedType() == pq.ConvertedType_UINT_32 ||
+			element.GetConvertedType() == pq.ConvertedType_UINT_64 ||
+			(element.LogicalType != nil && (element.LogicalType.IsSetINTEGER() || element.LogicalType.IsSetDECIMAL()))
+	}
+
+	switch element.GetType() {
+	case pq.Type_INT32, pq.Type_INT64, pq.Type_FLOAT, pq.Type_DOUBLE:
+		return true
+	}
+	return false
+}
+
+// toFloat 把数值列的值转换为浮点数，DECIMAL类型的整数按小数位数转换
+func toFloat(value interface{}, element *pq.SchemaElement) (float64, error) {
+	var number float64
+	switch v := value.(type) {
+	case nil:
+		return 0, fmt.Errorf("null value")
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int32:
+		number = float64(v)
+	case int64:
+		number = float64(v)
+	default:
+		return 0, fmt.Errorf("unsupported value type %T", value)
+	}
+
+	if element.GetConvertedType() == pq.ConvertedType_DECIMAL ||
+		(element.LogicalType != nil && element.LogicalType.IsSetDECIMAL()) {
+		number /= math.Pow10(int(element.GetScale()))
+	}
+	return number, nil
+}
+
+// toTime 根据列的类型把时间列的值转换为UTC时间
+func toTime(value interface{}, element *pq.SchemaElement, unit time.Duration) (time.Time, error) {
+	switch v := value.(type) {
+	case nil:
+		return time.Time{}, fmt.Errorf("null value")
+	case string:
+		if element.GetType() == pq.Type_INT96 {
+			return types.INT96ToTime(v).UTC(), nil
+		}
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t.UTC(), nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid time %q", v)
+	case int32:
+		if element.GetConvertedType() == pq.ConvertedType_DATE ||
+			(element.LogicalType != nil && element.LogicalType.IsSetDATE()) {
+			return time.Unix(int64(v)*24*60*60, 0).UTC(), nil
+		}
+		return time.Unix(0, int64(v)*int64(unit)).UTC(), nil
+	case int64:
+		if element.LogicalType != nil && element.LogicalType.IsSetTIMESTAMP() {
+			switch timestamp := element.LogicalType.GetTIMESTAMP(); {
+			case timestamp.Unit.IsSetMILLIS():
+				unit = time.Millisecond
+			case timestamp.Unit.IsSetMICROS():
+				unit = time.Microsecond
+			case timestamp.Unit.IsSetNANOS():
+				unit = time.Nanosecond
+			}
+		} else if element.GetConvertedType() == pq.ConvertedType_TIMESTAMP_MILLIS {
+			unit = time.Millisecond
+		} else if element.GetConvertedType() == pq.ConvertedType_TIMESTAMP_MICROS {
+			unit = time.Microsecond
+		}
+		return time.Unix(0, v*int64(unit)).UTC(), nil
+	case float64:
+		return time.Unix(0, int64(v*float64(unit))).UTC(), nil
+	case float32:
+		return time.Unix(0, int64(float64(v)*float64(unit))).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("unsupported time type %T", value)
+}
+
+/*
+CandleWriter 以Parquet格式写入K线，列与CSV文件相同：time（毫秒时间戳）、open、close、low、high、volume，
+之后是metadata中的附加列。写入完成后必须调用Close写入文件尾，Close不会关闭底层的io.Writer。
+*/
+type CandleWriter struct {
+	writer   *writer.CSVWriter
+	metadata []string
+}
+
+// NewCandleWriter 创建K线的Parquet写入器，metadata是需要保存的Candle.Metadata列名称
+func NewCandleWriter(w io.Writer, metadata ...string) (*CandleWriter, error) {
+	schema := []string{
+		"name=time, type=INT64, convertedtype=TIMESTAMP_MILLIS",
+		"name=open, type=DOUBLE",
+		"name=close, type=DOUBLE",
+		"name=low, type=DOUBLE",
+		"name=high, type=DOUBLE",
+		"name=volume, type=DOUBLE",
+	}
+	for _, name := range metadata {
+		if strings.ContainsAny(name, ",=") {
+			return nil, fmt.Errorf("invalid metadata column name: %s", name)
+		}
+		schema = append(schema, fmt.Sprintf("name=%s, type=DOUBLE", name))
+	}
+
+	pw, err := writer.NewCSVWriterFromWriter(schema, w, parallel)
+	if err != nil {
+		return nil, err
+	}
+	pw.CompressionType = pq.CompressionCodec_SNAPPY
+	return &CandleWriter{writer: pw, metadata: metadata}, nil
+}
+
+// Write 写入K线
+func (c *CandleWriter) Write(candles ...model.Candle) error {
+	for _, candle := range candles {
+		row := []interface{}{
+			types.TimeToTIMESTAMP_MILLIS(candle.Time, true),
+			candle.Open,
+			candle.Close,
+			candle.Low,
+			candle.High,
+			candle.Volume,
+		}
+		for _, name := range c.metadata {
+			row = append(row, candle.Metadata[name])
+		}
+		if err := c.writer.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close 写入剩余的数据和文件尾
+func (c *CandleWriter) Close() error {
+	return c.writer.WriteStop()
+}
+
+// WriteCandles 把K线写入Parquet文件
+func WriteCandles(path string, candles []model.Candle, metadata ...string) error {
+	return writeFile(path, func(w io.Writer) error {
+		writer, err := NewCandleWriter(w, metadata...)
+		if err != nil {
+			return err
+		}
+		if err := writer.Write(candles...); err != nil {
+			return err
+		}
+		return writer.Close()
+	})
+}
+
+// writeFile 创建文件并调用write写入数据
+func writeFile(path string, write func(io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,187 @@
+package parquet
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/types"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/order"
+)
+
+// writeStructs 按结构体标签写入测试用的Parquet文件
+func writeStructs(t *testing.T, path string, schema interface{}, rows ...interface{}) {
+	t.Helper()
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	pw, err := writer.NewParquetWriterFromWriter(file, schema, 1)
+	require.NoError(t, err)
+	for _, row := range rows {
+		require.NoError(t, pw.Write(row))
+	}
+	require.NoError(t, pw.WriteStop())
+}
+
+// readStructs 按结构体标签读取Parquet文件中的所有行
+func readStructs(t *testing.T, path string, rows interface{}, schema interface{}) {
+	t.Helper()
+	file, err := local.NewLocalFileReader(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	pr, err := reader.NewParquetReader(file, schema, 1)
+	require.NoError(t, err)
+	defer pr.ReadStop()
+	require.NoError(t, pr.Read(rows))
+}
+
+func TestFeed(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("same candles as csv feed", func(t *testing.T) {
+		csvFeed, err := exchange.NewCSVFeed("4h", exchange.PairFeed{
+			Pair:      "BTCUSDT",
+			File:      "../testdata/btc-1h.csv",
+			Timeframe: "1h",
+		})
+		require.NoError(t, err)
+
+		path := filepath.Join(dir, "btc-1h.parquet")
+		require.NoError(t, WriteCandles(path, csvFeed.CandlePairTimeFrame["BTCUSDT--1h"]))
+
+		feed, err := NewFeed("4h", PairFeed{PairFeed: exchange.PairFeed{
+			Pair:      "BTCUSDT",
+			File:      path,
+			Timeframe: "1h",
+		}})
+		require.NoError(t, err)
+		assert.Equal(t, csvFeed.CandlePairTimeFrame, feed.CandlePairTimeFrame)
+	})
+
+	t.Run("metadata", func(t *testing.T) {
+		csvFeed, err := exchange.NewCSVFeed("1d", exchange.PairFeed{
+			Pair:      "BTCUSDT",
+			File:      "../testdata/btc-1d-header.csv",
+			Timeframe: "1d",
+		})
+		require.NoError(t, err)
+		expected := csvFeed.CandlePairTimeFrame["BTCUSDT--1d"]
+
+		path := filepath.Join(dir, "btc-1d-header.parquet")
+		require.NoError(t, WriteCandles(path, expected, "trades", "lsr"))
+
+		candles, err := ReadCandles(path, "BTCUSDT", Columns{})
+		require.NoError(t, err)
+		assert.Equal(t, expected, candles)
+		assert.Equal(t, 1.1, candles[0].Metadata["lsr"])
+	})
+
+	t.Run("column mapping", func(t *testing.T) {
+		type row struct {
+			OpenTime int64   `parquet:"name=open_time, type=INT64, convertedtype=TIMESTAMP_MICROS"`
+			Symbol   string  `parquet:"name=symbol, type=BYTE_ARRAY, convertedtype=UTF8"`
+			Open     float64 `parquet:"name=Open, type=DOUBLE"`
+			High     float64 `parquet:"name=High, type=DOUBLE"`
+			Low      float32 `parquet:"name=Low, type=FLOAT"`
+			Close    int64   `parquet:"name=Close, type=INT64, convertedtype=DECIMAL, scale=2, precision=18"`
+			Volume   int64   `parquet:"name=base_volume, type=INT64"`
+			Trades   int32   `parquet:"name=trades, type=INT32"`
+		}
+
+		start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+		path := filepath.Join(dir, "mapped.parquet")
+		writeStructs(t, path, new(row),
+			row{types.TimeToTIMESTAMP_MICROS(start, true), "BTCUSDT", 1.5, 3, 0.5, 250, 10, 7},
+			row{types.TimeToTIMESTAMP_MICROS(start.Add(time.Hour), true), "BTCUSDT", 2.5, 4, 1.5, 325, 20, 8},
+		)
+
+		feed, err := NewFeed("1h", PairFeed{
+			PairFeed: exchange.PairFeed{Pair: "BTCUSDT", File: path, Timeframe: "1h"},
+			Columns:  Columns{Time: "open_time", Volume: "base_volume"},
+		})
+		require.NoError(t, err)
+
+		candles, err := feed.CandlesByPeriod(context.Background(), "BTCUSDT", "1h", start, start.Add(time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, []model.Candle{
+			{Pair: "BTCUSDT", Time: start, UpdatedAt: start, Open: 1.5, High: 3, Low: 0.5, Close: 2.5, Volume: 10,
+				Complete: true, Metadata: map[string]float64{"trades": 7}},
+			{Pair: "BTCUSDT", Time: start.Add(time.Hour), UpdatedAt: start.Add(time.Hour), Open: 2.5, High: 4,
+				Low: 1.5, Close: 3.25, Volume: 20, Complete: true, Metadata: map[string]float64{"trades": 8}},
+		}, candles)
+
+		_, err = ReadCandles(path, "BTCUSDT", Columns{})
+		require.ErrorContains(t, err, "column time not found")
+	})
+
+	t.Run("time formats", func(t *testing.T) {
+		type row struct {
+			Time   string  `parquet:"name=time, type=BYTE_ARRAY, convertedtype=UTF8"`
+			Millis int64   `parquet:"name=millis, type=INT64"`
+			Open   float64 `parquet:"name=open, type=DOUBLE"`
+			Close  float64 `parquet:"name=close, type=DOUBLE"`
+			Low    float64 `parquet:"name=low, type=DOUBLE"`
+			High   float64 `parquet:"name=high, type=DOUBLE"`
+			Volume float64 `parquet:"name=volume, type=DOUBLE"`
+		}
+
+		start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+		path := filepath.Join(dir, "times.parquet")
+		writeStructs(t, path, new(row), row{Time: "2022-01-01T00:00:00Z", Millis: start.UnixMilli()})
+
+		candles, err := ReadCandles(path, "BTCUSDT", Columns{})
+		require.NoError(t, err)
+		assert.Equal(t, start, candles[0].Time)
+
+		candles, err = ReadCandles(path, "BTCUSDT", Columns{Time: "millis", TimeUnit: time.Millisecond})
+		require.NoError(t, err)
+		assert.Equal(t, start, candles[0].Time)
+	})
+}
+
+func TestWriteResults(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	path := filepath.Join(dir, "trades.parquet")
+	require.NoError(t, WriteTrades(path, []order.Result{
+		{Pair: "BTCUSDT", Side: model.SideTypeBuy, ProfitPercent: 0.1, ProfitValue: 10, Duration: time.Hour,
+			CreatedAt: start},
+		{Pair: "ETHUSDT", Side: model.SideTypeSell, PositionSide: model.PositionSideTypeShort, ProfitPercent: -0.05,
+			ProfitValue: -5, Duration: 2 * time.Hour, CreatedAt: start.Add(time.Hour)},
+	}))
+
+	trades := make([]tradeRow, 2)
+	readStructs(t, path, &trades, new(tradeRow))
+	assert.Equal(t, []tradeRow{
+		{CreatedAt: start.UnixMilli(), Pair: "BTCUSDT", Side: "BUY", ProfitPercent: 0.1, ProfitValue: 10,
+			Duration: 3600},
+		{CreatedAt: start.Add(time.Hour).UnixMilli(), Pair: "ETHUSDT", Side: "SELL", PositionSide: "SHORT",
+			ProfitPercent: -0.05, ProfitValue: -5, Duration: 7200},
+	}, trades)
+
+	path = filepath.Join(dir, "equity.parquet")
+	require.NoError(t, WriteEquity(path, []exchange.AssetValue{
+		{Time: start, Value: 1000},
+		{Time: start.Add(time.Hour), Value: 1010},
+	}))
+
+	equity := make([]equityRow, 2)
+	readStructs(t, path, &equity, new(equityRow))
+	assert.Equal(t, []equityRow{
+		{Time: start.UnixMilli(), Value: 1000},
+		{Time: start.Add(time.Hour).UnixMilli(), Value: 1010},
+	}, equity)
+}
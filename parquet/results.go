@@ -0,0 +1,73 @@
+package parquet
+
+import (
+	"io"
+
+	"github.com/xitongsys/parquet-go/types"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/order"
+)
+
+// tradeRow 是交易明细文件中的一行
+type tradeRow struct {
+	CreatedAt     int64   `parquet:"name=created_at, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Pair          string  `parquet:"name=pair, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Side          string  `parquet:"name=side, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	PositionSide  string  `parquet:"name=position_side, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	ProfitPercent float64 `parquet:"name=profit_percent, type=DOUBLE"`
+	ProfitValue   float64 `parquet:"name=profit_value, type=DOUBLE"`
+	Duration      int64   `parquet:"name=duration_seconds, type=INT64"`
+}
+
+// equityRow 是权益曲线文件中的一行
+type equityRow struct {
+	Time  int64   `parquet:"name=time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Value float64 `parquet:"name=value, type=DOUBLE"`
+}
+
+// WriteTrades 把回测的交易明细写入Parquet文件，trades通常来自Controller.Trades
+func WriteTrades(path string, trades []order.Result) error {
+	rows := make([]interface{}, 0, len(trades))
+	for _, trade := range trades {
+		rows = append(rows, tradeRow{
+			CreatedAt:     types.TimeToTIMESTAMP_MILLIS(trade.CreatedAt, true),
+			Pair:          trade.Pair,
+			Side:          string(trade.Side),
+			PositionSide:  string(trade.PositionSide),
+			ProfitPercent: trade.ProfitPercent,
+			ProfitValue:   trade.ProfitValue,
+			Duration:      int64(trade.Duration.Seconds()),
+		})
+	}
+	return writeRows(path, new(tradeRow), rows)
+}
+
+// WriteEquity 把权益曲线写入Parquet文件，values通常来自PaperWallet.EquityValues
+func WriteEquity(path string, values []exchange.AssetValue) error {
+	rows := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		rows = append(rows, equityRow{
+			Time:  types.TimeToTIMESTAMP_MILLIS(value.Time, true),
+			Value: value.Value,
+		})
+	}
+	return writeRows(path, new(equityRow), rows)
+}
+
+// writeRows 按schema的结构体标签写入所有行
+func writeRows(path string, schema interface{}, rows []interface{}) error {
+	return writeFile(path, func(w io.Writer) error {
+		pw, err := writer.NewParquetWriterFromWriter(w, schema, parallel)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := pw.Write(row); err != nil {
+				return err
+			}
+		}
+		return pw.WriteStop()
+	})
+}
@@ -224,6 +224,39 @@ if (data.max_drawdown) {
 }
 
 
+// 有持仓时在蜡烛图上画出开仓均价和强平价的水平虚线
+if (data.position) {
+  const levels = [
+    { name: `${data.position.side} Entry`, price: data.position.entry_price, color: "blue" },
+    { name: "Liquidation", price: data.position.liquidation_price, color: "orange" },
+  ];
+  levels
+    .filter((level) => level.price > 0)
+    .forEach((level) => {
+      shapes.push({
+        type: "line",
+        xref: "paper", // 横跨整个图表宽度
+        yref: "y2", // 与蜡烛图使用同一个y轴
+        x0: 0,
+        x1: 1,
+        y0: level.price,
+        y1: level.price,
+        line: { color: level.color, width: 1, dash: "dash" },
+      });
+      annotations.push({
+        x: 1,
+        y: level.price,
+        xref: "paper",
+        yref: "y2",
+        xanchor: "right",
+        yanchor: "bottom",
+        text: `${level.name} ${level.price}`,
+        showarrow: false,
+        font: { size: 10, color: level.color },
+      });
+    });
+}
+
       // 构建买入和卖出点数据
       // 在points数组中筛选出所有标记为卖出的交易点  (p)是数组中的每一项
       const sellPoints = points.filter((p) => p.side === SELL_SIDE);
@@ -15,6 +15,7 @@ import (
 
 	"github.com/rodrigo-brito/ninjabot/exchange"
 	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
 	"github.com/rodrigo-brito/ninjabot/strategy"
 
 	"github.com/StudioSol/set"
@@ -41,6 +42,7 @@ type Chart struct {
 	ordersIDsByPair map[string]*set.LinkedHashSetINT64 //ordersIDsByPair这个结构确实就像是一个装着各种订单编号的盒子集合键（key）是货币对，比如BTC/USD或 ETH/USD，值（value）是指向 set.LinkedHashSetINT64 类型的指针，保证顺序是先后顺序，被添加的先后顺序排列，从最早添加的订单到最后添加的订单，这是一个特殊的集合，用来存储和管理订单编号。
 	orderByID       map[int64]model.Order              // 一个映射表，按订单ID存储订单的详细信息。model.Order是一个结构体，包含了订单的所有相关信息，如订单类型、价格、数量等。
 	indicators      []Indicator                        // 一个Indicator接口类型的切片（类似于数组但是长度可变的数据结构），用来存储图表将使用的各种指标。指标是交易分析中的工具，用来帮助交易者做出决策。
+	broker          service.Broker                     // 用于查询当前持仓，在图表上画出开仓均价和强平价，没有设置时使用paperWallet。
 	paperWallet     *exchange.PaperWallet              //：一个指向exchange.PaperWallet类型的指针，PaperWallet模拟了一个钱包，可以用来测试交易策略而无需实际资金交易。
 	scriptContent   string                             // 字符串类型，存储图表相关的JavaScript脚本内容。这些脚本在客户端执行，用于动态显示或更新图表。
 	indexHTML       *template.Template                 // 一个template.Template类型的指针，它指向一个HTML模板，用于生成显示图表的网页。
@@ -541,6 +543,8 @@ func (c *Chart) handleData(w http.ResponseWriter, r *http.Request) {
 
 	// 分割货币对为资产和报价货币。
 	asset, quote := exchange.SplitAssetQuote(pair)
+	// 获取当前持仓，用于画出开仓均价和强平价。
+	position := c.positionByPair(pair)
 	// 获取资产值和权益值的时间序列数据。
 	assetValues, equityValues := c.equityValuesByPair(pair)
 	// 编码并发送JSON响应给客户端，包含了货币对的各种数据信息。
@@ -554,6 +558,7 @@ func (c *Chart) handleData(w http.ResponseWriter, r *http.Request) {
 		"quote":         quote,                    // 报价货币
 		"asset":         asset,                    // 资产货币
 		"max_drawdown":  maxDrawdown,              // 最大回撤信息
+		"position":      position,                 // 当前持仓，没有持仓时为null
 	})
 	// 如果在编码JSON时遇到错误，则记录错误信息。
 	if err != nil {
@@ -561,6 +566,27 @@ func (c *Chart) handleData(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// positionByPair 返回交易对当前的持仓详情，没有持仓、没有设置broker或者查询失败时返回nil。
+func (c *Chart) positionByPair(pair string) *model.PositionInfo {
+	broker := c.broker
+	if broker == nil && c.paperWallet != nil {
+		broker = c.paperWallet
+	}
+	if broker == nil {
+		return nil
+	}
+
+	position, err := broker.PositionInfo(pair)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	if !position.Open() {
+		return nil
+	}
+	return &position
+}
+
 // handleTradingHistoryData 是 Chart 类型的方法，用于处理交易历史数据的 HTTP 请求。
 func (c *Chart) handleTradingHistoryData(w http.ResponseWriter, r *http.Request) {
 	// 从 HTTP 请求的查询参数中获取货币对。
@@ -693,6 +719,14 @@ func WithPaperWallet(paperWallet *exchange.PaperWallet) Option {
 	}
 }
 
+// WithBroker 创建一个配置选项，用于设置查询持仓的Broker（例如交易所或者order.Controller），
+// 图表会画出当前持仓的开仓均价和强平价。
+func WithBroker(broker service.Broker) Option {
+	return func(chart *Chart) {
+		chart.broker = broker
+	}
+}
+
 // WithDebug 创建一个配置选项，开启Chart的调试模式。
 // 在调试模式下，Chart可能会提供更详细的日志输出，或者禁用某些性能优化，以便于开发和调试。
 func WithDebug() Option {
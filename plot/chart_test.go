@@ -1,6 +1,7 @@
 package plot
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -161,6 +162,23 @@ func TestChart_WithPaperWallet(t *testing.T) {
 	require.Equal(t, wallet, c.paperWallet)
 }
 
+func TestChart_PositionByPair(t *testing.T) {
+	wallet := exchange.NewPaperWallet(context.Background(), "USDT", exchange.WithPaperAsset("USDT", 1000))
+	c, err := NewChart(WithBroker(wallet))
+	require.NoErrorf(t, err, "error when initial chart")
+	require.Nil(t, c.positionByPair("BTCUSDT"))
+
+	wallet.OnCandle(model.Candle{Pair: "BTCUSDT", Close: 100})
+	_, err = wallet.CreateOrderMarket(model.SideTypeSell, "BTCUSDT", 1)
+	require.NoError(t, err)
+
+	position := c.positionByPair("BTCUSDT")
+	require.NotNil(t, position)
+	require.Equal(t, model.PositionSideTypeShort, position.Side)
+	require.Equal(t, 100.0, position.EntryPrice)
+	require.Equal(t, 200.0, position.LiquidationPrice)
+}
+
 func TestChart_WithDebug(t *testing.T) {
 	c, err := NewChart(WithDebug())
 	require.NoErrorf(t, err, "error when initial chart")
@@ -28,18 +28,62 @@ type Feeder interface {
 
 }
 
+// TickFeeder 是Feeder的可选扩展，提供原始成交数据（例如币安的归集成交），
+// 用于生成成交笔数、成交量、成交额、Renko和价格区间K线。
+type TickFeeder interface {
+	TicksByPeriod(ctx context.Context, pair string, start, end time.Time) ([]model.Tick, error) // 获取时间范围内的成交。
+	TicksSubscription(ctx context.Context, pair string) (chan model.Tick, chan error)           // 订阅实时成交。
+}
+
+// DepthFeeder 是Feeder的可选扩展，提供订单簿快照和增量更新，
+// 用于在策略中计算买卖不平衡度、价差等指标，以及让PaperWallet按订单簿深度成交。
+type DepthFeeder interface {
+	DepthSnapshot(ctx context.Context, pair string, limit int) (model.DepthUpdate, error)    // 获取订单簿快照。
+	DepthSubscription(ctx context.Context, pair string) (chan model.DepthUpdate, chan error) // 订阅订单簿增量更新。
+}
+
 // Broker 接口提供了执行交易、管理订单等功能的方法。
 type Broker interface {
 	Account() (model.Account, error)                        // 获取账户信息。
 	Position(pair string) (asset, quote float64, err error) // 获取某交易对的持仓信息。
 	Order(pair string, id int64) (model.Order, error)       // 获取指定订单的详细信息。
+	// 获取某交易对的持仓详情，合约交易所会返回方向、开仓均价、未实现盈亏、杠杆、保证金和强平价。
+	PositionInfo(pair string) (model.PositionInfo, error)
+	// 所有下单方法都可以通过options传入有效方式（GTC/IOC/FOK/GTX）、过期时间和客户端订单ID，见model.OrderOption。
 	// 创建OCO（一单成交即取消另一单）订单。置两个不一样的价格一个是获利 一个是止损 只要触发哪一个 订单马上取消 size 数量 比如 btc 1个  price 获利价 stop 止损我们设置得价格, 到了stop 开始执行stopLimit 止损单执行价格
-	CreateOrderOCO(side model.SideType, pair string, size, price, stop, stopLimit float64) ([]model.Order, error)
-	CreateOrderLimit(side model.SideType, pair string, size float64, limit float64) (model.Order, error) // 创建限价订单。他们愿意交易（买入或卖出）的最优价格。当市场价格达到或更优于这个价格时，订单会被执行。。limit 1000比如 btc 1000 买入
-	CreateOrderMarket(side model.SideType, pair string, size float64) (model.Order, error)               // 创建市价订单。市价订单是一种立即按当前市场价格执行的订单类型，与限价订单相反，它不允许交易者指定成交价格。这个方法的目的是让交易者能够快速进入或退出市场，通常用于需要立即成交的场景。
-	CreateOrderMarketQuote(side model.SideType, pair string, quote float64) (model.Order, error)         // 以报价金额创建市价订单。比如我的账户有100000 我想出1000买btc 意思就是说这个方法可以以自己想买的金额买入
-	CreateOrderStop(pair string, quantity float64, limit float64) (model.Order, error)                   // 创建止损订单。，旨在限制投资者的损失。当交易资产的价格达到或者超过某个指定的价格点（止损价）时，止损订单会被触发，自动以市价或限价卖出（或买入，如果是做空操作）该资产。
-	Cancel(model.Order) error                                                                            // 取消订单。
+	CreateOrderOCO(side model.SideType, pair string, size, price, stop, stopLimit float64,
+		options ...model.OrderOption) ([]model.Order, error)
+	CreateOrderLimit(side model.SideType, pair string, size float64, limit float64, options ...model.OrderOption) (model.Order, error) // 创建限价订单。他们愿意交易（买入或卖出）的最优价格。当市场价格达到或更优于这个价格时，订单会被执行。。limit 1000比如 btc 1000 买入
+	CreateOrderMarket(side model.SideType, pair string, size float64, options ...model.OrderOption) (model.Order, error)               // 创建市价订单。市价订单是一种立即按当前市场价格执行的订单类型，与限价订单相反，它不允许交易者指定成交价格。这个方法的目的是让交易者能够快速进入或退出市场，通常用于需要立即成交的场景。
+	CreateOrderMarketQuote(side model.SideType, pair string, quote float64, options ...model.OrderOption) (model.Order, error)         // 以报价金额创建市价订单。比如我的账户有100000 我想出1000买btc 意思就是说这个方法可以以自己想买的金额买入
+	CreateOrderStop(pair string, quantity float64, limit float64, options ...model.OrderOption) (model.Order, error)                   // 创建止损订单。，旨在限制投资者的损失。当交易资产的价格达到或者超过某个指定的价格点（止损价）时，止损订单会被触发，自动以市价或限价卖出（或买入，如果是做空操作）该资产。
+	Cancel(model.Order) error                                                                                                          // 取消订单。
+	// 修改挂单的价格和数量（改单），成功时原订单被取消，返回的新订单通过ReplacedID关联原订单；
+	// 交易所原地修改订单时，返回的订单ExchangeID与原订单相同。
+	ReplaceOrder(order model.Order, price, quantity float64) (model.Order, error)
+}
+
+// OrderLister 是Broker的可选扩展，用于列出交易所中某个交易对最近的订单，
+// 对账时可以发现手动下单或者程序崩溃时漏记的订单。
+type OrderLister interface {
+	Orders(pair string, limit int) ([]model.Order, error) // 获取指定交易对最近limit个订单。
+}
+
+// OrderStreamer 是Broker的可选扩展，交易所主动推送订单状态的变化（例如币安的用户数据流），
+// Controller收到推送后立即更新订单，轮询只作为兜底。
+type OrderStreamer interface {
+	OrderSubscription(ctx context.Context) (chan model.Order, chan error) // 订阅订单更新，连接无法建立时关闭通道。
+}
+
+// ConditionalBroker 是Broker的可选扩展，提供由本地引擎模拟的条件单（突破买入、止盈、止损等），
+// 不依赖交易所是否原生支持。策略中可以通过类型断言 broker.(service.ConditionalBroker) 使用。
+type ConditionalBroker interface {
+	Broker
+	// 创建条件单。价格按condition方向穿越trigger后提交订单，limit为0时提交市价单，否则提交限价单。
+	CreateOrderConditional(side model.SideType, pair string, size float64, condition model.ConditionType,
+		trigger, limit float64) (model.ConditionalOrder, error)
+	CancelConditional(id int64) error                       // 取消尚未触发的条件单。
+	ConditionalOrders(pair string) []model.ConditionalOrder // 获取指定交易对等待触发的条件单。
 }
 
 // Notifier 接口定义了通知相关的方法。
@@ -52,6 +52,27 @@ func WithPair(pair string) OrderFilter {
 	}
 }
 
+// WithClientOrderID 返回一个过滤器，该过滤器检查订单的客户端订单ID是否等于指定的ID。
+func WithClientOrderID(id string) OrderFilter {
+	return func(order model.Order) bool {
+		return order.ClientOrderID == id
+	}
+}
+
+// WithExchangeID 返回一个过滤器，该过滤器检查订单的交易所ID是否等于指定的ID。
+func WithExchangeID(id int64) OrderFilter {
+	return func(order model.Order) bool {
+		return order.ExchangeID == id
+	}
+}
+
+// WithReplacedID 返回一个过滤器，用于查找替换了指定交易所订单ID的新订单，便于追溯改单链路。
+func WithReplacedID(exchangeID int64) OrderFilter {
+	return func(order model.Order) bool {
+		return order.ReplacedID != nil && *order.ReplacedID == exchangeID
+	}
+}
+
 // WithUpdateAtBeforeOrEqual 返回一个过滤器，该过滤器检查订单的更新时间是否早于或等于指定的时间。
 // 如果订单的更新时间早于或等同于给定时间，则返回 true。
 func WithUpdateAtBeforeOrEqual(time time.Time) OrderFilter {
@@ -34,6 +34,11 @@ func (s *Controller) Start() {
 	s.started = true
 }
 
+// OnOrderBook 方法更新策略看到的订单簿，在处理K线之前调用，策略通过dataframe.OrderBook读取。
+func (s *Controller) OnOrderBook(book *model.OrderBook) {
+	s.dataframe.OrderBook = book
+}
+
 // OnPartialCandle 方法在每个新的部分完成的K线时被调用，用于更新K线数据并执行高频交易逻辑。
 func (s *Controller) OnPartialCandle(candle model.Candle) {
 	// 如果k线未完成，并且k线数据的收盘价 >=  预加载的数据(说明执行了预加载)
@@ -100,20 +100,27 @@ func (_c *Broker_Cancel_Call) Return(_a0 error) *Broker_Cancel_Call {
 	return _c
 }
 
-// CreateOrderLimit provides a mock function with given fields: side, pair, size, limit
-func (_m *Broker) CreateOrderLimit(side model.SideType, pair string, size float64, limit float64) (model.Order, error) {
-	ret := _m.Called(side, pair, size, limit)
+// CreateOrderLimit provides a mock function with given fields: side, pair, size, limit, options
+func (_m *Broker) CreateOrderLimit(side model.SideType, pair string, size float64, limit float64, options ...model.OrderOption) (model.Order, error) {
+	_va := make([]interface{}, len(options))
+	for _i := range options {
+		_va[_i] = options[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, side, pair, size, limit)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
 
 	var r0 model.Order
-	if rf, ok := ret.Get(0).(func(model.SideType, string, float64, float64) model.Order); ok {
-		r0 = rf(side, pair, size, limit)
+	if rf, ok := ret.Get(0).(func(model.SideType, string, float64, float64, ...model.OrderOption) model.Order); ok {
+		r0 = rf(side, pair, size, limit, options...)
 	} else {
 		r0 = ret.Get(0).(model.Order)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(model.SideType, string, float64, float64) error); ok {
-		r1 = rf(side, pair, size, limit)
+	if rf, ok := ret.Get(1).(func(model.SideType, string, float64, float64, ...model.OrderOption) error); ok {
+		r1 = rf(side, pair, size, limit, options...)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -131,13 +138,21 @@ type Broker_CreateOrderLimit_Call struct {
 //   - pair string
 //   - size float64
 //   - limit float64
-func (_e *Broker_Expecter) CreateOrderLimit(side interface{}, pair interface{}, size interface{}, limit interface{}) *Broker_CreateOrderLimit_Call {
-	return &Broker_CreateOrderLimit_Call{Call: _e.mock.On("CreateOrderLimit", side, pair, size, limit)}
+//   - options ...model.OrderOption
+func (_e *Broker_Expecter) CreateOrderLimit(side interface{}, pair interface{}, size interface{}, limit interface{}, options ...interface{}) *Broker_CreateOrderLimit_Call {
+	return &Broker_CreateOrderLimit_Call{Call: _e.mock.On("CreateOrderLimit",
+		append([]interface{}{side, pair, size, limit}, options...)...)}
 }
 
-func (_c *Broker_CreateOrderLimit_Call) Run(run func(side model.SideType, pair string, size float64, limit float64)) *Broker_CreateOrderLimit_Call {
+func (_c *Broker_CreateOrderLimit_Call) Run(run func(side model.SideType, pair string, size float64, limit float64, options ...model.OrderOption)) *Broker_CreateOrderLimit_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(model.SideType), args[1].(string), args[2].(float64), args[3].(float64))
+		variadicArgs := make([]model.OrderOption, len(args)-4)
+		for i, a := range args[4:] {
+			if a != nil {
+				variadicArgs[i] = a.(model.OrderOption)
+			}
+		}
+		run(args[0].(model.SideType), args[1].(string), args[2].(float64), args[3].(float64), variadicArgs...)
 	})
 	return _c
 }
@@ -147,20 +162,27 @@ func (_c *Broker_CreateOrderLimit_Call) Return(_a0 model.Order, _a1 error) *Brok
 	return _c
 }
 
-// CreateOrderMarket provides a mock function with given fields: side, pair, size
-func (_m *Broker) CreateOrderMarket(side model.SideType, pair string, size float64) (model.Order, error) {
-	ret := _m.Called(side, pair, size)
+// CreateOrderMarket provides a mock function with given fields: side, pair, size, options
+func (_m *Broker) CreateOrderMarket(side model.SideType, pair string, size float64, options ...model.OrderOption) (model.Order, error) {
+	_va := make([]interface{}, len(options))
+	for _i := range options {
+		_va[_i] = options[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, side, pair, size)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
 
 	var r0 model.Order
-	if rf, ok := ret.Get(0).(func(model.SideType, string, float64) model.Order); ok {
-		r0 = rf(side, pair, size)
+	if rf, ok := ret.Get(0).(func(model.SideType, string, float64, ...model.OrderOption) model.Order); ok {
+		r0 = rf(side, pair, size, options...)
 	} else {
 		r0 = ret.Get(0).(model.Order)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(model.SideType, string, float64) error); ok {
-		r1 = rf(side, pair, size)
+	if rf, ok := ret.Get(1).(func(model.SideType, string, float64, ...model.OrderOption) error); ok {
+		r1 = rf(side, pair, size, options...)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -177,13 +199,21 @@ type Broker_CreateOrderMarket_Call struct {
 //   - side model.SideType
 //   - pair string
 //   - size float64
-func (_e *Broker_Expecter) CreateOrderMarket(side interface{}, pair interface{}, size interface{}) *Broker_CreateOrderMarket_Call {
-	return &Broker_CreateOrderMarket_Call{Call: _e.mock.On("CreateOrderMarket", side, pair, size)}
+//   - options ...model.OrderOption
+func (_e *Broker_Expecter) CreateOrderMarket(side interface{}, pair interface{}, size interface{}, options ...interface{}) *Broker_CreateOrderMarket_Call {
+	return &Broker_CreateOrderMarket_Call{Call: _e.mock.On("CreateOrderMarket",
+		append([]interface{}{side, pair, size}, options...)...)}
 }
 
-func (_c *Broker_CreateOrderMarket_Call) Run(run func(side model.SideType, pair string, size float64)) *Broker_CreateOrderMarket_Call {
+func (_c *Broker_CreateOrderMarket_Call) Run(run func(side model.SideType, pair string, size float64, options ...model.OrderOption)) *Broker_CreateOrderMarket_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(model.SideType), args[1].(string), args[2].(float64))
+		variadicArgs := make([]model.OrderOption, len(args)-3)
+		for i, a := range args[3:] {
+			if a != nil {
+				variadicArgs[i] = a.(model.OrderOption)
+			}
+		}
+		run(args[0].(model.SideType), args[1].(string), args[2].(float64), variadicArgs...)
 	})
 	return _c
 }
@@ -193,20 +223,27 @@ func (_c *Broker_CreateOrderMarket_Call) Return(_a0 model.Order, _a1 error) *Bro
 	return _c
 }
 
-// CreateOrderMarketQuote provides a mock function with given fields: side, pair, quote
-func (_m *Broker) CreateOrderMarketQuote(side model.SideType, pair string, quote float64) (model.Order, error) {
-	ret := _m.Called(side, pair, quote)
+// CreateOrderMarketQuote provides a mock function with given fields: side, pair, quote, options
+func (_m *Broker) CreateOrderMarketQuote(side model.SideType, pair string, quote float64, options ...model.OrderOption) (model.Order, error) {
+	_va := make([]interface{}, len(options))
+	for _i := range options {
+		_va[_i] = options[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, side, pair, quote)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
 
 	var r0 model.Order
-	if rf, ok := ret.Get(0).(func(model.SideType, string, float64) model.Order); ok {
-		r0 = rf(side, pair, quote)
+	if rf, ok := ret.Get(0).(func(model.SideType, string, float64, ...model.OrderOption) model.Order); ok {
+		r0 = rf(side, pair, quote, options...)
 	} else {
 		r0 = ret.Get(0).(model.Order)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(model.SideType, string, float64) error); ok {
-		r1 = rf(side, pair, quote)
+	if rf, ok := ret.Get(1).(func(model.SideType, string, float64, ...model.OrderOption) error); ok {
+		r1 = rf(side, pair, quote, options...)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -223,13 +260,21 @@ type Broker_CreateOrderMarketQuote_Call struct {
 //   - side model.SideType
 //   - pair string
 //   - quote float64
-func (_e *Broker_Expecter) CreateOrderMarketQuote(side interface{}, pair interface{}, quote interface{}) *Broker_CreateOrderMarketQuote_Call {
-	return &Broker_CreateOrderMarketQuote_Call{Call: _e.mock.On("CreateOrderMarketQuote", side, pair, quote)}
+//   - options ...model.OrderOption
+func (_e *Broker_Expecter) CreateOrderMarketQuote(side interface{}, pair interface{}, quote interface{}, options ...interface{}) *Broker_CreateOrderMarketQuote_Call {
+	return &Broker_CreateOrderMarketQuote_Call{Call: _e.mock.On("CreateOrderMarketQuote",
+		append([]interface{}{side, pair, quote}, options...)...)}
 }
 
-func (_c *Broker_CreateOrderMarketQuote_Call) Run(run func(side model.SideType, pair string, quote float64)) *Broker_CreateOrderMarketQuote_Call {
+func (_c *Broker_CreateOrderMarketQuote_Call) Run(run func(side model.SideType, pair string, quote float64, options ...model.OrderOption)) *Broker_CreateOrderMarketQuote_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(model.SideType), args[1].(string), args[2].(float64))
+		variadicArgs := make([]model.OrderOption, len(args)-3)
+		for i, a := range args[3:] {
+			if a != nil {
+				variadicArgs[i] = a.(model.OrderOption)
+			}
+		}
+		run(args[0].(model.SideType), args[1].(string), args[2].(float64), variadicArgs...)
 	})
 	return _c
 }
@@ -239,13 +284,20 @@ func (_c *Broker_CreateOrderMarketQuote_Call) Return(_a0 model.Order, _a1 error)
 	return _c
 }
 
-// CreateOrderOCO provides a mock function with given fields: side, pair, size, price, stop, stopLimit
-func (_m *Broker) CreateOrderOCO(side model.SideType, pair string, size float64, price float64, stop float64, stopLimit float64) ([]model.Order, error) {
-	ret := _m.Called(side, pair, size, price, stop, stopLimit)
+// CreateOrderOCO provides a mock function with given fields: side, pair, size, price, stop, stopLimit, options
+func (_m *Broker) CreateOrderOCO(side model.SideType, pair string, size float64, price float64, stop float64, stopLimit float64, options ...model.OrderOption) ([]model.Order, error) {
+	_va := make([]interface{}, len(options))
+	for _i := range options {
+		_va[_i] = options[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, side, pair, size, price, stop, stopLimit)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
 
 	var r0 []model.Order
-	if rf, ok := ret.Get(0).(func(model.SideType, string, float64, float64, float64, float64) []model.Order); ok {
-		r0 = rf(side, pair, size, price, stop, stopLimit)
+	if rf, ok := ret.Get(0).(func(model.SideType, string, float64, float64, float64, float64, ...model.OrderOption) []model.Order); ok {
+		r0 = rf(side, pair, size, price, stop, stopLimit, options...)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]model.Order)
@@ -253,8 +305,8 @@ func (_m *Broker) CreateOrderOCO(side model.SideType, pair string, size float64,
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(model.SideType, string, float64, float64, float64, float64) error); ok {
-		r1 = rf(side, pair, size, price, stop, stopLimit)
+	if rf, ok := ret.Get(1).(func(model.SideType, string, float64, float64, float64, float64, ...model.OrderOption) error); ok {
+		r1 = rf(side, pair, size, price, stop, stopLimit, options...)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -274,13 +326,21 @@ type Broker_CreateOrderOCO_Call struct {
 //   - price float64
 //   - stop float64
 //   - stopLimit float64
-func (_e *Broker_Expecter) CreateOrderOCO(side interface{}, pair interface{}, size interface{}, price interface{}, stop interface{}, stopLimit interface{}) *Broker_CreateOrderOCO_Call {
-	return &Broker_CreateOrderOCO_Call{Call: _e.mock.On("CreateOrderOCO", side, pair, size, price, stop, stopLimit)}
+//   - options ...model.OrderOption
+func (_e *Broker_Expecter) CreateOrderOCO(side interface{}, pair interface{}, size interface{}, price interface{}, stop interface{}, stopLimit interface{}, options ...interface{}) *Broker_CreateOrderOCO_Call {
+	return &Broker_CreateOrderOCO_Call{Call: _e.mock.On("CreateOrderOCO",
+		append([]interface{}{side, pair, size, price, stop, stopLimit}, options...)...)}
 }
 
-func (_c *Broker_CreateOrderOCO_Call) Run(run func(side model.SideType, pair string, size float64, price float64, stop float64, stopLimit float64)) *Broker_CreateOrderOCO_Call {
+func (_c *Broker_CreateOrderOCO_Call) Run(run func(side model.SideType, pair string, size float64, price float64, stop float64, stopLimit float64, options ...model.OrderOption)) *Broker_CreateOrderOCO_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(model.SideType), args[1].(string), args[2].(float64), args[3].(float64), args[4].(float64), args[5].(float64))
+		variadicArgs := make([]model.OrderOption, len(args)-6)
+		for i, a := range args[6:] {
+			if a != nil {
+				variadicArgs[i] = a.(model.OrderOption)
+			}
+		}
+		run(args[0].(model.SideType), args[1].(string), args[2].(float64), args[3].(float64), args[4].(float64), args[5].(float64), variadicArgs...)
 	})
 	return _c
 }
@@ -290,20 +350,27 @@ func (_c *Broker_CreateOrderOCO_Call) Return(_a0 []model.Order, _a1 error) *Brok
 	return _c
 }
 
-// CreateOrderStop provides a mock function with given fields: pair, quantity, limit
-func (_m *Broker) CreateOrderStop(pair string, quantity float64, limit float64) (model.Order, error) {
-	ret := _m.Called(pair, quantity, limit)
+// CreateOrderStop provides a mock function with given fields: pair, quantity, limit, options
+func (_m *Broker) CreateOrderStop(pair string, quantity float64, limit float64, options ...model.OrderOption) (model.Order, error) {
+	_va := make([]interface{}, len(options))
+	for _i := range options {
+		_va[_i] = options[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, pair, quantity, limit)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
 
 	var r0 model.Order
-	if rf, ok := ret.Get(0).(func(string, float64, float64) model.Order); ok {
-		r0 = rf(pair, quantity, limit)
+	if rf, ok := ret.Get(0).(func(string, float64, float64, ...model.OrderOption) model.Order); ok {
+		r0 = rf(pair, quantity, limit, options...)
 	} else {
 		r0 = ret.Get(0).(model.Order)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, float64, float64) error); ok {
-		r1 = rf(pair, quantity, limit)
+	if rf, ok := ret.Get(1).(func(string, float64, float64, ...model.OrderOption) error); ok {
+		r1 = rf(pair, quantity, limit, options...)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -320,13 +387,21 @@ type Broker_CreateOrderStop_Call struct {
 //   - pair string
 //   - quantity float64
 //   - limit float64
-func (_e *Broker_Expecter) CreateOrderStop(pair interface{}, quantity interface{}, limit interface{}) *Broker_CreateOrderStop_Call {
-	return &Broker_CreateOrderStop_Call{Call: _e.mock.On("CreateOrderStop", pair, quantity, limit)}
+//   - options ...model.OrderOption
+func (_e *Broker_Expecter) CreateOrderStop(pair interface{}, quantity interface{}, limit interface{}, options ...interface{}) *Broker_CreateOrderStop_Call {
+	return &Broker_CreateOrderStop_Call{Call: _e.mock.On("CreateOrderStop",
+		append([]interface{}{pair, quantity, limit}, options...)...)}
 }
 
-func (_c *Broker_CreateOrderStop_Call) Run(run func(pair string, quantity float64, limit float64)) *Broker_CreateOrderStop_Call {
+func (_c *Broker_CreateOrderStop_Call) Run(run func(pair string, quantity float64, limit float64, options ...model.OrderOption)) *Broker_CreateOrderStop_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(string), args[1].(float64), args[2].(float64))
+		variadicArgs := make([]model.OrderOption, len(args)-3)
+		for i, a := range args[3:] {
+			if a != nil {
+				variadicArgs[i] = a.(model.OrderOption)
+			}
+		}
+		run(args[0].(string), args[1].(float64), args[2].(float64), variadicArgs...)
 	})
 	return _c
 }
@@ -432,6 +507,96 @@ func (_c *Broker_Position_Call) Return(asset float64, quote float64, err error)
 	return _c
 }
 
+// PositionInfo provides a mock function with given fields: pair
+func (_m *Broker) PositionInfo(pair string) (model.PositionInfo, error) {
+	ret := _m.Called(pair)
+
+	var r0 model.PositionInfo
+	if rf, ok := ret.Get(0).(func(string) model.PositionInfo); ok {
+		r0 = rf(pair)
+	} else {
+		r0 = ret.Get(0).(model.PositionInfo)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(pair)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Broker_PositionInfo_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'PositionInfo'
+type Broker_PositionInfo_Call struct {
+	*mock.Call
+}
+
+// PositionInfo is a helper method to define mock.On call
+//   - pair string
+func (_e *Broker_Expecter) PositionInfo(pair interface{}) *Broker_PositionInfo_Call {
+	return &Broker_PositionInfo_Call{Call: _e.mock.On("PositionInfo", pair)}
+}
+
+func (_c *Broker_PositionInfo_Call) Run(run func(pair string)) *Broker_PositionInfo_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string))
+	})
+	return _c
+}
+
+func (_c *Broker_PositionInfo_Call) Return(_a0 model.PositionInfo, _a1 error) *Broker_PositionInfo_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+// ReplaceOrder provides a mock function with given fields: order, price, quantity
+func (_m *Broker) ReplaceOrder(order model.Order, price float64, quantity float64) (model.Order, error) {
+	ret := _m.Called(order, price, quantity)
+
+	var r0 model.Order
+	if rf, ok := ret.Get(0).(func(model.Order, float64, float64) model.Order); ok {
+		r0 = rf(order, price, quantity)
+	} else {
+		r0 = ret.Get(0).(model.Order)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(model.Order, float64, float64) error); ok {
+		r1 = rf(order, price, quantity)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Broker_ReplaceOrder_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ReplaceOrder'
+type Broker_ReplaceOrder_Call struct {
+	*mock.Call
+}
+
+// ReplaceOrder is a helper method to define mock.On call
+//   - order model.Order
+//   - price float64
+//   - quantity float64
+func (_e *Broker_Expecter) ReplaceOrder(order interface{}, price interface{}, quantity interface{}) *Broker_ReplaceOrder_Call {
+	return &Broker_ReplaceOrder_Call{Call: _e.mock.On("ReplaceOrder", order, price, quantity)}
+}
+
+func (_c *Broker_ReplaceOrder_Call) Run(run func(order model.Order, price float64, quantity float64)) *Broker_ReplaceOrder_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(model.Order), args[1].(float64), args[2].(float64))
+	})
+	return _c
+}
+
+func (_c *Broker_ReplaceOrder_Call) Return(_a0 model.Order, _a1 error) *Broker_ReplaceOrder_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
 type mockConstructorTestingTNewBroker interface {
 	mock.TestingT
 	Cleanup(func())
@@ -290,20 +290,27 @@ func (_c *Exchange_CandlesSubscription_Call) Return(_a0 chan model.Candle, _a1 c
 	return _c
 }
 
-// CreateOrderLimit provides a mock function with given fields: side, pair, size, limit
-func (_m *Exchange) CreateOrderLimit(side model.SideType, pair string, size float64, limit float64) (model.Order, error) {
-	ret := _m.Called(side, pair, size, limit)
+// CreateOrderLimit provides a mock function with given fields: side, pair, size, limit, options
+func (_m *Exchange) CreateOrderLimit(side model.SideType, pair string, size float64, limit float64, options ...model.OrderOption) (model.Order, error) {
+	_va := make([]interface{}, len(options))
+	for _i := range options {
+		_va[_i] = options[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, side, pair, size, limit)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
 
 	var r0 model.Order
-	if rf, ok := ret.Get(0).(func(model.SideType, string, float64, float64) model.Order); ok {
-		r0 = rf(side, pair, size, limit)
+	if rf, ok := ret.Get(0).(func(model.SideType, string, float64, float64, ...model.OrderOption) model.Order); ok {
+		r0 = rf(side, pair, size, limit, options...)
 	} else {
 		r0 = ret.Get(0).(model.Order)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(model.SideType, string, float64, float64) error); ok {
-		r1 = rf(side, pair, size, limit)
+	if rf, ok := ret.Get(1).(func(model.SideType, string, float64, float64, ...model.OrderOption) error); ok {
+		r1 = rf(side, pair, size, limit, options...)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -321,13 +328,21 @@ type Exchange_CreateOrderLimit_Call struct {
 //   - pair string
 //   - size float64
 //   - limit float64
-func (_e *Exchange_Expecter) CreateOrderLimit(side interface{}, pair interface{}, size interface{}, limit interface{}) *Exchange_CreateOrderLimit_Call {
-	return &Exchange_CreateOrderLimit_Call{Call: _e.mock.On("CreateOrderLimit", side, pair, size, limit)}
+//   - options ...model.OrderOption
+func (_e *Exchange_Expecter) CreateOrderLimit(side interface{}, pair interface{}, size interface{}, limit interface{}, options ...interface{}) *Exchange_CreateOrderLimit_Call {
+	return &Exchange_CreateOrderLimit_Call{Call: _e.mock.On("CreateOrderLimit",
+		append([]interface{}{side, pair, size, limit}, options...)...)}
 }
 
-func (_c *Exchange_CreateOrderLimit_Call) Run(run func(side model.SideType, pair string, size float64, limit float64)) *Exchange_CreateOrderLimit_Call {
+func (_c *Exchange_CreateOrderLimit_Call) Run(run func(side model.SideType, pair string, size float64, limit float64, options ...model.OrderOption)) *Exchange_CreateOrderLimit_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(model.SideType), args[1].(string), args[2].(float64), args[3].(float64))
+		variadicArgs := make([]model.OrderOption, len(args)-4)
+		for i, a := range args[4:] {
+			if a != nil {
+				variadicArgs[i] = a.(model.OrderOption)
+			}
+		}
+		run(args[0].(model.SideType), args[1].(string), args[2].(float64), args[3].(float64), variadicArgs...)
 	})
 	return _c
 }
@@ -337,20 +352,27 @@ func (_c *Exchange_CreateOrderLimit_Call) Return(_a0 model.Order, _a1 error) *Ex
 	return _c
 }
 
-// CreateOrderMarket provides a mock function with given fields: side, pair, size
-func (_m *Exchange) CreateOrderMarket(side model.SideType, pair string, size float64) (model.Order, error) {
-	ret := _m.Called(side, pair, size)
+// CreateOrderMarket provides a mock function with given fields: side, pair, size, options
+func (_m *Exchange) CreateOrderMarket(side model.SideType, pair string, size float64, options ...model.OrderOption) (model.Order, error) {
+	_va := make([]interface{}, len(options))
+	for _i := range options {
+		_va[_i] = options[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, side, pair, size)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
 
 	var r0 model.Order
-	if rf, ok := ret.Get(0).(func(model.SideType, string, float64) model.Order); ok {
-		r0 = rf(side, pair, size)
+	if rf, ok := ret.Get(0).(func(model.SideType, string, float64, ...model.OrderOption) model.Order); ok {
+		r0 = rf(side, pair, size, options...)
 	} else {
 		r0 = ret.Get(0).(model.Order)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(model.SideType, string, float64) error); ok {
-		r1 = rf(side, pair, size)
+	if rf, ok := ret.Get(1).(func(model.SideType, string, float64, ...model.OrderOption) error); ok {
+		r1 = rf(side, pair, size, options...)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -367,13 +389,21 @@ type Exchange_CreateOrderMarket_Call struct {
 //   - side model.SideType
 //   - pair string
 //   - size float64
-func (_e *Exchange_Expecter) CreateOrderMarket(side interface{}, pair interface{}, size interface{}) *Exchange_CreateOrderMarket_Call {
-	return &Exchange_CreateOrderMarket_Call{Call: _e.mock.On("CreateOrderMarket", side, pair, size)}
+//   - options ...model.OrderOption
+func (_e *Exchange_Expecter) CreateOrderMarket(side interface{}, pair interface{}, size interface{}, options ...interface{}) *Exchange_CreateOrderMarket_Call {
+	return &Exchange_CreateOrderMarket_Call{Call: _e.mock.On("CreateOrderMarket",
+		append([]interface{}{side, pair, size}, options...)...)}
 }
 
-func (_c *Exchange_CreateOrderMarket_Call) Run(run func(side model.SideType, pair string, size float64)) *Exchange_CreateOrderMarket_Call {
+func (_c *Exchange_CreateOrderMarket_Call) Run(run func(side model.SideType, pair string, size float64, options ...model.OrderOption)) *Exchange_CreateOrderMarket_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(model.SideType), args[1].(string), args[2].(float64))
+		variadicArgs := make([]model.OrderOption, len(args)-3)
+		for i, a := range args[3:] {
+			if a != nil {
+				variadicArgs[i] = a.(model.OrderOption)
+			}
+		}
+		run(args[0].(model.SideType), args[1].(string), args[2].(float64), variadicArgs...)
 	})
 	return _c
 }
@@ -383,20 +413,27 @@ func (_c *Exchange_CreateOrderMarket_Call) Return(_a0 model.Order, _a1 error) *E
 	return _c
 }
 
-// CreateOrderMarketQuote provides a mock function with given fields: side, pair, quote
-func (_m *Exchange) CreateOrderMarketQuote(side model.SideType, pair string, quote float64) (model.Order, error) {
-	ret := _m.Called(side, pair, quote)
+// CreateOrderMarketQuote provides a mock function with given fields: side, pair, quote, options
+func (_m *Exchange) CreateOrderMarketQuote(side model.SideType, pair string, quote float64, options ...model.OrderOption) (model.Order, error) {
+	_va := make([]interface{}, len(options))
+	for _i := range options {
+		_va[_i] = options[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, side, pair, quote)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
 
 	var r0 model.Order
-	if rf, ok := ret.Get(0).(func(model.SideType, string, float64) model.Order); ok {
-		r0 = rf(side, pair, quote)
+	if rf, ok := ret.Get(0).(func(model.SideType, string, float64, ...model.OrderOption) model.Order); ok {
+		r0 = rf(side, pair, quote, options...)
 	} else {
 		r0 = ret.Get(0).(model.Order)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(model.SideType, string, float64) error); ok {
-		r1 = rf(side, pair, quote)
+	if rf, ok := ret.Get(1).(func(model.SideType, string, float64, ...model.OrderOption) error); ok {
+		r1 = rf(side, pair, quote, options...)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -413,13 +450,21 @@ type Exchange_CreateOrderMarketQuote_Call struct {
 //   - side model.SideType
 //   - pair string
 //   - quote float64
-func (_e *Exchange_Expecter) CreateOrderMarketQuote(side interface{}, pair interface{}, quote interface{}) *Exchange_CreateOrderMarketQuote_Call {
-	return &Exchange_CreateOrderMarketQuote_Call{Call: _e.mock.On("CreateOrderMarketQuote", side, pair, quote)}
+//   - options ...model.OrderOption
+func (_e *Exchange_Expecter) CreateOrderMarketQuote(side interface{}, pair interface{}, quote interface{}, options ...interface{}) *Exchange_CreateOrderMarketQuote_Call {
+	return &Exchange_CreateOrderMarketQuote_Call{Call: _e.mock.On("CreateOrderMarketQuote",
+		append([]interface{}{side, pair, quote}, options...)...)}
 }
 
-func (_c *Exchange_CreateOrderMarketQuote_Call) Run(run func(side model.SideType, pair string, quote float64)) *Exchange_CreateOrderMarketQuote_Call {
+func (_c *Exchange_CreateOrderMarketQuote_Call) Run(run func(side model.SideType, pair string, quote float64, options ...model.OrderOption)) *Exchange_CreateOrderMarketQuote_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(model.SideType), args[1].(string), args[2].(float64))
+		variadicArgs := make([]model.OrderOption, len(args)-3)
+		for i, a := range args[3:] {
+			if a != nil {
+				variadicArgs[i] = a.(model.OrderOption)
+			}
+		}
+		run(args[0].(model.SideType), args[1].(string), args[2].(float64), variadicArgs...)
 	})
 	return _c
 }
@@ -429,13 +474,20 @@ func (_c *Exchange_CreateOrderMarketQuote_Call) Return(_a0 model.Order, _a1 erro
 	return _c
 }
 
-// CreateOrderOCO provides a mock function with given fields: side, pair, size, price, stop, stopLimit
-func (_m *Exchange) CreateOrderOCO(side model.SideType, pair string, size float64, price float64, stop float64, stopLimit float64) ([]model.Order, error) {
-	ret := _m.Called(side, pair, size, price, stop, stopLimit)
+// CreateOrderOCO provides a mock function with given fields: side, pair, size, price, stop, stopLimit, options
+func (_m *Exchange) CreateOrderOCO(side model.SideType, pair string, size float64, price float64, stop float64, stopLimit float64, options ...model.OrderOption) ([]model.Order, error) {
+	_va := make([]interface{}, len(options))
+	for _i := range options {
+		_va[_i] = options[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, side, pair, size, price, stop, stopLimit)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
 
 	var r0 []model.Order
-	if rf, ok := ret.Get(0).(func(model.SideType, string, float64, float64, float64, float64) []model.Order); ok {
-		r0 = rf(side, pair, size, price, stop, stopLimit)
+	if rf, ok := ret.Get(0).(func(model.SideType, string, float64, float64, float64, float64, ...model.OrderOption) []model.Order); ok {
+		r0 = rf(side, pair, size, price, stop, stopLimit, options...)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]model.Order)
@@ -443,8 +495,8 @@ func (_m *Exchange) CreateOrderOCO(side model.SideType, pair string, size float6
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(model.SideType, string, float64, float64, float64, float64) error); ok {
-		r1 = rf(side, pair, size, price, stop, stopLimit)
+	if rf, ok := ret.Get(1).(func(model.SideType, string, float64, float64, float64, float64, ...model.OrderOption) error); ok {
+		r1 = rf(side, pair, size, price, stop, stopLimit, options...)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -464,13 +516,21 @@ type Exchange_CreateOrderOCO_Call struct {
 //   - price float64
 //   - stop float64
 //   - stopLimit float64
-func (_e *Exchange_Expecter) CreateOrderOCO(side interface{}, pair interface{}, size interface{}, price interface{}, stop interface{}, stopLimit interface{}) *Exchange_CreateOrderOCO_Call {
-	return &Exchange_CreateOrderOCO_Call{Call: _e.mock.On("CreateOrderOCO", side, pair, size, price, stop, stopLimit)}
+//   - options ...model.OrderOption
+func (_e *Exchange_Expecter) CreateOrderOCO(side interface{}, pair interface{}, size interface{}, price interface{}, stop interface{}, stopLimit interface{}, options ...interface{}) *Exchange_CreateOrderOCO_Call {
+	return &Exchange_CreateOrderOCO_Call{Call: _e.mock.On("CreateOrderOCO",
+		append([]interface{}{side, pair, size, price, stop, stopLimit}, options...)...)}
 }
 
-func (_c *Exchange_CreateOrderOCO_Call) Run(run func(side model.SideType, pair string, size float64, price float64, stop float64, stopLimit float64)) *Exchange_CreateOrderOCO_Call {
+func (_c *Exchange_CreateOrderOCO_Call) Run(run func(side model.SideType, pair string, size float64, price float64, stop float64, stopLimit float64, options ...model.OrderOption)) *Exchange_CreateOrderOCO_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(model.SideType), args[1].(string), args[2].(float64), args[3].(float64), args[4].(float64), args[5].(float64))
+		variadicArgs := make([]model.OrderOption, len(args)-6)
+		for i, a := range args[6:] {
+			if a != nil {
+				variadicArgs[i] = a.(model.OrderOption)
+			}
+		}
+		run(args[0].(model.SideType), args[1].(string), args[2].(float64), args[3].(float64), args[4].(float64), args[5].(float64), variadicArgs...)
 	})
 	return _c
 }
@@ -480,20 +540,27 @@ func (_c *Exchange_CreateOrderOCO_Call) Return(_a0 []model.Order, _a1 error) *Ex
 	return _c
 }
 
-// CreateOrderStop provides a mock function with given fields: pair, quantity, limit
-func (_m *Exchange) CreateOrderStop(pair string, quantity float64, limit float64) (model.Order, error) {
-	ret := _m.Called(pair, quantity, limit)
+// CreateOrderStop provides a mock function with given fields: pair, quantity, limit, options
+func (_m *Exchange) CreateOrderStop(pair string, quantity float64, limit float64, options ...model.OrderOption) (model.Order, error) {
+	_va := make([]interface{}, len(options))
+	for _i := range options {
+		_va[_i] = options[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, pair, quantity, limit)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
 
 	var r0 model.Order
-	if rf, ok := ret.Get(0).(func(string, float64, float64) model.Order); ok {
-		r0 = rf(pair, quantity, limit)
+	if rf, ok := ret.Get(0).(func(string, float64, float64, ...model.OrderOption) model.Order); ok {
+		r0 = rf(pair, quantity, limit, options...)
 	} else {
 		r0 = ret.Get(0).(model.Order)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, float64, float64) error); ok {
-		r1 = rf(pair, quantity, limit)
+	if rf, ok := ret.Get(1).(func(string, float64, float64, ...model.OrderOption) error); ok {
+		r1 = rf(pair, quantity, limit, options...)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -510,13 +577,21 @@ type Exchange_CreateOrderStop_Call struct {
 //   - pair string
 //   - quantity float64
 //   - limit float64
-func (_e *Exchange_Expecter) CreateOrderStop(pair interface{}, quantity interface{}, limit interface{}) *Exchange_CreateOrderStop_Call {
-	return &Exchange_CreateOrderStop_Call{Call: _e.mock.On("CreateOrderStop", pair, quantity, limit)}
+//   - options ...model.OrderOption
+func (_e *Exchange_Expecter) CreateOrderStop(pair interface{}, quantity interface{}, limit interface{}, options ...interface{}) *Exchange_CreateOrderStop_Call {
+	return &Exchange_CreateOrderStop_Call{Call: _e.mock.On("CreateOrderStop",
+		append([]interface{}{pair, quantity, limit}, options...)...)}
 }
 
-func (_c *Exchange_CreateOrderStop_Call) Run(run func(pair string, quantity float64, limit float64)) *Exchange_CreateOrderStop_Call {
+func (_c *Exchange_CreateOrderStop_Call) Run(run func(pair string, quantity float64, limit float64, options ...model.OrderOption)) *Exchange_CreateOrderStop_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(string), args[1].(float64), args[2].(float64))
+		variadicArgs := make([]model.OrderOption, len(args)-3)
+		for i, a := range args[3:] {
+			if a != nil {
+				variadicArgs[i] = a.(model.OrderOption)
+			}
+		}
+		run(args[0].(string), args[1].(float64), args[2].(float64), variadicArgs...)
 	})
 	return _c
 }
@@ -667,6 +742,96 @@ func (_c *Exchange_Position_Call) Return(asset float64, quote float64, err error
 	return _c
 }
 
+// PositionInfo provides a mock function with given fields: pair
+func (_m *Exchange) PositionInfo(pair string) (model.PositionInfo, error) {
+	ret := _m.Called(pair)
+
+	var r0 model.PositionInfo
+	if rf, ok := ret.Get(0).(func(string) model.PositionInfo); ok {
+		r0 = rf(pair)
+	} else {
+		r0 = ret.Get(0).(model.PositionInfo)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(pair)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Exchange_PositionInfo_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'PositionInfo'
+type Exchange_PositionInfo_Call struct {
+	*mock.Call
+}
+
+// PositionInfo is a helper method to define mock.On call
+//   - pair string
+func (_e *Exchange_Expecter) PositionInfo(pair interface{}) *Exchange_PositionInfo_Call {
+	return &Exchange_PositionInfo_Call{Call: _e.mock.On("PositionInfo", pair)}
+}
+
+func (_c *Exchange_PositionInfo_Call) Run(run func(pair string)) *Exchange_PositionInfo_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string))
+	})
+	return _c
+}
+
+func (_c *Exchange_PositionInfo_Call) Return(_a0 model.PositionInfo, _a1 error) *Exchange_PositionInfo_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+// ReplaceOrder provides a mock function with given fields: order, price, quantity
+func (_m *Exchange) ReplaceOrder(order model.Order, price float64, quantity float64) (model.Order, error) {
+	ret := _m.Called(order, price, quantity)
+
+	var r0 model.Order
+	if rf, ok := ret.Get(0).(func(model.Order, float64, float64) model.Order); ok {
+		r0 = rf(order, price, quantity)
+	} else {
+		r0 = ret.Get(0).(model.Order)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(model.Order, float64, float64) error); ok {
+		r1 = rf(order, price, quantity)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Exchange_ReplaceOrder_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ReplaceOrder'
+type Exchange_ReplaceOrder_Call struct {
+	*mock.Call
+}
+
+// ReplaceOrder is a helper method to define mock.On call
+//   - order model.Order
+//   - price float64
+//   - quantity float64
+func (_e *Exchange_Expecter) ReplaceOrder(order interface{}, price interface{}, quantity interface{}) *Exchange_ReplaceOrder_Call {
+	return &Exchange_ReplaceOrder_Call{Call: _e.mock.On("ReplaceOrder", order, price, quantity)}
+}
+
+func (_c *Exchange_ReplaceOrder_Call) Run(run func(order model.Order, price float64, quantity float64)) *Exchange_ReplaceOrder_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(model.Order), args[1].(float64), args[2].(float64))
+	})
+	return _c
+}
+
+func (_c *Exchange_ReplaceOrder_Call) Return(_a0 model.Order, _a1 error) *Exchange_ReplaceOrder_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
 type mockConstructorTestingTNewExchange interface {
 	mock.TestingT
 	Cleanup(func())
@@ -0,0 +1,176 @@
+package arbitrage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+// 套利的错误。
+var (
+	ErrNoOpportunity = errors.New("arbitrage: no opportunity")   // 没有同步的报价或者价差低于最小价差
+	ErrLegFailed     = errors.New("arbitrage: one leg failed")   // 只有一条腿成交，已经按LegPolicy处理
+	ErrUnknownVenue  = errors.New("arbitrage: unknown venue")    // 套利机会中的交易所不在Tracker中
+	ErrBothLegs      = errors.New("arbitrage: both legs failed") // 两条腿都没有成交
+)
+
+// LegPolicy 只有一条腿成交时的处理方式。
+type LegPolicy int
+
+const (
+	// LegUnwind 在成交的交易所下反向市价单平掉成交的腿，回到没有持仓的状态。
+	LegUnwind LegPolicy = iota
+	// LegHedge 重试失败的腿完成对冲，重试次数用完仍然失败时再平掉成交的腿。
+	LegHedge
+)
+
+// Result 一次套利的结果。
+type Result struct {
+	Opportunity Opportunity  // Opportunity 下单时的套利机会
+	Buy         *model.Order // Buy 买入腿的订单，失败时为nil
+	Sell        *model.Order // Sell 卖出腿的订单，失败时为nil
+	Unwind      *model.Order // Unwind 只有一条腿成交时平仓的订单
+}
+
+// Arbitrage 根据Tracker的报价同时下两条腿的市价单。
+type Arbitrage struct {
+	tracker   *Tracker
+	minSpread float64   // minSpread 扣除手续费之后的最小价差，低于这个价差不下单
+	policy    LegPolicy // policy 单腿风险的处理方式
+	retries   int       // retries LegHedge重试失败腿的次数
+}
+
+// ArbitrageOption 定义了一个函数类型，用于通过不同的配置选项来定制化Arbitrage实例。
+type ArbitrageOption func(*Arbitrage)
+
+// WithMinSpread 设置扣除手续费之后的最小价差，默认为0，即只要扣除手续费之后有收益就下单。
+func WithMinSpread(spread float64) ArbitrageOption {
+	return func(a *Arbitrage) {
+		a.minSpread = spread
+	}
+}
+
+// WithLegPolicy 设置只有一条腿成交时的处理方式，retries为LegHedge重试失败腿的次数。默认为LegUnwind。
+func WithLegPolicy(policy LegPolicy, retries int) ArbitrageOption {
+	return func(a *Arbitrage) {
+		a.policy = policy
+		a.retries = retries
+	}
+}
+
+// NewArbitrage 创建一个使用tracker报价的Arbitrage。
+func NewArbitrage(tracker *Tracker, options ...ArbitrageOption) *Arbitrage {
+	arbitrage := &Arbitrage{tracker: tracker}
+	for _, option := range options {
+		option(arbitrage)
+	}
+	return arbitrage
+}
+
+// Check 找到当前最好的套利机会，价差不低于最小价差时买卖size数量，否则返回ErrNoOpportunity。
+func (a *Arbitrage) Check(size float64) (Result, error) {
+	opportunity, ok := a.tracker.Best()
+	if !ok || opportunity.NetSpread <= 0 || opportunity.NetSpread < a.minSpread {
+		return Result{}, ErrNoOpportunity
+	}
+	return a.Execute(opportunity, size)
+}
+
+// Execute 同时在opportunity的两个交易所下市价单：在Buy交易所买入size，在Sell交易所卖出size。
+// 只有一条腿成交时按LegPolicy处理，并返回ErrLegFailed，Result中包含成交的腿和平仓的订单。
+func (a *Arbitrage) Execute(opportunity Opportunity, size float64) (Result, error) {
+	buyVenue, ok := a.tracker.Venue(opportunity.Buy.Venue)
+	if !ok {
+		return Result{}, fmt.Errorf("%w %s", ErrUnknownVenue, opportunity.Buy.Venue)
+	}
+	sellVenue, ok := a.tracker.Venue(opportunity.Sell.Venue)
+	if !ok {
+		return Result{}, fmt.Errorf("%w %s", ErrUnknownVenue, opportunity.Sell.Venue)
+	}
+
+	// 两条腿同时下单，减少两个交易所成交时间的差异
+	var (
+		wg                  sync.WaitGroup
+		buyOrder, sellOrder model.Order
+		buyErr, sellErr     error
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		buyOrder, buyErr = buyVenue.Exchange.CreateOrderMarket(model.SideTypeBuy, buyVenue.Pair, size)
+	}()
+	go func() {
+		defer wg.Done()
+		sellOrder, sellErr = sellVenue.Exchange.CreateOrderMarket(model.SideTypeSell, sellVenue.Pair, size)
+	}()
+	wg.Wait()
+
+	result := Result{Opportunity: opportunity}
+	switch {
+	case buyErr == nil && sellErr == nil:
+		result.Buy, result.Sell = &buyOrder, &sellOrder
+		return result, nil
+	case buyErr != nil && sellErr != nil:
+		return result, fmt.Errorf("%w: buy %s: %v, sell %s: %v", ErrBothLegs,
+			buyVenue.Name, buyErr, sellVenue.Name, sellErr)
+	case buyErr != nil:
+		result.Sell = &sellOrder
+		order, hedged, err := a.handleLeg(buyVenue, model.SideTypeBuy, size, sellVenue, sellOrder, buyErr)
+		if err != nil {
+			return result, err
+		}
+		if hedged {
+			result.Buy = &order
+			return result, nil
+		}
+		result.Unwind = &order
+		return result, fmt.Errorf("%w: buy %s: %v", ErrLegFailed, buyVenue.Name, buyErr)
+	default:
+		result.Buy = &buyOrder
+		order, hedged, err := a.handleLeg(sellVenue, model.SideTypeSell, size, buyVenue, buyOrder, sellErr)
+		if err != nil {
+			return result, err
+		}
+		if hedged {
+			result.Sell = &order
+			return result, nil
+		}
+		result.Unwind = &order
+		return result, fmt.Errorf("%w: sell %s: %v", ErrLegFailed, sellVenue.Name, sellErr)
+	}
+}
+
+// handleLeg 处理只有一条腿成交的情况。LegHedge时先重试失败的腿，成功时返回失败腿的新订单，hedged为true；
+// 否则在成交的交易所下反向市价单平掉成交的腿，返回平仓订单。平仓也失败时返回错误，调用方需要人工处理持仓。
+func (a *Arbitrage) handleLeg(failed Venue, side model.SideType, size float64,
+	filled Venue, filledOrder model.Order, legErr error) (order model.Order, hedged bool, err error) {
+	if a.policy == LegHedge {
+		for i := 0; i < a.retries; i++ {
+			order, err = failed.Exchange.CreateOrderMarket(side, failed.Pair, size)
+			if err == nil {
+				return order, true, nil
+			}
+			log.Warnf("arbitrage: retry %d %s %s: %v", i+1, side, failed.Name, err)
+			legErr = err
+		}
+	}
+
+	unwindSide := model.SideTypeSell
+	if filledOrder.Side == model.SideTypeSell {
+		unwindSide = model.SideTypeBuy
+	}
+	quantity := filledOrder.Quantity
+	if quantity == 0 {
+		quantity = size
+	}
+	order, err = filled.Exchange.CreateOrderMarket(unwindSide, filled.Pair, quantity)
+	if err != nil {
+		return model.Order{}, false, fmt.Errorf("%w: %s %s: %v, unwind %s: %v", ErrLegFailed,
+			side, failed.Name, legErr, filled.Name, err)
+	}
+	return order, false, nil
+}
@@ -0,0 +1,203 @@
+package arbitrage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/model"
+)
+
+// testFeed 通过通道推送K线的数据源。
+type testFeed struct {
+	candles chan model.Candle
+}
+
+func (f testFeed) AssetsInfo(string) model.AssetInfo { return model.AssetInfo{} }
+
+func (f testFeed) LastQuote(context.Context, string) (float64, error) { return 0, nil }
+
+func (f testFeed) CandlesByPeriod(context.Context, string, string, time.Time, time.Time) ([]model.Candle, error) {
+	return nil, nil
+}
+
+func (f testFeed) CandlesByLimit(context.Context, string, string, int) ([]model.Candle, error) {
+	return nil, nil
+}
+
+func (f testFeed) CandlesSubscription(context.Context, string, string) (chan model.Candle, chan error) {
+	return f.candles, make(chan error)
+}
+
+// flakyWallet 前failures次市价单失败的PaperWallet。
+type flakyWallet struct {
+	*exchange.PaperWallet
+	failures int
+}
+
+func (f *flakyWallet) CreateOrderMarket(side model.SideType, pair string, size float64,
+	options ...model.OrderOption) (model.Order, error) {
+	if f.failures > 0 {
+		f.failures--
+		return model.Order{}, errors.New("timeout")
+	}
+	return f.PaperWallet.CreateOrderMarket(side, pair, size, options...)
+}
+
+func TestSpread(t *testing.T) {
+	require.InDelta(t, 0.01, Spread(100, 0, 101, 0), 1e-9)
+	require.InDelta(t, 101*0.999/(100*1.001)-1, Spread(100, 0.001, 101, 0.001), 1e-9)
+	require.Less(t, Spread(100, 0.001, 100.1, 0.001), 0.0)
+	require.Equal(t, 0.0, Spread(0, 0, 100, 0))
+}
+
+func TestTracker_Best(t *testing.T) {
+	_, err := NewTracker([]Venue{{Name: "a"}})
+	require.Error(t, err)
+
+	_, err = NewTracker([]Venue{{Name: "a"}, {Name: "a"}})
+	require.Error(t, err)
+
+	tracker, err := NewTracker([]Venue{{Name: "a", Fee: 0.001}, {Name: "b", Fee: 0.001}, {Name: "c"}},
+		WithMaxSkew(time.Second), WithMaxAge(time.Minute))
+	require.NoError(t, err)
+
+	now := time.Now()
+	_, ok := tracker.best(now)
+	require.False(t, ok)
+
+	t.Run("best spread", func(t *testing.T) {
+		tracker.Update("a", 100, now)
+		tracker.Update("b", 102, now)
+		tracker.Update("c", 101, now)
+
+		best, ok := tracker.best(now)
+		require.True(t, ok)
+		require.Equal(t, "a", best.Buy.Venue)
+		require.Equal(t, "b", best.Sell.Venue)
+		require.InDelta(t, Spread(100, 0.001, 102, 0.001), best.NetSpread, 1e-9)
+	})
+
+	t.Run("older quote ignored", func(t *testing.T) {
+		tracker.Update("b", 200, now.Add(-time.Second))
+		quote, ok := tracker.Quote("b")
+		require.True(t, ok)
+		require.Equal(t, 102.0, quote.Price)
+	})
+
+	t.Run("skew", func(t *testing.T) {
+		// b的报价比a和c新，时间差超过maxSkew，只剩下a和c
+		tracker.Update("b", 102, now.Add(2*time.Second))
+		best, ok := tracker.best(now)
+		require.True(t, ok)
+		require.Equal(t, "a", best.Buy.Venue)
+		require.Equal(t, "c", best.Sell.Venue)
+	})
+
+	t.Run("max age", func(t *testing.T) {
+		_, ok := tracker.best(now.Add(2 * time.Minute))
+		require.False(t, ok)
+	})
+}
+
+func TestArbitrage_Paper(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	feedA := testFeed{candles: make(chan model.Candle)}
+	feedB := testFeed{candles: make(chan model.Candle)}
+	venueA, walletA := NewPaperVenue(ctx, "a", "BTCUSDT", feedA, 0.001, exchange.WithPaperAsset("USDT", 1000))
+	venueB, walletB := NewPaperVenue(ctx, "b", "BTCUSDT", feedB, 0.001,
+		exchange.WithPaperAsset("USDT", 1000), exchange.WithPaperAsset("BTC", 1))
+
+	tracker, err := NewTracker([]Venue{venueA, venueB})
+	require.NoError(t, err)
+	tracker.Start(ctx, "1m")
+
+	arbitrage := NewArbitrage(tracker, WithMinSpread(0.005))
+	_, err = arbitrage.Check(1)
+	require.ErrorIs(t, err, ErrNoOpportunity)
+
+	now := time.Now()
+	feedA.candles <- model.Candle{Pair: "BTCUSDT", Time: now, Close: 100}
+	feedB.candles <- model.Candle{Pair: "BTCUSDT", Time: now, Close: 102}
+	require.Eventually(t, func() bool {
+		quote, ok := tracker.Quote("b")
+		return ok && quote.Price == 102
+	}, time.Second, time.Millisecond)
+
+	result, err := arbitrage.Check(1)
+	require.NoError(t, err)
+	require.Equal(t, 100.0, result.Buy.Price)
+	require.Equal(t, 102.0, result.Sell.Price)
+	require.Nil(t, result.Unwind)
+
+	asset, _, err := walletA.Position("BTCUSDT")
+	require.NoError(t, err)
+	require.Equal(t, 1.0, asset)
+	asset, _, err = walletB.Position("BTCUSDT")
+	require.NoError(t, err)
+	require.Equal(t, 0.0, asset)
+}
+
+func TestArbitrage_LegRisk(t *testing.T) {
+	ctx := context.Background()
+	newVenues := func(failures int) (*flakyWallet, *exchange.PaperWallet, *Tracker) {
+		walletA := &flakyWallet{
+			PaperWallet: exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 1000)),
+			failures:    failures,
+		}
+		walletA.OnCandle(model.Candle{Pair: "BTCUSDT", Close: 100})
+		walletB := exchange.NewPaperWallet(ctx, "USDT", exchange.WithPaperAsset("USDT", 1000),
+			exchange.WithPaperAsset("BTC", 1))
+		walletB.OnCandle(model.Candle{Pair: "BTCUSDT", Close: 102})
+
+		tracker, err := NewTracker([]Venue{
+			{Name: "a", Exchange: walletA, Pair: "BTCUSDT"},
+			{Name: "b", Exchange: walletB, Pair: "BTCUSDT"},
+		})
+		require.NoError(t, err)
+		tracker.Update("a", 100, time.Now())
+		tracker.Update("b", 102, time.Now())
+		return walletA, walletB, tracker
+	}
+
+	t.Run("unwind", func(t *testing.T) {
+		_, walletB, tracker := newVenues(1)
+		result, err := NewArbitrage(tracker).Check(1)
+		require.ErrorIs(t, err, ErrLegFailed)
+		require.Nil(t, result.Buy)
+		require.NotNil(t, result.Sell)
+		require.NotNil(t, result.Unwind)
+		require.Equal(t, model.SideTypeBuy, result.Unwind.Side)
+
+		// 卖出的腿已经买回，b的持仓不变
+		asset, _, err := walletB.Position("BTCUSDT")
+		require.NoError(t, err)
+		require.Equal(t, 1.0, asset)
+	})
+
+	t.Run("hedge", func(t *testing.T) {
+		walletA, _, tracker := newVenues(2)
+		result, err := NewArbitrage(tracker, WithLegPolicy(LegHedge, 2)).Check(1)
+		require.NoError(t, err)
+		require.NotNil(t, result.Buy)
+		require.NotNil(t, result.Sell)
+		require.Nil(t, result.Unwind)
+
+		asset, _, err := walletA.Position("BTCUSDT")
+		require.NoError(t, err)
+		require.Equal(t, 1.0, asset)
+	})
+
+	t.Run("hedge retries exhausted", func(t *testing.T) {
+		_, _, tracker := newVenues(3)
+		result, err := NewArbitrage(tracker, WithLegPolicy(LegHedge, 2)).Check(1)
+		require.ErrorIs(t, err, ErrLegFailed)
+		require.NotNil(t, result.Unwind)
+	})
+}
@@ -0,0 +1,25 @@
+package arbitrage
+
+import (
+	"context"
+
+	"github.com/rodrigo-brito/ninjabot/exchange"
+	"github.com/rodrigo-brito/ninjabot/service"
+)
+
+// NewPaperVenue 创建一个使用PaperWallet模拟交易的交易所，用于在不下真实订单的情况下验证套利策略。
+// 每个交易所使用自己的PaperWallet和数据源feed，Tracker.Start订阅K线时会把K线交给这个PaperWallet，
+// 所以市价单按这个交易所自己的价格成交，两边的手续费也分别计算。options可以设置初始资产等配置，
+// 没有设置计价资产时计价资产为0。
+func NewPaperVenue(ctx context.Context, name, pair string, feed service.Feeder, fee float64,
+	options ...exchange.PaperWalletOption) (Venue, *exchange.PaperWallet) {
+	_, quote := exchange.SplitAssetQuote(pair)
+	options = append([]exchange.PaperWalletOption{
+		exchange.WithDataFeed(feed),
+		exchange.WithPaperFee(fee, fee),
+		exchange.WithPaperAsset(quote, 0),
+	}, options...)
+
+	wallet := exchange.NewPaperWallet(ctx, quote, options...)
+	return Venue{Name: name, Exchange: wallet, Pair: pair, Fee: fee}, wallet
+}
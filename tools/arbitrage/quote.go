@@ -0,0 +1,240 @@
+package arbitrage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rodrigo-brito/ninjabot/model"
+	"github.com/rodrigo-brito/ninjabot/service"
+)
+
+/*
+arbitrage 包提供跨交易所的空间套利工具：同一个交易对在不同交易所的价格不同时，在便宜的交易所买入，同时在贵的交易所卖出。
+Tracker 跟踪每个交易所的最新报价，Spread 计算扣除两边手续费之后的价差，Arbitrage 同时下两条腿的订单并处理单腿风险。
+交易所可以是任意的service.Exchange，也可以是同一个exchange.Router中不同交易所的交易对（例如 "okx:BTCUSDT"）。
+*/
+
+// Venue 套利的一个交易所。
+type Venue struct {
+	Name     string           // Name 交易所名称，在Tracker中唯一
+	Exchange service.Exchange // Exchange 行情和下单使用的交易所
+	Pair     string           // Pair 交易对在这个交易所的名称
+	Fee      float64          // Fee 吃单（taker）手续费率，例如0.001表示0.1%
+}
+
+// Quote 一个交易所的最新报价。
+type Quote struct {
+	Venue string    // Venue 交易所名称
+	Price float64   // Price 最新价格
+	Time  time.Time // Time 报价的时间
+}
+
+// Opportunity 一次套利机会：在Buy交易所买入，在Sell交易所卖出。
+type Opportunity struct {
+	Buy       Quote   // Buy 买入的交易所和价格
+	Sell      Quote   // Sell 卖出的交易所和价格
+	NetSpread float64 // NetSpread 扣除两边手续费之后的价差，0.01表示1%
+}
+
+// Spread 计算在buyPrice买入、在sellPrice卖出，扣除两边手续费之后的收益率。
+// 例如买入价100，卖出价101，两边手续费都是0.1%：101*(1-0.001) / (100*(1+0.001)) - 1 ≈ 0.8%。
+func Spread(buyPrice, buyFee, sellPrice, sellFee float64) float64 {
+	if buyPrice <= 0 {
+		return 0
+	}
+	return sellPrice*(1-sellFee)/(buyPrice*(1+buyFee)) - 1
+}
+
+// Tracker 跟踪每个交易所的最新报价。两个交易所的报价时间相差超过maxSkew时不认为是同步的报价，不计算套利机会，
+// 避免用一个交易所的旧价格和另一个交易所的新价格计算出虚假的价差。
+type Tracker struct {
+	mtx     sync.RWMutex
+	venues  []Venue          // venues 所有交易所，按添加顺序排列
+	quotes  map[string]Quote // quotes 每个交易所的最新报价
+	maxSkew time.Duration    // maxSkew 两个报价的最大时间差
+	maxAge  time.Duration    // maxAge 报价的最长有效时间，0表示不过期
+}
+
+// TrackerOption 定义了一个函数类型，用于通过不同的配置选项来定制化Tracker实例。
+type TrackerOption func(*Tracker)
+
+// WithMaxSkew 设置两个报价的最大时间差，默认为5秒。
+func WithMaxSkew(skew time.Duration) TrackerOption {
+	return func(t *Tracker) {
+		t.maxSkew = skew
+	}
+}
+
+// WithMaxAge 设置报价的最长有效时间，超过之后不再用于计算套利机会，默认不过期。
+func WithMaxAge(age time.Duration) TrackerOption {
+	return func(t *Tracker) {
+		t.maxAge = age
+	}
+}
+
+// NewTracker 创建一个跟踪venues报价的Tracker，至少需要两个交易所。
+func NewTracker(venues []Venue, options ...TrackerOption) (*Tracker, error) {
+	if len(venues) < 2 {
+		return nil, fmt.Errorf("arbitrage: at least two venues are required")
+	}
+
+	tracker := &Tracker{
+		quotes:  make(map[string]Quote),
+		maxSkew: 5 * time.Second,
+	}
+	for _, venue := range venues {
+		if _, ok := tracker.Venue(venue.Name); ok || venue.Name == "" {
+			return nil, fmt.Errorf("arbitrage: invalid or duplicated venue name %q", venue.Name)
+		}
+		tracker.venues = append(tracker.venues, venue)
+	}
+
+	for _, option := range options {
+		option(tracker)
+	}
+	return tracker, nil
+}
+
+// Venue 返回名称为name的交易所。
+func (t *Tracker) Venue(name string) (Venue, bool) {
+	for _, venue := range t.venues {
+		if venue.Name == name {
+			return venue, true
+		}
+	}
+	return Venue{}, false
+}
+
+// Update 更新一个交易所的报价，比已有报价更旧的报价会被忽略。
+func (t *Tracker) Update(venue string, price float64, at time.Time) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if quote, ok := t.quotes[venue]; ok && at.Before(quote.Time) {
+		return
+	}
+	t.quotes[venue] = Quote{Venue: venue, Price: price, Time: at}
+}
+
+// Quote 返回一个交易所的最新报价。
+func (t *Tracker) Quote(venue string) (Quote, bool) {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	quote, ok := t.quotes[venue]
+	return quote, ok
+}
+
+// Refresh 通过LastQuote同时查询所有交易所的最新价格，报价时间为查询完成的时间。
+func (t *Tracker) Refresh(ctx context.Context) error {
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(t.venues))
+	)
+	for i, venue := range t.venues {
+		wg.Add(1)
+		go func(i int, venue Venue) {
+			defer wg.Done()
+			price, err := venue.Exchange.LastQuote(ctx, venue.Pair)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", venue.Name, err)
+				return
+			}
+			t.Update(venue.Name, price, time.Now())
+		}(i, venue)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start 订阅所有交易所的K线，用收盘价和更新时间作为报价，ctx取消后停止。
+// 交易所实现了OnCandle时（例如PaperWallet）同时把K线交给交易所，这样模拟交易按这个交易所自己的价格成交。
+func (t *Tracker) Start(ctx context.Context, timeframe string) {
+	for _, venue := range t.venues {
+		candles, errs := venue.Exchange.CandlesSubscription(ctx, venue.Pair, timeframe)
+		go t.subscribe(ctx, venue, candles, errs)
+	}
+}
+
+// subscribe 处理一个交易所的K线订阅，直到通道关闭或者ctx取消。
+func (t *Tracker) subscribe(ctx context.Context, venue Venue, candles chan model.Candle, errs chan error) {
+	handler, _ := venue.Exchange.(interface{ OnCandle(model.Candle) })
+	for candles != nil || errs != nil {
+		select {
+		case <-ctx.Done():
+			return
+		case candle, ok := <-candles:
+			if !ok {
+				candles = nil
+				continue
+			}
+			if handler != nil {
+				handler.OnCandle(candle)
+			}
+
+			at := candle.UpdatedAt
+			if at.IsZero() {
+				at = candle.Time
+			}
+			t.Update(venue.Name, candle.Close, at)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			log.Errorf("arbitrage: %s: %v", venue.Name, err)
+		}
+	}
+}
+
+// Best 返回当前扣除手续费之后价差最大的套利机会，没有同步的报价时ok为false。
+// 返回的机会价差可能为负数，是否值得交易由调用方根据NetSpread决定。
+func (t *Tracker) Best() (best Opportunity, ok bool) {
+	return t.best(time.Now())
+}
+
+// best 按now判断报价是否过期，返回价差最大的套利机会。
+func (t *Tracker) best(now time.Time) (best Opportunity, ok bool) {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	for _, buy := range t.venues {
+		buyQuote, found := t.quotes[buy.Name]
+		if !found || !t.fresh(buyQuote, now) {
+			continue
+		}
+
+		for _, sell := range t.venues {
+			sellQuote, found := t.quotes[sell.Name]
+			if sell.Name == buy.Name || !found || !t.fresh(sellQuote, now) {
+				continue
+			}
+			if math.Abs(float64(buyQuote.Time.Sub(sellQuote.Time))) > float64(t.maxSkew) {
+				continue
+			}
+
+			spread := Spread(buyQuote.Price, buy.Fee, sellQuote.Price, sell.Fee)
+			if !ok || spread > best.NetSpread {
+				best = Opportunity{Buy: buyQuote, Sell: sellQuote, NetSpread: spread}
+				ok = true
+			}
+		}
+	}
+	return best, ok
+}
+
+// fresh 判断报价是否还在有效期内。
+func (t *Tracker) fresh(quote Quote, now time.Time) bool {
+	return quote.Price > 0 && (t.maxAge == 0 || now.Sub(quote.Time) <= t.maxAge)
+}
@@ -17,6 +17,8 @@ type (
 	SideType         = model.SideType         // SideType类型别名，表示订单的买卖方向（买入或卖出）
 	OrderType        = model.OrderType        // OrderType类型别名，表示订单的类型
 	OrderStatusType  = model.OrderStatusType  // OrderStatusType类型别名，表示订单的状态
+	ConditionType    = model.ConditionType    // ConditionType类型别名，表示条件单的触发方向
+	ConditionalOrder = model.ConditionalOrder // ConditionalOrder类型别名，表示本地模拟的条件单
 )
 
 var (
@@ -36,4 +38,6 @@ var (
 	OrderStatusTypePendingCancel   = model.OrderStatusTypePendingCancel   // 待取消的订单状态常量
 	OrderStatusTypeRejected        = model.OrderStatusTypeRejected        // 被拒绝的订单状态常量
 	OrderStatusTypeExpired         = model.OrderStatusTypeExpired         // 过期的订单状态常量
+	ConditionTypeAbove             = model.ConditionTypeAbove             // 价格向上触发的条件单常量
+	ConditionTypeBelow             = model.ConditionTypeBelow             // 价格向下触发的条件单常量
 )